@@ -0,0 +1,179 @@
+// Package alias expands user-defined aliases and macros of CLI commands. They're defined one per
+// line, i.e., in a ~/.fsrc file:
+//
+//	# Comments start with '#'.
+//	alias ll = ls
+//	macro fresh = rm -rf $1; mkdir $1
+//
+// Arguments after an alias are appended to its command. Macros run multiple commands separated by
+// ';' and refer to their arguments as $1, $2...
+package alias
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// maxDepth bounds how many times aliases/macros can expand into others, which catches cycles.
+const maxDepth = 10
+
+// Set is a thread-safe set of aliases and macros.
+type Set struct {
+	mu      sync.RWMutex
+	aliases map[string]string
+	macros  map[string][]string
+}
+
+// New returns an empty set.
+func New() *Set {
+	return &Set{
+		aliases: make(map[string]string),
+		macros:  make(map[string][]string),
+	}
+}
+
+// Load returns the set defined in the file at path. A missing file is an empty set.
+func Load(path string) (*Set, error) {
+	s := New()
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if err := s.Define(line); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, n, err)
+		}
+	}
+	return s, scanner.Err()
+}
+
+// Define adds the alias (alias name = command) or macro (macro name = cmd1; cmd2) in line.
+// Redefining a name replaces it.
+func (s *Set) Define(line string) error {
+	fields := strings.Fields(line)
+	if len(fields) == 0 || (fields[0] != "alias" && fields[0] != "macro") {
+		return fmt.Errorf("expected alias or macro definition")
+	}
+	def := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(line), fields[0]))
+	parts := strings.SplitN(def, "=", 2)
+	if len(parts) != 2 {
+		return fmt.Errorf("expected %s name = command", fields[0])
+	}
+	name, body := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+	if name == "" || strings.ContainsAny(name, " \t") {
+		return fmt.Errorf("invalid name %q", name)
+	}
+	if body == "" {
+		return fmt.Errorf("empty %s %s", fields[0], name)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.aliases, name)
+	delete(s.macros, name)
+	if fields[0] == "alias" {
+		s.aliases[name] = body
+		return nil
+	}
+	cmds := make([]string, 0)
+	for _, cmd := range strings.Split(body, ";") {
+		if cmd = strings.TrimSpace(cmd); cmd != "" {
+			cmds = append(cmds, cmd)
+		}
+	}
+	s.macros[name] = cmds
+	return nil
+}
+
+// Definitions returns all the definitions sorted by name, in the format they're defined in.
+func (s *Set) Definitions() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	defs := make([]string, 0, len(s.aliases)+len(s.macros))
+	for name, cmd := range s.aliases {
+		defs = append(defs, "alias "+name+" = "+cmd)
+	}
+	for name, cmds := range s.macros {
+		defs = append(defs, "macro "+name+" = "+strings.Join(cmds, "; "))
+	}
+	sort.Slice(defs, func(i, j int) bool {
+		return strings.Fields(defs[i])[1] < strings.Fields(defs[j])[1]
+	})
+	return defs
+}
+
+// Expand returns the commands to run for line. Lines that aren't aliases or macros are returned
+// as is.
+func (s *Set) Expand(line string) ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.expand(strings.TrimSpace(line), 0)
+}
+
+func (s *Set) expand(line string, depth int) ([]string, error) {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return []string{line}, nil
+	}
+	name, args := fields[0], fields[1:]
+	var expanded []string
+	if cmd, ok := s.aliases[name]; ok {
+		expanded = []string{strings.Join(append([]string{cmd}, args...), " ")}
+	} else if cmds, ok := s.macros[name]; ok {
+		expanded = make([]string, 0, len(cmds))
+		for _, cmd := range cmds {
+			cmd, err := substitute(cmd, args)
+			if err != nil {
+				return nil, fmt.Errorf("macro %s: %w", name, err)
+			}
+			expanded = append(expanded, cmd)
+		}
+	} else {
+		return []string{line}, nil
+	}
+	if depth == maxDepth {
+		return nil, fmt.Errorf("%s expands too deeply. is it recursive?", name)
+	}
+
+	lines := make([]string, 0, len(expanded))
+	for _, cmd := range expanded {
+		more, err := s.expand(cmd, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		lines = append(lines, more...)
+	}
+	return lines, nil
+}
+
+// argRegex matches macro arguments (i.e., $1).
+var argRegex = regexp.MustCompile(`\$[1-9][0-9]*`)
+
+// substitute replaces $1, $2... in cmd with args.
+func substitute(cmd string, args []string) (string, error) {
+	var err error
+	cmd = argRegex.ReplaceAllStringFunc(cmd, func(arg string) string {
+		n, _ := strconv.Atoi(arg[1:])
+		if n > len(args) {
+			err = fmt.Errorf("missing argument %s", arg)
+			return arg
+		}
+		return args[n-1]
+	})
+	return cmd, err
+}
@@ -0,0 +1,85 @@
+package alias
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestSet_Expand(t *testing.T) {
+	s := New()
+	defs := []string{
+		"alias ll = ls",
+		"alias l = ll",
+		"macro fresh = rm -rf $1; mkdir $1",
+		"alias loop = loop",
+		"macro touchin = touch $1/x",
+	}
+	for _, def := range defs {
+		if err := s.Define(def); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		line    string
+		want    []string
+		wantErr bool
+	}{
+		{"ls /foo", []string{"ls /foo"}, false},
+		{"ll /foo", []string{"ls /foo"}, false},
+		{"l", []string{"ls"}, false},
+		{"fresh /tmp", []string{"rm -rf /tmp", "mkdir /tmp"}, false},
+		{"touchin /tmp", []string{"touch /tmp/x"}, false},
+		{"fresh", nil, true},
+		{"loop", nil, true},
+	}
+	for _, test := range tests {
+		got, err := s.Expand(test.line)
+		if (err != nil) != test.wantErr {
+			t.Errorf("Expand(%q): unexpected error %v", test.line, err)
+			continue
+		}
+		if !test.wantErr && !reflect.DeepEqual(got, test.want) {
+			t.Errorf("Expand(%q): expected %v, got %v", test.line, test.want, got)
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "alias")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Missing files are empty.
+	s, err := Load(filepath.Join(dir, ".fsrc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Definitions()) != 0 {
+		t.Errorf("Expected no definitions, got %v", s.Definitions())
+	}
+
+	rc := "# aliases\n\nmacro fresh = rm -rf $1;mkdir $1\nalias ll = ls\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, ".fsrc"), []byte(rc), 0644); err != nil {
+		t.Fatal(err)
+	}
+	s, err = Load(filepath.Join(dir, ".fsrc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"macro fresh = rm -rf $1; mkdir $1", "alias ll = ls"}
+	if got := s.Definitions(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, ".fsrc"), []byte("alias ll\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(filepath.Join(dir, ".fsrc")); err == nil {
+		t.Errorf("Expected an error for an invalid definition")
+	}
+}
@@ -0,0 +1,105 @@
+'use strict';
+
+// Helpers for the streaming RPCs of file_server, which the generated stubs only expose as raw
+// streams. They work with any @grpc/grpc-js client of the FileSever service, i.e., the one in
+// gen/filesystem.ts or one loaded with @grpc/proto-loader.
+
+// chunkSize matches the chunks the Go client and server send (32KiB), so that messages stay well
+// under gRPC's default 4MiB limit.
+const chunkSize = 32 << 10;
+
+/**
+ * Reads the file at path, yielding its content as Buffers as they arrive.
+ *
+ * @param {object} client a FileSever client.
+ * @param {string} path absolute path of the file.
+ * @param {object} [options] call options (i.e., a deadline).
+ * @returns {AsyncIterable<Buffer>}
+ */
+async function* readFile(client, path, options = {}) {
+  const stream = client.readFile({ path }, options);
+  try {
+    for await (const payload of stream) {
+      yield Buffer.from(payload.data);
+    }
+  } finally {
+    // Stops the server from sending the rest when the caller stops reading early.
+    stream.cancel();
+  }
+}
+
+/**
+ * Reads the whole file at path.
+ *
+ * @param {object} client a FileSever client.
+ * @param {string} path absolute path of the file.
+ * @param {object} [options] call options.
+ * @returns {Promise<Buffer>}
+ */
+async function readAll(client, path, options = {}) {
+  const chunks = [];
+  for await (const chunk of readFile(client, path, options)) {
+    chunks.push(chunk);
+  }
+  return Buffer.concat(chunks);
+}
+
+/**
+ * Appends content to the file at path, which must exist. The first message of the stream is the
+ * path and the others are the data, like the Go client sends them. Nothing is written unless the
+ * whole upload makes it to the server.
+ *
+ * @param {object} client a FileSever client.
+ * @param {string} path absolute path of the file.
+ * @param {Buffer|string|Iterable<Buffer>|AsyncIterable<Buffer>} content what to append, i.e., a
+ *     Buffer or a Node Readable stream.
+ * @param {object} [options] call options.
+ * @returns {Promise<number>} the number of bytes sent.
+ */
+function writeFile(client, path, content, options = {}) {
+  return new Promise((resolve, reject) => {
+    let sent = 0;
+    let done = false;
+    const stream = client.writeFile(options, (err) => {
+      done = true;
+      return err ? reject(err) : resolve(sent);
+    });
+    const send = async () => {
+      stream.write({ path });
+      for await (const chunk of chunks(content)) {
+        for (let i = 0; i < chunk.length; i += chunkSize) {
+          const data = chunk.subarray(i, i + chunkSize);
+          // Waits for the stream to drain, so that large uploads aren't buffered in memory. The
+          // server may fail the upload in the meantime (i.e., once the file is too large).
+          if (!stream.write({ data })) {
+            await new Promise((drained) => {
+              stream.once('drain', drained);
+              stream.once('status', drained);
+            });
+          }
+          if (done) {
+            return;
+          }
+          sent += data.length;
+        }
+      }
+      stream.end();
+    };
+    send().catch((err) => {
+      stream.cancel();
+      reject(err);
+    });
+  });
+}
+
+async function* chunks(content) {
+  if (typeof content === 'string' || Buffer.isBuffer(content) || content instanceof Uint8Array) {
+    yield Buffer.from(content);
+    return;
+  }
+  for await (const chunk of content) {
+    yield Buffer.from(chunk);
+  }
+}
+
+module.exports = { readFile, readAll, writeFile, chunkSize };
@@ -0,0 +1,23 @@
+// Package blob provides simple key/value stores for file content that lives outside of the
+// in-memory filesystem (i.e., a local disk, a bbolt file or S3).
+package blob
+
+import (
+	"fmt"
+	"io"
+)
+
+var ErrNotFound = fmt.Errorf("blob not found")
+
+// Store is a thread-safe key/value store for blobs. Keys are '/' separated strings.
+type Store interface {
+	// Put stores what's in reader until EOF under key, replacing any existing blob. Returns the
+	// number of bytes stored.
+	Put(key string, reader io.Reader) (int64, error)
+
+	// Get streams the blob under key to writer. Returns ErrNotFound if it doesn't exist.
+	Get(key string, writer io.Writer) (int64, error)
+
+	// Delete removes the blob under key. Deleting a missing blob isn't an error.
+	Delete(key string) error
+}
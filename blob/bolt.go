@@ -0,0 +1,81 @@
+package blob
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// boltBucket is the bucket of a Bolt file blobs are kept in.
+var boltBucket = []byte("blobs")
+
+// Bolt stores blobs in a single bbolt file, an embedded KV store, so that small blobs (i.e., the
+// records of fs.Opts.MetaStore) don't take a file each. It must be closed.
+type Bolt struct {
+	db *bolt.DB
+}
+
+// OpenBolt opens the bbolt file at path, which is created if it doesn't exist. Only one process
+// can have it open, so OpenBolt fails after a second if another one does.
+func OpenBolt(path string) (*Bolt, error) {
+	db, err := bolt.Open(path, 0644, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s. %w", path, err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(boltBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to open %s. %w", path, err)
+	}
+	return &Bolt{db: db}, nil
+}
+
+// Put reads the whole blob before storing it. Concurrent puts are committed together (see
+// bolt.DB.Batch), so that storing many blobs at once doesn't take a transaction each.
+func (b *Bolt) Put(key string, reader io.Reader) (int64, error) {
+	if key == "" {
+		return 0, fmt.Errorf("invalid key %q", key)
+	}
+	value, err := io.ReadAll(reader)
+	if err != nil {
+		return 0, err
+	}
+	err = b.db.Batch(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltBucket).Put([]byte(key), value)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return int64(len(value)), nil
+}
+
+// Get writes the blob within a read transaction, which doesn't block writers.
+func (b *Bolt) Get(key string, writer io.Writer) (int64, error) {
+	var n int
+	err := b.db.View(func(tx *bolt.Tx) error {
+		value := tx.Bucket(boltBucket).Get([]byte(key))
+		if value == nil {
+			return ErrNotFound
+		}
+		var err error
+		n, err = writer.Write(value)
+		return err
+	})
+	return int64(n), err
+}
+
+func (b *Bolt) Delete(key string) error {
+	return b.db.Batch(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltBucket).Delete([]byte(key))
+	})
+}
+
+// Close closes the file.
+func (b *Bolt) Close() error {
+	return b.db.Close()
+}
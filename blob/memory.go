@@ -0,0 +1,46 @@
+package blob
+
+import (
+	"bytes"
+	"io"
+	"sync"
+)
+
+// Memory stores blobs in memory. Mostly useful for tests.
+type Memory struct {
+	mu    sync.RWMutex
+	blobs map[string][]byte
+}
+
+func NewMemory() *Memory {
+	return &Memory{blobs: make(map[string][]byte)}
+}
+
+func (m *Memory) Put(key string, reader io.Reader) (int64, error) {
+	buf := bytes.NewBuffer(nil)
+	n, err := io.Copy(buf, reader)
+	if err != nil {
+		return n, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.blobs[key] = buf.Bytes()
+	return n, nil
+}
+
+func (m *Memory) Get(key string, writer io.Writer) (int64, error) {
+	m.mu.RLock()
+	b, ok := m.blobs[key]
+	m.mu.RUnlock()
+	if !ok {
+		return 0, ErrNotFound
+	}
+	return io.Copy(writer, bytes.NewReader(b))
+}
+
+func (m *Memory) Delete(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.blobs, key)
+	return nil
+}
@@ -0,0 +1,151 @@
+//go:build linux || darwin || freebsd
+// +build linux darwin freebsd
+
+package blob
+
+import (
+	"io"
+	"os"
+	"sync"
+	"syscall"
+)
+
+// Mmap stores blobs as files under a local directory like Disk, and serves them from memory maps
+// of the files. The OS page cache decides which parts are resident, so large read-mostly blobs
+// aren't held in both the heap and the page cache. Blobs are immutable: Put replaces them.
+type Mmap struct {
+	disk *Disk
+
+	// mu protects maps.
+	mu   sync.Mutex
+	maps map[string]*mapping
+}
+
+// mapping is a memory map of a blob. It's unmapped once it's replaced and no Get uses it.
+type mapping struct {
+	data     []byte
+	refs     int
+	replaced bool
+}
+
+// NewMmap returns a store rooted at dir. dir is created if it doesn't exist.
+func NewMmap(dir string) (*Mmap, error) {
+	disk, err := NewDisk(dir)
+	if err != nil {
+		return nil, err
+	}
+	return &Mmap{disk: disk, maps: make(map[string]*mapping)}, nil
+}
+
+func (m *Mmap) Put(key string, reader io.Reader) (int64, error) {
+	n, err := m.disk.Put(key, reader)
+	if err != nil {
+		return n, err
+	}
+	// Maps of the old file stay valid after the rename until they're unmapped.
+	m.release(key)
+	return n, nil
+}
+
+func (m *Mmap) Get(key string, writer io.Writer) (int64, error) {
+	mp, err := m.acquire(key)
+	if err != nil {
+		return 0, err
+	}
+	defer m.done(mp)
+	n, err := writer.Write(mp.data)
+	return int64(n), err
+}
+
+func (m *Mmap) Delete(key string) error {
+	if err := m.disk.Delete(key); err != nil {
+		return err
+	}
+	m.release(key)
+	return nil
+}
+
+// Close unmaps the blobs. The store can't be used afterwards.
+func (m *Mmap) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var err error
+	for key, mp := range m.maps {
+		delete(m.maps, key)
+		if e := unmap(mp); e != nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// acquire returns the map of the blob under key, mapping it if needed. done must be called once
+// it's no longer used.
+func (m *Mmap) acquire(key string) (*mapping, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if mp, ok := m.maps[key]; ok {
+		mp.refs++
+		return mp, nil
+	}
+	path, err := m.disk.path(key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	// The map outlives the file.
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	mp := &mapping{refs: 1}
+	// Empty files can't be mapped.
+	if fi.Size() > 0 {
+		mp.data, err = syscall.Mmap(int(f.Fd()), 0, int(fi.Size()), syscall.PROT_READ, syscall.MAP_SHARED)
+		if err != nil {
+			return nil, err
+		}
+	}
+	m.maps[key] = mp
+	return mp, nil
+}
+
+func (m *Mmap) done(mp *mapping) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	mp.refs--
+	if mp.replaced && mp.refs == 0 {
+		unmap(mp)
+	}
+}
+
+// release drops the map of the blob under key, which is unmapped once no Get uses it.
+func (m *Mmap) release(key string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	mp, ok := m.maps[key]
+	if !ok {
+		return
+	}
+	delete(m.maps, key)
+	mp.replaced = true
+	if mp.refs == 0 {
+		unmap(mp)
+	}
+}
+
+func unmap(mp *mapping) error {
+	if mp.data == nil {
+		return nil
+	}
+	data := mp.data
+	mp.data = nil
+	return syscall.Munmap(data)
+}
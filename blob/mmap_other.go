@@ -0,0 +1,23 @@
+//go:build !linux && !darwin && !freebsd
+// +build !linux,!darwin,!freebsd
+
+package blob
+
+// Mmap is Disk on platforms without memory maps.
+type Mmap struct {
+	*Disk
+}
+
+// NewMmap returns a store rooted at dir. dir is created if it doesn't exist.
+func NewMmap(dir string) (*Mmap, error) {
+	disk, err := NewDisk(dir)
+	if err != nil {
+		return nil, err
+	}
+	return &Mmap{disk}, nil
+}
+
+// Close does nothing.
+func (m *Mmap) Close() error {
+	return nil
+}
@@ -0,0 +1,163 @@
+package blob
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// S3Opts configures an S3 (or S3-compatible) store.
+type S3Opts struct {
+	// Endpoint is the base URL (i.e., https://s3.us-east-1.amazonaws.com). Buckets are addressed
+	// by path.
+	Endpoint string
+	Region   string
+	Bucket   string
+
+	// Prefix is prepended to all keys.
+	Prefix string
+
+	AccessKey string
+	SecretKey string
+
+	// Timeout for a single request. Defaults to 30s.
+	Timeout time.Duration
+}
+
+// S3 stores blobs as objects in an S3 bucket. Requests are signed with AWS Signature V4.
+type S3 struct {
+	opts   S3Opts
+	client *http.Client
+}
+
+// NewS3 returns a store backed by opts.Bucket.
+func NewS3(opts S3Opts) (*S3, error) {
+	if opts.Endpoint == "" || opts.Region == "" || opts.Bucket == "" {
+		return nil, fmt.Errorf("s3 endpoint, region and bucket are required")
+	}
+	if opts.AccessKey == "" || opts.SecretKey == "" {
+		return nil, fmt.Errorf("s3 credentials are required")
+	}
+	if opts.Timeout == 0 {
+		opts.Timeout = 30 * time.Second
+	}
+	opts.Endpoint = strings.TrimSuffix(opts.Endpoint, "/")
+	return &S3{opts: opts, client: &http.Client{Timeout: opts.Timeout}}, nil
+}
+
+// Put buffers the blob in memory since S3 requires the content length and hash upfront.
+func (s *S3) Put(key string, reader io.Reader) (int64, error) {
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return 0, err
+	}
+	res, err := s.do(http.MethodPut, key, data)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("s3 put %s: %s", key, res.Status)
+	}
+	return int64(len(data)), nil
+}
+
+func (s *S3) Get(key string, writer io.Writer) (int64, error) {
+	res, err := s.do(http.MethodGet, key, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotFound {
+		return 0, ErrNotFound
+	}
+	if res.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("s3 get %s: %s", key, res.Status)
+	}
+	return io.Copy(writer, res.Body)
+}
+
+func (s *S3) Delete(key string) error {
+	res, err := s.do(http.MethodDelete, key, nil)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusNoContent && res.StatusCode != http.StatusOK &&
+		res.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("s3 delete %s: %s", key, res.Status)
+	}
+	return nil
+}
+
+func (s *S3) do(method, key string, body []byte) (*http.Response, error) {
+	escaped := (&url.URL{Path: "/" + s.opts.Bucket + "/" + s.opts.Prefix + key}).EscapedPath()
+	req, err := http.NewRequest(method, s.opts.Endpoint+escaped, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	s.sign(req, escaped, body, time.Now().UTC())
+	return s.client.Do(req)
+}
+
+// sign adds AWS Signature V4 headers to req.
+func (s *S3) sign(req *http.Request, escapedPath string, body []byte, now time.Time) {
+	date := now.Format("20060102")
+	stamp := now.Format("20060102T150405Z")
+	payloadHash := sha256Hex(body)
+	req.Header.Set("X-Amz-Date", stamp)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := map[string]string{
+		"host":                 req.URL.Host,
+		"x-amz-content-sha256": payloadHash,
+		"x-amz-date":           stamp,
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method, escapedPath, "", canonicalHeaders.String(), signedHeaders, payloadHash,
+	}, "\n")
+	scope := date + "/" + s.opts.Region + "/s3/aws4_request"
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256", stamp, scope, sha256Hex([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+s.opts.SecretKey), date)
+	key = hmacSHA256(key, s.opts.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.opts.AccessKey, scope, signedHeaders, signature))
+}
+
+func sha256Hex(b []byte) string {
+	h := sha256.Sum256(b)
+	return hex.EncodeToString(h[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
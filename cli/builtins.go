@@ -0,0 +1,421 @@
+package cli
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/basharal/filesystem/alias"
+	"github.com/basharal/filesystem/client"
+	"github.com/basharal/filesystem/fs"
+	"github.com/basharal/filesystem/output"
+	"github.com/basharal/filesystem/session"
+)
+
+// stdio is the local path of read/write commands standing for stdout/stdin.
+const stdio = "-"
+
+// Env is what the shared commands run with.
+type Env struct {
+	FS      FS
+	Input   *bufio.Reader
+	Aliases *alias.Set
+	Session *session.Session
+	Out     *output.Printer
+
+	// Report turns errors of operations spanning servers into what's shown (i.e., which servers
+	// failed). Optional.
+	Report func(error) error
+}
+
+func (e *Env) report(err error) error {
+	if e.Report == nil {
+		return err
+	}
+	return e.Report(err)
+}
+
+func (e *Env) confirm(question string) (bool, error) {
+	return Confirm(e.Input, question)
+}
+
+// Builtins returns the commands shared by the CLIs.
+func Builtins(env *Env) []Command {
+	return []Command{
+		{Name: "alias", Usage: "defines an alias, or lists aliases and macros without arguments " +
+			"(i.e., alias ll = ls)", MaxArgs: -1, Handler: env.alias},
+		{Name: "add", Usage: "add creates an empty file, and the missing dirs above it with -p (i.e., add /foo, add -p /a/b/foo)",
+			MinArgs: 1, MaxArgs: 2, Handler: env.add},
+		{Name: "apply", Usage: "converges a dir to a JSON manifest of dirs and files, removing everything else under it " +
+			"after a confirmation. -n only shows what would change and -f/--force skips the confirmation " +
+			"(i.e., apply layout.json /app -n)", MinArgs: 2, MaxArgs: 3, Handler: env.apply},
+		{Name: "cp", Usage: "copies a file to a new file (i.e., cp /foo.txt /bar.txt)", MinArgs: 2, MaxArgs: 2, Handler: env.cp},
+		{Name: "scaffold", Usage: "creates the dirs and files of a JSON spec (the format of apply) under a dir, " +
+			"keeping the dirs that exist and failing on files that do (i.e., scaffold service.json /projects/new)",
+			MinArgs: 2, MaxArgs: 2, Handler: env.scaffold},
+		{Name: "history", Usage: "lists previous commands, including those of earlier sessions", Handler: env.history},
+		{Name: "macro", Usage: "defines a macro running multiple commands with $1, $2... as its arguments " +
+			"(i.e., macro fresh = rm -rf $1; mkdir $1)", MinArgs: 1, MaxArgs: -1, Handler: env.macro},
+		{Name: "mkdir", Usage: "creates a new directory (i.e., mkdir foo)", MinArgs: 1, MaxArgs: 1, Handler: env.mkDir},
+		{Name: "pipe", Usage: "streams a file into a local command and optionally its output into another file " +
+			"(i.e., pipe /foo.log | grep error > /errors.log)", MinArgs: 3, MaxArgs: -1, Handler: env.pipe},
+		{Name: "read", Usage: "reads from the filesystem into local filesystem, or to stdout with -. " +
+			"asks before replacing an existing local file unless -f is given (i.e., read /bar /tmp/bar, read /bar -)", MinArgs: 2, MaxArgs: 3, Handler: env.read},
+		{Name: "rm", Usage: "removes a file/directory(if empty). -r removes everything under it after a confirmation, " +
+			"-i always asks and -f/--force never does (i.e., rm foo, rm -r foo, rm -rf foo)", MinArgs: 1, MaxArgs: -1, Handler: env.rm},
+		{Name: "rmprefix", Usage: "removes a path and everything under it after a confirmation. -n only counts what " +
+			"would be removed and -f/--force skips the confirmation (i.e., rmprefix /foo -n)", MinArgs: 1, MaxArgs: 2, Handler: env.rmPrefix},
+		{Name: "touch", Usage: "creates an empty file or updates its modification time (i.e., touch /foo)", MinArgs: 1, MaxArgs: 1, Handler: env.touch},
+		{Name: "write", Usage: "reads from local filesystem, or from stdin until it ends with -, and writes into the filesystem. " +
+			"will append, asking first if the file isn't empty unless -f is given or it reads from stdin. " +
+			"-p creates the file and the missing dirs above it (i.e., write /tmp/bar /bar, write - /bar, " +
+			"write -p /tmp/bar /a/b/bar)", MinArgs: 2, MaxArgs: 4, Handler: env.write},
+	}
+}
+
+func (e *Env) alias(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		for _, def := range e.Aliases.Definitions() {
+			fmt.Println(def)
+		}
+		return nil
+	}
+	return e.Aliases.Define("alias " + strings.Join(args, " "))
+}
+
+func (e *Env) macro(ctx context.Context, args []string) error {
+	return e.Aliases.Define("macro " + strings.Join(args, " "))
+}
+
+func (e *Env) history(ctx context.Context, args []string) error {
+	for i, line := range e.Session.History() {
+		fmt.Printf("%5d  %s\n", i+1, line)
+	}
+	return nil
+}
+
+func (e *Env) mkDir(ctx context.Context, args []string) error {
+	return e.FS.MakeDir(ctx, args[0])
+}
+
+func (e *Env) add(ctx context.Context, args []string) error {
+	args, parents := ParseParents(args)
+	if len(args) != 1 {
+		return fmt.Errorf("wrong arguments")
+	}
+	return e.FS.CreateFile(ctx, args[0], parents)
+}
+
+func (e *Env) touch(ctx context.Context, args []string) error {
+	return e.FS.TouchFile(ctx, args[0])
+}
+
+func (e *Env) apply(ctx context.Context, args []string) error {
+	args, force := ParseForce(args)
+	args, dryRun := cutFlag(args, "-n")
+	if len(args) != 2 {
+		return fmt.Errorf("wrong arguments")
+	}
+	applier, ok := e.FS.(ManifestApplier)
+	if !ok {
+		return fmt.Errorf("applying manifests: %w", fs.ErrNotSupported)
+	}
+	m, err := ReadManifest(args[0])
+	if err != nil {
+		return err
+	}
+	dir, err := RemotePath(args[1])
+	if err != nil {
+		return err
+	}
+	if !dryRun && !force {
+		planned, err := applier.ApplyManifest(ctx, dir, m, true)
+		if err != nil {
+			return err
+		}
+		if len(planned.Removed) > 0 {
+			if ok, err := e.confirm(fmt.Sprintf("remove %d files/dirs under %s?", len(planned.Removed), dir)); err != nil || !ok {
+				return err
+			}
+		}
+	}
+	result, err := applier.ApplyManifest(ctx, dir, m, dryRun)
+	if err != nil {
+		return err
+	}
+	for _, change := range []struct {
+		mark  string
+		paths []string
+	}{{"-", result.Removed}, {"+", result.Created}, {"~", result.Updated}} {
+		for _, p := range change.paths {
+			fmt.Println(change.mark, p)
+		}
+	}
+	verb := ""
+	if dryRun {
+		verb = "would have "
+	}
+	fmt.Printf("%screated %d, updated %d and removed %d files/dirs\n", verb, len(result.Created), len(result.Updated), len(result.Removed))
+	return nil
+}
+
+func (e *Env) scaffold(ctx context.Context, args []string) error {
+	spec, err := ReadManifest(args[0])
+	if err != nil {
+		return err
+	}
+	dir, err := RemotePath(args[1])
+	if err != nil {
+		return err
+	}
+	result, err := Scaffold(ctx, e.FS, dir, spec)
+	fmt.Printf("created %d dirs and %d files under %s\n", result.Dirs, result.Files, dir)
+	return err
+}
+
+func (e *Env) cp(ctx context.Context, args []string) error {
+	return e.FS.Copy(ctx, args[0], args[1])
+}
+
+func (e *Env) rm(ctx context.Context, args []string) error {
+	path, flags, err := ParseRmFlags(args)
+	if err != nil {
+		return err
+	}
+	if !flags.Recursive {
+		if flags.Interactive && !flags.Force {
+			if ok, err := e.confirm(fmt.Sprintf("remove %s?", path)); err != nil || !ok {
+				return err
+			}
+		}
+		return e.FS.Remove(ctx, path)
+	}
+	return e.deletePrefix(ctx, path, flags.Force)
+}
+
+func (e *Env) rmPrefix(ctx context.Context, args []string) error {
+	if len(args) == 1 {
+		return e.deletePrefix(ctx, args[0], false)
+	}
+	switch args[1] {
+	case "-n":
+		n, err := e.FS.DeletePrefix(ctx, args[0], true)
+		if err != nil {
+			return e.report(err)
+		}
+		fmt.Printf("would remove %d files/dirs\n", n)
+		return nil
+	case "-f", "--force":
+		return e.deletePrefix(ctx, args[0], true)
+	}
+	return fmt.Errorf("wrong arguments")
+}
+
+// deletePrefix removes everything under path, after asking for a confirmation unless force is set.
+func (e *Env) deletePrefix(ctx context.Context, path string, force bool) error {
+	if !force {
+		n, err := e.FS.DeletePrefix(ctx, path, true)
+		if err != nil {
+			return e.report(err)
+		}
+		if ok, err := e.confirm(fmt.Sprintf("remove %d files/dirs under %s?", n, path)); err != nil || !ok {
+			return err
+		}
+	}
+	n, err := e.FS.DeletePrefix(ctx, path, false)
+	if err == nil || n > 0 {
+		fmt.Printf("removed %d files/dirs\n", n)
+	}
+	return e.report(err)
+}
+
+func (e *Env) read(ctx context.Context, args []string) error {
+	args, force := ParseForce(args)
+	if len(args) != 2 {
+		return fmt.Errorf("wrong arguments")
+	}
+	if args[1] == stdio {
+		remote, err := RemotePath(args[0])
+		if err != nil {
+			return err
+		}
+		_, err = e.readRemote(ctx, remote, os.Stdout)
+		return err
+	}
+	local, remote, err := LocalAndRemote(args[1], args[0])
+	if err != nil {
+		return err
+	}
+	// Mounted files can't be stat'ed, and only the local file is checked for them.
+	size, isDir, err := e.FS.Stat(ctx, remote)
+	if err != nil && !errors.Is(err, fs.ErrNotSupported) {
+		return err
+	}
+	if isDir {
+		return fmt.Errorf("%s is a directory", remote)
+	}
+	if fi, statErr := os.Stat(local); statErr == nil && !force {
+		question := fmt.Sprintf("overwrite %s (%s)?", local, e.Out.Size(fi.Size()))
+		if err == nil {
+			question = fmt.Sprintf("overwrite %s (%s) with %s (%s)?", local, e.Out.Size(fi.Size()), remote, e.Out.Size(size))
+		}
+		if ok, err := e.confirm(question); err != nil || !ok {
+			return err
+		}
+	}
+	n, err := e.readFile(ctx, local, remote)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("read %s from %s\n", e.Out.Size(n), remote)
+	return nil
+}
+
+// readFile reads remote into a temp file next to local and only replaces local once the whole file
+// is read, so that a failed read doesn't destroy it.
+func (e *Env) readFile(ctx context.Context, local, remote string) (int64, error) {
+	tmp, err := os.CreateTemp(filepath.Dir(local), "."+filepath.Base(local)+".*.tmp")
+	if err != nil {
+		return 0, fmt.Errorf("local path %s: %w", local, err)
+	}
+	defer os.Remove(tmp.Name())
+	mode := os.FileMode(0644)
+	if fi, err := os.Stat(local); err == nil {
+		mode = fi.Mode().Perm()
+	}
+	if err := tmp.Chmod(mode); err != nil {
+		tmp.Close()
+		return 0, fmt.Errorf("local path %s: %w", local, err)
+	}
+	n, err := e.readRemote(ctx, remote, tmp)
+	if err != nil {
+		tmp.Close()
+		return n, err
+	}
+	if err := tmp.Close(); err != nil {
+		return n, fmt.Errorf("local path %s: %w", local, err)
+	}
+	if err := os.Rename(tmp.Name(), local); err != nil {
+		return n, fmt.Errorf("local path %s: %w", local, err)
+	}
+	return n, nil
+}
+
+func (e *Env) write(ctx context.Context, args []string) error {
+	args, force := ParseForce(args)
+	args, parents := ParseParents(args)
+	if len(args) != 2 {
+		return fmt.Errorf("wrong arguments")
+	}
+	if args[0] == stdio {
+		remote, err := RemotePath(args[1])
+		if err != nil {
+			return err
+		}
+		if err := e.createMissing(ctx, remote, parents); err != nil {
+			return err
+		}
+		// There's no asking before appending, since the answer would be read from stdin too.
+		_, err = e.writeRemote(ctx, remote, e.Input)
+		return err
+	}
+	local, remote, err := LocalAndRemote(args[0], args[1])
+	if err != nil {
+		return err
+	}
+	// Missing files are left for Write to report, or for -p to create.
+	if size, isDir, err := e.FS.Stat(ctx, remote); err == nil && !isDir && size > 0 && !force {
+		if ok, err := e.confirm(fmt.Sprintf("append to %s (%s)?", remote, e.Out.Size(size))); err != nil || !ok {
+			return err
+		}
+	}
+
+	f, err := os.Open(local)
+	if err != nil {
+		return fmt.Errorf("local path %s: %w", local, err)
+	}
+	defer f.Close()
+	if err := e.createMissing(ctx, remote, parents); err != nil {
+		return err
+	}
+	n, err := e.writeRemote(ctx, remote, f)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("wrote %s to %s\n", e.Out.Size(n), remote)
+	return nil
+}
+
+// createMissing creates remote and the missing dirs above it for write -p, which appends to remote
+// if it exists.
+func (e *Env) createMissing(ctx context.Context, remote string, parents bool) error {
+	if !parents {
+		return nil
+	}
+	if err := e.FS.CreateFile(ctx, remote, true); err != nil && !errors.Is(err, fs.ErrAlreadyExist) {
+		return err
+	}
+	return nil
+}
+
+// readRemote reads remote into w, printing the server's summary of the read in verbose mode.
+func (e *Env) readRemote(ctx context.Context, remote string, w io.Writer) (int64, error) {
+	sfs, ok := e.FS.(Summarizer)
+	if !ok || e.Out == nil || !e.Out.Verbose() {
+		return e.FS.Read(ctx, remote, w)
+	}
+	n, summary, err := sfs.ReadSummarized(ctx, remote, w)
+	if err == nil {
+		printSummary("read", remote, summary)
+	}
+	return n, err
+}
+
+// writeRemote appends r to remote, printing the server's summary of the write in verbose mode.
+func (e *Env) writeRemote(ctx context.Context, remote string, r io.Reader) (int64, error) {
+	sfs, ok := e.FS.(Summarizer)
+	if !ok || e.Out == nil || !e.Out.Verbose() {
+		return e.FS.Write(ctx, remote, r)
+	}
+	n, summary, err := sfs.WriteSummarized(ctx, remote, r)
+	if err == nil {
+		printSummary("write", remote, summary)
+	}
+	return n, err
+}
+
+// printSummary prints summary to stderr, so that it doesn't mix with content read to stdout.
+// Servers that don't send summaries leave it zero.
+func printSummary(op, remote string, summary client.TransferSummary) {
+	if summary == (client.TransferSummary{}) {
+		fmt.Fprintf(os.Stderr, "%s %s: the server sent no summary\n", op, remote)
+		return
+	}
+	fmt.Fprintf(os.Stderr, "%s %s: %d bytes in %d chunks, crc32c %08x, %s on the server, confirmed\n",
+		op, remote, summary.Bytes, summary.Chunks, summary.CRC32C, summary.ServerTime)
+}
+
+func (e *Env) pipe(ctx context.Context, args []string) error {
+	p, err := ParsePipe(args)
+	if err != nil {
+		return err
+	}
+	read := func(w io.Writer) error {
+		_, err := e.FS.Read(ctx, p.Src, w)
+		return err
+	}
+	write := func(r io.Reader) error {
+		// Output to a new file is created on the way.
+		if err := e.FS.CreateFile(ctx, p.Dst, false); err != nil && !errors.Is(err, fs.ErrAlreadyExist) {
+			return err
+		}
+		_, err := e.FS.Write(ctx, p.Dst, r)
+		return err
+	}
+	return RunPipe(ctx, p, read, write)
+}
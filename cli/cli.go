@@ -0,0 +1,243 @@
+// Package cli runs the commands of the filesystem CLIs. Commands are registered once with their
+// usage and argument counts, and the shared ones (see Builtins) work on any FS, so that both the
+// in-memory and the distributed CLI get them:
+//
+//	r := cli.NewRegistry()
+//	r.Register(cli.Builtins(env)...)
+//	r.Register(cli.Command{Name: "pwd", Usage: "prints current path", Handler: pwd})
+//	shell := &cli.Shell{Registry: r, Input: env.Input, Aliases: env.Aliases, Session: env.Session}
+//	shell.Run(ctx)
+package cli
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+
+	"github.com/basharal/filesystem/alias"
+	"github.com/basharal/filesystem/session"
+	"github.com/fatih/color"
+	"github.com/golang/glog"
+)
+
+// HandlerFunc runs a command with its arguments.
+type HandlerFunc func(ctx context.Context, args []string) error
+
+// Command is a command of a CLI.
+type Command struct {
+	Name  string
+	Usage string
+
+	// MinArgs and MaxArgs bound the number of arguments, flags included. A negative MaxArgs means
+	// any number. Commands with optional flags check the rest themselves.
+	MinArgs, MaxArgs int
+
+	Handler HandlerFunc
+}
+
+// Registry dispatches command lines to the registered commands.
+type Registry struct {
+	commands map[string]Command
+}
+
+// NewRegistry returns an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{commands: make(map[string]Command)}
+}
+
+// Register adds cmds, replacing the ones with the same names. Binaries register their own commands
+// after Builtins to override them.
+func (r *Registry) Register(cmds ...Command) {
+	for _, cmd := range cmds {
+		r.commands[cmd.Name] = cmd
+	}
+}
+
+// Usage returns the usage of every command by name.
+func (r *Registry) Usage() map[string]string {
+	usage := make(map[string]string, len(r.commands))
+	for name, cmd := range r.commands {
+		usage[name] = cmd.Usage
+	}
+	return usage
+}
+
+// PrintUsage prints the usage of every command, ordered by name.
+func (r *Registry) PrintUsage() {
+	names := make([]string, 0, len(r.commands))
+	for name := range r.commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Printf("%s - %s\n", name, r.commands[name].Usage)
+	}
+}
+
+// Run parses line and runs its command.
+func (r *Registry) Run(ctx context.Context, line string) error {
+	name, args, err := Parse(line)
+	if err != nil {
+		return err
+	}
+	cmd, ok := r.commands[name]
+	if !ok {
+		return fmt.Errorf("unknown command %s", name)
+	}
+	if len(args) < cmd.MinArgs || cmd.MaxArgs >= 0 && len(args) > cmd.MaxArgs {
+		return fmt.Errorf("wrong arguments")
+	}
+	return cmd.Handler(ctx, args)
+}
+
+// Parse splits a command line into the command and its arguments.
+func Parse(line string) (string, []string, error) {
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return "", nil, fmt.Errorf("empty command")
+	}
+	// Space must be escaped for arguments. The filesystem supports space, but parsing doesn't.
+	splitted := strings.Split(line, " ")
+	if len(splitted) > 1 {
+		return splitted[0], splitted[1:], nil
+	}
+	return splitted[0], []string{}, nil
+}
+
+// Shell is the REPL of a CLI.
+type Shell struct {
+	Registry *Registry
+	Input    *bufio.Reader
+	Aliases  *alias.Set
+	Session  *session.Session
+
+	// After is called after every command (i.e., to remember the working dir). Optional.
+	After func()
+
+	// Signals delivers the signals Run handles. Defaults to SIGINT and SIGTERM.
+	Signals <-chan os.Signal
+}
+
+// Handle expands aliases and macros in line and runs the resulting commands, stopping at the first
+// failure.
+func (s *Shell) Handle(ctx context.Context, line string) error {
+	lines, err := s.Aliases.Expand(line)
+	if err != nil {
+		return err
+	}
+	for _, line := range lines {
+		if err := s.Registry.Run(ctx, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Run reads commands from the input and runs them until ctx is done, the input ends or the user
+// asks to exit. The first Ctrl-C cancels the running command (or asks to press it again when
+// idle), and a second one in a row exits, as does SIGTERM. Commands are added to the session's
+// history, which is saved after each of them.
+func (s *Shell) Run(ctx context.Context) {
+	signals := s.Signals
+	if signals == nil {
+		ch := make(chan os.Signal, 1)
+		signal.Notify(ch, os.Interrupt, syscall.SIGTERM)
+		defer signal.Stop(ch)
+		signals = ch
+	}
+	// Lines are read in the background, so that waiting for one doesn't block signals. The next
+	// line is only read when asked for, since commands read confirmations from the input too.
+	next := make(chan struct{})
+	lines := make(chan inputLine, 1)
+	go s.read(next, lines)
+	defer close(next)
+
+	fmt.Println("Please enter filesystem command.")
+	reading, interrupted := false, false
+	for {
+		if !reading {
+			next <- struct{}{}
+			reading = true
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case sig := <-signals:
+			if sig != os.Interrupt || interrupted {
+				return
+			}
+			interrupted = true
+			fmt.Println("\npress Ctrl-C again to exit")
+		case l := <-lines:
+			reading, interrupted = false, false
+			if l.err == io.EOF {
+				return
+			}
+			if l.err != nil {
+				color.Red(l.err.Error())
+				continue
+			}
+			if strings.TrimSpace(l.text) != "" {
+				s.Session.Add(strings.TrimSpace(l.text))
+			}
+			exit := !s.run(ctx, l.text, signals)
+			if s.After != nil {
+				s.After()
+			}
+			if err := s.Session.Save(); err != nil {
+				glog.Warningf("Failed to save session. %s\n", err)
+			}
+			if exit {
+				return
+			}
+		}
+	}
+}
+
+// inputLine is a line read from the input, or the error reading it.
+type inputLine struct {
+	text string
+	err  error
+}
+
+// read reads a line from the input whenever next receives, until next is closed.
+func (s *Shell) read(next <-chan struct{}, lines chan<- inputLine) {
+	for range next {
+		text, err := s.Input.ReadString('\n')
+		lines <- inputLine{text: text, err: err}
+	}
+}
+
+// run runs the commands of line. The first Ctrl-C cancels them, while a second one or SIGTERM
+// returns false right away to exit, without waiting for them.
+func (s *Shell) run(ctx context.Context, line string, signals <-chan os.Signal) bool {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- s.Handle(ctx, line)
+	}()
+	canceled := false
+	for {
+		select {
+		case err := <-done:
+			if err != nil {
+				color.Red(err.Error())
+			}
+			return true
+		case sig := <-signals:
+			if sig != os.Interrupt || canceled {
+				return false
+			}
+			canceled = true
+			cancel()
+			fmt.Println("\ncanceling, press Ctrl-C again to exit")
+		}
+	}
+}
@@ -0,0 +1,252 @@
+package cli
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/basharal/filesystem/alias"
+	"github.com/basharal/filesystem/fs"
+	"github.com/basharal/filesystem/output"
+	"github.com/basharal/filesystem/session"
+)
+
+func TestRegistry_Run(t *testing.T) {
+	var got []string
+	r := NewRegistry()
+	r.Register(Command{Name: "echo", MinArgs: 1, MaxArgs: 2, Handler: func(ctx context.Context, args []string) error {
+		got = args
+		return nil
+	}})
+	ctx := context.Background()
+	if err := r.Run(ctx, "echo a b\n"); err != nil || strings.Join(got, ",") != "a,b" {
+		t.Errorf("Run() = %v with %v, want a,b", err, got)
+	}
+	for _, line := range []string{"echo", "echo a b c", "missing", " "} {
+		if err := r.Run(ctx, line); err == nil {
+			t.Errorf("Run(%q) succeeded, want an error", line)
+		}
+	}
+}
+
+func TestBuiltins(t *testing.T) {
+	f := fs.New()
+	env := &Env{FS: Local(f), Input: bufio.NewReader(strings.NewReader("y\n")), Aliases: alias.New()}
+	r := NewRegistry()
+	r.Register(Builtins(env)...)
+	shell := &Shell{Registry: r, Aliases: env.Aliases}
+	ctx := context.Background()
+	for _, line := range []string{"alias mk = mkdir", "mk /foo", "add /a", "cp /a /b"} {
+		if err := shell.Handle(ctx, line); err != nil {
+			t.Fatalf("Handle(%s) = %v", line, err)
+		}
+	}
+	if _, _, err := f.Stat("/b"); err != nil {
+		t.Errorf("Stat(/b) = %v, want the copy", err)
+	}
+	// The confirmation is answered from the input.
+	if err := shell.Handle(ctx, "rm -r /foo"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := f.Stat("/foo"); err == nil {
+		t.Errorf("Stat(/foo) succeeded after rm -r")
+	}
+	if err := shell.Handle(ctx, "mkdir"); err == nil {
+		t.Errorf("Handle(mkdir) succeeded without a path")
+	}
+}
+
+func TestBuiltins_Stdin(t *testing.T) {
+	f := fs.New()
+	if err := f.NewFile("/a"); err != nil {
+		t.Fatal(err)
+	}
+	env := &Env{FS: Local(f), Input: bufio.NewReader(strings.NewReader("piped\ncontent")), Aliases: alias.New()}
+	r := NewRegistry()
+	r.Register(Builtins(env)...)
+	ctx := context.Background()
+	// The file isn't empty the second time, and nothing is asked.
+	for i := 0; i < 2; i++ {
+		if err := r.Run(ctx, "write - /a"); err != nil {
+			t.Fatalf("Run(write - /a) = %v", err)
+		}
+	}
+	var buf strings.Builder
+	if _, err := f.Read("/a", &buf); err != nil || buf.String() != "piped\ncontent" {
+		t.Errorf("Read(/a) = %q, %v, want the input", buf.String(), err)
+	}
+}
+
+func TestBuiltins_Parents(t *testing.T) {
+	f := fs.New()
+	local := filepath.Join(t.TempDir(), "local.txt")
+	if err := os.WriteFile(local, []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	env := &Env{FS: Local(f), Out: &output.Printer{}, Aliases: alias.New()}
+	r := NewRegistry()
+	r.Register(Builtins(env)...)
+	ctx := context.Background()
+	for _, line := range []string{"write -p " + local + " /a/b/c/file.txt", "add -p /a/d/file", "write -p -f " + local + " /a/b/c/file.txt"} {
+		if err := r.Run(ctx, line); err != nil {
+			t.Fatalf("Run(%s) = %v", line, err)
+		}
+	}
+	var buf strings.Builder
+	if _, err := f.Read("/a/b/c/file.txt", &buf); err != nil || buf.String() != "datadata" {
+		t.Errorf("Read(/a/b/c/file.txt) = %q, %v, want the local file twice", buf.String(), err)
+	}
+	if file, _, err := f.Stat("/a/d/file"); err != nil || file == nil {
+		t.Errorf("Stat(/a/d/file) = %v, %v, want the added file", file, err)
+	}
+	if err := r.Run(ctx, "add /e/file"); err == nil {
+		t.Errorf("Run(add /e/file) created the parents without -p")
+	}
+}
+
+func TestBuiltins_Apply(t *testing.T) {
+	f := fs.New()
+	dir := t.TempDir()
+	manifest := filepath.Join(dir, "layout.json")
+	layout := `{"dirs": ["etc"], "files": [{"path": "etc/app.conf", "local": "app.conf"}, {"path": "README", "content": "hi"}]}`
+	if err := os.WriteFile(manifest, []byte(layout), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "app.conf"), []byte("port=1"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	env := &Env{FS: Local(f), Out: &output.Printer{}, Aliases: alias.New()}
+	r := NewRegistry()
+	r.Register(Builtins(env)...)
+	ctx := context.Background()
+	for _, line := range []string{"add -p /app/stale", "apply " + manifest + " /app -n"} {
+		if err := r.Run(ctx, line); err != nil {
+			t.Fatalf("Run(%s) = %v", line, err)
+		}
+	}
+	if file, _, err := f.Stat("/app/stale"); err != nil || file == nil {
+		t.Fatalf("Stat(/app/stale) = %v, %v, want it kept by the dry run", file, err)
+	}
+	if err := r.Run(ctx, "apply -f "+manifest+" /app"); err != nil {
+		t.Fatalf("Run(apply) = %v", err)
+	}
+	if _, _, err := f.Stat("/app/stale"); !errors.Is(err, fs.ErrNotFound) {
+		t.Errorf("Stat(/app/stale) = %v, want it removed", err)
+	}
+	var buf strings.Builder
+	if _, err := f.Read("/app/etc/app.conf", &buf); err != nil || buf.String() != "port=1" {
+		t.Errorf("Read(/app/etc/app.conf) = %q, %v, want the local file", buf.String(), err)
+	}
+}
+
+func TestBuiltins_Scaffold(t *testing.T) {
+	f := fs.New()
+	spec := filepath.Join(t.TempDir(), "service.json")
+	layout := `{"dirs": ["docs", "src/cmd"], "files": [{"path": "src/main.go", "content": "package main"}, {"path": "README"}, {"path": "docs/old/README", "source": "/projects/old/README"}]}`
+	if err := os.WriteFile(spec, []byte(layout), 0644); err != nil {
+		t.Fatal(err)
+	}
+	env := &Env{FS: Local(f), Out: &output.Printer{}, Aliases: alias.New()}
+	r := NewRegistry()
+	r.Register(Builtins(env)...)
+	ctx := context.Background()
+	if err := r.Run(ctx, "add -p /projects/old/README"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write("/projects/old/README", strings.NewReader("old")); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Run(ctx, "scaffold "+spec+" /projects/new"); err != nil {
+		t.Fatalf("Run(scaffold) = %v", err)
+	}
+	for _, dir := range []string{"/projects/new/docs/", "/projects/new/docs/old/", "/projects/new/src/cmd/"} {
+		if _, d, err := f.Stat(dir); err != nil || d == nil {
+			t.Errorf("Stat(%s) = %v, %v, want the scaffolded dir", dir, d, err)
+		}
+	}
+	var buf strings.Builder
+	if _, err := f.Read("/projects/new/src/main.go", &buf); err != nil || buf.String() != "package main" {
+		t.Errorf("Read(/projects/new/src/main.go) = %q, %v, want the spec's content", buf.String(), err)
+	}
+	buf.Reset()
+	if _, err := f.Read("/projects/new/docs/old/README", &buf); err != nil || buf.String() != "old" {
+		t.Errorf("Read(/projects/new/docs/old/README) = %q, %v, want the source's content", buf.String(), err)
+	}
+	if err := r.Run(ctx, "scaffold "+spec+" /projects/old"); !errors.Is(err, fs.ErrAlreadyExist) {
+		t.Errorf("Run(scaffold) over an existing file = %v, want ErrAlreadyExist", err)
+	}
+}
+
+func TestShell_RunSignals(t *testing.T) {
+	started, canceled := make(chan struct{}), make(chan struct{})
+	r := NewRegistry()
+	r.Register(Command{Name: "wait", Handler: func(ctx context.Context, args []string) error {
+		close(started)
+		<-ctx.Done()
+		close(canceled)
+		return ctx.Err()
+	}})
+	sess, err := session.Load("", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	in, w := io.Pipe()
+	defer w.Close()
+	signals := make(chan os.Signal)
+	shell := &Shell{Registry: r, Input: bufio.NewReader(in), Aliases: alias.New(), Session: sess, Signals: signals}
+	done := make(chan struct{})
+	go func() {
+		shell.Run(context.Background())
+		close(done)
+	}()
+
+	io.WriteString(w, "wait\n")
+	<-started
+	// The first Ctrl-C cancels the command, and the shell keeps running.
+	signals <- os.Interrupt
+	<-canceled
+	signals <- os.Interrupt
+	select {
+	case <-done:
+		t.Fatal("Run() returned after a single Ctrl-C while idle")
+	default:
+	}
+	signals <- os.Interrupt
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run() didn't return after a second Ctrl-C")
+	}
+	if got := sess.History(); len(got) != 1 || got[0] != "wait" {
+		t.Errorf("History() = %v, want [wait]", got)
+	}
+}
+
+func TestParsePipe(t *testing.T) {
+	p, err := ParsePipe(strings.Split("/a.log | grep -v x > /b.log", " "))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Src != "/a.log" || p.Dst != "/b.log" || strings.Join(p.Cmd, " ") != "grep -v x" {
+		t.Errorf("ParsePipe() = %+v", p)
+	}
+	if _, err := ParsePipe([]string{"/a.log", "|"}); err == nil {
+		t.Errorf("ParsePipe() without a command succeeded")
+	}
+}
+
+func TestParseRmFlags(t *testing.T) {
+	path, flags, err := ParseRmFlags([]string{"-rf", "/foo"})
+	if err != nil || path != "/foo" || !flags.Recursive || !flags.Force || flags.Interactive {
+		t.Errorf("ParseRmFlags() = %s, %+v, %v", path, flags, err)
+	}
+	if _, _, err := ParseRmFlags([]string{"-x", "/foo"}); err == nil {
+		t.Errorf("ParseRmFlags(-x) succeeded")
+	}
+}
@@ -0,0 +1,89 @@
+package cli
+
+import (
+	"bufio"
+	"fmt"
+	"strings"
+)
+
+// Confirm asks the user a yes/no question through the REPL's input and returns true for yes.
+func Confirm(input *bufio.Reader, question string) (bool, error) {
+	fmt.Printf("%s [y/N] ", question)
+	answer, err := input.ReadString('\n')
+	if err != nil {
+		return false, err
+	}
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true, nil
+	}
+	return false, nil
+}
+
+// RmFlags are the options of rm.
+type RmFlags struct {
+	Recursive   bool
+	Interactive bool
+	Force       bool
+}
+
+// ParseRmFlags separates flags (i.e., -r, -i, -f, -rf or --force) from the path of rm.
+func ParseRmFlags(args []string) (string, RmFlags, error) {
+	var flags RmFlags
+	path := ""
+	for _, arg := range args {
+		switch {
+		case arg == "--force":
+			flags.Force = true
+		case strings.HasPrefix(arg, "-") && len(arg) > 1:
+			for _, f := range arg[1:] {
+				switch f {
+				case 'r':
+					flags.Recursive = true
+				case 'i':
+					flags.Interactive = true
+				case 'f':
+					flags.Force = true
+				default:
+					return "", flags, fmt.Errorf("unknown flag -%c", f)
+				}
+			}
+		case path == "":
+			path = arg
+		default:
+			return "", flags, fmt.Errorf("wrong arguments")
+		}
+	}
+	if path == "" {
+		return "", flags, fmt.Errorf("wrong arguments")
+	}
+	return path, flags, nil
+}
+
+// ParseForce separates -f/--force from the other arguments.
+func ParseForce(args []string) ([]string, bool) {
+	return cutFlag(args, "-f", "--force")
+}
+
+// ParseParents separates -p, which creates missing parent dirs, from the other arguments.
+func ParseParents(args []string) ([]string, bool) {
+	return cutFlag(args, "-p")
+}
+
+// cutFlag separates the flag named any of names from the other arguments.
+func cutFlag(args []string, names ...string) ([]string, bool) {
+	rest := make([]string, 0, len(args))
+	found := false
+	for _, arg := range args {
+		matched := false
+		for _, name := range names {
+			matched = matched || arg == name
+		}
+		if matched {
+			found = true
+			continue
+		}
+		rest = append(rest, arg)
+	}
+	return rest, found
+}
@@ -0,0 +1,175 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/basharal/filesystem/client"
+	"github.com/basharal/filesystem/fs"
+)
+
+// FS is what the shared commands need from the filesystem a CLI operates on. Paths are the ones
+// given to commands. Local and Remote adapt the in-memory filesystem and the distributed client.
+type FS interface {
+	MakeDir(ctx context.Context, path string) error
+	// MakeDirAll makes the dir at path along with its missing parents. Dirs that exist are fine.
+	MakeDirAll(ctx context.Context, path string) error
+	// CreateFile creates an empty file at path, along with the missing dirs above it with parents.
+	CreateFile(ctx context.Context, path string, parents bool) error
+	TouchFile(ctx context.Context, path string) error
+	Remove(ctx context.Context, path string) error
+	Copy(ctx context.Context, src, dst string) error
+	DeletePrefix(ctx context.Context, path string, dryRun bool) (int, error)
+	Read(ctx context.Context, path string, writer io.Writer) (int64, error)
+	Write(ctx context.Context, path string, reader io.Reader) (int64, error)
+
+	// Stat returns the size of the file at path, or true for dirs.
+	Stat(ctx context.Context, path string) (size int64, isDir bool, err error)
+}
+
+// Summarizer is implemented by FSs whose servers confirm reads and writes with a summary (see
+// client.TransferSummary), which verbose CLIs print.
+type Summarizer interface {
+	ReadSummarized(ctx context.Context, path string, writer io.Writer) (int64, client.TransferSummary, error)
+	WriteSummarized(ctx context.Context, path string, reader io.Reader) (int64, client.TransferSummary, error)
+}
+
+// ManifestApplier is implemented by FSs that converge dirs to manifests (see
+// fs.FileSystem.ApplyManifest).
+type ManifestApplier interface {
+	ApplyManifest(ctx context.Context, path string, m fs.Manifest, dryRun bool) (fs.ManifestResult, error)
+}
+
+// Local returns f as an FS.
+func Local(f fs.Interface) FS {
+	return local{f: f}
+}
+
+type local struct {
+	f fs.Interface
+}
+
+func (l local) MakeDir(ctx context.Context, path string) error {
+	return l.f.MakeDir(path)
+}
+
+func (l local) MakeDirAll(ctx context.Context, path string) error {
+	maker, ok := l.f.(fs.DirMaker)
+	if !ok {
+		return fmt.Errorf("creating parents: %w", fs.ErrNotSupported)
+	}
+	return maker.MakeDirAll(path)
+}
+
+func (l local) CreateFile(ctx context.Context, path string, parents bool) error {
+	if !parents {
+		return l.f.NewFile(path)
+	}
+	creator, ok := l.f.(fs.Creator)
+	if !ok {
+		return fmt.Errorf("creating parents: %w", fs.ErrNotSupported)
+	}
+	return creator.CreateFile(path, fs.CreateOpts{Parents: true})
+}
+
+func (l local) TouchFile(ctx context.Context, path string) error {
+	return l.f.TouchFile(path)
+}
+
+func (l local) Remove(ctx context.Context, path string) error {
+	return l.f.Remove(path)
+}
+
+func (l local) Copy(ctx context.Context, src, dst string) error {
+	return l.f.Copy(src, dst)
+}
+
+func (l local) DeletePrefix(ctx context.Context, path string, dryRun bool) (int, error) {
+	return l.f.DeletePrefix(path, dryRun)
+}
+
+func (l local) Read(ctx context.Context, path string, writer io.Writer) (int64, error) {
+	return l.f.Read(path, writer)
+}
+
+func (l local) Write(ctx context.Context, path string, reader io.Reader) (int64, error) {
+	return l.f.Write(path, reader)
+}
+
+func (l local) ApplyManifest(ctx context.Context, path string, m fs.Manifest, dryRun bool) (fs.ManifestResult, error) {
+	applier, ok := l.f.(fs.ManifestApplier)
+	if !ok {
+		return fs.ManifestResult{}, fmt.Errorf("applying manifests: %w", fs.ErrNotSupported)
+	}
+	return applier.ApplyManifest(path, m, dryRun)
+}
+
+func (l local) Stat(ctx context.Context, path string) (int64, bool, error) {
+	file, _, err := l.f.Stat(path)
+	if err != nil {
+		return 0, false, err
+	}
+	if file == nil {
+		return 0, true, nil
+	}
+	return file.Size(), false, nil
+}
+
+// Remote returns c as an FS, reading with opts (i.e., client.MaxStaleness). c must be dialed.
+func Remote(c *client.Client, opts ...client.CallOption) FS {
+	return remote{c, opts}
+}
+
+type remote struct {
+	*client.Client
+
+	reads []client.CallOption
+}
+
+func (r remote) MakeDir(ctx context.Context, path string) error {
+	return r.Client.MakeDir(ctx, path)
+}
+
+func (r remote) MakeDirAll(ctx context.Context, path string) error {
+	return r.Client.MakeDir(ctx, path, client.Parents())
+}
+
+func (r remote) CreateFile(ctx context.Context, path string, parents bool) error {
+	if parents {
+		return r.Client.CreateFile(ctx, path, client.Parents())
+	}
+	return r.Client.CreateFile(ctx, path)
+}
+
+func (r remote) Read(ctx context.Context, path string, writer io.Writer) (int64, error) {
+	return r.Client.Read(ctx, path, writer, r.reads...)
+}
+
+func (r remote) Write(ctx context.Context, path string, reader io.Reader) (int64, error) {
+	return r.Client.Write(ctx, path, reader)
+}
+
+func (r remote) ReadSummarized(ctx context.Context, path string, writer io.Writer) (int64, client.TransferSummary, error) {
+	var s client.TransferSummary
+	opts := append(append([]client.CallOption(nil), r.reads...), client.Summary(&s))
+	n, err := r.Client.Read(ctx, path, writer, opts...)
+	return n, s, err
+}
+
+func (r remote) WriteSummarized(ctx context.Context, path string, reader io.Reader) (int64, client.TransferSummary, error) {
+	var s client.TransferSummary
+	n, err := r.Client.Write(ctx, path, reader, client.Summary(&s))
+	return n, s, err
+}
+
+func (r remote) Stat(ctx context.Context, path string) (int64, bool, error) {
+	file, _, err := r.Client.Stat(ctx, path)
+	if err != nil {
+		return 0, false, err
+	}
+	if file == nil {
+		return 0, true, nil
+	}
+	return file.Size, false, nil
+}
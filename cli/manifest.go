@@ -0,0 +1,62 @@
+package cli
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/basharal/filesystem/fs"
+)
+
+// manifestFile is the JSON format of manifests:
+//
+//	{"dirs": ["etc"], "files": [{"path": "etc/app.conf", "local": "app.conf"}, ...]}
+//
+// Paths are relative to the dir the manifest is applied to. The content of a file is given inline
+// (content), read from a local file relative to the manifest (local) or copied from a file in the
+// filesystem (source). A file with only a hex sha256 must already have that content.
+type manifestFile struct {
+	Dirs  []string `json:"dirs"`
+	Files []struct {
+		Path    string `json:"path"`
+		Content string `json:"content"`
+		Local   string `json:"local"`
+		Source  string `json:"source"`
+		SHA256  string `json:"sha256"`
+	} `json:"files"`
+}
+
+// ReadManifest reads the manifest file at path (see manifestFile), along with the local files it
+// refers to.
+func ReadManifest(path string) (fs.Manifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fs.Manifest{}, err
+	}
+	var mf manifestFile
+	if err := json.Unmarshal(data, &mf); err != nil {
+		return fs.Manifest{}, fmt.Errorf("manifest %s: %w", path, err)
+	}
+	m := fs.Manifest{Dirs: mf.Dirs, Files: make([]fs.ManifestFile, 0, len(mf.Files))}
+	for _, f := range mf.Files {
+		file := fs.ManifestFile{Path: f.Path, Content: []byte(f.Content), Source: f.Source}
+		if f.Local != "" {
+			local := f.Local
+			if !filepath.IsAbs(local) {
+				local = filepath.Join(filepath.Dir(path), local)
+			}
+			if file.Content, err = os.ReadFile(local); err != nil {
+				return fs.Manifest{}, fmt.Errorf("content of %s: %w", f.Path, err)
+			}
+		}
+		if f.SHA256 != "" {
+			if file.SHA256, err = hex.DecodeString(f.SHA256); err != nil {
+				return fs.Manifest{}, fmt.Errorf("sha256 of %s: %w", f.Path, err)
+			}
+		}
+		m.Files = append(m.Files, file)
+	}
+	return m, nil
+}
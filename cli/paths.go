@@ -0,0 +1,44 @@
+package cli
+
+import (
+	"fmt"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// LocalPath normalizes a path on the local side of a command. Both '/' and '\' are accepted as
+// separators, so that paths pasted from Windows work everywhere.
+func LocalPath(p string) (string, error) {
+	if p == "" {
+		return "", fmt.Errorf("local path is empty")
+	}
+	if runtime.GOOS != "windows" {
+		p = strings.ReplaceAll(p, `\`, "/")
+	}
+	return filepath.Clean(filepath.FromSlash(p)), nil
+}
+
+// RemotePath validates a path on the filesystem's side of a command. Only '/' is a separator there.
+func RemotePath(p string) (string, error) {
+	if p == "" {
+		return "", fmt.Errorf("remote path is empty")
+	}
+	if strings.Contains(p, `\`) {
+		return "", fmt.Errorf("remote path %s must use '/' as the separator", p)
+	}
+	return p, nil
+}
+
+// LocalAndRemote validates the local and remote paths of a read/write command.
+func LocalAndRemote(local, remote string) (string, string, error) {
+	local, err := LocalPath(local)
+	if err != nil {
+		return "", "", err
+	}
+	remote, err = RemotePath(remote)
+	if err != nil {
+		return "", "", err
+	}
+	return local, remote, nil
+}
@@ -0,0 +1,86 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// PipeArgs are the parts of `pipe /remote | cmd args... [> /remote]`.
+type PipeArgs struct {
+	Src string
+	Cmd []string
+	// Dst is optional. The command's output goes to stdout without it.
+	Dst string
+}
+
+// ParsePipe parses the arguments of pipe.
+func ParsePipe(args []string) (PipeArgs, error) {
+	if len(args) < 3 || args[1] != "|" {
+		return PipeArgs{}, fmt.Errorf("wrong arguments")
+	}
+	p := PipeArgs{Src: args[0], Cmd: args[2:]}
+	if n := len(p.Cmd); n >= 2 && p.Cmd[n-2] == ">" {
+		p.Dst = p.Cmd[n-1]
+		p.Cmd = p.Cmd[:n-2]
+	}
+	if len(p.Cmd) == 0 {
+		return PipeArgs{}, fmt.Errorf("missing local command")
+	}
+	for _, path := range []string{p.Src, p.Dst} {
+		if path == "" {
+			continue
+		}
+		if _, err := RemotePath(path); err != nil {
+			return PipeArgs{}, err
+		}
+	}
+	return p, nil
+}
+
+// RunPipe runs the local command of p while read streams the remote source into its stdin. If p
+// has a destination, write streams the command's stdout into it.
+func RunPipe(ctx context.Context, p PipeArgs, read func(io.Writer) error, write func(io.Reader) error) error {
+	cmd := exec.CommandContext(ctx, p.Cmd[0], p.Cmd[1:]...)
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	var stdout io.Reader
+	if p.Dst == "" {
+		cmd.Stdout = os.Stdout
+	} else if stdout, err = cmd.StdoutPipe(); err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start %s. %w", p.Cmd[0], err)
+	}
+
+	readErr := make(chan error, 1)
+	go func() {
+		err := read(stdin)
+		stdin.Close()
+		// Commands may exit before consuming all their input (i.e., head).
+		if errors.Is(err, syscall.EPIPE) {
+			err = nil
+		}
+		readErr <- err
+	}()
+	var writeErr error
+	if stdout != nil {
+		// All the output must be consumed before waiting for the command.
+		writeErr = write(stdout)
+	}
+	if err := cmd.Wait(); err != nil {
+		return fmt.Errorf("%s failed. %w", p.Cmd[0], err)
+	}
+	if err := <-readErr; err != nil {
+		return err
+	}
+	return writeErr
+}
@@ -0,0 +1,194 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+
+	"github.com/basharal/filesystem/fs"
+	"github.com/basharal/filesystem/fspath"
+)
+
+// scaffoldWorkers is how many dirs/files scaffold creates at once. Through the client, they're
+// spread over the shards holding them.
+const scaffoldWorkers = 8
+
+// ScaffoldResult is what Scaffold created. Dirs that existed already aren't counted.
+type ScaffoldResult struct {
+	Dirs, Files int
+}
+
+// Scaffold creates the dirs and files of spec (see manifestFile) under dir, along with dir and its
+// missing parents. Unlike apply, it only adds: existing dirs are kept, while existing files fail
+// it. Dirs are created a level at a time and files all at once, each on up to scaffoldWorkers
+// goroutines. The first failure cancels the rest, leaving what was created.
+func Scaffold(ctx context.Context, f FS, dir string, spec fs.Manifest) (ScaffoldResult, error) {
+	var result ScaffoldResult
+	dirs, files, err := scaffoldPaths(dir, spec)
+	if err != nil {
+		return result, err
+	}
+	var mu sync.Mutex
+	for _, level := range dirs {
+		level := level
+		err := parallel(ctx, len(level), func(ctx context.Context, i int) error {
+			// The parents of the level exist, so MakeDirAll only makes the missing dir, which is
+			// what's counted.
+			if _, isDir, err := f.Stat(ctx, level[i]); err == nil && isDir {
+				return nil
+			}
+			if err := f.MakeDirAll(ctx, level[i]); err != nil {
+				return err
+			}
+			mu.Lock()
+			result.Dirs++
+			mu.Unlock()
+			return nil
+		})
+		if err != nil {
+			return result, err
+		}
+	}
+	err = parallel(ctx, len(files), func(ctx context.Context, i int) error {
+		if err := scaffoldFile(ctx, f, files[i]); err != nil {
+			return err
+		}
+		mu.Lock()
+		result.Files++
+		mu.Unlock()
+		return nil
+	})
+	return result, err
+}
+
+// scaffoldPaths returns the dirs to create by depth, starting with dir's ancestors, and the files
+// of spec, both with absolute paths.
+func scaffoldPaths(dir string, spec fs.Manifest) ([][]string, []fs.ManifestFile, error) {
+	if !fspath.IsAbs(dir) {
+		return nil, nil, fmt.Errorf("scaffold dir %s must be absolute: %w", dir, fs.ErrInvalidName)
+	}
+	dir = fspath.Clean(dir)
+	seen := make(map[string]bool)
+	var dirs [][]string
+	addDir := func(p string) {
+		for p != fspath.Root && !seen[p] {
+			seen[p] = true
+			depth := len(fspath.Split(p))
+			for len(dirs) < depth {
+				dirs = append(dirs, nil)
+			}
+			dirs[depth-1] = append(dirs[depth-1], p)
+			p = fspath.Dir(p)
+		}
+	}
+	join := func(rel string) (string, error) {
+		if rel == "" || fspath.IsAbs(rel) || hasDotDot(rel) {
+			return "", fmt.Errorf("spec path %q must be relative and stay under %s: %w", rel, dir, fs.ErrInvalidName)
+		}
+		return fspath.Join(dir, rel), nil
+	}
+	addDir(dir)
+	for _, d := range spec.Dirs {
+		p, err := join(d)
+		if err != nil {
+			return nil, nil, err
+		}
+		addDir(p)
+	}
+	files := make([]fs.ManifestFile, 0, len(spec.Files))
+	for _, file := range spec.Files {
+		p, err := join(file.Path)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(file.SHA256) > 0 && len(file.Content) == 0 && file.Source == "" {
+			return nil, nil, fmt.Errorf("scaffolding %s from only a sha256: %w", file.Path, fs.ErrNotSupported)
+		}
+		addDir(fspath.Dir(p))
+		file.Path = p
+		files = append(files, file)
+	}
+	for _, level := range dirs {
+		sort.Strings(level)
+	}
+	return dirs, files, nil
+}
+
+func hasDotDot(p string) bool {
+	for _, name := range fspath.Split(p) {
+		if name == ".." {
+			return true
+		}
+	}
+	return false
+}
+
+// scaffoldFile creates file, copying it from its source or writing its content.
+func scaffoldFile(ctx context.Context, f FS, file fs.ManifestFile) error {
+	// Its dir exists, so parents only lets the file be created below the top level.
+	if err := f.CreateFile(ctx, file.Path, true); err != nil {
+		return err
+	}
+	if file.Source != "" {
+		r, w := io.Pipe()
+		go func() {
+			_, err := f.Read(ctx, file.Source, w)
+			w.CloseWithError(err)
+		}()
+		_, err := f.Write(ctx, file.Path, r)
+		// Unblocks the read if the write stopped reading.
+		r.CloseWithError(err)
+		return err
+	}
+	if len(file.Content) == 0 {
+		return nil
+	}
+	_, err := f.Write(ctx, file.Path, bytes.NewReader(file.Content))
+	return err
+}
+
+// parallel calls fn with 0 to n-1 on up to scaffoldWorkers goroutines. The first error cancels the
+// ctx of the other calls and is returned.
+func parallel(ctx context.Context, n int, fn func(ctx context.Context, i int) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var (
+		wg    sync.WaitGroup
+		once  sync.Once
+		first error
+	)
+	next := make(chan int)
+	workers := scaffoldWorkers
+	if workers > n {
+		workers = n
+	}
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				if err := fn(ctx, i); err != nil {
+					once.Do(func() {
+						first = err
+						cancel()
+					})
+				}
+			}
+		}()
+	}
+	for i := 0; i < n && ctx.Err() == nil; i++ {
+		select {
+		case next <- i:
+		case <-ctx.Done():
+		}
+	}
+	close(next)
+	wg.Wait()
+	if first == nil {
+		return ctx.Err()
+	}
+	return first
+}
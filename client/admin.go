@@ -0,0 +1,22 @@
+package client
+
+import (
+	"fmt"
+
+	"github.com/basharal/filesystem/proto/pb_filesystem"
+)
+
+// Admin returns a client of the admin service of the server at addr, over the client's connection
+// to it. The client must be dialed. It returns a *CapabilityError for servers without the service.
+func (c *Client) Admin(addr string) (pb_filesystem.FileAdminClient, error) {
+	c.mu.RLock()
+	conn, ok := c.conns[addr]
+	c.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("not connected to server %s", addr)
+	}
+	if !c.supports(addr, FeatureAdmin) {
+		return nil, &CapabilityError{Addr: addr, Feature: FeatureAdmin}
+	}
+	return pb_filesystem.NewFileAdminClient(conn), nil
+}
@@ -0,0 +1,53 @@
+package client
+
+import (
+	"context"
+	"io"
+
+	"github.com/basharal/filesystem/fs"
+)
+
+// Backend returns the cluster as an fs.Backend so that it can be mounted into a local filesystem
+// with fs.Mount. Dial must be called first.
+func (c *Client) Backend() fs.Backend {
+	return backend{c: c}
+}
+
+type backend struct {
+	c *Client
+}
+
+func (b backend) ListDir(ctx context.Context, path string) ([]fs.BackendEntry, error) {
+	files, dirs, err := b.c.ListDir(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]fs.BackendEntry, 0, len(files)+len(dirs))
+	for _, f := range files {
+		entries = append(entries, fs.BackendEntry{Name: f.Name, Size: f.Size})
+	}
+	for _, d := range dirs {
+		entries = append(entries, fs.BackendEntry{Name: d.Name, IsDir: true})
+	}
+	return entries, nil
+}
+
+func (b backend) MakeDir(ctx context.Context, path string) error {
+	return b.c.MakeDir(ctx, path)
+}
+
+func (b backend) CreateFile(ctx context.Context, path string) error {
+	return b.c.CreateFile(ctx, path)
+}
+
+func (b backend) Remove(ctx context.Context, path string) error {
+	return b.c.Remove(ctx, path)
+}
+
+func (b backend) Read(ctx context.Context, path string, writer io.Writer) (int64, error) {
+	return b.c.Read(ctx, path, writer)
+}
+
+func (b backend) Write(ctx context.Context, path string, reader io.Reader) (int64, error) {
+	return b.c.Write(ctx, path, reader)
+}
@@ -0,0 +1,175 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang/glog"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ErrCircuitOpen is returned without contacting a server after it failed too many times in a row.
+var ErrCircuitOpen = errors.New("circuit open")
+
+// defaultBreakerCooldown is how long an open circuit fails fast if BreakerOpts.Cooldown isn't set.
+const defaultBreakerCooldown = 10 * time.Second
+
+// BreakerOpts configure the circuit breaker in front of each server, so that a downed server fails
+// fast instead of adding the full timeout to every operation spanning it.
+type BreakerOpts struct {
+	// Failures is how many consecutive failures open a server's circuit. 0 disables the breakers.
+	Failures int
+
+	// Cooldown is how long an open circuit fails fast before letting a single request through to
+	// probe the server (half-open). Defaults to 10s.
+	Cooldown time.Duration
+}
+
+// breaker is the circuit breaker of a single server. Only unavailable servers and deadlines count
+// as failures, not errors returned by a healthy server (i.e., not found).
+type breaker struct {
+	addr     string
+	failures int
+	cooldown time.Duration
+
+	mu       sync.Mutex
+	failed   int
+	openedAt time.Time
+	probing  bool
+}
+
+func newBreaker(addr string, opts BreakerOpts) *breaker {
+	cooldown := opts.Cooldown
+	if cooldown == 0 {
+		cooldown = defaultBreakerCooldown
+	}
+	return &breaker{addr: addr, failures: opts.Failures, cooldown: cooldown}
+}
+
+// allow returns an error if the circuit is open, or half-open with a probe already in flight.
+func (b *breaker) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.failed < b.failures {
+		return nil
+	}
+	if b.probing || time.Since(b.openedAt) < b.cooldown {
+		return fmt.Errorf("%s: %w", b.addr, ErrCircuitOpen)
+	}
+	b.probing = true
+	return nil
+}
+
+// done records the outcome of a request that was allowed. Requests the client canceled tell
+// nothing about the server, so they only let another probe through.
+func (b *breaker) done(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+	if status.Code(err) == codes.Canceled || errors.Is(err, context.Canceled) {
+		return
+	}
+	if !tripsBreaker(err) {
+		if b.failed >= b.failures {
+			glog.Infof("Circuit of %s closed\n", b.addr)
+		}
+		b.failed = 0
+		return
+	}
+	b.failed++
+	if b.failed >= b.failures {
+		if b.failed == b.failures {
+			glog.Warningf("Circuit of %s opened after %d failures. %s\n", b.addr, b.failed, err)
+		}
+		b.openedAt = time.Now()
+	}
+}
+
+// tripsBreaker returns true if err means the server couldn't serve the request.
+func tripsBreaker(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded:
+		return true
+	}
+	return false
+}
+
+func (b *breaker) unaryInterceptor(ctx context.Context, method string, req, reply interface{},
+	cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	if err := b.allow(); err != nil {
+		return err
+	}
+	err := invoker(ctx, method, req, reply, cc, opts...)
+	b.done(err)
+	return err
+}
+
+func (b *breaker) streamInterceptor(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn,
+	method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	if err := b.allow(); err != nil {
+		return nil, err
+	}
+	stream, err := streamer(ctx, desc, cc, method, opts...)
+	if err != nil {
+		b.done(err)
+		return nil, err
+	}
+	return newBreakerStream(ctx, stream, b), nil
+}
+
+// breakerStream reports the outcome of a stream to its breaker once it ends. Streams that are
+// abandoned instead of read until they end (i.e., hedges that lost) end with their context, which
+// callers must cancel like with any gRPC stream. Those count as successes if they got a response.
+// Otherwise, a half-open breaker would wait for the outcome of its probe forever.
+type breakerStream struct {
+	grpc.ClientStream
+	breaker *breaker
+	once    sync.Once
+	ended   chan struct{}
+	// received is 1 once a message is received. It's accessed atomically.
+	received int32
+}
+
+func newBreakerStream(ctx context.Context, stream grpc.ClientStream, b *breaker) *breakerStream {
+	s := &breakerStream{ClientStream: stream, breaker: b, ended: make(chan struct{})}
+	go func() {
+		select {
+		case <-ctx.Done():
+			if atomic.LoadInt32(&s.received) == 1 {
+				s.end(nil)
+			} else {
+				s.end(status.FromContextError(ctx.Err()).Err())
+			}
+		case <-s.ended:
+		}
+	}()
+	return s
+}
+
+// end reports the outcome of the stream the first time it's called.
+func (s *breakerStream) end(err error) {
+	s.once.Do(func() {
+		s.breaker.done(err)
+		close(s.ended)
+	})
+}
+
+func (s *breakerStream) RecvMsg(m interface{}) error {
+	err := s.ClientStream.RecvMsg(m)
+	switch {
+	case err == nil:
+		atomic.StoreInt32(&s.received, 1)
+	case err == io.EOF:
+		s.end(nil)
+	default:
+		s.end(err)
+	}
+	return err
+}
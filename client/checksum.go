@@ -0,0 +1,25 @@
+package client
+
+import (
+	"errors"
+	"hash/crc32"
+
+	"github.com/basharal/filesystem/proto/pb_filesystem"
+)
+
+// ErrChecksum is returned when a chunk of a file doesn't match its checksum (see Opts.Checksums),
+// whether the client or the server caught it, or a transfer doesn't match the server's summary of
+// it (see TransferSummary).
+var ErrChecksum = errors.New("content doesn't match its checksum")
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// checkPayload returns ErrChecksum if payload carries a checksum its data doesn't match, counting
+// the mismatch.
+func (c *Client) checkPayload(payload *pb_filesystem.Payload) error {
+	if !payload.GetHasCrc32c() || crc32.Checksum(payload.GetData(), castagnoli) == payload.GetCrc32c() {
+		return nil
+	}
+	c.metrics.checksumFailed()
+	return ErrChecksum
+}
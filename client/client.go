@@ -1,49 +1,189 @@
 package client
 
 import (
+	"bytes"
 	"context"
 	"fmt"
+	"hash/crc32"
 	"io"
 	"os"
+	"path/filepath"
+	"sort"
 	"sync"
+	"time"
 
 	"github.com/basharal/filesystem/fs"
+	"github.com/basharal/filesystem/fspath"
 	"github.com/basharal/filesystem/proto/pb_filesystem"
+	"github.com/golang/glog"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/keepalive"
 )
 
 // Server represents a file-server
 type Server struct {
-	// StartPrefix is the prefix for first possible path on the server (inclusive)
+	// StartPrefix is the prefix for first possible path on the server (inclusive). Prefixes are
+	// relative to the cluster's root and compared with paths lexicographically, so they can be a
+	// letter (i.e., n) or split a dir (i.e., projects/m). Empty starts the range at the beginning of
+	// the namespace, so that the first server owns every name before the others' ranges.
 	StartPrefix string `json:"start_prefix"`
 
-	// EndPrefix is the prefix for last possible path on the server (exclusive)
+	// EndPrefix is the prefix for last possible path on the server (exclusive). Empty runs the
+	// range to the end of the namespace.
 	EndPrefix string `json:"end_prefix"`
 
-	// Addr is the ip:port (or host:port) for the server to accept gRPC requests.
+	// Addr is the ip:port (or host:port) for the server to accept gRPC requests. It's the preferred
+	// address of the server.
 	Addr string `json:"addr_prefix"`
+
+	// Addrs are other addresses the server listens on (i.e., [::1]:9800 or unix:///tmp/fs.sock),
+	// in order of preference. They're tried when Addr can't be reached. Optional.
+	Addrs []string `json:"addrs,omitempty"`
+
+	// Replicas are the addresses of servers serving the same prefix range (i.e., seeded from the
+	// same dir or read replicas of the server). Only reads are sent to them, when hedging or first
+	// with MaxStaleness. Optional.
+	Replicas []string `json:"replicas,omitempty"`
+}
+
+// has returns true if the server's range has rel, a path within the cluster without its leading
+// '/'.
+func (s Server) has(rel string) bool {
+	return rel >= s.StartPrefix && (s.EndPrefix == "" || rel < s.EndPrefix)
+}
+
+// spans returns true if the server's range has rel or anything under it. Dirs above a boundary
+// (i.e., projects for projects/m) span the servers on both sides.
+func (s Server) spans(rel string) bool {
+	if s.has(rel) {
+		return true
+	}
+	// Paths under rel are in [rel/, rel0), since '0' follows '/'.
+	return s.StartPrefix < rel+"0" && (s.EndPrefix == "" || s.EndPrefix > rel+"/")
+}
+
+// Cluster is a set of servers mounted at a virtual root of the client's namespace.
+type Cluster struct {
+	// Root is the absolute path the cluster is mounted at (i.e., /clusterA). It must be a single
+	// directory under '/'.
+	Root string `json:"root"`
+
+	Servers []Server `json:"servers"`
 }
 
 type Opts struct {
 	Servers []Server
+
+	// Clusters lets a single client span multiple clusters. Operations are routed by the cluster
+	// root first and then by the servers' prefixes. Can't be used with Servers.
+	Clusters []Cluster
+
+	// PartialResults makes operations spanning multiple servers (i.e., ListDir) return what the
+	// reachable servers returned along with a *MultiError, instead of failing altogether.
+	PartialResults bool
+
+	// Keepalive pings idle connections so that long-idle sessions behind NATs or load balancers
+	// don't silently lose them. Defaults to gRPC's defaults.
+	Keepalive KeepaliveOpts
+
+	// Breaker fails requests to a server fast after it failed too many times in a row. Disabled by
+	// default.
+	Breaker BreakerOpts
+
+	// AllowGaps lets the ranges of the servers of a cluster leave prefixes between them that no
+	// server serves. Paths under them fail to route. By default, New rejects such configs.
+	AllowGaps bool
+
+	// HedgeDelay sends reads (ReadFile and ListDir) to a server's replicas too if it hasn't
+	// responded after this long, one more replica every HedgeDelay, and takes the first response.
+	// Only reads allowing stale results (see MaxStaleness) are hedged, since replicas may be
+	// behind. 0 disables hedging.
+	HedgeDelay time.Duration
+
+	// WriteHeartbeat sends an empty payload on uploads nothing was sent on for this long (i.e.,
+	// while reading slowly from the source), so that proxies killing idle-looking streams and
+	// servers' idle write timeouts (see server.Opts.WriteIdleTimeout) don't fail slow uploads.
+	// Servers ignore the empty payloads. 0 disables heartbeats.
+	WriteHeartbeat time.Duration
+
+	// Checksums sends the CRC-32C of each chunk of uploads and asks servers for the ones of
+	// downloads, so that the receiving side catches chunks corrupted on the way (i.e., by a
+	// misbehaving proxy) and fails with ErrChecksum. Off by default, for trusted links.
+	Checksums bool
+}
+
+// KeepaliveOpts configure pinging idle server connections. Zero values use gRPC's defaults.
+type KeepaliveOpts struct {
+	// Time is how long a connection can be idle before the client pings it. It must not be less
+	// than the servers' minimum ping interval.
+	Time time.Duration
+
+	// Timeout is how long the client waits for a ping ack before closing the connection.
+	Timeout time.Duration
+
+	// PermitWithoutStream pings even when there are no active streams.
+	PermitWithoutStream bool
 }
 
 type Client struct {
-	servers []Server
+	clusters       []Cluster
+	keepalive      KeepaliveOpts
+	breaker        BreakerOpts
+	hedgeDelay     time.Duration
+	writeHeartbeat time.Duration
+	checksums      bool
+	partialResults bool
+	// nextReplica is the replica the next stale read goes to. It's accessed atomically.
+	nextReplica uint32
+	metrics     *Metrics
+	calls       callTracker
+
+	// dialMu serializes Dial and Close.
+	dialMu sync.Mutex
 
 	mu      sync.RWMutex
 	clients map[string]pb_filesystem.FileSeverClient
 	conns   map[string]*grpc.ClientConn
+	// infos are the infos servers reported when dialed, by address.
+	infos map[string]*pb_filesystem.ServerInfo
+	// missing are the features servers turned out not to have when called, by address.
+	missing map[string]map[string]bool
 }
 
+// New returns a client of the servers or clusters in opts, which must be valid (see ConfigError).
+// It doesn't connect to them until Dial.
 func New(opts Opts) (*Client, error) {
-	// TODO: validate prefixes and stuff
-	return &Client{servers: opts.Servers}, nil
+	if err := validate(opts); err != nil {
+		return nil, err
+	}
+	clusters := opts.Clusters
+	if len(clusters) == 0 {
+		clusters = []Cluster{{Root: fspath.Root, Servers: opts.Servers}}
+	}
+	return &Client{
+		clusters:       clusters,
+		keepalive:      opts.Keepalive,
+		breaker:        opts.Breaker,
+		hedgeDelay:     opts.HedgeDelay,
+		writeHeartbeat: opts.WriteHeartbeat,
+		checksums:      opts.Checksums,
+		partialResults: opts.PartialResults,
+		metrics:        newMetrics(),
+	}, nil
 }
 
-// Dial connects to all server. TODO: Make this lazy and also have it dial
-// upon disconnects.
+// Dial connects to all server. Dialing a connected client does nothing, while a closed one is
+// dialed again. TODO: Make this lazy and also have it dial upon disconnects.
 func (c *Client) Dial(ctx context.Context) error {
+	c.dialMu.Lock()
+	defer c.dialMu.Unlock()
+	c.mu.RLock()
+	dialed := c.conns != nil
+	c.mu.RUnlock()
+	if dialed {
+		return nil
+	}
+
 	// Dial all servers. TODO: Not do that and do it lazily.
 	conns := make(map[string]*grpc.ClientConn)
 	clients := make(map[string]pb_filesystem.FileSeverClient)
@@ -53,248 +193,1150 @@ func (c *Client) Dial(ctx context.Context) error {
 		}
 	}()
 
-	for _, server := range c.servers {
-		conn, err := grpc.DialContext(ctx, server.Addr, grpc.WithInsecure())
-		if err != nil {
-			return err
+	opts := []grpc.DialOption{grpc.WithInsecure()}
+	// gRPC turns zero values into a 10s ping interval, so only set them if asked to.
+	if c.keepalive != (KeepaliveOpts{}) {
+		opts = append(opts, grpc.WithKeepaliveParams(keepalive.ClientParameters{
+			Time:                c.keepalive.Time,
+			Timeout:             c.keepalive.Timeout,
+			PermitWithoutStream: c.keepalive.PermitWithoutStream,
+		}))
+	}
+	for _, cluster := range c.clusters {
+		for _, server := range cluster.Servers {
+			conn, err := c.dialServer(ctx, server, c.interceptors(opts, server.Addr))
+			if err != nil {
+				return err
+			}
+			conns[server.Addr] = conn
+			clients[server.Addr] = pb_filesystem.NewFileSeverClient(conn)
+			for _, replica := range server.Replicas {
+				conn, err := grpc.DialContext(ctx, replica, c.interceptors(opts, replica)...)
+				if err != nil {
+					return err
+				}
+				conns[replica] = conn
+				clients[replica] = pb_filesystem.NewFileSeverClient(conn)
+			}
 		}
-		conns[server.Addr] = conn
-		clients[server.Addr] = pb_filesystem.NewFileSeverClient(conn)
-
 	}
 
+	infos := queryInfos(ctx, clients)
+
 	// Don't cleanup
 	c.mu.Lock()
 	c.conns = conns
 	c.clients = clients
+	c.infos = infos
 	c.mu.Unlock()
 	conns = nil
 	return nil
 }
 
-func (c *Client) clientsForPath(path string) ([]pb_filesystem.FileSeverClient, error) {
-	// TODO: optimize this. We should do some sort of binary search/b-tree
-	servers := make([]string, 0)
-	for _, server := range c.servers {
-		if !fs.IsAbs(path) {
-			return nil, fmt.Errorf("path must be absolute")
+// drainTimeout bounds how long Close waits for calls in flight.
+const drainTimeout = 5 * time.Second
+
+// Close waits up to drainTimeout for the calls in flight and closes the connections to the
+// servers. Calls still running then (i.e., streams nobody reads) fail with codes.Canceled, as do
+// later calls until the client is dialed again. Closing a closed client does nothing.
+func (c *Client) Close() error {
+	c.dialMu.Lock()
+	defer c.dialMu.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), drainTimeout)
+	defer cancel()
+	if err := c.calls.wait(ctx); err != nil {
+		glog.Warningf("Closing the client with calls in flight. %s\n", err)
+	}
+	c.mu.Lock()
+	conns := c.conns
+	c.conns = nil
+	c.mu.Unlock()
+	var firstErr error
+	for _, conn := range conns {
+		if err := conn.Close(); err != nil && firstErr == nil {
+			firstErr = err
 		}
-		// TODO: support longer prefixes
-		if path == fs.SeperatorStr || path[1] >= server.StartPrefix[0] && path[1] < server.EndPrefix[0] {
-			servers = append(servers, server.Addr)
+	}
+	return firstErr
+}
+
+// callTracker counts the calls in flight, so that Close can wait for them.
+type callTracker struct {
+	mu sync.Mutex
+	n  int
+	// idle is closed once n drops to zero.
+	idle chan struct{}
+}
+
+func (t *callTracker) start() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.n == 0 {
+		t.idle = make(chan struct{})
+	}
+	t.n++
+}
+
+func (t *callTracker) done() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.n--
+	if t.n == 0 {
+		close(t.idle)
+	}
+}
+
+// wait waits until no calls are in flight or ctx is done.
+func (t *callTracker) wait(ctx context.Context) error {
+	t.mu.Lock()
+	if t.n == 0 {
+		t.mu.Unlock()
+		return nil
+	}
+	idle := t.idle
+	t.mu.Unlock()
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (t *callTracker) unaryInterceptor(ctx context.Context, method string, req, reply interface{},
+	cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	t.start()
+	defer t.done()
+	return invoker(ctx, method, req, reply, cc, opts...)
+}
+
+// streamInterceptor counts streams until they end, which cancels their context, be it by reading
+// them to the end, failing or canceling the context they were started with.
+func (t *callTracker) streamInterceptor(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn,
+	method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	t.start()
+	stream, err := streamer(ctx, desc, cc, method, opts...)
+	if err != nil {
+		t.done()
+		return nil, err
+	}
+	go func() {
+		<-stream.Context().Done()
+		t.done()
+	}()
+	return stream, nil
+}
+
+// interceptors returns opts with the metrics and the breaker (if enabled) of the server at addr.
+func (c *Client) interceptors(opts []grpc.DialOption, addr string) []grpc.DialOption {
+	unary := []grpc.UnaryClientInterceptor{c.calls.unaryInterceptor, c.metrics.unaryInterceptor}
+	stream := []grpc.StreamClientInterceptor{c.calls.streamInterceptor, c.metrics.streamInterceptor}
+	if c.breaker.Failures > 0 {
+		b := newBreaker(addr, c.breaker)
+		unary = append(unary, b.unaryInterceptor)
+		stream = append(stream, b.streamInterceptor)
+	}
+	return append(opts[:len(opts):len(opts)],
+		grpc.WithChainUnaryInterceptor(unary...), grpc.WithChainStreamInterceptor(stream...))
+}
+
+// Metrics returns the metrics of the RPCs made by the client.
+func (c *Client) Metrics() *Metrics {
+	return c.metrics
+}
+
+// dialTimeout bounds how long an address of a server with multiple addresses is tried before
+// moving on to the next one.
+const dialTimeout = 5 * time.Second
+
+// dialServer connects to the first reachable address of server. Servers with a single address are
+// dialed lazily like before.
+func (c *Client) dialServer(ctx context.Context, server Server, opts []grpc.DialOption) (*grpc.ClientConn, error) {
+	if len(server.Addrs) == 0 {
+		return grpc.DialContext(ctx, server.Addr, opts...)
+	}
+	opts = append(opts, grpc.WithBlock())
+	var lastErr error
+	for i, addr := range append([]string{server.Addr}, server.Addrs...) {
+		if i > 0 {
+			c.metrics.retried()
+		}
+		dialCtx, cancel := context.WithTimeout(ctx, dialTimeout)
+		conn, err := grpc.DialContext(dialCtx, addr, opts...)
+		cancel()
+		if err == nil {
+			return conn, nil
+		}
+		glog.Warningf("Failed to dial %s. %s\n", addr, err)
+		lastErr = err
+	}
+	return nil, fmt.Errorf("failed to dial any address of %s. %w", server.Addr, lastErr)
+}
+
+// clusterForPath returns the cluster that path is under and the path within the cluster.
+func (c *Client) clusterForPath(path string) (Cluster, string, error) {
+	if !fspath.IsAbs(path) {
+		return Cluster{}, "", fmt.Errorf("path must be absolute")
+	}
+	for _, cluster := range c.clusters {
+		if rel, ok := fspath.TrimPrefix(path, cluster.Root); ok {
+			return cluster, rel, nil
+		}
+	}
+	return Cluster{}, "", fmt.Errorf("%s isn't under any cluster", path)
+}
+
+// virtualRoot returns true if path is the client's root and it only has clusters under it.
+func (c *Client) virtualRoot(path string) bool {
+	return path == fspath.Root && c.clusters[0].Root != fspath.Root
+}
+
+// shard is a server that a path is routed to.
+type shard struct {
+	addr     string
+	client   pb_filesystem.FileSeverClient
+	replicas []pb_filesystem.FileSeverClient
+	// replicaAddrs are the addresses of replicas, in the same order.
+	replicaAddrs []string
+}
+
+// serversForPath returns the servers of cluster that path (within the cluster) is routed to: the
+// one whose range has path, along with the others whose ranges are under it if it's a dir above
+// a boundary. The root spans all of them.
+func serversForPath(cluster Cluster, path string) []Server {
+	// TODO: optimize this. We should do some sort of binary search/b-tree
+	servers := make([]Server, 0)
+	for _, server := range cluster.Servers {
+		if path == fspath.Root || server.spans(path[1:]) {
+			servers = append(servers, server)
 		}
 	}
-	clients := make([]pb_filesystem.FileSeverClient, 0, len(servers))
+	return servers
+}
+
+// shardsForPath returns the servers that path is routed to and the path within their cluster.
+func (c *Client) shardsForPath(path string) ([]shard, string, error) {
+	cluster, path, err := c.clusterForPath(path)
+	if err != nil {
+		return nil, "", err
+	}
+	servers := serversForPath(cluster, path)
+	shards := make([]shard, 0, len(servers))
 	c.mu.RLock()
-	for _, addr := range servers {
-		clients = append(clients, c.clients[addr])
+	for _, server := range servers {
+		s := shard{addr: server.Addr, client: c.clients[server.Addr]}
+		for _, replica := range server.Replicas {
+			s.replicas = append(s.replicas, c.clients[replica])
+			s.replicaAddrs = append(s.replicaAddrs, replica)
+		}
+		shards = append(shards, s)
 	}
 	c.mu.RUnlock()
-	return clients, nil
+	return shards, path, nil
+}
+
+// ownerFirst moves the shard whose range has path (if any) to the front of shards.
+func ownerFirst(cluster Cluster, shards []shard, path string) []shard {
+	for _, server := range cluster.Servers {
+		if !server.has(path[1:]) {
+			continue
+		}
+		for i, s := range shards {
+			if s.addr == server.Addr {
+				shards[0], shards[i] = shards[i], shards[0]
+			}
+		}
+	}
+	return shards
 }
 
-func (c *Client) ListDir(ctx context.Context, path string) ([]*pb_filesystem.File, []*pb_filesystem.Dir, error) {
-	clients, err := c.clientsForPath(path)
+// shardForPath returns the server owning path and the path within its cluster, for operations
+// done on a single server. Paths are owned by the server whose range has them. The root and dirs
+// above a boundary are on every server they span instead (see MakeDir), so op fails with
+// fs.ErrInvalidName for them.
+func (c *Client) shardForPath(op, path string) (shard, string, error) {
+	shards, rel, err := c.shardsForPath(path)
 	if err != nil {
-		return nil, nil, err
+		return shard{}, "", err
 	}
+	switch {
+	case len(shards) == 0:
+		return shard{}, "", &fs.PathError{Op: op, Path: path, Err: ErrNoServer}
+	case len(shards) > 1 || rel == fspath.Root:
+		return shard{}, "", &fs.PathError{Op: op, Path: path, Err: errSpans}
+	}
+	return shards[0], rel, nil
+}
 
-	// guarantee that the channels won't block.
-	// TODO: optimize this and support cancelation upon first error.
-	filesCh := make(chan []*pb_filesystem.File, len(clients))
-	dirsCh := make(chan []*pb_filesystem.Dir, len(clients))
-	errCh := make(chan error, len(clients))
-	combinedFiles := make([]*pb_filesystem.File, 0)
-	combinedDirs := make([]*pb_filesystem.Dir, 0)
+// spanningOp does op on every server path spans: the root (which already exists everywhere, so
+// making it fails with fs.ErrAlreadyExist like it does locally) and dirs above a boundary, which
+// exist on each server with entries under them. Other paths are done on their single owner.
+func (c *Client) spanningOp(ctx context.Context, op, path string, fn func(context.Context, pb_filesystem.FileSeverClient, string) error) error {
+	shards, rel, err := c.shardsForPath(path)
+	if err != nil {
+		return err
+	}
+	switch {
+	case rel == fspath.Root && op == "mkdir":
+		return &fs.PathError{Op: op, Path: path, Err: fs.ErrAlreadyExist}
+	case rel == fspath.Root:
+		return &fs.PathError{Op: op, Path: path, Err: errSpans}
+	case len(shards) == 0:
+		return &fs.PathError{Op: op, Path: path, Err: ErrNoServer}
+	case len(shards) == 1:
+		return fromStatus(fn(ctx, shards[0].client, rel))
+	}
+	if me := fanOut(ctx, shards, false, func(ctx context.Context, s shard) error {
+		return fromStatus(fn(ctx, s.client, rel))
+	}); me != nil {
+		return me
+	}
+	return nil
+}
+
+// clientsForPath returns the clients of the servers that path is routed to and the path within
+// their cluster.
+func (c *Client) clientsForPath(path string) ([]pb_filesystem.FileSeverClient, string, error) {
+	shards, path, err := c.shardsForPath(path)
+	if err != nil {
+		return nil, "", err
+	}
+	clients := make([]pb_filesystem.FileSeverClient, 0, len(shards))
+	for _, s := range shards {
+		clients = append(clients, s.client)
+	}
+	return clients, path, nil
+}
+
+// joinRoot returns the client's path for the path within a cluster mounted at root.
+func joinRoot(root, path string) string {
+	return fspath.Join(root, path)
+}
+
+// fanOut calls fn for every shard in parallel and reports the ones that failed. With cancelOnError,
+// the first failure cancels the outstanding calls. Returns nil if all succeeded.
+func fanOut(ctx context.Context, shards []shard, cancelOnError bool, fn func(context.Context, shard) error) *MultiError {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var mu sync.Mutex
+	var me MultiError
 	var wg sync.WaitGroup
-	for _, client := range clients {
-		client := client
+	for _, s := range shards {
+		s := s
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			out, err := client.ListDir(ctx, &pb_filesystem.Path{Path: path})
-			if err != nil {
-				select {
-				case errCh <- err:
-				default:
+			err := fn(ctx, s)
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case err == nil:
+				me.Succeeded = append(me.Succeeded, s.addr)
+			case cancelOnError && len(me.Failed) > 0 && canceled(err):
+				me.Canceled = append(me.Canceled, s.addr)
+			default:
+				me.Failed = append(me.Failed, &ShardError{Addr: s.addr, Err: err})
+				if cancelOnError {
+					cancel()
 				}
-				return
 			}
-			filesCh <- out.Files
-			dirsCh <- out.Dirs
 		}()
 	}
-
 	wg.Wait()
-	// We guaranteed that channels won't block
-	close(errCh)
-	close(filesCh)
-	close(dirsCh)
-	for err := range errCh {
-		if err != nil {
-			return nil, nil, err
+	if len(me.Failed) == 0 {
+		return nil
+	}
+	return &me
+}
+
+// ListDir lists path on all the servers it spans. If any of them fails, the error is a
+// *MultiError, and the results of the others are returned too with Opts.PartialResults.
+func (c *Client) ListDir(ctx context.Context, path string, opts ...CallOption) ([]*pb_filesystem.File, []*pb_filesystem.Dir, error) {
+	o := newCallOpts(opts)
+	if c.virtualRoot(path) {
+		dirs := make([]*pb_filesystem.Dir, 0, len(c.clusters))
+		for _, cluster := range c.clusters {
+			dirs = append(dirs, &pb_filesystem.Dir{Name: cluster.Root[1:], Path: cluster.Root})
 		}
+		return []*pb_filesystem.File{}, dirs, nil
 	}
-	for files := range filesCh {
-		combinedFiles = append(combinedFiles, files...)
+	cluster, _, err := c.clusterForPath(path)
+	if err != nil {
+		return nil, nil, err
 	}
-	for dirs := range dirsCh {
-		combinedDirs = append(combinedDirs, dirs...)
+	shards, path, err := c.shardsForPath(path)
+	if err != nil {
+		return nil, nil, err
 	}
 
+	var mu sync.Mutex
+	combinedFiles := make([]*pb_filesystem.File, 0)
+	combinedDirs := make([]*pb_filesystem.Dir, 0)
+	me := fanOut(ctx, shards, !c.partialResults, func(ctx context.Context, s shard) error {
+		v, done, err := c.read(ctx, s, o, func(ctx context.Context, client pb_filesystem.FileSeverClient) (interface{}, error) {
+			return client.ListDir(ctx, o.path(path))
+		})
+		if err != nil {
+			return fromStatus(err)
+		}
+		done()
+		out := v.(*pb_filesystem.ListResponse)
+		mu.Lock()
+		combinedFiles = append(combinedFiles, out.Files...)
+		combinedDirs = append(combinedDirs, out.Dirs...)
+		mu.Unlock()
+		return nil
+	})
+	if me != nil && !c.partialResults {
+		return nil, nil, me
+	}
+	for _, f := range combinedFiles {
+		f.Path = joinRoot(cluster.Root, f.Path)
+	}
+	// Dirs above a boundary are on every server they span.
+	seen := make(map[string]bool, len(combinedDirs))
+	n := 0
+	for _, d := range combinedDirs {
+		if seen[d.Name] {
+			continue
+		}
+		seen[d.Name] = true
+		d.Path = joinRoot(cluster.Root, d.Path)
+		combinedDirs[n] = d
+		n++
+	}
+	combinedDirs = combinedDirs[:n]
+	if me != nil {
+		return combinedFiles, combinedDirs, me
+	}
 	return combinedFiles, combinedDirs, nil
 }
-func (c *Client) MakeDir(ctx context.Context, path string) error {
-	clients, err := c.clientsForPath(path)
+
+// ListEntries lists path on all the servers it spans, ordered by name. Failures are reported like
+// ListDir's.
+func (c *Client) ListEntries(ctx context.Context, path string, opts ...CallOption) ([]*pb_filesystem.Entry, error) {
+	o := newCallOpts(opts)
+	if c.virtualRoot(path) {
+		entries := make([]*pb_filesystem.Entry, 0, len(c.clusters))
+		for _, cluster := range c.clusters {
+			dir := &pb_filesystem.Dir{Name: cluster.Root[1:], Path: cluster.Root}
+			entries = append(entries, &pb_filesystem.Entry{
+				Name: dir.Name, Path: dir.Path, Node: &pb_filesystem.Entry_Dir{Dir: dir},
+			})
+		}
+		return mergeEntries([][]*pb_filesystem.Entry{entries}), nil
+	}
+	cluster, _, err := c.clusterForPath(path)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	shards, path, err := c.shardsForPath(path)
+	if err != nil {
+		return nil, err
 	}
 
-	// We must have a single server.
-	if len(clients) != 1 {
-		return fmt.Errorf("must have a single server per path")
+	var mu sync.Mutex
+	lists := make([][]*pb_filesystem.Entry, 0, len(shards))
+	me := fanOut(ctx, shards, !c.partialResults, func(ctx context.Context, s shard) error {
+		if !c.supports(s.addr, FeatureListEntries) {
+			list, err := c.listDirEntries(ctx, s, o, path)
+			if err != nil {
+				return err
+			}
+			mu.Lock()
+			lists = append(lists, list)
+			mu.Unlock()
+			return nil
+		}
+		v, done, err := c.read(ctx, s, o, func(ctx context.Context, client pb_filesystem.FileSeverClient) (interface{}, error) {
+			return client.ListEntries(ctx, o.path(path))
+		})
+		if missingRPC(err) {
+			c.unsupported(s.addr, FeatureListEntries)
+			list, err := c.listDirEntries(ctx, s, o, path)
+			if err != nil {
+				return err
+			}
+			mu.Lock()
+			lists = append(lists, list)
+			mu.Unlock()
+			return nil
+		}
+		if err != nil {
+			return fromStatus(err)
+		}
+		done()
+		mu.Lock()
+		lists = append(lists, v.(*pb_filesystem.EntryList).Entries)
+		mu.Unlock()
+		return nil
+	})
+	if me != nil && !c.partialResults {
+		return nil, me
 	}
+	for _, list := range lists {
+		for _, e := range list {
+			e.Path = joinRoot(cluster.Root, e.Path)
+			switch node := e.Node.(type) {
+			case *pb_filesystem.Entry_File:
+				node.File.Path = e.Path
+			case *pb_filesystem.Entry_Dir:
+				node.Dir.Path = e.Path
+			case *pb_filesystem.Entry_Symlink:
+				node.Symlink.Path = e.Path
+			}
+		}
+	}
+	entries := mergeEntries(lists)
+	if me != nil {
+		return entries, me
+	}
+	return entries, nil
+}
 
-	if _, err := clients[0].MakeDir(ctx, &pb_filesystem.Path{Path: path}); err != nil {
-		return err
+// listDirEntries lists path on a server that doesn't support ListEntries, ordered by name.
+func (c *Client) listDirEntries(ctx context.Context, s shard, o callOpts, path string) ([]*pb_filesystem.Entry, error) {
+	v, done, err := c.read(ctx, s, o, func(ctx context.Context, client pb_filesystem.FileSeverClient) (interface{}, error) {
+		return client.ListDir(ctx, o.path(path))
+	})
+	if err != nil {
+		return nil, fromStatus(err)
 	}
-	return nil
+	done()
+	out := v.(*pb_filesystem.ListResponse)
+	entries := make([]*pb_filesystem.Entry, 0, len(out.Files)+len(out.Dirs))
+	for _, f := range out.Files {
+		entries = append(entries, &pb_filesystem.Entry{
+			Name: f.Name, Path: f.Path, Size: f.Size, Node: &pb_filesystem.Entry_File{File: f},
+		})
+	}
+	for _, d := range out.Dirs {
+		entries = append(entries, &pb_filesystem.Entry{
+			Name: d.Name, Path: d.Path, Size: d.Size, Node: &pb_filesystem.Entry_Dir{Dir: d},
+		})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+	return entries, nil
 }
-func (c *Client) Remove(ctx context.Context, path string) error {
-	clients, err := c.clientsForPath(path)
+
+// mergeEntries merges lists that are each ordered by name into a single ordered list, where names
+// on several lists are listed once.
+func mergeEntries(lists [][]*pb_filesystem.Entry) []*pb_filesystem.Entry {
+	total := 0
+	for _, list := range lists {
+		total += len(list)
+	}
+	merged := make([]*pb_filesystem.Entry, 0, total)
+	next := make([]int, len(lists))
+	for total > 0 {
+		min := -1
+		for i, list := range lists {
+			if next[i] < len(list) && (min == -1 || list[next[i]].Name < lists[min][next[min]].Name) {
+				min = i
+			}
+		}
+		// Dirs above a boundary are on every server they span.
+		if e := lists[min][next[min]]; len(merged) == 0 || merged[len(merged)-1].Name != e.Name {
+			merged = append(merged, e)
+		}
+		next[min]++
+		total--
+	}
+	return merged
+}
+
+// Stat returns the file or the dir at path, whichever exists.
+func (c *Client) Stat(ctx context.Context, path string) (*pb_filesystem.File, *pb_filesystem.Dir, error) {
+	if c.virtualRoot(path) {
+		return nil, &pb_filesystem.Dir{Name: "", Path: fspath.Root}, nil
+	}
+	cluster, _, err := c.clusterForPath(path)
 	if err != nil {
-		return err
+		return nil, nil, err
+	}
+	shards, path, err := c.shardsForPath(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	if path == fspath.Root {
+		return nil, &pb_filesystem.Dir{Name: fspath.Base(path), Path: joinRoot(cluster.Root, path)}, nil
+	}
+	if len(shards) == 0 {
+		return nil, nil, &fs.PathError{Op: "stat", Path: joinRoot(cluster.Root, path), Err: ErrNoServer}
+	}
+	// Dirs above a boundary are stat'ed on the server whose range has them.
+	if len(shards) > 1 {
+		shards = ownerFirst(cluster, shards, path)
 	}
 
-	// We must have a single server.
-	if len(clients) != 1 {
-		return fmt.Errorf("must have a single server per path")
+	var out *pb_filesystem.StatResponse
+	if c.supports(shards[0].addr, FeatureStat) {
+		out, err = shards[0].client.Stat(ctx, &pb_filesystem.Path{Path: path})
+		if missingRPC(err) {
+			c.unsupported(shards[0].addr, FeatureStat)
+		} else if err != nil {
+			return nil, nil, fromStatus(err)
+		}
+	}
+	if out == nil {
+		file, dir, err := c.statFromList(ctx, shards[0], path)
+		if err != nil {
+			return nil, nil, err
+		}
+		out = &pb_filesystem.StatResponse{File: file, Dir: dir}
 	}
+	if out.File != nil {
+		out.File.Path = joinRoot(cluster.Root, out.File.Path)
+		return out.File, nil, nil
+	}
+	out.Dir.Path = joinRoot(cluster.Root, out.Dir.Path)
+	return nil, out.Dir, nil
+}
 
-	if _, err := clients[0].Remove(ctx, &pb_filesystem.Path{Path: path}); err != nil {
+// MakeDir makes the dir at path on the server owning it, or on every server it spans if it's
+// above a boundary. Options other than Parents are ignored.
+func (c *Client) MakeDir(ctx context.Context, path string, opts ...CallOption) error {
+	o := newCallOpts(opts)
+	if o.parents {
+		shards, _, err := c.shardsForPath(path)
+		if err != nil {
+			return err
+		}
+		for _, s := range shards {
+			if !c.supports(s.addr, FeatureParents) {
+				return &CapabilityError{Addr: s.addr, Feature: FeatureParents}
+			}
+		}
+	}
+	return c.spanningOp(ctx, "mkdir", path, func(ctx context.Context, client pb_filesystem.FileSeverClient, path string) error {
+		_, err := client.MakeDir(ctx, &pb_filesystem.Path{Path: path, Parents: o.parents})
+		return err
+	})
+}
+
+// Remove removes the file or empty dir at path, from every server it spans if it's a dir above a
+// boundary.
+func (c *Client) Remove(ctx context.Context, path string) error {
+	return c.spanningOp(ctx, "remove", path, func(ctx context.Context, client pb_filesystem.FileSeverClient, path string) error {
+		_, err := client.Remove(ctx, &pb_filesystem.Path{Path: path})
+		return err
+	})
+}
+
+// TouchFile creates the file at path if it doesn't exist. Otherwise, it updates its modification
+// time.
+func (c *Client) TouchFile(ctx context.Context, path string) error {
+	s, path, err := c.shardForPath("touch", path)
+	if err != nil {
 		return err
 	}
+
+	if !c.supports(s.addr, FeatureTouch) {
+		return &CapabilityError{Addr: s.addr, Feature: FeatureTouch}
+	}
+	if _, err := s.client.TouchFile(ctx, &pb_filesystem.Path{Path: path}); err != nil {
+		if missingRPC(err) {
+			return c.unsupported(s.addr, FeatureTouch)
+		}
+		return fromStatus(err)
+	}
 	return nil
 }
 
-func (c *Client) CreateFile(ctx context.Context, path string) error {
-	clients, err := c.clientsForPath(path)
+// DeletePrefix removes path and everything under it on all the servers it spans and returns the
+// number of removed files/dirs. With dryRun, nothing is removed and the count is what would be.
+// If any of the servers fails, the others still remove theirs and the error is a *MultiError.
+func (c *Client) DeletePrefix(ctx context.Context, path string, dryRun bool) (int, error) {
+	if c.virtualRoot(path) {
+		total := 0
+		for _, cluster := range c.clusters {
+			n, err := c.DeletePrefix(ctx, cluster.Root, dryRun)
+			if err != nil {
+				return total, err
+			}
+			total += n
+		}
+		return total, nil
+	}
+	shards, path, err := c.shardsForPath(path)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
-	// We must have a single server.
-	if len(clients) != 1 {
-		return fmt.Errorf("must have a single server per path")
+	// Not canceled upon the first error, so that the other servers aren't left half-deleted.
+	var mu sync.Mutex
+	total := 0
+	req := &pb_filesystem.DeletePrefixRequest{Path: path, DryRun: dryRun}
+	me := fanOut(ctx, shards, false, func(ctx context.Context, s shard) error {
+		if !c.supports(s.addr, FeatureDeletePrefix) {
+			return &CapabilityError{Addr: s.addr, Feature: FeatureDeletePrefix}
+		}
+		out, err := s.client.DeletePrefix(ctx, req)
+		if missingRPC(err) {
+			return c.unsupported(s.addr, FeatureDeletePrefix)
+		}
+		if err != nil {
+			return fromStatus(err)
+		}
+		mu.Lock()
+		total += int(out.Deleted)
+		mu.Unlock()
+		return nil
+	})
+	if me != nil {
+		return total, me
 	}
+	return total, nil
+}
 
-	if _, err := clients[0].CreateFile(ctx, &pb_filesystem.Path{Path: path}); err != nil {
+// Parents makes CreateFile create the missing dirs above the file, MakeDir the ones above the dir,
+// and Write and WriteFile create the file along with them if it doesn't exist. They're created on
+// the servers holding the file or dir.
+func Parents() CallOption {
+	return func(o *callOpts) {
+		o.parents = true
+	}
+}
+
+// CreateFile creates an empty file at path. Options other than Parents are ignored.
+func (c *Client) CreateFile(ctx context.Context, path string, opts ...CallOption) error {
+	o := newCallOpts(opts)
+	s, path, err := c.shardForPath("create", path)
+	if err != nil {
 		return err
 	}
+
+	if o.parents && !c.supports(s.addr, FeatureParents) {
+		return &CapabilityError{Addr: s.addr, Feature: FeatureParents}
+	}
+	if _, err := s.client.CreateFile(ctx, &pb_filesystem.Path{Path: path, Parents: o.parents}); err != nil {
+		return fromStatus(err)
+	}
 	return nil
 }
 
-func (c *Client) ReadFile(ctx context.Context, local, remote string) error {
-	clients, err := c.clientsForPath(remote)
+// ReadFile reads remote into the local file at local, replacing it, and returns the number of
+// bytes read. It wraps Read, which streams to any io.Writer (i.e., a buffer or an HTTP response).
+func (c *Client) ReadFile(ctx context.Context, local, remote string, opts ...CallOption) (int64, error) {
+	// Read into a temp file next to local and only replace local once the whole file is read, so
+	// that a failed read doesn't destroy it.
+	tmp, err := os.CreateTemp(filepath.Dir(local), "."+filepath.Base(local)+".*.tmp")
 	if err != nil {
-		return err
+		return 0, fmt.Errorf("local path %s: %w", local, err)
+	}
+	defer os.Remove(tmp.Name())
+	mode := os.FileMode(0644)
+	if fi, err := os.Stat(local); err == nil {
+		mode = fi.Mode().Perm()
+	}
+	if err := tmp.Chmod(mode); err != nil {
+		tmp.Close()
+		return 0, fmt.Errorf("local path %s: %w", local, err)
 	}
 
-	f, err := os.Create(local)
+	n, err := c.Read(ctx, remote, tmp, opts...)
 	if err != nil {
-		return err
+		tmp.Close()
+		return n, err
+	}
+	if err := tmp.Close(); err != nil {
+		return n, fmt.Errorf("local path %s: %w", local, err)
+	}
+	if err := os.Rename(tmp.Name(), local); err != nil {
+		return n, fmt.Errorf("local path %s: %w", local, err)
+	}
+	return n, nil
+}
+
+// Read streams the content of remote to writer and returns the number of bytes read.
+func (c *Client) Read(ctx context.Context, remote string, writer io.Writer, opts ...CallOption) (int64, error) {
+	o := newCallOpts(opts)
+	s, remote, err := c.shardForPath("read", remote)
+	if err != nil {
+		return 0, err
 	}
-	defer f.Close()
 
-	// We must have a single server.
-	if len(clients) != 1 {
-		return fmt.Errorf("must have a single server per path")
+	// The first payload tells which replica responded first, and if a replica is too stale.
+	v, done, err := c.read(ctx, s, o, func(ctx context.Context, client pb_filesystem.FileSeverClient) (interface{}, error) {
+		req := o.path(remote)
+		req.Crc32c = c.checksums
+		stream, err := client.ReadFile(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+		first, err := stream.Recv()
+		if err == io.EOF {
+			return &streamReader{stream: stream, eof: true}, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err := c.checkPayload(first); err != nil {
+			return nil, err
+		}
+		r := &streamReader{stream: stream, buf: first.GetData(), check: c.checkPayload}
+		r.tally.add(first.GetData())
+		return r, nil
+	})
+	if err != nil {
+		return 0, fromStatus(err)
 	}
+	defer done()
 
-	client, err := clients[0].ReadFile(ctx, &pb_filesystem.Path{Path: remote})
+	r := v.(*streamReader)
+	n, err := io.Copy(o.withProgress(writer), r)
 	if err != nil {
-		return err
+		return n, fromStatus(err)
 	}
+	return n, o.confirm(s.addr, remote, r.stream.Trailer(), r.tally)
+}
 
-	reader := streamReader{stream: client}
-	if _, err := io.Copy(f, reader); err != nil {
+// Copy copies the remote file at src to a new remote file at dst without going through the local
+// disk. Files on the same server are copied by the server. Otherwise, the content is relayed
+// through the client.
+func (c *Client) Copy(ctx context.Context, src, dst string) error {
+	srcShard, srcPath, err := c.shardForPath("copy", src)
+	if err != nil {
 		return err
 	}
-	return nil
-}
-func (c *Client) WriteFile(ctx context.Context, local, remote string) error {
-	clients, err := c.clientsForPath(remote)
+	dstShard, dstPath, err := c.shardForPath("copy", dst)
 	if err != nil {
 		return err
 	}
+	if srcShard.addr == dstShard.addr && c.supports(srcShard.addr, FeatureCopy) {
+		req := &pb_filesystem.CopyRequest{Src: srcPath, Dst: dstPath}
+		_, err := srcShard.client.Copy(ctx, req)
+		if !missingRPC(err) {
+			return fromStatus(err)
+		}
+		// Relayed below instead.
+		c.unsupported(srcShard.addr, FeatureCopy)
+	}
 
-	// We must have a single server.
-	if len(clients) != 1 {
-		return fmt.Errorf("must have a single server per path")
+	// Fail before creating dst if src can't be read.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	pr, pw := io.Pipe()
+	// Unblocks the reader when returning early, since canceling ctx doesn't while it writes to pw.
+	defer pr.Close()
+	go func() {
+		_, err := c.Read(ctx, src, pw)
+		pw.CloseWithError(err)
+	}()
+	first := make([]byte, 1)
+	n, err := io.ReadFull(pr, first)
+	if err != nil && err != io.EOF {
+		return err
+	}
+	if err := c.CreateFile(ctx, dst); err != nil {
+		return err
 	}
+	if _, err := c.Write(ctx, dst, io.MultiReader(bytes.NewReader(first[:n]), pr)); err != nil {
+		if err := c.Remove(ctx, dst); err != nil {
+			glog.Warningf("Failed to remove partial copy %s. %s\n", dst, err)
+		}
+		return err
+	}
+	return nil
+}
 
+// WriteFile appends the local file at local to remote and returns the number of bytes written. It
+// wraps Write, which streams from any io.Reader.
+func (c *Client) WriteFile(ctx context.Context, local, remote string, opts ...CallOption) (int64, error) {
 	f, err := os.Open(local)
 	if err != nil {
-		return err
+		return 0, fmt.Errorf("local path %s: %w", local, err)
 	}
 	defer f.Close()
 
-	client, err := clients[0].WriteFile(ctx)
+	return c.Write(ctx, remote, f, opts...)
+}
+
+// Write appends what's in reader until EOF to remote and returns the number of bytes written.
+// Options only applying to reads (i.e., MaxStaleness) are ignored. Failures the server reports are
+// filesystem errors (or a *StatusError), while a stream that broke is a *TransportError. Errors of
+// reader are returned as is, after canceling the upload.
+func (c *Client) Write(ctx context.Context, remote string, reader io.Reader, opts ...CallOption) (int64, error) {
+	o := newCallOpts(opts)
+	s, remote, err := c.shardForPath("write", remote)
 	if err != nil {
-		return err
+		return 0, err
+	}
+	if o.parents && !c.supports(s.addr, FeatureParents) {
+		return 0, &CapabilityError{Addr: s.addr, Feature: FeatureParents}
+	}
+
+	// Canceling the stream makes the server discard what it staged of an upload failing on this
+	// side.
+	streamCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	client, err := s.client.WriteFile(streamCtx)
+	if err != nil {
+		return 0, writeError(ctx, s.addr, false, err)
 	}
 
 	// Send the first message with the path
-	req := &pb_filesystem.FilePayload{Input: &pb_filesystem.FilePayload_Path{Path: remote}}
+	// Servers that don't check checksums aren't sent any.
+	sw := &streamWriter{stream: client, crc32c: c.checksums && c.supports(s.addr, FeatureCRC32C)}
+	req := &pb_filesystem.FilePayload{
+		Input:    &pb_filesystem.FilePayload_Path{Path: remote},
+		SizeHint: o.sizeOf(reader),
+		Parents:  o.parents,
+	}
 	if err := client.Send(req); err != nil {
-		client.CloseSend()
-		return err
+		return 0, closeWrite(ctx, s.addr, remote, client, err)
 	}
 
-	writer := streamWriter{stream: client}
-	if _, err := io.Copy(writer, f); err != nil {
-		return err
+	stopHeartbeat := sw.heartbeat(c.writeHeartbeat)
+	n, err := io.Copy(o.withProgress(sw), reader)
+	stopHeartbeat()
+	if err != nil && sw.err == nil {
+		cancel()
+		return n, err
+	}
+	// The server ending the stream early (i.e., the file got too large) fails sending, and its
+	// status says why.
+	if err := closeWrite(ctx, s.addr, remote, client, sw.err); err != nil {
+		return n, err
+	}
+	return n, o.confirm(s.addr, remote, client.Trailer(), sw.tally)
+}
+
+// closeWrite closes the upload of a write to the server at addr and returns how it ended. The
+// server's response is always received, since that's where a failed send learns its cause.
+// sendErr is the error that stopped sending, if any.
+func closeWrite(ctx context.Context, addr, path string, stream pb_filesystem.FileSever_WriteFileClient, sendErr error) error {
+	resp, err := stream.CloseAndRecv()
+	switch {
+	case err != nil:
+		return writeError(ctx, addr, sendErr == nil, err)
+	case resp.GetStatus() == pb_filesystem.Status_FAILURE:
+		return &StatusError{Addr: addr, Op: "write", Path: path, Reason: resp.GetReason()}
+	case sendErr != nil:
+		// The server can't have succeeded without the whole content.
+		return writeError(ctx, addr, false, sendErr)
 	}
+	return nil
+}
 
-	// Done.
-	if _, err := client.CloseAndRecv(); err != nil {
+// SetRetention sets the retention policy of the dir at path. Zero values disable the rules.
+func (c *Client) SetRetention(ctx context.Context, path string, maxAge time.Duration, maxFiles int) error {
+	s, path, err := c.shardForPath("set retention", path)
+	if err != nil {
 		return err
 	}
 
+	policy := &pb_filesystem.RetentionPolicy{
+		Path:          path,
+		MaxAgeSeconds: int64(maxAge / time.Second),
+		MaxFiles:      int64(maxFiles),
+	}
+	if _, err := s.client.SetRetention(ctx, policy); err != nil {
+		return fromStatus(err)
+	}
 	return nil
 }
 
+// ListRetention returns the retention policies of all dirs under path.
+func (c *Client) ListRetention(ctx context.Context, path string) ([]*pb_filesystem.RetentionPolicy, error) {
+	if c.virtualRoot(path) {
+		combined := make([]*pb_filesystem.RetentionPolicy, 0)
+		for _, cluster := range c.clusters {
+			policies, err := c.ListRetention(ctx, cluster.Root)
+			if err != nil {
+				return nil, err
+			}
+			combined = append(combined, policies...)
+		}
+		return combined, nil
+	}
+	cluster, _, err := c.clusterForPath(path)
+	if err != nil {
+		return nil, err
+	}
+	clients, path, err := c.clientsForPath(path)
+	if err != nil {
+		return nil, err
+	}
+
+	combined := make([]*pb_filesystem.RetentionPolicy, 0)
+	for _, client := range clients {
+		out, err := client.ListRetention(ctx, &pb_filesystem.Path{Path: path})
+		if err != nil {
+			return nil, fromStatus(err)
+		}
+		combined = append(combined, out.Policies...)
+	}
+	for _, policy := range combined {
+		policy.Path = joinRoot(cluster.Root, policy.Path)
+	}
+	return combined, nil
+}
+
+// FindFirstRegex returns up to max paths under path that match the regex, in lexicographic order.
+// Dirs end with a '/'. Failures are reported like ListDir's.
+func (c *Client) FindFirstRegex(ctx context.Context, path, regex string, max int) ([]string, error) {
+	if c.virtualRoot(path) {
+		combined := make([]string, 0)
+		for _, cluster := range c.clusters {
+			paths, err := c.FindFirstRegex(ctx, cluster.Root, regex, max)
+			if err != nil {
+				return nil, err
+			}
+			combined = append(combined, paths...)
+		}
+		sort.Strings(combined)
+		if len(combined) > max {
+			combined = combined[:max]
+		}
+		return combined, nil
+	}
+	cluster, _, err := c.clusterForPath(path)
+	if err != nil {
+		return nil, err
+	}
+	shards, path, err := c.shardsForPath(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var mu sync.Mutex
+	combined := make([]string, 0)
+	req := &pb_filesystem.RegexRequest{Path: path, Regex: regex, MaxResults: int64(max)}
+	me := fanOut(ctx, shards, !c.partialResults, func(ctx context.Context, s shard) error {
+		out, err := s.client.FindFirstRegex(ctx, req)
+		if err != nil {
+			return fromStatus(err)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		for _, p := range out.Paths {
+			// Not joined since that would drop the trailing '/' of dirs.
+			if cluster.Root != fspath.Root {
+				p = cluster.Root + p
+			}
+			combined = append(combined, p)
+		}
+		return nil
+	})
+	if me != nil && !c.partialResults {
+		return nil, me
+	}
+	sort.Strings(combined)
+	if len(combined) > max {
+		combined = combined[:max]
+	}
+	if me != nil {
+		return combined, me
+	}
+	return combined, nil
+}
+
+// streamWriter sends what's written to it as the data of a write. err keeps the error sending
+// failed with, telling it apart from errors reading what to send.
 type streamWriter struct {
 	stream pb_filesystem.FileSever_WriteFileClient
+	// crc32c sets the checksum of payloads.
+	crc32c bool
+
+	// mu serializes sending data and heartbeats.
+	mu    sync.Mutex
+	err   error
+	sent  time.Time
+	tally tally
 }
 
-func (sw streamWriter) Write(p []byte) (int, error) {
-	payload := &pb_filesystem.FilePayload{Input: &pb_filesystem.FilePayload_Data{Data: p}}
-	if err := sw.stream.Send(payload); err != nil {
+func (sw *streamWriter) Write(p []byte) (int, error) {
+	if err := sw.send(p); err != nil {
 		return 0, err
 	}
 	return len(p), nil
 }
 
+func (sw *streamWriter) send(data []byte) error {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	if sw.err != nil {
+		return sw.err
+	}
+	payload := &pb_filesystem.FilePayload{Input: &pb_filesystem.FilePayload_Data{Data: data}}
+	if sw.crc32c {
+		payload.Crc32c, payload.HasCrc32c = crc32.Checksum(data, castagnoli), true
+	}
+	if err := sw.stream.Send(payload); err != nil {
+		sw.err = err
+		return err
+	}
+	sw.tally.add(data)
+	sw.sent = time.Now()
+	return nil
+}
+
+// heartbeat sends an empty payload whenever nothing was sent for interval, until the returned func
+// is called. It does nothing for a zero interval.
+func (sw *streamWriter) heartbeat(interval time.Duration) func() {
+	if interval <= 0 {
+		return func() {}
+	}
+	sw.mu.Lock()
+	sw.sent = time.Now()
+	sw.mu.Unlock()
+	stop, done := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(interval / 2)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+			sw.mu.Lock()
+			idle := time.Since(sw.sent) >= interval
+			sw.mu.Unlock()
+			if idle && sw.send(nil) != nil {
+				// The upload fails on its next write.
+				return
+			}
+		}
+	}()
+	return func() {
+		close(stop)
+		<-done
+	}
+}
+
 type streamReader struct {
 	stream pb_filesystem.FileSever_ReadFileClient
+	// check fails payloads that don't match their checksum.
+	check func(*pb_filesystem.Payload) error
+	tally tally
 
 	buf []byte
+	eof bool
 }
 
-func (sw streamReader) Read(p []byte) (int, error) {
+func (sw *streamReader) Read(p []byte) (int, error) {
 	if len(sw.buf) > 0 {
 		return sw.read(p), nil
 	}
+	if sw.eof {
+		return 0, io.EOF
+	}
 	pb, err := sw.stream.Recv()
 	if err != nil {
 		return 0, err
 	}
+	if err := sw.check(pb); err != nil {
+		return 0, err
+	}
+	sw.tally.add(pb.GetData())
 	sw.buf = pb.GetData()
 	return sw.read(p), nil
 }
 
-func (sw streamReader) read(p []byte) int {
+func (sw *streamReader) read(p []byte) int {
 	n := copy(p, sw.buf)
 	sw.buf = sw.buf[n:]
 	return n
@@ -0,0 +1,1068 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/basharal/filesystem/fs"
+	"github.com/basharal/filesystem/fspath"
+	"github.com/basharal/filesystem/proto/pb_filesystem"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// fakeServer serves ReadFile with chunks, failing with err once they're sent (if set). With
+// checksums, it sets their checksums, which are wrong for the ones in corrupt.
+type fakeServer struct {
+	pb_filesystem.FileSeverClient
+
+	chunks    []string
+	err       error
+	checksums bool
+	corrupt   map[string]bool
+	list      *pb_filesystem.ListResponse
+	write     *fakeWriteStream
+}
+
+func (f *fakeServer) WriteFile(ctx context.Context, opts ...grpc.CallOption) (pb_filesystem.FileSever_WriteFileClient, error) {
+	return f.write, nil
+}
+
+func (f *fakeServer) ReadFile(ctx context.Context, in *pb_filesystem.Path, opts ...grpc.CallOption) (pb_filesystem.FileSever_ReadFileClient, error) {
+	return &fakeReadStream{chunks: f.chunks, err: f.err, checksums: f.checksums && in.Crc32c, corrupt: f.corrupt}, nil
+}
+
+// Stat and TouchFile fail like they do on servers that predate them.
+func (f *fakeServer) Stat(ctx context.Context, in *pb_filesystem.Path, opts ...grpc.CallOption) (*pb_filesystem.StatResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "unknown method Stat for service FileSever")
+}
+
+func (f *fakeServer) TouchFile(ctx context.Context, in *pb_filesystem.Path, opts ...grpc.CallOption) (*pb_filesystem.StatusResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "unknown method TouchFile for service FileSever")
+}
+
+func (f *fakeServer) ListDir(ctx context.Context, in *pb_filesystem.Path, opts ...grpc.CallOption) (*pb_filesystem.ListResponse, error) {
+	return f.list, nil
+}
+
+// ValidatePath rejects paths under /b, like a server whose range differs from the config.
+func (f *fakeServer) ValidatePath(ctx context.Context, in *pb_filesystem.Path, opts ...grpc.CallOption) (*pb_filesystem.StatusResponse, error) {
+	if strings.HasPrefix(in.Path, "/b") {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid path (%s)", in.Path)
+	}
+	return &pb_filesystem.StatusResponse{Status: pb_filesystem.Status_SUCCESS}, nil
+}
+
+type fakeReadStream struct {
+	grpc.ClientStream
+
+	chunks    []string
+	err       error
+	checksums bool
+	corrupt   map[string]bool
+	trailer   metadata.MD
+}
+
+func (s *fakeReadStream) Trailer() metadata.MD {
+	return s.trailer
+}
+
+func (s *fakeReadStream) Recv() (*pb_filesystem.Payload, error) {
+	if len(s.chunks) == 0 {
+		if s.err != nil {
+			return nil, s.err
+		}
+		return nil, io.EOF
+	}
+	chunk := s.chunks[0]
+	s.chunks = s.chunks[1:]
+	payload := &pb_filesystem.Payload{Data: []byte(chunk)}
+	if s.checksums {
+		payload.Crc32c, payload.HasCrc32c = crc32.Checksum(payload.Data, castagnoli), true
+		if s.corrupt[chunk] {
+			payload.Crc32c++
+		}
+	}
+	return payload, nil
+}
+
+// fakeWriteStream fails sending with sendErr once sends messages are sent (if set), and answers
+// with resp or recvErr. It counts the empty payloads it's sent in heartbeats.
+type fakeWriteStream struct {
+	grpc.ClientStream
+
+	sends      int
+	sendErr    error
+	resp       *pb_filesystem.StatusResponse
+	recvErr    error
+	heartbeats int
+	trailer    metadata.MD
+}
+
+func (s *fakeWriteStream) Send(payload *pb_filesystem.FilePayload) error {
+	if s.sendErr != nil && s.sends == 0 {
+		return s.sendErr
+	}
+	s.sends--
+	if payload.GetPath() == "" && len(payload.GetData()) == 0 {
+		s.heartbeats++
+	}
+	return nil
+}
+
+func (s *fakeWriteStream) CloseAndRecv() (*pb_filesystem.StatusResponse, error) {
+	return s.resp, s.recvErr
+}
+
+func (s *fakeWriteStream) Trailer() metadata.MD {
+	return s.trailer
+}
+
+func createTestClient(server *fakeServer) *Client {
+	return &Client{
+		clusters: []Cluster{{Root: "/", Servers: []Server{{StartPrefix: "a", EndPrefix: "z", Addr: "fake"}}}},
+		clients:  map[string]pb_filesystem.FileSeverClient{"fake": server},
+		metrics:  newMetrics(),
+	}
+}
+
+func TestClient_ReadFile(t *testing.T) {
+	errStream := errors.New("stream broken")
+	tests := []struct {
+		name    string
+		server  *fakeServer
+		want    string
+		wantErr error
+	}{
+		{"Complete", &fakeServer{chunks: []string{"new ", "content"}}, "new content", nil},
+		{"Empty", &fakeServer{}, "", nil},
+		{"MidStreamError", &fakeServer{chunks: []string{"partial"}, err: errStream}, "old content", errStream},
+		{"ImmediateError", &fakeServer{err: errStream}, "old content", errStream},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "client")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			local := filepath.Join(dir, "local")
+			if err := ioutil.WriteFile(local, []byte("old content"), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			c := createTestClient(tt.server)
+			var progress int64
+			n, err := c.ReadFile(context.Background(), local, "/foo", Progress(func(n int64) { progress = n }))
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Client.ReadFile() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr == nil && (n != int64(len(tt.want)) || progress != n) {
+				t.Errorf("Client.ReadFile() = %d with progress %d, want %d", n, progress, len(tt.want))
+			}
+			got, err := ioutil.ReadFile(local)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("Client.ReadFile() content = %q, want %q", got, tt.want)
+			}
+			if fi, err := os.Stat(local); err != nil || fi.Mode().Perm() != 0600 {
+				t.Errorf("Client.ReadFile() mode = %v, want %v", fi.Mode().Perm(), os.FileMode(0600))
+			}
+			entries, err := ioutil.ReadDir(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(entries) != 1 {
+				t.Errorf("Client.ReadFile() left %d files, want 1", len(entries))
+			}
+		})
+	}
+}
+
+func TestClient_ReadFileNew(t *testing.T) {
+	dir, err := ioutil.TempDir("", "client")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	local := filepath.Join(dir, "local")
+
+	c := createTestClient(&fakeServer{chunks: []string{"partial"}, err: errors.New("stream broken")})
+	if _, err := c.ReadFile(context.Background(), local, "/foo"); err == nil {
+		t.Errorf("Client.ReadFile() error = %v, wantErr true", err)
+	}
+	if _, err := os.Stat(local); !os.IsNotExist(err) {
+		t.Errorf("Client.ReadFile() created %s after failing", local)
+	}
+}
+
+// summaryOf returns the trailer of a server summarizing a transfer of content in a single chunk.
+func summaryOf(content string) metadata.MD {
+	return metadata.Pairs(summaryBytes, fmt.Sprint(len(content)), summaryChunks, "1",
+		summaryCRC32C, fmt.Sprint(crc32.Checksum([]byte(content), castagnoli)), summaryServerTime, "10")
+}
+
+func TestClient_WriteErrors(t *testing.T) {
+	ok := &pb_filesystem.StatusResponse{Status: pb_filesystem.Status_SUCCESS}
+	broken := status.Error(codes.Unavailable, "connection reset")
+	tooLarge := status.Error(codes.ResourceExhausted, "file too large")
+	errRead := errors.New("disk failed")
+	tests := []struct {
+		name   string
+		stream *fakeWriteStream
+		reader io.Reader
+		check  func(error) bool
+	}{
+		{"Success", &fakeWriteStream{resp: ok}, strings.NewReader("data"), func(err error) bool { return err == nil }},
+		{"Failure", &fakeWriteStream{resp: &pb_filesystem.StatusResponse{Status: pb_filesystem.Status_FAILURE, Reason: "quota"}},
+			strings.NewReader("data"), func(err error) bool {
+				var se *StatusError
+				return errors.As(err, &se) && se.Reason == "quota" && se.Path == "/foo"
+			}},
+		// The server ended the stream early, and its status says why rather than the send's EOF.
+		{"ServerEnded", &fakeWriteStream{sends: 1, sendErr: io.EOF, recvErr: tooLarge}, strings.NewReader("data"),
+			func(err error) bool { return errors.Is(err, fs.ErrLimitExceeded) }},
+		{"BrokeMidUpload", &fakeWriteStream{sends: 1, sendErr: io.EOF, recvErr: broken}, strings.NewReader("data"),
+			func(err error) bool {
+				var te *TransportError
+				return errors.As(err, &te) && !te.Complete && status.Code(te.Err) == codes.Unavailable
+			}},
+		{"BrokeAfterUpload", &fakeWriteStream{recvErr: broken}, strings.NewReader("data"), func(err error) bool {
+			var te *TransportError
+			return errors.As(err, &te) && te.Complete
+		}},
+		{"ReaderFailed", &fakeWriteStream{resp: ok}, io.MultiReader(strings.NewReader("data"), iotest.ErrReader(errRead)),
+			func(err error) bool { return err == errRead }},
+		{"Summary", &fakeWriteStream{resp: ok, trailer: summaryOf("data")}, strings.NewReader("data"),
+			func(err error) bool { return err == nil }},
+		// The server wrote something other than what was sent.
+		{"SummaryMismatch", &fakeWriteStream{resp: ok, trailer: summaryOf("dat")}, strings.NewReader("data"),
+			func(err error) bool { return errors.Is(err, ErrChecksum) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := createTestClient(&fakeServer{write: tt.stream})
+			if _, err := c.Write(context.Background(), "/foo", tt.reader); !tt.check(err) {
+				t.Errorf("Client.Write() error = %v (%T)", err, err)
+			}
+		})
+	}
+}
+
+func TestClient_Checksums(t *testing.T) {
+	chunks := []string{"intact ", "corrupted"}
+	corrupt := map[string]bool{"corrupted": true}
+	tests := []struct {
+		name      string
+		checksums bool
+		wantErr   error
+	}{
+		{"Disabled", false, nil},
+		{"Enabled", true, ErrChecksum},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := createTestClient(&fakeServer{chunks: chunks, checksums: true, corrupt: corrupt})
+			c.checksums = tt.checksums
+			var buf bytes.Buffer
+			if _, err := c.Read(context.Background(), "/foo", &buf); !errors.Is(err, tt.wantErr) {
+				t.Errorf("Client.Read() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			want := int64(0)
+			if tt.wantErr != nil {
+				want = 1
+			}
+			if got := c.metrics.ChecksumErrors(); got != want {
+				t.Errorf("ChecksumErrors() = %d, want %d", got, want)
+			}
+		})
+	}
+}
+
+func TestClient_WriteHeartbeat(t *testing.T) {
+	stream := &fakeWriteStream{resp: &pb_filesystem.StatusResponse{Status: pb_filesystem.Status_SUCCESS}}
+	c := createTestClient(&fakeServer{write: stream})
+	c.writeHeartbeat = 10 * time.Millisecond
+	// The source is idle for a while between its two chunks.
+	pr, pw := io.Pipe()
+	go func() {
+		pw.Write([]byte("slow"))
+		time.Sleep(100 * time.Millisecond)
+		pw.Write([]byte(" source"))
+		pw.Close()
+	}()
+	if n, err := c.Write(context.Background(), "/foo", pr); err != nil || n != 11 {
+		t.Fatalf("Client.Write() = %d, %v", n, err)
+	}
+	if stream.heartbeats == 0 {
+		t.Errorf("Client.Write() sent no heartbeats while the source was idle")
+	}
+}
+
+func TestMergeEntries(t *testing.T) {
+	entries := func(names ...string) []*pb_filesystem.Entry {
+		list := make([]*pb_filesystem.Entry, 0, len(names))
+		for _, name := range names {
+			list = append(list, &pb_filesystem.Entry{Name: name})
+		}
+		return list
+	}
+	merged := mergeEntries([][]*pb_filesystem.Entry{entries("a", "d", "e"), entries(), entries("b", "c", "f")})
+	got := make([]string, 0, len(merged))
+	for _, e := range merged {
+		got = append(got, e.Name)
+	}
+	if want := "abcdef"; strings.Join(got, "") != want {
+		t.Errorf("mergeEntries() = %v, want %v", got, want)
+	}
+}
+
+func TestBestHits(t *testing.T) {
+	hits := []*pb_filesystem.SearchHit{{Path: "/b", Score: 1}, {Path: "/c", Score: 3}, {Path: "/a", Score: 1}, {Path: "/d", Score: 0.5}}
+	got := make([]string, 0)
+	for _, hit := range bestHits(hits, 3) {
+		got = append(got, hit.Path)
+	}
+	if want := "/c,/a,/b"; strings.Join(got, ",") != want {
+		t.Errorf("bestHits() = %v, want %v", got, want)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	build := func(files map[string]string) DigestFunc {
+		f := fs.New()
+		for _, dir := range []string{"/a", "/b", "/b/c"} {
+			if err := f.ChangeDir(fspath.Dir(dir)); err != nil {
+				t.Fatal(err)
+			}
+			if err := f.MakeDir(fspath.Base(dir)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for path, content := range files {
+			if err := f.ChangeDir(fspath.Dir(path)); err != nil {
+				t.Fatal(err)
+			}
+			if err := f.NewFile(fspath.Base(path)); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := f.Write(path, strings.NewReader(content)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return func(ctx context.Context, path string) ([]byte, []fs.EntryDigest, error) {
+			return f.Digest(path)
+		}
+	}
+	a := build(map[string]string{"/a/1": "x", "/b/c/2": "y", "/b/3": "z"})
+	b := build(map[string]string{"/a/1": "x", "/b/c/2": "changed", "/b/4": "z"})
+	diffs, err := Diff(context.Background(), a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make([]string, 0, len(diffs))
+	for _, d := range diffs {
+		got = append(got, d.Kind.String()+" "+d.Path)
+	}
+	if want := "removed /b/3, added /b/4, modified /b/c/2"; strings.Join(got, ", ") != want {
+		t.Errorf("Diff() = %v, want %s", got, want)
+	}
+	if diffs, err := Diff(context.Background(), a, a); err != nil || len(diffs) != 0 {
+		t.Errorf("Diff() = %v, %v, want no diffs", diffs, err)
+	}
+}
+
+func TestClient_ListEntriesFallback(t *testing.T) {
+	server := &fakeServer{list: &pb_filesystem.ListResponse{
+		Files: []*pb_filesystem.File{{Name: "c", Path: "/c", Size: 3}, {Name: "a", Path: "/a"}},
+		Dirs:  []*pb_filesystem.Dir{{Name: "b", Path: "/b"}},
+	}}
+	c := createTestClient(server)
+	// A server that predates ListEntries.
+	c.infos = map[string]*pb_filesystem.ServerInfo{"fake": {}}
+
+	entries, err := c.ListEntries(context.Background(), "/")
+	if err != nil {
+		t.Fatalf("Client.ListEntries() error = %v", err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name)
+	}
+	if got, want := strings.Join(names, ","), "a,b,c"; got != want {
+		t.Errorf("Client.ListEntries() = %s, want %s", got, want)
+	}
+	if _, ok := entries[1].Node.(*pb_filesystem.Entry_Dir); !ok {
+		t.Errorf("Client.ListEntries() entry b = %T, want dir", entries[1].Node)
+	}
+	if entries[2].Size != 3 {
+		t.Errorf("Client.ListEntries() size of c = %d, want 3", entries[2].Size)
+	}
+}
+
+func TestClient_OldServer(t *testing.T) {
+	server := &fakeServer{list: &pb_filesystem.ListResponse{
+		Files: []*pb_filesystem.File{{Name: "a", Path: "/a", Size: 3}},
+	}}
+	c := createTestClient(server)
+	ctx := context.Background()
+
+	// Stat falls back to listing the parent.
+	file, _, err := c.Stat(ctx, "/a")
+	if err != nil || file == nil || file.Size != 3 {
+		t.Errorf("Client.Stat(/a) = %v, %v, want a file of size 3", file, err)
+	}
+	if c.supports("fake", FeatureStat) {
+		t.Errorf("Client.supports(%s) = true after Unimplemented, want false", FeatureStat)
+	}
+	if _, _, err := c.Stat(ctx, "/b"); !errors.Is(err, fs.ErrNotFound) {
+		t.Errorf("Client.Stat(/b) error = %v, want %v", err, fs.ErrNotFound)
+	}
+
+	// TouchFile can't be done otherwise.
+	var ce *CapabilityError
+	if err := c.TouchFile(ctx, "/a"); !errors.As(err, &ce) || !errors.Is(err, fs.ErrNotSupported) {
+		t.Errorf("Client.TouchFile() error = %v, want a *CapabilityError", err)
+	}
+}
+
+func TestNew_Validate(t *testing.T) {
+	server := func(start, end, addr string) Server {
+		return Server{StartPrefix: start, EndPrefix: end, Addr: addr}
+	}
+	tests := []struct {
+		name     string
+		opts     Opts
+		problems int
+	}{
+		{"valid", Opts{Servers: []Server{server("n", "{", "b:1"), server("a", "n", "a:1")}}, 0},
+		{"unix socket", Opts{Servers: []Server{server("a", "{", "unix:///tmp/fs.sock")}}, 0},
+		{"open ranges", Opts{Servers: []Server{server("", "n", "a:1"), server("n", "", "b:1")}}, 0},
+		{"open overlap", Opts{Servers: []Server{server("a", "", "a:1"), server("n", "", "b:1")}}, 1},
+		{"no servers", Opts{}, 1},
+		{"both", Opts{Servers: []Server{server("a", "{", "a:1")}, Clusters: []Cluster{{Root: "/x", Servers: []Server{server("a", "{", "b:1")}}}}, 1},
+		{"gap", Opts{Servers: []Server{server("a", "m", "a:1"), server("n", "{", "b:1")}}, 1},
+		{"allowed gap", Opts{Servers: []Server{server("a", "m", "a:1"), server("n", "{", "b:1")}, AllowGaps: true}, 0},
+		{"overlap", Opts{Servers: []Server{server("a", "o", "a:1"), server("n", "{", "b:1")}}, 1},
+		{"bad prefixes", Opts{Servers: []Server{server("/a", "{", "a:1"), server("z", "a", "b:1")}}, 2},
+		{"hierarchical prefixes", Opts{Servers: []Server{server("", "projects/m", "a:1"), server("projects/m", "", "b:1")}}, 0},
+		{"bad addresses", Opts{Servers: []Server{{StartPrefix: "a", EndPrefix: "{", Addr: "a", Replicas: []string{""}}}}, 2},
+		{"shared address", Opts{Clusters: []Cluster{
+			{Root: "/x", Servers: []Server{server("a", "{", "a:1")}},
+			{Root: "/y", Servers: []Server{server("a", "{", "a:1")}},
+		}}, 1},
+		{"bad roots", Opts{Clusters: []Cluster{
+			{Root: "/x/y", Servers: []Server{server("a", "{", "a:1")}},
+			{Root: "/x/y", Servers: []Server{server("a", "{", "b:1")}},
+		}}, 3},
+	}
+	for _, test := range tests {
+		_, err := New(test.opts)
+		var ce *ConfigError
+		if test.problems == 0 {
+			if err != nil {
+				t.Errorf("%s: New() error = %v, want nil", test.name, err)
+			}
+			continue
+		}
+		if !errors.As(err, &ce) || len(ce.Problems) != test.problems {
+			t.Errorf("%s: New() error = %v, want a *ConfigError with %d problems", test.name, err, test.problems)
+		}
+	}
+}
+
+func TestClient_RootEntries(t *testing.T) {
+	c := createTestClient(&fakeServer{})
+	ctx := context.Background()
+	if err := c.MakeDir(ctx, "/"); !errors.Is(err, fs.ErrAlreadyExist) {
+		t.Errorf("Client.MakeDir(/) error = %v, want %v", err, fs.ErrAlreadyExist)
+	}
+	if err := c.Remove(ctx, "/"); !errors.Is(err, fs.ErrInvalidName) {
+		t.Errorf("Client.Remove(/) error = %v, want %v", err, fs.ErrInvalidName)
+	}
+	// The server's range is [a, z).
+	if err := c.MakeDir(ctx, "/Z"); !errors.Is(err, ErrNoServer) {
+		t.Errorf("Client.MakeDir(/Z) error = %v, want %v", err, ErrNoServer)
+	}
+	if _, _, err := c.Stat(ctx, "/Z"); !errors.Is(err, ErrNoServer) {
+		t.Errorf("Client.Stat(/Z) error = %v, want %v", err, ErrNoServer)
+	}
+
+	// Open ranges own every name before and after the others'.
+	c.clusters[0].Servers = []Server{{StartPrefix: "", EndPrefix: "n", Addr: "first"}, {StartPrefix: "n", EndPrefix: "", Addr: "last"}}
+	for path, want := range map[string]string{"/Z": "first", "/a/b": "first", "/n": "last", "/~": "last"} {
+		if s, _, err := c.shardForPath("mkdir", path); err != nil || s.addr != want {
+			t.Errorf("Client.shardForPath(%s) = %s, %v, want %s", path, s.addr, err, want)
+		}
+	}
+}
+
+func TestClient_HierarchicalPrefixes(t *testing.T) {
+	c := createTestClient(&fakeServer{})
+	c.clusters[0].Servers = []Server{
+		{StartPrefix: "", EndPrefix: "projects/a", Addr: "first"},
+		{StartPrefix: "projects/a", EndPrefix: "projects/m", Addr: "middle"},
+		{StartPrefix: "projects/m", EndPrefix: "", Addr: "last"},
+	}
+	for path, want := range map[string]string{
+		"/a": "first", "/project": "first", "/projects-old": "first", "/projects/Z": "first",
+		"/projects/a": "middle", "/projects/b/c": "middle", "/projects/lz": "middle",
+		"/projects/m": "last", "/projects/z": "last", "/projectz": "last", "/z": "last",
+	} {
+		if s, _, err := c.shardForPath("mkdir", path); err != nil || s.addr != want {
+			t.Errorf("Client.shardForPath(%s) = %s, %v, want %s", path, s.addr, err, want)
+		}
+	}
+	if n := len(serversForPath(c.clusters[0], "/projects")); n != 3 {
+		t.Errorf("serversForPath(/projects) = %d servers, want 3", n)
+	}
+	if _, _, err := c.shardForPath("create", "/projects"); !errors.Is(err, fs.ErrInvalidName) {
+		t.Errorf("Client.shardForPath(/projects) error = %v, want %v", err, fs.ErrInvalidName)
+	}
+
+	// Dirs above a boundary are listed once.
+	list := func(names ...string) *fakeServer {
+		out := &pb_filesystem.ListResponse{}
+		for _, name := range names {
+			out.Dirs = append(out.Dirs, &pb_filesystem.Dir{Name: name, Path: "/projects/" + name})
+		}
+		return &fakeServer{list: out}
+	}
+	c.clusters[0].Servers = c.clusters[0].Servers[1:]
+	c.clusters[0].Servers[0].StartPrefix = "projects/a/m"
+	c.clients = map[string]pb_filesystem.FileSeverClient{"middle": list("a", "b"), "last": list("a", "m")}
+	_, dirs, err := c.ListDir(context.Background(), "/projects")
+	if err != nil || len(dirs) != 3 {
+		t.Errorf("Client.ListDir(/projects) = %v, %v, want a, b and m", dirs, err)
+	}
+}
+
+// clusterServer is the server of a cluster, recording the paths it's asked for.
+type clusterServer struct {
+	fakeServer
+	mu    sync.Mutex
+	paths []string
+}
+
+func (c *clusterServer) record(path string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.paths = append(c.paths, path)
+}
+
+func (c *clusterServer) ListDir(ctx context.Context, in *pb_filesystem.Path, opts ...grpc.CallOption) (*pb_filesystem.ListResponse, error) {
+	c.record(in.Path)
+	return &pb_filesystem.ListResponse{
+		Files: []*pb_filesystem.File{{Name: "file", Path: fspath.Join(in.Path, "file")}},
+		Dirs:  []*pb_filesystem.Dir{{Name: "dir", Path: fspath.Join(in.Path, "dir")}},
+	}, nil
+}
+
+func (c *clusterServer) MakeDir(ctx context.Context, in *pb_filesystem.Path, opts ...grpc.CallOption) (*pb_filesystem.StatusResponse, error) {
+	c.record(in.Path)
+	return &pb_filesystem.StatusResponse{Status: pb_filesystem.Status_SUCCESS}, nil
+}
+
+func TestClient_Clusters(t *testing.T) {
+	east, west := &clusterServer{}, &clusterServer{}
+	c := createTestClient(nil)
+	c.clusters = []Cluster{
+		{Root: "/east", Servers: []Server{{StartPrefix: "a", EndPrefix: "z", Addr: "east"}}},
+		{Root: "/west", Servers: []Server{{StartPrefix: "a", EndPrefix: "z", Addr: "west"}}},
+	}
+	c.clients = map[string]pb_filesystem.FileSeverClient{"east": east, "west": west}
+	ctx := context.Background()
+
+	// The root lists the clusters, without asking the servers.
+	files, dirs, err := c.ListDir(ctx, "/")
+	if err != nil || len(files) != 0 || len(dirs) != 2 || dirs[0].Path != "/east" || dirs[1].Path != "/west" {
+		t.Errorf("Client.ListDir(/) = %v, %v, %v, want /east and /west", files, dirs, err)
+	}
+	if _, dir, err := c.Stat(ctx, "/"); err != nil || dir == nil {
+		t.Errorf("Client.Stat(/) = %v, %v, want the root dir", dir, err)
+	}
+
+	// Paths are routed by cluster and sent to the servers within it, and the paths they return
+	// are under the cluster's root.
+	files, dirs, err = c.ListDir(ctx, "/west/a")
+	if err != nil || len(files) != 1 || files[0].Path != "/west/a/file" || len(dirs) != 1 || dirs[0].Path != "/west/a/dir" {
+		t.Errorf("Client.ListDir(/west/a) = %v, %v, %v, want /west/a/file and /west/a/dir", files, dirs, err)
+	}
+	if _, dirs, err := c.ListDir(ctx, "/east"); err != nil || len(dirs) != 1 || dirs[0].Path != "/east/dir" {
+		t.Errorf("Client.ListDir(/east) = %v, %v, want /east/dir", dirs, err)
+	}
+	if err := c.MakeDir(ctx, "/east/b"); err != nil {
+		t.Errorf("Client.MakeDir(/east/b) = %v", err)
+	}
+	if want := []string{"/", "/b"}; !reflect.DeepEqual(east.paths, want) {
+		t.Errorf("east was asked for %v, want %v", east.paths, want)
+	}
+	if want := []string{"/a"}; !reflect.DeepEqual(west.paths, want) {
+		t.Errorf("west was asked for %v, want %v", west.paths, want)
+	}
+
+	// Cluster roots exist, and paths outside the clusters don't.
+	if err := c.MakeDir(ctx, "/east"); !errors.Is(err, fs.ErrAlreadyExist) {
+		t.Errorf("Client.MakeDir(/east) = %v, want %v", err, fs.ErrAlreadyExist)
+	}
+	if _, _, err := c.ListDir(ctx, "/north/a"); err == nil {
+		t.Errorf("Client.ListDir(/north/a) succeeded outside of the clusters")
+	}
+	if _, _, err := c.ListDir(ctx, "/eastern"); err == nil {
+		t.Errorf("Client.ListDir(/eastern) succeeded outside of the clusters")
+	}
+}
+
+// existingDst fails creating files like a server they exist on already.
+type existingDst struct {
+	fakeServer
+}
+
+func (e *existingDst) CreateFile(ctx context.Context, in *pb_filesystem.Path, opts ...grpc.CallOption) (*pb_filesystem.StatusResponse, error) {
+	return nil, status.Errorf(codes.AlreadyExists, "create %s: already exists", in.Path)
+}
+
+func TestClient_CopyOntoExisting(t *testing.T) {
+	c := createTestClient(&fakeServer{})
+	c.clusters[0].Servers = []Server{
+		{StartPrefix: "a", EndPrefix: "m", Addr: "src"},
+		{StartPrefix: "m", EndPrefix: "z", Addr: "dst"},
+	}
+	c.clients = map[string]pb_filesystem.FileSeverClient{
+		"src": &fakeServer{chunks: []string{"relayed ", "through ", "the client"}},
+		"dst": &existingDst{},
+	}
+	before := runtime.NumGoroutine()
+	for i := 0; i < 10; i++ {
+		if err := c.Copy(context.Background(), "/a", "/n"); !errors.Is(err, fs.ErrAlreadyExist) {
+			t.Fatalf("Client.Copy() error = %v, want %v", err, fs.ErrAlreadyExist)
+		}
+	}
+	// The relays reading src must be gone.
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := runtime.NumGoroutine(); n > before {
+		t.Errorf("Client.Copy() leaked %d goroutines", n-before)
+	}
+}
+
+// staleReplica fails reads bounding staleness, like a replica behind its primary.
+type staleReplica struct {
+	fakeServer
+}
+
+func (r *staleReplica) ListDir(ctx context.Context, in *pb_filesystem.Path, opts ...grpc.CallOption) (*pb_filesystem.ListResponse, error) {
+	if in.MaxStalenessMs > 0 {
+		return nil, status.Errorf(codes.FailedPrecondition, "replica is behind")
+	}
+	return r.list, nil
+}
+
+func TestClient_MaxStaleness(t *testing.T) {
+	list := func(name string) *pb_filesystem.ListResponse {
+		return &pb_filesystem.ListResponse{Files: []*pb_filesystem.File{{Name: name, Path: "/a/" + name}}}
+	}
+	c := createTestClient(&fakeServer{list: list("primary")})
+	c.clusters[0].Servers[0].Replicas = []string{"replica"}
+	c.clients["replica"] = &fakeServer{list: list("replica")}
+	ctx := context.Background()
+	tests := []struct {
+		name string
+		opts []CallOption
+		want string
+	}{
+		{"Default", nil, "primary"},
+		{"MaxStaleness", []CallOption{MaxStaleness(time.Second)}, "replica"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			files, _, err := c.ListDir(ctx, "/a", tt.opts...)
+			if err != nil || len(files) != 1 || files[0].Name != tt.want {
+				t.Errorf("Client.ListDir() = %v, %v, want a file from the %s", files, err, tt.want)
+			}
+		})
+	}
+
+	// Reads fall back to the primary when the replica is too stale.
+	c.clients["replica"] = &staleReplica{fakeServer{list: list("replica")}}
+	files, _, err := c.ListDir(ctx, "/a", MaxStaleness(time.Second))
+	if err != nil || len(files) != 1 || files[0].Name != "primary" {
+		t.Errorf("Client.ListDir() = %v, %v, want a file from the primary", files, err)
+	}
+}
+
+// slowServer lists after delay.
+type slowServer struct {
+	fakeServer
+	delay time.Duration
+}
+
+func (s *slowServer) ListDir(ctx context.Context, in *pb_filesystem.Path, opts ...grpc.CallOption) (*pb_filesystem.ListResponse, error) {
+	select {
+	case <-time.After(s.delay):
+		return s.list, nil
+	case <-ctx.Done():
+		return nil, status.FromContextError(ctx.Err()).Err()
+	}
+}
+
+func TestClient_HedgeStaleness(t *testing.T) {
+	list := func(name string) *pb_filesystem.ListResponse {
+		return &pb_filesystem.ListResponse{Files: []*pb_filesystem.File{{Name: name, Path: "/a/" + name}}}
+	}
+	c := createTestClient(nil)
+	c.hedgeDelay = time.Millisecond
+	c.clients["fake"] = &slowServer{fakeServer{list: list("primary")}, 50 * time.Millisecond}
+	c.clusters[0].Servers[0].Replicas = []string{"replica"}
+	// The replica lags behind, so it only answers reads without a bound on staleness.
+	c.clients["replica"] = &staleReplica{fakeServer{list: list("replica")}}
+	ctx := context.Background()
+	files, _, err := c.ListDir(ctx, "/a")
+	if err != nil || len(files) != 1 || files[0].Name != "primary" {
+		t.Errorf("Client.ListDir() = %v, %v, want a file from the primary", files, err)
+	}
+	files, _, err = c.ListDir(ctx, "/a", MaxStaleness(time.Second))
+	if err != nil || len(files) != 1 || files[0].Name != "primary" {
+		t.Errorf("Client.ListDir(MaxStaleness) = %v, %v, want a file from the primary", files, err)
+	}
+
+	// Replicas keeping up take hedged reads allowing staleness.
+	c.clients["replica"] = &fakeServer{list: list("replica")}
+	files, _, err = c.ListDir(ctx, "/a", MaxStaleness(time.Second))
+	if err != nil || len(files) != 1 || files[0].Name != "replica" {
+		t.Errorf("Client.ListDir(MaxStaleness) = %v, %v, want a file from the replica", files, err)
+	}
+}
+
+func TestClient_Explain(t *testing.T) {
+	c := createTestClient(&fakeServer{})
+	ctx := context.Background()
+	if routes, err := c.Explain(ctx, "/a"); err != nil || len(routes) != 1 || routes[0].Err == nil {
+		t.Errorf("Client.Explain(/a) = %v, %v, want a route that isn't connected", routes, err)
+	}
+
+	// Nothing listens on the address, which gRPC only finds out when called.
+	conn, err := grpc.Dial("localhost:1", grpc.WithInsecure())
+	if err != nil {
+		t.Fatalf("grpc.Dial() error = %v", err)
+	}
+	defer conn.Close()
+	c.conns = map[string]*grpc.ClientConn{"fake": conn}
+	routes, err := c.Explain(ctx, "/a/b")
+	if err != nil || len(routes) != 1 {
+		t.Fatalf("Client.Explain(/a/b) = %v, %v, want one route", routes, err)
+	}
+	if r := routes[0]; r.Addr != "fake" || r.Err != nil || r.State == "" || !strings.Contains(r.Reason, "[a, z)") {
+		t.Errorf("Client.Explain(/a/b) = %+v, want an accepted route to fake", r)
+	}
+	if routes, err := c.Explain(ctx, "/b"); err != nil || len(routes) != 1 || !errors.Is(routes[0].Err, fs.ErrInvalidName) {
+		t.Errorf("Client.Explain(/b) = %v, %v, want a rejected route", routes, err)
+	}
+	if routes, err := c.Explain(ctx, "/zoo"); err != nil || len(routes) != 0 {
+		t.Errorf("Client.Explain(/zoo) = %v, %v, want no routes", routes, err)
+	}
+}
+
+func TestClient_DialClose(t *testing.T) {
+	// Nothing listens on the address, which gRPC only finds out when called.
+	c, err := New(Opts{Servers: []Server{{StartPrefix: "a", EndPrefix: "{", Addr: "localhost:1"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if err := c.Dial(ctx); err != nil {
+		t.Fatal(err)
+	}
+	conns := reflect.ValueOf(c.conns).Pointer()
+	if err := c.Dial(ctx); err != nil || reflect.ValueOf(c.conns).Pointer() != conns {
+		t.Errorf("Client.Dial() = %v, redialed a connected client", err)
+	}
+	if err := c.Close(); err != nil || c.conns != nil {
+		t.Fatalf("Client.Close() = %v, conns = %v", err, c.conns)
+	}
+	if err := c.Close(); err != nil {
+		t.Errorf("Client.Close() = %v on a closed client, want nil", err)
+	}
+	if err := c.Dial(ctx); err != nil || c.conns["localhost:1"] == nil {
+		t.Errorf("Client.Dial() = %v, didn't redial a closed client", err)
+	}
+	c.Close()
+}
+
+func TestCallTracker(t *testing.T) {
+	var calls callTracker
+	if err := calls.wait(context.Background()); err != nil {
+		t.Errorf("wait() = %v without calls, want nil", err)
+	}
+	calls.start()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := calls.wait(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("wait() = %v with a call in flight, want %v", err, context.DeadlineExceeded)
+	}
+	go calls.done()
+	if err := calls.wait(context.Background()); err != nil {
+		t.Errorf("wait() = %v once the call is done, want nil", err)
+	}
+}
+
+func TestMultiError(t *testing.T) {
+	me := &MultiError{
+		Succeeded: []string{"first"},
+		Failed:    []*ShardError{{Addr: "second", Err: fs.ErrNotFound}, {Addr: "third", Err: fs.ErrBusy}},
+		Canceled:  []string{"fourth"},
+	}
+	want := fmt.Sprintf("2 of 4 servers failed: second: %s; third: %s", fs.ErrNotFound, fs.ErrBusy)
+	if got := me.Error(); got != want {
+		t.Errorf("MultiError.Error() = %q, want %q", got, want)
+	}
+	// Only the first failure is unwrapped.
+	var err error = fmt.Errorf("list /a: %w", me)
+	if !errors.Is(err, fs.ErrNotFound) || errors.Is(err, fs.ErrBusy) {
+		t.Errorf("errors.Is(%v) matches the wrong failures", err)
+	}
+	var se *ShardError
+	if !errors.As(err, &se) || se.Addr != "second" {
+		t.Errorf("errors.As(%v) = %v, want the ShardError of second", err, se)
+	}
+	var got *MultiError
+	if !errors.As(err, &got) || got != me {
+		t.Errorf("errors.As(%v) = %v, want the MultiError", err, got)
+	}
+	if err := (&MultiError{Succeeded: []string{"first"}}).Unwrap(); err != nil {
+		t.Errorf("MultiError.Unwrap() = %v without failures, want nil", err)
+	}
+}
+
+func TestFanOut(t *testing.T) {
+	shards := []shard{{addr: "ok"}, {addr: "failing"}, {addr: "slow"}}
+	fn := func(ctx context.Context, s shard) error {
+		switch s.addr {
+		case "failing":
+			return fs.ErrNotFound
+		case "slow":
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(10 * time.Millisecond):
+				return nil
+			}
+		}
+		return nil
+	}
+	ctx := context.Background()
+	// The failure cancels the slow call.
+	me := fanOut(ctx, shards, true, fn)
+	if me == nil || !reflect.DeepEqual(me.Succeeded, []string{"ok"}) || !reflect.DeepEqual(me.Canceled, []string{"slow"}) ||
+		len(me.Failed) != 1 || me.Failed[0].Addr != "failing" || !errors.Is(me, fs.ErrNotFound) {
+		t.Errorf("fanOut() = %+v, want ok succeeded, failing failed and slow canceled", me)
+	}
+	// Without canceling, the slow call completes.
+	me = fanOut(ctx, shards, false, fn)
+	if me == nil || len(me.Succeeded) != 2 || len(me.Canceled) != 0 || len(me.Failed) != 1 {
+		t.Errorf("fanOut() = %+v, want ok and slow succeeded, failing failed", me)
+	}
+	if me := fanOut(ctx, shards[:1], true, fn); me != nil {
+		t.Errorf("fanOut() = %+v, want nil when all succeed", me)
+	}
+}
+
+// failingList fails listing with err.
+type failingList struct {
+	fakeServer
+	err error
+}
+
+func (f *failingList) ListDir(ctx context.Context, in *pb_filesystem.Path, opts ...grpc.CallOption) (*pb_filesystem.ListResponse, error) {
+	return nil, f.err
+}
+
+func TestClient_ListDirPartialResults(t *testing.T) {
+	c := createTestClient(&fakeServer{})
+	c.clusters[0].Servers = []Server{{StartPrefix: "a", EndPrefix: "n", Addr: "first"}, {StartPrefix: "n", EndPrefix: "z", Addr: "second"}}
+	c.clients = map[string]pb_filesystem.FileSeverClient{
+		"first":  &fakeServer{list: &pb_filesystem.ListResponse{Files: []*pb_filesystem.File{{Name: "b", Path: "/b"}}}},
+		"second": &failingList{err: status.Error(codes.NotFound, "list /: not found")},
+	}
+	ctx := context.Background()
+	files, _, err := c.ListDir(ctx, "/")
+	var me *MultiError
+	if !errors.As(err, &me) || files != nil || len(me.Failed) != 1 || me.Failed[0].Addr != "second" || !errors.Is(err, fs.ErrNotFound) {
+		t.Errorf("Client.ListDir(/) = %v, %v, want a MultiError of second", files, err)
+	}
+	// With partial results, the files of the servers that succeeded are returned with the error.
+	c.partialResults = true
+	files, _, err = c.ListDir(ctx, "/")
+	if !errors.As(err, &me) || len(files) != 1 || files[0].Path != "/b" || !reflect.DeepEqual(me.Succeeded, []string{"first"}) {
+		t.Errorf("Client.ListDir(/) = %v, %v, want /b and a MultiError of second", files, err)
+	}
+}
+
+func TestFromStatus_Exists(t *testing.T) {
+	st, err := status.New(codes.AlreadyExists, "create /foo: a dir already exists at /foo").WithDetails(
+		&pb_filesystem.PathError{Op: "create", Path: "/foo", Reason: fs.ErrAlreadyExist.Error(), Existing: "/foo", ExistingDir: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := fromStatus(st.Err())
+	var ee *fs.ExistsError
+	if !errors.As(got, &ee) || ee.Path != "/foo" || !ee.IsDir || !errors.Is(got, fs.ErrAlreadyExist) {
+		t.Errorf("fromStatus() = %v, want an ExistsError for the dir /foo", got)
+	}
+	if want := "create /foo: a dir already exists at /foo"; got.Error() != want {
+		t.Errorf("fromStatus() = %q, want %q", got, want)
+	}
+}
+
+func TestFromStatus_Rejected(t *testing.T) {
+	tests := []struct {
+		name      string
+		code      codes.Code
+		reason    error
+		rejection string
+		want      error
+	}{
+		{"Type", codes.InvalidArgument, fs.ErrRejected, "content type image/png isn't one of text/*", fs.ErrRejected},
+		{"Size", codes.ResourceExhausted, fs.ErrLimitExceeded, "max upload size of 10 bytes: limit exceeded", fs.ErrLimitExceeded},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st, err := status.New(tt.code, "write /foo: rejected").WithDetails(&pb_filesystem.PathError{
+				Op: "write", Path: "/foo", Reason: tt.reason.Error(), RejectedBy: "v", RejectedAt: 512, Rejection: tt.rejection,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := fromStatus(st.Err())
+			var re *fs.RejectedError
+			if !errors.As(got, &re) || re.Validator != "v" || re.Offset != 512 || !errors.Is(got, tt.want) || !errors.Is(got, fs.ErrRejected) {
+				t.Fatalf("fromStatus() = %v, want a RejectedError matching %v", got, tt.want)
+			}
+			if want := "write /foo: rejected by v at byte 512: " + tt.rejection; got.Error() != want {
+				t.Errorf("fromStatus() = %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestBreaker(t *testing.T) {
+	b := newBreaker("fake", BreakerOpts{Failures: 2, Cooldown: 20 * time.Millisecond})
+	unavailable := status.Error(codes.Unavailable, "down")
+
+	// Closed: errors of a healthy server don't count, and a success resets the failures.
+	for _, err := range []error{unavailable, status.Error(codes.NotFound, "missing"), nil, unavailable} {
+		if err := b.allow(); err != nil {
+			t.Fatalf("breaker.allow() = %v while closed", err)
+		}
+		b.done(err)
+	}
+	// Open after 2 failures in a row.
+	if err := b.allow(); err != nil {
+		t.Fatalf("breaker.allow() = %v while closed", err)
+	}
+	b.done(unavailable)
+	if err := b.allow(); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("breaker.allow() = %v while open, want %v", err, ErrCircuitOpen)
+	}
+	// Half-open after the cooldown: a single probe goes through, and failing it opens again.
+	time.Sleep(20 * time.Millisecond)
+	if err := b.allow(); err != nil {
+		t.Fatalf("breaker.allow() = %v after the cooldown", err)
+	}
+	if err := b.allow(); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("breaker.allow() = %v with a probe in flight, want %v", err, ErrCircuitOpen)
+	}
+	b.done(unavailable)
+	if err := b.allow(); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("breaker.allow() = %v after a failed probe, want %v", err, ErrCircuitOpen)
+	}
+	// A canceled probe lets another one through, and a successful one closes the circuit.
+	time.Sleep(20 * time.Millisecond)
+	if err := b.allow(); err != nil {
+		t.Fatalf("breaker.allow() = %v after the cooldown", err)
+	}
+	b.done(status.Error(codes.Canceled, "canceled"))
+	if err := b.allow(); err != nil {
+		t.Fatalf("breaker.allow() = %v after a canceled probe", err)
+	}
+	b.done(nil)
+	for i := 0; i < 3; i++ {
+		if err := b.allow(); err != nil {
+			t.Fatalf("breaker.allow() = %v after a successful probe", err)
+		}
+		b.done(nil)
+	}
+}
+
+// probeStream receives a single message.
+type probeStream struct {
+	grpc.ClientStream
+}
+
+func (s *probeStream) RecvMsg(m interface{}) error {
+	return nil
+}
+
+func TestBreaker_AbandonedProbe(t *testing.T) {
+	tests := []struct {
+		name   string
+		recv   bool
+		closed bool
+	}{
+		{"AfterResponse", true, true},
+		{"BeforeResponse", false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newBreaker("fake", BreakerOpts{Failures: 1, Cooldown: time.Millisecond})
+			b.done(status.Error(codes.Unavailable, "down"))
+			time.Sleep(time.Millisecond)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			streamer := func(context.Context, *grpc.StreamDesc, *grpc.ClientConn, string, ...grpc.CallOption) (grpc.ClientStream, error) {
+				return &probeStream{}, nil
+			}
+			stream, err := b.streamInterceptor(ctx, &grpc.StreamDesc{}, nil, "/probe", streamer)
+			if err != nil {
+				t.Fatalf("breaker.streamInterceptor() = %v for the probe", err)
+			}
+			if tt.recv {
+				if err := stream.RecvMsg(nil); err != nil {
+					t.Fatal(err)
+				}
+			}
+			// Abandoned like a losing hedge.
+			cancel()
+			<-stream.(*breakerStream).ended
+			if err := b.allow(); err != nil {
+				t.Fatalf("breaker.allow() = %v after an abandoned probe", err)
+			}
+			b.mu.Lock()
+			closed := b.failed == 0
+			b.mu.Unlock()
+			if closed != tt.closed {
+				t.Errorf("breaker closed = %v after an abandoned probe, want %v", closed, tt.closed)
+			}
+		})
+	}
+}
@@ -0,0 +1,78 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/basharal/filesystem/fs"
+	"github.com/basharal/filesystem/fspath"
+	"github.com/basharal/filesystem/proto/pb_filesystem"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// CapabilityError is returned when a server is too old for an operation and there's no way to do
+// it with the RPCs the server has. It matches fs.ErrNotSupported.
+type CapabilityError struct {
+	Addr    string
+	Feature string
+}
+
+func (e *CapabilityError) Error() string {
+	return fmt.Sprintf("server %s doesn't support %s, upgrade it to use this", e.Addr, e.Feature)
+}
+
+func (e *CapabilityError) Unwrap() error {
+	return fs.ErrNotSupported
+}
+
+// missingRPC returns whether err is a server not having the called RPC, as opposed to the RPC not
+// supporting a path (i.e., a mounted one) which servers also report as Unimplemented.
+func missingRPC(err error) bool {
+	st, ok := status.FromError(err)
+	if !ok || st.Code() != codes.Unimplemented || len(st.Details()) != 0 {
+		return false
+	}
+	msg := st.Message()
+	return strings.HasPrefix(msg, "unknown method") || strings.HasSuffix(msg, "not implemented")
+}
+
+// unsupported records that the server at addr lacks feature, so that it isn't called again, and
+// returns the error for it.
+func (c *Client) unsupported(addr, feature string) error {
+	c.mu.Lock()
+	if c.missing == nil {
+		c.missing = make(map[string]map[string]bool)
+	}
+	if c.missing[addr] == nil {
+		c.missing[addr] = make(map[string]bool)
+	}
+	c.missing[addr][feature] = true
+	c.mu.Unlock()
+	return &CapabilityError{Addr: addr, Feature: feature}
+}
+
+// statFromList stats path on a server that doesn't support Stat by listing its parent.
+func (c *Client) statFromList(ctx context.Context, s shard, path string) (*pb_filesystem.File, *pb_filesystem.Dir, error) {
+	path = fspath.Clean(path)
+	if path == fspath.Root {
+		return nil, &pb_filesystem.Dir{Name: "", Path: fspath.Root}, nil
+	}
+	out, err := s.client.ListDir(ctx, &pb_filesystem.Path{Path: fspath.Dir(path)})
+	if err != nil {
+		return nil, nil, fromStatus(err)
+	}
+	name := fspath.Base(path)
+	for _, f := range out.Files {
+		if f.Name == name {
+			return f, nil, nil
+		}
+	}
+	for _, d := range out.Dirs {
+		if d.Name == name {
+			return nil, d, nil
+		}
+	}
+	return nil, nil, &fs.PathError{Op: "stat", Path: path, Err: fs.ErrNotFound}
+}
@@ -0,0 +1,131 @@
+package client
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/basharal/filesystem/fspath"
+)
+
+// ConfigError lists all the problems of the servers and clusters given to New, so that a config
+// can be fixed at once: prefixes that aren't relative to the root (see Server), ranges that are
+// empty, overlap or leave gaps between servers (unless Opts.AllowGaps), cluster roots that aren't single dirs under
+// '/' or are used twice, and addresses that are malformed or used by more than one server.
+type ConfigError struct {
+	Problems []string
+}
+
+func (e *ConfigError) Error() string {
+	return fmt.Sprintf("invalid config: %s", strings.Join(e.Problems, "; "))
+}
+
+// validAddr returns an error if addr isn't a gRPC target the client can dial: host:port, or a
+// unix socket (i.e., unix:///tmp/fs.sock).
+func validAddr(addr string) error {
+	if addr == "" {
+		return fmt.Errorf("empty address")
+	}
+	if strings.HasPrefix(addr, "unix:") {
+		if strings.TrimPrefix(strings.TrimPrefix(addr, "unix:"), "//") == "" {
+			return fmt.Errorf("address %s has no socket path", addr)
+		}
+		return nil
+	}
+	host, port, err := net.SplitHostPort(strings.TrimPrefix(addr, "dns:///"))
+	if err != nil {
+		return fmt.Errorf("address %s must be host:port. %s", addr, err)
+	}
+	if host == "" || port == "" {
+		return fmt.Errorf("address %s must be host:port", addr)
+	}
+	return nil
+}
+
+// validateServers appends the problems of the servers of the cluster at root to problems.
+func validateServers(root string, servers []Server, allowGaps bool, problems []string) []string {
+	if len(servers) == 0 {
+		return append(problems, fmt.Sprintf("cluster %s has no servers", root))
+	}
+	var ranged []Server
+	for _, server := range servers {
+		for _, addr := range append(append([]string{server.Addr}, server.Addrs...), server.Replicas...) {
+			if err := validAddr(addr); err != nil {
+				problems = append(problems, fmt.Sprintf("server %s of cluster %s: %s", server.Addr, root, err))
+			}
+		}
+		if strings.HasPrefix(server.StartPrefix, "/") || strings.HasPrefix(server.EndPrefix, "/") {
+			problems = append(problems, fmt.Sprintf("server %s of cluster %s has prefixes [%q, %q), which must be relative to the root",
+				server.Addr, root, server.StartPrefix, server.EndPrefix))
+			continue
+		}
+		if server.StartPrefix != "" && server.EndPrefix != "" && server.StartPrefix >= server.EndPrefix {
+			problems = append(problems, fmt.Sprintf("server %s of cluster %s has an empty range [%s, %s)",
+				server.Addr, root, server.StartPrefix, server.EndPrefix))
+			continue
+		}
+		ranged = append(ranged, server)
+	}
+
+	// Empty start prefixes sort first, and empty end prefixes overlap any range after them.
+	sort.Slice(ranged, func(i, j int) bool { return ranged[i].StartPrefix < ranged[j].StartPrefix })
+	for i := 1; i < len(ranged); i++ {
+		prev, server := ranged[i-1], ranged[i]
+		switch {
+		case prev.EndPrefix == "" || prev.EndPrefix > server.StartPrefix:
+			end := prev.EndPrefix
+			if end == "" || server.EndPrefix != "" && server.EndPrefix < end {
+				end = server.EndPrefix
+			}
+			problems = append(problems, fmt.Sprintf("servers %s and %s of cluster %s overlap on [%s, %s)",
+				prev.Addr, server.Addr, root, server.StartPrefix, end))
+		case prev.EndPrefix < server.StartPrefix && !allowGaps:
+			problems = append(problems, fmt.Sprintf("no server of cluster %s serves [%s, %s) between %s and %s",
+				root, prev.EndPrefix, server.StartPrefix, prev.Addr, server.Addr))
+		}
+	}
+	return problems
+}
+
+// validate returns a *ConfigError if opts has problems.
+func validate(opts Opts) error {
+	var problems []string
+	switch {
+	case len(opts.Servers) != 0 && len(opts.Clusters) != 0:
+		problems = append(problems, "only one of servers and clusters can be set")
+	case len(opts.Clusters) == 0:
+		problems = validateServers(fspath.Root, opts.Servers, opts.AllowGaps, problems)
+	}
+	roots := make(map[string]bool)
+	for _, cluster := range opts.Clusters {
+		root := cluster.Root
+		if !fspath.IsAbs(root) || len(fspath.Split(root)) != 1 {
+			problems = append(problems, fmt.Sprintf("cluster root %s must be a single directory under /", root))
+		}
+		if roots[root] {
+			problems = append(problems, fmt.Sprintf("duplicate cluster root %s", root))
+		}
+		roots[root] = true
+		problems = validateServers(root, cluster.Servers, opts.AllowGaps, problems)
+	}
+
+	// Servers are told apart by address, so they can't share one, even across clusters.
+	seen := make(map[string]bool)
+	servers := append([]Server(nil), opts.Servers...)
+	for _, cluster := range opts.Clusters {
+		servers = append(servers, cluster.Servers...)
+	}
+	for _, server := range servers {
+		for _, addr := range append([]string{server.Addr}, server.Replicas...) {
+			if addr != "" && seen[addr] {
+				problems = append(problems, fmt.Sprintf("address %s is used by more than one server", addr))
+			}
+			seen[addr] = true
+		}
+	}
+	if len(problems) > 0 {
+		return &ConfigError{Problems: problems}
+	}
+	return nil
+}
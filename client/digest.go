@@ -0,0 +1,212 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"path/filepath"
+	"sync"
+
+	"github.com/basharal/filesystem/fs"
+	"github.com/basharal/filesystem/fspath"
+	"github.com/basharal/filesystem/proto/pb_filesystem"
+)
+
+// DigestFunc returns the digest of the file/dir at path and the digests of its entries, like
+// fs.FileSystem.Digest. Diff compares two of them.
+type DigestFunc func(ctx context.Context, path string) ([]byte, []fs.EntryDigest, error)
+
+// ChangeKind is how an entry differs between two trees.
+type ChangeKind int
+
+const (
+	// Added entries are only in the second tree.
+	Added ChangeKind = iota + 1
+	// Removed entries are only in the first tree.
+	Removed
+	// Modified entries are files with different content or entries of different kinds.
+	Modified
+)
+
+var changeKindNames = map[ChangeKind]string{
+	Added:    "added",
+	Removed:  "removed",
+	Modified: "modified",
+}
+
+func (k ChangeKind) String() string {
+	if s, ok := changeKindNames[k]; ok {
+		return s
+	}
+	return fmt.Sprintf("unknown(%d)", int(k))
+}
+
+// Change is an entry that differs between two trees. IsDir is the kind in the second tree, unless
+// it was removed.
+type Change struct {
+	Path  string
+	Kind  ChangeKind
+	IsDir bool
+}
+
+// Diff returns the entries that differ between two trees, ordered by path. Paths are relative to
+// the compared trees' roots, with a leading "/". Only dirs whose digests differ are descended into,
+// so comparing mostly equal trees takes few calls.
+func Diff(ctx context.Context, a, b DigestFunc) ([]Change, error) {
+	var diffs []Change
+	var diff func(path string) error
+	diff = func(path string) error {
+		sumA, entriesA, err := a(ctx, path)
+		if err != nil {
+			return err
+		}
+		sumB, entriesB, err := b(ctx, path)
+		if err != nil {
+			return err
+		}
+		if bytes.Equal(sumA, sumB) {
+			return nil
+		}
+		// Files have no entries.
+		if len(entriesA) == 0 && len(entriesB) == 0 {
+			diffs = append(diffs, Change{Path: path, Kind: Modified})
+			return nil
+		}
+		i, j := 0, 0
+		for i < len(entriesA) || j < len(entriesB) {
+			switch {
+			case j == len(entriesB) || i < len(entriesA) && entriesA[i].Name < entriesB[j].Name:
+				diffs = append(diffs, Change{Path: fspath.Join(path, entriesA[i].Name), Kind: Removed, IsDir: entriesA[i].IsDir})
+				i++
+			case i == len(entriesA) || entriesB[j].Name < entriesA[i].Name:
+				diffs = append(diffs, Change{Path: fspath.Join(path, entriesB[j].Name), Kind: Added, IsDir: entriesB[j].IsDir})
+				j++
+			default:
+				ea, eb := entriesA[i], entriesB[j]
+				i, j = i+1, j+1
+				child := fspath.Join(path, ea.Name)
+				switch {
+				case bytes.Equal(ea.Sum, eb.Sum) && ea.IsDir == eb.IsDir:
+				case ea.IsDir && eb.IsDir:
+					if err := diff(child); err != nil {
+						return err
+					}
+				default:
+					diffs = append(diffs, Change{Path: child, Kind: Modified, IsDir: eb.IsDir})
+				}
+			}
+		}
+		return nil
+	}
+	if err := diff(fspath.Root); err != nil {
+		return nil, err
+	}
+	return diffs, nil
+}
+
+// Digest returns the Merkle digest of the file/dir at path and the digests of its entries ordered
+// by name (see fs.FileSystem.Digest). The entries of dirs spanning servers are combined, so that
+// digests don't depend on how the namespace is sharded.
+func (c *Client) Digest(ctx context.Context, path string) ([]byte, []fs.EntryDigest, error) {
+	if c.virtualRoot(path) {
+		entries := make([]fs.EntryDigest, 0, len(c.clusters))
+		for _, cluster := range c.clusters {
+			sum, _, err := c.Digest(ctx, cluster.Root)
+			if err != nil {
+				return nil, nil, err
+			}
+			entries = append(entries, fs.EntryDigest{Name: cluster.Root[1:], IsDir: true, Sum: sum})
+		}
+		return fs.DirDigest(entries), entries, nil
+	}
+	shards, path, err := c.shardsForPath(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(shards) == 1 {
+		return c.digestAt(ctx, shards[0].addr, shards[0].client, path)
+	}
+
+	var mu sync.Mutex
+	var entries []fs.EntryDigest
+	me := fanOut(ctx, shards, true, func(ctx context.Context, s shard) error {
+		_, list, err := c.digestAt(ctx, s.addr, s.client, path)
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		entries = append(entries, list...)
+		mu.Unlock()
+		return nil
+	})
+	if me != nil {
+		return nil, nil, me
+	}
+	return fs.DirDigest(entries), entries, nil
+}
+
+// digestAt returns the digest of path on a single server.
+func (c *Client) digestAt(ctx context.Context, addr string, client pb_filesystem.FileSeverClient, path string) ([]byte, []fs.EntryDigest, error) {
+	if !c.supports(addr, FeatureDigest) {
+		return nil, nil, &CapabilityError{Addr: addr, Feature: FeatureDigest}
+	}
+	resp, err := client.Digest(ctx, &pb_filesystem.Path{Path: path})
+	if err != nil {
+		if missingRPC(err) {
+			return nil, nil, c.unsupported(addr, FeatureDigest)
+		}
+		return nil, nil, fromStatus(err)
+	}
+	entries := make([]fs.EntryDigest, 0, len(resp.Entries))
+	for _, e := range resp.Entries {
+		entries = append(entries, fs.EntryDigest{Name: e.Name, IsDir: e.IsDir, Sum: e.Sum})
+	}
+	return resp.Sum, entries, nil
+}
+
+// DigestFunc returns a DigestFunc for the tree at root.
+func (c *Client) DigestFunc(root string) DigestFunc {
+	return func(ctx context.Context, path string) ([]byte, []fs.EntryDigest, error) {
+		return c.Digest(ctx, fspath.Join(root, path))
+	}
+}
+
+// LocalDigestFunc returns a DigestFunc for the local tree at dir.
+func LocalDigestFunc(dir string) DigestFunc {
+	return func(ctx context.Context, path string) ([]byte, []fs.EntryDigest, error) {
+		return fs.DigestOS(filepath.Join(dir, filepath.FromSlash(path)))
+	}
+}
+
+// DiffReplicas compares path on the server owning it with each of the server's replicas, and
+// returns the entries that differ by replica address (added ones are only on the replica).
+// Replicas that are in sync are left out, so that the result can drive repairing the others.
+func (c *Client) DiffReplicas(ctx context.Context, path string) (map[string][]Change, error) {
+	cluster, _, err := c.clusterForPath(path)
+	if err != nil {
+		return nil, err
+	}
+	s, path, err := c.shardForPath("diff", path)
+	if err != nil {
+		return nil, err
+	}
+	at := func(addr string, client pb_filesystem.FileSeverClient) DigestFunc {
+		return func(ctx context.Context, p string) ([]byte, []fs.EntryDigest, error) {
+			return c.digestAt(ctx, addr, client, fspath.Join(path, p))
+		}
+	}
+	diffs := make(map[string][]Change)
+	for i, addr := range s.replicaAddrs {
+		list, err := Diff(ctx, at(s.addr, s.client), at(addr, s.replicas[i]))
+		if err != nil {
+			return nil, &ShardError{Addr: addr, Err: err}
+		}
+		for j := range list {
+			list[j].Path = joinRoot(cluster.Root, fspath.Join(path, list[j].Path))
+		}
+		if len(list) > 0 {
+			diffs[addr] = list
+		}
+	}
+	return diffs, nil
+}
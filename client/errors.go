@@ -0,0 +1,194 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/basharal/filesystem/fs"
+	"github.com/basharal/filesystem/proto/pb_filesystem"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// sentinels maps gRPC status codes returned by servers back to filesystem errors. The first one is
+// used for a code unless the PathError detail has the reason of another.
+var sentinels = []struct {
+	code codes.Code
+	err  error
+}{
+	{codes.NotFound, fs.ErrNotFound},
+	{codes.AlreadyExists, fs.ErrAlreadyExist},
+	{codes.InvalidArgument, fs.ErrInvalidName},
+	{codes.InvalidArgument, fs.ErrRejected},
+	{codes.Unimplemented, fs.ErrNotSupported},
+	{codes.FailedPrecondition, fs.ErrDirNotEmpty},
+	{codes.DeadlineExceeded, fs.ErrTimeout},
+	{codes.ResourceExhausted, fs.ErrLimitExceeded},
+	{codes.Aborted, fs.ErrBusy},
+	{codes.Aborted, fs.ErrConflict},
+	{codes.DataLoss, ErrChecksum},
+}
+
+// fromStatus converts gRPC statuses returned by servers back into filesystem errors, so that
+// callers can check them against the fs.Err* sentinels with errors.Is.
+func fromStatus(err error) error {
+	if err == nil {
+		return nil
+	}
+	st, ok := status.FromError(err)
+	if !ok {
+		return err
+	}
+	var pe *pb_filesystem.PathError
+	for _, detail := range st.Details() {
+		if d, ok := detail.(*pb_filesystem.PathError); ok {
+			pe = d
+			break
+		}
+	}
+	var sentinel error
+	for _, s := range sentinels {
+		if s.code != st.Code() {
+			continue
+		}
+		if sentinel == nil {
+			sentinel = s.err
+		}
+		if pe != nil && pe.Reason == s.err.Error() {
+			sentinel = s.err
+			break
+		}
+	}
+	if sentinel == nil {
+		return err
+	}
+	if pe != nil && pe.Existing != "" && sentinel == fs.ErrAlreadyExist {
+		return &fs.PathError{Op: pe.Op, Path: pe.Path, Err: &fs.ExistsError{Path: pe.Existing, IsDir: pe.ExistingDir}}
+	}
+	if pe != nil && pe.RejectedBy != "" {
+		rejection := &remoteError{msg: pe.Rejection, err: sentinel}
+		return &fs.PathError{Op: pe.Op, Path: pe.Path, Err: &fs.RejectedError{Validator: pe.RejectedBy, Offset: pe.RejectedAt, Err: rejection}}
+	}
+	if pe != nil {
+		return &fs.PathError{Op: pe.Op, Path: pe.Path, Err: sentinel}
+	}
+	return fmt.Errorf("%s: %w", st.Message(), sentinel)
+}
+
+// remoteError is an error of a server, keeping its message while matching the sentinel it was
+// returned with.
+type remoteError struct {
+	msg string
+	err error
+}
+
+func (e *remoteError) Error() string {
+	return e.msg
+}
+
+func (e *remoteError) Unwrap() error {
+	return e.err
+}
+
+// ErrNoServer is returned for top-level entries outside the ranges of all the servers of their
+// cluster, which happens when the first and last ranges aren't left open (see Server).
+var ErrNoServer = errors.New("no server serves the path")
+
+// errSpans is returned for operations on a single server given a path on several: the root of a
+// cluster or a dir above a boundary between servers.
+var errSpans = fmt.Errorf("the path spans multiple servers: %w", fs.ErrInvalidName)
+
+// ShardError is the error of a single server in an operation spanning multiple servers.
+type ShardError struct {
+	Addr string
+	Err  error
+}
+
+func (e *ShardError) Error() string {
+	return e.Addr + ": " + e.Err.Error()
+}
+
+func (e *ShardError) Unwrap() error {
+	return e.Err
+}
+
+// MultiError reports which servers succeeded and which failed in an operation spanning multiple
+// servers. Servers whose requests were canceled because of another's failure are in Canceled.
+type MultiError struct {
+	Succeeded []string
+	Failed    []*ShardError
+	Canceled  []string
+}
+
+func (e *MultiError) Error() string {
+	msgs := make([]string, 0, len(e.Failed))
+	for _, f := range e.Failed {
+		msgs = append(msgs, f.Error())
+	}
+	total := len(e.Succeeded) + len(e.Failed) + len(e.Canceled)
+	return fmt.Sprintf("%d of %d servers failed: %s", len(e.Failed), total, strings.Join(msgs, "; "))
+}
+
+// Unwrap returns the error of the first failed server, so that errors.Is works when a single
+// server failed, which is the common case.
+func (e *MultiError) Unwrap() error {
+	if len(e.Failed) == 0 {
+		return nil
+	}
+	return e.Failed[0]
+}
+
+// StatusError is the error of an operation a server answered with a FAILURE status rather than an
+// error status.
+type StatusError struct {
+	Addr   string
+	Op     string
+	Path   string
+	Reason string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%s %s failed on %s: %s", e.Op, e.Path, e.Addr, e.Reason)
+}
+
+// TransportError is the error of a write whose stream to a server broke (i.e., the connection
+// dropped or the server went away) rather than one the server failed. Uploads are staged by
+// servers until they're complete, so the write wasn't applied unless Complete is true, in which
+// case it may have been: retrying it then risks appending the content twice.
+type TransportError struct {
+	Addr string
+	// Complete is true if the whole content was sent before the stream broke.
+	Complete bool
+	Err      error
+}
+
+func (e *TransportError) Error() string {
+	return "stream to " + e.Addr + " broke: " + e.Err.Error()
+}
+
+func (e *TransportError) Unwrap() error {
+	return e.Err
+}
+
+// writeError converts err, which ended a write stream to the server at addr, into a
+// *TransportError if the stream broke, or a filesystem error otherwise. Streams canceled or timed
+// out through ctx didn't break.
+func writeError(ctx context.Context, addr string, complete bool, err error) error {
+	if err == nil {
+		return nil
+	}
+	switch status.Code(err) {
+	case codes.Unavailable, codes.Internal, codes.Canceled:
+		if ctx.Err() == nil {
+			return &TransportError{Addr: addr, Complete: complete, Err: err}
+		}
+	}
+	return fromStatus(err)
+}
+
+// canceled returns true if err is the result of canceling the request.
+func canceled(err error) bool {
+	return errors.Is(err, context.Canceled) || status.Code(err) == codes.Canceled
+}
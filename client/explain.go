@@ -0,0 +1,87 @@
+package client
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/basharal/filesystem/fspath"
+	"github.com/basharal/filesystem/proto/pb_filesystem"
+)
+
+// Route is a server a path is routed to, and why.
+type Route struct {
+	Addr     string
+	Replicas []string
+
+	// Cluster is the root of the cluster the path is under, and Path the path within the cluster
+	// that the server is called with.
+	Cluster string
+	Path    string
+
+	// StartPrefix and EndPrefix are the range the config gives the server. Reason says how the path
+	// matched it.
+	StartPrefix string
+	EndPrefix   string
+	Reason      string
+
+	// State is the state of the connection to the server (i.e., READY or TRANSIENT_FAILURE), or
+	// empty if the client isn't dialed.
+	State string
+
+	// Err is the server's answer to validating Path: nil if it serves Path, a *CapabilityError if it
+	// can't validate paths, or why it doesn't serve it (i.e., its range differs from the config).
+	Err error
+}
+
+// Explain returns the servers path is routed to and, for each, the result of having it validate
+// the path without doing anything with it. It's meant for debugging routing, i.e., when a server
+// rejects paths the config sends to it.
+func (c *Client) Explain(ctx context.Context, path string) ([]Route, error) {
+	cluster, rel, err := c.clusterForPath(path)
+	if err != nil {
+		return nil, err
+	}
+	var routes []Route
+	for _, server := range serversForPath(cluster, rel) {
+		route := Route{
+			Addr:        server.Addr,
+			Replicas:    server.Replicas,
+			Cluster:     cluster.Root,
+			Path:        rel,
+			StartPrefix: server.StartPrefix,
+			EndPrefix:   server.EndPrefix,
+			Reason:      fmt.Sprintf("%q is in [%s, %s)", rel[1:], server.StartPrefix, server.EndPrefix),
+		}
+		if rel == fspath.Root {
+			route.Reason = "the root spans all servers"
+		} else if !server.has(rel[1:]) {
+			route.Reason = fmt.Sprintf("paths under %q are in [%s, %s)", rel[1:], server.StartPrefix, server.EndPrefix)
+		}
+		c.mu.RLock()
+		conn, client := c.conns[server.Addr], c.clients[server.Addr]
+		c.mu.RUnlock()
+		if conn == nil {
+			route.Err = fmt.Errorf("not connected to server %s", server.Addr)
+			routes = append(routes, route)
+			continue
+		}
+		route.State = conn.GetState().String()
+		route.Err = c.validatePath(ctx, server.Addr, client, rel)
+		routes = append(routes, route)
+	}
+	return routes, nil
+}
+
+// validatePath has the server at addr validate path.
+func (c *Client) validatePath(ctx context.Context, addr string, client pb_filesystem.FileSeverClient, path string) error {
+	if !c.supports(addr, FeatureValidatePath) {
+		return &CapabilityError{Addr: addr, Feature: FeatureValidatePath}
+	}
+	if _, err := client.ValidatePath(ctx, &pb_filesystem.Path{Path: path}); err != nil {
+		if missingRPC(err) {
+			return c.unsupported(addr, FeatureValidatePath)
+		}
+		return fromStatus(err)
+	}
+	return nil
+}
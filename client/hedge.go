@@ -0,0 +1,85 @@
+package client
+
+import (
+	"context"
+	"time"
+
+	"github.com/basharal/filesystem/proto/pb_filesystem"
+)
+
+// hedged calls call against the server of s and, with hedging enabled and o allowing stale reads,
+// against one more of its replicas every hedge delay until one responds. Servers that are
+// unavailable or timed out are moved on from right away. Returns the first successful result and a
+// func to release it once it's consumed (i.e., the stream is read), which the caller must call. The
+// other calls are canceled.
+func (c *Client) hedged(ctx context.Context, s shard, o callOpts,
+	call func(context.Context, pb_filesystem.FileSeverClient) (interface{}, error)) (interface{}, func(), error) {
+	clients := []pb_filesystem.FileSeverClient{s.client}
+	// Replicas serve reads without a bound on staleness however far behind they are.
+	if o.maxStaleness > 0 {
+		clients = append(clients, s.replicas...)
+	}
+	if c.hedgeDelay == 0 || len(clients) == 1 {
+		ctx, cancel := context.WithCancel(ctx)
+		v, err := call(ctx, s.client)
+		if err != nil {
+			cancel()
+			return nil, nil, err
+		}
+		return v, cancel, nil
+	}
+
+	type result struct {
+		v   interface{}
+		err error
+		i   int
+	}
+	// Buffered so that the calls that lost don't block.
+	results := make(chan result, len(clients))
+	cancels := make([]context.CancelFunc, 0, len(clients))
+	launch := func() {
+		i := len(cancels)
+		ctx, cancel := context.WithCancel(ctx)
+		cancels = append(cancels, cancel)
+		go func() {
+			v, err := call(ctx, clients[i])
+			results <- result{v: v, err: err, i: i}
+		}()
+	}
+	cancelOthers := func(winner int) {
+		for i, cancel := range cancels {
+			if i != winner {
+				cancel()
+			}
+		}
+	}
+
+	launch()
+	pending := 1
+	timer := time.NewTimer(c.hedgeDelay)
+	defer timer.Stop()
+	var lastErr error
+	for pending > 0 {
+		select {
+		case <-timer.C:
+			if len(cancels) < len(clients) {
+				launch()
+				pending++
+				timer.Reset(c.hedgeDelay)
+			}
+		case r := <-results:
+			pending--
+			if r.err == nil {
+				cancelOthers(r.i)
+				return r.v, cancels[r.i], nil
+			}
+			lastErr = r.err
+			if tripsBreaker(r.err) && len(cancels) < len(clients) {
+				launch()
+				pending++
+			}
+		}
+	}
+	cancelOthers(-1)
+	return nil, nil, lastErr
+}
@@ -0,0 +1,229 @@
+// Package highlevel wraps a client.Client with helpers for common operations (reading/writing
+// whole files, walking and copying trees), so that applications don't have to manage streams and
+// temp files. Operations are retried when servers are temporarily unavailable.
+package highlevel
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/basharal/filesystem/client"
+	"github.com/basharal/filesystem/fs"
+	"github.com/basharal/filesystem/fspath"
+	"github.com/basharal/filesystem/proto/pb_filesystem"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	defaultRetries = 3
+	defaultBackoff = 100 * time.Millisecond
+)
+
+// SkipDir can be returned by a WalkFunc to skip the dir it was called with.
+var SkipDir = errors.New("skip this dir")
+
+type Opts struct {
+	// Retries is how many times an operation is retried after a retryable error (see Retryable).
+	// Defaults to 3. Negative disables retries.
+	Retries int
+
+	// Backoff is how long to wait before the first retry. It doubles on every retry. Defaults to
+	// 100ms.
+	Backoff time.Duration
+}
+
+// Client is thread-safe.
+type Client struct {
+	client  *client.Client
+	retries int
+	backoff time.Duration
+}
+
+// New returns a Client using c, which must be dialed already.
+func New(c *client.Client, opts Opts) *Client {
+	if opts.Retries == 0 {
+		opts.Retries = defaultRetries
+	}
+	if opts.Backoff == 0 {
+		opts.Backoff = defaultBackoff
+	}
+	return &Client{client: c, retries: opts.Retries, backoff: opts.Backoff}
+}
+
+// Retryable returns true if err is temporary: a server is unavailable (i.e., draining, out of its
+// memory budget or unreachable), the stream of a write broke, its circuit is open, or the file is
+// busy.
+func Retryable(err error) bool {
+	var te *client.TransportError
+	if errors.As(err, &te) {
+		return true
+	}
+	var st interface{ GRPCStatus() *status.Status }
+	if errors.As(err, &st) && st.GRPCStatus().Code() == codes.Unavailable {
+		return true
+	}
+	return errors.Is(err, client.ErrCircuitOpen) || errors.Is(err, fs.ErrBusy) || errors.Is(err, fs.ErrConflict)
+}
+
+// retry calls fn with the attempt number until it succeeds, fails with an error that isn't
+// retryable, runs out of retries or ctx is done.
+func (c *Client) retry(ctx context.Context, fn func(attempt int) error) error {
+	backoff := c.backoff
+	for attempt := 0; ; attempt++ {
+		err := fn(attempt)
+		if err == nil || attempt >= c.retries || !Retryable(err) {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// ReadBytes returns the content of the file at path.
+func (c *Client) ReadBytes(ctx context.Context, path string) ([]byte, error) {
+	var buf bytes.Buffer
+	err := c.retry(ctx, func(int) error {
+		buf.Reset()
+		_, err := c.client.Read(ctx, path, &buf)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// ReadString is like ReadBytes for text.
+func (c *Client) ReadString(ctx context.Context, path string) (string, error) {
+	data, err := c.ReadBytes(ctx, path)
+	return string(data), err
+}
+
+// WriteBytes writes data to the file at path, replacing the file if it exists. Its parent must
+// exist. The file is replaced by removing and creating it again, so readers may find it missing
+// or empty in the meantime.
+func (c *Client) WriteBytes(ctx context.Context, path string, data []byte) error {
+	err := c.retry(ctx, func(int) error {
+		return c.client.Remove(ctx, path)
+	})
+	if err != nil && !errors.Is(err, fs.ErrNotFound) {
+		return err
+	}
+	if err := c.create(ctx, path, false); err != nil {
+		return err
+	}
+	return c.retry(ctx, func(attempt int) error {
+		if attempt > 0 {
+			// Writes are appended, so one that made it to the server before failing mustn't be
+			// applied again.
+			file, _, err := c.client.Stat(ctx, path)
+			if err != nil {
+				return err
+			}
+			if file != nil && file.Size == int64(len(data)) {
+				return nil
+			}
+			if file == nil || file.Size != 0 {
+				return fmt.Errorf("%s was changed while being written: %w", path, fs.ErrConflict)
+			}
+		}
+		_, err := c.client.Write(ctx, path, bytes.NewReader(data))
+		return err
+	})
+}
+
+// create creates the file/dir at path. Retries that find it created by a previous attempt
+// succeed.
+func (c *Client) create(ctx context.Context, path string, isDir bool) error {
+	return c.retry(ctx, func(attempt int) error {
+		var err error
+		if isDir {
+			err = c.client.MakeDir(ctx, path)
+		} else {
+			err = c.client.CreateFile(ctx, path)
+		}
+		if attempt > 0 && errors.Is(err, fs.ErrAlreadyExist) {
+			return nil
+		}
+		return err
+	})
+}
+
+// WalkFunc is called with the absolute path of each file/dir WalkRemote visits. Returning SkipDir
+// for a dir skips its entries. Returning any other error stops the walk with it.
+type WalkFunc func(path string, entry *pb_filesystem.Entry) error
+
+// WalkRemote calls fn for the files/dirs under the dir at root, dirs before their entries and
+// entries in name order.
+func (c *Client) WalkRemote(ctx context.Context, root string, fn WalkFunc) error {
+	var entries []*pb_filesystem.Entry
+	err := c.retry(ctx, func(int) error {
+		var err error
+		entries, err = c.client.ListEntries(ctx, root)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		path := fspath.Join(root, e.Name)
+		err := fn(path, e)
+		if e.GetDir() == nil {
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		if err == SkipDir {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err := c.WalkRemote(ctx, path, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CopyTree copies the dir at src to a new dir at dst, with all the files/dirs under it, and
+// returns the number of copied files. Files are copied by the servers holding them when they hold
+// the copies too (see client.Client.Copy).
+func (c *Client) CopyTree(ctx context.Context, src, dst string) (int, error) {
+	if fspath.HasPrefix(dst, src) {
+		return 0, fmt.Errorf("can't copy %s into itself: %w", src, fs.ErrInvalidName)
+	}
+	if err := c.create(ctx, dst, true); err != nil {
+		return 0, err
+	}
+	copied := 0
+	err := c.WalkRemote(ctx, src, func(path string, e *pb_filesystem.Entry) error {
+		rel, _ := fspath.TrimPrefix(path, src)
+		target := fspath.Join(dst, rel)
+		if e.GetDir() != nil {
+			return c.create(ctx, target, true)
+		}
+		err := c.retry(ctx, func(attempt int) error {
+			err := c.client.Copy(ctx, path, target)
+			if attempt > 0 && errors.Is(err, fs.ErrAlreadyExist) {
+				return nil
+			}
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		copied++
+		return nil
+	})
+	return copied, err
+}
@@ -0,0 +1,208 @@
+package highlevel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/basharal/filesystem/client"
+	"github.com/basharal/filesystem/fs"
+	"github.com/basharal/filesystem/fs/osfs"
+	"github.com/basharal/filesystem/proto/pb_filesystem"
+	"github.com/basharal/filesystem/server"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// createTestClient returns a Client of a server for [a, {) started in-process. It serves a temp
+// dir, whose filesystem makes nested files/dirs like the trees copied.
+func createTestClient(t *testing.T) *Client {
+	t.Helper()
+	dir, err := osfs.New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := server.New(server.Opts{StartPrefix: "a", EndPrefix: "{", FileSystem: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := grpc.NewServer()
+	s.RegisterWith(g)
+	go g.Serve(lis)
+	t.Cleanup(g.Stop)
+	c, err := client.New(client.Opts{Servers: []client.Server{{StartPrefix: "a", EndPrefix: "{", Addr: lis.Addr().String()}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Dial(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return New(c, Opts{Backoff: time.Millisecond})
+}
+
+func TestRetryable(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{err: status.Error(codes.Unavailable, "draining"), want: true},
+		{err: fmt.Errorf("write: %w", status.Error(codes.Unavailable, "draining")), want: true},
+		{err: &client.TransportError{Err: errors.New("broken stream")}, want: true},
+		{err: client.ErrCircuitOpen, want: true},
+		{err: fs.ErrBusy, want: true},
+		{err: fs.ErrConflict, want: true},
+		{err: status.Error(codes.InvalidArgument, "bad path"), want: false},
+		{err: fs.ErrNotFound, want: false},
+		{err: context.DeadlineExceeded, want: false},
+	}
+	for _, tt := range tests {
+		if got := Retryable(tt.err); got != tt.want {
+			t.Errorf("Retryable(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestClient_Retry(t *testing.T) {
+	tests := []struct {
+		name         string
+		retries      int
+		err          error
+		wantAttempts int
+	}{
+		{name: "default", err: fs.ErrBusy, wantAttempts: 4},
+		{name: "one", retries: 1, err: fs.ErrBusy, wantAttempts: 2},
+		{name: "disabled", retries: -1, err: fs.ErrBusy, wantAttempts: 1},
+		{name: "not retryable", err: fs.ErrNotFound, wantAttempts: 1},
+		{name: "success", wantAttempts: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := New(nil, Opts{Retries: tt.retries, Backoff: time.Millisecond})
+			attempts := 0
+			err := c.retry(context.Background(), func(attempt int) error {
+				if attempt != attempts {
+					t.Errorf("retry() called attempt %d, want %d", attempt, attempts)
+				}
+				attempts++
+				return tt.err
+			})
+			if err != tt.err || attempts != tt.wantAttempts {
+				t.Errorf("retry() = %v after %d attempts, want %v after %d", err, attempts, tt.err, tt.wantAttempts)
+			}
+		})
+	}
+
+	// A done context stops the retries.
+	c := New(nil, Opts{Backoff: time.Hour})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	attempts := 0
+	err := c.retry(ctx, func(int) error {
+		attempts++
+		return fs.ErrBusy
+	})
+	if err != fs.ErrBusy || attempts != 1 {
+		t.Errorf("retry() = %v after %d attempts with a done context, want %v after 1", err, attempts, fs.ErrBusy)
+	}
+}
+
+func TestClient_WriteBytes(t *testing.T) {
+	c := createTestClient(t)
+	ctx := context.Background()
+	for _, content := range []string{"hello", "bye"} {
+		if err := c.WriteBytes(ctx, "/notes", []byte(content)); err != nil {
+			t.Fatalf("Client.WriteBytes() error = %v", err)
+		}
+		// The file is replaced, not appended to.
+		if got, err := c.ReadString(ctx, "/notes"); err != nil || got != content {
+			t.Errorf("Client.ReadString() = %q, %v, want %q", got, err, content)
+		}
+	}
+	if _, err := c.ReadBytes(ctx, "/missing"); !errors.Is(err, fs.ErrNotFound) {
+		t.Errorf("Client.ReadBytes(/missing) error = %v, want %v", err, fs.ErrNotFound)
+	}
+}
+
+func TestClient_WalkRemote(t *testing.T) {
+	c := createTestClient(t)
+	ctx := context.Background()
+	for _, dir := range []string{"/b", "/a"} {
+		if err := c.client.MakeDir(ctx, dir); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, file := range []string{"/a/y", "/a/x", "/b/z", "/c"} {
+		if err := c.client.CreateFile(ctx, file); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var visited []string
+	err := c.WalkRemote(ctx, "/", func(path string, e *pb_filesystem.Entry) error {
+		visited = append(visited, path)
+		if path == "/b" {
+			return SkipDir
+		}
+		return nil
+	})
+	// Dirs come before their entries, entries in name order, and /b/z is skipped.
+	if want := []string{"/a", "/a/x", "/a/y", "/b", "/c"}; err != nil || !reflect.DeepEqual(visited, want) {
+		t.Errorf("Client.WalkRemote() visited %v, %v, want %v", visited, err, want)
+	}
+
+	stop := errors.New("stop")
+	visited = nil
+	err = c.WalkRemote(ctx, "/", func(path string, e *pb_filesystem.Entry) error {
+		visited = append(visited, path)
+		if path == "/a/x" {
+			return stop
+		}
+		return nil
+	})
+	if want := []string{"/a", "/a/x"}; err != stop || !reflect.DeepEqual(visited, want) {
+		t.Errorf("Client.WalkRemote() visited %v, %v, want %v, %v", visited, err, want, stop)
+	}
+}
+
+func TestClient_CopyTree(t *testing.T) {
+	c := createTestClient(t)
+	ctx := context.Background()
+	for _, dir := range []string{"/src", "/src/sub"} {
+		if err := c.client.MakeDir(ctx, dir); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, file := range []string{"/src/a", "/src/sub/b"} {
+		if err := c.WriteBytes(ctx, file, []byte(file)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	n, err := c.CopyTree(ctx, "/src", "/dst")
+	if err != nil || n != 2 {
+		t.Fatalf("Client.CopyTree() = %d, %v, want 2 files copied", n, err)
+	}
+	for _, file := range []string{"/src/a", "/src/sub/b"} {
+		copied := "/dst" + file[len("/src"):]
+		if got, err := c.ReadString(ctx, copied); err != nil || got != file {
+			t.Errorf("Client.CopyTree() copied %q, %v to %s, want %q", got, err, copied, file)
+		}
+	}
+
+	if _, err := c.CopyTree(ctx, "/src", "/src/sub/copy"); !errors.Is(err, fs.ErrInvalidName) {
+		t.Errorf("Client.CopyTree() into itself error = %v, want %v", err, fs.ErrInvalidName)
+	}
+	if _, err := c.CopyTree(ctx, "/src", "/dst"); !errors.Is(err, fs.ErrAlreadyExist) {
+		t.Errorf("Client.CopyTree() to an existing dir error = %v, want %v", err, fs.ErrAlreadyExist)
+	}
+}
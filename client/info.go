@@ -0,0 +1,156 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/basharal/filesystem/proto/pb_filesystem"
+	"github.com/golang/glog"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Optional features servers may advertise. These must match the ones in the server package.
+const (
+	FeatureAdmin        = "admin"
+	FeatureCopy         = "copy"
+	FeatureCRC32C       = "crc32c"
+	FeatureDeletePrefix = "delete_prefix"
+	FeatureDigest       = "digest"
+	FeatureDirUsage     = "dir_usage"
+	FeatureListEntries  = "list_entries"
+	FeatureManifest     = "manifest"
+	FeatureParents      = "parents"
+	FeatureSearch       = "search"
+	FeatureSnapshots    = "snapshots"
+	FeatureStat         = "stat"
+	FeatureTouch        = "touch"
+	FeatureValidatePath = "validate_path"
+)
+
+// infoTimeout bounds how long Dial waits for the info of a server.
+const infoTimeout = 2 * time.Second
+
+// queryInfos gets the info of every server in clients. Servers that don't implement GetServerInfo
+// predate all optional features, so they get an empty info. Servers that can't be queried are
+// left out and assumed to support everything.
+func queryInfos(ctx context.Context, clients map[string]pb_filesystem.FileSeverClient) map[string]*pb_filesystem.ServerInfo {
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	infos := make(map[string]*pb_filesystem.ServerInfo)
+	for addr, client := range clients {
+		wg.Add(1)
+		go func(addr string, client pb_filesystem.FileSeverClient) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(ctx, infoTimeout)
+			defer cancel()
+			info, err := client.GetServerInfo(ctx, &pb_filesystem.ServerInfoRequest{})
+			if status.Code(err) == codes.Unimplemented {
+				info, err = &pb_filesystem.ServerInfo{}, nil
+			}
+			if err != nil {
+				glog.Warningf("Failed to get info of server %s. %s\n", addr, err)
+				return
+			}
+			mu.Lock()
+			infos[addr] = info
+			mu.Unlock()
+		}(addr, client)
+	}
+	wg.Wait()
+	return infos
+}
+
+// ServerInfo returns the info the server at addr reported when dialed, or nil if it's unknown.
+func (c *Client) ServerInfo(addr string) *pb_filesystem.ServerInfo {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.infos[addr]
+}
+
+// RefreshServerInfo queries the info of every server again, i.e., for the age of their last
+// snapshots. Servers that can't be queried keep their previous info.
+func (c *Client) RefreshServerInfo(ctx context.Context) {
+	c.mu.RLock()
+	clients := c.clients
+	c.mu.RUnlock()
+	infos := queryInfos(ctx, clients)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.infos == nil {
+		c.infos = make(map[string]*pb_filesystem.ServerInfo)
+	}
+	for addr, info := range infos {
+		c.infos[addr] = info
+	}
+}
+
+// CheckTopology queries the info of every server, including replicas, and returns a *ShardError
+// for each one that can't be reached or doesn't serve the range the config gives it, ordered by
+// address. Servers older than 1.1 don't report their range and are reported as well.
+func (c *Client) CheckTopology(ctx context.Context) []*ShardError {
+	c.mu.RLock()
+	clients := c.clients
+	c.mu.RUnlock()
+	infos := queryInfos(ctx, clients)
+
+	var mismatches []*ShardError
+	check := func(addr string, server Server) {
+		info, ok := infos[addr]
+		switch {
+		case !ok:
+			mismatches = append(mismatches, &ShardError{Addr: addr, Err: fmt.Errorf("unreachable")})
+		case info.Version == "":
+			mismatches = append(mismatches, &ShardError{Addr: addr, Err: fmt.Errorf("doesn't report its range (pre-1.1)")})
+		case info.StartPrefix != server.StartPrefix || info.EndPrefix != server.EndPrefix:
+			mismatches = append(mismatches, &ShardError{Addr: addr, Err: fmt.Errorf("serves [%s, %s) instead of [%s, %s)",
+				info.StartPrefix, info.EndPrefix, server.StartPrefix, server.EndPrefix)})
+		}
+	}
+	for _, cluster := range c.clusters {
+		for _, server := range cluster.Servers {
+			check(server.Addr, server)
+			for _, replica := range server.Replicas {
+				check(replica, server)
+			}
+		}
+	}
+	sort.Slice(mismatches, func(i, j int) bool { return mismatches[i].Addr < mismatches[j].Addr })
+	return mismatches
+}
+
+// Addrs returns the addresses of all the servers, including replicas, ordered.
+func (c *Client) Addrs() []string {
+	var addrs []string
+	for _, cluster := range c.clusters {
+		for _, server := range cluster.Servers {
+			addrs = append(addrs, server.Addr)
+			addrs = append(addrs, server.Replicas...)
+		}
+	}
+	sort.Strings(addrs)
+	return addrs
+}
+
+// supports returns whether the server at addr supports feature. Servers whose info is unknown are
+// assumed to support it until a call shows otherwise.
+func (c *Client) supports(addr, feature string) bool {
+	c.mu.RLock()
+	info, missing := c.infos[addr], c.missing[addr][feature]
+	c.mu.RUnlock()
+	if missing {
+		return false
+	}
+	if info == nil {
+		return true
+	}
+	for _, f := range info.Features {
+		if f == feature {
+			return true
+		}
+	}
+	return false
+}
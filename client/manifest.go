@@ -0,0 +1,65 @@
+package client
+
+import (
+	"bytes"
+	"context"
+
+	"github.com/basharal/filesystem/fs"
+	"github.com/basharal/filesystem/proto/pb_filesystem"
+)
+
+// ApplyManifest converges the dir at path to m at once on the server holding it (see
+// fs.FileSystem.ApplyManifest) and returns what changed, or would change with dryRun. Sources are
+// absolute, and those on other servers are read here and sent as content. Dirs spanning servers
+// can't be converged at once, so they fail like other single-server operations.
+func (c *Client) ApplyManifest(ctx context.Context, path string, m fs.Manifest, dryRun bool) (fs.ManifestResult, error) {
+	cluster, _, err := c.clusterForPath(path)
+	if err != nil {
+		return fs.ManifestResult{}, err
+	}
+	s, path, err := c.shardForPath("apply", path)
+	if err != nil {
+		return fs.ManifestResult{}, err
+	}
+	if !c.supports(s.addr, FeatureManifest) {
+		return fs.ManifestResult{}, &CapabilityError{Addr: s.addr, Feature: FeatureManifest}
+	}
+
+	req := &pb_filesystem.ApplyManifestRequest{Path: path, Dirs: m.Dirs, DryRun: dryRun}
+	for _, f := range m.Files {
+		file := &pb_filesystem.ManifestFile{Path: f.Path, Content: f.Content, Sha256: f.SHA256}
+		if f.Source != "" {
+			src, srcPath, err := c.shardForPath("apply", f.Source)
+			if err != nil {
+				return fs.ManifestResult{}, err
+			}
+			if src.addr == s.addr {
+				file.Source = srcPath
+			} else {
+				var buf bytes.Buffer
+				if _, err := c.Read(ctx, f.Source, &buf); err != nil {
+					return fs.ManifestResult{}, err
+				}
+				file.Content = buf.Bytes()
+			}
+		}
+		req.Files = append(req.Files, file)
+	}
+	out, err := s.client.ApplyManifest(ctx, req)
+	if missingRPC(err) {
+		return fs.ManifestResult{}, c.unsupported(s.addr, FeatureManifest)
+	}
+	if err != nil {
+		return fs.ManifestResult{}, fromStatus(err)
+	}
+	result := fs.ManifestResult{}
+	for _, list := range []struct {
+		from []string
+		to   *[]string
+	}{{out.Created, &result.Created}, {out.Updated, &result.Updated}, {out.Removed, &result.Removed}} {
+		for _, p := range list.from {
+			*list.to = append(*list.to, joinRoot(cluster.Root, p))
+		}
+	}
+	return result, nil
+}
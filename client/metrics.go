@@ -0,0 +1,228 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+)
+
+// latencyBounds are the upper bounds of the latency histogram buckets. The last bucket has no bound.
+var latencyBounds = []time.Duration{
+	time.Millisecond, 5 * time.Millisecond, 10 * time.Millisecond, 25 * time.Millisecond,
+	50 * time.Millisecond, 100 * time.Millisecond, 250 * time.Millisecond, 500 * time.Millisecond,
+	time.Second, 2500 * time.Millisecond, 5 * time.Second, 10 * time.Second,
+}
+
+// Metrics are the per-RPC counters and latency histograms of a client. It's an expvar.Var, so
+// embedding applications can export it with expvar.Publish and scrape it from /debug/vars.
+type Metrics struct {
+	mu      sync.Mutex
+	methods map[string]*MethodStats
+	retries int64
+	// checksumErrors are the chunks of downloads that didn't match their checksum.
+	checksumErrors int64
+}
+
+// MethodStats are the metrics of a single RPC method, across all servers.
+type MethodStats struct {
+	Calls         int64 `json:"calls"`
+	Failures      int64 `json:"failures"`
+	BytesSent     int64 `json:"bytes_sent"`
+	BytesReceived int64 `json:"bytes_received"`
+
+	// Latency counts calls by duration. Latency[i] are the calls that took up to
+	// LatencyBounds()[i], and the last one the ones that took longer.
+	Latency      []int64       `json:"latency_buckets"`
+	TotalLatency time.Duration `json:"total_latency_ns"`
+}
+
+// LatencyBounds returns the upper bounds of the MethodStats.Latency buckets.
+func LatencyBounds() []time.Duration {
+	return append([]time.Duration(nil), latencyBounds...)
+}
+
+// Mean returns the mean latency of the calls.
+func (s MethodStats) Mean() time.Duration {
+	if s.Calls == 0 {
+		return 0
+	}
+	return s.TotalLatency / time.Duration(s.Calls)
+}
+
+// Quantile returns the upper bound of the bucket the q (i.e., 0.99) quantile of latency falls in.
+// Calls slower than the last bound are reported as twice the last bound.
+func (s MethodStats) Quantile(q float64) time.Duration {
+	target := int64(q*float64(s.Calls) + 0.5)
+	seen := int64(0)
+	for i, n := range s.Latency {
+		seen += n
+		if seen >= target && n > 0 {
+			if i < len(latencyBounds) {
+				return latencyBounds[i]
+			}
+			break
+		}
+	}
+	if seen == 0 {
+		return 0
+	}
+	return 2 * latencyBounds[len(latencyBounds)-1]
+}
+
+func newMetrics() *Metrics {
+	return &Metrics{methods: make(map[string]*MethodStats)}
+}
+
+// Snapshot returns a copy of the metrics of each method.
+func (m *Metrics) Snapshot() map[string]MethodStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	snapshot := make(map[string]MethodStats, len(m.methods))
+	for method, s := range m.methods {
+		c := *s
+		c.Latency = append([]int64(nil), s.Latency...)
+		snapshot[method] = c
+	}
+	return snapshot
+}
+
+// Retries returns how many times another address of a server was tried after failing to dial one.
+func (m *Metrics) Retries() int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.retries
+}
+
+// ChecksumErrors returns how many chunks of downloads didn't match their checksum (see
+// Opts.Checksums). Servers count the ones of uploads.
+func (m *Metrics) ChecksumErrors() int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.checksumErrors
+}
+
+// String returns the metrics as JSON, as required by expvar.Var.
+func (m *Metrics) String() string {
+	b, err := json.Marshal(struct {
+		Methods        map[string]MethodStats `json:"methods"`
+		Retries        int64                  `json:"retries"`
+		ChecksumErrors int64                  `json:"checksum_errors"`
+	}{m.Snapshot(), m.Retries(), m.ChecksumErrors()})
+	if err != nil {
+		return "{}"
+	}
+	return string(b)
+}
+
+func (m *Metrics) retried() {
+	m.mu.Lock()
+	m.retries++
+	m.mu.Unlock()
+}
+
+func (m *Metrics) checksumFailed() {
+	m.mu.Lock()
+	m.checksumErrors++
+	m.mu.Unlock()
+}
+
+// stats returns the metrics of method, creating them if needed. m.mu must be held.
+func (m *Metrics) stats(method string) *MethodStats {
+	// Full methods are /package.Service/Method.
+	method = method[strings.LastIndex(method, "/")+1:]
+	s, ok := m.methods[method]
+	if !ok {
+		s = &MethodStats{Latency: make([]int64, len(latencyBounds)+1)}
+		m.methods[method] = s
+	}
+	return s
+}
+
+func (m *Metrics) observe(method string, latency time.Duration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s := m.stats(method)
+	s.Calls++
+	if err != nil {
+		s.Failures++
+	}
+	s.TotalLatency += latency
+	bucket := len(latencyBounds)
+	for i, bound := range latencyBounds {
+		if latency <= bound {
+			bucket = i
+			break
+		}
+	}
+	s.Latency[bucket]++
+}
+
+// transferred counts the file bytes of msg if it carries any.
+func (m *Metrics) transferred(method string, msg interface{}, sent bool) {
+	payload, ok := msg.(interface{ GetData() []byte })
+	if !ok {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s := m.stats(method)
+	if sent {
+		s.BytesSent += int64(len(payload.GetData()))
+	} else {
+		s.BytesReceived += int64(len(payload.GetData()))
+	}
+}
+
+func (m *Metrics) unaryInterceptor(ctx context.Context, method string, req, reply interface{},
+	cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	start := time.Now()
+	err := invoker(ctx, method, req, reply, cc, opts...)
+	m.observe(method, time.Since(start), err)
+	return err
+}
+
+func (m *Metrics) streamInterceptor(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn,
+	method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	start := time.Now()
+	stream, err := streamer(ctx, desc, cc, method, opts...)
+	if err != nil {
+		m.observe(method, time.Since(start), err)
+		return nil, err
+	}
+	return &metricsStream{ClientStream: stream, metrics: m, method: method, start: start}, nil
+}
+
+// metricsStream counts the bytes of a stream and observes its latency once it ends.
+type metricsStream struct {
+	grpc.ClientStream
+	metrics *Metrics
+	method  string
+	start   time.Time
+	once    sync.Once
+}
+
+func (s *metricsStream) SendMsg(msg interface{}) error {
+	err := s.ClientStream.SendMsg(msg)
+	if err == nil {
+		s.metrics.transferred(s.method, msg, true)
+	}
+	return err
+}
+
+func (s *metricsStream) RecvMsg(msg interface{}) error {
+	err := s.ClientStream.RecvMsg(msg)
+	switch {
+	case err == nil:
+		s.metrics.transferred(s.method, msg, false)
+	case err == io.EOF:
+		s.once.Do(func() { s.metrics.observe(s.method, time.Since(s.start), nil) })
+	default:
+		s.once.Do(func() { s.metrics.observe(s.method, time.Since(s.start), err) })
+	}
+	return err
+}
@@ -0,0 +1,36 @@
+package client
+
+import "io"
+
+// Progress calls fn with the number of bytes transferred so far as Read, Write, ReadFile and
+// WriteFile transfer chunks, i.e., to show the progress of large files. fn is called from the
+// calling goroutine and should be quick.
+func Progress(fn func(transferred int64)) CallOption {
+	return func(o *callOpts) {
+		o.progress = fn
+	}
+}
+
+// progressWriter reports the bytes written through it to fn.
+type progressWriter struct {
+	w  io.Writer
+	fn func(int64)
+	n  int64
+}
+
+// withProgress returns w reporting to o's progress callback, or w itself without one.
+func (o callOpts) withProgress(w io.Writer) io.Writer {
+	if o.progress == nil {
+		return w
+	}
+	return &progressWriter{w: w, fn: o.progress}
+}
+
+func (pw *progressWriter) Write(p []byte) (int, error) {
+	n, err := pw.w.Write(p)
+	if n > 0 {
+		pw.n += int64(n)
+		pw.fn(pw.n)
+	}
+	return n, err
+}
@@ -0,0 +1,80 @@
+package client
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/basharal/filesystem/proto/pb_filesystem"
+)
+
+// Search returns up to max files under path whose content best matches query, best first (see
+// server.Opts.ContentIndex). The hits of dirs spanning servers are merged by score, though scores
+// are only roughly comparable across servers since each ranks against its own files. Failures are
+// reported like ListDir's.
+func (c *Client) Search(ctx context.Context, path, query string, max int) ([]*pb_filesystem.SearchHit, error) {
+	if c.virtualRoot(path) {
+		combined := make([]*pb_filesystem.SearchHit, 0)
+		for _, cluster := range c.clusters {
+			hits, err := c.Search(ctx, cluster.Root, query, max)
+			if err != nil {
+				return nil, err
+			}
+			combined = append(combined, hits...)
+		}
+		return bestHits(combined, max), nil
+	}
+	cluster, _, err := c.clusterForPath(path)
+	if err != nil {
+		return nil, err
+	}
+	shards, path, err := c.shardsForPath(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var mu sync.Mutex
+	combined := make([]*pb_filesystem.SearchHit, 0)
+	req := &pb_filesystem.SearchRequest{Path: path, Query: query, MaxResults: int64(max)}
+	me := fanOut(ctx, shards, !c.partialResults, func(ctx context.Context, s shard) error {
+		if !c.supports(s.addr, FeatureSearch) {
+			return &CapabilityError{Addr: s.addr, Feature: FeatureSearch}
+		}
+		out, err := s.client.Search(ctx, req)
+		if missingRPC(err) {
+			return c.unsupported(s.addr, FeatureSearch)
+		}
+		if err != nil {
+			return fromStatus(err)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		for _, hit := range out.Hits {
+			hit.Path = joinRoot(cluster.Root, hit.Path)
+			combined = append(combined, hit)
+		}
+		return nil
+	})
+	if me != nil && !c.partialResults {
+		return nil, me
+	}
+	combined = bestHits(combined, max)
+	if me != nil {
+		return combined, me
+	}
+	return combined, nil
+}
+
+// bestHits returns up to max of hits, best first.
+func bestHits(hits []*pb_filesystem.SearchHit, max int) []*pb_filesystem.SearchHit {
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Score != hits[j].Score {
+			return hits[i].Score > hits[j].Score
+		}
+		return hits[i].Path < hits[j].Path
+	})
+	if max > 0 && len(hits) > max {
+		hits = hits[:max]
+	}
+	return hits
+}
@@ -0,0 +1,38 @@
+package client
+
+import (
+	"io"
+	"os"
+)
+
+// SizeHint tells the server that Write is about to append n bytes, so that it can make room for
+// them at once rather than growing the file as chunks arrive. It's only a hint: the upload isn't
+// checked against it. Without it, the size of readers that know it (i.e., *bytes.Reader and
+// regular *os.File) is sent.
+func SizeHint(n int64) CallOption {
+	return func(o *callOpts) {
+		o.sizeHint = n
+	}
+}
+
+// sizeOf returns the hinted size of what's left in r, or 0 if it isn't known.
+func (o callOpts) sizeOf(r io.Reader) int64 {
+	if o.sizeHint > 0 {
+		return o.sizeHint
+	}
+	switch r := r.(type) {
+	case interface{ Len() int }:
+		return int64(r.Len())
+	case *os.File:
+		info, err := r.Stat()
+		if err != nil || !info.Mode().IsRegular() {
+			return 0
+		}
+		offset, err := r.Seek(0, io.SeekCurrent)
+		if err != nil || offset > info.Size() {
+			return 0
+		}
+		return info.Size() - offset
+	}
+	return 0
+}
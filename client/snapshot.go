@@ -0,0 +1,68 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/basharal/filesystem/proto/pb_filesystem"
+)
+
+// Snapshots returns when the server owning path took the snapshots it keeps, oldest first.
+func (c *Client) Snapshots(ctx context.Context, path string) ([]time.Time, error) {
+	s, _, err := c.shardForPath("snapshots", path)
+	if err != nil {
+		return nil, err
+	}
+	if !c.supports(s.addr, FeatureSnapshots) {
+		return nil, &CapabilityError{Addr: s.addr, Feature: FeatureSnapshots}
+	}
+	list, err := s.client.ListSnapshots(ctx, &pb_filesystem.ListSnapshotsRequest{})
+	if err != nil {
+		if missingRPC(err) {
+			return nil, c.unsupported(s.addr, FeatureSnapshots)
+		}
+		return nil, fromStatus(err)
+	}
+	taken := make([]time.Time, 0, len(list.TakenUnixMs))
+	for _, ms := range list.TakenUnixMs {
+		taken = append(taken, time.Unix(0, ms*int64(time.Millisecond)))
+	}
+	return taken, nil
+}
+
+// RestoreSnapshot restores path (with everything under it) to target from the latest snapshot
+// taken at or before at, leaving the rest of the filesystem as is. A zero at restores from the
+// latest snapshot and an empty target restores path in place. Both paths must be on the same
+// server. It returns the number of restored files/dirs and when the snapshot was taken.
+func (c *Client) RestoreSnapshot(ctx context.Context, path, target string, at time.Time) (int64, time.Time, error) {
+	if target == "" {
+		target = path
+	}
+	s, path, err := c.shardForPath("restore", path)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	targetShard, target, err := c.shardForPath("restore", target)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	if s.addr != targetShard.addr {
+		return 0, time.Time{}, fmt.Errorf("path and target must be on a single server")
+	}
+	if !c.supports(s.addr, FeatureSnapshots) {
+		return 0, time.Time{}, &CapabilityError{Addr: s.addr, Feature: FeatureSnapshots}
+	}
+	req := &pb_filesystem.RestoreRequest{Path: path, Target: target}
+	if !at.IsZero() {
+		req.AtUnixMs = at.UnixNano() / int64(time.Millisecond)
+	}
+	resp, err := s.client.RestoreSnapshot(ctx, req)
+	if err != nil {
+		if missingRPC(err) {
+			return 0, time.Time{}, c.unsupported(s.addr, FeatureSnapshots)
+		}
+		return 0, time.Time{}, fromStatus(err)
+	}
+	return resp.Restored, time.Unix(0, resp.TakenUnixMs*int64(time.Millisecond)), nil
+}
@@ -0,0 +1,61 @@
+package client
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/basharal/filesystem/proto/pb_filesystem"
+	"github.com/golang/glog"
+)
+
+// CallOption changes how a single read or write is done. See MaxStaleness, Progress and Summary.
+type CallOption func(*callOpts)
+
+type callOpts struct {
+	maxStaleness time.Duration
+	progress     func(int64)
+	summary      *TransferSummary
+	sizeHint     int64
+	parents      bool
+}
+
+func newCallOpts(opts []CallOption) callOpts {
+	var o callOpts
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// MaxStaleness lets reads be served by the replicas of a server (see Server.Replicas) as long as
+// they're at most d behind it, spreading reads over them. Replicas further behind, or that haven't
+// caught up yet, fail the read and it's done by the server instead.
+func MaxStaleness(d time.Duration) CallOption {
+	return func(o *callOpts) {
+		o.maxStaleness = d
+	}
+}
+
+// path returns the request of reading path with o.
+func (o callOpts) path(path string) *pb_filesystem.Path {
+	return &pb_filesystem.Path{Path: path, MaxStalenessMs: o.maxStaleness.Milliseconds()}
+}
+
+// read calls call against one of the replicas of s if o allows stale reads, going through them in
+// turn, and against the server of s (see hedged) if there are none or the replica fails. Returns
+// like hedged.
+func (c *Client) read(ctx context.Context, s shard, o callOpts,
+	call func(context.Context, pb_filesystem.FileSeverClient) (interface{}, error)) (interface{}, func(), error) {
+	if o.maxStaleness > 0 && len(s.replicas) > 0 {
+		i := int(atomic.AddUint32(&c.nextReplica, 1) % uint32(len(s.replicas)))
+		ctx, cancel := context.WithCancel(ctx)
+		v, err := call(ctx, s.replicas[i])
+		if err == nil {
+			return v, cancel, nil
+		}
+		cancel()
+		glog.V(1).Infof("Replica %s failed a read, reading from %s. %s\n", s.replicaAddrs[i], s.addr, err)
+	}
+	return c.hedged(ctx, s, o, call)
+}
@@ -0,0 +1,98 @@
+package client
+
+import (
+	"fmt"
+	"hash/crc32"
+	"strconv"
+	"time"
+
+	"google.golang.org/grpc/metadata"
+)
+
+// Trailing metadata keys of the summary servers attach to the reads and writes they complete.
+// These must match the ones in the server package.
+const (
+	summaryBytes      = "fs-bytes"
+	summaryChunks     = "fs-chunks"
+	summaryCRC32C     = "fs-crc32c"
+	summaryServerTime = "fs-server-time-us"
+)
+
+// TransferSummary is what a server reports of a read or write it completed. The client checks it
+// against what it received or sent, failing the transfer with ErrChecksum if they differ.
+type TransferSummary struct {
+	Bytes  int64
+	Chunks int64
+	// CRC32C is the CRC-32C (Castagnoli) of the whole content.
+	CRC32C uint32
+	// ServerTime is how long the server spent on the transfer.
+	ServerTime time.Duration
+}
+
+// Summary fills s with the server's summary of a read or write once it's confirmed (see
+// TransferSummary). s is left zero for servers that don't send summaries.
+func Summary(s *TransferSummary) CallOption {
+	return func(o *callOpts) {
+		o.summary = s
+	}
+}
+
+// tally is what the client sent or received of a transfer, to check against the server's
+// summary.
+type tally struct {
+	bytes int64
+	crc   uint32
+}
+
+func (t *tally) add(data []byte) {
+	t.bytes += int64(len(data))
+	t.crc = crc32.Update(t.crc, castagnoli, data)
+}
+
+// parseSummary returns the summary in trailer, and false if there's none (i.e., older servers).
+func parseSummary(trailer metadata.MD) (TransferSummary, bool, error) {
+	get := func(key string) string {
+		if values := trailer.Get(key); len(values) > 0 {
+			return values[0]
+		}
+		return ""
+	}
+	if get(summaryBytes) == "" {
+		return TransferSummary{}, false, nil
+	}
+	var s TransferSummary
+	var err error
+	if s.Bytes, err = strconv.ParseInt(get(summaryBytes), 10, 64); err != nil {
+		return s, false, fmt.Errorf("invalid transfer summary: %w", err)
+	}
+	if s.Chunks, err = strconv.ParseInt(get(summaryChunks), 10, 64); err != nil {
+		return s, false, fmt.Errorf("invalid transfer summary: %w", err)
+	}
+	crc, err := strconv.ParseUint(get(summaryCRC32C), 10, 32)
+	if err != nil {
+		return s, false, fmt.Errorf("invalid transfer summary: %w", err)
+	}
+	s.CRC32C = uint32(crc)
+	us, err := strconv.ParseInt(get(summaryServerTime), 10, 64)
+	if err != nil {
+		return s, false, fmt.Errorf("invalid transfer summary: %w", err)
+	}
+	s.ServerTime = time.Duration(us) * time.Microsecond
+	return s, true, nil
+}
+
+// confirm checks the summary in trailer against t, and fills o's summary with it.
+func (o callOpts) confirm(addr, path string, trailer metadata.MD, t tally) error {
+	s, ok, err := parseSummary(trailer)
+	if err != nil || !ok {
+		return err
+	}
+	if s.Bytes != t.bytes || s.CRC32C != t.crc {
+		return fmt.Errorf("%s: %s reported %d bytes with CRC-32C %08x, but the client has %d with %08x: %w",
+			path, addr, s.Bytes, s.CRC32C, t.bytes, t.crc, ErrChecksum)
+	}
+	if o.summary != nil {
+		*o.summary = s
+	}
+	return nil
+}
@@ -3,155 +3,182 @@ package main
 import (
 	"context"
 	"fmt"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/basharal/filesystem/cli"
 	"github.com/basharal/filesystem/client"
+	"github.com/basharal/filesystem/output"
 	"github.com/basharal/filesystem/proto/pb_filesystem"
-	"github.com/fatih/color"
 )
 
-type handlerFunc func(ctx context.Context, args []string) error
-
-type cmdHandler struct {
-	usage   string
-	handler handlerFunc
-}
-
+// commands are the ones only the distributed CLI has. The shared ones are in cli.Builtins.
 type commands struct {
-	fs        *client.Client
-	supported map[string]cmdHandler
-}
-
-func newCommands(client *client.Client) commands {
-	c := commands{
-		fs: client,
-	}
-	supported := map[string]cmdHandler{
-		"add":   {"add creates an empty file (i.e., add /foo)", c.add},
-		"ls":    {"lists directory content at path (or current dir)", c.ls},
-		"mkdir": {"creates a new directory (i.e., mkdir foo)", c.mkDir},
-		"read": {"reads from in-memory filesystem into local filesystem. " +
-			"will truncate the local file (i.e., read /bar /tmp/bar", c.read},
-		"rm": {"removes a file/directory(if empty) (i.e., rm foo)", c.rm},
-		"write": {"reads from local filesystem and writes into in-memory filesystem. " +
-			"will append (i.e., write /tmp/bar /bar", c.write},
-	}
-	c.supported = supported
-	return c
+	fs *client.Client
+	// reads are the options of listing and reading files.
+	reads []client.CallOption
 }
 
-func (c commands) Supported() map[string]string {
-	s := make(map[string]string, len(c.supported))
-	for k, v := range c.supported {
-		s[k] = v.usage
+func (c commands) list() []cli.Command {
+	return []cli.Command{
+		{Name: "diff", Usage: "lists the entries added/removed/modified between two paths (across servers/clusters too), " +
+			"between a local dir and a path with -l, or between a path and its replicas. -c shows the changed " +
+			"lines of small text files (i.e., diff -c /a /b, diff -l /tmp/projects /projects, diff /projects)", MinArgs: 1, MaxArgs: 4, Handler: c.diff},
+		{Name: "explain", Usage: "shows which servers path routes to and why, their connection state and whether they " +
+			"accept it (i.e., explain /projects/x)", MinArgs: 1, MaxArgs: 1, Handler: c.explain},
+		{Name: "ls", Usage: "lists directory content at path (or current dir). -l shows dir sizes (i.e., ls -l /foo)", MaxArgs: 2, Handler: c.ls},
+		{Name: "regex", Usage: "returns paths to the first regex matches at path, optionally up to a count " +
+			"(i.e., regex /bar .*foo 10)", MinArgs: 2, MaxArgs: 3, Handler: c.regex},
+		{Name: "restore", Usage: "restores a path from the latest snapshot (or the latest one taken by an RFC 3339 time) " +
+			"to itself or a target on the same server (i.e., restore /projects/x /projects/x.restored 2024-01-02T15:04:05Z)", MinArgs: 1, MaxArgs: 3, Handler: c.restore},
+		{Name: "retention", Usage: "sets or lists retention policies. age accepts d/h/m/s units, 0 disables a rule " +
+			"(i.e., retention set /foo 7d 100, retention ls /)", MinArgs: 1, MaxArgs: 4, Handler: c.retention},
+		{Name: "search", Usage: "lists the files under path whose content best matches the words, optionally up to a " +
+			"count with -n (i.e., search -n 5 /docs install server)", MinArgs: 2, MaxArgs: -1, Handler: c.search},
+		{Name: "snapshots", Usage: "lists when the server owning path took the snapshots it keeps (i.e., snapshots /projects)", MinArgs: 1, MaxArgs: 1, Handler: c.snapshots},
+		{Name: "servers", Usage: "shows the version, range and optional features each server reported when dialed", Handler: c.servers},
+		{Name: "stats", Usage: "shows the latency, failures and bytes transferred of each RPC so far", Handler: c.stats},
 	}
-
-	return s
 }
 
-func (c commands) mkDir(ctx context.Context, args []string) error {
-	if len(args) != 1 {
-		return fmt.Errorf("wrong arguments")
+func (c commands) printEntries(entries []*pb_filesystem.Entry, long bool) error {
+	printed := make([]output.Entry, 0, len(entries))
+	for _, e := range entries {
+		entry := output.Entry{Name: e.Name, Path: e.Path, Size: e.Size}
+		if d := e.GetDir(); d != nil {
+			entry.IsDir, entry.Files, entry.Dirs = true, d.Files, d.Dirs
+		}
+		printed = append(printed, entry)
 	}
-	return c.fs.MakeDir(ctx, args[0])
-}
-
-func (c commands) rm(ctx context.Context, args []string) error {
-	if len(args) != 1 {
-		return fmt.Errorf("wrong arguments")
-	}
-	return c.fs.Remove(ctx, args[0])
-}
-
-func (c commands) add(ctx context.Context, args []string) error {
-	if len(args) != 1 {
-		return fmt.Errorf("wrong arguments")
-	}
-	return c.fs.CreateFile(ctx, args[0])
-}
-
-func (c commands) printFilesAndDirs(files []*pb_filesystem.File, dirs []*pb_filesystem.Dir, fullPath bool) {
-	// TODO: Sort by name.
-	for _, f := range files {
-		fmt.Printf("%d\t%s\n", f.Size, f.Name)
-	}
-	for _, d := range dirs {
-		color.Cyan("\t%s\n", d.Name)
+	if long {
+		return out.LongEntries(printed, false)
 	}
+	return out.Entries(printed, false)
 }
 
 func (c commands) ls(ctx context.Context, args []string) error {
+	long := len(args) > 0 && args[0] == "-l"
+	if long {
+		args = args[1:]
+	}
 	if len(args) != 1 && len(args) != 0 {
 		return fmt.Errorf("wrong arguments")
 	}
 	if len(args) == 0 {
 		args = []string{""}
 	}
-	files, dirs, err := c.fs.ListDir(ctx, args[0])
-	if err != nil {
-		return err
+	entries, err := c.fs.ListEntries(ctx, args[0], c.reads...)
+	if entries == nil {
+		return reportShards(err)
 	}
 
-	c.printFilesAndDirs(files, dirs, false)
-	return nil
-}
-
-func (c commands) read(ctx context.Context, args []string) error {
-	if len(args) != 2 {
-		return fmt.Errorf("wrong arguments")
-	}
-
-	if err := c.fs.ReadFile(ctx, args[1], args[0]); err != nil {
+	if err := c.printEntries(entries, long); err != nil {
 		return err
 	}
-
-	return nil
+	return reportShards(err)
 }
 
-func (c commands) write(ctx context.Context, args []string) error {
-	if len(args) != 2 {
-		return fmt.Errorf("wrong arguments")
+func (c commands) regex(ctx context.Context, args []string) error {
+	max := 1
+	if len(args) == 3 {
+		n, err := strconv.Atoi(args[2])
+		if err != nil || n <= 0 {
+			return fmt.Errorf("invalid count %s", args[2])
+		}
+		max = n
 	}
-
-	if err := c.fs.WriteFile(ctx, args[0], args[1]); err != nil {
-		return err
+	found, err := c.fs.FindFirstRegex(ctx, args[0], args[1], max)
+	if found == nil {
+		return reportShards(err)
 	}
 
-	return nil
-}
-
-func (c commands) Handle(ctx context.Context, line string) error {
-	cmd, args, err := c.parse(line)
-	if err != nil {
+	if err := out.Paths(found); err != nil {
 		return err
 	}
-	found, ok := c.supported[cmd]
-	if !ok {
-		return fmt.Errorf("unknown command %s", cmd)
-	}
-	return found.handler(ctx, args)
+	return reportShards(err)
 }
 
-func (c commands) parse(line string) (string, []string, error) {
-	line = strings.TrimSpace(line)
-	if line == "" {
-		return "", nil, fmt.Errorf("empty command")
-	}
-	// Space must be escaped for arguments. The filesystem supports space, but parsing doesn't.
-	splitted := strings.Split(line, " ")
-	if len(splitted) > 1 {
-		return splitted[0], splitted[1:], nil
-	}
-	return splitted[0], []string{}, nil
+func (c commands) search(ctx context.Context, args []string) error {
+	max := 10
+	if args[0] == "-n" {
+		if len(args) < 4 {
+			return fmt.Errorf("wrong arguments")
+		}
+		n, err := strconv.Atoi(args[1])
+		if err != nil || n <= 0 {
+			return fmt.Errorf("invalid count %s", args[1])
+		}
+		max, args = n, args[2:]
+	}
+	hits, err := c.fs.Search(ctx, args[0], strings.Join(args[1:], " "), max)
+	if hits == nil {
+		return reportShards(err)
+	}
+
+	for _, hit := range hits {
+		if out.JSON() {
+			if err := out.Object(hit); err != nil {
+				return err
+			}
+			continue
+		}
+		fmt.Printf("%s	%.3f\n", hit.Path, hit.Score)
+	}
+	return reportShards(err)
 }
 
-type item struct {
-	name  string
-	isDir bool
+func (c commands) retention(ctx context.Context, args []string) error {
+	switch args[0] {
+	case "set":
+		if len(args) != 4 {
+			return fmt.Errorf("wrong arguments")
+		}
+		maxAge, err := parseAge(args[2])
+		if err != nil {
+			return err
+		}
+		maxFiles, err := strconv.Atoi(args[3])
+		if err != nil {
+			return err
+		}
+		return c.fs.SetRetention(ctx, args[1], maxAge, maxFiles)
+	case "ls":
+		if len(args) > 2 {
+			return fmt.Errorf("wrong arguments")
+		}
+		path := "/"
+		if len(args) == 2 {
+			path = args[1]
+		}
+		policies, err := c.fs.ListRetention(ctx, path)
+		if err != nil {
+			return err
+		}
+		sort.Slice(policies, func(i, j int) bool { return policies[i].Path < policies[j].Path })
+		for _, p := range policies {
+			if out.JSON() {
+				if err := out.Object(p); err != nil {
+					return err
+				}
+				continue
+			}
+			fmt.Printf("%s	max_age=%v	max_files=%d\n", p.Path, time.Duration(p.MaxAgeSeconds)*time.Second, p.MaxFiles)
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown retention command %s", args[0])
 }
 
-type itemSlice []item
-
-func (x itemSlice) Len() int           { return len(x) }
-func (x itemSlice) Less(i, j int) bool { return x[i].name < x[j].name }
-func (x itemSlice) Swap(i, j int)      { x[i], x[j] = x[j], x[i] }
+// parseAge parses a duration that also accepts days (i.e., 7d).
+func parseAge(s string) (time.Duration, error) {
+	if strings.HasSuffix(s, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(s, "d"))
+		if err != nil {
+			return 0, err
+		}
+		return time.Duration(days) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(s)
+}
@@ -10,6 +10,9 @@ import (
 // Conf represents a configuration
 type Conf struct {
 	Servers []client.Server `json:"servers"`
+
+	// Clusters mounts multiple clusters at different roots instead of using Servers.
+	Clusters []client.Cluster `json:"clusters"`
 }
 
 // Parse parses the config file
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/basharal/filesystem/cli"
+	"github.com/basharal/filesystem/client"
+	"github.com/basharal/filesystem/fspath"
+)
+
+// maxTextDiffSize is the largest file that diff -c shows the content diff of.
+const maxTextDiffSize = 64 << 10
+
+// maxTextDiffCells bounds the work of a content diff, which is the product of the line counts.
+const maxTextDiffCells = 4 << 20
+
+// change is how a changed entry is printed with -output json.
+type change struct {
+	Path    string `json:"path"`
+	Kind    string `json:"kind"`
+	Dir     bool   `json:"dir,omitempty"`
+	Replica string `json:"replica,omitempty"`
+	OldSize *int64 `json:"old_size,omitempty"`
+	NewSize *int64 `json:"new_size,omitempty"`
+}
+
+// tree is a side of a diff: a remote path or a local dir/file.
+type tree struct {
+	digest client.DigestFunc
+	// path returns the path of an entry at rel (i.e., /x/y) for printing.
+	path func(rel string) string
+	// size returns the size of the file at rel.
+	size func(ctx context.Context, rel string) (int64, error)
+	// read writes the content of the file at rel to w.
+	read func(ctx context.Context, rel string, w io.Writer) error
+}
+
+func (c commands) remoteTree(root string) tree {
+	path := func(rel string) string { return fspath.Join(root, rel) }
+	return tree{
+		digest: c.fs.DigestFunc(root),
+		path:   path,
+		size: func(ctx context.Context, rel string) (int64, error) {
+			file, _, err := c.fs.Stat(ctx, path(rel))
+			if err != nil {
+				return 0, err
+			}
+			if file == nil {
+				return 0, fmt.Errorf("%s is a directory", path(rel))
+			}
+			return file.Size, nil
+		},
+		read: func(ctx context.Context, rel string, w io.Writer) error {
+			_, err := c.fs.Read(ctx, path(rel), w)
+			return err
+		},
+	}
+}
+
+func localTree(root string) tree {
+	path := func(rel string) string { return filepath.Join(root, filepath.FromSlash(rel)) }
+	return tree{
+		digest: client.LocalDigestFunc(root),
+		path:   path,
+		size: func(ctx context.Context, rel string) (int64, error) {
+			info, err := os.Stat(path(rel))
+			if err != nil {
+				return 0, err
+			}
+			return info.Size(), nil
+		},
+		read: func(ctx context.Context, rel string, w io.Writer) error {
+			f, err := os.Open(path(rel))
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			_, err = io.Copy(w, f)
+			return err
+		},
+	}
+}
+
+// diff compares a path with its replicas, two paths (possibly on different servers/clusters) or,
+// with -l, a local dir/file with a path. -c shows content diffs of small text files.
+func (c commands) diff(ctx context.Context, args []string) error {
+	var content, local bool
+	rest := make([]string, 0, len(args))
+	for _, arg := range args {
+		switch arg {
+		case "-c":
+			content = true
+		case "-l":
+			local = true
+		default:
+			rest = append(rest, arg)
+		}
+	}
+	switch {
+	case len(rest) == 1 && !local:
+		remote, err := cli.RemotePath(rest[0])
+		if err != nil {
+			return err
+		}
+		return c.diffReplicas(ctx, remote)
+	case len(rest) == 2 && local:
+		l, remote, err := cli.LocalAndRemote(rest[0], rest[1])
+		if err != nil {
+			return err
+		}
+		return c.diffTrees(ctx, localTree(l), c.remoteTree(remote), content)
+	case len(rest) == 2:
+		a, err := cli.RemotePath(rest[0])
+		if err != nil {
+			return err
+		}
+		b, err := cli.RemotePath(rest[1])
+		if err != nil {
+			return err
+		}
+		return c.diffTrees(ctx, c.remoteTree(a), c.remoteTree(b), content)
+	}
+	return fmt.Errorf("wrong arguments")
+}
+
+func (c commands) diffReplicas(ctx context.Context, path string) error {
+	diffs, err := c.fs.DiffReplicas(ctx, path)
+	if err != nil {
+		return err
+	}
+	addrs := make([]string, 0, len(diffs))
+	for addr := range diffs {
+		addrs = append(addrs, addr)
+	}
+	sort.Strings(addrs)
+	for _, addr := range addrs {
+		for _, d := range diffs[addr] {
+			if out.JSON() {
+				if err := out.Object(change{Path: d.Path, Kind: d.Kind.String(), Dir: d.IsDir, Replica: addr}); err != nil {
+					return err
+				}
+				continue
+			}
+			fmt.Printf("%s %s %s\n", addr, d.Kind, d.Path)
+		}
+	}
+	return nil
+}
+
+// diffTrees prints the entries added/removed/modified from a to b, with the paths in b (or a for
+// removed ones).
+func (c commands) diffTrees(ctx context.Context, a, b tree, content bool) error {
+	diffs, err := client.Diff(ctx, a.digest, b.digest)
+	if err != nil {
+		return err
+	}
+	for _, d := range diffs {
+		printed := change{Path: b.path(d.Path), Kind: d.Kind.String(), Dir: d.IsDir}
+		if d.Kind == client.Removed {
+			printed.Path = a.path(d.Path)
+		}
+		// Sizes are only shown for files, which are modified in place.
+		var oldSize, newSize int64
+		if d.Kind == client.Modified && !d.IsDir {
+			if oldSize, err = a.size(ctx, d.Path); err == nil {
+				printed.OldSize = &oldSize
+			}
+			if newSize, err = b.size(ctx, d.Path); err == nil {
+				printed.NewSize = &newSize
+			}
+		}
+		if out.JSON() {
+			if err := out.Object(printed); err != nil {
+				return err
+			}
+			continue
+		}
+		kind := map[client.ChangeKind]string{client.Added: "+", client.Removed: "-", client.Modified: "M"}[d.Kind]
+		name := printed.Path
+		if d.IsDir {
+			name += fspath.SeparatorStr
+		}
+		if printed.OldSize != nil && printed.NewSize != nil {
+			fmt.Printf("%s %s (%s -> %s)\n", kind, name, out.Size(oldSize), out.Size(newSize))
+		} else {
+			fmt.Printf("%s %s\n", kind, name)
+		}
+		if content && printed.OldSize != nil && printed.NewSize != nil &&
+			oldSize <= maxTextDiffSize && newSize <= maxTextDiffSize {
+			if err := printTextDiff(ctx, a, b, d.Path); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// printTextDiff prints the lines removed from/added to the file at rel, if it's text on both sides.
+func printTextDiff(ctx context.Context, a, b tree, rel string) error {
+	var before, after bytes.Buffer
+	if err := a.read(ctx, rel, &before); err != nil {
+		return err
+	}
+	if err := b.read(ctx, rel, &after); err != nil {
+		return err
+	}
+	if !isText(before.Bytes()) || !isText(after.Bytes()) {
+		return nil
+	}
+	oldLines, newLines := splitLines(before.String()), splitLines(after.String())
+	if len(oldLines)*len(newLines) > maxTextDiffCells {
+		fmt.Println("  (too many lines to diff)")
+		return nil
+	}
+	for _, l := range diffLines(oldLines, newLines) {
+		fmt.Printf("  %s", l)
+		if !strings.HasSuffix(l, "\n") {
+			fmt.Println()
+		}
+	}
+	return nil
+}
+
+// splitLines splits s after each newline.
+func splitLines(s string) []string {
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+func isText(b []byte) bool {
+	return utf8.Valid(b) && bytes.IndexByte(b, 0) < 0
+}
+
+// diffLines returns the lines removed from before prefixed by "-" and the ones added in after
+// prefixed by "+", in order, based on their longest common subsequence.
+func diffLines(before, after []string) []string {
+	// lcs[i][j] is the length of the longest common subsequence of before[i:] and after[j:].
+	lcs := make([][]int, len(before)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(after)+1)
+	}
+	for i := len(before) - 1; i >= 0; i-- {
+		for j := len(after) - 1; j >= 0; j-- {
+			if before[i] == after[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	var lines []string
+	i, j := 0, 0
+	for i < len(before) || j < len(after) {
+		switch {
+		case i < len(before) && j < len(after) && before[i] == after[j]:
+			i, j = i+1, j+1
+		case j == len(after) || i < len(before) && lcs[i+1][j] >= lcs[i][j+1]:
+			lines = append(lines, "-"+before[i])
+			i++
+		default:
+			lines = append(lines, "+"+after[j])
+			j++
+		}
+	}
+	return lines
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/fatih/color"
+)
+
+// route is how a client.Route is printed with -output json.
+type route struct {
+	Addr        string   `json:"addr"`
+	Replicas    []string `json:"replicas,omitempty"`
+	Cluster     string   `json:"cluster"`
+	Path        string   `json:"path"`
+	StartPrefix string   `json:"start_prefix"`
+	EndPrefix   string   `json:"end_prefix"`
+	Reason      string   `json:"reason"`
+	State       string   `json:"state,omitempty"`
+	Valid       bool     `json:"valid"`
+	Error       string   `json:"error,omitempty"`
+}
+
+func (c commands) explain(ctx context.Context, args []string) error {
+	routes, err := c.fs.Explain(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	for _, r := range routes {
+		if out.JSON() {
+			printed := route{Addr: r.Addr, Replicas: r.Replicas, Cluster: r.Cluster, Path: r.Path, StartPrefix: r.StartPrefix,
+				EndPrefix: r.EndPrefix, Reason: r.Reason, State: r.State, Valid: r.Err == nil}
+			if r.Err != nil {
+				printed.Error = r.Err.Error()
+			}
+			if err := out.Object(printed); err != nil {
+				return err
+			}
+			continue
+		}
+		fmt.Printf("%s [%s, %s) in cluster %s: %s\n", r.Addr, r.StartPrefix, r.EndPrefix, r.Cluster, r.Reason)
+		if len(r.Replicas) > 0 {
+			fmt.Printf("  replicas: %s\n", strings.Join(r.Replicas, ", "))
+		}
+		state := r.State
+		if state == "" {
+			state = "not connected"
+		}
+		fmt.Printf("  connection: %s\n", state)
+		if r.Err != nil {
+			color.Red("  %s rejected by server: %s\n", r.Path, r.Err)
+			continue
+		}
+		color.Green("  %s accepted by server\n", r.Path)
+	}
+	return nil
+}
@@ -3,57 +3,114 @@ package main
 import (
 	"bufio"
 	"context"
+	"expvar"
 	"flag"
 	"fmt"
+	"net/http"
 	"os"
+	"path/filepath"
+	"strings"
 
+	"github.com/basharal/filesystem/alias"
+	"github.com/basharal/filesystem/cli"
 	"github.com/basharal/filesystem/client"
-	"github.com/fatih/color"
+	"github.com/basharal/filesystem/output"
+	"github.com/basharal/filesystem/session"
 	"github.com/golang/glog"
 )
 
 var (
-	flagConf = flag.String("config", "config.json", "path to json file with config")
-	flagHelp = flag.Bool("help", false, "print usage")
+	flagConf   = flag.String("config", "config.json", "path to json file with config")
+	flagHelp   = flag.Bool("help", false, "print usage")
+	flagRC     = flag.String("rc", defaultRC(), "file with aliases and macros to load at startup")
+	flagState  = flag.String("state", session.DefaultPath("distributed_filesystem"), "file keeping the command history across sessions")
+	flagOutput = output.RegisterFlags(flag.CommandLine)
+
+	// out prints the results of commands.
+	out *output.Printer
+
+	flagKeepaliveTime    = flag.Duration("keepalive_time", 0, "ping idle server connections after this long (0 uses the gRPC default)")
+	flagKeepaliveTimeout = flag.Duration("keepalive_timeout", 0, "close connections whose pings aren't acked within this long")
+	flagKeepalivePermit  = flag.Bool("keepalive_permit_without_stream", false, "ping even without active streams")
+	flagBreakerFailures  = flag.Int("breaker_failures", 0, "fail fast for a server after this many consecutive failures (0 disables)")
+	flagBreakerCooldown  = flag.Duration("breaker_cooldown", 0, "how long to fail fast before probing a failed server again (0 means 10s)")
+	flagDebugAddr        = flag.String("debug_addr", "", "host:port to serve the client metrics on at /debug/vars (optional)")
+	flagHedgeDelay       = flag.Duration("hedge_delay", 0, "also send reads to a server's replicas if it hasn't responded after this long (0 disables)")
+	flagChecksums        = flag.Bool("checksums", false, "send and check the CRC-32C of each chunk of uploads and downloads")
+	flagWriteHeartbeat   = flag.Duration("write_heartbeat", 0, "send a heartbeat on uploads idle this long, so that proxies and servers don't time out slow ones (0 disables)")
+	flagPartialResults   = flag.Bool("partial_results", false, "list what reachable servers return when others fail")
+	flagAllowGaps        = flag.Bool("allow_gaps", false, "accept configs whose servers leave prefixes between them unserved")
+	flagMaxStaleness     = flag.Duration("max_staleness", 0, "read from replicas at most this far behind their server (0 only reads from replicas when hedging)")
 )
 
-func processCommands(ctx context.Context, cmd commands) {
-	fmt.Println("Please enter filesystem command.")
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		default:
-			reader := bufio.NewReader(os.Stdin)
-			line, err := reader.ReadString('\n')
-			if err != nil {
-				color.Red(err.Error())
-				continue
-			}
-			if err := cmd.Handle(ctx, line); err != nil {
-				color.Red(err.Error())
-			}
-		}
+// defaultRC returns ~/.fsrc, or nothing if the home dir is unknown.
+func defaultRC() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
 	}
+	return filepath.Join(home, ".fsrc")
 }
 
 func main() {
 	flag.Parse()
+	printer, err := output.New(*flagOutput)
+	if err != nil {
+		glog.Fatal(err)
+	}
+	out = printer
+	aliases, err := alias.Load(*flagRC)
+	if err != nil {
+		glog.Fatal(err)
+	}
+	sess, err := session.Load(*flagState, session.DefaultMaxHistory)
+	if err != nil {
+		glog.Fatal(err)
+	}
 	conf, err := Parse(*flagConf)
 	if err != nil {
 		glog.Fatal(err)
 	}
 
-	c, err := client.New(client.Opts{Servers: conf.Servers})
+	c, err := client.New(client.Opts{
+		Servers:        conf.Servers,
+		Clusters:       conf.Clusters,
+		PartialResults: *flagPartialResults,
+		HedgeDelay:     *flagHedgeDelay,
+		WriteHeartbeat: *flagWriteHeartbeat,
+		Checksums:      *flagChecksums,
+		AllowGaps:      *flagAllowGaps,
+		Breaker: client.BreakerOpts{
+			Failures: *flagBreakerFailures,
+			Cooldown: *flagBreakerCooldown,
+		},
+		Keepalive: client.KeepaliveOpts{
+			Time:                *flagKeepaliveTime,
+			Timeout:             *flagKeepaliveTimeout,
+			PermitWithoutStream: *flagKeepalivePermit,
+		},
+	})
 	if err != nil {
 		glog.Fatal(err)
 	}
-	cmds := newCommands(c)
+	expvar.Publish("filesystem_client", c.Metrics())
+	if *flagDebugAddr != "" {
+		go func() {
+			if err := http.ListenAndServe(*flagDebugAddr, nil); err != nil {
+				glog.Errorf("Failed to serve metrics. %s\n", err)
+			}
+		}()
+	}
+	var reads []client.CallOption
+	if *flagMaxStaleness > 0 {
+		reads = append(reads, client.MaxStaleness(*flagMaxStaleness))
+	}
+	registry := cli.NewRegistry()
+	env := &cli.Env{FS: cli.Remote(c, reads...), Input: bufio.NewReader(os.Stdin), Aliases: aliases, Session: sess, Out: out, Report: reportShards}
+	registry.Register(cli.Builtins(env)...)
+	registry.Register(commands{fs: c, reads: reads}.list()...)
 	if *flagHelp {
-		supported := cmds.Supported()
-		for k, v := range supported {
-			fmt.Printf("%s - %s\n", k, v)
-		}
+		registry.PrintUsage()
 		return
 	}
 
@@ -62,6 +119,18 @@ func main() {
 	if err := c.Dial(ctx); err != nil {
 		glog.Fatal(err)
 	}
+	defer c.Close()
 
-	processCommands(ctx, cmds)
+	shell := &cli.Shell{Registry: registry, Input: env.Input, Aliases: aliases, Session: sess}
+	if flag.NArg() > 0 {
+		// A command given as arguments runs on its own, i.e., to pipe a file in or out with "-".
+		if err := shell.Handle(ctx, strings.Join(flag.Args(), " ")); err != nil {
+			c.Close()
+			glog.Flush()
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+	shell.Run(ctx)
 }
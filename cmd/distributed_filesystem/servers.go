@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/basharal/filesystem/client"
+	"github.com/basharal/filesystem/proto/pb_filesystem"
+)
+
+// serverInfo is how the info of a server is printed with -output json.
+type serverInfo struct {
+	Addr        string   `json:"addr"`
+	Version     string   `json:"version,omitempty"`
+	Features    []string `json:"features"`
+	StartPrefix string   `json:"start_prefix"`
+	EndPrefix   string   `json:"end_prefix"`
+
+	// LastSnapshotAgeMs is only set for servers taking snapshots. -1 means none was taken yet.
+	LastSnapshotAgeMs *int64 `json:"last_snapshot_age_ms,omitempty"`
+}
+
+// snapshotAge returns the age of the last snapshot of the server described by info, or nil if it
+// doesn't take snapshots.
+func snapshotAge(info *pb_filesystem.ServerInfo) *int64 {
+	for _, f := range info.Features {
+		if f == client.FeatureSnapshots {
+			age := info.LastSnapshotAgeMs
+			return &age
+		}
+	}
+	return nil
+}
+
+func (c commands) servers(ctx context.Context, args []string) error {
+	c.fs.RefreshServerInfo(ctx)
+	for _, addr := range c.fs.Addrs() {
+		info := c.fs.ServerInfo(addr)
+		if info == nil {
+			if out.JSON() {
+				if err := out.Object(serverInfo{Addr: addr}); err != nil {
+					return err
+				}
+				continue
+			}
+			fmt.Printf("%s unknown\n", addr)
+			continue
+		}
+		if out.JSON() {
+			if err := out.Object(serverInfo{
+				Addr:        addr,
+				Version:     info.Version,
+				Features:    info.Features,
+				StartPrefix: info.StartPrefix,
+				EndPrefix:   info.EndPrefix,
+
+				LastSnapshotAgeMs: snapshotAge(info),
+			}); err != nil {
+				return err
+			}
+			continue
+		}
+		version := info.Version
+		if version == "" {
+			version = "(pre-1.1)"
+		}
+		fmt.Printf("%s %s [%s, %s) features: %s", addr, version, info.StartPrefix, info.EndPrefix,
+			strings.Join(info.Features, ","))
+		if age := snapshotAge(info); age != nil && *age < 0 {
+			fmt.Printf(" last snapshot: none")
+		} else if age != nil {
+			fmt.Printf(" last snapshot: %s ago", (time.Duration(*age) * time.Millisecond).Round(time.Second))
+		}
+		fmt.Println()
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/basharal/filesystem/client"
+	"github.com/fatih/color"
+)
+
+// shardReport is how a *client.MultiError is printed with -output json.
+type shardReport struct {
+	Succeeded []string          `json:"succeeded"`
+	Failed    map[string]string `json:"failed"`
+	Canceled  []string          `json:"canceled,omitempty"`
+}
+
+// reportShards prints which servers succeeded and which failed if err is a *client.MultiError and
+// returns a short summary instead. Other errors are returned as is.
+func reportShards(err error) error {
+	var me *client.MultiError
+	if !errors.As(err, &me) {
+		return err
+	}
+	if out.JSON() {
+		report := shardReport{Succeeded: me.Succeeded, Failed: make(map[string]string), Canceled: me.Canceled}
+		for _, f := range me.Failed {
+			report.Failed[f.Addr] = f.Err.Error()
+		}
+		if err := out.Object(report); err != nil {
+			return err
+		}
+	} else {
+		for _, addr := range me.Succeeded {
+			color.Green("ok       %s\n", addr)
+		}
+		for _, f := range me.Failed {
+			color.Red("failed   %s: %s\n", f.Addr, f.Err)
+		}
+		for _, addr := range me.Canceled {
+			color.Yellow("canceled %s\n", addr)
+		}
+	}
+	total := len(me.Succeeded) + len(me.Failed) + len(me.Canceled)
+	return fmt.Errorf("%d of %d servers failed", len(me.Failed), total)
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+func (c commands) snapshots(ctx context.Context, args []string) error {
+	taken, err := c.fs.Snapshots(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	for _, t := range taken {
+		fmt.Println(t.Format(time.RFC3339))
+	}
+	return nil
+}
+
+func (c commands) restore(ctx context.Context, args []string) error {
+	var target string
+	if len(args) > 1 {
+		target = args[1]
+	}
+	var at time.Time
+	if len(args) > 2 {
+		var err error
+		if at, err = time.Parse(time.RFC3339, args[2]); err != nil {
+			return fmt.Errorf("invalid time %s. %w", args[2], err)
+		}
+	}
+	n, taken, err := c.fs.RestoreSnapshot(ctx, args[0], target, at)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("restored %d files/dirs from the snapshot taken at %s\n", n, taken.Format(time.RFC3339))
+	return nil
+}
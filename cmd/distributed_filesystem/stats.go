@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// methodStats is how the metrics of a method are printed with -output json.
+type methodStats struct {
+	Method        string `json:"method"`
+	Calls         int64  `json:"calls"`
+	Failures      int64  `json:"failures"`
+	MeanMs        int64  `json:"mean_ms"`
+	P50Ms         int64  `json:"p50_ms"`
+	P99Ms         int64  `json:"p99_ms"`
+	BytesSent     int64  `json:"bytes_sent"`
+	BytesReceived int64  `json:"bytes_received"`
+}
+
+func (c commands) stats(ctx context.Context, args []string) error {
+	snapshot := c.fs.Metrics().Snapshot()
+	methods := make([]string, 0, len(snapshot))
+	for method := range snapshot {
+		methods = append(methods, method)
+	}
+	sort.Strings(methods)
+
+	if !out.JSON() {
+		fmt.Printf("%-16s %8s %8s %8s %8s %8s %10s %10s\n", "method", "calls", "failures", "mean", "p50", "p99", "sent", "received")
+	}
+	for _, method := range methods {
+		s := snapshot[method]
+		if out.JSON() {
+			if err := out.Object(methodStats{
+				Method:        method,
+				Calls:         s.Calls,
+				Failures:      s.Failures,
+				MeanMs:        s.Mean().Milliseconds(),
+				P50Ms:         s.Quantile(0.5).Milliseconds(),
+				P99Ms:         s.Quantile(0.99).Milliseconds(),
+				BytesSent:     s.BytesSent,
+				BytesReceived: s.BytesReceived,
+			}); err != nil {
+				return err
+			}
+			continue
+		}
+		fmt.Printf("%-16s %8d %8d %8s %8s %8s %10s %10s\n", method, s.Calls, s.Failures,
+			s.Mean().Round(time.Microsecond), s.Quantile(0.5), s.Quantile(0.99),
+			out.Size(s.BytesSent), out.Size(s.BytesReceived))
+	}
+	if !out.JSON() {
+		fmt.Printf("dial retries: %d\n", c.fs.Metrics().Retries())
+	}
+	return nil
+}
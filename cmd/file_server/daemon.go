@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/basharal/filesystem/server"
+	"github.com/golang/glog"
+)
+
+var (
+	daemon       = flag.Bool("daemon", false, "run in the background, detached from the terminal, with output in the log dir")
+	pidfile      = flag.String("pidfile", "", "file to write the pid to while running; refuses to start if its process is running (optional)")
+	logMaxSize   = flag.Uint64("log_max_size", 0, "rotate log files in -log_dir once they reach this many MiB (0 uses glog's default)")
+	reloadConfig = flag.String("reload_config", "", "file of name=value lines setting -v, -max_file_size and -memory_budget, "+
+		"read at startup and again on SIGHUP (optional)")
+)
+
+// daemonEnv marks the process started by -daemon, which runs the server.
+const daemonEnv = "FILE_SERVER_DAEMON"
+
+// reloadable are the flags -reload_config can set.
+var reloadable = map[string]bool{"v": true, "max_file_size": true, "memory_budget": true}
+
+// daemonize starts the file server again in the background, in its own session so that it
+// outlives the terminal, and returns its pid. Its output goes to file_server.out in the log dir.
+func daemonize() (int, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return 0, err
+	}
+	dir := os.TempDir()
+	if f := flag.Lookup("log_dir"); f != nil && f.Value.String() != "" {
+		dir = f.Value.String()
+	}
+	out, err := os.OpenFile(filepath.Join(dir, "file_server.out"), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return 0, err
+	}
+	defer out.Close()
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Env = append(os.Environ(), daemonEnv+"=1")
+	cmd.Stdout = out
+	cmd.Stderr = out
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if err := cmd.Start(); err != nil {
+		return 0, err
+	}
+	return cmd.Process.Pid, nil
+}
+
+// writePidfile writes the pid of the process to path, unless the process in it is still running.
+func writePidfile(path string) error {
+	if b, err := ioutil.ReadFile(path); err == nil {
+		if pid, err := strconv.Atoi(strings.TrimSpace(string(b))); err == nil && pid != os.Getpid() && running(pid) {
+			return fmt.Errorf("pidfile %s: already running as %d", path, pid)
+		}
+	}
+	return ioutil.WriteFile(path, []byte(strconv.Itoa(os.Getpid())+"\n"), 0644)
+}
+
+// removePidfile removes -pidfile, if set.
+func removePidfile() {
+	if *pidfile == "" {
+		return
+	}
+	if err := os.Remove(*pidfile); err != nil && !os.IsNotExist(err) {
+		glog.Warningf("Failed to remove pidfile %s. %s\n", *pidfile, err)
+	}
+}
+
+func running(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	return p.Signal(syscall.Signal(0)) == nil
+}
+
+// loadConfig sets the flags in the config file at path. Lines are name=value, and the ones
+// starting with # are comments. Only reloadable flags can be set.
+func loadConfig(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	values := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		parts := strings.SplitN(text, "=", 2)
+		name := strings.TrimSpace(parts[0])
+		if len(parts) != 2 || !reloadable[name] {
+			return fmt.Errorf("%s:%d: expected one of v, max_file_size or memory_budget as name=value", path, line)
+		}
+		values[name] = strings.TrimSpace(parts[1])
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	// Nothing is set unless the whole file is valid.
+	for name, value := range values {
+		if err := flag.Set(name, value); err != nil {
+			return fmt.Errorf("%s: %s: %w", path, name, err)
+		}
+	}
+	return nil
+}
+
+// reloadOnSignal reads -reload_config again on SIGHUP and applies it to s. Without a config,
+// SIGHUP is ignored rather than ending the server.
+func reloadOnSignal(s *server.Server) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	for range signals {
+		if *reloadConfig == "" {
+			glog.Infof("Got SIGHUP without -reload_config, ignoring.\n")
+			continue
+		}
+		if err := loadConfig(*reloadConfig); err != nil {
+			glog.Errorf("Failed to reload config. %s\n", err)
+			continue
+		}
+		s.SetMaxFileSize(*maxFileSize)
+		s.SetMemoryBudget(*memoryBudget)
+		glog.Infof("Reloaded %s: v=%s max_file_size=%d memory_budget=%d\n",
+			*reloadConfig, flag.Lookup("v").Value, *maxFileSize, *memoryBudget)
+	}
+}
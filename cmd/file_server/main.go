@@ -2,30 +2,431 @@ package main
 
 import (
 	"context"
+	"expvar"
 	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
+	"github.com/basharal/filesystem/blob"
+	"github.com/basharal/filesystem/fs"
+	"github.com/basharal/filesystem/fs/osfs"
+	"github.com/basharal/filesystem/search"
 	"github.com/basharal/filesystem/server"
 	"github.com/golang/glog"
+	// Registers the driver of the sqlite backend.
+	_ "github.com/mattn/go-sqlite3"
 )
 
 var (
-	port  = flag.Int("port", 0, "port to listen on")
-	start = flag.String("start_prefix", "", "start prefix for file-paths for server (inclusive)")
-	end   = flag.String("end_prefix", "", "end prefix for file-paths for server (exclusive")
+	port   = flag.Int("port", 0, "port to listen on")
+	listen = flag.String("listen", "", "comma-separated addresses to listen on instead of localhost:port "+
+		"(i.e., 0.0.0.0:9800,[::]:9800,unix:///tmp/fs.sock)")
+	start = flag.String("start_prefix", "", "start prefix for file-paths for server (inclusive). empty starts at the beginning")
+	end   = flag.String("end_prefix", "", "end prefix for file-paths for server (exclusive). empty runs to the end")
+
+	webListen = flag.String("web_listen", "", "comma-separated addresses to also serve browsers on over grpc-web, "+
+		"and gRPC clients over h2c (i.e., localhost:8080)")
+	webOrigins = flag.String("web_origins", "", "comma-separated origins of pages allowed to call -web_listen "+
+		"(i.e., https://app.example.com), or * for any")
+
+	webhookURL    = flag.String("webhook_url", "", "url to POST filesystem events to (optional)")
+	webhookSecret = flag.String("webhook_secret", "", "secret to sign webhook bodies with HMAC-SHA256")
+
+	natsAddr     = flag.String("nats_addr", "", "host:port of a NATS server to publish events to (optional)")
+	natsSubject  = flag.String("nats_subject", "filesystem.events", "NATS subject for events")
+	kafkaRESTURL = flag.String("kafka_rest_url", "", "url of a Confluent Kafka REST proxy to produce events to (optional). Brokers can't be produced to directly")
+	kafkaTopic   = flag.String("kafka_topic", "filesystem.events", "Kafka topic for events")
+
+	eventEncoding = flag.String("event_encoding", "json", "serialization for exported events (json or proto)")
+	eventTypes    = flag.String("events", "", "comma-separated events to export (i.e., create,write). defaults to all")
+	eventPrefix   = flag.String("events_prefix", "", "only export events for paths under this prefix")
+
+	archiveDir      = flag.String("archive_dir", "", "local dir to archive cold files to (optional)")
+	archiveS3URL    = flag.String("archive_s3_endpoint", "", "S3 endpoint to archive cold files to (optional)")
+	archiveS3Region = flag.String("archive_s3_region", "us-east-1", "S3 region for archiving")
+	archiveS3Bucket = flag.String("archive_s3_bucket", "", "S3 bucket for archiving")
+	archiveAfter    = flag.Duration("archive_after", 24*time.Hour, "archive files not accessed for this long")
+	archivePrefix   = flag.String("archive_prefix", "/", "only archive files under this prefix")
+
+	contentDir      = flag.String("content_dir", "", "local dir to keep file content in instead of memory (optional)")
+	mmapContent     = flag.Bool("mmap_content", false, "serve the content in -content_dir from memory maps, leaving residency to the page cache")
+	contentS3URL    = flag.String("content_s3_endpoint", "", "S3 endpoint to keep file content in instead of memory (optional)")
+	contentS3Region = flag.String("content_s3_region", "us-east-1", "S3 region for file content")
+	contentS3Bucket = flag.String("content_s3_bucket", "", "S3 bucket for file content")
+
+	metaDir      = flag.String("meta_dir", "", "local dir to persist the namespace in so that it survives restarts (optional)")
+	metaDB       = flag.String("meta_db", "", "bbolt file to persist the namespace in instead of -meta_dir, which suits millions of files (optional)")
+	syncInterval = flag.Duration("sync_interval", 10*time.Second, "how often to persist namespace changes to -meta_dir or -meta_db")
+
+	snapshotDir      = flag.String("snapshot_dir", "", "local dir to take periodic snapshots to (optional)")
+	snapshotS3URL    = flag.String("snapshot_s3_endpoint", "", "S3 endpoint to take periodic snapshots to (optional)")
+	snapshotS3Region = flag.String("snapshot_s3_region", "us-east-1", "S3 region for snapshots")
+	snapshotS3Bucket = flag.String("snapshot_s3_bucket", "", "S3 bucket for snapshots")
+	snapshotInterval = flag.Duration("snapshot_interval", 15*time.Minute, "how often to take snapshots")
+	snapshotKeep     = flag.Int("snapshot_keep", 4, "how many of the latest snapshots to keep")
+	snapshotFull     = flag.Int("snapshot_full_every", 1, "take a full snapshot every this many snapshots and incremental ones in between")
+
+	debugAddr = flag.String("debug_addr", "", "host:port to serve metrics on at /debug/vars and health checks at /healthz, /livez and /readyz (optional)")
+
+	osDir = flag.String("os_dir", "", "local dir to serve as is instead of an in-memory filesystem (optional)")
+
+	backend     = flag.String("backend", "memory", "where to keep the filesystem: memory or sqlite (requires a binary built with cgo)")
+	sqlitePath  = flag.String("sqlite_path", "filesystem.db", "database file of the sqlite backend")
+	groupCommit = flag.Duration("group_commit", 0, "batch concurrent mutations of the sqlite backend into one transaction committed within this long (0 commits each on its own)")
+
+	seedDir  = flag.String("seed_dir", "", "local dir to load into the filesystem at startup (optional)")
+	seedLazy = flag.Bool("seed_lazy", false, "load the content of seeded files on first access")
+
+	mirrorDir      = flag.String("mirror_dir", "", "local dir to keep in sync with the filesystem in both directions (optional)")
+	mirrorInterval = flag.Duration("mirror_interval", time.Minute, "how often to rescan the mirror dir for local changes notifications missed")
+
+	maxReadDuration  = flag.Duration("max_read_duration", 0, "max duration of a file read stream (0 means no limit)")
+	maxWriteDuration = flag.Duration("max_write_duration", 0, "max duration of a file write stream (0 means no limit)")
+	maxRegexDuration = flag.Duration("max_regex_duration", 0, "max duration of a regex search (0 means no limit)")
+	maxRegexNodes    = flag.Int("max_regex_nodes", 0, "max dirs/files a regex search can visit (0 means no limit)")
+
+	regexMaxPatternLength = flag.Int("regex_max_pattern_length", 0, "max length of regex patterns (0 uses the default)")
+	regexMaxResults       = flag.Int("regex_max_results", 0, "max results of a regex search (0 uses the default)")
+
+	writePolicy        = flag.String("write_policy", "serialize", "what to do with concurrent writes to a file: serialize, reject or last_wins")
+	queueWrites        = flag.Bool("queue_writes", false, "apply concurrent writes to the same file in arrival order")
+	maxFileSize        = flag.Int64("max_file_size", 0, "max size of a file in bytes; larger uploads are rejected (0 means no limit)")
+	parallelism        = flag.Int("parallelism", 0, "how many files digests, snapshots and prefix deletions work on at once (0 uses GOMAXPROCS)")
+	contentIndex       = flag.Bool("content_index", false, "index the content of text files in memory so that clients can search it")
+	nameIndex          = flag.Bool("name_index", true, "index names so that find doesn't walk subtrees; disable to save memory")
+	memoryBudget       = flag.Int64("memory_budget", 0, "reject writes with a retryable error once files plus uploads in flight reach this many bytes (0 means no budget)")
+	streamingWrites    = flag.Bool("streaming_writes", false, "write uploads as they arrive instead of staging them until complete; failed uploads may leave partial content")
+	writeIdleTimeout   = flag.Duration("write_idle_timeout", 0, "fail uploads nothing (data or heartbeat) arrived on for this long (0 disables it)")
+	keepaliveTime      = flag.Duration("keepalive_time", 0, "ping idle client connections after this long (0 uses the gRPC default)")
+	keepaliveTimeout   = flag.Duration("keepalive_timeout", 0, "close connections whose pings aren't acked within this long (0 uses the gRPC default)")
+	keepaliveMinTime   = flag.Duration("keepalive_min_time", 0, "minimum interval clients may ping at (0 uses the gRPC default)")
+	keepalivePermitAll = flag.Bool("keepalive_permit_without_stream", false, "allow client pings without active streams")
+	deferOpenRemoves   = flag.Bool("defer_open_removes", false, "remove open files right away and discard their content once closed, instead of failing as busy")
+	replicaOf          = flag.String("replica_of", "", "host:port of the primary to serve a read-only replica of, applying its changes asynchronously")
+	hooks              = flag.String("hooks", "", "comma-separated hooks to call around operations, in order, as name or name:config (optional)")
+	uploadMaxSize      = flag.Int64("upload_max_size", 0, "reject uploads of more than this many bytes as they arrive (0 means no limit)")
+	uploadTypes        = flag.String("upload_types", "", "comma-separated MIME types uploads may have, detected from their content (i.e., text/*,image/png). empty allows any")
+	hookPlugins        = flag.String("hook_plugins", "", "comma-separated Go plugins to load -hooks from, besides the compiled-in ones (optional)")
 )
 
+// blobStore returns a store for either dir or the S3 bucket. Returns nil if neither is set. S3
+// credentials are read from AWS_ACCESS_KEY_ID/AWS_SECRET_ACCESS_KEY.
+func blobStore(dir, s3Endpoint, s3Region, s3Bucket string) (blob.Store, error) {
+	switch {
+	case dir != "" && s3Endpoint != "":
+		return nil, fmt.Errorf("only one of a local dir and an S3 endpoint can be set")
+	case dir != "":
+		return blob.NewDisk(dir)
+	case s3Endpoint != "":
+		return blob.NewS3(blob.S3Opts{
+			Endpoint:  s3Endpoint,
+			Region:    s3Region,
+			Bucket:    s3Bucket,
+			AccessKey: os.Getenv("AWS_ACCESS_KEY_ID"),
+			SecretKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		})
+	}
+	return nil, nil
+}
+
+// splitList splits a comma-separated flag, dropping empty entries.
+func splitList(s string) []string {
+	list := make([]string, 0)
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// serverHooks loads the -hook_plugins and creates the -hooks.
+func serverHooks() ([]server.Hook, error) {
+	for _, path := range splitList(*hookPlugins) {
+		if err := server.LoadHookPlugin(path); err != nil {
+			return nil, err
+		}
+	}
+	list := make([]server.Hook, 0)
+	for _, spec := range splitList(*hooks) {
+		name, config := spec, ""
+		if i := strings.Index(spec, ":"); i >= 0 {
+			name, config = spec[:i], spec[i+1:]
+		}
+		hook, err := server.NewHook(name, config)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, hook)
+	}
+	return list, nil
+}
+
+func eventFilter() (server.EventFilter, error) {
+	filter := server.EventFilter{PathPrefix: *eventPrefix}
+	if *eventTypes == "" {
+		return filter, nil
+	}
+	for _, name := range strings.Split(*eventTypes, ",") {
+		t, err := fs.ParseEventType(strings.TrimSpace(name))
+		if err != nil {
+			return filter, err
+		}
+		filter.Events = append(filter.Events, t)
+	}
+	return filter, nil
+}
+
+func exporters(filter server.EventFilter) ([]server.WebhookOpts, []server.SinkOpts, error) {
+	var webhooks []server.WebhookOpts
+	if *webhookURL != "" {
+		webhooks = append(webhooks, server.WebhookOpts{URL: *webhookURL, Secret: *webhookSecret, Filter: filter})
+	}
+
+	enc, err := server.ParseEncoding(*eventEncoding)
+	if err != nil {
+		return nil, nil, err
+	}
+	var sinks []server.SinkOpts
+	if *natsAddr != "" {
+		sink, err := server.NewNATSSink(server.NATSOpts{Addr: *natsAddr, Subject: *natsSubject, Encoding: enc})
+		if err != nil {
+			return nil, nil, err
+		}
+		sinks = append(sinks, server.SinkOpts{Sink: sink, Filter: filter})
+	}
+	if *kafkaRESTURL != "" {
+		sink, err := server.NewKafkaRESTSink(server.KafkaRESTOpts{RESTURL: *kafkaRESTURL, Topic: *kafkaTopic, Encoding: enc})
+		if err != nil {
+			return nil, nil, err
+		}
+		sinks = append(sinks, server.SinkOpts{Sink: sink, Filter: filter})
+	}
+	return webhooks, sinks, nil
+}
+
 func main() {
 	flag.Parse()
+	if *daemon && os.Getenv(daemonEnv) == "" {
+		pid, err := daemonize()
+		if err != nil {
+			glog.Fatal(err)
+		}
+		fmt.Printf("Started file server in the background as %d.\n", pid)
+		return
+	}
+	if *logMaxSize > 0 {
+		glog.MaxSize = *logMaxSize << 20
+	}
+	if *reloadConfig != "" {
+		if err := loadConfig(*reloadConfig); err != nil {
+			glog.Fatal(err)
+		}
+	}
+	if *pidfile != "" {
+		if err := writePidfile(*pidfile); err != nil {
+			glog.Fatal(err)
+		}
+		defer removePidfile()
+	}
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	s, err := server.New(server.Opts{
+	filter, err := eventFilter()
+	if err != nil {
+		glog.Fatal(err)
+	}
+	webhooks, sinks, err := exporters(filter)
+	if err != nil {
+		glog.Fatal(err)
+	}
+	hookList, err := serverHooks()
+	if err != nil {
+		glog.Fatal(err)
+	}
+	opts := server.Opts{
 		StartPrefix: *start,
 		EndPrefix:   *end,
 		Port:        *port,
-	})
+		Listen:      splitList(*listen),
+		WebListen:   splitList(*webListen),
+		WebOrigins:  splitList(*webOrigins),
+		Webhooks:    webhooks,
+		Sinks:       sinks,
+		SeedDir:     *seedDir,
+		SeedLazy:    *seedLazy,
+
+		MirrorDir:      *mirrorDir,
+		MirrorInterval: *mirrorInterval,
+		Parallelism:    *parallelism,
+
+		DisableNameIndex: !*nameIndex,
+
+		Limits: fs.Limits{
+			MaxReadDuration:  *maxReadDuration,
+			MaxWriteDuration: *maxWriteDuration,
+			MaxRegexDuration: *maxRegexDuration,
+			MaxRegexNodes:    *maxRegexNodes,
+		},
+		Regex: server.RegexOpts{
+			MaxPatternLength: *regexMaxPatternLength,
+			MaxResults:       *regexMaxResults,
+		},
+		QueueWrites:      *queueWrites,
+		StreamingWrites:  *streamingWrites,
+		MaxFileSize:      *maxFileSize,
+		MemoryBudget:     *memoryBudget,
+		WriteIdleTimeout: *writeIdleTimeout,
+		Keepalive: server.KeepaliveOpts{
+			Time:                *keepaliveTime,
+			Timeout:             *keepaliveTimeout,
+			MinTime:             *keepaliveMinTime,
+			PermitWithoutStream: *keepalivePermitAll,
+		},
+		ReplicaOf: *replicaOf,
+		Hooks:     hookList,
+	}
+	if *uploadMaxSize > 0 {
+		opts.UploadValidators = append(opts.UploadValidators, server.MaxUploadSize(*uploadMaxSize))
+	}
+	if types := splitList(*uploadTypes); len(types) > 0 {
+		opts.UploadValidators = append(opts.UploadValidators, server.AllowedTypes(types...))
+	}
+	if *deferOpenRemoves {
+		opts.OpenPolicy = fs.OpenDeferRemove
+	}
+	if *contentIndex {
+		opts.ContentIndex = search.NewMemory()
+	}
+	switch *writePolicy {
+	case "serialize":
+		opts.WritePolicy = fs.WriteSerialize
+	case "reject":
+		opts.WritePolicy = fs.WriteReject
+	case "last_wins":
+		opts.WritePolicy = fs.WriteLastWins
+	default:
+		glog.Fatalf("Unknown write policy %s\n", *writePolicy)
+	}
+	store, err := blobStore(*archiveDir, *archiveS3URL, *archiveS3Region, *archiveS3Bucket)
+	if err != nil {
+		glog.Fatal(err)
+	}
+	if store != nil {
+		opts.ArchiveStore = store
+		opts.ArchiveRules = []fs.LifecycleRule{{Prefix: *archivePrefix, ColdAfter: *archiveAfter}}
+	}
+	store, err = blobStore(*contentDir, *contentS3URL, *contentS3Region, *contentS3Bucket)
+	if err != nil {
+		glog.Fatal(err)
+	}
+	if *mmapContent {
+		if *contentDir == "" {
+			glog.Fatal("-mmap_content requires -content_dir")
+		}
+		if store, err = blob.NewMmap(*contentDir); err != nil {
+			glog.Fatal(err)
+		}
+	}
+	if store != nil {
+		// Servers may share a store, so keys are scoped by the server's range.
+		opts.ContentStore = fs.NewBlobContentStore(store, fmt.Sprintf("%s-%s/", *start, *end))
+	}
+	store, err = blobStore(*snapshotDir, *snapshotS3URL, *snapshotS3Region, *snapshotS3Bucket)
 	if err != nil {
 		glog.Fatal(err)
 	}
-	s.ListenAndServe(ctx)
+	if store != nil {
+		opts.SnapshotStore = store
+		opts.SnapshotInterval = *snapshotInterval
+		opts.SnapshotKeep = *snapshotKeep
+		opts.SnapshotFullEvery = *snapshotFull
+	}
+	if *metaDir != "" && *metaDB != "" {
+		glog.Fatal("-meta_dir and -meta_db can't be used together")
+	}
+	if *metaDir != "" {
+		store, err := blob.NewDisk(*metaDir)
+		if err != nil {
+			glog.Fatal(err)
+		}
+		opts.MetaStore = store
+		opts.SyncInterval = *syncInterval
+	}
+	if *metaDB != "" {
+		// Closed by exiting, since the server syncs to it until then.
+		store, err := blob.OpenBolt(*metaDB)
+		if err != nil {
+			glog.Fatal(err)
+		}
+		opts.MetaStore = store
+		opts.SyncInterval = *syncInterval
+	}
+	if *osDir != "" {
+		disk, err := osfs.New(*osDir)
+		if err != nil {
+			glog.Fatal(err)
+		}
+		opts.FileSystem = disk
+	} else {
+		opts.Backend = server.Backend(*backend)
+		opts.SQLitePath = *sqlitePath
+		opts.GroupCommit = *groupCommit
+	}
+	s, err := server.New(opts)
+	if err != nil {
+		glog.Fatal(err)
+	}
+	if *debugAddr != "" {
+		serveMetrics(*debugAddr, s)
+	}
+	go stopOnSignal(cancel)
+	go reloadOnSignal(s)
+	if err := s.ListenAndServe(ctx); err != nil {
+		glog.Error(err)
+	}
+}
+
+// stopOnSignal calls stop on SIGINT/SIGTERM, which lets calls in flight finish before exiting,
+// and exits right away on a second one.
+func stopOnSignal(stop func()) {
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	sig := <-signals
+	glog.Infof("Got %s, stopping. Send again to exit right away.\n", sig)
+	stop()
+	<-signals
+	removePidfile()
+	glog.Flush()
+	os.Exit(1)
+}
+
+// serveMetrics serves the server's metrics at /debug/vars and its health checks on addr in the
+// background.
+func serveMetrics(addr string, s *server.Server) {
+	health := s.HealthHandler()
+	for _, path := range []string{"/healthz", "/livez", "/readyz"} {
+		http.Handle(path, health)
+	}
+	expvar.Publish("last_snapshot_age_seconds", expvar.Func(func() interface{} {
+		age, ok := s.LastSnapshotAge()
+		if !ok {
+			return -1
+		}
+		return age.Seconds()
+	}))
+	expvar.Publish("admission", expvar.Func(func() interface{} {
+		stats, _ := s.AdmissionStats()
+		return stats
+	}))
+	go func() {
+		if err := http.ListenAndServe(addr, nil); err != nil {
+			glog.Errorf("Failed to serve metrics on %s. %s\n", addr, err)
+		}
+	}()
 }
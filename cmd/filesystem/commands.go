@@ -1,147 +1,100 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
-	"os"
-	"strings"
+	"io/ioutil"
 
+	"github.com/basharal/filesystem/cli"
+	"github.com/basharal/filesystem/client"
 	"github.com/basharal/filesystem/fs"
-	"github.com/fatih/color"
+	"github.com/basharal/filesystem/output"
 )
 
-type handlerFunc func(args []string) error
-
-type cmdHandler struct {
-	usage   string
-	handler handlerFunc
-}
-
+// commands are the ones only the in-memory CLI has. The shared ones are in cli.Builtins.
 type commands struct {
-	fs        *fs.FileSystem
-	supported map[string]cmdHandler
+	fs fs.Interface
+	// mounts are the clients of the mounted clusters by mount path, closed once unmounted.
+	mounts map[string]*client.Client
 }
 
-func newCommands(fs *fs.FileSystem) commands {
-	c := commands{
-		fs: fs,
-	}
-	supported := map[string]cmdHandler{
-		"add":   {"add creates an empty file (i.e., add /foo)", c.add},
-		"cd":    {"changes current directory (i.e., cd /foo)", c.chDir},
-		"find":  {"finds all files/dirs matching string at path (i.e., find /foo hello)", c.find},
-		"ls":    {"lists directory content at path (or current dir)", c.ls},
-		"mkdir": {"creates a new directory (i.e., mkdir foo)", c.mkDir},
-		"mv":    {"mv moves a file from a to b (i.e., mv foo.txt /bar.txt", c.mv},
-		"pwd":   {"prints current path", c.pwd},
-		"read": {"reads from in-memory filesystem into local filesystem. " +
-			"will truncate the local file (i.e., read /bar /tmp/bar", c.read},
-		"regex": {"returns path to first regex match at path (i.e., regex /bar .*foo", c.regex},
-		"rm":    {"removes a file/directory(if empty) (i.e., rm foo)", c.rm},
-		"write": {"reads from local filesystem and writes into in-memory filesystem. " +
-			"will append (i.e., write /tmp/bar /bar", c.write},
-	}
-	c.supported = supported
-	return c
-}
-
-func (c commands) Supported() map[string]string {
-	s := make(map[string]string, len(c.supported))
-	for k, v := range c.supported {
-		s[k] = v.usage
+// closeMounts closes the clients of the mounted clusters.
+func (c commands) closeMounts() {
+	for _, cl := range c.mounts {
+		cl.Close()
 	}
-
-	return s
 }
 
-func (c commands) mkDir(args []string) error {
-	if len(args) != 1 {
-		return fmt.Errorf("wrong arguments")
+func (c commands) list() []cli.Command {
+	return []cli.Command{
+		{Name: "cd", Usage: "changes current directory (i.e., cd /foo)", MinArgs: 1, MaxArgs: 1, Handler: c.chDir},
+		{Name: "find", Usage: "finds all files/dirs matching string at path (i.e., find /foo hello)", MinArgs: 2, MaxArgs: 2, Handler: c.find},
+		{Name: "ls", Usage: "lists directory content at path (or current dir). -l shows dir sizes (i.e., ls -l /foo)", MaxArgs: 2, Handler: c.ls},
+		{Name: "mount", Usage: "mounts a distributed filesystem on an existing dir given its client config " +
+			"(i.e., mount /remote config.json)", MinArgs: 2, MaxArgs: 2, Handler: c.mount},
+		{Name: "mv", Usage: "mv moves a file from a to b (i.e., mv foo.txt /bar.txt", MinArgs: 2, MaxArgs: 2, Handler: c.mv},
+		{Name: "pwd", Usage: "prints current path", Handler: c.pwd},
+		{Name: "regex", Usage: "returns path to first regex match at path (i.e., regex /bar .*foo", MinArgs: 2, MaxArgs: 2, Handler: c.regex},
+		{Name: "umount", Usage: "unmounts a distributed filesystem (i.e., umount /remote)", MinArgs: 1, MaxArgs: 1, Handler: c.umount},
 	}
-	return c.fs.MakeDir(args[0])
 }
 
-func (c commands) chDir(args []string) error {
-	if len(args) != 1 {
-		return fmt.Errorf("wrong arguments")
-	}
+func (c commands) chDir(ctx context.Context, args []string) error {
 	return c.fs.ChangeDir(args[0])
 }
 
-func (c commands) rm(args []string) error {
-	if len(args) != 1 {
-		return fmt.Errorf("wrong arguments")
-	}
-	return c.fs.Remove(args[0])
-}
-
-func (c commands) mv(args []string) error {
-	if len(args) != 2 {
-		return fmt.Errorf("wrong arguments")
-	}
+func (c commands) mv(ctx context.Context, args []string) error {
 	return c.fs.Move(args[0], args[1])
 }
 
-func (c commands) add(args []string) error {
-	if len(args) != 1 {
-		return fmt.Errorf("wrong arguments")
-	}
-	return c.fs.NewFile(args[0])
-}
-
-func (c commands) find(args []string) error {
-	if len(args) != 2 {
-		return fmt.Errorf("wrong arguments")
-	}
+func (c commands) find(ctx context.Context, args []string) error {
 	files, dirs, err := c.fs.Find(args[0], args[1])
 	if err != nil {
 		return err
 	}
 
-	c.printFilesAndDirs(files, dirs, true)
-	return nil
+	return c.printFilesAndDirs(files, dirs, true, false)
 }
 
-func (c commands) regex(args []string) error {
-	if len(args) != 2 {
-		return fmt.Errorf("wrong arguments")
-	}
+func (c commands) regex(ctx context.Context, args []string) error {
 	found, err := c.fs.FindFirstRegex(args[0], args[1])
 	if err != nil {
 		return err
 	}
 
-	fmt.Println(found)
-	return nil
+	return out.Paths([]string{found})
 }
 
-func (c commands) pwd(args []string) error {
-	if len(args) != 0 {
-		return fmt.Errorf("wrong arguments")
-	}
+func (c commands) pwd(ctx context.Context, args []string) error {
 	dir := c.fs.CurrentDir()
 	fmt.Println(dir)
 	return nil
 }
 
-func (c commands) printFilesAndDirs(files []*fs.File, dirs []*fs.Dir, fullPath bool) {
+func (c commands) printFilesAndDirs(files []*fs.File, dirs []*fs.Dir, fullPath, long bool) error {
 	// TODO: Sort by name.
+	entries := make([]output.Entry, 0, len(files)+len(dirs))
 	for _, f := range files {
-		s := f.String()
-		if fullPath {
-			s = f.Path()
-		}
-		fmt.Printf("%d\t%s\n", f.Size(), s)
+		modified := f.ModTime()
+		entries = append(entries, output.Entry{Name: f.String(), Path: f.Path(), Size: f.Size(), ModTime: &modified})
 	}
 	for _, d := range dirs {
-		s := d.String()
-		if fullPath {
-			s = d.Path()
-		}
-		color.Cyan("\t%s\n", s)
+		usage := d.Usage()
+		entries = append(entries, output.Entry{Name: d.String(), Path: d.Path(), IsDir: true,
+			Size: usage.Size, Files: usage.Files, Dirs: usage.Dirs})
+	}
+	if long {
+		return out.LongEntries(entries, fullPath)
 	}
+	return out.Entries(entries, fullPath)
 }
 
-func (c commands) ls(args []string) error {
+func (c commands) ls(ctx context.Context, args []string) error {
+	long := len(args) > 0 && args[0] == "-l"
+	if long {
+		args = args[1:]
+	}
 	if len(args) != 1 && len(args) != 0 {
 		return fmt.Errorf("wrong arguments")
 	}
@@ -153,76 +106,65 @@ func (c commands) ls(args []string) error {
 		return err
 	}
 
-	c.printFilesAndDirs(files, dirs, false)
-	return nil
+	return c.printFilesAndDirs(files, dirs, false, long)
 }
 
-func (c commands) read(args []string) error {
-	if len(args) != 2 {
-		return fmt.Errorf("wrong arguments")
+func (c commands) mount(ctx context.Context, args []string) error {
+	mounter, ok := c.fs.(fs.Mounter)
+	if !ok {
+		return fs.ErrNotSupported
 	}
-
-	f, err := os.Create(args[1])
+	config, err := cli.LocalPath(args[1])
 	if err != nil {
 		return err
 	}
-	defer f.Close()
-	if _, err := c.fs.Read(args[0], f); err != nil {
-		return err
-	}
-
-	return nil
-}
-
-func (c commands) write(args []string) error {
-	if len(args) != 2 {
-		return fmt.Errorf("wrong arguments")
-	}
-
-	f, err := os.Open(args[0])
+	cl, err := dialConfig(config)
 	if err != nil {
 		return err
 	}
-	defer f.Close()
-	if _, err := c.fs.Write(args[1], f); err != nil {
+	if err := mounter.Mount(args[0], cl.Backend()); err != nil {
+		cl.Close()
 		return err
 	}
-
+	c.mounts[args[0]] = cl
 	return nil
 }
 
-func (c commands) Handle(line string) error {
-	cmd, args, err := c.parse(line)
+// dialConfig returns a client dialed to the cluster in the config file (like the distributed
+// CLI's config.json).
+func dialConfig(config string) (*client.Client, error) {
+	b, err := ioutil.ReadFile(config)
 	if err != nil {
-		return err
+		return nil, fmt.Errorf("local path %s: %w", config, err)
 	}
-	found, ok := c.supported[cmd]
-	if !ok {
-		return fmt.Errorf("unknown command %s", cmd)
+	conf := struct {
+		Servers  []client.Server  `json:"servers"`
+		Clusters []client.Cluster `json:"clusters"`
+	}{}
+	if err := json.Unmarshal(b, &conf); err != nil {
+		return nil, err
 	}
-	return found.handler(args)
-}
-
-func (c commands) parse(line string) (string, []string, error) {
-	line = strings.TrimSpace(line)
-	if line == "" {
-		return "", nil, fmt.Errorf("empty command")
+	cl, err := client.New(client.Opts{Servers: conf.Servers, Clusters: conf.Clusters})
+	if err != nil {
+		return nil, err
 	}
-	// Space must be escaped for arguments. The filesystem supports space, but parsing doesn't.
-	splitted := strings.Split(line, " ")
-	if len(splitted) > 1 {
-		return splitted[0], splitted[1:], nil
+	if err := cl.Dial(context.Background()); err != nil {
+		return nil, err
 	}
-	return splitted[0], []string{}, nil
+	return cl, nil
 }
 
-type item struct {
-	name  string
-	isDir bool
+func (c commands) umount(ctx context.Context, args []string) error {
+	mounter, ok := c.fs.(fs.Mounter)
+	if !ok {
+		return fs.ErrNotSupported
+	}
+	if err := mounter.Unmount(args[0]); err != nil {
+		return err
+	}
+	if cl, ok := c.mounts[args[0]]; ok {
+		delete(c.mounts, args[0])
+		return cl.Close()
+	}
+	return nil
 }
-
-type itemSlice []item
-
-func (x itemSlice) Len() int           { return len(x) }
-func (x itemSlice) Less(i, j int) bool { return x[i].name < x[j].name }
-func (x itemSlice) Swap(i, j int)      { x[i], x[j] = x[j], x[i] }
@@ -6,49 +6,99 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
 
+	"github.com/basharal/filesystem/alias"
+	"github.com/basharal/filesystem/cli"
+	"github.com/basharal/filesystem/client"
 	"github.com/basharal/filesystem/fs"
-	"github.com/fatih/color"
+	"github.com/basharal/filesystem/fs/remotefs"
+	"github.com/basharal/filesystem/output"
+	"github.com/basharal/filesystem/session"
+	"github.com/golang/glog"
 )
 
 var (
-	flagHelp = flag.Bool("help", false, "print usage")
+	flagHelp   = flag.Bool("help", false, "print usage")
+	flagRC     = flag.String("rc", defaultRC(), "file with aliases and macros to load at startup")
+	flagRemote = flag.String("remote", "", "config file of a cluster to operate on instead of an in-memory filesystem (optional)")
+	flagState  = flag.String("state", session.DefaultPath("filesystem"), "file keeping the command history and last working directory across sessions")
+	flagOutput = output.RegisterFlags(flag.CommandLine)
+
+	// out prints the results of commands.
+	out *output.Printer
 )
 
-func processCommands(ctx context.Context, fs *fs.FileSystem, cmd commands) {
-	fmt.Println("Please enter filesystem command.")
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		default:
-			reader := bufio.NewReader(os.Stdin)
-			line, err := reader.ReadString('\n')
-			if err != nil {
-				color.Red(err.Error())
-				continue
-			}
-			if err := cmd.Handle(line); err != nil {
-				color.Red(err.Error())
-			}
-		}
+// defaultRC returns ~/.fsrc, or nothing if the home dir is unknown.
+func defaultRC() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
 	}
+	return filepath.Join(home, ".fsrc")
 }
 
 func main() {
 	flag.Parse()
-	fs := fs.New()
-	cmds := newCommands(fs)
+	printer, err := output.New(*flagOutput)
+	if err != nil {
+		glog.Fatal(err)
+	}
+	out = printer
+	aliases, err := alias.Load(*flagRC)
+	if err != nil {
+		glog.Fatal(err)
+	}
+	sess, err := session.Load(*flagState, session.DefaultMaxHistory)
+	if err != nil {
+		glog.Fatal(err)
+	}
+	var fs fs.Interface = fs.New()
+	if *flagRemote != "" {
+		cl, err := dialConfig(*flagRemote)
+		if err != nil {
+			glog.Fatal(err)
+		}
+		defer cl.Close()
+		fs = remotefs.New(cl, remotefs.Opts{})
+	}
+	registry := cli.NewRegistry()
+	env := &cli.Env{FS: cli.Local(fs), Input: bufio.NewReader(os.Stdin), Aliases: aliases, Session: sess, Out: out}
+	registry.Register(cli.Builtins(env)...)
+	cmds := commands{fs: fs, mounts: make(map[string]*client.Client)}
+	defer cmds.closeMounts()
+	registry.Register(cmds.list()...)
+	if dir := sess.Dir(); dir != "" {
+		if err := fs.ChangeDir(dir); err != nil {
+			glog.Warningf("Failed to restore working directory %s. %s\n", dir, err)
+		}
+	}
 
 	if *flagHelp {
-		supported := cmds.Supported()
-		for k, v := range supported {
-			fmt.Printf("%s - %s\n", k, v)
-		}
+		registry.PrintUsage()
 		return
 	}
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	processCommands(ctx, fs, cmds)
+	shell := &cli.Shell{
+		Registry: registry,
+		Input:    env.Input,
+		Aliases:  aliases,
+		Session:  sess,
+		After:    func() { sess.SetDir(fs.CurrentDir()) },
+	}
+	if flag.NArg() > 0 {
+		// A command given as arguments runs on its own, i.e., to pipe a file in or out of a remote
+		// filesystem with "-".
+		if err := shell.Handle(ctx, strings.Join(flag.Args(), " ")); err != nil {
+			cmds.closeMounts()
+			glog.Flush()
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+	shell.Run(ctx)
 }
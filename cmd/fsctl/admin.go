@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/basharal/filesystem/client"
+	"github.com/basharal/filesystem/proto/pb_filesystem"
+)
+
+// eachServer runs call on the admin service of every server addrs returns, printing a line per
+// server. It returns an error if any of the calls failed.
+func eachServer(ctx context.Context, c *client.Client, addr string, call func(pb_filesystem.FileAdminClient) (string, error)) error {
+	servers, err := addrs(c, addr)
+	if err != nil {
+		return err
+	}
+	failed := 0
+	for _, addr := range servers {
+		admin, err := c.Admin(addr)
+		var out string
+		if err == nil {
+			out, err = call(admin)
+		}
+		if err != nil {
+			failed++
+			fmt.Printf("%s: %s\n", addr, err)
+			continue
+		}
+		fmt.Printf("%s: %s\n", addr, out)
+	}
+	if failed > 0 {
+		return fmt.Errorf("failed on %d of %d servers", failed, len(servers))
+	}
+	return nil
+}
+
+func listServers(args []string) error {
+	flags := flag.NewFlagSet("servers", flag.ExitOnError)
+	t := targetFlags(flags)
+	flags.Parse(args)
+
+	ctx, cancel := context.WithTimeout(context.Background(), t.timeout)
+	defer cancel()
+	c, conf, err := t.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	print := func(addr string, server client.Server, role string) {
+		line := fmt.Sprintf("%s %s [%s, %s)", addr, role, server.StartPrefix, server.EndPrefix)
+		info := c.ServerInfo(addr)
+		switch {
+		case info == nil:
+			line += " unreachable"
+		case info.Version == "":
+			line += " pre-1.1"
+		default:
+			if info.StartPrefix != server.StartPrefix || info.EndPrefix != server.EndPrefix {
+				line += fmt.Sprintf(" serving [%s, %s)", info.StartPrefix, info.EndPrefix)
+			}
+			line += fmt.Sprintf(" v%s %s", info.Version, strings.Join(info.Features, ","))
+		}
+		fmt.Println(line)
+	}
+	for _, server := range conf.servers() {
+		print(server.Addr, server, "primary")
+		for _, replica := range server.Replicas {
+			print(replica, server, "replica")
+		}
+	}
+	return nil
+}
+
+func snapshot(args []string) error {
+	flags := flag.NewFlagSet("snapshot", flag.ExitOnError)
+	t := targetFlags(flags)
+	addr := flags.String("addr", "", "server to snapshot. defaults to all of them")
+	flags.Parse(args)
+
+	ctx, cancel := context.WithTimeout(context.Background(), t.timeout)
+	defer cancel()
+	c, _, err := t.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	return eachServer(ctx, c, *addr, func(admin pb_filesystem.FileAdminClient) (string, error) {
+		resp, err := admin.TakeSnapshot(ctx, &pb_filesystem.TakeSnapshotRequest{})
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("took snapshot %s", time.Unix(0, resp.TakenUnixMs*int64(time.Millisecond)).Format(time.RFC3339)), nil
+	})
+}
+
+func gc(args []string) error {
+	flags := flag.NewFlagSet("gc", flag.ExitOnError)
+	t := targetFlags(flags)
+	addr := flags.String("addr", "", "server to run the janitor of. defaults to all of them")
+	flags.Parse(args)
+
+	ctx, cancel := context.WithTimeout(context.Background(), t.timeout)
+	defer cancel()
+	c, _, err := t.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	return eachServer(ctx, c, *addr, func(admin pb_filesystem.FileAdminClient) (string, error) {
+		resp, err := admin.RunJanitor(ctx, &pb_filesystem.RunJanitorRequest{})
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("removed %d files, archived %d files", len(resp.Removed), len(resp.Archived)), nil
+	})
+}
+
+func drain(args []string) error {
+	flags := flag.NewFlagSet("drain", flag.ExitOnError)
+	t := targetFlags(flags)
+	addr := flags.String("addr", "", "server to drain")
+	undo := flags.Bool("undo", false, "have the server take writes again")
+	flags.Parse(args)
+	if *addr == "" {
+		flags.Usage()
+		return fmt.Errorf("-addr is required")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), t.timeout)
+	defer cancel()
+	c, _, err := t.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	return eachServer(ctx, c, *addr, func(admin pb_filesystem.FileAdminClient) (string, error) {
+		if _, err := admin.Drain(ctx, &pb_filesystem.DrainRequest{Drain: !*undo}); err != nil {
+			return "", err
+		}
+		if *undo {
+			return "taking writes", nil
+		}
+		return "draining", nil
+	})
+}
+
+func stats(args []string) error {
+	flags := flag.NewFlagSet("stats", flag.ExitOnError)
+	t := targetFlags(flags)
+	addr := flags.String("addr", "", "server to show. defaults to all of them")
+	flags.Parse(args)
+
+	ctx, cancel := context.WithTimeout(context.Background(), t.timeout)
+	defer cancel()
+	c, _, err := t.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	limit := func(n int64) string {
+		if n == 0 {
+			return "none"
+		}
+		return fmt.Sprint(n)
+	}
+	return eachServer(ctx, c, *addr, func(admin pb_filesystem.FileAdminClient) (string, error) {
+		s, err := admin.GetStats(ctx, &pb_filesystem.StatsRequest{})
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("size=%d files=%d dirs=%d max_file_size=%s memory_budget=%s in_flight=%d rejected=%d requests=%d checksum_errors=%d ready=%t draining=%t",
+			s.Size, s.Files, s.Dirs, limit(s.MaxFileSize), limit(s.MemoryBudget), s.InFlightBytes, s.RejectedWrites,
+			s.Requests, s.ChecksumErrors, s.Ready, s.Draining), nil
+	})
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"github.com/basharal/filesystem/client"
+)
+
+// defaultRange is what clusters are split over without -boundaries: lowercase names, since '{'
+// follows 'z'.
+const defaultStart, defaultEnd = 'a', '{'
+
+// unit is the systemd unit of a server.
+var unit = template.Must(template.New("unit").Parse(`[Unit]
+Description=filesystem file server for [{{.Start}}, {{.End}})
+Wants=network-online.target
+After=network-online.target
+
+[Service]
+ExecStart={{.Binary}} {{.Flags}}
+Restart=on-failure
+
+[Install]
+WantedBy=multi-user.target
+`))
+
+// split returns the n+1 boundaries of n even ranges of [defaultStart, defaultEnd).
+func split(n int) []string {
+	width := int(defaultEnd - defaultStart)
+	boundaries := make([]string, 0, n+1)
+	for i := 0; i <= n; i++ {
+		boundaries = append(boundaries, string(rune(defaultStart+i*width/n)))
+	}
+	return boundaries
+}
+
+// parseBoundaries returns the boundaries of the ranges of n servers in s (i.e., a,i,q,{), which
+// must be n+1 increasing prefixes relative to the root (i.e., projects/a).
+func parseBoundaries(s string, n int) ([]string, error) {
+	if s == "" {
+		if n > int(defaultEnd-defaultStart) {
+			return nil, fmt.Errorf("can't split %c-%c over %d servers, set -boundaries", defaultStart, defaultEnd-1, n)
+		}
+		return split(n), nil
+	}
+	boundaries := strings.Split(s, ",")
+	if len(boundaries) != n+1 {
+		return nil, fmt.Errorf("-boundaries needs %d prefixes for %d hosts, got %d", n+1, n, len(boundaries))
+	}
+	for i, b := range boundaries {
+		if b == "" || strings.HasPrefix(b, "/") {
+			return nil, fmt.Errorf("boundary %q must be a non-empty prefix relative to the root", b)
+		}
+		if i > 0 && boundaries[i-1] >= b {
+			return nil, fmt.Errorf("boundaries must increase, got %s before %s", boundaries[i-1], b)
+		}
+	}
+	return boundaries, nil
+}
+
+func bootstrap(args []string) error {
+	flags := flag.NewFlagSet("bootstrap", flag.ExitOnError)
+	hosts := flags.String("hosts", "", "comma-separated host:port of the servers, in the order of their ranges")
+	boundaries := flags.String("boundaries", "", "comma-separated prefixes splitting the namespace, one more than hosts "+
+		"(i.e., a,i,q,{). defaults to splitting a-z evenly")
+	out := flags.String("out", ".", "dir to write config.json and the servers' flags/units to")
+	systemd := flags.Bool("systemd", false, "also write a systemd unit per server")
+	binary := flags.String("binary", "/usr/local/bin/file_server", "path of file_server in the systemd units")
+	extra := flags.String("server_flags", "", "more flags for every server (i.e., -meta_dir=/var/lib/fs)")
+	check := flags.Bool("verify", false, "check the running servers against the generated config afterwards")
+	flags.Parse(args)
+	if *hosts == "" || flags.NArg() != 0 {
+		flags.Usage()
+		return fmt.Errorf("wrong arguments")
+	}
+
+	addrs := strings.Split(*hosts, ",")
+	prefixes, err := parseBoundaries(*boundaries, len(addrs))
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(*out, 0755); err != nil {
+		return err
+	}
+	conf := clientConfig{}
+	for i, addr := range addrs {
+		_, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return fmt.Errorf("host %s: %w", addr, err)
+		}
+		server := client.Server{StartPrefix: prefixes[i], EndPrefix: prefixes[i+1], Addr: addr}
+		conf.Servers = append(conf.Servers, server)
+
+		serverFlags := fmt.Sprintf("-listen=0.0.0.0:%s -start_prefix=%s -end_prefix=%s", port, server.StartPrefix, server.EndPrefix)
+		if *extra != "" {
+			serverFlags += " " + *extra
+		}
+		// Hosts may run several servers, so files are named by host and port.
+		name := strings.NewReplacer(":", "_", "[", "", "]", "").Replace(addr)
+		if err := ioutil.WriteFile(filepath.Join(*out, name+".flags"), []byte(serverFlags+"\n"), 0644); err != nil {
+			return err
+		}
+		if *systemd {
+			f, err := os.Create(filepath.Join(*out, "file_server-"+name+".service"))
+			if err != nil {
+				return err
+			}
+			err = unit.Execute(f, struct{ Start, End, Binary, Flags string }{server.StartPrefix, server.EndPrefix, *binary, serverFlags})
+			if closeErr := f.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				return err
+			}
+		}
+		fmt.Printf("%s [%s, %s)\n", addr, server.StartPrefix, server.EndPrefix)
+	}
+	config := filepath.Join(*out, "config.json")
+	if err := writeConfig(config, &conf); err != nil {
+		return err
+	}
+	fmt.Printf("wrote %s\n", config)
+	if *check {
+		return verify([]string{"-config", config})
+	}
+	return nil
+}
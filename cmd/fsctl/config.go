@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/basharal/filesystem/client"
+)
+
+// clientConfig is the config of the distributed CLI. bootstrap only writes Servers.
+type clientConfig struct {
+	Servers  []client.Server  `json:"servers,omitempty"`
+	Clusters []client.Cluster `json:"clusters,omitempty"`
+}
+
+// servers returns the servers of all the clusters of conf.
+func (conf *clientConfig) servers() []client.Server {
+	servers := append([]client.Server(nil), conf.Servers...)
+	for _, cluster := range conf.Clusters {
+		servers = append(servers, cluster.Servers...)
+	}
+	return servers
+}
+
+func loadConfig(path string) (*clientConfig, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	conf := &clientConfig{}
+	if err := json.Unmarshal(b, conf); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return conf, nil
+}
+
+// writeConfig replaces the config at path atomically, so that clients loading it meanwhile get
+// either the old routing or the new one.
+func writeConfig(path string, conf *clientConfig) error {
+	b, err := json.MarshalIndent(conf, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, append(b, '\n'), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// target is the cluster a command runs against, set by the -config and -timeout flags.
+type target struct {
+	config  string
+	timeout time.Duration
+}
+
+func targetFlags(flags *flag.FlagSet) *target {
+	t := &target{}
+	flags.StringVar(&t.config, "config", "config.json", "client config of the cluster")
+	flags.DurationVar(&t.timeout, "timeout", 10*time.Second, "how long to wait for the servers")
+	return t
+}
+
+// dial loads the config and connects to its servers. Servers that can't be reached are left to
+// fail the calls to them. Gaps between servers are allowed, so that the servers of such configs
+// can be administered (and the gaps fixed with migrate).
+func (t *target) dial(ctx context.Context) (*client.Client, *clientConfig, error) {
+	conf, err := loadConfig(t.config)
+	if err != nil {
+		return nil, nil, err
+	}
+	c, err := client.New(client.Opts{Servers: conf.Servers, Clusters: conf.Clusters, AllowGaps: true})
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := c.Dial(ctx); err != nil {
+		return nil, nil, err
+	}
+	return c, conf, nil
+}
+
+// addrs returns the servers to run a command on: addr if set (which must be in the config), or all
+// of them.
+func addrs(c *client.Client, addr string) ([]string, error) {
+	if addr == "" {
+		return c.Addrs(), nil
+	}
+	for _, a := range c.Addrs() {
+		if a == addr {
+			return []string{addr}, nil
+		}
+	}
+	return nil, fmt.Errorf("server %s isn't in the config", addr)
+}
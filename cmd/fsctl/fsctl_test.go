@@ -0,0 +1,195 @@
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/basharal/filesystem/client"
+	"github.com/basharal/filesystem/server"
+	"google.golang.org/grpc"
+)
+
+// capture returns what run printed to stdout.
+func capture(t *testing.T, run func() error) (string, error) {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+	out := make(chan string)
+	go func() {
+		b, _ := ioutil.ReadAll(r)
+		out <- string(b)
+	}()
+	err = run()
+	w.Close()
+	return <-out, err
+}
+
+// startServer serves the range [start, end) on a local port, returning its address.
+func startServer(t *testing.T, start, end string) (*server.Server, string) {
+	t.Helper()
+	s, err := server.New(server.Opts{StartPrefix: start, EndPrefix: end})
+	if err != nil {
+		t.Fatal(err)
+	}
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := grpc.NewServer()
+	s.RegisterWith(g)
+	go g.Serve(lis)
+	t.Cleanup(g.Stop)
+	return s, lis.Addr().String()
+}
+
+func TestParseBoundaries(t *testing.T) {
+	tests := []struct {
+		s       string
+		n       int
+		want    []string
+		wantErr bool
+	}{
+		{s: "", n: 1, want: []string{"a", "{"}},
+		{s: "", n: 2, want: []string{"a", "n", "{"}},
+		{s: "a,i,q,{", n: 3, want: []string{"a", "i", "q", "{"}},
+		{s: "projects/a,projects/m", n: 1, want: []string{"projects/a", "projects/m"}},
+		{s: "", n: 27, wantErr: true},
+		{s: "a,i", n: 2, wantErr: true},
+		{s: "a,,q", n: 2, wantErr: true},
+		{s: "/a,q", n: 1, wantErr: true},
+		{s: "q,a", n: 1, wantErr: true},
+		{s: "a,a", n: 1, wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseBoundaries(tt.s, tt.n)
+		if (err != nil) != tt.wantErr || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseBoundaries(%q, %d) = %q, %v, want %q, wantErr %v", tt.s, tt.n, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestBootstrap(t *testing.T) {
+	_, first := startServer(t, "a", "n")
+	_, second := startServer(t, "n", "{")
+	out := t.TempDir()
+	args := []string{"-hosts", first + "," + second, "-out", out, "-systemd", "-server_flags", "-meta_dir=/var/lib/fs", "-verify"}
+	printed, err := capture(t, func() error { return bootstrap(args) })
+	if err != nil {
+		t.Fatalf("bootstrap() = %v\n%s", err, printed)
+	}
+	if !strings.Contains(printed, "all 2 servers match") {
+		t.Errorf("bootstrap() printed %q, want the servers verified", printed)
+	}
+
+	conf, err := loadConfig(filepath.Join(out, "config.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []client.Server{{StartPrefix: "a", EndPrefix: "n", Addr: first}, {StartPrefix: "n", EndPrefix: "{", Addr: second}}
+	if !reflect.DeepEqual(conf.Servers, want) {
+		t.Errorf("bootstrap() wrote servers %+v, want %+v", conf.Servers, want)
+	}
+	name := strings.Replace(first, ":", "_", 1)
+	_, port, _ := net.SplitHostPort(first)
+	flags, err := ioutil.ReadFile(filepath.Join(out, name+".flags"))
+	if wantFlags := "-listen=0.0.0.0:" + port + " -start_prefix=a -end_prefix=n -meta_dir=/var/lib/fs\n"; err != nil || string(flags) != wantFlags {
+		t.Errorf("bootstrap() wrote flags %q, %v, want %q", flags, err, wantFlags)
+	}
+	if _, err := os.Stat(filepath.Join(out, "file_server-"+name+".service")); err != nil {
+		t.Errorf("bootstrap() didn't write the systemd unit. %s", err)
+	}
+
+	if _, err := capture(t, func() error { return bootstrap([]string{"-out", out}) }); err == nil {
+		t.Errorf("bootstrap() without -hosts succeeded")
+	}
+}
+
+func TestCommands(t *testing.T) {
+	firstServer, first := startServer(t, "a", "n")
+	_, second := startServer(t, "n", "{")
+	dir := t.TempDir()
+	config := filepath.Join(dir, "config.json")
+	err := writeConfig(config, &clientConfig{Servers: []client.Server{
+		{StartPrefix: "a", EndPrefix: "n", Addr: first}, {StartPrefix: "n", EndPrefix: "{", Addr: second},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// mismatched gives the servers ranges they don't serve.
+	mismatched := filepath.Join(dir, "mismatched.json")
+	err = writeConfig(mismatched, &clientConfig{Servers: []client.Server{
+		{StartPrefix: "a", EndPrefix: "m", Addr: first}, {StartPrefix: "m", EndPrefix: "{", Addr: second},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// gapped leaves [n, q) to no server.
+	gapped := filepath.Join(dir, "gapped.json")
+	err = writeConfig(gapped, &clientConfig{Servers: []client.Server{
+		{StartPrefix: "a", EndPrefix: "n", Addr: first}, {StartPrefix: "q", EndPrefix: "{", Addr: second},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		run      func([]string) error
+		args     []string
+		wantErr  bool
+		want     []string
+		draining bool
+	}{
+		{name: "validate", run: validate, args: []string{"-config", config}, want: []string{"is valid"}},
+		{name: "validate with a gap", run: validate, args: []string{"-config", gapped}, wantErr: true},
+		{name: "verify", run: verify, args: []string{"-config", config, "-probe"}, want: []string{"all 2 servers match"}},
+		{name: "verify mismatched", run: verify, args: []string{"-config", mismatched}, wantErr: true, want: []string{first, second}},
+		{name: "verify missing config", run: verify, args: []string{"-config", filepath.Join(dir, "missing.json")}, wantErr: true},
+		{
+			name: "servers",
+			run:  listServers,
+			args: []string{"-config", config},
+			want: []string{first + " primary [a, n) v", second + " primary [n, {) v"},
+		},
+		{
+			name: "servers mismatched",
+			run:  listServers,
+			args: []string{"-config", mismatched},
+			want: []string{first + " primary [a, m) serving [a, n)"},
+		},
+		{name: "stats", run: stats, args: []string{"-config", config}, want: []string{first + ": size=", second + ": size="}},
+		{name: "stats of one", run: stats, args: []string{"-config", config, "-addr", second}, want: []string{second + ": size="}},
+		{name: "stats of unknown", run: stats, args: []string{"-config", config, "-addr", "10.0.0.1:1"}, wantErr: true},
+		{name: "drain", run: drain, args: []string{"-config", config, "-addr", first}, want: []string{first + ": draining"}, draining: true},
+		{name: "drain undo", run: drain, args: []string{"-config", config, "-addr", first, "-undo"}, want: []string{first + ": taking writes"}},
+		{name: "drain without addr", run: drain, args: []string{"-config", config}, wantErr: true},
+		{name: "topology", run: showTopology, args: []string{"-config", config}, want: []string{"digraph", first, second}},
+		{name: "topology unknown format", run: showTopology, args: []string{"-config", config, "-format", "svg"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			printed, err := capture(t, func() error { return tt.run(tt.args) })
+			if (err != nil) != tt.wantErr {
+				t.Errorf("%v = %v, wantErr %v\n%s", tt.args, err, tt.wantErr, printed)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(printed, want) {
+					t.Errorf("%v printed %q, want %q", tt.args, printed, want)
+				}
+			}
+			if firstServer.Draining() != tt.draining {
+				t.Errorf("%v left the server draining: %v", tt.args, firstServer.Draining())
+			}
+		})
+	}
+}
@@ -0,0 +1,76 @@
+// fsctl administers clusters of file servers.
+//
+//	fsctl bootstrap -hosts 10.0.0.1:9800,10.0.0.2:9800 -out cluster/ [-systemd]
+//	fsctl verify -config cluster/config.json [-probe]
+//	fsctl validate -config cluster/config.json
+//	fsctl servers|stats -config cluster/config.json
+//	fsctl snapshot|gc [-addr 10.0.0.1:9800]
+//	fsctl drain -addr 10.0.0.1:9800 [-undo]
+//	fsctl migrate -boundary n -to m
+//	fsctl split -max_size 10737418240 -max_qps 500 [-to 10.0.0.3:9800] [-every 5m]
+//	fsctl topology -format dot | dot -Tsvg > topology.svg
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/golang/glog"
+)
+
+// command is a subcommand of fsctl.
+type command struct {
+	usage string
+	run   func(args []string) error
+}
+
+var commands = map[string]command{
+	"bootstrap": {"generates the flags (and systemd units) of the servers of a cluster and its client config", bootstrap},
+	"drain":     {"stops a server from taking writes, or has it take them again", drain},
+	"gc":        {"applies retention policies and lifecycle rules on the servers right away", gc},
+	"migrate":   {"moves the boundary between two adjacent servers and the files between them", migrate},
+	"servers":   {"lists the servers with their ranges, versions and features", listServers},
+	"snapshot":  {"takes snapshots of the servers right away", snapshot},
+	"split":     {"splits the range of a server over the size or QPS limits, moving its upper half to another server", splitRange},
+	"stats":     {"shows the usage, limits and admission counters of the servers", stats},
+	"topology":  {"renders the servers, their ranges, replicas and health as a dot graph or json", showTopology},
+	"validate":  {"checks the client config for gaps, overlaps and duplicate addresses", validate},
+	"verify":    {"checks the client config for gaps and overlaps and that the running servers serve the ranges it gives them", verify},
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: fsctl <command> [flags]. Run fsctl <command> -help for its flags.\n\n")
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "%s - %s\n", name, commands[name].usage)
+	}
+}
+
+func main() {
+	// The global flags are glog's. Subcommands parse their own.
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
+	cmd, ok := commands[flag.Arg(0)]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %s\n", flag.Arg(0))
+		usage()
+		os.Exit(2)
+	}
+	if err := cmd.run(flag.Args()[1:]); err != nil {
+		glog.Flush()
+		fmt.Fprintln(os.Stderr, strings.TrimSpace(err.Error()))
+		os.Exit(1)
+	}
+	glog.Flush()
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+
+	"github.com/basharal/filesystem/client"
+	"github.com/basharal/filesystem/fs"
+	"github.com/basharal/filesystem/fspath"
+	"github.com/basharal/filesystem/proto/pb_filesystem"
+)
+
+// setRange changes the range the server at addr serves.
+func setRange(ctx context.Context, c *client.Client, addr, start, end string) error {
+	admin, err := c.Admin(addr)
+	if err != nil {
+		return err
+	}
+	_, err = admin.SetRange(ctx, &pb_filesystem.SetRangeRequest{StartPrefix: start, EndPrefix: end})
+	return err
+}
+
+// dialServer returns a client of server alone, so that paths are routed to it whatever the config
+// says.
+func dialServer(ctx context.Context, server client.Server) (*client.Client, error) {
+	c, err := client.New(client.Opts{Servers: []client.Server{server}})
+	if err != nil {
+		return nil, err
+	}
+	if err := c.Dial(ctx); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// copyTree copies the file/dir at path from src to dst. Files dst has already (i.e., from an
+// interrupted migration) are replaced, since writes append. Entries are made with Parents, since
+// servers may not make nested paths otherwise.
+func copyTree(ctx context.Context, src, dst *client.Client, path string, isDir bool) error {
+	if !isDir {
+		if err := dst.Remove(ctx, path); err != nil && !errors.Is(err, fs.ErrNotFound) {
+			return err
+		}
+		if err := dst.CreateFile(ctx, path, client.Parents()); err != nil {
+			return err
+		}
+		r, w := io.Pipe()
+		go func() {
+			_, err := src.Read(ctx, path, w)
+			w.CloseWithError(err)
+		}()
+		_, err := dst.Write(ctx, path, r)
+		// Unblocks the read if the write stopped reading.
+		r.CloseWithError(err)
+		return err
+	}
+	if err := dst.MakeDir(ctx, path, client.Parents()); err != nil && !errors.Is(err, fs.ErrAlreadyExist) {
+		return err
+	}
+	files, dirs, err := src.ListDir(ctx, path)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if err := copyTree(ctx, src, dst, fspath.Join(path, file.Name), false); err != nil {
+			return err
+		}
+	}
+	for _, dir := range dirs {
+		if err := copyTree(ctx, src, dst, fspath.Join(path, dir.Name), true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// entry is a file/dir moved by migrate.
+type entry struct {
+	path  string
+	isDir bool
+}
+
+// movedEntries returns the entries under dir on src whose whole tree is in [lo, hi). Dirs that are
+// partly in the range (i.e., /projects when moving [projects/m, projects/t)) are made on dst and
+// searched instead, and stay on src too.
+func movedEntries(ctx context.Context, src, dst *client.Client, dir, lo, hi string) ([]entry, error) {
+	files, dirs, err := src.ListDir(ctx, dir)
+	if err != nil {
+		return nil, err
+	}
+	var moved []entry
+	for _, file := range files {
+		path := fspath.Join(dir, file.Name)
+		if rel := path[1:]; rel >= lo && (hi == "" || rel < hi) {
+			moved = append(moved, entry{path, false})
+		}
+	}
+	for _, d := range dirs {
+		path := fspath.Join(dir, d.Name)
+		// Paths under rel are in [rel/, rel0), since '0' follows '/'.
+		rel := path[1:]
+		switch {
+		case rel >= lo && (hi == "" || rel+"0" <= hi):
+			moved = append(moved, entry{path, true})
+		case lo < rel+"0" && (hi == "" || hi > rel+"/"):
+			if err := dst.MakeDir(ctx, path, client.Parents()); err != nil && !errors.Is(err, fs.ErrAlreadyExist) {
+				return nil, err
+			}
+			under, err := movedEntries(ctx, src, dst, path, lo, hi)
+			if err != nil {
+				return nil, err
+			}
+			moved = append(moved, under...)
+		}
+	}
+	return moved, nil
+}
+
+// moveRange copies the entries in [lo, hi) (where an empty hi is open) from src to dst, which must
+// serve the range already, and then removes them from src. It returns how many were moved.
+func moveRange(ctx context.Context, src, dst client.Server, lo, hi string) (int, error) {
+	srcClient, err := dialServer(ctx, src)
+	if err != nil {
+		return 0, err
+	}
+	defer srcClient.Close()
+	dstClient, err := dialServer(ctx, dst)
+	if err != nil {
+		return 0, err
+	}
+	defer dstClient.Close()
+	moved, err := movedEntries(ctx, srcClient, dstClient, fspath.Root, lo, hi)
+	if err != nil {
+		return 0, err
+	}
+	for _, e := range moved {
+		if err := copyTree(ctx, srcClient, dstClient, e.path, e.isDir); err != nil {
+			return 0, fmt.Errorf("failed to copy %s. %w", e.path, err)
+		}
+	}
+	for _, e := range moved {
+		if _, err := srcClient.DeletePrefix(ctx, e.path, false); err != nil {
+			return 0, fmt.Errorf("failed to remove %s from %s. %w", e.path, src.Addr, err)
+		}
+	}
+	return len(moved), nil
+}
+
+// migrate moves the boundary between two adjacent servers, and the files between the old and new
+// boundaries with it. The server taking the range over serves it first, then the files are copied
+// over and removed from the other server, which stops serving the range last. Writes to the moved
+// range must be paused meanwhile, since writes landing on the old server after their files were
+// copied are lost.
+func migrate(args []string) error {
+	flags := flag.NewFlagSet("migrate", flag.ExitOnError)
+	t := targetFlags(flags)
+	boundary := flags.String("boundary", "", "current prefix between the two servers (the end of one and the start of the other)")
+	to := flags.String("to", "", "new prefix between the two servers (i.e., n or projects/m)")
+	flags.Parse(args)
+	if *boundary == "" || *to == "" || *boundary == *to {
+		flags.Usage()
+		return fmt.Errorf("-boundary and -to must be different prefixes")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), t.timeout)
+	defer cancel()
+	c, conf, err := t.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	if len(conf.Clusters) != 0 {
+		return fmt.Errorf("migrating configs with clusters isn't supported")
+	}
+	i := 0
+	for i < len(conf.Servers)-1 && (conf.Servers[i].EndPrefix != *boundary || conf.Servers[i+1].StartPrefix != *boundary) {
+		i++
+	}
+	if i == len(conf.Servers)-1 {
+		return fmt.Errorf("no two servers meet at %s in %s", *boundary, t.config)
+	}
+	left, right := &conf.Servers[i], &conf.Servers[i+1]
+	if *to <= left.StartPrefix || right.EndPrefix != "" && *to >= right.EndPrefix {
+		return fmt.Errorf("%s must be in (%s, %s)", *to, left.StartPrefix, right.EndPrefix)
+	}
+	if len(left.Replicas) != 0 || len(right.Replicas) != 0 {
+		return fmt.Errorf("migrating servers with replicas isn't supported")
+	}
+
+	// The moved range is [lo, hi).
+	src, dst, lo, hi := *left, *right, *to, *boundary
+	dst.StartPrefix = *to
+	if *to > *boundary {
+		src, dst, lo, hi = *right, *left, *boundary, *to
+		dst.EndPrefix = *to
+	}
+	fmt.Printf("moving [%s, %s) from %s to %s\n", lo, hi, src.Addr, dst.Addr)
+	if err := setRange(ctx, c, dst.Addr, dst.StartPrefix, dst.EndPrefix); err != nil {
+		return fmt.Errorf("failed to widen the range of %s. %w", dst.Addr, err)
+	}
+
+	// Copying isn't bound by -timeout.
+	work := context.Background()
+	n, err := moveRange(work, src, dst, lo, hi)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("moved %d entries\n", n)
+
+	// src serves the rest of its range.
+	src.StartPrefix, src.EndPrefix = left.StartPrefix, *to
+	if src.Addr == right.Addr {
+		src.StartPrefix, src.EndPrefix = *to, right.EndPrefix
+	}
+	if err := setRange(work, c, src.Addr, src.StartPrefix, src.EndPrefix); err != nil {
+		return fmt.Errorf("failed to shrink the range of %s. %w", src.Addr, err)
+	}
+	left.EndPrefix, right.StartPrefix = *to, *to
+	if err := writeConfig(t.config, conf); err != nil {
+		return err
+	}
+	fmt.Printf("wrote %s\n", t.config)
+	return nil
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/basharal/filesystem/client"
+	"github.com/basharal/filesystem/fs"
+)
+
+// dialTestServer returns a client of a server startServer started for [start, end).
+func dialTestServer(t *testing.T, start, end string) (client.Server, *client.Client) {
+	t.Helper()
+	_, addr := startServer(t, start, end)
+	server := client.Server{StartPrefix: start, EndPrefix: end, Addr: addr}
+	c, err := dialServer(context.Background(), server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return server, c
+}
+
+// put writes content to the file at path through c, making its parents.
+func put(t *testing.T, c *client.Client, path, content string) {
+	t.Helper()
+	ctx := context.Background()
+	if err := c.CreateFile(ctx, path, client.Parents()); err != nil && !errors.Is(err, fs.ErrAlreadyExist) {
+		t.Fatal(err)
+	}
+	if _, err := c.Write(ctx, path, strings.NewReader(content)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// content returns the content of the file at path through c, or "" if it's missing.
+func content(t *testing.T, c *client.Client, path string) string {
+	t.Helper()
+	var buf bytes.Buffer
+	if _, err := c.Read(context.Background(), path, &buf); err != nil {
+		if errors.Is(err, fs.ErrNotFound) {
+			return ""
+		}
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+// putTree puts files at paths on c, each with its path as content.
+func putTree(t *testing.T, c *client.Client, paths ...string) {
+	t.Helper()
+	for _, path := range paths {
+		put(t, c, path, path)
+	}
+}
+
+var testTree = []string{"/apple", "/melon", "/projects/a", "/projects/z/x", "/zoo/x"}
+
+func TestMovedEntries(t *testing.T) {
+	tests := []struct {
+		lo, hi string
+		want   []entry
+	}{
+		{lo: "a", hi: "m", want: []entry{{"/apple", false}}},
+		{lo: "m", hi: "", want: []entry{{"/melon", false}, {"/projects", true}, {"/zoo", true}}},
+		{lo: "projects/m", hi: "", want: []entry{{"/projects/z", true}, {"/zoo", true}}},
+		{lo: "m", hi: "projects/m", want: []entry{{"/melon", false}, {"/projects/a", false}}},
+		{lo: "q", hi: "z", want: nil},
+	}
+	for _, tt := range tests {
+		_, src := dialTestServer(t, "a", "{")
+		_, dst := dialTestServer(t, "a", "{")
+		putTree(t, src, testTree...)
+		got, err := movedEntries(context.Background(), src, dst, "/", tt.lo, tt.hi)
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("movedEntries(%s, %s) = %v, %v, want %v", tt.lo, tt.hi, got, err, tt.want)
+		}
+	}
+}
+
+func TestCopyTree(t *testing.T) {
+	_, src := dialTestServer(t, "a", "{")
+	_, dst := dialTestServer(t, "a", "{")
+	putTree(t, src, "/projects/a", "/projects/z/x")
+	// dst has part of the tree from an interrupted copy.
+	put(t, dst, "/projects/a", "/proj")
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		if err := copyTree(ctx, src, dst, "/projects", true); err != nil {
+			t.Fatalf("copyTree() = %v", err)
+		}
+		for _, path := range []string{"/projects/a", "/projects/z/x"} {
+			if got := content(t, dst, path); got != path {
+				t.Errorf("copyTree() copied %q to %s, want %q", got, path, path)
+			}
+		}
+	}
+}
+
+func TestMoveRange(t *testing.T) {
+	src, srcClient := dialTestServer(t, "a", "{")
+	dst, dstClient := dialTestServer(t, "projects/m", "{")
+	putTree(t, srcClient, testTree...)
+	// A previous run was interrupted after copying part of /projects/z/x.
+	put(t, dstClient, "/projects/z/x", "/proj")
+
+	ctx := context.Background()
+	n, err := moveRange(ctx, src, dst, "projects/m", "")
+	if err != nil || n != 2 {
+		t.Fatalf("moveRange() = %d, %v, want 2 entries moved", n, err)
+	}
+	for _, path := range []string{"/projects/z/x", "/zoo/x"} {
+		if got := content(t, dstClient, path); got != path {
+			t.Errorf("moveRange() moved %q to %s, want %q", got, path, path)
+		}
+		if got := content(t, srcClient, path); got != "" {
+			t.Errorf("moveRange() left %s on src", path)
+		}
+	}
+	for _, path := range []string{"/apple", "/melon", "/projects/a"} {
+		if got := content(t, srcClient, path); got != path {
+			t.Errorf("moveRange() changed %s on src to %q", path, got)
+		}
+	}
+
+	// Running it again moves nothing and leaves dst as is.
+	if n, err := moveRange(ctx, src, dst, "projects/m", ""); err != nil || n != 0 {
+		t.Errorf("moveRange() again = %d, %v, want nothing moved", n, err)
+	}
+	if got := content(t, dstClient, "/zoo/x"); got != "/zoo/x" {
+		t.Errorf("moveRange() again changed /zoo/x on dst to %q", got)
+	}
+}
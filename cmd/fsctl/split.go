@@ -0,0 +1,258 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/basharal/filesystem/client"
+	"github.com/basharal/filesystem/fspath"
+	"github.com/basharal/filesystem/proto/pb_filesystem"
+	"github.com/golang/glog"
+)
+
+// errNoSplit is returned by splitPoint for ranges that can't be split, i.e., a single file.
+var errNoSplit = errors.New("the range can't be split")
+
+// limits are the thresholds above which the range of a server is split. Zero means no limit.
+type limits struct {
+	size int64
+	qps  float64
+}
+
+// load is the usage of a server, with its QPS sampled over an interval.
+type load struct {
+	size int64
+	qps  float64
+}
+
+// ratio returns how loaded the server is relative to lim: above 1 if it exceeds either limit.
+func (l load) ratio(lim limits) float64 {
+	r := 0.0
+	if lim.size > 0 {
+		r = float64(l.size) / float64(lim.size)
+	}
+	if lim.qps > 0 && l.qps/lim.qps > r {
+		r = l.qps / lim.qps
+	}
+	return r
+}
+
+// sampleLoad returns the load of servers, counting their requests over interval.
+func sampleLoad(ctx context.Context, c *client.Client, servers []client.Server, interval time.Duration) ([]load, error) {
+	stats := func() ([]*pb_filesystem.ServerStats, error) {
+		all := make([]*pb_filesystem.ServerStats, len(servers))
+		for i, server := range servers {
+			admin, err := c.Admin(server.Addr)
+			if err != nil {
+				return nil, err
+			}
+			if all[i], err = admin.GetStats(ctx, &pb_filesystem.StatsRequest{}); err != nil {
+				return nil, fmt.Errorf("failed to get the stats of %s. %w", server.Addr, err)
+			}
+		}
+		return all, nil
+	}
+	before, err := stats()
+	if err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	select {
+	case <-time.After(interval):
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	after, err := stats()
+	if err != nil {
+		return nil, err
+	}
+	elapsed := time.Since(start).Seconds()
+	loads := make([]load, len(servers))
+	for i := range servers {
+		loads[i] = load{size: after[i].Size, qps: float64(after[i].Requests-before[i].Requests) / elapsed}
+	}
+	return loads, nil
+}
+
+// splitPoint returns the prefix splitting what the server of c holds under dir in [start, end)
+// into two halves by size. It searches the dirs holding most of the range, so that ranges made of
+// a few large dirs are split within them (i.e., at projects/m).
+func splitPoint(ctx context.Context, c *client.Client, dir, start, end string) (string, error) {
+	all, err := c.ListEntries(ctx, dir)
+	if err != nil {
+		return "", err
+	}
+	// Entries are in the range if anything under them is. Paths under rel are in [rel/, rel0).
+	var entries []*pb_filesystem.Entry
+	var total int64
+	for _, e := range all {
+		rel := fspath.Join(dir, e.Name)[1:]
+		if start < rel+"0" && (end == "" || rel < end) {
+			entries = append(entries, e)
+			total += e.Size
+		}
+	}
+	var acc int64
+	for i, e := range entries {
+		rel := fspath.Join(dir, e.Name)[1:]
+		if 2*(acc+e.Size) <= total {
+			acc += e.Size
+			continue
+		}
+		if acc > 0 && rel > start {
+			return rel, nil
+		}
+		// e holds most of the range.
+		if _, ok := e.Node.(*pb_filesystem.Entry_Dir); ok {
+			mid, err := splitPoint(ctx, c, fspath.Join(dir, e.Name), start, end)
+			if !errors.Is(err, errNoSplit) {
+				return mid, err
+			}
+		}
+		if i+1 < len(entries) {
+			return fspath.Join(dir, entries[i+1].Name)[1:], nil
+		}
+		break
+	}
+	return "", errNoSplit
+}
+
+// splitRange is the coordinator splitting hot or large ranges. It samples the servers' load and
+// splits the range of the most loaded one above the limits in two halves by size, moving the upper
+// half to an empty server (-to) or to the next server if that one is underloaded. The moved range is
+// served by its new server first and by the old one last, and the config is replaced atomically
+// once it's moved. Like with migrate, writes to the moved range must be paused meanwhile.
+func splitRange(args []string) error {
+	flags := flag.NewFlagSet("split", flag.ExitOnError)
+	t := targetFlags(flags)
+	maxSize := flags.Int64("max_size", 0, "bytes a server can hold before its range is split. 0 for no limit")
+	maxQPS := flags.Float64("max_qps", 0, "requests per second a server can take before its range is split. 0 for no limit")
+	interval := flags.Duration("interval", 10*time.Second, "how long to sample the servers' requests for")
+	to := flags.String("to", "", "address of an empty server to move the upper half of the range to. "+
+		"defaults to the next server if it's under half the limits")
+	every := flags.Duration("every", 0, "keep checking the servers this often instead of once")
+	dryRun := flags.Bool("dry_run", false, "only print the split")
+	flags.Parse(args)
+	lim := limits{size: *maxSize, qps: *maxQPS}
+	if lim.size <= 0 && lim.qps <= 0 {
+		flags.Usage()
+		return fmt.Errorf("-max_size or -max_qps must be set")
+	}
+	for {
+		done, err := splitOnce(t, lim, *interval, *to, *dryRun)
+		if err != nil {
+			return err
+		}
+		if *every == 0 {
+			return nil
+		}
+		// -to is a single server, which only takes one split.
+		if done && !*dryRun {
+			*to = ""
+		}
+		time.Sleep(*every)
+	}
+}
+
+// splitOnce does a round of splitRange, returning true if a range was split.
+func splitOnce(t *target, lim limits, interval time.Duration, to string, dryRun bool) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), t.timeout+interval)
+	defer cancel()
+	c, conf, err := t.dial(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer c.Close()
+	if len(conf.Clusters) != 0 {
+		return false, fmt.Errorf("splitting configs with clusters isn't supported")
+	}
+	loads, err := sampleLoad(ctx, c, conf.Servers, interval)
+	if err != nil {
+		return false, err
+	}
+	i := -1
+	for j, l := range loads {
+		if l.ratio(lim) > 1 && (i == -1 || l.ratio(lim) > loads[i].ratio(lim)) {
+			i = j
+		}
+	}
+	if i == -1 {
+		glog.V(1).Infof("no server exceeds the limits\n")
+		return false, nil
+	}
+	src := &conf.Servers[i]
+	if len(src.Replicas) != 0 {
+		return false, fmt.Errorf("splitting servers with replicas isn't supported")
+	}
+	srcClient, err := dialServer(ctx, *src)
+	if err != nil {
+		return false, err
+	}
+	mid, err := splitPoint(ctx, srcClient, fspath.Root, src.StartPrefix, src.EndPrefix)
+	srcClient.Close()
+	if err != nil {
+		return false, fmt.Errorf("failed to split [%s, %s) of %s. %w", src.StartPrefix, src.EndPrefix, src.Addr, err)
+	}
+
+	// dst takes [mid, end) over.
+	var dst client.Server
+	next := -1
+	if to != "" {
+		for _, s := range conf.Servers {
+			if s.Addr == to {
+				return false, fmt.Errorf("%s is in %s already", to, t.config)
+			}
+		}
+		dst = client.Server{StartPrefix: mid, EndPrefix: src.EndPrefix, Addr: to}
+	} else {
+		for j, s := range conf.Servers {
+			if src.EndPrefix != "" && s.StartPrefix == src.EndPrefix {
+				next = j
+			}
+		}
+		if next == -1 || 2*loads[next].ratio(lim) >= 1 || len(conf.Servers[next].Replicas) != 0 {
+			return false, fmt.Errorf("%s is over the limits but the next server can't take half of its range, set -to", src.Addr)
+		}
+		dst = conf.Servers[next]
+		dst.StartPrefix = mid
+	}
+	fmt.Printf("splitting [%s, %s) of %s at %s, moving [%s, %s) to %s\n",
+		src.StartPrefix, src.EndPrefix, src.Addr, mid, mid, src.EndPrefix, dst.Addr)
+	if dryRun {
+		return true, nil
+	}
+	dstClient, err := dialServer(ctx, dst)
+	if err != nil {
+		return false, err
+	}
+	err = setRange(ctx, dstClient, dst.Addr, dst.StartPrefix, dst.EndPrefix)
+	dstClient.Close()
+	if err != nil {
+		return false, fmt.Errorf("failed to widen the range of %s. %w", dst.Addr, err)
+	}
+
+	// Copying isn't bound by -timeout.
+	work := context.Background()
+	n, err := moveRange(work, *src, dst, mid, src.EndPrefix)
+	if err != nil {
+		return false, err
+	}
+	fmt.Printf("moved %d entries\n", n)
+	if err := setRange(work, c, src.Addr, src.StartPrefix, mid); err != nil {
+		return false, fmt.Errorf("failed to shrink the range of %s. %w", src.Addr, err)
+	}
+	src.EndPrefix = mid
+	if next == -1 {
+		conf.Servers = append(conf.Servers[:i+1], append([]client.Server{dst}, conf.Servers[i+1:]...)...)
+	} else {
+		conf.Servers[next].StartPrefix = mid
+	}
+	if err := writeConfig(t.config, conf); err != nil {
+		return false, err
+	}
+	fmt.Printf("wrote %s\n", t.config)
+	return true, nil
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/basharal/filesystem/client"
+)
+
+func TestSplitPoint(t *testing.T) {
+	tests := []struct {
+		name       string
+		sizes      map[string]int
+		start, end string
+		want       string
+		wantErr    error
+	}{
+		{
+			name:  "one large dir",
+			sizes: map[string]int{"/projects/a": 10, "/projects/z": 10, "/zoo": 1},
+			start: "a", end: "{",
+			want: "projects/z",
+		},
+		{
+			name:  "large file first",
+			sizes: map[string]int{"/apple": 100, "/melon": 5},
+			start: "a", end: "n",
+			want: "melon",
+		},
+		{
+			name:  "single file",
+			sizes: map[string]int{"/apple": 100},
+			start: "a", end: "{",
+			wantErr: errNoSplit,
+		},
+		{
+			// The last shard is open-ended and only holds what's above its start.
+			name:  "open-ended last shard",
+			sizes: map[string]int{"/apple": 100, "/nut": 5, "/zoo": 5},
+			start: "n", end: "",
+			want: "zoo",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, c := dialTestServer(t, "a", "{")
+			for path, size := range tt.sizes {
+				put(t, c, path, strings.Repeat("x", size))
+			}
+			got, err := splitPoint(context.Background(), c, "/", tt.start, tt.end)
+			if !errors.Is(err, tt.wantErr) || got != tt.want {
+				t.Errorf("splitPoint(%s, %s) = %q, %v, want %q, %v", tt.start, tt.end, got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSplitOnce(t *testing.T) {
+	tests := []struct {
+		name   string
+		to     bool
+		dryRun bool
+	}{
+		{name: "to the next server"},
+		{name: "to an empty server", to: true},
+		{name: "dry run", dryRun: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			first, firstClient := dialTestServer(t, "a", "n")
+			second, _ := dialTestServer(t, "n", "{")
+			putTree(t, firstClient, "/apple", "/melon")
+			config := filepath.Join(t.TempDir(), "config.json")
+			if err := writeConfig(config, &clientConfig{Servers: []client.Server{first, second}}); err != nil {
+				t.Fatal(err)
+			}
+			var to string
+			want := []client.Server{
+				{StartPrefix: "a", EndPrefix: "melon", Addr: first.Addr},
+				{StartPrefix: "melon", EndPrefix: "{", Addr: second.Addr},
+			}
+			if tt.to {
+				_, to = startServer(t, "x", "y")
+				want = []client.Server{
+					{StartPrefix: "a", EndPrefix: "melon", Addr: first.Addr},
+					{StartPrefix: "melon", EndPrefix: "n", Addr: to},
+					second,
+				}
+			}
+			if tt.dryRun {
+				want = []client.Server{first, second}
+			}
+
+			tg := &target{config: config, timeout: 5 * time.Second}
+			var split bool
+			printed, err := capture(t, func() error {
+				var err error
+				split, err = splitOnce(tg, limits{size: 5}, time.Millisecond, to, tt.dryRun)
+				return err
+			})
+			if err != nil || !split {
+				t.Fatalf("splitOnce() = %v, %v, want a split\n%s", split, err, printed)
+			}
+			if !strings.Contains(printed, "at melon") {
+				t.Errorf("splitOnce() printed %q, want the split at melon", printed)
+			}
+			conf, err := loadConfig(config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(conf.Servers, want) {
+				t.Errorf("splitOnce() wrote servers %+v, want %+v", conf.Servers, want)
+			}
+			// The files are where the config routes them.
+			c, _, err := tg.dial(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.Close()
+			for _, path := range []string{"/apple", "/melon"} {
+				if got := content(t, c, path); got != path {
+					t.Errorf("splitOnce() left %q at %s", got, path)
+				}
+			}
+
+			// Nothing is over the limits that high.
+			split, err = splitOnce(tg, limits{size: 1 << 20}, time.Millisecond, "", false)
+			if err != nil || split {
+				t.Errorf("splitOnce() = %v, %v under the limits, want no split", split, err)
+			}
+		})
+	}
+}
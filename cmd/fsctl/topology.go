@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/basharal/filesystem/client"
+	"github.com/basharal/filesystem/fspath"
+	"github.com/basharal/filesystem/proto/pb_filesystem"
+)
+
+// Health statuses of servers in the topology.
+const (
+	healthy     = "ok"
+	unreachable = "unreachable"
+	mismatched  = "mismatched"
+	notReady    = "not ready"
+	draining    = "draining"
+)
+
+// topologyNode is a server of the topology and its health. Replicas have no replicas themselves.
+type topologyNode struct {
+	Addr        string         `json:"addr"`
+	StartPrefix string         `json:"start_prefix"`
+	EndPrefix   string         `json:"end_prefix"`
+	Health      string         `json:"health"`
+	Version     string         `json:"version,omitempty"`
+	Replicas    []topologyNode `json:"replicas,omitempty"`
+}
+
+type topologyCluster struct {
+	Root    string         `json:"root"`
+	Servers []topologyNode `json:"servers"`
+}
+
+// health returns the health of the server at addr, which the config gives server's range. Servers
+// without the admin service are only checked for being reachable and serving the range.
+func health(ctx context.Context, c *client.Client, addr string, server client.Server) (string, string) {
+	info := c.ServerInfo(addr)
+	if info == nil {
+		return unreachable, ""
+	}
+	if info.Version != "" && (info.StartPrefix != server.StartPrefix || info.EndPrefix != server.EndPrefix) {
+		return mismatched, info.Version
+	}
+	admin, err := c.Admin(addr)
+	if err != nil {
+		return healthy, info.Version
+	}
+	stats, err := admin.GetStats(ctx, &pb_filesystem.StatsRequest{})
+	switch {
+	case err != nil:
+		return unreachable, info.Version
+	case stats.Draining:
+		return draining, info.Version
+	case !stats.Ready:
+		return notReady, info.Version
+	}
+	return healthy, info.Version
+}
+
+// topology returns the clusters of conf with the health of their servers.
+func topology(ctx context.Context, c *client.Client, conf *clientConfig) []topologyCluster {
+	clusters := conf.Clusters
+	if len(conf.Servers) != 0 {
+		clusters = []client.Cluster{{Root: fspath.Root, Servers: conf.Servers}}
+	}
+	var out []topologyCluster
+	for _, cluster := range clusters {
+		tc := topologyCluster{Root: cluster.Root}
+		for _, server := range cluster.Servers {
+			node := topologyNode{Addr: server.Addr, StartPrefix: server.StartPrefix, EndPrefix: server.EndPrefix}
+			node.Health, node.Version = health(ctx, c, server.Addr, server)
+			for _, addr := range server.Replicas {
+				replica := topologyNode{Addr: addr, StartPrefix: server.StartPrefix, EndPrefix: server.EndPrefix}
+				replica.Health, replica.Version = health(ctx, c, addr, server)
+				node.Replicas = append(node.Replicas, replica)
+			}
+			tc.Servers = append(tc.Servers, node)
+		}
+		out = append(out, tc)
+	}
+	return out
+}
+
+// healthColors are the colors of the servers in dot graphs.
+var healthColors = map[string]string{
+	healthy:     "green",
+	unreachable: "red",
+	mismatched:  "red",
+	notReady:    "orange",
+	draining:    "orange",
+}
+
+// writeDot writes clusters as a Graphviz graph: a box per cluster with its servers in the order of
+// their ranges, and dashed edges from servers to their replicas.
+func writeDot(w io.Writer, clusters []topologyCluster) {
+	quote := func(s string) string {
+		return `"` + strings.ReplaceAll(s, `"`, `\"`) + `"`
+	}
+	node := func(indent string, n topologyNode, style string) {
+		label := fmt.Sprintf(`%s\n[%s, %s)\n%s`, n.Addr, n.StartPrefix, n.EndPrefix, n.Health)
+		fmt.Fprintf(w, "%s%s [label=%s, color=%s, style=%s];\n", indent, quote(n.Addr), quote(label), healthColors[n.Health], style)
+	}
+	fmt.Fprintln(w, "digraph topology {")
+	fmt.Fprintln(w, "  rankdir=LR;")
+	fmt.Fprintln(w, "  node [shape=box];")
+	for i, cluster := range clusters {
+		fmt.Fprintf(w, "  subgraph cluster_%d {\n", i)
+		fmt.Fprintf(w, "    label=%s;\n", quote(cluster.Root))
+		for _, server := range cluster.Servers {
+			node("    ", server, "solid")
+			for _, replica := range server.Replicas {
+				node("    ", replica, "dashed")
+				fmt.Fprintf(w, "    %s -> %s [style=dashed, label=replica];\n", quote(server.Addr), quote(replica.Addr))
+			}
+		}
+		// Invisible edges keep the servers in the order of their ranges.
+		for j := 1; j < len(cluster.Servers); j++ {
+			fmt.Fprintf(w, "    %s -> %s [style=invis];\n", quote(cluster.Servers[j-1].Addr), quote(cluster.Servers[j].Addr))
+		}
+		fmt.Fprintln(w, "  }")
+	}
+	fmt.Fprintln(w, "}")
+}
+
+func showTopology(args []string) error {
+	flags := flag.NewFlagSet("topology", flag.ExitOnError)
+	t := targetFlags(flags)
+	format := flags.String("format", "dot", "output format: dot (Graphviz) or json")
+	flags.Parse(args)
+	if *format != "dot" && *format != "json" {
+		flags.Usage()
+		return fmt.Errorf("unknown format %s", *format)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), t.timeout)
+	defer cancel()
+	c, conf, err := t.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	clusters := topology(ctx, c, conf)
+	if *format == "json" {
+		b, err := json.MarshalIndent(struct {
+			Clusters []topologyCluster `json:"clusters"`
+		}{clusters}, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(b))
+		return nil
+	}
+	writeDot(os.Stdout, clusters)
+	return nil
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+
+	"github.com/basharal/filesystem/client"
+)
+
+// checkConfig returns the problems client.New finds with conf, gaps included.
+func checkConfig(conf *clientConfig) []string {
+	_, err := client.New(client.Opts{Servers: conf.Servers, Clusters: conf.Clusters})
+	var ce *client.ConfigError
+	if errors.As(err, &ce) {
+		return ce.Problems
+	}
+	if err != nil {
+		return []string{err.Error()}
+	}
+	return nil
+}
+
+func validate(args []string) error {
+	flags := flag.NewFlagSet("validate", flag.ExitOnError)
+	config := flags.String("config", "config.json", "client config of the cluster")
+	flags.Parse(args)
+
+	conf, err := loadConfig(*config)
+	if err != nil {
+		return err
+	}
+	problems := checkConfig(conf)
+	for _, p := range problems {
+		fmt.Println(p)
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%s has %d problems", *config, len(problems))
+	}
+	fmt.Printf("%s is valid\n", *config)
+	return nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+
+	"github.com/basharal/filesystem/client"
+	"github.com/basharal/filesystem/fs"
+	"github.com/basharal/filesystem/fspath"
+)
+
+// probe stats the first path of the range of every server through c, so that a server that's
+// reachable but can't serve requests (i.e., its filesystem is stuck) is caught. Missing paths are
+// fine.
+func probe(ctx context.Context, c *client.Client, conf *clientConfig) []error {
+	clusters := conf.Clusters
+	if len(conf.Servers) != 0 {
+		clusters = []client.Cluster{{Root: fspath.Root, Servers: conf.Servers}}
+	}
+	var errs []error
+	for _, cluster := range clusters {
+		for _, server := range cluster.Servers {
+			if server.StartPrefix == "" {
+				// Open ranges have no first path, and probing the root would hit every server.
+				continue
+			}
+			path := fspath.Join(cluster.Root, server.StartPrefix)
+			if _, _, err := c.Stat(ctx, path); err != nil && !errors.Is(err, fs.ErrNotFound) {
+				errs = append(errs, fmt.Errorf("%s: probing %s failed. %w", server.Addr, path, err))
+			}
+		}
+	}
+	return errs
+}
+
+func verify(args []string) error {
+	flags := flag.NewFlagSet("verify", flag.ExitOnError)
+	t := targetFlags(flags)
+	stat := flags.Bool("probe", false, "also stat a path in the range of every server")
+	flags.Parse(args)
+
+	ctx, cancel := context.WithTimeout(context.Background(), t.timeout)
+	defer cancel()
+	c, conf, err := t.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	// Gaps in the config are reported along with the servers disagreeing with it.
+	problems := checkConfig(conf)
+	for _, m := range c.CheckTopology(ctx) {
+		problems = append(problems, m.Error())
+	}
+	if *stat {
+		for _, err := range probe(ctx, c, conf) {
+			problems = append(problems, err.Error())
+		}
+	}
+	for _, p := range problems {
+		fmt.Println(p)
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%s has %d problems across %d servers", t.config, len(problems), len(c.Addrs()))
+	}
+	fmt.Printf("all %d servers match %s\n", len(c.Addrs()), t.config)
+	return nil
+}
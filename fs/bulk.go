@@ -0,0 +1,167 @@
+package fs
+
+import (
+	"fmt"
+
+	"github.com/basharal/filesystem/fspath"
+	"github.com/basharal/filesystem/trie"
+)
+
+// DeletePrefix removes s (relative/absolute) and everything under it at once and returns the
+// number of removed files/dirs. For root, only its content is removed. With dryRun, nothing is
+// removed and the count is what would have been removed.
+func (fs *FileSystem) DeletePrefix(s string, dryRun bool) (_ int, err error) {
+	defer wrapPathError(&err, "deleteprefix", s)
+	if _, _, ok := fs.mounted(s); ok {
+		return 0, fmt.Errorf("deleting mounted paths: %w", ErrNotSupported)
+	}
+	s = fs.absPath(s)
+	n, discarded, err := fs.deletePrefix("deleteprefix", s, dryRun)
+	if err != nil {
+		return 0, err
+	}
+	// Content is discarded without holding mu, since it's slow with ContentStores.
+	fs.parallel(len(discarded), func(i int) error {
+		discarded[i].discard()
+		return nil
+	})
+	return n, nil
+}
+
+// RemoveAll removes the dir s (relative/absolute) with its whole subtree at once, like
+// DeletePrefix, or the file s. Root can't be removed, and nothing is removed when a file under s
+// is busy. Like os.RemoveAll, it succeeds if s doesn't exist.
+func (fs *FileSystem) RemoveAll(s string) (err error) {
+	defer wrapPathError(&err, "removeall", s)
+	s = fs.resolve(s)
+	if _, _, ok := fs.mounted(s); ok {
+		return fmt.Errorf("removing mounted paths: %w", ErrNotSupported)
+	}
+	s = fs.absPath(s)
+	if fspath.Clean(s) == fspath.Root {
+		return fmt.Errorf("removing root: %w", ErrNotSupported)
+	}
+	_, discarded, err := fs.deletePrefix("removeall", s, false)
+	if err == ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	fs.parallel(len(discarded), func(i int) error {
+		discarded[i].discard()
+		return nil
+	})
+	return nil
+}
+
+// deletePrefix is DeletePrefix for the absolute path s, failing busy files with op. It returns the
+// removed files whose content must be discarded.
+func (fs *FileSystem) deletePrefix(op, s string, dryRun bool) (int, []*File, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	node := fs.findNode(s)
+	if node == nil {
+		node = fs.findNode(fs.normalizeDirPath(s))
+	}
+	if node == nil {
+		return 0, nil, ErrNotFound
+	}
+
+	// Like Remove, don't remove the current directory.
+	path := nodePath(node)
+	current := fs.currentDir.md.AbsolutePath()
+	if fspath.HasPrefix(current, path) && (node != fs.root.md.node || current != fspath.Root) {
+		return 0, nil, fmt.Errorf("current directory is under %s: %w", path, ErrNotSupported)
+	}
+	for mp := range fs.mounts {
+		if fspath.HasPrefix(mp, path) {
+			return 0, nil, fmt.Errorf("%s is mounted: %w", mp, ErrNotSupported)
+		}
+	}
+
+	nodes := make([]*trie.Node, 0)
+	if err := fs.collectSubtree(node, &nodes); err != nil {
+		return 0, nil, err
+	}
+	if node != fs.root.md.node {
+		nodes = append(nodes, node)
+	}
+	for _, n := range nodes {
+		if file, ok := n.Meta().(*File); ok {
+			if err := fs.busy(file); err != nil {
+				return 0, nil, &PathError{Op: op, Path: file.Path(), Err: err}
+			}
+		}
+	}
+	if dryRun {
+		return len(nodes), nil, nil
+	}
+	discarded := make([]*File, 0)
+	for _, n := range nodes {
+		if file := fs.unlinkNode(n); file != nil {
+			discarded = append(discarded, file)
+		}
+	}
+	return len(nodes), discarded, nil
+}
+
+// collectSubtree appends the nodes under n in post-order (children before their parent), so that
+// removing them in order only ever removes files and empty dirs. Must be called with mu held.
+func (fs *FileSystem) collectSubtree(n *trie.Node, nodes *[]*trie.Node) error {
+	_, children, err := fs.listAtNode(n)
+	if err != nil {
+		return err
+	}
+	for _, child := range children {
+		if _, ok := child.Meta().(*Dir); ok {
+			if err := fs.collectSubtree(child, nodes); err != nil {
+				return err
+			}
+		}
+		*nodes = append(*nodes, child)
+	}
+	return nil
+}
+
+// removeNode removes the file or empty dir at n. Must be called with mu held.
+func (fs *FileSystem) removeNode(n *trie.Node) {
+	if file := fs.unlinkNode(n); file != nil {
+		file.discard()
+	}
+}
+
+// unlinkNode is removeNode, except that it returns the removed file if its content must be
+// discarded rather than discarding it. Must be called with mu held.
+func (fs *FileSystem) unlinkNode(n *trie.Node) *File {
+	switch meta := n.Meta().(type) {
+	case *File:
+		path := meta.Path()
+		fs.detach(path, meta)
+		meta.detached = true
+		fs.trie.Remove(n.Path())
+		fs.publish(EventRemove, path, false)
+		if fs.release(meta) {
+			return meta
+		}
+	case *Dir:
+		path := meta.Path()
+		fs.detach(path, meta)
+		fs.trie.Remove(n.Path())
+		delete(fs.retention, meta)
+		fs.forget(meta)
+		fs.publish(EventRemove, path, true)
+	}
+	return nil
+}
+
+// nodePath returns the absolute path of the file/dir at n.
+func nodePath(n *trie.Node) string {
+	switch meta := n.Meta().(type) {
+	case *File:
+		return meta.Path()
+	case *Dir:
+		return meta.Path()
+	}
+	return n.Path()
+}
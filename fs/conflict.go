@@ -0,0 +1,95 @@
+package fs
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync/atomic"
+)
+
+// WritePolicy decides what happens when a file is written by more than one writer at once.
+type WritePolicy int
+
+const (
+	// WriteSerialize applies concurrent writes one after the other.
+	WriteSerialize WritePolicy = iota
+
+	// WriteReject fails writes started while another one is in progress with ErrConflict.
+	WriteReject
+
+	// WriteLastWins buffers each write and applies it at once when it's done. If an overlapping
+	// write was applied in the meantime, its content is dropped in favor of the last one.
+	WriteLastWins
+)
+
+// write appends reader to file according to the filesystem's WritePolicy.
+func (fs *FileSystem) write(file *File, reader io.Reader) (int64, error) {
+	switch fs.writePolicy {
+	case WriteReject:
+		if !atomic.CompareAndSwapInt32(&file.writers, 0, 1) {
+			return 0, fmt.Errorf("another write is in progress: %w", ErrConflict)
+		}
+		defer atomic.StoreInt32(&file.writers, 0)
+		return file.Write(reader)
+	case WriteLastWins:
+		version, size := file.versionAndSize()
+		data, err := ioutil.ReadAll(reader)
+		if err != nil {
+			return 0, err
+		}
+		return file.commit(version, size, data)
+	}
+	return file.Write(reader)
+}
+
+// versionAndSize returns the version and size of the file as of the same write.
+func (f *File) versionAndSize() (uint64, int64) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	if f.md.fs.content != nil {
+		return f.version, f.size
+	}
+	if f.archive != nil || f.source != "" {
+		return f.version, f.offloadedSize
+	}
+	return f.version, int64(len(f.content))
+}
+
+// commit appends data to the file as of version, when it had size bytes. If the file was written
+// since then, those writes are dropped first.
+func (f *File) commit(version uint64, size int64, data []byte) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.version != version {
+		if err := f.truncate(size); err != nil {
+			return 0, err
+		}
+	}
+	return f.write(bytes.NewReader(data))
+}
+
+// truncate drops the content after size bytes. Must be called with mu held.
+func (f *File) truncate(size int64) error {
+	if store := f.md.fs.content; store != nil {
+		if size >= f.size {
+			return nil
+		}
+		var buf bytes.Buffer
+		if _, err := store.Get(f.md.id, &buf); err != nil {
+			return err
+		}
+		if _, err := store.Put(f.md.id, bytes.NewReader(buf.Bytes()[:size])); err != nil {
+			return err
+		}
+		f.size = size
+		return nil
+	}
+	if err := f.rehydrate(); err != nil {
+		return err
+	}
+	if size < int64(len(f.content)) {
+		f.content = f.content[:size]
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package fs
+
+import (
+	"io"
+	"strconv"
+
+	"github.com/basharal/filesystem/blob"
+)
+
+// ContentStore keeps file content outside of the namespace, keyed by the file's ID. It allows
+// serving namespaces whose content doesn't fit in memory. Implementations must be thread-safe.
+type ContentStore interface {
+	// Put replaces the content of the file with what's in reader until EOF.
+	Put(id uint64, reader io.Reader) (int64, error)
+
+	// Get streams the content of the file to writer. Files without content have no entry.
+	Get(id uint64, writer io.Writer) (int64, error)
+
+	// Delete removes the content of the file.
+	Delete(id uint64) error
+}
+
+// blobContentStore adapts a blob.Store into a ContentStore.
+type blobContentStore struct {
+	store  blob.Store
+	prefix string
+}
+
+// NewBlobContentStore returns a ContentStore keeping content in store (i.e., blob.Memory,
+// blob.Disk or blob.S3). Keys are prefixed with prefix so that stores can be shared.
+func NewBlobContentStore(store blob.Store, prefix string) ContentStore {
+	return &blobContentStore{store: store, prefix: prefix}
+}
+
+func (s *blobContentStore) key(id uint64) string {
+	return s.prefix + strconv.FormatUint(id, 10)
+}
+
+func (s *blobContentStore) Put(id uint64, reader io.Reader) (int64, error) {
+	return s.store.Put(s.key(id), reader)
+}
+
+func (s *blobContentStore) Get(id uint64, writer io.Writer) (int64, error) {
+	n, err := s.store.Get(s.key(id), writer)
+	if err == blob.ErrNotFound {
+		return 0, nil
+	}
+	return n, err
+}
+
+func (s *blobContentStore) Delete(id uint64) error {
+	return s.store.Delete(s.key(id))
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
+
+// skipWriter drops the first skip bytes written to it.
+type skipWriter struct {
+	w    io.Writer
+	skip int64
+}
+
+func (sw *skipWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	if sw.skip >= int64(n) {
+		sw.skip -= int64(n)
+		return n, nil
+	}
+	p = p[sw.skip:]
+	sw.skip = 0
+	if _, err := sw.w.Write(p); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
@@ -0,0 +1,92 @@
+package fs
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/basharal/filesystem/fspath"
+	"github.com/basharal/filesystem/trie"
+)
+
+// CreateOpts are the options of CreateFile.
+type CreateOpts struct {
+	// Parents creates the missing dirs above the file, like mkdir -p. Files in the way fail the
+	// create with an ExistsError.
+	Parents bool
+}
+
+// CreateFile is NewFile with opts. Dirs it creates for Parents are kept if creating the file fails.
+func (fs *FileSystem) CreateFile(s string, opts CreateOpts) (err error) {
+	if !opts.Parents {
+		return fs.NewFile(s)
+	}
+	defer wrapPathError(&err, "create", s)
+	if m, p, ok := fs.mounted(s); ok {
+		dir := fspath.Root
+		for _, name := range fspath.Split(fspath.Dir(p)) {
+			dir = fspath.Join(dir, name)
+			if err := m.backend.MakeDir(context.Background(), dir); err != nil && !errors.Is(err, ErrAlreadyExist) {
+				return err
+			}
+		}
+		return m.backend.CreateFile(context.Background(), p)
+	}
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	n := fs.currentDir.md.node
+	if IsAbs(s) {
+		n, s = fs.root.md.node, s[1:]
+	}
+	parent, name, err := fs.makeParents(s, n)
+	if err != nil {
+		return err
+	}
+	return fs.newFileAtNode(name, parent)
+}
+
+// MakeDirAll makes the dir s (relative/abs) along with its missing parents, like mkdir -p. Dirs that
+// exist already are fine, and the dirs it creates are kept if a file is in the way of another.
+func (fs *FileSystem) MakeDirAll(s string) (err error) {
+	defer wrapPathError(&err, "mkdir", s)
+	s = fs.resolve(s)
+	if m, p, ok := fs.mounted(s); ok {
+		dir := fspath.Root
+		for _, name := range fspath.Split(p) {
+			dir = fspath.Join(dir, name)
+			if err := m.backend.MakeDir(context.Background(), dir); err != nil && !errors.Is(err, ErrAlreadyExist) {
+				return err
+			}
+		}
+		return nil
+	}
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	n := fs.currentDir.md.node
+	if IsAbs(s) {
+		n, s = fs.root.md.node, s[1:]
+	}
+	if s == "" {
+		return nil
+	}
+	_, _, err = fs.makeParents(fs.normalizeDirPath(s), n)
+	return err
+}
+
+// makeParents creates the missing dirs above path, which is relative to n, and returns the node of
+// its parent and its base name. Must be called with mu held.
+func (fs *FileSystem) makeParents(path string, n *trie.Node) (*trie.Node, string, error) {
+	names := strings.Split(path, SeperatorStr)
+	for _, name := range names[:len(names)-1] {
+		if name == "" {
+			return nil, "", ErrInvalidName
+		}
+		dir := name + SeperatorStr
+		var ee *ExistsError
+		if err := fs.mkdirAtNode(dir, n); err != nil && !(errors.As(err, &ee) && ee.IsDir) {
+			return nil, "", err
+		}
+		n, _ = fs.trie.FindAtNode(dir, n)
+	}
+	return n, names[len(names)-1], nil
+}
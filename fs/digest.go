@@ -0,0 +1,158 @@
+package fs
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/basharal/filesystem/fspath"
+)
+
+// EntryDigest is the digest of a file/dir in a dir.
+type EntryDigest struct {
+	Name  string
+	IsDir bool
+	Sum   []byte
+}
+
+// FileDigest returns the digest of a file with the content in r.
+func FileDigest(r io.Reader) ([]byte, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+// DirDigest returns the digest of a dir with entries, in any order. It's a Merkle hash, so dirs
+// with the same digest have the same names and content throughout, while different digests of
+// their entries tell where they differ.
+func DirDigest(entries []EntryDigest) []byte {
+	sortDigests(entries)
+	h := sha256.New()
+	for _, e := range entries {
+		kind := "f"
+		if e.IsDir {
+			kind = "d"
+		}
+		fmt.Fprintf(h, "%s%s\x00", kind, e.Name)
+		h.Write(e.Sum)
+	}
+	return h.Sum(nil)
+}
+
+func sortDigests(entries []EntryDigest) {
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+}
+
+// Digest returns the digest of the file/dir at s (relative/absolute) and, for dirs, the digests of
+// their entries sorted by name. Times aren't part of digests, and mounted backends aren't
+// included. Like Snapshot, the structure is captured at once, while content is hashed afterwards,
+// and hashing doesn't count as accessing files.
+func (fs *FileSystem) Digest(s string) (_ []byte, _ []EntryDigest, err error) {
+	defer wrapPathError(&err, "digest", s)
+	fs.mu.RLock()
+	var file *File
+	var dir *Dir
+	var entries []snapshotEntry
+	for _, p := range []string{s, fs.normalizeDirPath(s)} {
+		if node := fs.findNode(p); node != nil {
+			switch meta := node.Meta().(type) {
+			case *File:
+				file = meta
+			case *Dir:
+				dir = meta
+				entries, err = fs.collect(node, nil)
+			}
+		}
+		if file != nil || dir != nil {
+			break
+		}
+	}
+	fs.mu.RUnlock()
+	if err != nil {
+		return nil, nil, err
+	}
+	if file != nil {
+		sum, err := file.digest()
+		return sum, nil, err
+	}
+	if dir == nil {
+		return nil, nil, ErrNotFound
+	}
+
+	// Files are hashed in parallel, dirs once their entries are. Entries have parents before their
+	// children, so dirs are hashed in reverse.
+	sums := make([][]byte, len(entries))
+	err = fs.parallel(len(entries), func(i int) error {
+		e := entries[i]
+		if e.file == nil {
+			return nil
+		}
+		sum, err := e.file.digest()
+		if err != nil {
+			return fmt.Errorf("failed to digest %s. %w", e.path, err)
+		}
+		sums[i] = sum
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	children := make(map[string][]EntryDigest)
+	for i := len(entries) - 1; i >= 0; i-- {
+		e := entries[i]
+		d := EntryDigest{Name: fspath.Base(e.path), IsDir: e.dir != nil, Sum: sums[i]}
+		if e.dir != nil {
+			d.Sum = DirDigest(children[e.path])
+			delete(children, e.path)
+		}
+		parent := fspath.Dir(e.path)
+		children[parent] = append(children[parent], d)
+	}
+	top := children[dir.Path()]
+	return DirDigest(top), top, nil
+}
+
+func (f *File) digest() ([]byte, error) {
+	var buf bytes.Buffer
+	if _, err := f.read(&buf, false); err != nil {
+		return nil, err
+	}
+	return FileDigest(&buf)
+}
+
+// DigestOS is like Digest for a local file/dir, so that it can be compared with a filesystem.
+func DigestOS(path string) ([]byte, []EntryDigest, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !info.IsDir() {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, nil, err
+		}
+		defer f.Close()
+		sum, err := FileDigest(f)
+		return sum, nil, err
+	}
+	infos, err := ioutil.ReadDir(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	entries := make([]EntryDigest, 0, len(infos))
+	for _, info := range infos {
+		sum, _, err := DigestOS(filepath.Join(path, info.Name()))
+		if err != nil {
+			return nil, nil, err
+		}
+		entries = append(entries, EntryDigest{Name: info.Name(), IsDir: info.IsDir(), Sum: sum})
+	}
+	return DirDigest(entries), entries, nil
+}
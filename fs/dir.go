@@ -2,8 +2,18 @@ package fs
 
 // Dir is an abstraction of a directory
 type Dir struct {
+	// files, dirs and size make up the dir's usage. Must be accessed atomically and be first for
+	// 64-bit alignment.
+	files int64
+	dirs  int64
+	size  int64
+
 	// md is immutable.
-	md *Metadata
+	md Metadata
+
+	// unloaded is set while the dir's children are still only in the MetaStore. Must be accessed
+	// atomically.
+	unloaded int32
 }
 
 func newDir(fs *FileSystem) *Dir {
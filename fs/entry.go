@@ -0,0 +1,26 @@
+package fs
+
+import (
+	"time"
+)
+
+// NewFileEntry returns a file that isn't part of a FileSystem, for other implementations of
+// Interface to return from ListDir/Stat. Only its name, path, size and times are meaningful.
+func NewFileEntry(path string, size int64, modified time.Time) *File {
+	return &File{
+		accessed: modified.UnixNano(),
+		md:       Metadata{nt: fileType, ext: &external{path: path}, created: unixNano(modified)},
+		size:     size,
+		modified: modified,
+	}
+}
+
+// NewDirEntry is like NewFileEntry for dirs. usage is what Usage returns.
+func NewDirEntry(path string, usage DirUsage) *Dir {
+	return &Dir{
+		files: usage.Files,
+		dirs:  usage.Dirs,
+		size:  usage.Size,
+		md:    Metadata{nt: dirType, ext: &external{path: path}},
+	}
+}
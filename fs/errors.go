@@ -0,0 +1,87 @@
+package fs
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/basharal/filesystem/trie"
+)
+
+// PathError records the operation and path that caused an error. Err is one of the Err* sentinels
+// when applicable, so callers should check it with errors.Is.
+type PathError struct {
+	Op   string
+	Path string
+	Err  error
+}
+
+func (e *PathError) Error() string {
+	return e.Op + " " + e.Path + ": " + e.Err.Error()
+}
+
+func (e *PathError) Unwrap() error {
+	return e.Err
+}
+
+// wrapPathError adds op and path to *err unless it's nil or already has them. It's meant to be
+// deferred by exported operations with a named error result.
+func wrapPathError(err *error, op, path string) {
+	var pe *PathError
+	if *err == nil || errors.As(*err, &pe) {
+		return
+	}
+	*err = &PathError{Op: op, Path: path, Err: *err}
+}
+
+// ExistsError is returned when creating a file/dir whose name is taken. It says what's in the way,
+// since a file and a dir can't share a name either. It unwraps to ErrAlreadyExist.
+type ExistsError struct {
+	// Path is the absolute path of what exists.
+	Path  string
+	IsDir bool
+}
+
+func (e *ExistsError) Error() string {
+	if e.IsDir {
+		return "a dir already exists at " + e.Path
+	}
+	return "a file already exists at " + e.Path
+}
+
+func (e *ExistsError) Unwrap() error {
+	return ErrAlreadyExist
+}
+
+// RejectedError is returned when a validator rejects the content of an upload (see
+// server.UploadValidator). It says which validator rejected it, where and why, and matches
+// ErrRejected besides what Err matches.
+type RejectedError struct {
+	Validator string
+	// Offset is where the rejected content starts, or the size of the upload when it was rejected
+	// once complete.
+	Offset int64
+	Err    error
+}
+
+func (e *RejectedError) Error() string {
+	return fmt.Sprintf("rejected by %s at byte %d: %s", e.Validator, e.Offset, e.Err)
+}
+
+func (e *RejectedError) Unwrap() error {
+	return e.Err
+}
+
+func (e *RejectedError) Is(target error) bool {
+	return target == ErrRejected
+}
+
+// existsError returns the ExistsError of the file/dir at n.
+func existsError(n *trie.Node) error {
+	switch meta := n.Meta().(type) {
+	case *Dir:
+		return &ExistsError{Path: meta.Path(), IsDir: true}
+	case *File:
+		return &ExistsError{Path: meta.Path()}
+	}
+	return ErrAlreadyExist
+}
@@ -0,0 +1,149 @@
+package fs
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// EventType is the kind of change that happened in the filesystem.
+type EventType int
+
+const (
+	EventCreate EventType = iota + 1
+	EventMakeDir
+	EventWrite
+	EventRemove
+	EventMove
+)
+
+var eventTypeNames = map[EventType]string{
+	EventCreate:  "create",
+	EventMakeDir: "mkdir",
+	EventWrite:   "write",
+	EventRemove:  "remove",
+	EventMove:    "move",
+}
+
+func (t EventType) String() string {
+	if s, ok := eventTypeNames[t]; ok {
+		return s
+	}
+	return fmt.Sprintf("unknown(%d)", int(t))
+}
+
+// MarshalText allows event types to be serialized by name (i.e., in JSON).
+func (t EventType) MarshalText() ([]byte, error) {
+	return []byte(t.String()), nil
+}
+
+// ParseEventType returns the event type for its name (i.e., "create").
+func ParseEventType(s string) (EventType, error) {
+	for t, name := range eventTypeNames {
+		if name == strings.ToLower(s) {
+			return t, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown event type %s", s)
+}
+
+// Event describes a single change in the filesystem. Paths are absolute.
+type Event struct {
+	Type EventType `json:"type"`
+	Path string    `json:"path"`
+	// NewPath is only set for moves.
+	NewPath string    `json:"new_path,omitempty"`
+	IsDir   bool      `json:"is_dir"`
+	Time    time.Time `json:"time"`
+	// Dropped is how many earlier events the subscriber missed because it wasn't keeping up. They
+	// were dropped to make room for this one, so subscribers mirroring the filesystem know that
+	// they must resync.
+	Dropped int `json:"dropped,omitempty"`
+}
+
+// eventBus fans out events to all subscribers. Publishing never blocks. If a subscriber isn't
+// keeping up, its oldest events are dropped, and the newest one reports how many.
+type eventBus struct {
+	mu     sync.RWMutex
+	nextID int
+	subs   map[int]*subscriber
+}
+
+type subscriber struct {
+	// mu serializes publishers, so that the room made for an event isn't taken by another one.
+	mu sync.Mutex
+	ch chan Event
+}
+
+func newEventBus() *eventBus {
+	return &eventBus{subs: make(map[int]*subscriber)}
+}
+
+func (b *eventBus) subscribe(size int) (int, <-chan Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	id := b.nextID
+	b.nextID++
+	sub := &subscriber{ch: make(chan Event, size)}
+	b.subs[id] = sub
+	return id, sub.ch
+}
+
+func (b *eventBus) unsubscribe(id int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if sub, ok := b.subs[id]; ok {
+		delete(b.subs, id)
+		close(sub.ch)
+	}
+}
+
+func (b *eventBus) publish(e Event) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for id, sub := range b.subs {
+		sub.send(id, e)
+	}
+}
+
+// send delivers e, dropping the oldest buffered event if the subscriber isn't keeping up. Events
+// dropped this way are added to e.Dropped, along with the ones the dropped event reported.
+func (sub *subscriber) send(id int, e Event) {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+	for {
+		select {
+		case sub.ch <- e:
+			return
+		default:
+		}
+		// Unbuffered subscribers can't be made room for.
+		if cap(sub.ch) == 0 {
+			glog.Warningf("Dropping %s event for %s. Subscriber %d is too slow.\n", e.Type, e.Path, id)
+			return
+		}
+		select {
+		case old := <-sub.ch:
+			glog.Warningf("Dropping %s event for %s. Subscriber %d is too slow.\n", old.Type, old.Path, id)
+			e.Dropped += 1 + old.Dropped
+		default:
+			// The subscriber made room itself.
+		}
+	}
+}
+
+// Watch subscribes to all changes in the filesystem. Events are buffered up to size, after which
+// the oldest ones are dropped and the next event delivered reports how many (see Event.Dropped).
+// The returned function must be called to stop watching. It closes the channel.
+func (fs *FileSystem) Watch(size int) (<-chan Event, func()) {
+	id, ch := fs.events.subscribe(size)
+	return ch, func() { fs.events.unsubscribe(id) }
+}
+
+func (fs *FileSystem) publish(t EventType, path string, isDir bool) {
+	fs.changed(path)
+	fs.events.publish(Event{Type: t, Path: path, IsDir: isDir, Time: time.Now()})
+}
@@ -2,66 +2,219 @@ package fs
 
 import (
 	"bytes"
+	"context"
+	"fmt"
 	"io"
+	"io/ioutil"
 	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/basharal/filesystem/blob"
+	"github.com/golang/glog"
 )
 
 // File is an abstraction of a file.
 type File struct {
-	md *Metadata
+	// accessed is the last read time in unix nanos. Must be accessed atomically and be first
+	// for 64-bit alignment.
+	accessed int64
+
+	// accounted is the size last added to the file's dirs. Must be accessed atomically.
+	accounted int64
+
+	md Metadata
+
+	// accounting serializes adding the file's size to its dirs.
+	accounting sync.Mutex
 
 	// mu protects below
-	mu      sync.RWMutex
-	content []byte
+	mu       sync.RWMutex
+	content  []byte
+	modified time.Time
+
+	// archive is set when the content lives in an external store instead of memory.
+	archive blob.Store
+
+	// source is set when the content hasn't been loaded yet from the local filesystem.
+	source string
+
+	// offloadedSize is the size of content that isn't in memory (archived or not loaded yet).
+	offloadedSize int64
+
+	// size is only maintained when the filesystem has a ContentStore or for mounted files.
+	size int64
+
+	// version is bumped on every write. Protected by mu.
+	version uint64
+
+	// writers is the number of writes in progress. Must be accessed atomically.
+	writers int32
+
+	// opens is the number of open handles. removed is set when the file was removed while open.
+	// Both are protected by the filesystem's mu.
+	opens   int
+	removed bool
+
+	// detached is set once the file is removed from the tree, so that writes still in progress
+	// don't account for it. Protected by the filesystem's mu.
+	detached bool
 }
 
 func newFile(fs *FileSystem) *File {
+	md := newMetadata(fs, fileType)
 	return &File{
-		md:      newMetadata(fs, fileType),
-		content: make([]byte, 0),
+		accessed: md.created,
+		md:       md,
+		content:  make([]byte, 0),
+		modified: time.Unix(0, md.created),
 	}
 }
 
 // Write appends to the file's content as a stream until io.EOF is encountered and returns the
-// number of bytes written.
+// number of bytes written. If reading fails (i.e., an upload is abandoned), nothing is appended.
 func (f *File) Write(reader io.Reader) (int64, error) {
+	if m := f.md.mount(); m != nil {
+		return m.backend.Write(context.Background(), m.rel(f.md.ext.path), reader)
+	}
 	f.mu.Lock()
 	defer f.mu.Unlock()
+	return f.write(reader)
+}
+
+// write appends reader to the content and bumps the version. Must be called with mu held.
+func (f *File) write(reader io.Reader) (int64, error) {
+	f.version++
+	if store := f.md.fs.content; store != nil {
+		return f.appendToStore(store, reader)
+	}
+	if err := f.rehydrate(); err != nil {
+		return 0, err
+	}
 	buf := bytes.NewBuffer(f.content)
-	n, err := io.Copy(buf, reader)
-	if err != nil {
-		return n, err
+	if _, err := io.Copy(buf, reader); err != nil {
+		return 0, err
 	}
+	n := int64(buf.Len() - len(f.content))
 	f.content = buf.Bytes()
+	f.modified = time.Now()
 	return n, nil
 }
 
 // Read reads the file content as a stream and returns the number of bytes read.
 func (f *File) Read(writer io.Writer) (int64, error) {
+	return f.read(writer, true)
+}
+
+// read is Read, but only updates the access time with touch.
+func (f *File) read(writer io.Writer, touch bool) (int64, error) {
+	if m := f.md.mount(); m != nil {
+		return m.backend.Read(context.Background(), m.rel(f.md.ext.path), writer)
+	}
+	if store := f.md.fs.content; store != nil {
+		f.mu.RLock()
+		defer f.mu.RUnlock()
+		if touch {
+			f.touch()
+		}
+		return store.Get(f.md.id, writer)
+	}
+	if err := f.ensureInMemory(); err != nil {
+		return 0, err
+	}
 	f.mu.RLock()
 	defer f.mu.RUnlock()
-	buf := bytes.NewBuffer(f.content)
-	return io.Copy(writer, buf)
+	if touch {
+		f.touch()
+	}
+	n, err := writer.Write(f.content)
+	return int64(n), err
 }
 
 // ReadAt reads at a particular offset of the file. Returns number of bytes read.
 func (f *File) ReadAt(writer io.Writer, offset int) (int64, error) {
+	if m := f.md.mount(); m != nil {
+		if int64(offset) >= f.size {
+			return 0, io.EOF
+		}
+		n, err := m.backend.Read(context.Background(), m.rel(f.md.ext.path), &skipWriter{w: writer, skip: int64(offset)})
+		return n - int64(offset), err
+	}
+	if store := f.md.fs.content; store != nil {
+		f.mu.RLock()
+		defer f.mu.RUnlock()
+		f.touch()
+		if int64(offset) >= f.size {
+			return 0, io.EOF
+		}
+		n, err := store.Get(f.md.id, &skipWriter{w: writer, skip: int64(offset)})
+		return n - int64(offset), err
+	}
+	if err := f.ensureInMemory(); err != nil {
+		return 0, err
+	}
 	f.mu.RLock()
 	defer f.mu.RUnlock()
+	f.touch()
 	if offset >= len(f.content) {
 		return 0, io.EOF
 	}
-	buf := bytes.NewBuffer(f.content[offset:])
-	return io.Copy(writer, buf)
+	n, err := writer.Write(f.content[offset:])
+	return int64(n), err
 }
 
 // Size of the file.
 func (f *File) Size() int64 {
 	f.mu.RLock()
 	defer f.mu.RUnlock()
+	if f.md.mount() != nil || f.md.fs == nil || f.md.fs.content != nil {
+		return f.size
+	}
+	if f.archive != nil || f.source != "" {
+		return f.offloadedSize
+	}
 	return int64(len(f.content))
 }
 
+// Version is bumped every time the file's content changes.
+func (f *File) Version() uint64 {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.version
+}
+
+// ModTime is the last time the file's content changed.
+func (f *File) ModTime() time.Time {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.modified
+}
+
+// updateTimes sets the access/modification times without touching the content.
+func (f *File) updateTimes(accessed, modified time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.modified = modified
+	atomic.StoreInt64(&f.accessed, accessed.UnixNano())
+}
+
+// AccessTime is the last time the file's content was read or changed.
+func (f *File) AccessTime() time.Time {
+	accessed := time.Unix(0, atomic.LoadInt64(&f.accessed))
+	if modified := f.ModTime(); modified.After(accessed) {
+		return modified
+	}
+	return accessed
+}
+
+// Archived returns true if the content lives in an external store. It's transparently brought
+// back into memory on the next read/write.
+func (f *File) Archived() bool {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.archive != nil
+}
+
 func (f *File) String() string {
 	return f.md.Name()
 }
@@ -70,3 +223,158 @@ func (f *File) String() string {
 func (f *File) Path() string {
 	return f.md.AbsolutePath()
 }
+
+func (f *File) touch() {
+	atomic.StoreInt64(&f.accessed, time.Now().UnixNano())
+}
+
+// blobKey is the key of the file's content in external stores.
+func (f *File) blobKey() string {
+	return fmt.Sprintf("%s/%d", f.md.fs.instance, f.md.id)
+}
+
+// archiveTo moves the content to store and drops it from memory.
+func (f *File) archiveTo(store blob.Store) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.archive != nil {
+		return nil
+	}
+	if _, err := store.Put(f.blobKey(), bytes.NewReader(f.content)); err != nil {
+		return err
+	}
+	f.archive = store
+	f.offloadedSize = int64(len(f.content))
+	f.content = nil
+	return nil
+}
+
+// offloaded returns true if the content isn't in memory.
+func (f *File) offloaded() bool {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.archive != nil || f.source != ""
+}
+
+func (f *File) ensureInMemory() error {
+	if !f.offloaded() {
+		return nil
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.rehydrate()
+}
+
+// rehydrate brings archived or not yet loaded content into memory. Must be called with mu held.
+func (f *File) rehydrate() error {
+	if f.source != "" {
+		content, err := ioutil.ReadFile(f.source)
+		if err != nil {
+			return err
+		}
+		f.content = content
+		f.source = ""
+		f.offloadedSize = 0
+		return nil
+	}
+	if f.archive == nil {
+		return nil
+	}
+	buf := bytes.NewBuffer(make([]byte, 0, f.offloadedSize))
+	if _, err := f.archive.Get(f.blobKey(), buf); err != nil {
+		return err
+	}
+	if err := f.archive.Delete(f.blobKey()); err != nil {
+		glog.Warningf("Failed to delete archived content of %s. %s\n", f.md.AbsolutePath(), err)
+	}
+	f.content = buf.Bytes()
+	f.archive = nil
+	f.offloadedSize = 0
+	return nil
+}
+
+// replace overwrites the file's content with what's in reader and returns its new size.
+func (f *File) replace(reader io.Reader) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.version++
+	if store := f.md.fs.content; store != nil {
+		counter := &countingReader{r: reader}
+		if _, err := store.Put(f.md.id, counter); err != nil {
+			return 0, err
+		}
+		f.size = counter.n
+		f.modified = time.Now()
+		return counter.n, nil
+	}
+	content, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return 0, err
+	}
+	if f.archive != nil {
+		if err := f.archive.Delete(f.blobKey()); err != nil {
+			glog.Warningf("Failed to delete archived content of %s. %s\n", f.md.AbsolutePath(), err)
+		}
+		f.archive = nil
+	}
+	f.source = ""
+	f.offloadedSize = 0
+	f.content = content
+	f.modified = time.Now()
+	return int64(len(content)), nil
+}
+
+// appendToStore streams the existing content followed by reader back into the store. Must be
+// called with mu held.
+func (f *File) appendToStore(store ContentStore, reader io.Reader) (int64, error) {
+	existing := io.Reader(bytes.NewReader(nil))
+	if f.size > 0 {
+		pr, pw := io.Pipe()
+		// Unblocks the goroutine if Put fails before consuming everything.
+		defer pr.Close()
+		go func() {
+			_, err := store.Get(f.md.id, pw)
+			pw.CloseWithError(err)
+		}()
+		existing = pr
+	}
+	counter := &countingReader{r: reader}
+	if _, err := store.Put(f.md.id, io.MultiReader(existing, counter)); err != nil {
+		return 0, err
+	}
+	f.size += counter.n
+	f.modified = time.Now()
+	return counter.n, nil
+}
+
+// swapContent makes the content stored under id in the ContentStore, of size bytes, the file's
+// and returns the ID its content was stored under before.
+func (f *File) swapContent(id uint64, size int64) uint64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	old := f.md.id
+	f.md.id = id
+	f.size = size
+	f.version++
+	f.modified = time.Now()
+	return old
+}
+
+// discard deletes archived/stored content. Called when the file is removed.
+func (f *File) discard() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if store := f.md.fs.content; store != nil {
+		if err := store.Delete(f.md.id); err != nil {
+			glog.Warningf("Failed to delete content of removed file. %s\n", err)
+		}
+		return
+	}
+	if f.archive == nil {
+		return
+	}
+	if err := f.archive.Delete(f.blobKey()); err != nil {
+		glog.Warningf("Failed to delete archived content of removed file. %s\n", err)
+	}
+	f.archive = nil
+}
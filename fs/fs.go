@@ -1,67 +1,190 @@
 package fs
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
+	"runtime"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 
-	"github.com/basharal/trie"
+	"github.com/basharal/filesystem/blob"
+	"github.com/basharal/filesystem/fspath"
+	"github.com/basharal/filesystem/trie"
+	"github.com/golang/glog"
 )
 
 const (
-	Separator    = '/'
-	SeperatorStr = string(Separator)
+	Separator    = fspath.Separator
+	SeperatorStr = fspath.SeparatorStr
 )
 
 var (
-	ErrAlreadyExist = fmt.Errorf("already exists")
-	ErrNotFound     = fmt.Errorf("not found")
-	ErrInvalidName  = fmt.Errorf("invalid name")
-	ErrNotSupported = fmt.Errorf("not supported")
-	ErrDirNotEmpty  = fmt.Errorf("directory not empty")
+	ErrAlreadyExist  = fmt.Errorf("already exists")
+	ErrNotFound      = fmt.Errorf("not found")
+	ErrInvalidName   = fmt.Errorf("invalid name")
+	ErrNotSupported  = fmt.Errorf("not supported")
+	ErrDirNotEmpty   = fmt.Errorf("directory not empty")
+	ErrTimeout       = fmt.Errorf("operation timed out")
+	ErrLimitExceeded = fmt.Errorf("limit exceeded")
+	ErrBusy          = fmt.Errorf("file is busy")
+	ErrConflict      = fmt.Errorf("conflicting write")
+	ErrRejected      = fmt.Errorf("content rejected")
 )
 
 // FileSystem is a thread-safe in-memory filesystem that allows basic operations. All public methods
 // are thread-safe.
 type FileSystem struct {
+	// lastID is the last ID assigned to a dir/file. Must be accessed atomically and be first
+	// for 64-bit alignment.
+	lastID uint64
+
 	// trie is thread-safe and provides internal datastructure for
 	// the filesystem metadata.
 	trie *trie.Trie
 
+	// events is thread-safe and notifies watchers of changes.
+	events *eventBus
+
+	// instance is a random ID to avoid collisions when filesystems share external stores.
+	instance string
+
+	// content is optional. If set, file content lives there instead of memory.
+	content ContentStore
+
+	// persist is only set with a MetaStore.
+	persist *persister
+
+	// index is thread-safe and nil if disabled.
+	index *nameIndex
+
+	limits      Limits
+	openPolicy  OpenPolicy
+	writePolicy WritePolicy
+	parallelism int
+
 	// mu protects below.
 	mu         sync.RWMutex
 	currentDir *Dir
 	root       *Dir
+	retention  map[*Dir]RetentionPolicy
+	mounts     map[string]*mount
+	// mountedDir is set when the current dir is under a mount. currentDir is the mount point then.
+	mountedDir string
+}
+
+// Opts are optional settings for a filesystem.
+type Opts struct {
+	// ContentStore keeps file content outside of memory. Defaults to in-memory content.
+	ContentStore ContentStore
+
+	// MetaStore persists the namespace so that it survives restarts. Dirs are loaded from it
+	// lazily when first accessed, so restarts are fast regardless of the number of files. Changes
+	// are written by Sync. Content goes to the ContentStore, which defaults to the MetaStore too.
+	// blob.Bolt keeps it in an embedded KV store, and other stores (i.e., Badger) can be used by
+	// implementing blob.Store. Optional.
+	MetaStore blob.Store
+
+	// Limits bound how long operations can take. Defaults to no limits.
+	Limits Limits
+
+	// OpenPolicy decides what happens when open files are removed/moved. Defaults to OpenBusy.
+	OpenPolicy OpenPolicy
+
+	// WritePolicy decides what happens when a file is written concurrently. Defaults to
+	// WriteSerialize.
+	WritePolicy WritePolicy
+
+	// DisableNameIndex saves the memory of the index of names Find looks files/dirs up in, at the
+	// cost of Find walking the subtree it searches instead.
+	DisableNameIndex bool
+
+	// Parallelism is how many files operations on subtrees (Digest, Snapshot and DeletePrefix)
+	// read or discard the content of at once, which pays off with content in a ContentStore.
+	// Defaults to GOMAXPROCS.
+	Parallelism int
 }
 
 // New returns a new filesystem.
 func New() *FileSystem {
+	return NewWithOpts(Opts{})
+}
+
+// NewWithOpts returns a new filesystem with the given options.
+func NewWithOpts(opts Opts) *FileSystem {
 	t := trie.New()
 	fs := &FileSystem{
-		trie: t,
+		trie:        t,
+		events:      newEventBus(),
+		instance:    newInstanceID(),
+		content:     opts.ContentStore,
+		limits:      opts.Limits,
+		openPolicy:  opts.OpenPolicy,
+		writePolicy: opts.WritePolicy,
+		parallelism: opts.Parallelism,
+		retention:   make(map[*Dir]RetentionPolicy),
+		mounts:      make(map[string]*mount),
+	}
+
+	if !opts.DisableNameIndex {
+		fs.index = newNameIndex()
+	}
+	if fs.parallelism <= 0 {
+		fs.parallelism = runtime.GOMAXPROCS(0)
+	}
+	if opts.MetaStore != nil {
+		fs.persist = newPersister(opts.MetaStore)
+		if fs.content == nil {
+			fs.content = NewBlobContentStore(opts.MetaStore, persistedContentPrefix)
+		}
 	}
 
 	root := newDir(fs)
 	node := t.Add("/", root)
 	root.md.setNode(node)
-
-	return &FileSystem{
-		trie:       t,
-		root:       root,
-		currentDir: root,
+	if fs.persist != nil {
+		root.unloaded = 1
 	}
+
+	fs.root = root
+	fs.currentDir = root
+	return fs
+}
+
+// Limits returns the limits the filesystem was created with.
+func (fs *FileSystem) Limits() Limits {
+	return fs.limits
 }
 
 // CurrentDir returns the absolute path of the current directory
 func (fs *FileSystem) CurrentDir() string {
 	fs.mu.RLock()
 	defer fs.mu.RUnlock()
+	if fs.mountedDir != "" {
+		return fs.mountedDir
+	}
 	return fs.currentDir.md.AbsolutePath()
 }
 
 // ChangeDir switches current directory to s (relative/absolute)
-func (fs *FileSystem) ChangeDir(s string) error {
+func (fs *FileSystem) ChangeDir(s string) (err error) {
+	defer wrapPathError(&err, "chdir", s)
+	s = fs.resolve(s)
+	if m, p, ok := fs.mounted(s); ok {
+		if _, err := m.backend.ListDir(context.Background(), p); err != nil {
+			return err
+		}
+		fs.mu.Lock()
+		defer fs.mu.Unlock()
+		fs.currentDir = fs.findNode(fs.normalizeDirPath(m.path)).Meta().(*Dir)
+		fs.mountedDir = fspath.Join(m.path, p)
+		return nil
+	}
 	s = fs.normalizeDirPath(s)
 	fs.mu.Lock()
 	defer fs.mu.Unlock()
@@ -74,14 +197,27 @@ func (fs *FileSystem) ChangeDir(s string) error {
 		return fmt.Errorf("directory expected. file given")
 	}
 	fs.currentDir = dir
+	fs.mountedDir = ""
 	return nil
 }
 
 // MakeDir makes a new directory relative or absolute.
-func (fs *FileSystem) MakeDir(s string) error {
-	s = fs.normalizeDirPath(s)
+func (fs *FileSystem) MakeDir(s string) (err error) {
+	defer wrapPathError(&err, "mkdir", s)
+	resolved := fs.resolve(s)
+	if m, p, ok := fs.mounted(resolved); ok {
+		return m.backend.MakeDir(context.Background(), p)
+	}
 	fs.mu.Lock()
 	defer fs.mu.Unlock()
+	if resolved != s {
+		// Resolved paths are absolute, so the dir may be nested under root.
+		if resolved == fspath.Root {
+			return ErrInvalidName
+		}
+		return fs.createLocked(fspath.Clean(resolved), true)
+	}
+	s = fs.normalizeDirPath(s)
 	if IsAbs(s) {
 		return fs.mkdirAtNode(s[1:], fs.root.md.node)
 	}
@@ -89,13 +225,18 @@ func (fs *FileSystem) MakeDir(s string) error {
 }
 
 // Remove removes s (relative/absolute) from the filesystem. It could be dir/file.
-func (fs *FileSystem) Remove(s string) error {
-	// s maybe a dir/file.
-	s = fs.normalizePath(s)
-
+func (fs *FileSystem) Remove(s string) (err error) {
+	defer wrapPathError(&err, "remove", s)
+	s = fs.resolve(s)
+	if m, p, ok := fs.mounted(s); ok {
+		return m.backend.Remove(context.Background(), p)
+	}
 	fs.mu.Lock()
 	defer fs.mu.Unlock()
 
+	// s maybe a dir/file.
+	s = fs.normalizePath(s)
+
 	// Check if it's a file
 	node := fs.findNode(s)
 	if node == nil {
@@ -113,16 +254,16 @@ func (fs *FileSystem) Remove(s string) error {
 		return ErrNotSupported
 	}
 
-	_, ok := node.Meta().(*File)
-	if ok {
-		// Just a file. We can remove it
-		fs.trie.Remove(s)
+	if file, ok := node.Meta().(*File); ok {
+		// Just a file. We can remove it unless it's busy.
+		if err := fs.busy(file); err != nil {
+			return err
+		}
+		fs.removeNode(node)
 		return nil
 	}
 
-	// We have a directory. We can only remove it after all its content is gone.
-	// It's a bit more complicated to do it Because we need to do a reverse topological sort.
-	// TODO.
+	// We have a directory. We can only remove it after all its content is gone (see RemoveAll).
 	files, dirs, err := fs.listDir(s)
 	if err != nil {
 		return err
@@ -131,19 +272,24 @@ func (fs *FileSystem) Remove(s string) error {
 		return ErrDirNotEmpty
 	}
 
-	fs.trie.Remove(s)
+	fs.removeNode(node)
 	return nil
 }
 
 // FindFirstRegex returns the first absolute path matching the regex for the given path (absolute/
 // relative)
-func (fs *FileSystem) FindFirstRegex(path, regex string) (string, error) {
-	// s maybe a dir/file.
-	path = fs.normalizePath(path)
-
+func (fs *FileSystem) FindFirstRegex(path, regex string) (_ string, err error) {
+	defer wrapPathError(&err, "regex", path)
+	path = fs.resolve(path)
+	if _, _, ok := fs.mounted(path); ok {
+		return "", fmt.Errorf("searching mounts: %w", ErrNotSupported)
+	}
 	fs.mu.RLock()
 	defer fs.mu.RUnlock()
 
+	// s maybe a dir/file.
+	path = fs.normalizePath(path)
+
 	node := fs.findNode(path)
 	if node == nil {
 		// See if it's a file
@@ -154,21 +300,110 @@ func (fs *FileSystem) FindFirstRegex(path, regex string) (string, error) {
 		return "", ErrNotFound
 	}
 
-	path, _, err := fs.trie.FirstRegexMatchAtNode(regex, node)
+	if fs.limits.MaxRegexDuration > 0 || fs.limits.MaxRegexNodes > 0 {
+		res, err := fs.findRegex(node, regex, 1)
+		if err != nil || len(res.Paths) == 0 {
+			return "", err
+		}
+		return res.Paths[0], nil
+	}
+	fs.loadTree(node)
+	found, _, err := fs.trie.FirstRegexMatchAtNode(regex, node)
 	if err != nil {
 		return "", err
 	}
-	return path, err
+	return found, nil
+}
+
+// RegexResult is the outcome of FindRegex.
+type RegexResult struct {
+	// Paths are absolute. Dirs end with a '/'.
+	Paths []string
+
+	// Visited is the number of dirs/files that were matched against the regex.
+	Visited int
+}
+
+// FindRegex returns up to max absolute paths under path (absolute/relative) that match the regex.
+// It's subject to the regex limits. If a limit is hit, the matches found so far are returned with
+// the error.
+func (fs *FileSystem) FindRegex(path, regex string, max int) (_ RegexResult, err error) {
+	defer wrapPathError(&err, "regex", path)
+	path = fs.resolve(path)
+	if _, _, ok := fs.mounted(path); ok {
+		return RegexResult{}, fmt.Errorf("searching mounts: %w", ErrNotSupported)
+	}
+	if max <= 0 {
+		return RegexResult{}, fmt.Errorf("max results must be positive")
+	}
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+	path = fs.normalizeDirPath(fs.normalizePath(path))
+	node := fs.findNode(path)
+	if node == nil {
+		return RegexResult{}, ErrNotFound
+	}
+	return fs.findRegex(node, regex, max)
 }
 
 // ListDir lists all the files/dirs in s (relative/abs)
-func (fs *FileSystem) ListDir(s string) ([]*File, []*Dir, error) {
+func (fs *FileSystem) ListDir(s string) (_ []*File, _ []*Dir, err error) {
+	defer wrapPathError(&err, "list", s)
+	s = fs.resolve(s)
+	if m, p, ok := fs.mounted(s); ok {
+		return m.listDir(p)
+	}
 	fs.mu.RLock()
 	defer fs.mu.RUnlock()
 	return fs.listDir(s)
 }
 
 func (fs *FileSystem) listDir(s string) ([]*File, []*Dir, error) {
+	files := make([]*File, 0)
+	dirs := make([]*Dir, 0)
+	err := fs.listDirFunc(s, func(file *File, dir *Dir) bool {
+		if dir != nil {
+			dirs = append(dirs, dir)
+		} else {
+			files = append(files, file)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return files, dirs, nil
+}
+
+// ListDirFunc is like ListDir, but calls fn with each file/dir at s (the other one is nil) until
+// it returns false, instead of collecting them. fn is called with the filesystem read-locked, so it
+// must be quick and can't call into the filesystem.
+func (fs *FileSystem) ListDirFunc(s string, fn func(*File, *Dir) bool) (err error) {
+	defer wrapPathError(&err, "list", s)
+	s = fs.resolve(s)
+	if m, p, ok := fs.mounted(s); ok {
+		files, dirs, err := m.listDir(p)
+		if err != nil {
+			return err
+		}
+		for _, file := range files {
+			if !fn(file, nil) {
+				return nil
+			}
+		}
+		for _, dir := range dirs {
+			if !fn(nil, dir) {
+				return nil
+			}
+		}
+		return nil
+	}
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+	return fs.listDirFunc(s, fn)
+}
+
+func (fs *FileSystem) listDirFunc(s string, fn func(*File, *Dir) bool) error {
 	var node *trie.Node
 	if s == "" {
 		node = fs.currentDir.md.node
@@ -176,46 +411,131 @@ func (fs *FileSystem) listDir(s string) ([]*File, []*Dir, error) {
 		s = fs.normalizeDirPath(s)
 		node = fs.findNode(s)
 		if node == nil {
-			return nil, nil, ErrNotFound
+			return ErrNotFound
 		}
 	}
+	fs.loadNode(node)
+	fs.trie.WalkAtNode(node, false, nodeFunc(fn))
+	return nil
+}
 
-	_, nodes, err := fs.trie.ListAtNode(node)
-	if err != nil {
-		return nil, nil, err
+// nodeFunc adapts fn to be called with the file/dir of trie nodes.
+func nodeFunc(fn func(*File, *Dir) bool) func(*trie.Node) bool {
+	return func(n *trie.Node) bool {
+		if dir, ok := n.Meta().(*Dir); ok {
+			return fn(nil, dir)
+		}
+		return fn(n.Meta().(*File), nil)
 	}
+}
 
-	files, dirs := convertNodes(nodes)
-	return files, dirs, nil
+// Stat returns the file or the dir at s (relative/abs), whichever exists.
+func (fs *FileSystem) Stat(s string) (_ *File, _ *Dir, err error) {
+	defer wrapPathError(&err, "stat", s)
+	s = fs.resolve(s)
+	if _, _, ok := fs.mounted(s); ok {
+		return nil, nil, fmt.Errorf("stat of mounted paths: %w", ErrNotSupported)
+	}
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+	for _, p := range []string{s, fs.normalizeDirPath(s)} {
+		if node := fs.findNode(p); node != nil {
+			switch meta := node.Meta().(type) {
+			case *File:
+				return meta, nil, nil
+			case *Dir:
+				return nil, meta, nil
+			}
+		}
+	}
+	return nil, nil, ErrNotFound
 }
 
 // NewFile creates a new empty file at s (relative/absolute).
-func (fs *FileSystem) NewFile(s string) error {
+func (fs *FileSystem) NewFile(s string) (err error) {
+	defer wrapPathError(&err, "create", s)
+	resolved := fs.resolve(s)
+	if m, p, ok := fs.mounted(resolved); ok {
+		return m.backend.CreateFile(context.Background(), p)
+	}
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if resolved != s {
+		if strings.HasSuffix(resolved, SeperatorStr) {
+			return ErrInvalidName
+		}
+		return fs.createLocked(resolved, false)
+	}
+	if IsAbs(s) {
+		return fs.newFileAtNode(s[1:], fs.root.md.node)
+	}
+	return fs.newFileAtNode(s, fs.currentDir.md.node)
+}
+
+// TouchFile creates the file s (relative/abs) if it doesn't exist. Otherwise, it sets its
+// access/modification times to now.
+func (fs *FileSystem) TouchFile(s string) (err error) {
+	defer wrapPathError(&err, "touch", s)
+	resolved := fs.resolve(s)
+	if m, p, ok := fs.mounted(resolved); ok {
+		// Backends don't keep times, so only missing files are created.
+		if err := m.backend.CreateFile(context.Background(), p); !errors.Is(err, ErrAlreadyExist) {
+			return err
+		}
+		return nil
+	}
 	fs.mu.Lock()
 	defer fs.mu.Unlock()
+	if node := fs.findNode(resolved); node != nil {
+		if file, ok := node.Meta().(*File); ok {
+			now := time.Now()
+			file.updateTimes(now, now)
+			fs.changed(file.Path())
+			return nil
+		}
+	}
+	if resolved != s {
+		if strings.HasSuffix(resolved, SeperatorStr) {
+			return ErrInvalidName
+		}
+		return fs.createLocked(resolved, false)
+	}
 	if IsAbs(s) {
 		return fs.newFileAtNode(s[1:], fs.root.md.node)
 	}
 	return fs.newFileAtNode(s, fs.currentDir.md.node)
 }
 
-// Write writes the what's in reader until EOF to the file s (relative/abs).
-func (fs *FileSystem) Write(s string, reader io.Reader) (int64, error) {
+// UpdateTimes sets the access/modification times of the file s (relative/abs).
+func (fs *FileSystem) UpdateTimes(s string, accessed, modified time.Time) (err error) {
+	defer wrapPathError(&err, "touch", s)
+	s = fs.resolve(s)
+	if _, _, ok := fs.mounted(s); ok {
+		return fmt.Errorf("updating times of mounted files: %w", ErrNotSupported)
+	}
 	fs.mu.RLock()
 	node := fs.findNode(s)
 	fs.mu.RUnlock()
 	if node == nil {
-		return -1, ErrNotFound
+		return ErrNotFound
 	}
 	file, ok := node.Meta().(*File)
 	if !ok {
-		return -1, fmt.Errorf("cannot write content on directories")
+		return fmt.Errorf("updating times of dirs: %w", ErrNotSupported)
 	}
-	return file.Write(reader)
+	file.updateTimes(accessed, modified)
+	fs.changed(file.Path())
+	return nil
 }
 
-// Read reads the file at s (relative/abs) and streams its content to writer.
-func (fs *FileSystem) Read(s string, writer io.Writer) (int64, error) {
+// Write writes the what's in reader until EOF to the file s (relative/abs). If reading fails, the
+// file is left as it was.
+func (fs *FileSystem) Write(s string, reader io.Reader) (_ int64, err error) {
+	defer wrapPathError(&err, "write", s)
+	s = fs.resolve(s)
+	if m, p, ok := fs.mounted(s); ok {
+		return m.backend.Write(context.Background(), p, reader)
+	}
 	fs.mu.RLock()
 	node := fs.findNode(s)
 	fs.mu.RUnlock()
@@ -224,63 +544,167 @@ func (fs *FileSystem) Read(s string, writer io.Writer) (int64, error) {
 	}
 	file, ok := node.Meta().(*File)
 	if !ok {
-		return -1, fmt.Errorf("cannot read content on directories")
+		return -1, fmt.Errorf("cannot write content on directories")
+	}
+	n, err := fs.write(file, fs.limitReader(reader))
+	if n > 0 || err == nil {
+		fs.account(file)
+	}
+	if n > 0 {
+		fs.publish(EventWrite, file.Path(), false)
 	}
-	return file.Read(writer)
+	return n, err
 }
 
-// Move moves a file from src to dst. src/dst are relative or absolute.
-func (fs *FileSystem) Move(src, dst string) error {
-	if err := validateName(src); err != nil {
-		return ErrInvalidName
+// Read reads the file at s (relative/abs) and streams its content to writer.
+func (fs *FileSystem) Read(s string, writer io.Writer) (_ int64, err error) {
+	defer wrapPathError(&err, "read", s)
+	s = fs.resolve(s)
+	if m, p, ok := fs.mounted(s); ok {
+		return m.backend.Read(context.Background(), p, writer)
+	}
+	// Keep the file open while reading so that it isn't removed under the reader.
+	h, err := fs.open(s)
+	if err != nil {
+		return -1, err
 	}
+	defer h.Close()
+	return h.Read(fs.limitWriter(writer))
+}
 
-	if err := validateName(dst); err != nil {
-		return ErrInvalidName
+// Move moves a file from src to dst. src/dst are relative or absolute.
+func (fs *FileSystem) Move(src, dst string) (err error) {
+	defer wrapPathError(&err, "move", src)
+	src, dst = fs.resolve(src), fs.resolve(dst)
+
+	_, _, srcMounted := fs.mounted(src)
+	_, _, dstMounted := fs.mounted(dst)
+	if srcMounted || dstMounted {
+		return fmt.Errorf("moving mounted files: %w", ErrNotSupported)
 	}
 
 	fs.mu.Lock()
 	defer fs.mu.Unlock()
 	srcNode := fs.findNode(src)
 	if srcNode == nil {
-		return fmt.Errorf("%s %w", src, ErrNotFound)
+		return ErrNotFound
 	}
 
 	dstNode := fs.findNode(dst)
 	if dstNode != nil {
 		// Don't support overwrites
-		return fmt.Errorf("%s %w", dst, ErrAlreadyExist)
+		return &PathError{Op: "move", Path: dst, Err: ErrAlreadyExist}
 	}
 
 	// No-op
 	if srcNode == dstNode {
 		return nil
 	}
+	if file, ok := srcNode.Meta().(*File); ok {
+		if err := fs.busy(file); err != nil {
+			return err
+		}
+	}
 
 	absSrc := fs.normalizePath(src)
 	absDst := fs.normalizePath(dst)
 
-	fs.trie.Add(absDst, srcNode.Meta())
+	fs.detach(nodePath(srcNode), srcNode.Meta())
+	added := fs.trie.Add(absDst, srcNode.Meta())
 	fs.trie.Remove(absSrc)
+	switch meta := srcNode.Meta().(type) {
+	case *File:
+		meta.md.moveNode(added)
+	case *Dir:
+		meta.md.moveNode(added)
+	}
+	fs.attach(nodePath(added), added.Meta())
+	_, isDir := srcNode.Meta().(*Dir)
+	if isDir {
+		fs.moved(absSrc, absDst)
+	}
+	fs.changed(absSrc)
+	fs.changed(absDst)
+	fs.events.publish(Event{Type: EventMove, Path: absSrc, NewPath: absDst, IsDir: isDir, Time: time.Now()})
+	return nil
+}
+
+// Copy copies the file at src to a new file at dst. src/dst are relative or absolute.
+func (fs *FileSystem) Copy(src, dst string) (err error) {
+	defer wrapPathError(&err, "copy", src)
+	read := func(writer io.Writer) (int64, error) { return fs.Read(src, writer) }
+	if _, _, ok := fs.mounted(src); !ok {
+		// Keep the file open so that it isn't removed while copying it.
+		h, err := fs.Open(src)
+		if err != nil {
+			return err
+		}
+		defer h.Close()
+		read = h.Read
+	}
+
+	if err := fs.NewFile(dst); err != nil {
+		return err
+	}
+	pr, pw := io.Pipe()
+	go func() {
+		_, err := read(pw)
+		pw.CloseWithError(err)
+	}()
+	_, err = fs.Write(dst, pr)
+	// Unblocks the reader if writing failed.
+	pr.Close()
+	if err != nil {
+		if err := fs.Remove(dst); err != nil {
+			glog.Warningf("Failed to remove partial copy %s. %s\n", dst, err)
+		}
+		return err
+	}
 	return nil
 }
 
 // Find returns the list of files/dirs that match search given the path (relative/abs)
-func (fs *FileSystem) Find(path, search string) ([]*File, []*Dir, error) {
+func (fs *FileSystem) Find(path, search string) (_ []*File, _ []*Dir, err error) {
+	files := make([]*File, 0)
+	dirs := make([]*Dir, 0)
+	err = fs.FindFunc(path, search, func(file *File, dir *Dir) bool {
+		if dir != nil {
+			dirs = append(dirs, dir)
+		} else {
+			files = append(files, file)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return files, dirs, nil
+}
+
+// FindFunc is like Find, but calls fn with each match (see ListDirFunc).
+func (fs *FileSystem) FindFunc(path, search string, fn func(*File, *Dir) bool) (err error) {
+	defer wrapPathError(&err, "find", path)
+	path = fs.resolve(path)
+	if _, _, ok := fs.mounted(path); ok {
+		return fmt.Errorf("searching mounts: %w", ErrNotSupported)
+	}
 	path = fs.normalizeDirPath(path)
 	fs.mu.RLock()
 	defer fs.mu.RUnlock()
 	node := fs.findNode(path)
 	if node == nil {
-		return nil, nil, ErrNotFound
+		return ErrNotFound
 	}
-	_, nodes, err := fs.trie.ExactSearchAtNode(search, node)
-	if err != nil {
-		return nil, nil, err
+	fs.loadTree(node)
+	each := nodeFunc(fn)
+	if fs.index != nil {
+		fs.findIndexed(node, search, each)
+		return nil
 	}
-
-	files, dirs := convertNodes(nodes)
-	return files, dirs, nil
+	fs.trie.WalkAtNode(node, true, func(n *trie.Node) bool {
+		return !strings.EqualFold(search, n.Name()) || each(n)
+	})
+	return nil
 }
 
 func (fs *FileSystem) IsAbs(s string) bool {
@@ -297,24 +721,27 @@ func (fs *FileSystem) mkdirAtNode(path string, n *trie.Node) error {
 		return err
 	}
 
-	// TODO: Support creating subdirectories. For now, we only support one
+	// Nested dirs are made by MakeDirAll.
 	splitted := strings.Split(path, SeperatorStr)
 	if len(splitted) != 2 {
 		return ErrNotSupported
 	}
 
 	// Check if we already have a dir with this name
-	if _, ok := fs.trie.FindAtNode(path, n); ok {
-		return ErrAlreadyExist
+	fs.loadNode(n)
+	if node, ok := fs.trie.FindAtNode(path, n); ok {
+		return existsError(node)
 	}
 	// Try for a file
-	if _, ok := fs.trie.FindAtNode(path[:len(path)-1], n); ok {
-		return ErrAlreadyExist
+	if node, ok := fs.trie.FindAtNode(path[:len(path)-1], n); ok {
+		return existsError(node)
 	}
 
 	dir := newDir(fs)
 	added := fs.trie.AddAtNode(path, n, dir)
 	dir.md.setNode(added)
+	fs.attach(dir.Path(), dir)
+	fs.publish(EventMakeDir, dir.Path(), true)
 	return nil
 }
 
@@ -323,6 +750,9 @@ func (fs *FileSystem) findNode(path string) *trie.Node {
 	if IsAbs(path) {
 		node = fs.trie.Root()
 	}
+	if fs.persist != nil {
+		fs.loadAlong(node, path)
+	}
 	node, _ = fs.trie.FindAtNode(path, node)
 	return node
 }
@@ -336,7 +766,6 @@ func (fs *FileSystem) normalizeDirPath(path string) string {
 }
 
 func (fs *FileSystem) normalizePath(path string) string {
-	// TODO: support . and ..
 	if IsAbs(path) {
 		return path
 	}
@@ -345,10 +774,37 @@ func (fs *FileSystem) normalizePath(path string) string {
 	if fs.currentDir == fs.root {
 		separator = ""
 	}
-	s := fs.currentDir.md.AbsolutePath() + separator + path
+	dir := fs.currentDir.md.AbsolutePath()
+	if fs.mountedDir != "" {
+		dir = fs.mountedDir
+	}
+	s := dir + separator + path
 	return s
 }
 
+// absPath is normalizePath for callers that don't hold mu, since it depends on the current dir.
+func (fs *FileSystem) absPath(path string) string {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+	return fs.normalizePath(path)
+}
+
+// resolve lexically resolves the '.' and '..' components of s (relative/abs) into an absolute
+// path, keeping its trailing separator. '..' at root stays at root. Paths without such components
+// are returned as they are. Must be called without mu held.
+func (fs *FileSystem) resolve(s string) string {
+	if !hasDots(s) {
+		return s
+	}
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+	p := fspath.Clean(fs.normalizePath(s))
+	if p != fspath.Root && (strings.HasSuffix(s, SeperatorStr) || endsInDots(s)) {
+		p += SeperatorStr
+	}
+	return p
+}
+
 // creates a new file at n with relative path
 func (fs *FileSystem) newFileAtNode(path string, n *trie.Node) error {
 	if path == "" || strings.HasSuffix(path, SeperatorStr) {
@@ -367,17 +823,20 @@ func (fs *FileSystem) newFileAtNode(path string, n *trie.Node) error {
 	}
 
 	// Check if we already have a file with this name
-	if _, ok := fs.trie.FindAtNode(path, n); ok {
-		return ErrAlreadyExist
+	fs.loadNode(n)
+	if node, ok := fs.trie.FindAtNode(path, n); ok {
+		return existsError(node)
 	}
 	// Try for a directory
-	if _, ok := fs.trie.FindAtNode(path+SeperatorStr, n); ok {
-		return ErrAlreadyExist
+	if node, ok := fs.trie.FindAtNode(path+SeperatorStr, n); ok {
+		return existsError(node)
 	}
 
 	file := newFile(fs)
 	added := fs.trie.AddAtNode(path, n, file)
 	file.md.setNode(added)
+	fs.attach(file.Path(), file)
+	fs.publish(EventCreate, file.Path(), false)
 	return nil
 }
 
@@ -397,6 +856,14 @@ func convertNodes(nodes []*trie.Node) ([]*File, []*Dir) {
 	return files, dirs
 }
 
+func newInstanceID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 16)
+	}
+	return hex.EncodeToString(b)
+}
+
 func IsAbs(s string) bool {
-	return s != "" && s[0] == Separator
+	return fspath.IsAbs(s)
 }
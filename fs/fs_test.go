@@ -2,8 +2,25 @@ package fs
 
 import (
 	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
 	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/basharal/filesystem/blob"
 )
 
 func createTestFS() (*FileSystem, error) {
@@ -83,7 +100,7 @@ func TestFileSystem_Move(t *testing.T) {
 			if _, err := fs.Read(tt.args.dst, bytes.NewBuffer(nil)); err != nil {
 				t.Errorf("FileSystem.Read() error = %v, wantErr %v", err, nil)
 			}
-			if _, err := fs.Read(tt.args.src, bytes.NewBuffer(nil)); err != ErrNotFound {
+			if _, err := fs.Read(tt.args.src, bytes.NewBuffer(nil)); !errors.Is(err, ErrNotFound) {
 				t.Errorf("FileSystem.Read() error = %v, wantErr %v", err, ErrNotFound)
 			}
 		})
@@ -199,3 +216,2126 @@ func TestFileSystem_Find(t *testing.T) {
 		})
 	}
 }
+
+func TestFileSystem_ListDirFunc(t *testing.T) {
+	fs, err := createTestFS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	err = fs.ListDirFunc("/bar", func(file *File, dir *Dir) bool {
+		if dir != nil {
+			names = append(names, dir.String()+"/")
+		} else {
+			names = append(names, file.String())
+		}
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(names)
+	if want := []string{"file1", "file2", "file3", "foo/", "foo2/"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("ListDirFunc(/bar) = %v, want %v", names, want)
+	}
+
+	// Returning false stops the listing.
+	calls := 0
+	if err := fs.ListDirFunc("/bar", func(*File, *Dir) bool { calls++; return false }); err != nil || calls != 1 {
+		t.Errorf("ListDirFunc() = %v after %d calls, want 1 call", err, calls)
+	}
+	if err := fs.ListDirFunc("/nope", func(*File, *Dir) bool { return true }); !errors.Is(err, ErrNotFound) {
+		t.Errorf("ListDirFunc(/nope) = %v, want %v", err, ErrNotFound)
+	}
+
+	var paths []string
+	err = fs.FindFunc("/", "FOO", func(file *File, dir *Dir) bool {
+		paths = append(paths, dir.Path())
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(paths)
+	if want := []string{"/bar/foo", "/foo"}; !reflect.DeepEqual(paths, want) {
+		t.Errorf("FindFunc(FOO) = %v, want %v", paths, want)
+	}
+}
+
+func TestFileSystem_FindIndex(t *testing.T) {
+	find := func(fs *FileSystem, path, search string) []string {
+		files, dirs, err := fs.Find(path, search)
+		if err != nil {
+			t.Fatal(err)
+		}
+		paths := make([]string, 0)
+		for _, file := range files {
+			paths = append(paths, file.Path())
+		}
+		for _, dir := range dirs {
+			paths = append(paths, dir.Path())
+		}
+		sort.Strings(paths)
+		return paths
+	}
+	for _, disabled := range []bool{false, true} {
+		t.Run(fmt.Sprintf("Disabled=%v", disabled), func(t *testing.T) {
+			fs := NewWithOpts(Opts{DisableNameIndex: disabled})
+			for _, p := range []string{"/a/", "/a/f1", "/b/", "/b/F1", "/b/c/", "/b/c/f1", "/f2"} {
+				if err := fs.create(strings.TrimSuffix(p, "/"), strings.HasSuffix(p, "/")); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if got, want := find(fs, "/", "f1"), []string{"/a/f1", "/b/F1", "/b/c/f1"}; !reflect.DeepEqual(got, want) {
+				t.Errorf("Find(/, f1) = %v, want %v", got, want)
+			}
+			if got, want := find(fs, "/b", "f1"), []string{"/b/F1", "/b/c/f1"}; !reflect.DeepEqual(got, want) {
+				t.Errorf("Find(/b, f1) = %v, want %v", got, want)
+			}
+			if got, want := find(fs, "/b", "c"), []string{"/b/c"}; !reflect.DeepEqual(got, want) {
+				t.Errorf("Find(/b, c) = %v, want %v", got, want)
+			}
+
+			// Moved and removed entries are found where they are now, or not at all.
+			if err := fs.Move("/a/f1", "/b/c/f3"); err != nil {
+				t.Fatal(err)
+			}
+			if err := fs.Move("/f2", "/a/f1"); err != nil {
+				t.Fatal(err)
+			}
+			if err := fs.Remove("/b/F1"); err != nil {
+				t.Fatal(err)
+			}
+			if got, want := find(fs, "/", "f1"), []string{"/a/f1", "/b/c/f1"}; !reflect.DeepEqual(got, want) {
+				t.Errorf("Find(/, f1) = %v after changes, want %v", got, want)
+			}
+			if got, want := find(fs, "/", "f2"), []string{}; !reflect.DeepEqual(got, want) {
+				t.Errorf("Find(/, f2) = %v after changes, want %v", got, want)
+			}
+			if _, err := fs.DeletePrefix("/b/c/", false); err != nil {
+				t.Fatal(err)
+			}
+			if got, want := find(fs, "/", "f1"), []string{"/a/f1"}; !reflect.DeepEqual(got, want) {
+				t.Errorf("Find(/, f1) = %v after DeletePrefix, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestFileSystem_Watch(t *testing.T) {
+	fs := New()
+	events, stop := fs.Watch(10)
+	defer stop()
+
+	if err := fs.MakeDir("foo"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.ChangeDir("foo"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.NewFile("bar"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.Write("/foo/bar", bytes.NewBufferString("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Remove("/foo/bar"); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []Event{
+		{Type: EventMakeDir, Path: "/foo", IsDir: true},
+		{Type: EventCreate, Path: "/foo/bar"},
+		{Type: EventWrite, Path: "/foo/bar"},
+		{Type: EventRemove, Path: "/foo/bar"},
+	}
+	for _, want := range expected {
+		got := <-events
+		if got.Type != want.Type || got.Path != want.Path || got.IsDir != want.IsDir {
+			t.Errorf("Expected event %v %s, got %v %s", want.Type, want.Path, got.Type, got.Path)
+		}
+	}
+}
+
+func TestFileSystem_WatchOverflow(t *testing.T) {
+	fs := New()
+	events, stop := fs.Watch(2)
+	for i := 1; i <= 5; i++ {
+		if err := fs.NewFile(fmt.Sprintf("/f%d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stop()
+	// The oldest events make room for the newest, which report them.
+	var paths []string
+	dropped := 0
+	for e := range events {
+		paths = append(paths, e.Path)
+		dropped += e.Dropped
+	}
+	if !reflect.DeepEqual(paths, []string{"/f4", "/f5"}) || dropped != 3 {
+		t.Errorf("FileSystem.Watch() = %v with %d dropped, want [/f4 /f5] with 3", paths, dropped)
+	}
+}
+
+func TestFileSystem_ApplyRetention(t *testing.T) {
+	fs, err := createTestFS()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Only keep 2 files in /bar. file1 was written last.
+	if err := fs.SetRetention("/bar", RetentionPolicy{MaxFiles: 2}); err != nil {
+		t.Fatal(err)
+	}
+	removed, err := fs.ApplyRetention(time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != 1 {
+		t.Errorf("Expected 1 removed file, got %v", removed)
+	}
+	files, _, err := fs.ListDir("/bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 {
+		t.Errorf("Expected 2 files, got %d", len(files))
+	}
+
+	// Everything directly under the root expires. /bar has its own policy.
+	if err := fs.SetRetention("/", RetentionPolicy{MaxAge: time.Hour}); err != nil {
+		t.Fatal(err)
+	}
+	removed, err = fs.ApplyRetention(time.Now().Add(2 * time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(removed)
+	expected := []string{"/f1", "/f2", "/f3"}
+	if len(removed) != len(expected) {
+		t.Fatalf("Expected %v removed, got %v", expected, removed)
+	}
+	for i := range expected {
+		if removed[i] != expected[i] {
+			t.Errorf("Expected %s removed, got %s", expected[i], removed[i])
+		}
+	}
+	if got := len(fs.Retention()); got != 2 {
+		t.Errorf("Expected 2 policies, got %d", got)
+	}
+}
+
+func TestFileSystem_Archive(t *testing.T) {
+	fs, err := createTestFS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := blob.NewDisk(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rules := []LifecycleRule{{Prefix: "/bar", ColdAfter: time.Hour}}
+	archived, err := fs.Archive(store, rules, time.Now().Add(2*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(archived) != 3 {
+		t.Fatalf("Expected 3 archived files, got %v", archived)
+	}
+	files, _, err := fs.ListDir("/bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range files {
+		if !f.Archived() {
+			t.Errorf("Expected %s to be archived", f.Path())
+		}
+	}
+
+	// Reading rehydrates the content.
+	buf := bytes.NewBuffer(nil)
+	if _, err := fs.Read("/bar/file1", buf); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "foobar" {
+		t.Errorf("Expected foobar, got %s", buf.String())
+	}
+}
+
+func TestFileSystem_ContentStore(t *testing.T) {
+	store := blob.NewMemory()
+	fs := NewWithOpts(Opts{ContentStore: NewBlobContentStore(store, "test/")})
+	if err := fs.NewFile("foo"); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{"hello ", "world"} {
+		if _, err := fs.Write("foo", bytes.NewBufferString(s)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	buf := bytes.NewBuffer(nil)
+	if _, err := fs.Read("foo", buf); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "hello world" {
+		t.Errorf("Expected hello world, got %s", buf.String())
+	}
+	files, _, err := fs.ListDir("/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || files[0].Size() != 11 {
+		t.Fatalf("Expected a single file of size 11")
+	}
+	buf.Reset()
+	if _, err := files[0].ReadAt(buf, 6); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "world" {
+		t.Errorf("Expected world, got %s", buf.String())
+	}
+
+	// Content is dropped from the store on removal.
+	if err := fs.Remove("foo"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Get("test/"+strconv.FormatUint(files[0].md.ID(), 10), buf); err != blob.ErrNotFound {
+		t.Errorf("Expected content to be deleted, got %v", err)
+	}
+}
+
+func TestFileSystem_MetaStore(t *testing.T) {
+	store := blob.NewMemory()
+	fs := NewWithOpts(Opts{MetaStore: store})
+	for _, dir := range []string{"/a", "/c", "/gone"} {
+		if err := fs.MakeDir(dir); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := fs.ChangeDir("/a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.MakeDir("b"); err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range []string{"/a/b/f1", "/a/f2", "/f3", "/gone/f4"} {
+		// Files can only be created in the current dir.
+		if err := fs.ChangeDir(filepath.Dir(file)); err != nil {
+			t.Fatal(err)
+		}
+		if err := fs.NewFile(filepath.Base(file)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := fs.ChangeDir("/"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.Write("/a/b/f1", bytes.NewBufferString("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Move("/a/b/f1", "/c/f1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.Write("/c/f1", bytes.NewBufferString(" world")); err != nil {
+		t.Fatal(err)
+	}
+	_, gone, err := fs.Stat("/gone")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.DeletePrefix("/gone", false); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	restarted := NewWithOpts(Opts{MetaStore: store})
+	files, dirs, err := restarted.ListDir("/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || len(dirs) != 2 {
+		t.Fatalf("ListDir(/) = %d files, %d dirs, want 1 file, 2 dirs", len(files), len(dirs))
+	}
+	_, dir, err := restarted.Stat("/c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The usage comes from the root's record, before /c is loaded.
+	if got, want := dir.Usage(), (DirUsage{Files: 1, Size: 11}); got != want {
+		t.Errorf("Dir.Usage(/c) = %+v, want %+v", got, want)
+	}
+	buf := bytes.NewBuffer(nil)
+	if _, err := restarted.Read("/c/f1", buf); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "hello world" {
+		t.Errorf("Expected hello world, got %s", buf.String())
+	}
+	if _, _, err := restarted.Stat("/a/b/f1"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Stat(/a/b/f1) error = %v, want %v", err, ErrNotFound)
+	}
+	if _, _, err := restarted.Stat("/a/b"); err != nil {
+		t.Errorf("Stat(/a/b) error = %v", err)
+	}
+	if _, err := store.Get(dirKey(gone.md.ID()), buf); err != blob.ErrNotFound {
+		t.Errorf("Expected the record of /gone to be deleted, got %v", err)
+	}
+
+	// IDs aren't reused, so content isn't overwritten.
+	if err := restarted.NewFile("/f4"); err != nil {
+		t.Fatal(err)
+	}
+	file, _, err := restarted.Stat("/f4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if file.md.ID() <= gone.md.ID() {
+		t.Errorf("Expected a new ID, got %d", file.md.ID())
+	}
+}
+
+func TestFileSystem_MetaStoreCorruptRecord(t *testing.T) {
+	store := blob.NewMemory()
+	fs := NewWithOpts(Opts{MetaStore: store})
+	for _, file := range []string{"/c/f1", "/c/f2"} {
+		if err := fs.CreateFile(file, CreateOpts{Parents: true}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := fs.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	_, dir, err := fs.Stat("/c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := dirKey(dir.md.ID())
+	record := bytes.NewBuffer(nil)
+	if _, err := store.Get(key, record); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Put(key, strings.NewReader("{corrupt")); err != nil {
+		t.Fatal(err)
+	}
+
+	// /c fails to load, so it looks empty, but its record isn't overwritten by a sync.
+	restarted := NewWithOpts(Opts{MetaStore: store})
+	if err := restarted.CreateFile("/c/f3", CreateOpts{Parents: true}); err != nil {
+		t.Fatal(err)
+	}
+	if err := restarted.Sync(); err == nil {
+		t.Errorf("Sync() succeeded with /c not loaded")
+	}
+	buf := bytes.NewBuffer(nil)
+	if _, err := store.Get(key, buf); err != nil || buf.String() != "{corrupt" {
+		t.Errorf("Sync() overwrote the record of /c with %q, %v", buf.String(), err)
+	}
+
+	// Once the record is repaired, the children are back.
+	if _, err := store.Put(key, record); err != nil {
+		t.Fatal(err)
+	}
+	repaired := NewWithOpts(Opts{MetaStore: store})
+	files, _, err := repaired.ListDir("/c")
+	if err != nil || len(files) != 2 {
+		t.Errorf("ListDir(/c) = %v, %v, want f1 and f2", files, err)
+	}
+}
+
+func TestFileSystem_MetaStoreBolt(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "meta.db")
+	store, err := blob.OpenBolt(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs := NewWithOpts(Opts{MetaStore: store})
+	for i := 0; i < 50; i++ {
+		file := fmt.Sprintf("/d%d/sub/f%d", i%10, i)
+		if err := fs.CreateFile(file, CreateOpts{Parents: true}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := fs.Write(file, strings.NewReader(file)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := fs.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	_, d0, err := fs.Stat("/d0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err = blob.OpenBolt(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	restarted := NewWithOpts(Opts{MetaStore: store})
+	_, dirs, err := restarted.ListDir("/")
+	if err != nil || len(dirs) != 10 {
+		t.Fatalf("ListDir(/) = %d dirs, %v, want 10", len(dirs), err)
+	}
+	// The usage comes from the root's record, before /d0 is loaded.
+	if got, want := dirs[0].Usage(), d0.Usage(); dirs[0].Path() != "/d0" || got != want {
+		t.Errorf("Dir.Usage(%s) = %+v, want %+v", dirs[0].Path(), got, want)
+	}
+	var buf bytes.Buffer
+	if _, err := restarted.Read("/d3/sub/f13", &buf); err != nil || buf.String() != "/d3/sub/f13" {
+		t.Errorf("Read(/d3/sub/f13) = %q, %v, want its path", buf.String(), err)
+	}
+}
+
+func TestFileSystem_Snapshot(t *testing.T) {
+	fs, err := createTestFS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, before, err := fs.Stat("/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := fs.Snapshot(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	restored := New()
+	snapshot := buf.Bytes()
+	if _, err := restored.RestoreSnapshot(bytes.NewReader(snapshot), RestoreOpts{}); err != nil {
+		t.Fatal(err)
+	}
+	_, after, err := restored.Stat("/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if before.Usage() != after.Usage() {
+		t.Errorf("Dir.Usage(/) = %+v, want %+v", after.Usage(), before.Usage())
+	}
+	for _, dir := range []string{"/foo", "/bar/foo", "/bar/foo2"} {
+		if _, d, err := restored.Stat(dir); err != nil || d == nil {
+			t.Errorf("Stat(%s) = %v, %v, want a dir", dir, d, err)
+		}
+	}
+	content := bytes.NewBuffer(nil)
+	if _, err := restored.Read("/bar/file1", content); err != nil {
+		t.Fatal(err)
+	}
+	if content.String() != "foobar" {
+		t.Errorf("Expected foobar, got %s", content.String())
+	}
+
+	// Partial restores leave the rest as is.
+	if _, err := fs.Write("/bar/file1", bytes.NewBufferString("baz")); err != nil {
+		t.Fatal(err)
+	}
+	n, err := fs.RestoreSnapshot(bytes.NewReader(snapshot), RestoreOpts{Path: "/bar", Target: "/bar.restored"})
+	if err != nil || n != 6 {
+		t.Fatalf("RestoreSnapshot() = %d, %v, want 6", n, err)
+	}
+	for path, want := range map[string]string{"/bar/file1": "foobarbaz", "/bar.restored/file1": "foobar"} {
+		content.Reset()
+		if _, err := fs.Read(path, content); err != nil {
+			t.Fatal(err)
+		}
+		if content.String() != want {
+			t.Errorf("Read(%s) = %s, want %s", path, content.String(), want)
+		}
+	}
+	if _, err := fs.RestoreSnapshot(bytes.NewReader(snapshot), RestoreOpts{Path: "/missing"}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("RestoreSnapshot() error = %v, want %v", err, ErrNotFound)
+	}
+}
+
+func TestFileSystem_SnapshotSince(t *testing.T) {
+	fs, err := createTestFS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	since := time.Now()
+	var full, inc bytes.Buffer
+	if err := fs.Snapshot(&full); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Move("/bar/file1", "/foo/moved"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.Write("/f1", bytes.NewBufferString("new")); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.SnapshotSince(&inc, since); err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(inc.Bytes(), []byte("foobar")) {
+		t.Errorf("SnapshotSince() included the content of an unchanged file")
+	}
+
+	layer := func(b []byte) SnapshotLayer {
+		return func() (io.ReadCloser, error) {
+			return ioutil.NopCloser(bytes.NewReader(b)), nil
+		}
+	}
+	restored := New()
+	if _, err := restored.RestoreSnapshots([]SnapshotLayer{layer(full.Bytes()), layer(inc.Bytes())}, RestoreOpts{}); err != nil {
+		t.Fatal(err)
+	}
+	content := bytes.NewBuffer(nil)
+	for path, want := range map[string]string{"/foo/moved": "foobar", "/f1": "new"} {
+		content.Reset()
+		if _, err := restored.Read(path, content); err != nil {
+			t.Fatal(err)
+		}
+		if content.String() != want {
+			t.Errorf("Read(%s) = %s, want %s", path, content.String(), want)
+		}
+	}
+	if _, _, err := restored.Stat("/bar/file1"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Stat(/bar/file1) error = %v, want %v", err, ErrNotFound)
+	}
+	if _, err := New().RestoreSnapshot(bytes.NewReader(inc.Bytes()), RestoreOpts{}); err == nil {
+		t.Errorf("RestoreSnapshot() of an incremental snapshot succeeded, want an error")
+	}
+}
+
+func TestFileSystem_Digest(t *testing.T) {
+	fs, err := createTestFS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := createTestFS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum, entries, err := fs.Digest("/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 5 || entries[0].Name != "bar" || !entries[0].IsDir {
+		t.Errorf("Digest(/) entries = %+v, want bar, f1, f2, f3 and foo", entries)
+	}
+	if otherSum, _, err := other.Digest("/"); err != nil || !bytes.Equal(sum, otherSum) {
+		t.Errorf("Digest(/) of equal trees = %x, %v, want %x", otherSum, err, sum)
+	}
+
+	if _, err := other.Write("/bar/file2", bytes.NewBufferString("x")); err != nil {
+		t.Fatal(err)
+	}
+	otherSum, otherEntries, err := other.Digest("/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(sum, otherSum) {
+		t.Errorf("Digest(/) didn't change with content")
+	}
+	for i := range entries {
+		if changed := !bytes.Equal(entries[i].Sum, otherEntries[i].Sum); changed != (entries[i].Name == "bar") {
+			t.Errorf("Digest(/) entry %s changed = %v", entries[i].Name, changed)
+		}
+	}
+
+	// Local trees with the same names and content have the same digests.
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "foo"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "foo", "a"), []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	loaded := New()
+	if err := loaded.LoadFromOS(dir, LoadOpts{}); err != nil {
+		t.Fatal(err)
+	}
+	sum, _, err = loaded.Digest("/foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if osSum, _, err := DigestOS(filepath.Join(dir, "foo")); err != nil || !bytes.Equal(sum, osSum) {
+		t.Errorf("DigestOS() = %x, %v, want %x", osSum, err, sum)
+	}
+}
+
+func TestFileSystem_Parallelism(t *testing.T) {
+	fs := NewWithOpts(Opts{Parallelism: 4})
+	next := 0
+	err := fs.ordered(100, func(i int) (interface{}, error) {
+		time.Sleep(time.Duration(100-i) * time.Microsecond)
+		return i, nil
+	}, func(i int, v interface{}, err error) error {
+		if i != next || v.(int) != i || err != nil {
+			t.Fatalf("done(%d, %v, %v), want %d", i, v, err, next)
+		}
+		next++
+		return nil
+	})
+	if err != nil || next != 100 {
+		t.Errorf("ordered() = %v after %d results, want 100", err, next)
+	}
+
+	// Failures stop the calls that haven't started.
+	errFailed := errors.New("failed")
+	var calls int32
+	err = fs.parallel(1000, func(i int) error {
+		atomic.AddInt32(&calls, 1)
+		return errFailed
+	})
+	if !errors.Is(err, errFailed) || atomic.LoadInt32(&calls) > 4 {
+		t.Errorf("parallel() = %v after %d calls, want %v after at most 4", err, calls, errFailed)
+	}
+
+	// Subtree operations give the same results at any parallelism.
+	var digests [][]byte
+	for _, parallelism := range []int{1, 8} {
+		fs := NewWithOpts(Opts{Parallelism: parallelism})
+		for _, dir := range []string{"/a", "/a/b", "/c"} {
+			if err := fs.create(dir, true); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for i, file := range []string{"/f", "/a/f", "/a/b/f", "/c/f"} {
+			if err := fs.create(file, false); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := fs.Write(file, strings.NewReader(strings.Repeat("x", i))); err != nil {
+				t.Fatal(err)
+			}
+		}
+		sum, _, err := fs.Digest("/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		digests = append(digests, sum)
+	}
+	if !bytes.Equal(digests[0], digests[1]) {
+		t.Errorf("Digest() differs across parallelisms")
+	}
+}
+
+func TestFileSystem_LoadFromOS(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "foo", "bar"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for path, content := range map[string]string{"a": "hello", "foo/b": "world", "foo/bar/c": "!"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, path), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, lazy := range []bool{false, true} {
+		fs := New()
+		err := fs.LoadFromOS(dir, LoadOpts{
+			Lazy:   lazy,
+			Filter: func(path string, isDir bool) bool { return path != "/a" },
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		files, dirs, err := fs.ListDir("/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(files) != 0 || len(dirs) != 1 {
+			t.Fatalf("Expected only /foo, got %v %v", files, dirs)
+		}
+		files, _, err = fs.ListDir("/foo")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(files) != 1 || files[0].Size() != 5 {
+			t.Fatalf("Expected a single file of size 5")
+		}
+		buf := bytes.NewBuffer(nil)
+		if _, err := fs.Read("/foo/bar/c", buf); err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != "!" {
+			t.Errorf("Expected !, got %s", buf.String())
+		}
+		if _, err := fs.Write("/foo/b", bytes.NewBufferString("!")); err != nil {
+			t.Fatal(err)
+		}
+		buf.Reset()
+		if _, err := fs.Read("/foo/b", buf); err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != "world!" {
+			t.Errorf("Expected world!, got %s", buf.String())
+		}
+	}
+}
+
+func TestFileSystem_Mirror(t *testing.T) {
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "a"), []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	fs := New()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	// Local changes are only pulled in as they're notified, without rescans.
+	go func() { done <- fs.Mirror(ctx, dir, MirrorOpts{Interval: time.Hour}) }()
+	defer func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Error(err)
+		}
+	}()
+
+	eventually := func(cond func() bool) {
+		t.Helper()
+		for start := time.Now(); !cond(); time.Sleep(10 * time.Millisecond) {
+			if time.Since(start) > 5*time.Second {
+				t.Fatal("Timed out")
+			}
+		}
+	}
+	fsContent := func(path string) string {
+		buf := bytes.NewBuffer(nil)
+		if _, err := fs.Read(path, buf); err != nil {
+			return ""
+		}
+		return buf.String()
+	}
+	osContent := func(path string) string {
+		content, _ := ioutil.ReadFile(filepath.Join(dir, path))
+		return string(content)
+	}
+
+	// Local changes are pulled in.
+	eventually(func() bool { return fsContent("/a") == "hello" })
+	if err := os.Mkdir(filepath.Join(dir, "foo"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "foo", "b"), []byte("world"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	eventually(func() bool { return fsContent("/foo/b") == "world" })
+
+	// Filesystem changes are written through.
+	if _, err := fs.Write("/a", bytes.NewBufferString("!")); err != nil {
+		t.Fatal(err)
+	}
+	eventually(func() bool { return osContent("a") == "hello!" })
+	if err := fs.Move("/foo/b", "/foo/c"); err != nil {
+		t.Fatal(err)
+	}
+	eventually(func() bool { return osContent("foo/c") == "world" })
+
+	// Local removals are pulled in.
+	if err := os.Remove(filepath.Join(dir, "a")); err != nil {
+		t.Fatal(err)
+	}
+	eventually(func() bool { return fsContent("/a") == "" })
+	if osContent("foo/c") != "world" {
+		t.Errorf("Expected /foo/c to still exist")
+	}
+}
+
+func TestFileSystem_MirrorPullsNotifiedPaths(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a", "b", "c"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte("hello"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// filtered are the paths the filter was called with, which scans call it with.
+	var mu sync.Mutex
+	filtered := make(map[string]bool)
+	filter := func(path string, isDir bool) bool {
+		mu.Lock()
+		defer mu.Unlock()
+		filtered[path] = true
+		return true
+	}
+	fs := New()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- fs.Mirror(ctx, dir, MirrorOpts{Interval: time.Hour, Filter: filter}) }()
+	defer func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Error(err)
+		}
+	}()
+	eventually := func(cond func() bool) {
+		t.Helper()
+		for start := time.Now(); !cond(); time.Sleep(10 * time.Millisecond) {
+			if time.Since(start) > 5*time.Second {
+				t.Fatal("Timed out")
+			}
+		}
+	}
+	content := func(path string) string {
+		buf := bytes.NewBuffer(nil)
+		if _, err := fs.Read(path, buf); err != nil {
+			return ""
+		}
+		return buf.String()
+	}
+	eventually(func() bool { return content("/c") == "hello" })
+
+	mu.Lock()
+	filtered = make(map[string]bool)
+	mu.Unlock()
+	if err := ioutil.WriteFile(filepath.Join(dir, "b"), []byte("edited"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	eventually(func() bool { return content("/b") == "edited" })
+	// Only the edited file was scanned.
+	mu.Lock()
+	defer mu.Unlock()
+	if !reflect.DeepEqual(filtered, map[string]bool{"/b": true}) {
+		t.Errorf("Mirror() scanned %v, want only /b", filtered)
+	}
+}
+
+func TestOverlay(t *testing.T) {
+	lower := New()
+	for _, dir := range []string{"/foo", "/bar", "/foo/foo"} {
+		if err := lower.create(dir, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, file := range []string{"/foo/bar", "/foo/foo/bar"} {
+		if err := lower.create(file, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := lower.Write("/foo/bar", bytes.NewBufferString("hello")); err != nil {
+		t.Fatal(err)
+	}
+	o := NewOverlay(New(), lower)
+
+	read := func(path string) string {
+		buf := bytes.NewBuffer(nil)
+		if _, err := o.Read(path, buf); err != nil {
+			t.Fatal(err)
+		}
+		return buf.String()
+	}
+	names := func(path string) []string {
+		files, dirs, err := o.ListDir(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		names := make([]string, 0)
+		for _, f := range files {
+			names = append(names, f.Path())
+		}
+		for _, d := range dirs {
+			names = append(names, d.Path())
+		}
+		sort.Strings(names)
+		return names
+	}
+
+	// Reads fall through and writes are copied up.
+	if read("/foo/bar") != "hello" {
+		t.Errorf("Expected hello from the lower layer")
+	}
+	if _, err := o.Write("/foo/bar", bytes.NewBufferString(" world")); err != nil {
+		t.Fatal(err)
+	}
+	if read("/foo/bar") != "hello world" {
+		t.Errorf("Expected hello world from the upper layer")
+	}
+	buf := bytes.NewBuffer(nil)
+	if _, err := lower.Read("/foo/bar", buf); err != nil || buf.String() != "hello" {
+		t.Errorf("Expected the lower layer to be untouched")
+	}
+
+	// New entries go to the upper layer and merge with the lower ones.
+	if err := o.ChangeDir("/foo"); err != nil {
+		t.Fatal(err)
+	}
+	if err := o.NewFile("baz"); err != nil {
+		t.Fatal(err)
+	}
+	if got := names("/foo"); strings.Join(got, ",") != "/foo/bar,/foo/baz,/foo/foo" {
+		t.Errorf("Unexpected listing %v", got)
+	}
+
+	// Removals hide the lower layer.
+	if err := o.Remove("/bar"); err != nil {
+		t.Fatal(err)
+	}
+	if err := o.Remove("/foo/foo"); !errors.Is(err, ErrDirNotEmpty) {
+		t.Errorf("Expected ErrDirNotEmpty, got %v", err)
+	}
+	if err := o.Move("/foo/foo/bar", "/bar"); err != nil {
+		t.Fatal(err)
+	}
+	if err := o.Remove("/foo/foo"); err != nil {
+		t.Fatal(err)
+	}
+	if err := o.MakeDir("/foo/foo"); err != nil {
+		t.Fatal(err)
+	}
+	if got := names("/"); strings.Join(got, ",") != "/bar,/foo" {
+		t.Errorf("Unexpected listing %v", got)
+	}
+	if got := names("/foo/foo"); len(got) != 0 {
+		t.Errorf("Expected recreated dir to be empty, got %v", got)
+	}
+	if _, _, err := lower.ListDir("/bar"); err != nil {
+		t.Errorf("Expected the lower layer to be untouched")
+	}
+}
+
+// fsBackend mounts a filesystem into another one.
+type fsBackend struct {
+	fs *FileSystem
+}
+
+func (b fsBackend) ListDir(ctx context.Context, path string) ([]BackendEntry, error) {
+	files, dirs, err := b.fs.ListDir(path)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]BackendEntry, 0)
+	for _, f := range files {
+		entries = append(entries, BackendEntry{Name: f.String(), Size: f.Size()})
+	}
+	for _, d := range dirs {
+		entries = append(entries, BackendEntry{Name: d.String(), IsDir: true})
+	}
+	return entries, nil
+}
+
+func (b fsBackend) MakeDir(ctx context.Context, path string) error { return b.fs.create(path, true) }
+
+func (b fsBackend) CreateFile(ctx context.Context, path string) error {
+	return b.fs.create(path, false)
+}
+
+func (b fsBackend) Remove(ctx context.Context, path string) error { return b.fs.Remove(path) }
+
+func (b fsBackend) Read(ctx context.Context, path string, writer io.Writer) (int64, error) {
+	return b.fs.Read(path, writer)
+}
+
+func (b fsBackend) Write(ctx context.Context, path string, reader io.Reader) (int64, error) {
+	return b.fs.Write(path, reader)
+}
+
+func TestFileSystem_Mount(t *testing.T) {
+	fs, err := createTestFS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	remote := New()
+	if err := fs.Mount("/bar/foo", fsBackend{fs: remote}); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Mount("/bar", fsBackend{fs: remote}); err == nil {
+		t.Errorf("Expected overlapping mounts to fail")
+	}
+
+	if err := fs.ChangeDir("/bar/foo"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.MakeDir("dir"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.ChangeDir("dir"); err != nil {
+		t.Fatal(err)
+	}
+	if fs.CurrentDir() != "/bar/foo/dir" {
+		t.Errorf("Expected /bar/foo/dir, got %s", fs.CurrentDir())
+	}
+	if err := fs.NewFile("file"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.Write("file", bytes.NewBufferString("hello")); err != nil {
+		t.Fatal(err)
+	}
+
+	// Changes land in the mounted filesystem.
+	buf := bytes.NewBuffer(nil)
+	if _, err := remote.Read("/dir/file", buf); err != nil || buf.String() != "hello" {
+		t.Errorf("Expected hello in the mounted filesystem, got %s %v", buf.String(), err)
+	}
+	files, dirs, err := fs.ListDir("/bar/foo/dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || len(dirs) != 0 || files[0].Path() != "/bar/foo/dir/file" || files[0].Size() != 5 {
+		t.Fatalf("Unexpected listing %v %v", files, dirs)
+	}
+	buf.Reset()
+	if _, err := files[0].ReadAt(buf, 1); err != nil || buf.String() != "ello" {
+		t.Errorf("Expected ello, got %s %v", buf.String(), err)
+	}
+	if err := fs.Move("/bar/foo/dir/file", "/f4"); err == nil {
+		t.Errorf("Expected moving out of a mount to fail")
+	}
+
+	// Unmounting reveals the local dir again.
+	if err := fs.Unmount("/bar/foo"); err != nil {
+		t.Fatal(err)
+	}
+	if fs.CurrentDir() != "/bar/foo" {
+		t.Errorf("Expected /bar/foo, got %s", fs.CurrentDir())
+	}
+	if _, _, err := fs.ListDir("/bar/foo/dir"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+}
+
+// slowReader returns a byte per read after sleeping.
+type slowReader struct {
+	delay time.Duration
+}
+
+func (r slowReader) Read(p []byte) (int, error) {
+	time.Sleep(r.delay)
+	p[0] = 'a'
+	return 1, nil
+}
+
+func TestFileSystem_Limits(t *testing.T) {
+	fs := NewWithOpts(Opts{Limits: Limits{MaxWriteDuration: 20 * time.Millisecond, MaxRegexDuration: time.Second}})
+	if err := fs.NewFile("foo"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.Write("foo", slowReader{delay: time.Millisecond}); !errors.Is(err, ErrTimeout) {
+		t.Errorf("Expected ErrTimeout, got %v", err)
+	}
+	if err := fs.MakeDir("bar"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.ChangeDir("bar"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.NewFile("baz"); err != nil {
+		t.Fatal(err)
+	}
+	found, err := fs.FindFirstRegex("/", "ba.$")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found != "/bar/baz" {
+		t.Errorf("Expected /bar/baz, got %s", found)
+	}
+
+	fs.limits.MaxRegexDuration = time.Nanosecond
+	if _, err := fs.FindFirstRegex("/", "ba.$"); !errors.Is(err, ErrTimeout) {
+		t.Errorf("Expected ErrTimeout, got %v", err)
+	}
+}
+
+func TestFileSystem_FindRegex(t *testing.T) {
+	fs, err := createTestFS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := fs.FindRegex("/", "file[12]$", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(res.Paths)
+	if strings.Join(res.Paths, ",") != "/bar/file1,/bar/file2" {
+		t.Errorf("Unexpected matches %v", res.Paths)
+	}
+	res, err = fs.FindRegex("/bar", "file", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Paths) != 1 {
+		t.Errorf("Expected a single match, got %v", res.Paths)
+	}
+
+	fs.limits.MaxRegexNodes = 2
+	res, err = fs.FindRegex("/", "file", 10)
+	if !errors.Is(err, ErrLimitExceeded) {
+		t.Errorf("Expected ErrLimitExceeded, got %v", err)
+	}
+	if res.Visited != 2 {
+		t.Errorf("Expected 2 visited nodes, got %d", res.Visited)
+	}
+}
+
+func TestFileSystem_DeletePrefix(t *testing.T) {
+	fs, err := createTestFS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, dirs, err := fs.ListDir("/bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	files, _, err := fs.ListDir("/bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := len(files) + len(dirs) + 1
+
+	// Can't remove the current directory.
+	if err := fs.ChangeDir("/bar/foo"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.DeletePrefix("/bar", false); !errors.Is(err, ErrNotSupported) {
+		t.Errorf("Expected ErrNotSupported, got %v", err)
+	}
+	if err := fs.ChangeDir("/"); err != nil {
+		t.Fatal(err)
+	}
+	n, err := fs.DeletePrefix("/bar", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n < want {
+		t.Errorf("Expected at least %d entries, got %d", want, n)
+	}
+	if _, _, err := fs.ListDir("/bar"); err != nil {
+		t.Errorf("Expected dry run to keep /bar, got %v", err)
+	}
+
+	removed, err := fs.DeletePrefix("/bar", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed != n {
+		t.Errorf("Expected %d removed entries, got %d", n, removed)
+	}
+	if _, _, err := fs.ListDir("/bar"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+
+	if _, err := fs.DeletePrefix("/", false); err != nil {
+		t.Fatal(err)
+	}
+	files, dirs, err = fs.ListDir("/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 0 || len(dirs) != 0 {
+		t.Errorf("Expected an empty root, got %v %v", files, dirs)
+	}
+}
+
+func TestFileSystem_TouchFile(t *testing.T) {
+	fs := New()
+	if err := fs.TouchFile("/foo"); err != nil {
+		t.Fatal(err)
+	}
+	files, _, err := fs.ListDir("/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Fatalf("Expected 1 file, got %d", len(files))
+	}
+
+	past := time.Now().Add(-time.Hour)
+	if err := fs.UpdateTimes("/foo", past, past); err != nil {
+		t.Fatal(err)
+	}
+	if !files[0].ModTime().Equal(past) || !files[0].AccessTime().Equal(past) {
+		t.Errorf("Expected times to be %v, got %v %v", past, files[0].ModTime(), files[0].AccessTime())
+	}
+	if _, err := fs.Write("/foo", strings.NewReader("hello")); err != nil {
+		t.Fatal(err)
+	}
+
+	// Touching an existing file only updates its times.
+	if err := fs.TouchFile("foo"); err != nil {
+		t.Fatal(err)
+	}
+	if !files[0].ModTime().After(past) {
+		t.Errorf("Expected mod time after %v, got %v", past, files[0].ModTime())
+	}
+	if files[0].Size() != 5 {
+		t.Errorf("Expected content to be kept, got size %d", files[0].Size())
+	}
+
+	if err := fs.MakeDir("/bar"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.TouchFile("/bar"); !errors.Is(err, ErrAlreadyExist) {
+		t.Errorf("Expected ErrAlreadyExist, got %v", err)
+	}
+	if err := fs.UpdateTimes("/baz", past, past); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestFileSystem_RemoveAll(t *testing.T) {
+	fs, err := createTestFS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.CreateFile("/bar/foo/a/b/file", CreateOpts{Parents: true}); err != nil {
+		t.Fatal(err)
+	}
+	h, err := fs.Open("/bar/file1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.RemoveAll("/bar"); !errors.Is(err, ErrBusy) {
+		t.Errorf("FileSystem.RemoveAll(/bar) = %v, want ErrBusy", err)
+	}
+	if _, dir, err := fs.Stat("/bar/foo/a/b/"); err != nil || dir == nil {
+		t.Errorf("FileSystem.Stat() = %v, %v, want nothing removed while a file is busy", dir, err)
+	}
+	if err := h.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.ChangeDir("/bar/foo"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.RemoveAll("a"); err != nil {
+		t.Errorf("FileSystem.RemoveAll(a) = %v", err)
+	}
+	if err := fs.RemoveAll(".."); !errors.Is(err, ErrNotSupported) {
+		t.Errorf("FileSystem.RemoveAll(..) = %v, want ErrNotSupported for the current dir", err)
+	}
+	if err := fs.ChangeDir("/"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.RemoveAll("/bar"); err != nil {
+		t.Errorf("FileSystem.RemoveAll(/bar) = %v", err)
+	}
+	if _, dir, err := fs.Stat("/bar/"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("FileSystem.Stat(/bar/) = %v, %v, want it removed", dir, err)
+	}
+	if err := fs.RemoveAll("/bar"); err != nil {
+		t.Errorf("FileSystem.RemoveAll() of a missing dir = %v, want nil", err)
+	}
+	if err := fs.RemoveAll("/"); !errors.Is(err, ErrNotSupported) {
+		t.Errorf("FileSystem.RemoveAll(/) = %v, want ErrNotSupported", err)
+	}
+}
+
+func TestFileSystem_Open(t *testing.T) {
+	fs := New()
+	if err := fs.NewFile("/foo"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.Write("/foo", strings.NewReader("hello")); err != nil {
+		t.Fatal(err)
+	}
+	h, err := fs.Open("/foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Remove("/foo"); !errors.Is(err, ErrBusy) {
+		t.Errorf("Expected ErrBusy, got %v", err)
+	}
+	if err := fs.Move("/foo", "/bar"); !errors.Is(err, ErrBusy) {
+		t.Errorf("Expected ErrBusy, got %v", err)
+	}
+	if _, err := fs.DeletePrefix("/", false); !errors.Is(err, ErrBusy) {
+		t.Errorf("Expected ErrBusy, got %v", err)
+	}
+	if err := h.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// Closing twice is a no-op.
+	if err := h.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Remove("/foo"); err != nil {
+		t.Fatal(err)
+	}
+
+	// With OpenDeferRemove, the content stays readable until the handle is closed.
+	fs = NewWithOpts(Opts{OpenPolicy: OpenDeferRemove})
+	if err := fs.NewFile("/foo"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.Write("/foo", strings.NewReader("hello")); err != nil {
+		t.Fatal(err)
+	}
+	h, err = fs.Open("/foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+	if err := fs.Remove("/foo"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.Read("/foo", ioutil.Discard); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+	var buf bytes.Buffer
+	if _, err := h.Read(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "hello" {
+		t.Errorf("Expected hello, got %q", buf.String())
+	}
+	if !h.File().removed {
+		t.Errorf("Expected the file to be marked as removed")
+	}
+}
+
+// blockingReader blocks reads until release is closed.
+type blockingReader struct {
+	data    string
+	started chan struct{}
+	release chan struct{}
+	done    bool
+}
+
+func (r *blockingReader) Read(p []byte) (int, error) {
+	if r.done {
+		return 0, io.EOF
+	}
+	close(r.started)
+	<-r.release
+	r.done = true
+	return copy(p, r.data), nil
+}
+
+func TestFileSystem_WritePolicy(t *testing.T) {
+	tests := []struct {
+		policy  WritePolicy
+		wantErr error
+		want    string
+	}{
+		{WriteSerialize, nil, "firstsecond"},
+		{WriteReject, ErrConflict, "first"},
+		// The first write finishes last, but it overlapped with the second one so it wins.
+		{WriteLastWins, nil, "first"},
+	}
+	for _, test := range tests {
+		fs := NewWithOpts(Opts{WritePolicy: test.policy})
+		if err := fs.NewFile("/foo"); err != nil {
+			t.Fatal(err)
+		}
+		first := &blockingReader{data: "first", started: make(chan struct{}), release: make(chan struct{})}
+		errc := make(chan error, 1)
+		go func() {
+			_, err := fs.Write("/foo", first)
+			errc <- err
+		}()
+		<-first.started
+
+		second := make(chan error, 1)
+		go func() {
+			_, err := fs.Write("/foo", strings.NewReader("second"))
+			second <- err
+		}()
+		if test.policy != WriteSerialize {
+			// The second write doesn't wait for the first one.
+			if err := <-second; !errors.Is(err, test.wantErr) {
+				t.Errorf("Policy %d: expected %v, got %v", test.policy, test.wantErr, err)
+			}
+		}
+		close(first.release)
+		if err := <-errc; err != nil {
+			t.Fatal(err)
+		}
+		if test.policy == WriteSerialize {
+			if err := <-second; err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		var buf bytes.Buffer
+		if _, err := fs.Read("/foo", &buf); err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != test.want {
+			t.Errorf("Policy %d: expected %q, got %q", test.policy, test.want, buf.String())
+		}
+	}
+}
+
+func TestFileSystem_WriteAbandoned(t *testing.T) {
+	errAbandoned := errors.New("client went away")
+	for name, opts := range map[string]Opts{
+		"Memory":       {},
+		"ContentStore": {ContentStore: NewBlobContentStore(blob.NewMemory(), "test/")},
+	} {
+		fs := NewWithOpts(opts)
+		if err := fs.NewFile("/foo"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := fs.Write("/foo", strings.NewReader("kept")); err != nil {
+			t.Fatal(err)
+		}
+		events, stop := fs.Watch(1)
+		reader := io.MultiReader(strings.NewReader("partial"), iotest.ErrReader(errAbandoned))
+		if n, err := fs.Write("/foo", reader); !errors.Is(err, errAbandoned) || n != 0 {
+			t.Errorf("%s: Write() = %d, %v, want 0, %v", name, n, err, errAbandoned)
+		}
+		stop()
+		for e := range events {
+			t.Errorf("%s: got %v for an abandoned write", name, e)
+		}
+		var buf bytes.Buffer
+		if _, err := fs.Read("/foo", &buf); err != nil || buf.String() != "kept" {
+			t.Errorf("%s: Read() = %q, %v, want kept", name, buf.String(), err)
+		}
+	}
+}
+
+func TestFileSystem_Copy(t *testing.T) {
+	// Setup
+	fs, err := createTestFS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.ChangeDir("/bar"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		src     string
+		dst     string
+		want    string
+		wantErr error
+	}{
+		{"Content", "/bar/file1", "/copy1", "foobar", nil},
+		{"Empty", "/f1", "copy2", "", nil},
+		{"Exists", "/bar/file1", "file2", "", ErrAlreadyExist},
+		{"NotFound", "/nope", "/copy3", "", ErrNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := fs.Copy(tt.src, tt.dst); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("FileSystem.Copy() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+			buf := bytes.NewBuffer(nil)
+			if _, err := fs.Read(tt.dst, buf); err != nil || buf.String() != tt.want {
+				t.Errorf("FileSystem.Read() = %q, %v, want %q", buf.String(), err, tt.want)
+			}
+		})
+	}
+	if _, err := fs.Read("/copy3", bytes.NewBuffer(nil)); !errors.Is(err, ErrNotFound) {
+		t.Errorf("FileSystem.Copy() created dst of a missing src")
+	}
+	buf := bytes.NewBuffer(nil)
+	if _, err := fs.Read("/bar/file2", buf); err != nil || buf.Len() != 0 {
+		t.Errorf("FileSystem.Copy() overwrote an existing dst")
+	}
+}
+
+func TestFileSystem_DirUsage(t *testing.T) {
+	// Setup
+	fs, err := createTestFS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	usage := func(path string) DirUsage {
+		_, dir, err := fs.Stat(path)
+		if err != nil || dir == nil {
+			t.Fatalf("FileSystem.Stat(%s) = %v, %v", path, dir, err)
+		}
+		return dir.Usage()
+	}
+	check := func(step, path string, want DirUsage) {
+		if got := usage(path); got != want {
+			t.Errorf("%s: Dir.Usage(%s) = %+v, want %+v", step, path, got, want)
+		}
+	}
+	check("Created", "/", DirUsage{Files: 3, Dirs: 2, Size: 6})
+	check("Created", "/bar", DirUsage{Files: 3, Dirs: 2, Size: 6})
+
+	if err := fs.ChangeDir("/bar/foo"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.NewFile("x"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.Write("x", bytes.NewBufferString("abcd")); err != nil {
+		t.Fatal(err)
+	}
+	check("Written", "/bar/foo", DirUsage{Files: 1, Size: 4})
+	check("Written", "/bar", DirUsage{Files: 3, Dirs: 2, Size: 10})
+	check("Written", "/", DirUsage{Files: 3, Dirs: 2, Size: 10})
+
+	if err := fs.Move("/bar/file1", "/moved"); err != nil {
+		t.Fatal(err)
+	}
+	check("Moved", "/bar", DirUsage{Files: 2, Dirs: 2, Size: 4})
+	check("Moved", "/", DirUsage{Files: 4, Dirs: 2, Size: 10})
+
+	if err := fs.Remove("/moved"); err != nil {
+		t.Fatal(err)
+	}
+	check("Removed", "/", DirUsage{Files: 3, Dirs: 2, Size: 4})
+
+	if err := fs.ChangeDir("/"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.DeletePrefix("/bar", false); err != nil {
+		t.Fatal(err)
+	}
+	check("DeletedPrefix", "/", DirUsage{Files: 3, Dirs: 1})
+}
+
+// BenchmarkFileSystem_Memory reports the heap used per entry of a namespace of 100 dirs holding
+// 1000 files each, named alike across dirs like real trees are.
+func BenchmarkFileSystem_Memory(b *testing.B) {
+	const dirs, files = 100, 1000
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		runtime.GC()
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		fs := New()
+		for d := 0; d < dirs; d++ {
+			dir := "/dir" + strconv.Itoa(d)
+			if err := fs.MakeDir(dir); err != nil {
+				b.Fatal(err)
+			}
+			if err := fs.ChangeDir(dir); err != nil {
+				b.Fatal(err)
+			}
+			for f := 0; f < files; f++ {
+				if err := fs.NewFile("file" + strconv.Itoa(f) + ".txt"); err != nil {
+					b.Fatal(err)
+				}
+			}
+			if err := fs.ChangeDir("/"); err != nil {
+				b.Fatal(err)
+			}
+		}
+		runtime.GC()
+		runtime.ReadMemStats(&after)
+		b.ReportMetric(float64(after.HeapAlloc-before.HeapAlloc)/(dirs*(files+1)), "B/entry")
+		runtime.KeepAlive(fs)
+	}
+}
+
+// remoteStore is a blob.Store with the latency of a remote one (i.e., S3) for reads.
+type remoteStore struct {
+	*blob.Memory
+}
+
+func (s remoteStore) Get(key string, writer io.Writer) (int64, error) {
+	time.Sleep(time.Millisecond)
+	return s.Memory.Get(key, writer)
+}
+
+// benchmarkWideTree runs op on a tree of 20 dirs holding 50 files of 4KiB each, with content in
+// a remote store, at different parallelisms.
+func benchmarkWideTree(b *testing.B, op func(fs *FileSystem) error) {
+	for _, parallelism := range []int{1, 4, 16} {
+		b.Run("Parallelism"+strconv.Itoa(parallelism), func(b *testing.B) {
+			store := NewBlobContentStore(remoteStore{blob.NewMemory()}, "")
+			fs := NewWithOpts(Opts{ContentStore: store, Parallelism: parallelism})
+			content := bytes.Repeat([]byte("x"), 4<<10)
+			for d := 0; d < 20; d++ {
+				dir := "/dir" + strconv.Itoa(d)
+				if err := fs.MakeDir(dir); err != nil {
+					b.Fatal(err)
+				}
+				if err := fs.ChangeDir(dir); err != nil {
+					b.Fatal(err)
+				}
+				for f := 0; f < 50; f++ {
+					name := "file" + strconv.Itoa(f)
+					if err := fs.NewFile(name); err != nil {
+						b.Fatal(err)
+					}
+					if _, err := fs.Write(name, bytes.NewReader(content)); err != nil {
+						b.Fatal(err)
+					}
+				}
+			}
+			if err := fs.ChangeDir("/"); err != nil {
+				b.Fatal(err)
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := op(fs); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkFileSystem_Digest(b *testing.B) {
+	benchmarkWideTree(b, func(fs *FileSystem) error {
+		_, _, err := fs.Digest("/")
+		return err
+	})
+}
+
+func BenchmarkFileSystem_Snapshot(b *testing.B) {
+	benchmarkWideTree(b, func(fs *FileSystem) error {
+		return fs.Snapshot(ioutil.Discard)
+	})
+}
+
+// BenchmarkFileSystem_Find searches a namespace of 1000 dirs holding 100 files each for a name
+// that's in a single dir, with and without the name index.
+func BenchmarkFileSystem_Find(b *testing.B) {
+	for _, disabled := range []bool{false, true} {
+		b.Run(fmt.Sprintf("DisableNameIndex=%v", disabled), func(b *testing.B) {
+			fs := NewWithOpts(Opts{DisableNameIndex: disabled})
+			for d := 0; d < 1000; d++ {
+				dir := "/dir" + strconv.Itoa(d)
+				if err := fs.MakeDir(dir); err != nil {
+					b.Fatal(err)
+				}
+				if err := fs.ChangeDir(dir); err != nil {
+					b.Fatal(err)
+				}
+				for f := 0; f < 100; f++ {
+					if err := fs.NewFile("file" + strconv.Itoa(d*100+f)); err != nil {
+						b.Fatal(err)
+					}
+				}
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				files, _, err := fs.Find("/", "file4242")
+				if err != nil {
+					b.Fatal(err)
+				}
+				if len(files) != 1 {
+					b.Fatalf("found %d files, want 1", len(files))
+				}
+			}
+		})
+	}
+}
+
+func TestFileSystem_Preallocate(t *testing.T) {
+	fs, err := createTestFS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	const size = 1 << 20
+	if err := fs.Preallocate("/bar/file1", size); err != nil {
+		t.Fatal(err)
+	}
+	f, _, err := fs.Stat("/bar/file1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cap(f.content) < len("foobar")+size {
+		t.Errorf("Preallocate() cap = %d, want at least %d", cap(f.content), len("foobar")+size)
+	}
+	preallocated := &f.content[:cap(f.content)][cap(f.content)-1]
+	data := bytes.Repeat([]byte("x"), size)
+	if _, err := fs.Write("/bar/file1", bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+	if &f.content[len(f.content)-1] != preallocated {
+		t.Errorf("Write() reallocated preallocated content")
+	}
+	buf := bytes.NewBuffer(nil)
+	if _, err := fs.Read("/bar/file1", buf); err != nil || buf.String() != "foobar"+string(data) {
+		t.Errorf("Read() = %d bytes, %v, want foobar and %d bytes", buf.Len(), err, size)
+	}
+
+	if err := fs.Preallocate("/nope", size); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Preallocate(/nope) error = %v, want %v", err, ErrNotFound)
+	}
+	if err := fs.Preallocate("/bar/", size); err == nil {
+		t.Errorf("Preallocate(/bar/) of a dir succeeded")
+	}
+}
+
+func TestFileSystem_ExistsError(t *testing.T) {
+	fs, err := createTestFS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.ChangeDir("/bar"); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		create func() error
+		want   ExistsError
+	}{
+		{"FileOverDir", func() error { return fs.NewFile("foo") }, ExistsError{Path: "/bar/foo", IsDir: true}},
+		{"DirOverFile", func() error { return fs.MakeDir("file1") }, ExistsError{Path: "/bar/file1"}},
+		{"FileOverFile", func() error { return fs.NewFile("/f1") }, ExistsError{Path: "/f1"}},
+		{"DirOverDir", func() error { return fs.MakeDir("/foo") }, ExistsError{Path: "/foo", IsDir: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.create()
+			var ee *ExistsError
+			if !errors.As(err, &ee) || *ee != tt.want || !errors.Is(err, ErrAlreadyExist) {
+				t.Errorf("error = %v, want %+v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestFileSystem_CreateFileParents(t *testing.T) {
+	fs, err := createTestFS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.ChangeDir("/bar"); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		path    string
+		wantErr error
+	}{
+		{"Absolute", "/a/b/c/file", nil},
+		{"ExistingParents", "/a/b/other", nil},
+		{"Relative", "foo/x/file", nil},
+		{"FileInTheWay", "/f1/file", ErrAlreadyExist},
+		{"Exists", "/a/b/c/file", ErrAlreadyExist},
+		{"Empty", "/a//file", ErrInvalidName},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := fs.CreateFile(tt.path, CreateOpts{Parents: true}); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("FileSystem.CreateFile() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+			if file, _, err := fs.Stat(tt.path); err != nil || file == nil {
+				t.Errorf("FileSystem.Stat() = %v, %v, want the created file", file, err)
+			}
+		})
+	}
+	if _, dir, err := fs.Stat("/bar/foo/x/"); err != nil || dir == nil {
+		t.Errorf("FileSystem.Stat(/bar/foo/x/) = %v, %v, want the created dir", dir, err)
+	}
+	if err := fs.CreateFile("/g/file", CreateOpts{}); err == nil {
+		t.Errorf("FileSystem.CreateFile() without Parents created missing parents")
+	}
+}
+
+func TestFileSystem_MakeDirAll(t *testing.T) {
+	fs, err := createTestFS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.ChangeDir("/bar"); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		path    string
+		wantErr error
+	}{
+		{"Absolute", "/a/b/c/", nil},
+		{"NoTrailingSeparator", "/a/b/d", nil},
+		{"Relative", "foo/x/y/", nil},
+		{"Exists", "/a/b/c/", nil},
+		{"FileInTheWay", "/f1/x/", ErrAlreadyExist},
+		{"Empty", "/a//b/", ErrInvalidName},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := fs.MakeDirAll(tt.path); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("FileSystem.MakeDirAll() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+	for _, dir := range []string{"/a/b/c/", "/a/b/d/", "/bar/foo/x/y/"} {
+		if _, d, err := fs.Stat(dir); err != nil || d == nil {
+			t.Errorf("FileSystem.Stat(%s) = %v, %v, want the created dir", dir, d, err)
+		}
+	}
+}
+
+func TestFileSystem_Dots(t *testing.T) {
+	fs, err := createTestFS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.ChangeDir("/bar/foo"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.ChangeDir(".."); err != nil || fs.CurrentDir() != "/bar" {
+		t.Fatalf("FileSystem.ChangeDir(..) = %v, in %s, want /bar", err, fs.CurrentDir())
+	}
+	if err := fs.ChangeDir("./foo/../../.."); err != nil || fs.CurrentDir() != "/" {
+		t.Fatalf("FileSystem.ChangeDir(./foo/../../..) = %v, in %s, want /", err, fs.CurrentDir())
+	}
+	if err := fs.ChangeDir("bar/foo"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.MakeDir("../new"); err != nil {
+		t.Fatalf("FileSystem.MakeDir(../new) = %v", err)
+	}
+	if err := fs.NewFile("../new/./file"); err != nil {
+		t.Fatalf("FileSystem.NewFile(../new/./file) = %v", err)
+	}
+	// Names ending in a dot are files, unlike paths ending in '.' or '..'.
+	for _, name := range []string{"../new/./notes.", "/bar/new/../new/notes.."} {
+		if err := fs.NewFile(name); err != nil {
+			t.Errorf("FileSystem.NewFile(%s) = %v", name, err)
+		}
+	}
+	for _, p := range []string{"/bar/new/notes.", "/bar/new/notes.."} {
+		if file, _, err := fs.Stat(p); err != nil || file == nil {
+			t.Errorf("FileSystem.Stat(%s) = %v, %v, want the file", p, file, err)
+		}
+		if err := fs.Remove(p); err != nil {
+			t.Errorf("FileSystem.Remove(%s) = %v", p, err)
+		}
+	}
+	if err := fs.NewFile("../new/."); !errors.Is(err, ErrInvalidName) {
+		t.Errorf("FileSystem.NewFile(../new/.) = %v, want ErrInvalidName", err)
+	}
+	if _, err := fs.Write("/bar/foo/../new/file", bytes.NewBufferString("dots")); err != nil {
+		t.Fatalf("FileSystem.Write() = %v", err)
+	}
+	var buf bytes.Buffer
+	if _, err := fs.Read("../../bar/new/file", &buf); err != nil || buf.String() != "dots" {
+		t.Errorf("FileSystem.Read() = %q, %v, want dots", buf.String(), err)
+	}
+	if err := fs.Move("../new/file", "../../f4"); err != nil {
+		t.Fatalf("FileSystem.Move() = %v", err)
+	}
+	if file, _, err := fs.Stat("/f4"); err != nil || file == nil {
+		t.Errorf("FileSystem.Stat(/f4) = %v, %v, want the moved file", file, err)
+	}
+	if err := fs.Remove("../new/"); err != nil {
+		t.Errorf("FileSystem.Remove(../new/) = %v", err)
+	}
+	if err := fs.Remove("."); !errors.Is(err, ErrNotSupported) {
+		t.Errorf("FileSystem.Remove(.) = %v, want ErrNotSupported", err)
+	}
+	if err := fs.MakeDir("/.."); !errors.Is(err, ErrInvalidName) {
+		t.Errorf("FileSystem.MakeDir(/..) = %v, want ErrInvalidName", err)
+	}
+}
+
+func TestFileSystem_DotsLookups(t *testing.T) {
+	fs, err := createTestFS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.ChangeDir("/bar/foo"); err != nil {
+		t.Fatal(err)
+	}
+	files, dirs, err := fs.ListDir("..")
+	if err != nil || len(files) != 3 || len(dirs) != 2 {
+		t.Errorf("FileSystem.ListDir(..) = %d files, %d dirs, %v, want 3 files and 2 dirs", len(files), len(dirs), err)
+	}
+	if file, _, err := fs.Stat("/foo/../bar/file1"); err != nil || file == nil || file.Size() != 6 {
+		t.Errorf("FileSystem.Stat(/foo/../bar/file1) = %v, %v, want /bar/file1", file, err)
+	}
+	if _, dir, err := fs.Stat("."); err != nil || dir == nil || dir.Path() != "/bar/foo" {
+		t.Errorf("FileSystem.Stat(.) = %v, %v, want /bar/foo", dir, err)
+	}
+	if files, _, err := fs.Find("../..", "file2"); err != nil || len(files) != 1 {
+		t.Errorf("FileSystem.Find(../.., file2) = %v, %v, want /bar/file2", files, err)
+	}
+	if err := fs.TouchFile("./x"); err != nil {
+		t.Fatalf("FileSystem.TouchFile(./x) = %v", err)
+	}
+	if file, _, err := fs.Stat("/bar/foo/x"); err != nil || file == nil {
+		t.Errorf("FileSystem.Stat(/bar/foo/x) = %v, %v, want the touched file", file, err)
+	}
+	// Touching it again only updates its times.
+	if err := fs.TouchFile("../foo/x"); err != nil {
+		t.Errorf("FileSystem.TouchFile(../foo/x) = %v", err)
+	}
+	if err := fs.UpdateTimes("./x", time.Unix(1, 0), time.Unix(1, 0)); err != nil {
+		t.Errorf("FileSystem.UpdateTimes(./x) = %v", err)
+	}
+}
+
+func TestFileSystem_DotsConcurrentChangeDir(t *testing.T) {
+	fs, err := createTestFS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Relative paths are resolved against the current dir, so this is a race without mu (-race).
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			if err := fs.ChangeDir("/bar/foo/.."); err != nil {
+				t.Errorf("FileSystem.ChangeDir() = %v", err)
+			}
+			runtime.Gosched()
+			if err := fs.ChangeDir("./foo"); err != nil {
+				t.Errorf("FileSystem.ChangeDir() = %v", err)
+			}
+			runtime.Gosched()
+		}
+	}()
+	ops := []func(){
+		func() { fs.MakeDir("../d/") },
+		func() { fs.NewFile("./f") },
+		func() { fs.Write("../f", bytes.NewBufferString("x")) },
+		func() { fs.Read("./f", ioutil.Discard) },
+		func() { fs.Remove("../d/") },
+		func() { fs.Remove("x") },
+		func() { fs.FindFirstRegex("x", "y") },
+		func() { fs.DeletePrefix("x", true) },
+	}
+	for i := 0; i < 100; i++ {
+		for _, op := range ops {
+			op()
+			runtime.Gosched()
+		}
+	}
+	<-done
+}
+
+func TestFileSystem_ApplyManifest(t *testing.T) {
+	fs, err := createTestFS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := func(s string) []byte {
+		d, _ := FileDigest(strings.NewReader(s))
+		return d
+	}
+	m := Manifest{
+		Dirs: []string{"foo", "new/empty"},
+		Files: []ManifestFile{
+			{Path: "file1", SHA256: sum("foobar")},
+			{Path: "file2", Content: []byte("updated")},
+			{Path: "new/copy", Source: "/bar/file1"},
+			{Path: "new/text", Content: []byte("text"), SHA256: sum("text")},
+		},
+	}
+	// Nothing changes in a dry run or when the manifest is invalid.
+	dry, err := fs.ApplyManifest("/bar", m, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, bad := range []Manifest{
+		{Files: []ManifestFile{{Path: "x", Content: []byte("x"), SHA256: sum("y")}}},
+		{Files: []ManifestFile{{Path: "missing", SHA256: sum("y")}}},
+		{Dirs: []string{"../escape"}},
+		{Dirs: []string{"x/y"}, Files: []ManifestFile{{Path: "x"}}},
+	} {
+		if _, err := fs.ApplyManifest("/bar", bad, false); err == nil {
+			t.Errorf("ApplyManifest(%+v) succeeded", bad)
+		}
+	}
+	if _, _, err := fs.Stat("/bar/foo2/"); err != nil {
+		t.Errorf("ApplyManifest() changed the dir in a dry run or failure: %v", err)
+	}
+
+	got, err := fs.ApplyManifest("/bar", m, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := ManifestResult{
+		Created: []string{"/bar/new", "/bar/new/copy", "/bar/new/empty", "/bar/new/text"},
+		Updated: []string{"/bar/file2"},
+		Removed: []string{"/bar/foo2", "/bar/file3"},
+	}
+	if !reflect.DeepEqual(got, want) || !reflect.DeepEqual(dry, want) {
+		t.Errorf("ApplyManifest() = %+v, dry run %+v, want %+v", got, dry, want)
+	}
+	for p, content := range map[string]string{"/bar/file1": "foobar", "/bar/file2": "updated", "/bar/new/copy": "foobar", "/bar/new/text": "text"} {
+		buf := bytes.NewBuffer(nil)
+		if _, err := fs.Read(p, buf); err != nil || buf.String() != content {
+			t.Errorf("Read(%s) = %q, %v, want %q", p, buf.String(), err, content)
+		}
+	}
+
+	// Applying it again changes nothing.
+	if got, err := fs.ApplyManifest("/bar", m, false); err != nil || !reflect.DeepEqual(got, ManifestResult{}) {
+		t.Errorf("ApplyManifest() again = %+v, %v, want no changes", got, err)
+	}
+}
+
+// pickyStore is a blob.Store failing to store content with "unstorable" in it.
+type pickyStore struct {
+	*blob.Memory
+}
+
+func (s pickyStore) Put(key string, reader io.Reader) (int64, error) {
+	content, err := io.ReadAll(reader)
+	if err != nil {
+		return 0, err
+	}
+	if bytes.Contains(content, []byte("unstorable")) {
+		return 0, errors.New("store is down")
+	}
+	return s.Memory.Put(key, bytes.NewReader(content))
+}
+
+func TestFileSystem_ApplyManifestFails(t *testing.T) {
+	store := pickyStore{blob.NewMemory()}
+	fs := NewWithOpts(Opts{ContentStore: NewBlobContentStore(store, "")})
+	if err := fs.MakeDirAll("/app/stale"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.ChangeDir("/app"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.NewFile("z"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.Write("z", strings.NewReader("old")); err != nil {
+		t.Fatal(err)
+	}
+	// stored returns the number of blobs in store.
+	stored := func() int {
+		n := 0
+		for id := uint64(1); id <= atomic.LoadUint64(&fs.lastID); id++ {
+			if _, err := store.Get(strconv.FormatUint(id, 10), ioutil.Discard); err == nil {
+				n++
+			}
+		}
+		return n
+	}
+	m := Manifest{Files: []ManifestFile{
+		{Path: "a", Content: []byte("stored")},
+		{Path: "b/c", Content: []byte("stored")},
+		{Path: "z", Content: []byte("unstorable")},
+	}}
+	// Nothing changes, and what was staged is deleted.
+	got, err := fs.ApplyManifest("/app", m, false)
+	if err == nil || !reflect.DeepEqual(got, ManifestResult{}) {
+		t.Errorf("ApplyManifest() = %+v, %v, want an error", got, err)
+	}
+	for _, p := range []string{"/app/a", "/app/b"} {
+		if _, _, err := fs.Stat(p); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Stat(%s) = %v, want ErrNotFound", p, err)
+		}
+	}
+	if _, _, err := fs.Stat("/app/stale"); err != nil {
+		t.Errorf("Stat(/app/stale) = %v", err)
+	}
+	buf := bytes.NewBuffer(nil)
+	if _, err := fs.Read("/app/z", buf); err != nil || buf.String() != "old" {
+		t.Errorf("Read(/app/z) = %q, %v, want old", buf.String(), err)
+	}
+	if n := stored(); n != 1 {
+		t.Errorf("ApplyManifest() left %d blobs stored, want 1", n)
+	}
+
+	// Applying it again once content can be stored makes all of it, and deletes the content the
+	// update replaced.
+	m.Files[2].Content = []byte("stored")
+	got, err = fs.ApplyManifest("/app", m, false)
+	want := ManifestResult{Created: []string{"/app/a", "/app/b", "/app/b/c"}, Updated: []string{"/app/z"}, Removed: []string{"/app/stale"}}
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("ApplyManifest() again = %+v, %v, want %+v", got, err, want)
+	}
+	for _, p := range []string{"/app/a", "/app/b/c", "/app/z"} {
+		buf := bytes.NewBuffer(nil)
+		if _, err := fs.Read(p, buf); err != nil || buf.String() != "stored" {
+			t.Errorf("Read(%s) = %q, %v, want stored", p, buf.String(), err)
+		}
+	}
+	if n := stored(); n != 3 {
+		t.Errorf("ApplyManifest() left %d blobs stored, want 3", n)
+	}
+}
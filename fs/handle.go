@@ -0,0 +1,109 @@
+package fs
+
+import (
+	"fmt"
+	"io"
+	"sync/atomic"
+)
+
+// OpenPolicy decides what happens when an open file is removed or moved.
+type OpenPolicy int
+
+const (
+	// OpenBusy fails removing/moving open files with ErrBusy.
+	OpenBusy OpenPolicy = iota
+
+	// OpenDeferRemove removes open files from the namespace right away, but keeps their content
+	// until the last handle is closed. Open files can be moved.
+	OpenDeferRemove
+)
+
+// Handle is an open file. Removing/moving the file while it's open is subject to the filesystem's
+// OpenPolicy. It must be closed once done.
+type Handle struct {
+	file   *File
+	closed int32
+}
+
+// Open opens the file s (relative/abs).
+func (fs *FileSystem) Open(s string) (_ *Handle, err error) {
+	defer wrapPathError(&err, "open", s)
+	return fs.open(s)
+}
+
+func (fs *FileSystem) open(s string) (*Handle, error) {
+	if _, _, ok := fs.mounted(s); ok {
+		return nil, fmt.Errorf("opening mounted files: %w", ErrNotSupported)
+	}
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	node := fs.findNode(s)
+	if node == nil {
+		return nil, ErrNotFound
+	}
+	file, ok := node.Meta().(*File)
+	if !ok {
+		return nil, fmt.Errorf("cannot open directories")
+	}
+	file.opens++
+	return &Handle{file: file}, nil
+}
+
+// File returns the opened file.
+func (h *Handle) File() *File {
+	return h.file
+}
+
+// Read reads the file content as a stream and returns the number of bytes read.
+func (h *Handle) Read(writer io.Writer) (int64, error) {
+	return h.file.Read(writer)
+}
+
+// ReadAt reads at a particular offset of the file. Returns number of bytes read.
+func (h *Handle) ReadAt(writer io.Writer, offset int) (int64, error) {
+	return h.file.ReadAt(writer, offset)
+}
+
+// Write appends to the file's content as a stream until io.EOF is encountered.
+func (h *Handle) Write(reader io.Reader) (int64, error) {
+	n, err := h.file.Write(reader)
+	if n > 0 || err == nil {
+		h.file.md.fs.account(h.file)
+	}
+	return n, err
+}
+
+// Close releases the handle. The content of a removed file is discarded once its last handle is
+// closed. Closing more than once is a no-op.
+func (h *Handle) Close() error {
+	if !atomic.CompareAndSwapInt32(&h.closed, 0, 1) {
+		return nil
+	}
+	fs := h.file.md.fs
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	h.file.opens--
+	if h.file.opens == 0 && h.file.removed {
+		h.file.discard()
+	}
+	return nil
+}
+
+// busy returns ErrBusy if removing/moving the file isn't allowed because it's open. Must be called
+// with mu held.
+func (fs *FileSystem) busy(file *File) error {
+	if file.opens > 0 && fs.openPolicy == OpenBusy {
+		return fmt.Errorf("%d open handles: %w", file.opens, ErrBusy)
+	}
+	return nil
+}
+
+// release returns true if the content of a removed file can be discarded, and otherwise leaves it
+// to its last handle. Must be called with mu held.
+func (fs *FileSystem) release(file *File) bool {
+	if file.opens > 0 {
+		file.removed = true
+		return false
+	}
+	return true
+}
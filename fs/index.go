@@ -0,0 +1,102 @@
+package fs
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/basharal/filesystem/fspath"
+	"github.com/basharal/filesystem/trie"
+)
+
+// nameIndex maps the names of files/dirs to them, so that Find looks them up rather than walking
+// subtrees. It's kept up to date by attach/detach and when dirs are loaded from the MetaStore.
+type nameIndex struct {
+	// mu protects entries. The index is also updated while loading dirs, which only holds the
+	// filesystem's mu for reading.
+	mu sync.Mutex
+	// entries are keyed by lower-cased name, since Find ignores case.
+	entries map[string]map[*Metadata]struct{}
+}
+
+func newNameIndex() *nameIndex {
+	return &nameIndex{entries: make(map[string]map[*Metadata]struct{})}
+}
+
+func (ix *nameIndex) add(name string, md *Metadata) {
+	key := strings.ToLower(name)
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	set, ok := ix.entries[key]
+	if !ok {
+		set = make(map[*Metadata]struct{}, 1)
+		ix.entries[key] = set
+	}
+	set[md] = struct{}{}
+}
+
+func (ix *nameIndex) remove(name string, md *Metadata) {
+	key := strings.ToLower(name)
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	set := ix.entries[key]
+	delete(set, md)
+	if len(set) == 0 {
+		delete(ix.entries, key)
+	}
+}
+
+// lookup returns the files/dirs that may be named name.
+func (ix *nameIndex) lookup(name string) []*Metadata {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	set := ix.entries[strings.ToLower(name)]
+	mds := make([]*Metadata, 0, len(set))
+	for md := range set {
+		mds = append(mds, md)
+	}
+	return mds
+}
+
+// indexAdd adds the file/dir at the absolute path p to the index, if there's one.
+func (fs *FileSystem) indexAdd(p string, meta interface{}) {
+	if md := metadataOf(meta); fs.index != nil && md != nil && p != fspath.Root {
+		fs.index.add(fspath.Base(p), md)
+	}
+}
+
+// indexRemove undoes indexAdd.
+func (fs *FileSystem) indexRemove(p string, meta interface{}) {
+	if md := metadataOf(meta); fs.index != nil && md != nil && p != fspath.Root {
+		fs.index.remove(fspath.Base(p), md)
+	}
+}
+
+func metadataOf(meta interface{}) *Metadata {
+	switch meta := meta.(type) {
+	case *File:
+		return &meta.md
+	case *Dir:
+		return &meta.md
+	}
+	return nil
+}
+
+// findIndexed is FindFunc with the index, for the dir at n. Must be called with mu held, for
+// reading at least, and with everything under n loaded.
+func (fs *FileSystem) findIndexed(n *trie.Node, search string, fn func(*trie.Node) bool) {
+	prefix := n.Path()
+	for _, md := range fs.index.lookup(search) {
+		node := md.node
+		path := node.Path()
+		if path == prefix || !strings.HasPrefix(path, prefix) || !strings.EqualFold(search, node.Name()) {
+			continue
+		}
+		// Entries under moved dirs are dropped from the trie without being detached.
+		if found, ok := fs.trie.Find(path); !ok || found != node {
+			continue
+		}
+		if !fn(node) {
+			return
+		}
+	}
+}
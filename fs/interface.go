@@ -0,0 +1,135 @@
+package fs
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/basharal/filesystem/blob"
+)
+
+// Interface is what consumers (the server and the CLIs) need from a filesystem, so that other
+// implementations (i.e., OS-backed, remote-backed or read-only ones) can be dropped in without
+// touching them. FileSystem implements it. Implementations that can't support an operation return
+// ErrNotSupported for it.
+type Interface interface {
+	CurrentDir() string
+	ChangeDir(path string) error
+
+	ListDir(path string) ([]*File, []*Dir, error)
+	Stat(path string) (*File, *Dir, error)
+	Find(path, search string) ([]*File, []*Dir, error)
+	FindFirstRegex(path, regex string) (string, error)
+	FindRegex(path, regex string, max int) (RegexResult, error)
+
+	MakeDir(path string) error
+	NewFile(path string) error
+	TouchFile(path string) error
+	Remove(path string) error
+	DeletePrefix(path string, dryRun bool) (int, error)
+	Move(src, dst string) error
+	Copy(src, dst string) error
+
+	Read(path string, writer io.Writer) (int64, error)
+	Write(path string, reader io.Reader) (int64, error)
+
+	Limits() Limits
+}
+
+// The interfaces below are optional features of implementations. Consumers check for them with
+// type assertions.
+
+// Watcher delivers the events of a filesystem. See FileSystem.Watch.
+type Watcher interface {
+	Watch(size int) (<-chan Event, func())
+}
+
+// Iterator streams listings and searches to a callback instead of returning them. See
+// FileSystem.ListDirFunc.
+type Iterator interface {
+	ListDirFunc(path string, fn func(*File, *Dir) bool) error
+	FindFunc(path, search string, fn func(*File, *Dir) bool) error
+}
+
+// Mounter mounts backends into a filesystem. See FileSystem.Mount.
+type Mounter interface {
+	Mount(path string, backend Backend) error
+	Unmount(path string) error
+}
+
+// Retainer enforces retention policies. See FileSystem.SetRetention.
+type Retainer interface {
+	SetRetention(path string, policy RetentionPolicy) error
+	Retention() map[string]RetentionPolicy
+	ApplyRetention(now time.Time) ([]string, error)
+}
+
+// Archiver moves cold files to a blob store. See FileSystem.Archive.
+type Archiver interface {
+	Archive(store blob.Store, rules []LifecycleRule, now time.Time) ([]string, error)
+}
+
+// Loader loads a local dir into a filesystem. See FileSystem.LoadFromOS.
+type Loader interface {
+	LoadFromOS(dir string, opts LoadOpts) error
+}
+
+// Snapshotter writes snapshots of a filesystem and restores them. See FileSystem.Snapshot.
+type Snapshotter interface {
+	Snapshot(w io.Writer) error
+	SnapshotSince(w io.Writer, since time.Time) error
+	RestoreSnapshot(r io.Reader, opts RestoreOpts) (int, error)
+	RestoreSnapshots(layers []SnapshotLayer, opts RestoreOpts) (int, error)
+}
+
+// Digester computes Merkle digests of subtrees. See FileSystem.Digest.
+type Digester interface {
+	Digest(path string) ([]byte, []EntryDigest, error)
+}
+
+// Syncer persists a filesystem. See FileSystem.Sync.
+type Syncer interface {
+	Sync() error
+}
+
+// Mirrorer keeps a local dir in sync with a filesystem. See FileSystem.Mirror.
+type Mirrorer interface {
+	Mirror(ctx context.Context, dir string, opts MirrorOpts) error
+}
+
+// Creator creates files with options (i.e., along with their parents). See FileSystem.CreateFile.
+type Creator interface {
+	CreateFile(path string, opts CreateOpts) error
+}
+
+// DirMaker makes dirs along with their missing parents. See FileSystem.MakeDirAll.
+type DirMaker interface {
+	MakeDirAll(path string) error
+}
+
+// ManifestApplier converges dirs to manifests. See FileSystem.ApplyManifest.
+type ManifestApplier interface {
+	ApplyManifest(path string, m Manifest, dryRun bool) (ManifestResult, error)
+}
+
+// Preallocator makes room for content about to be appended. See FileSystem.Preallocate.
+type Preallocator interface {
+	Preallocate(path string, size int64) error
+}
+
+var (
+	_ Interface       = (*FileSystem)(nil)
+	_ Watcher         = (*FileSystem)(nil)
+	_ Mounter         = (*FileSystem)(nil)
+	_ Retainer        = (*FileSystem)(nil)
+	_ Archiver        = (*FileSystem)(nil)
+	_ Loader          = (*FileSystem)(nil)
+	_ Mirrorer        = (*FileSystem)(nil)
+	_ Syncer          = (*FileSystem)(nil)
+	_ Snapshotter     = (*FileSystem)(nil)
+	_ Digester        = (*FileSystem)(nil)
+	_ Preallocator    = (*FileSystem)(nil)
+	_ Creator         = (*FileSystem)(nil)
+	_ DirMaker        = (*FileSystem)(nil)
+	_ ManifestApplier = (*FileSystem)(nil)
+)
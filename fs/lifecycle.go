@@ -0,0 +1,70 @@
+package fs
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/basharal/filesystem/blob"
+	"github.com/basharal/filesystem/fspath"
+	"github.com/basharal/filesystem/trie"
+	"github.com/golang/glog"
+)
+
+// LifecycleRule archives files under Prefix that haven't been read or written for ColdAfter.
+type LifecycleRule struct {
+	// Prefix is an absolute path. Files under it match (i.e., "/" matches all files).
+	Prefix    string
+	ColdAfter time.Duration
+}
+
+// Archive moves the content of cold files matching any of the rules to store, keeping only their
+// metadata in memory. Archived files are brought back into memory on their next read/write. It
+// returns the absolute paths of the archived files.
+func (fs *FileSystem) Archive(store blob.Store, rules []LifecycleRule, now time.Time) ([]string, error) {
+	if fs.content != nil {
+		return nil, fmt.Errorf("archiving isn't supported with a content store: %w", ErrNotSupported)
+	}
+	for _, rule := range rules {
+		if !fspath.IsAbs(rule.Prefix) || rule.ColdAfter <= 0 {
+			return nil, fmt.Errorf("invalid lifecycle rule %+v", rule)
+		}
+	}
+
+	// Collect candidates under the lock, but archive without holding it since it's slow.
+	fs.mu.RLock()
+	files := make([]*File, 0)
+	fs.walkFiles(fs.root.md.node, func(f *File) {
+		if f.offloaded() {
+			return
+		}
+		path := f.Path()
+		for _, rule := range rules {
+			if fspath.HasPrefix(path, rule.Prefix) && now.Sub(f.AccessTime()) > rule.ColdAfter {
+				files = append(files, f)
+				return
+			}
+		}
+	})
+	fs.mu.RUnlock()
+
+	archived := make([]string, 0, len(files))
+	for _, f := range files {
+		if err := f.archiveTo(store); err != nil {
+			glog.Errorf("Failed to archive %s. %s\n", f.Path(), err)
+			continue
+		}
+		archived = append(archived, f.Path())
+	}
+	return archived, nil
+}
+
+// walkFiles calls fn for every file under n recursively. Must be called with mu held.
+func (fs *FileSystem) walkFiles(n *trie.Node, fn func(*File)) {
+	fs.loadTree(n)
+	fs.trie.WalkAtNode(n, true, func(c *trie.Node) bool {
+		if f, ok := c.Meta().(*File); ok {
+			fn(f)
+		}
+		return true
+	})
+}
@@ -0,0 +1,126 @@
+package fs
+
+import (
+	"io"
+	"regexp"
+	"time"
+
+	"github.com/basharal/filesystem/trie"
+)
+
+// Limits bound how long operations can take on a shared filesystem. Zero values mean no limit.
+type Limits struct {
+	// MaxReadDuration and MaxWriteDuration bound streaming reads/writes. They're checked between
+	// chunks, so a stream that's stuck in a single read/write is only failed once it returns.
+	MaxReadDuration  time.Duration
+	MaxWriteDuration time.Duration
+
+	// MaxRegexDuration bounds the subtree walk of regex searches, which hold the filesystem lock.
+	MaxRegexDuration time.Duration
+
+	// MaxRegexNodes bounds the number of dirs/files a regex search can match against.
+	MaxRegexNodes int
+}
+
+// deadlineReader fails with ErrTimeout once deadline passes.
+type deadlineReader struct {
+	r        io.Reader
+	deadline time.Time
+}
+
+func (dr *deadlineReader) Read(p []byte) (int, error) {
+	if time.Now().After(dr.deadline) {
+		return 0, ErrTimeout
+	}
+	return dr.r.Read(p)
+}
+
+// deadlineWriter fails with ErrTimeout once deadline passes.
+type deadlineWriter struct {
+	w        io.Writer
+	deadline time.Time
+}
+
+func (dw *deadlineWriter) Write(p []byte) (int, error) {
+	if time.Now().After(dw.deadline) {
+		return 0, ErrTimeout
+	}
+	return dw.w.Write(p)
+}
+
+// ReadFrom keeps the fast path of writers that read content themselves (see io.ReaderFrom),
+// checking the deadline between their reads instead.
+func (dw *deadlineWriter) ReadFrom(r io.Reader) (int64, error) {
+	rf, ok := dw.w.(io.ReaderFrom)
+	if !ok {
+		return io.Copy(struct{ io.Writer }{dw}, r)
+	}
+	return rf.ReadFrom(&deadlineReader{r: r, deadline: dw.deadline})
+}
+
+func (fs *FileSystem) limitReader(r io.Reader) io.Reader {
+	if fs.limits.MaxWriteDuration <= 0 {
+		return r
+	}
+	return &deadlineReader{r: r, deadline: time.Now().Add(fs.limits.MaxWriteDuration)}
+}
+
+func (fs *FileSystem) limitWriter(w io.Writer) io.Writer {
+	if fs.limits.MaxReadDuration <= 0 {
+		return w
+	}
+	return &deadlineWriter{w: w, deadline: time.Now().Add(fs.limits.MaxReadDuration)}
+}
+
+// findRegex walks the subtree at n for up to max matches of regex. Must be called with mu held.
+func (fs *FileSystem) findRegex(n *trie.Node, regex string, max int) (RegexResult, error) {
+	re, err := regexp.Compile(regex)
+	if err != nil {
+		return RegexResult{}, err
+	}
+	w := &regexWalk{re: re, max: max}
+	if fs.limits.MaxRegexDuration > 0 {
+		w.deadline = time.Now().Add(fs.limits.MaxRegexDuration)
+	}
+	err = fs.walkRegex(w, n)
+	return w.result, err
+}
+
+// regexWalk is the state of a subtree walk for FindRegex.
+type regexWalk struct {
+	re       *regexp.Regexp
+	max      int
+	deadline time.Time
+	result   RegexResult
+}
+
+// walkRegex walks the subtree at n depth-first, collecting paths matching the regex until w.max
+// are found. Must be called with mu held.
+func (fs *FileSystem) walkRegex(w *regexWalk, n *trie.Node) error {
+	_, nodes, err := fs.listAtNode(n)
+	if err != nil {
+		return err
+	}
+	for _, child := range nodes {
+		if !w.deadline.IsZero() && time.Now().After(w.deadline) {
+			return ErrTimeout
+		}
+		if fs.limits.MaxRegexNodes > 0 && w.result.Visited >= fs.limits.MaxRegexNodes {
+			return ErrLimitExceeded
+		}
+		w.result.Visited++
+		if w.re.MatchString(child.Path()) {
+			w.result.Paths = append(w.result.Paths, child.Path())
+			if len(w.result.Paths) >= w.max {
+				return nil
+			}
+		}
+		if _, ok := child.Meta().(*Dir); !ok {
+			continue
+		}
+		if err := fs.walkRegex(w, child); err != nil || len(w.result.Paths) >= w.max {
+			return err
+		}
+	}
+	return nil
+}
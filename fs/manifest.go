@@ -0,0 +1,377 @@
+package fs
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+	"sync/atomic"
+
+	"github.com/basharal/filesystem/fspath"
+	"github.com/basharal/filesystem/trie"
+	"github.com/golang/glog"
+)
+
+// Manifest is the desired content of a dir, which ApplyManifest converges the dir to. Paths are
+// relative to the dir, and the dirs above the listed files/dirs are implied.
+type Manifest struct {
+	Dirs  []string
+	Files []ManifestFile
+}
+
+// ManifestFile is a file of a Manifest. Its content is that of the file at Source (absolute) when
+// it's set, and Content otherwise. SHA256 is the digest of the content (see FileDigest), which must
+// match when given. A file with a digest but neither Content nor Source must already have that
+// content, so that manifests don't need to carry the content of unchanged files.
+type ManifestFile struct {
+	Path    string
+	Content []byte
+	Source  string
+	SHA256  []byte
+}
+
+// ManifestResult lists the absolute paths ApplyManifest created, updated (i.e., replaced the
+// content of) and removed.
+type ManifestResult struct {
+	Created []string
+	Updated []string
+	Removed []string
+}
+
+// manifestPlan is what ApplyManifest changes. The new content is staged first, then nodes are
+// removed in order (children first), entries are created in order (parents first) and files
+// updated.
+type manifestPlan struct {
+	remove []*trie.Node
+	create []manifestEntry
+	update []manifestEntry
+	result ManifestResult
+}
+
+// manifestEntry is a file/dir to create, or a file to update. Dirs have no file.
+type manifestEntry struct {
+	path    string
+	file    *File
+	isDir   bool
+	content []byte
+	// staged is the ID content was put in the ContentStore under, if any, and size its size.
+	staged uint64
+	size   int64
+}
+
+// ApplyManifest converges the dir at s (relative/absolute) to m: missing files/dirs are created,
+// files whose content differs are replaced and everything else under s is removed. The whole
+// manifest is checked and the content of sources is read before anything changes, and the changes
+// are made under one lock, so that others don't see them halfway. It's atomic: the new content is
+// put in the ContentStore before the tree changes, and nothing changes if that fails. Files whose
+// content is replaced get a new ID then, like files renamed over. With dryRun, nothing changes and
+// the result is what would.
+func (fs *FileSystem) ApplyManifest(s string, m Manifest, dryRun bool) (_ ManifestResult, err error) {
+	defer wrapPathError(&err, "apply", s)
+	if _, _, ok := fs.mounted(s); ok {
+		return ManifestResult{}, fmt.Errorf("applying manifests to mounted paths: %w", ErrNotSupported)
+	}
+	s = fs.absPath(s)
+	fs.mu.Lock()
+	plan, err := fs.planManifest(s, m)
+	if err != nil {
+		fs.mu.Unlock()
+		return ManifestResult{}, err
+	}
+	if dryRun {
+		fs.mu.Unlock()
+		return plan.result, nil
+	}
+	discarded, stale, err := fs.applyPlan(plan)
+	fs.mu.Unlock()
+	// Like DeletePrefix, content is discarded without holding mu.
+	fs.parallel(len(discarded), func(i int) error {
+		discarded[i].discard()
+		return nil
+	})
+	fs.deleteContent(stale)
+	if err != nil {
+		return ManifestResult{}, err
+	}
+	return plan.result, nil
+}
+
+// planManifest compares the dir at the absolute path s with m. Must be called with mu held.
+func (fs *FileSystem) planManifest(s string, m Manifest) (manifestPlan, error) {
+	var plan manifestPlan
+	node := fs.findNode(fs.normalizeDirPath(s))
+	if node == nil {
+		if fs.findNode(s) != nil {
+			return plan, fmt.Errorf("%s isn't a dir: %w", s, ErrInvalidName)
+		}
+		return plan, ErrNotFound
+	}
+	root := nodePath(node)
+	for mp := range fs.mounts {
+		if fspath.HasPrefix(mp, root) {
+			return plan, fmt.Errorf("%s is mounted: %w", mp, ErrNotSupported)
+		}
+	}
+	want, err := manifestEntries(root, m)
+	if err != nil {
+		return plan, err
+	}
+	existing, err := fs.collect(node, nil)
+	if err != nil {
+		return plan, err
+	}
+
+	kept := make(map[string]bool, len(existing))
+	current := fs.currentDir.md.AbsolutePath()
+	for _, e := range existing {
+		f, ok := want[e.path]
+		if ok && (f == nil) == (e.dir != nil) {
+			kept[e.path] = true
+			continue
+		}
+		if e.dir != nil && fspath.HasPrefix(current, e.path) {
+			return plan, fmt.Errorf("current directory is under %s: %w", e.path, ErrNotSupported)
+		}
+		if e.file != nil {
+			if err := fs.busy(e.file); err != nil {
+				return plan, &PathError{Op: "apply", Path: e.path, Err: err}
+			}
+		}
+		if e.dir != nil {
+			plan.remove = append(plan.remove, e.dir.md.node)
+		} else {
+			plan.remove = append(plan.remove, e.file.md.node)
+		}
+	}
+	// collect lists parents before their children.
+	for i, j := 0, len(plan.remove)-1; i < j; i, j = i+1, j-1 {
+		plan.remove[i], plan.remove[j] = plan.remove[j], plan.remove[i]
+	}
+	for _, n := range plan.remove {
+		plan.result.Removed = append(plan.result.Removed, nodePath(n))
+	}
+
+	paths := make([]string, 0, len(want))
+	for p := range want {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	for _, p := range paths {
+		f := want[p]
+		var file *File
+		if kept[p] && f != nil {
+			file = fs.findNode(p).Meta().(*File)
+		}
+		if f == nil {
+			if !kept[p] {
+				plan.create = append(plan.create, manifestEntry{path: p, isDir: true})
+				plan.result.Created = append(plan.result.Created, p)
+			}
+			continue
+		}
+		content, changed, err := fs.manifestContent(p, f, file)
+		if err != nil {
+			return plan, err
+		}
+		switch {
+		case file == nil:
+			plan.create = append(plan.create, manifestEntry{path: p, content: content})
+			plan.result.Created = append(plan.result.Created, p)
+		case changed:
+			plan.update = append(plan.update, manifestEntry{path: p, file: file, content: content})
+			plan.result.Updated = append(plan.result.Updated, p)
+		}
+	}
+	return plan, nil
+}
+
+// manifestEntries returns the files/dirs m lists under the absolute path root by absolute path,
+// with nil for dirs.
+func manifestEntries(root string, m Manifest) (map[string]*ManifestFile, error) {
+	want := make(map[string]*ManifestFile)
+	add := func(rel string, f *ManifestFile) error {
+		clean := fspath.Clean(rel)
+		if clean == "" || clean == "." || clean == ".." || IsAbs(clean) || strings.HasPrefix(clean, "../") {
+			return fmt.Errorf("manifest path %q must be relative and inside the dir: %w", rel, ErrInvalidName)
+		}
+		p := fspath.Join(root, clean)
+		if prev, ok := want[p]; ok && (prev != nil || f != nil) {
+			return fmt.Errorf("%s is in the manifest twice: %w", p, ErrInvalidName)
+		}
+		want[p] = f
+		for dir := fspath.Dir(p); dir != root; dir = fspath.Dir(dir) {
+			if f, ok := want[dir]; ok && f != nil {
+				return fmt.Errorf("%s is a file in the manifest and a dir above %s: %w", dir, p, ErrInvalidName)
+			}
+			want[dir] = nil
+		}
+		return nil
+	}
+	for _, dir := range m.Dirs {
+		if err := add(dir, nil); err != nil {
+			return nil, err
+		}
+	}
+	for i := range m.Files {
+		if err := add(m.Files[i].Path, &m.Files[i]); err != nil {
+			return nil, err
+		}
+	}
+	return want, nil
+}
+
+// manifestContent returns the content of f at the absolute path p and whether it differs from that
+// of existing, which is nil if p isn't a file yet. Must be called with mu held.
+func (fs *FileSystem) manifestContent(p string, f *ManifestFile, existing *File) ([]byte, bool, error) {
+	content := f.Content
+	if f.Source != "" {
+		node := fs.findNode(f.Source)
+		if node == nil {
+			return nil, false, &PathError{Op: "apply", Path: f.Source, Err: ErrNotFound}
+		}
+		source, ok := node.Meta().(*File)
+		if !ok {
+			return nil, false, fmt.Errorf("source %s of %s isn't a file: %w", f.Source, p, ErrInvalidName)
+		}
+		var buf bytes.Buffer
+		if _, err := source.read(&buf, false); err != nil {
+			return nil, false, err
+		}
+		content = buf.Bytes()
+	}
+	sum, err := FileDigest(bytes.NewReader(content))
+	if err != nil {
+		return nil, false, err
+	}
+	if len(f.SHA256) > 0 && !bytes.Equal(sum, f.SHA256) {
+		if f.Source != "" || len(f.Content) > 0 {
+			return nil, false, fmt.Errorf("content of %s doesn't match its digest: %w", p, ErrConflict)
+		}
+		// Only the digest is given, which the existing file must have.
+		if existing == nil {
+			return nil, false, &PathError{Op: "apply", Path: p, Err: ErrNotFound}
+		}
+		sum = f.SHA256
+		content = nil
+	}
+	if existing == nil {
+		return content, true, nil
+	}
+	existingSum, err := existing.digest()
+	if err != nil {
+		return nil, false, err
+	}
+	if content == nil && !bytes.Equal(existingSum, sum) {
+		return nil, false, fmt.Errorf("%s doesn't have the digest of the manifest, which has no content for it: %w", p, ErrConflict)
+	}
+	return content, !bytes.Equal(existingSum, sum), nil
+}
+
+// applyPlan makes the changes of plan, or none of them if it fails. It returns the removed files
+// whose content must be discarded and the IDs of content that must be deleted (i.e., replaced or
+// staged for nothing). Must be called with mu held.
+func (fs *FileSystem) applyPlan(plan manifestPlan) ([]*File, []uint64, error) {
+	if err := fs.stage(plan.create); err != nil {
+		return nil, staged(plan.create), err
+	}
+	if err := fs.stage(plan.update); err != nil {
+		return nil, append(staged(plan.create), staged(plan.update)...), err
+	}
+	discarded := make([]*File, 0)
+	for _, n := range plan.remove {
+		if file := fs.unlinkNode(n); file != nil {
+			discarded = append(discarded, file)
+		}
+	}
+	created := make([]*trie.Node, 0, len(plan.create))
+	for _, e := range plan.create {
+		if err := fs.createLocked(e.path, e.isDir); err != nil {
+			// The plan was checked, so this only happens if it's wrong. The removals can't be
+			// undone, but what was created is. Its content is the staged content.
+			for i := len(created) - 1; i >= 0; i-- {
+				fs.unlinkNode(created[i])
+			}
+			return discarded, append(staged(plan.create), staged(plan.update)...), &PathError{Op: "apply", Path: e.path, Err: err}
+		}
+		p := e.path
+		if e.isDir {
+			p = fs.normalizeDirPath(p)
+		}
+		node := fs.findNode(p)
+		created = append(created, node)
+		if !e.isDir {
+			e.file = node.Meta().(*File)
+			fs.setContent(e)
+		}
+	}
+	var stale []uint64
+	for _, e := range plan.update {
+		if id := fs.setContent(e); id != 0 {
+			stale = append(stale, id)
+		}
+	}
+	return discarded, stale, nil
+}
+
+// stage puts the content of the files of entries in the ContentStore, if there's one, under new
+// IDs. If it fails, the content staged so far is left for the caller to delete.
+func (fs *FileSystem) stage(entries []manifestEntry) error {
+	if fs.content == nil {
+		return nil
+	}
+	for i := range entries {
+		e := &entries[i]
+		if e.isDir {
+			continue
+		}
+		id := atomic.AddUint64(&fs.lastID, 1)
+		n, err := fs.content.Put(id, bytes.NewReader(e.content))
+		if err != nil {
+			// The store may have kept part of it.
+			e.staged = id
+			return &PathError{Op: "apply", Path: e.path, Err: err}
+		}
+		e.staged, e.size = id, n
+	}
+	return nil
+}
+
+// staged returns the IDs content was staged under for entries.
+func staged(entries []manifestEntry) []uint64 {
+	var ids []uint64
+	for _, e := range entries {
+		if e.staged != 0 {
+			ids = append(ids, e.staged)
+		}
+	}
+	return ids
+}
+
+// deleteContent deletes the content stored under ids in the ContentStore.
+func (fs *FileSystem) deleteContent(ids []uint64) {
+	fs.parallel(len(ids), func(i int) error {
+		if err := fs.content.Delete(ids[i]); err != nil {
+			glog.Warningf("Failed to delete content. %s\n", err)
+		}
+		return nil
+	})
+}
+
+// setContent gives the file of e its new content, which can't fail: content staged in the
+// ContentStore becomes the file's by ID, and other content is kept in memory. It returns the ID
+// the file's content was stored under before, if any. Must be called with mu held.
+func (fs *FileSystem) setContent(e manifestEntry) uint64 {
+	var stale uint64
+	if e.staged != 0 {
+		stale = e.file.swapContent(e.staged, e.size)
+	} else {
+		if len(e.content) == 0 && e.file.Size() == 0 {
+			return 0
+		}
+		// Replacing content in memory doesn't fail.
+		e.file.replace(bytes.NewReader(e.content))
+	}
+	fs.accountLocked(e.file)
+	fs.publish(EventWrite, e.path, false)
+	return stale
+}
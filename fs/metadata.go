@@ -2,8 +2,11 @@ package fs
 
 import (
 	"strings"
+	"sync/atomic"
+	"time"
 
-	"github.com/basharal/trie"
+	"github.com/basharal/filesystem/fspath"
+	"github.com/basharal/filesystem/trie"
 	"github.com/golang/glog"
 )
 
@@ -15,20 +18,46 @@ var (
 	dirType  NodeType = 2
 )
 
-// Metadata provides common metadata for files and directories.
+// Metadata provides common metadata for files and directories. It's embedded in them rather than
+// pointed to, and kept small, since there's one per entry of the namespace.
 type Metadata struct {
 	fs *FileSystem
-	nt NodeType
+	id uint64
+	// created is in unix nanos, which is a third of the size of a time.Time.
+	created int64
 
-	// node is set later due to a chicken and egg problem with the trie node. node is immutable.
+	// node is set later due to a chicken and egg problem with the trie node. node only changes
+	// on moves, which hold the filesystem lock.
 	node *trie.Node
+
+	// ext is only set for entries without a node.
+	ext *external
+	nt  NodeType
+}
+
+// external is the metadata of entries listed from a mounted backend or made by
+// NewFileEntry/NewDirEntry. They don't have a node, so path is their absolute path instead.
+type external struct {
+	// mount is only set for entries listed from a mounted backend.
+	mount *mount
+	path  string
+}
+
+func newMetadata(fs *FileSystem, nt NodeType) Metadata {
+	return Metadata{
+		nt:      nt,
+		fs:      fs,
+		id:      atomic.AddUint64(&fs.lastID, 1),
+		created: time.Now().UnixNano(),
+	}
 }
 
-func newMetadata(fs *FileSystem, nt NodeType) *Metadata {
-	return &Metadata{
-		nt: nt,
-		fs: fs,
+// mount returns the mount of entries listed from a mounted backend.
+func (md *Metadata) mount() *mount {
+	if md.ext == nil {
+		return nil
 	}
+	return md.ext.mount
 }
 
 // setNode must be called to set the node on the metadata. It's a chicken and egg problem as to
@@ -41,13 +70,35 @@ func (md *Metadata) setNode(n *trie.Node) error {
 	return nil
 }
 
+// moveNode points the metadata to its new node after a move.
+func (md *Metadata) moveNode(n *trie.Node) {
+	md.node = n
+}
+
 func (md *Metadata) Node() *trie.Node {
 	return md.node
 }
 
+// ID uniquely identifies the dir/file within its filesystem. It doesn't change on moves, but a
+// file gets a new one when ApplyManifest replaces its content in a ContentStore.
+func (md *Metadata) ID() uint64 {
+	return md.id
+}
+
+// Created returns the creation time of the dir/file.
+func (md *Metadata) Created() time.Time {
+	if md.created == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, md.created)
+}
+
 // AbsolutePath return the absolute path of the dir/file. For dirs, we remove '/' except for the
 // root.
 func (md *Metadata) AbsolutePath() string {
+	if md.ext != nil {
+		return md.ext.path
+	}
 	if md.node == nil {
 		glog.Fatalln("Impossible. node is set at creation time.")
 	}
@@ -60,8 +111,19 @@ func (md *Metadata) AbsolutePath() string {
 
 // Returns the name of the node. For dirs, we trim suffix '/' for dirs)
 func (md *Metadata) Name() string {
+	if md.ext != nil {
+		return fspath.Base(md.ext.path)
+	}
 	if md.node == nil {
 		glog.Fatalln("Impossible. node is set at creation time.")
 	}
 	return md.node.Name()
 }
+
+// unixNano returns t in unix nanos, keeping the zero time 0.
+func unixNano(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
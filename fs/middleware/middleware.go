@@ -0,0 +1,145 @@
+// Package middleware layers cross-cutting concerns (logging, metrics, tracing, read-only, quotas)
+// around an fs.Interface without changing it, like http.Handler middleware.
+//
+// Wrapped filesystems only expose fs.Interface. The optional interfaces of the underlying
+// filesystem (i.e., fs.Watcher) are hidden, so use it directly for those.
+package middleware
+
+import (
+	"io"
+
+	"github.com/basharal/filesystem/fs"
+)
+
+// Middleware wraps a filesystem.
+type Middleware func(fs.Interface) fs.Interface
+
+// Chain wraps f with mws. The first one is the outermost, so it sees operations first.
+func Chain(f fs.Interface, mws ...Middleware) fs.Interface {
+	for i := len(mws) - 1; i >= 0; i-- {
+		f = mws[i](f)
+	}
+	return f
+}
+
+// Op is an operation on a filesystem.
+type Op struct {
+	// Name is the method (i.e., Write).
+	Name string
+
+	// Path is the path the operation was called with. For Move and Copy, it's the source.
+	Path string
+}
+
+// Around returns a middleware calling fn for every operation except CurrentDir and Limits. fn must
+// call call to run the operation and should return its error.
+func Around(fn func(op Op, call func() error) error) Middleware {
+	return func(next fs.Interface) fs.Interface {
+		return &around{next: next, fn: fn}
+	}
+}
+
+type around struct {
+	next fs.Interface
+	fn   func(op Op, call func() error) error
+}
+
+func (a *around) CurrentDir() string {
+	return a.next.CurrentDir()
+}
+
+func (a *around) ChangeDir(path string) error {
+	return a.fn(Op{"ChangeDir", path}, func() error { return a.next.ChangeDir(path) })
+}
+
+func (a *around) ListDir(path string) (files []*fs.File, dirs []*fs.Dir, err error) {
+	err = a.fn(Op{"ListDir", path}, func() error {
+		files, dirs, err = a.next.ListDir(path)
+		return err
+	})
+	return files, dirs, err
+}
+
+func (a *around) Stat(path string) (file *fs.File, dir *fs.Dir, err error) {
+	err = a.fn(Op{"Stat", path}, func() error {
+		file, dir, err = a.next.Stat(path)
+		return err
+	})
+	return file, dir, err
+}
+
+func (a *around) Find(path, search string) (files []*fs.File, dirs []*fs.Dir, err error) {
+	err = a.fn(Op{"Find", path}, func() error {
+		files, dirs, err = a.next.Find(path, search)
+		return err
+	})
+	return files, dirs, err
+}
+
+func (a *around) FindFirstRegex(path, regex string) (found string, err error) {
+	err = a.fn(Op{"FindFirstRegex", path}, func() error {
+		found, err = a.next.FindFirstRegex(path, regex)
+		return err
+	})
+	return found, err
+}
+
+func (a *around) FindRegex(path, regex string, max int) (res fs.RegexResult, err error) {
+	err = a.fn(Op{"FindRegex", path}, func() error {
+		res, err = a.next.FindRegex(path, regex, max)
+		return err
+	})
+	return res, err
+}
+
+func (a *around) MakeDir(path string) error {
+	return a.fn(Op{"MakeDir", path}, func() error { return a.next.MakeDir(path) })
+}
+
+func (a *around) NewFile(path string) error {
+	return a.fn(Op{"NewFile", path}, func() error { return a.next.NewFile(path) })
+}
+
+func (a *around) TouchFile(path string) error {
+	return a.fn(Op{"TouchFile", path}, func() error { return a.next.TouchFile(path) })
+}
+
+func (a *around) Remove(path string) error {
+	return a.fn(Op{"Remove", path}, func() error { return a.next.Remove(path) })
+}
+
+func (a *around) DeletePrefix(path string, dryRun bool) (n int, err error) {
+	err = a.fn(Op{"DeletePrefix", path}, func() error {
+		n, err = a.next.DeletePrefix(path, dryRun)
+		return err
+	})
+	return n, err
+}
+
+func (a *around) Move(src, dst string) error {
+	return a.fn(Op{"Move", src}, func() error { return a.next.Move(src, dst) })
+}
+
+func (a *around) Copy(src, dst string) error {
+	return a.fn(Op{"Copy", src}, func() error { return a.next.Copy(src, dst) })
+}
+
+func (a *around) Read(path string, writer io.Writer) (n int64, err error) {
+	err = a.fn(Op{"Read", path}, func() error {
+		n, err = a.next.Read(path, writer)
+		return err
+	})
+	return n, err
+}
+
+func (a *around) Write(path string, reader io.Reader) (n int64, err error) {
+	err = a.fn(Op{"Write", path}, func() error {
+		n, err = a.next.Write(path, reader)
+		return err
+	})
+	return n, err
+}
+
+func (a *around) Limits() fs.Limits {
+	return a.next.Limits()
+}
@@ -0,0 +1,72 @@
+package middleware
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/basharal/filesystem/fs"
+)
+
+func TestChain(t *testing.T) {
+	var calls []string
+	named := func(name string) Middleware {
+		return Around(func(op Op, call func() error) error {
+			calls = append(calls, name+" "+op.Name+" "+op.Path)
+			return call()
+		})
+	}
+	metrics := NewMetrics()
+	f := Chain(fs.New(), named("outer"), named("inner"), WithMetrics(metrics))
+	if err := f.MakeDir("/foo"); err != nil {
+		t.Fatalf("MakeDir() error = %v", err)
+	}
+	if err := f.MakeDir("/foo"); !errors.Is(err, fs.ErrAlreadyExist) {
+		t.Errorf("MakeDir() error = %v, want %v", err, fs.ErrAlreadyExist)
+	}
+	if got, want := strings.Join(calls[:2], ","), "outer MakeDir /foo,inner MakeDir /foo"; got != want {
+		t.Errorf("calls = %s, want %s", got, want)
+	}
+	if s := metrics.Snapshot()["MakeDir"]; s.Calls != 2 || s.Failures != 1 {
+		t.Errorf("Metrics.Snapshot() = %+v, want 2 calls and 1 failure", s)
+	}
+}
+
+func TestReadOnly(t *testing.T) {
+	inner := fs.New()
+	if err := inner.NewFile("/foo"); err != nil {
+		t.Fatal(err)
+	}
+	f := Chain(inner, ReadOnly())
+	if _, err := f.Write("/foo", strings.NewReader("bar")); !errors.Is(err, fs.ErrNotSupported) {
+		t.Errorf("Write() error = %v, want %v", err, fs.ErrNotSupported)
+	}
+	if err := f.Remove("/foo"); !errors.Is(err, fs.ErrNotSupported) {
+		t.Errorf("Remove() error = %v, want %v", err, fs.ErrNotSupported)
+	}
+	if n, err := f.DeletePrefix("/foo", true); err != nil || n != 1 {
+		t.Errorf("DeletePrefix(dry run) = %d, %v, want 1", n, err)
+	}
+	if file, _, err := f.Stat("/foo"); err != nil || file == nil {
+		t.Errorf("Stat() = %v, %v, want the file", file, err)
+	}
+}
+
+func TestQuota(t *testing.T) {
+	f := Chain(fs.New(), Quota(5))
+	for _, p := range []string{"/foo", "/bar"} {
+		if err := f.NewFile(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := f.Write("/foo", strings.NewReader("abc")); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	n, err := f.Write("/bar", strings.NewReader("defg"))
+	if !errors.Is(err, fs.ErrLimitExceeded) || n != 2 {
+		t.Errorf("Write() = %d, %v, want 2, %v", n, err, fs.ErrLimitExceeded)
+	}
+	if err := f.Copy("/foo", "/baz"); !errors.Is(err, fs.ErrLimitExceeded) {
+		t.Errorf("Copy() error = %v, want %v", err, fs.ErrLimitExceeded)
+	}
+}
@@ -0,0 +1,239 @@
+package middleware
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/basharal/filesystem/fs"
+	"github.com/basharal/filesystem/fspath"
+	"github.com/golang/glog"
+)
+
+// Logging logs every operation, its duration and error at glog verbosity level.
+func Logging(level glog.Level) Middleware {
+	return Around(func(op Op, call func() error) error {
+		start := time.Now()
+		err := call()
+		if err != nil {
+			glog.V(level).Infof("%s %s failed after %s. %s\n", op.Name, op.Path, time.Since(start), err)
+		} else {
+			glog.V(level).Infof("%s %s took %s\n", op.Name, op.Path, time.Since(start))
+		}
+		return err
+	})
+}
+
+// Tracer starts a span for an operation. The returned func ends it with the operation's error.
+type Tracer interface {
+	Start(op Op) func(err error)
+}
+
+// Tracing reports every operation to tracer.
+func Tracing(tracer Tracer) Middleware {
+	return Around(func(op Op, call func() error) error {
+		end := tracer.Start(op)
+		err := call()
+		end(err)
+		return err
+	})
+}
+
+// Metrics are the per-operation counters of wrapped filesystems. It's an expvar.Var, so it can be
+// exported with expvar.Publish.
+type Metrics struct {
+	mu  sync.Mutex
+	ops map[string]*OpStats
+}
+
+// OpStats are the metrics of a single operation.
+type OpStats struct {
+	Calls        int64         `json:"calls"`
+	Failures     int64         `json:"failures"`
+	TotalLatency time.Duration `json:"total_latency_ns"`
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{ops: make(map[string]*OpStats)}
+}
+
+// Snapshot returns a copy of the metrics by operation name.
+func (m *Metrics) Snapshot() map[string]OpStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	snapshot := make(map[string]OpStats, len(m.ops))
+	for name, s := range m.ops {
+		snapshot[name] = *s
+	}
+	return snapshot
+}
+
+// String returns the metrics as JSON, as expvar.Var requires.
+func (m *Metrics) String() string {
+	b, err := json.Marshal(m.Snapshot())
+	if err != nil {
+		return "{}"
+	}
+	return string(b)
+}
+
+func (m *Metrics) record(name string, took time.Duration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.ops[name]
+	if !ok {
+		s = &OpStats{}
+		m.ops[name] = s
+	}
+	s.Calls++
+	if err != nil {
+		s.Failures++
+	}
+	s.TotalLatency += took
+}
+
+// WithMetrics records every operation in m.
+func WithMetrics(m *Metrics) Middleware {
+	return Around(func(op Op, call func() error) error {
+		start := time.Now()
+		err := call()
+		m.record(op.Name, time.Since(start), err)
+		return err
+	})
+}
+
+// readOnly fails every operation that changes the filesystem.
+type readOnly struct {
+	fs.Interface
+}
+
+// ReadOnly fails every operation that changes the filesystem with fs.ErrNotSupported.
+func ReadOnly() Middleware {
+	return func(next fs.Interface) fs.Interface {
+		return readOnly{next}
+	}
+}
+
+func denied(op, path string) error {
+	return &fs.PathError{Op: op, Path: path, Err: fmt.Errorf("read-only filesystem: %w", fs.ErrNotSupported)}
+}
+
+func (r readOnly) MakeDir(path string) error {
+	return denied("mkdir", path)
+}
+
+func (r readOnly) NewFile(path string) error {
+	return denied("create", path)
+}
+
+func (r readOnly) TouchFile(path string) error {
+	return denied("touch", path)
+}
+
+func (r readOnly) Remove(path string) error {
+	return denied("remove", path)
+}
+
+func (r readOnly) DeletePrefix(path string, dryRun bool) (int, error) {
+	if dryRun {
+		return r.Interface.DeletePrefix(path, dryRun)
+	}
+	return 0, denied("deleteprefix", path)
+}
+
+func (r readOnly) Move(src, dst string) error {
+	return denied("move", src)
+}
+
+func (r readOnly) Copy(src, dst string) error {
+	return denied("copy", src)
+}
+
+func (r readOnly) Write(path string, reader io.Reader) (int64, error) {
+	return -1, denied("write", path)
+}
+
+// quota bounds the total size of the files.
+type quota struct {
+	fs.Interface
+	max int64
+
+	// mu serializes the writes, so that concurrent ones can't exceed the quota together.
+	mu sync.Mutex
+}
+
+// Quota fails writes and copies that would make the total size of the files exceed max bytes
+// with fs.ErrLimitExceeded. Writes are cut short at the quota. The total is the root's usage, so
+// the filesystem must track it like fs.FileSystem does.
+func Quota(max int64) Middleware {
+	return func(next fs.Interface) fs.Interface {
+		return &quota{Interface: next, max: max}
+	}
+}
+
+// remaining returns how many more bytes fit in the quota.
+func (q *quota) remaining() (int64, error) {
+	_, root, err := q.Interface.Stat(fspath.Root)
+	if err != nil {
+		return 0, err
+	}
+	return q.max - root.Usage().Size, nil
+}
+
+func (q *quota) Write(path string, reader io.Reader) (int64, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	remaining, err := q.remaining()
+	if err != nil {
+		return -1, err
+	}
+	limited := &quotaReader{r: reader, remaining: remaining}
+	n, err := q.Interface.Write(path, limited)
+	if err == nil && limited.exceeded {
+		err = &fs.PathError{Op: "write", Path: path, Err: fmt.Errorf("quota of %d bytes: %w", q.max, fs.ErrLimitExceeded)}
+	}
+	return n, err
+}
+
+func (q *quota) Copy(src, dst string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	file, _, err := q.Interface.Stat(src)
+	if err != nil {
+		return err
+	}
+	remaining, err := q.remaining()
+	if err != nil {
+		return err
+	}
+	if file != nil && file.Size() > remaining {
+		return &fs.PathError{Op: "copy", Path: src, Err: fmt.Errorf("quota of %d bytes: %w", q.max, fs.ErrLimitExceeded)}
+	}
+	return q.Interface.Copy(src, dst)
+}
+
+// quotaReader stops reading once remaining bytes were read and records if there was more.
+type quotaReader struct {
+	r         io.Reader
+	remaining int64
+	exceeded  bool
+}
+
+func (q *quotaReader) Read(p []byte) (int, error) {
+	if q.remaining <= 0 {
+		// See if there's more to read.
+		var b [1]byte
+		if n, _ := q.r.Read(b[:]); n > 0 {
+			q.exceeded = true
+		}
+		return 0, io.EOF
+	}
+	if int64(len(p)) > q.remaining {
+		p = p[:q.remaining]
+	}
+	n, err := q.r.Read(p)
+	q.remaining -= int64(n)
+	return n, err
+}
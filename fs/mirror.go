@@ -0,0 +1,410 @@
+package fs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/basharal/filesystem/fspath"
+	"github.com/fsnotify/fsnotify"
+	"github.com/golang/glog"
+)
+
+const (
+	defaultMirrorInterval = time.Minute
+	mirrorBufferSize      = 1024
+	// mirrorSettle is how long local changes are left to settle before they're pulled in, so that
+	// bursts of them (i.e., a tree being copied in) are pulled in at once.
+	mirrorSettle = 50 * time.Millisecond
+)
+
+// MirrorOpts controls how Mirror syncs the filesystem with a local dir.
+type MirrorOpts struct {
+	// Interval is how often the local dir is rescanned to catch the changes notifications missed
+	// (i.e., once the kernel's queue overflowed, or in dirs made right before their scan).
+	// Defaults to a minute.
+	Interval time.Duration
+
+	// Filter is called with the absolute path of every entry. Entries for which it returns false
+	// aren't synced in either direction. Optional.
+	Filter func(path string, isDir bool) bool
+}
+
+// Mirror keeps the filesystem and the local dir in sync until ctx is done. Changes to the
+// filesystem are written through to dir, while changes to dir are pulled in by scanning the paths
+// the OS notifies of (see fsnotify), and the whole dir every Interval or once notifications fail. On start, the content of dir is
+// loaded on top of the filesystem. Changes whose events are dropped because the mirror falls
+// behind are only written through on the next change.
+func (fs *FileSystem) Mirror(ctx context.Context, dir string, opts MirrorOpts) error {
+	if opts.Interval == 0 {
+		opts.Interval = defaultMirrorInterval
+	}
+	events, cancel := fs.Watch(mirrorBufferSize)
+	defer cancel()
+
+	m := &mirror{fs: fs, dir: dir, filter: opts.Filter, synced: make(map[string]osEntry)}
+	// Nil channels never receive, leaving only the rescans without a watcher.
+	var notified <-chan fsnotify.Event
+	var watchErrs <-chan error
+	if watcher, err := fsnotify.NewWatcher(); err != nil {
+		// i.e., the inotify limits are reached.
+		glog.Errorf("Failed to watch %s, scanning it every %v instead. %s\n", dir, opts.Interval, err)
+	} else {
+		defer watcher.Close()
+		m.watcher, m.watched = watcher, make(map[string]bool)
+		notified, watchErrs = watcher.Events, watcher.Errors
+	}
+	if err := m.pullTree(fspath.Root); err != nil {
+		return err
+	}
+	ticker := time.NewTicker(opts.Interval)
+	defer ticker.Stop()
+	// settle fires once local changes settled. It's nil while none are pending. notifiedPaths are
+	// the local paths changed since, unless rescan is set since notifications may have been lost.
+	var settle <-chan time.Time
+	notifiedPaths := make(map[string]bool)
+	rescan := false
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case e := <-events:
+			if err := m.push(e); err != nil {
+				glog.Errorf("Failed to mirror %s of %s. %s\n", e.Type, e.Path, err)
+			}
+		case e := <-notified:
+			notifiedPaths[e.Name] = true
+			if settle == nil {
+				settle = time.After(mirrorSettle)
+			}
+		case err := <-watchErrs:
+			// Notifications may have been lost, so the whole dir is scanned.
+			glog.Errorf("Failed to watch %s. %s\n", dir, err)
+			rescan = true
+			if settle == nil {
+				settle = time.After(mirrorSettle)
+			}
+		case <-settle:
+			settle = nil
+			if rescan {
+				if err := m.pullTree(fspath.Root); err != nil {
+					glog.Errorf("Failed to scan %s. %s\n", dir, err)
+				}
+			} else {
+				m.pullNotified(notifiedPaths)
+			}
+			notifiedPaths, rescan = make(map[string]bool), false
+		case <-ticker.C:
+			if err := m.pullTree(fspath.Root); err != nil {
+				glog.Errorf("Failed to scan %s. %s\n", dir, err)
+			}
+		}
+	}
+}
+
+// osEntry is what we know about a local file/dir when it was last synced.
+type osEntry struct {
+	isDir   bool
+	size    int64
+	modTime time.Time
+}
+
+// mirror is only accessed from the Mirror loop so it doesn't need locking.
+type mirror struct {
+	fs     *FileSystem
+	dir    string
+	filter func(path string, isDir bool) bool
+
+	// synced is keyed by absolute paths within the filesystem.
+	synced map[string]osEntry
+
+	// watcher notifies of local changes, if it could be created. Notifications aren't recursive,
+	// so every dir is watched, keyed by absolute path in watched once it is.
+	watcher *fsnotify.Watcher
+	watched map[string]bool
+}
+
+func (m *mirror) osPath(p string) string {
+	return filepath.Join(m.dir, filepath.FromSlash(p))
+}
+
+func (m *mirror) allowed(p string, isDir bool) bool {
+	return m.filter == nil || m.filter(p, isDir)
+}
+
+// scan returns the current state of root and everything under it in the local dir, which is
+// empty if root is missing.
+func (m *mirror) scan(root string) (map[string]osEntry, error) {
+	entries := make(map[string]osEntry)
+	err := filepath.Walk(m.osPath(root), func(osPath string, info os.FileInfo, err error) error {
+		if os.IsNotExist(err) {
+			// Removed since it was notified of or listed.
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if osPath == m.dir {
+			return nil
+		}
+		rel, err := filepath.Rel(m.dir, osPath)
+		if err != nil {
+			return err
+		}
+		p := SeperatorStr + filepath.ToSlash(rel)
+		if !info.IsDir() && !info.Mode().IsRegular() {
+			return nil
+		}
+		if !m.allowed(p, info.IsDir()) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		entries[p] = osEntry{isDir: info.IsDir(), size: info.Size(), modTime: info.ModTime()}
+		return nil
+	})
+	return entries, err
+}
+
+// pullNotified pulls in the local changes to the notified paths (see pullTree), given as local
+// paths.
+func (m *mirror) pullNotified(osPaths map[string]bool) {
+	paths := make([]string, 0, len(osPaths))
+	for osPath := range osPaths {
+		rel, err := filepath.Rel(m.dir, osPath)
+		// Changes to the dir itself (i.e., its times) don't need pulling.
+		if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			continue
+		}
+		paths = append(paths, SeperatorStr+filepath.ToSlash(rel))
+	}
+	// Pulling a dir pulls what's under it, and dirs sort before what's under them.
+	sort.Strings(paths)
+	pulled := ""
+	for _, p := range paths {
+		if pulled != "" && fspath.HasPrefix(p, pulled) {
+			continue
+		}
+		pulled = p
+		if err := m.pullTree(p); err != nil {
+			glog.Errorf("Failed to scan %s. %s\n", m.osPath(p), err)
+		}
+	}
+}
+
+// pullTree applies the local changes to root and everything under it since they were last synced
+// to the filesystem. Entries that fail are retried on the next scan.
+func (m *mirror) pullTree(root string) error {
+	current, err := m.scan(root)
+	if err != nil {
+		return err
+	}
+	m.watch(root, current)
+
+	// Children sort after their parents, so remove in reverse order and create in order.
+	removed := make([]string, 0)
+	for p, prev := range m.synced {
+		if !fspath.HasPrefix(p, root) {
+			continue
+		}
+		if e, ok := current[p]; !ok || e.isDir != prev.isDir {
+			removed = append(removed, p)
+		}
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(removed)))
+	for _, p := range removed {
+		if err := m.fs.Remove(p); err != nil && !errors.Is(err, ErrNotFound) {
+			glog.Errorf("Failed to remove %s. %s\n", p, err)
+			continue
+		}
+		delete(m.synced, p)
+	}
+
+	paths := make([]string, 0, len(current))
+	for p := range current {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	for _, p := range paths {
+		e := current[p]
+		if prev, ok := m.synced[p]; ok && prev == e {
+			continue
+		}
+		if err := m.fs.create(p, e.isDir); err != nil && !errors.Is(err, ErrAlreadyExist) {
+			glog.Errorf("Failed to create %s. %s\n", p, err)
+			continue
+		}
+		if !e.isDir {
+			if err := m.pullContent(p); err != nil {
+				glog.Errorf("Failed to copy %s. %s\n", p, err)
+				continue
+			}
+		}
+		m.synced[p] = e
+	}
+	return nil
+}
+
+// watch watches the dirs of current, the state of root and everything under it, that aren't yet.
+// The watches of the dirs under root that were removed went with them.
+func (m *mirror) watch(root string, current map[string]osEntry) {
+	if m.watcher == nil {
+		return
+	}
+	for p := range m.watched {
+		if e, ok := current[p]; p != fspath.Root && fspath.HasPrefix(p, root) && (!ok || !e.isDir) {
+			delete(m.watched, p)
+		}
+	}
+	dirs := []string{fspath.Root}
+	for p, e := range current {
+		if e.isDir {
+			dirs = append(dirs, p)
+		}
+	}
+	for _, p := range dirs {
+		if m.watched[p] {
+			continue
+		}
+		if err := m.watcher.Add(m.osPath(p)); err != nil {
+			// Retried on the next scan.
+			glog.Errorf("Failed to watch %s. %s\n", m.osPath(p), err)
+			continue
+		}
+		m.watched[p] = true
+	}
+}
+
+func (m *mirror) pullContent(p string) error {
+	f, err := os.Open(m.osPath(p))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return m.fs.replace(p, f)
+}
+
+// push writes a filesystem change through to the local dir.
+func (m *mirror) push(e Event) error {
+	if !m.allowed(e.Path, e.IsDir) {
+		return nil
+	}
+	osPath := m.osPath(e.Path)
+	switch e.Type {
+	case EventMakeDir:
+		if err := os.MkdirAll(osPath, 0755); err != nil {
+			return err
+		}
+	case EventCreate, EventWrite:
+		if err := m.pushContent(e.Path); err != nil {
+			return err
+		}
+	case EventRemove:
+		delete(m.synced, e.Path)
+		return os.RemoveAll(osPath)
+	case EventMove:
+		if !m.allowed(e.NewPath, e.IsDir) {
+			return nil
+		}
+		if _, err := os.Lstat(m.osPath(e.NewPath)); os.IsNotExist(err) {
+			if err := os.Rename(osPath, m.osPath(e.NewPath)); err != nil {
+				return err
+			}
+		}
+		for p, entry := range m.synced {
+			if rel, ok := fspath.TrimPrefix(p, e.Path); ok {
+				delete(m.synced, p)
+				m.synced[fspath.Join(e.NewPath, rel)] = entry
+			}
+		}
+		return nil
+	}
+	return m.record(e.Path)
+}
+
+// pushContent writes the file's content to the local dir unless it's already there.
+func (m *mirror) pushContent(p string) error {
+	buf := bytes.NewBuffer(nil)
+	if _, err := m.fs.Read(p, buf); err != nil {
+		if errors.Is(err, ErrNotFound) {
+			// Removed since.
+			return nil
+		}
+		return err
+	}
+	osPath := m.osPath(p)
+	if existing, err := ioutil.ReadFile(osPath); err == nil && bytes.Equal(existing, buf.Bytes()) {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(osPath), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(osPath, buf.Bytes(), 0644)
+}
+
+// record remembers the local state of p so that the next scan doesn't pull it back in.
+func (m *mirror) record(p string) error {
+	info, err := os.Stat(m.osPath(p))
+	if err != nil {
+		if os.IsNotExist(err) {
+			delete(m.synced, p)
+			return nil
+		}
+		return err
+	}
+	m.synced[p] = osEntry{isDir: info.IsDir(), size: info.Size(), modTime: info.ModTime()}
+	return nil
+}
+
+// create creates the file/dir at the absolute path p. Its parent must exist.
+func (fs *FileSystem) create(p string, isDir bool) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	return fs.createLocked(p, isDir)
+}
+
+// createLocked is create with mu held.
+func (fs *FileSystem) createLocked(p string, isDir bool) error {
+	parent := fs.root.md.node
+	if dir := fspath.Dir(p); dir != fspath.Root {
+		node := fs.findNode(fs.normalizeDirPath(dir))
+		if node == nil {
+			return ErrNotFound
+		}
+		parent = node
+	}
+	if isDir {
+		return fs.mkdirAtNode(fspath.Base(p)+SeperatorStr, parent)
+	}
+	return fs.newFileAtNode(fspath.Base(p), parent)
+}
+
+// replace overwrites the content of the file at p (relative/abs).
+func (fs *FileSystem) replace(p string, reader io.Reader) error {
+	fs.mu.RLock()
+	node := fs.findNode(p)
+	fs.mu.RUnlock()
+	if node == nil {
+		return ErrNotFound
+	}
+	file, ok := node.Meta().(*File)
+	if !ok {
+		return fmt.Errorf("cannot write content on directories")
+	}
+	_, err := file.replace(reader)
+	fs.account(file)
+	if err != nil {
+		return err
+	}
+	fs.publish(EventWrite, file.Path(), false)
+	return nil
+}
@@ -0,0 +1,114 @@
+package fs
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/basharal/filesystem/fspath"
+)
+
+// BackendEntry is a file/dir listed by a Backend.
+type BackendEntry struct {
+	Name  string
+	IsDir bool
+	Size  int64
+}
+
+// Backend is a filesystem that can be mounted into the namespace with Mount. Paths passed to it
+// are absolute within the backend (i.e., the mount point is its root).
+type Backend interface {
+	ListDir(ctx context.Context, path string) ([]BackendEntry, error)
+	MakeDir(ctx context.Context, path string) error
+	CreateFile(ctx context.Context, path string) error
+	Remove(ctx context.Context, path string) error
+	Read(ctx context.Context, path string, writer io.Writer) (int64, error)
+	Write(ctx context.Context, path string, reader io.Reader) (int64, error)
+}
+
+type mount struct {
+	// path is the absolute path of the mount point.
+	path    string
+	backend Backend
+}
+
+// Mount grafts backend onto the existing dir s (relative/abs). Operations on paths under it are
+// forwarded to backend, hiding the dir's local content until Unmount is called. Moves across the
+// mount point and searches under it aren't supported.
+func (fs *FileSystem) Mount(s string, backend Backend) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	p := fspath.Clean(fs.normalizePath(s))
+	if p == fspath.Root {
+		return fmt.Errorf("cannot mount on root: %w", ErrNotSupported)
+	}
+	for mp := range fs.mounts {
+		if fspath.HasPrefix(p, mp) || fspath.HasPrefix(mp, p) {
+			return fmt.Errorf("%s overlaps with mount %s: %w", p, mp, ErrAlreadyExist)
+		}
+	}
+	node := fs.findNode(fs.normalizeDirPath(p))
+	if node == nil {
+		return fmt.Errorf("%s %w", p, ErrNotFound)
+	}
+	if _, ok := node.Meta().(*Dir); !ok {
+		return fmt.Errorf("directory expected. file given")
+	}
+	fs.mounts[p] = &mount{path: p, backend: backend}
+	return nil
+}
+
+// Unmount removes the mount at s (relative/abs).
+func (fs *FileSystem) Unmount(s string) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	p := fspath.Clean(fs.normalizePath(s))
+	if _, ok := fs.mounts[p]; !ok {
+		return fmt.Errorf("%s isn't mounted: %w", p, ErrNotFound)
+	}
+	delete(fs.mounts, p)
+	if fspath.HasPrefix(fs.mountedDir, p) {
+		fs.mountedDir = ""
+	}
+	return nil
+}
+
+// mounted returns the mount that s (relative/abs) is under, if any, and the path within it.
+func (fs *FileSystem) mounted(s string) (*mount, string, bool) {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+	if len(fs.mounts) == 0 {
+		return nil, "", false
+	}
+	p := fs.normalizePath(s)
+	for mp, m := range fs.mounts {
+		if rel, ok := fspath.TrimPrefix(p, mp); ok {
+			return m, rel, true
+		}
+	}
+	return nil, "", false
+}
+
+func (m *mount) listDir(p string) ([]*File, []*Dir, error) {
+	entries, err := m.backend.ListDir(context.Background(), p)
+	if err != nil {
+		return nil, nil, err
+	}
+	files := make([]*File, 0)
+	dirs := make([]*Dir, 0)
+	for _, e := range entries {
+		abs := fspath.Join(m.path, p, e.Name)
+		if e.IsDir {
+			dirs = append(dirs, &Dir{md: Metadata{nt: dirType, ext: &external{mount: m, path: abs}}})
+			continue
+		}
+		files = append(files, &File{md: Metadata{nt: fileType, ext: &external{mount: m, path: abs}}, size: e.Size})
+	}
+	return files, dirs, nil
+}
+
+// rel returns the path of a mounted entry within its backend.
+func (m *mount) rel(abs string) string {
+	rel, _ := fspath.TrimPrefix(abs, m.path)
+	return rel
+}
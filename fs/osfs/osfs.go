@@ -0,0 +1,509 @@
+// Package osfs implements fs.Interface on top of a local directory, so that servers and CLIs can
+// serve actual disk contents.
+package osfs
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/basharal/filesystem/fs"
+	"github.com/basharal/filesystem/fspath"
+	"github.com/golang/glog"
+)
+
+// ErrOutsideRoot is returned for paths that lead outside the root through symlinks.
+var ErrOutsideRoot = fmt.Errorf("path is outside the root: %w", fs.ErrInvalidName)
+
+// FileSystem is a filesystem backed by a local directory (the root). Paths are jailed to the root:
+// '..' can't go above it and symlinks that resolve outside of it are rejected. Only regular files
+// and dirs are listed. Dir usage isn't tracked, so dirs report zeros.
+type FileSystem struct {
+	// root is absolute with symlinks resolved.
+	root string
+
+	// mu protects below.
+	mu sync.RWMutex
+	// currentDir is absolute within the root.
+	currentDir string
+}
+
+var _ fs.Interface = (*FileSystem)(nil)
+
+// New returns a filesystem backed by the existing dir.
+func New(dir string) (*FileSystem, error) {
+	root, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	root, err = filepath.EvalSymlinks(root)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(root)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("%s is not a dir", dir)
+	}
+	return &FileSystem{root: root, currentDir: fspath.Root}, nil
+}
+
+// abs returns the absolute path of p (relative/absolute) within the root. It never goes above
+// the root.
+func (o *FileSystem) abs(p string) string {
+	if fspath.IsAbs(p) {
+		return fspath.Clean(p)
+	}
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	return fspath.Join(o.currentDir, p)
+}
+
+// local returns the local path of the absolute path p. Symlinks in the existing part of it must
+// not lead outside the root.
+func (o *FileSystem) local(p string) (string, error) {
+	local := filepath.Join(o.root, filepath.FromSlash(p))
+	existing := local
+	for {
+		resolved, err := filepath.EvalSymlinks(existing)
+		if err == nil {
+			if resolved != o.root && !strings.HasPrefix(resolved, o.root+string(filepath.Separator)) {
+				return "", ErrOutsideRoot
+			}
+			return local, nil
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return "", err
+		}
+		parent := filepath.Dir(existing)
+		if parent == existing {
+			return local, nil
+		}
+		existing = parent
+	}
+}
+
+// resolve returns the absolute and local paths of p (relative/absolute).
+func (o *FileSystem) resolve(p string) (string, string, error) {
+	if p == "" {
+		return "", "", fs.ErrInvalidName
+	}
+	abs := o.abs(p)
+	local, err := o.local(abs)
+	return abs, local, err
+}
+
+// pathError converts OS errors into the fs sentinels, without the local path, and adds op and
+// path to them.
+func pathError(op, path string, err error) error {
+	var pathErr *os.PathError
+	var linkErr *os.LinkError
+	switch {
+	case err == nil:
+		return nil
+	case errors.As(err, new(*fs.PathError)):
+		return err
+	case errors.Is(err, os.ErrNotExist):
+		err = fs.ErrNotFound
+	case errors.Is(err, os.ErrExist):
+		err = fs.ErrAlreadyExist
+	case errors.Is(err, syscall.ENOTEMPTY):
+		err = fs.ErrDirNotEmpty
+	case errors.As(err, &pathErr):
+		err = pathErr.Err
+	case errors.As(err, &linkErr):
+		err = linkErr.Err
+	}
+	return &fs.PathError{Op: op, Path: path, Err: err}
+}
+
+// existsError replaces an error saying that the local path of the absolute path p exists with an
+// fs.ExistsError saying what's there.
+func existsError(p, local string, err error) error {
+	if !errors.Is(err, os.ErrExist) {
+		return err
+	}
+	info, statErr := os.Lstat(local)
+	if statErr != nil {
+		return err
+	}
+	return &fs.ExistsError{Path: p, IsDir: info.IsDir()}
+}
+
+// entry returns the fs entry for the local file/dir described by info at the absolute path p, or
+// nil if it's neither.
+func entry(p string, info os.FileInfo) (*fs.File, *fs.Dir) {
+	switch {
+	case info.Mode().IsRegular():
+		return fs.NewFileEntry(p, info.Size(), info.ModTime()), nil
+	case info.IsDir():
+		return nil, fs.NewDirEntry(p, fs.DirUsage{})
+	}
+	return nil, nil
+}
+
+// CurrentDir returns the absolute path of the current directory.
+func (o *FileSystem) CurrentDir() string {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	return o.currentDir
+}
+
+// ChangeDir switches the current directory to p (relative/absolute).
+func (o *FileSystem) ChangeDir(p string) error {
+	abs, local, err := o.resolve(p)
+	if err != nil {
+		return pathError("chdir", p, err)
+	}
+	info, err := os.Stat(local)
+	if err != nil {
+		return pathError("chdir", p, err)
+	}
+	if !info.IsDir() {
+		return pathError("chdir", p, fs.ErrNotFound)
+	}
+	o.mu.Lock()
+	o.currentDir = abs
+	o.mu.Unlock()
+	return nil
+}
+
+// ListDir lists the files/dirs in p (relative/absolute).
+func (o *FileSystem) ListDir(p string) ([]*fs.File, []*fs.Dir, error) {
+	abs, local, err := o.resolve(p)
+	if err != nil {
+		return nil, nil, pathError("list", p, err)
+	}
+	entries, err := os.ReadDir(local)
+	if err != nil {
+		return nil, nil, pathError("list", p, err)
+	}
+	files := make([]*fs.File, 0)
+	dirs := make([]*fs.Dir, 0)
+	for _, e := range entries {
+		info, err := e.Info()
+		if err != nil {
+			// Removed since being listed.
+			continue
+		}
+		file, dir := entry(fspath.Join(abs, e.Name()), info)
+		if file != nil {
+			files = append(files, file)
+		}
+		if dir != nil {
+			dirs = append(dirs, dir)
+		}
+	}
+	return files, dirs, nil
+}
+
+// Stat returns the file or dir at p (relative/absolute).
+func (o *FileSystem) Stat(p string) (*fs.File, *fs.Dir, error) {
+	abs, local, err := o.resolve(p)
+	if err != nil {
+		return nil, nil, pathError("stat", p, err)
+	}
+	info, err := os.Stat(local)
+	if err != nil {
+		return nil, nil, pathError("stat", p, err)
+	}
+	file, dir := entry(abs, info)
+	if file == nil && dir == nil {
+		return nil, nil, pathError("stat", p, fs.ErrNotSupported)
+	}
+	return file, dir, nil
+}
+
+// walk calls fn for everything under the absolute path p (excluding p itself) until it returns
+// io.EOF.
+func (o *FileSystem) walk(p string, fn func(abs string, info os.FileInfo) error) error {
+	local, err := o.local(p)
+	if err != nil {
+		return err
+	}
+	err = filepath.Walk(local, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == local {
+			return nil
+		}
+		rel, err := filepath.Rel(o.root, path)
+		if err != nil {
+			return err
+		}
+		return fn(fspath.Join(fspath.Root, filepath.ToSlash(rel)), info)
+	})
+	if err == io.EOF {
+		return nil
+	}
+	return err
+}
+
+// Find returns the files/dirs under p (relative/absolute) named search.
+func (o *FileSystem) Find(p, search string) ([]*fs.File, []*fs.Dir, error) {
+	var files []*fs.File
+	var dirs []*fs.Dir
+	err := o.walk(o.abs(p), func(abs string, info os.FileInfo) error {
+		if info.Name() != search {
+			return nil
+		}
+		file, dir := entry(abs, info)
+		if file != nil {
+			files = append(files, file)
+		}
+		if dir != nil {
+			dirs = append(dirs, dir)
+		}
+		return nil
+	})
+	return files, dirs, pathError("find", p, err)
+}
+
+// FindFirstRegex returns the first absolute path under p (relative/absolute) matching the regex.
+func (o *FileSystem) FindFirstRegex(p, regex string) (string, error) {
+	res, err := o.FindRegex(p, regex, 1)
+	if err != nil || len(res.Paths) == 0 {
+		return "", err
+	}
+	return res.Paths[0], nil
+}
+
+// FindRegex returns up to max absolute paths under p (relative/absolute) that match the regex.
+// Dirs end with a '/'.
+func (o *FileSystem) FindRegex(p, regex string, max int) (fs.RegexResult, error) {
+	if max <= 0 {
+		return fs.RegexResult{}, pathError("regex", p, fmt.Errorf("max results must be positive"))
+	}
+	re, err := regexp.Compile(regex)
+	if err != nil {
+		return fs.RegexResult{}, pathError("regex", p, err)
+	}
+	var res fs.RegexResult
+	err = o.walk(o.abs(p), func(abs string, info os.FileInfo) error {
+		if info.IsDir() {
+			abs += fspath.SeparatorStr
+		}
+		res.Visited++
+		if re.MatchString(abs) {
+			res.Paths = append(res.Paths, abs)
+		}
+		if len(res.Paths) >= max {
+			return io.EOF
+		}
+		return nil
+	})
+	return res, pathError("regex", p, err)
+}
+
+// MakeDir creates the dir at p (relative/absolute). Its parent must exist.
+func (o *FileSystem) MakeDir(p string) error {
+	abs, local, err := o.resolve(p)
+	if err != nil {
+		return pathError("mkdir", p, err)
+	}
+	return pathError("mkdir", p, existsError(abs, local, os.Mkdir(local, 0755)))
+}
+
+// NewFile creates an empty file at p (relative/absolute).
+func (o *FileSystem) NewFile(p string) error {
+	abs, local, err := o.resolve(p)
+	if err != nil {
+		return pathError("create", p, err)
+	}
+	// O_EXCL also refuses to follow symlinks.
+	f, err := os.OpenFile(local, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return pathError("create", p, existsError(abs, local, err))
+	}
+	return pathError("create", p, f.Close())
+}
+
+// TouchFile creates the file at p (relative/absolute) if it doesn't exist. Otherwise, it updates
+// its modification time.
+func (o *FileSystem) TouchFile(p string) error {
+	err := o.NewFile(p)
+	if !errors.Is(err, fs.ErrAlreadyExist) {
+		return err
+	}
+	_, local, err := o.resolve(p)
+	if err != nil {
+		return pathError("touch", p, err)
+	}
+	now := time.Now()
+	return pathError("touch", p, os.Chtimes(local, now, now))
+}
+
+// removable returns an error if the absolute path p is the root or contains the current dir.
+func (o *FileSystem) removable(p string) error {
+	if p == fspath.Root {
+		return fmt.Errorf("removing the root: %w", fs.ErrNotSupported)
+	}
+	if current := o.CurrentDir(); fspath.HasPrefix(current, p) {
+		return fmt.Errorf("current directory is under %s: %w", p, fs.ErrNotSupported)
+	}
+	return nil
+}
+
+// Remove removes the file or empty dir at p (relative/absolute).
+func (o *FileSystem) Remove(p string) error {
+	abs, local, err := o.resolve(p)
+	if err != nil {
+		return pathError("remove", p, err)
+	}
+	if err := o.removable(abs); err != nil {
+		return pathError("remove", p, err)
+	}
+	return pathError("remove", p, os.Remove(local))
+}
+
+// DeletePrefix removes p (relative/absolute) and everything under it and returns the number of
+// removed files/dirs. For root, only its content is removed. With dryRun, nothing is removed and
+// the count is what would have been removed.
+func (o *FileSystem) DeletePrefix(p string, dryRun bool) (int, error) {
+	abs, local, err := o.resolve(p)
+	if err != nil {
+		return 0, pathError("deleteprefix", p, err)
+	}
+	if _, err := os.Lstat(local); err != nil {
+		return 0, pathError("deleteprefix", p, err)
+	}
+	if current := o.CurrentDir(); abs != fspath.Root || current != fspath.Root {
+		if fspath.HasPrefix(current, abs) {
+			return 0, pathError("deleteprefix", p, fmt.Errorf("current directory is under %s: %w", abs, fs.ErrNotSupported))
+		}
+	}
+	n := 0
+	if abs != fspath.Root {
+		n++
+	}
+	if err := o.walk(abs, func(string, os.FileInfo) error { n++; return nil }); err != nil {
+		return 0, pathError("deleteprefix", p, err)
+	}
+	if dryRun {
+		return n, nil
+	}
+	if abs != fspath.Root {
+		return n, pathError("deleteprefix", p, os.RemoveAll(local))
+	}
+	entries, err := os.ReadDir(local)
+	if err != nil {
+		return 0, pathError("deleteprefix", p, err)
+	}
+	for _, e := range entries {
+		if err := os.RemoveAll(filepath.Join(local, e.Name())); err != nil {
+			return n, pathError("deleteprefix", p, err)
+		}
+	}
+	return n, nil
+}
+
+// Move moves the file/dir at src to dst, which must not exist. src/dst are relative or absolute.
+func (o *FileSystem) Move(src, dst string) error {
+	absSrc, localSrc, err := o.resolve(src)
+	if err != nil {
+		return pathError("move", src, err)
+	}
+	_, localDst, err := o.resolve(dst)
+	if err != nil {
+		return pathError("move", src, err)
+	}
+	if err := o.removable(absSrc); err != nil {
+		return pathError("move", src, err)
+	}
+	if _, err := os.Lstat(localDst); err == nil {
+		return pathError("move", dst, fs.ErrAlreadyExist)
+	}
+	return pathError("move", src, os.Rename(localSrc, localDst))
+}
+
+// Copy copies the file at src to a new file at dst. src/dst are relative or absolute.
+func (o *FileSystem) Copy(src, dst string) error {
+	_, localSrc, err := o.resolve(src)
+	if err != nil {
+		return pathError("copy", src, err)
+	}
+	in, err := os.Open(localSrc)
+	if err != nil {
+		return pathError("copy", src, err)
+	}
+	defer in.Close()
+	if err := o.NewFile(dst); err != nil {
+		return err
+	}
+	_, localDst, err := o.resolve(dst)
+	if err != nil {
+		return pathError("copy", src, err)
+	}
+	out, err := os.OpenFile(localDst, os.O_WRONLY|os.O_APPEND, 0)
+	if err == nil {
+		_, err = io.Copy(out, in)
+		if closeErr := out.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		os.Remove(localDst)
+		return pathError("copy", src, err)
+	}
+	return nil
+}
+
+// Read streams the content of the file at p (relative/absolute) to writer.
+func (o *FileSystem) Read(p string, writer io.Writer) (int64, error) {
+	_, local, err := o.resolve(p)
+	if err != nil {
+		return 0, pathError("read", p, err)
+	}
+	f, err := os.Open(local)
+	if err != nil {
+		return 0, pathError("read", p, err)
+	}
+	defer f.Close()
+	n, err := io.Copy(writer, f)
+	return n, pathError("read", p, err)
+}
+
+// Write appends reader's content to the existing file at p (relative/absolute). If reading fails,
+// what was appended is truncated away.
+func (o *FileSystem) Write(p string, reader io.Reader) (int64, error) {
+	_, local, err := o.resolve(p)
+	if err != nil {
+		return -1, pathError("write", p, err)
+	}
+	f, err := os.OpenFile(local, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		return -1, pathError("write", p, err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return -1, pathError("write", p, err)
+	}
+	n, err := io.Copy(f, reader)
+	if err != nil {
+		if truncErr := f.Truncate(info.Size()); truncErr != nil {
+			glog.Warningf("Failed to truncate %s after a failed write. %s\n", local, truncErr)
+		} else {
+			n = 0
+		}
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return n, pathError("write", p, err)
+}
+
+// Limits returns no limits.
+func (o *FileSystem) Limits() fs.Limits {
+	return fs.Limits{}
+}
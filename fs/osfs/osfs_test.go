@@ -0,0 +1,130 @@
+package osfs
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/basharal/filesystem/fs"
+)
+
+func createTestFS(t *testing.T) (*FileSystem, string) {
+	dir, err := ioutil.TempDir("", "osfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	o, err := New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return o, dir
+}
+
+func TestFileSystem_Ops(t *testing.T) {
+	o, dir := createTestFS(t)
+	if err := o.MakeDir("/foo"); err != nil {
+		t.Fatalf("FileSystem.MakeDir() error = %v", err)
+	}
+	if err := o.ChangeDir("foo"); err != nil {
+		t.Fatalf("FileSystem.ChangeDir() error = %v", err)
+	}
+	if err := o.NewFile("bar"); err != nil {
+		t.Fatalf("FileSystem.NewFile() error = %v", err)
+	}
+	if err := o.NewFile("bar"); !errors.Is(err, fs.ErrAlreadyExist) {
+		t.Errorf("FileSystem.NewFile() error = %v, want %v", err, fs.ErrAlreadyExist)
+	}
+	for _, s := range []string{"foo", "bar"} {
+		if _, err := o.Write("/foo/bar", strings.NewReader(s)); err != nil {
+			t.Fatalf("FileSystem.Write() error = %v", err)
+		}
+	}
+	if b, err := ioutil.ReadFile(filepath.Join(dir, "foo", "bar")); err != nil || string(b) != "foobar" {
+		t.Errorf("local content = %q, %v, want foobar", b, err)
+	}
+	// A failed write is truncated away.
+	abandoned := io.MultiReader(strings.NewReader("partial"), iotest.ErrReader(errors.New("client went away")))
+	if _, err := o.Write("/foo/bar", abandoned); err == nil {
+		t.Errorf("FileSystem.Write() succeeded with a failing reader")
+	}
+	if b, err := ioutil.ReadFile(filepath.Join(dir, "foo", "bar")); err != nil || string(b) != "foobar" {
+		t.Errorf("local content = %q, %v after a failed write, want foobar", b, err)
+	}
+	if err := o.Copy("bar", "/baz"); err != nil {
+		t.Fatalf("FileSystem.Copy() error = %v", err)
+	}
+	var buf bytes.Buffer
+	if _, err := o.Read("/baz", &buf); err != nil || buf.String() != "foobar" {
+		t.Errorf("FileSystem.Read() = %q, %v, want foobar", buf.String(), err)
+	}
+
+	files, dirs, err := o.ListDir("/")
+	if err != nil || len(files) != 1 || len(dirs) != 1 {
+		t.Fatalf("FileSystem.ListDir() = %v, %v, %v, want a file and a dir", files, dirs, err)
+	}
+	if files[0].Path() != "/baz" || files[0].String() != "baz" || files[0].Size() != 6 {
+		t.Errorf("FileSystem.ListDir() file = %s %s %d, want /baz baz 6", files[0].Path(), files[0].String(), files[0].Size())
+	}
+	if dirs[0].Path() != "/foo" {
+		t.Errorf("FileSystem.ListDir() dir = %s, want /foo", dirs[0].Path())
+	}
+
+	res, err := o.FindRegex("/", "ba", 10)
+	if err != nil || len(res.Paths) != 2 {
+		t.Errorf("FileSystem.FindRegex() = %v, %v, want 2 paths", res.Paths, err)
+	}
+	if err := o.Remove("/foo"); !errors.Is(err, fs.ErrNotSupported) {
+		t.Errorf("FileSystem.Remove(current dir) error = %v, want %v", err, fs.ErrNotSupported)
+	}
+	if err := o.ChangeDir("/"); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := o.DeletePrefix("/", false); err != nil || n != 3 {
+		t.Errorf("FileSystem.DeletePrefix(/) = %d, %v, want 3", n, err)
+	}
+	if _, _, err := o.Stat("/baz"); !errors.Is(err, fs.ErrNotFound) {
+		t.Errorf("FileSystem.Stat() error = %v, want %v", err, fs.ErrNotFound)
+	}
+}
+
+func TestFileSystem_Jail(t *testing.T) {
+	o, dir := createTestFS(t)
+	outside, err := ioutil.TempDir("", "outside")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(outside)
+	if err := ioutil.WriteFile(filepath.Join(outside, "secret"), []byte("secret"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(dir, "link")); err != nil {
+		t.Skip(err)
+	}
+
+	// '..' can't go above the root.
+	if err := o.NewFile("/../../escaped"); err != nil {
+		t.Fatalf("FileSystem.NewFile() error = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "escaped")); err != nil {
+		t.Errorf("file isn't under the root. %v", err)
+	}
+
+	// Symlinks can't lead outside of it.
+	var buf bytes.Buffer
+	if _, err := o.Read("/link/secret", &buf); !errors.Is(err, ErrOutsideRoot) {
+		t.Errorf("FileSystem.Read() error = %v, want %v", err, ErrOutsideRoot)
+	}
+	if err := o.NewFile("/link/new"); !errors.Is(err, ErrOutsideRoot) {
+		t.Errorf("FileSystem.NewFile() error = %v, want %v", err, ErrOutsideRoot)
+	}
+	if _, err := os.Stat(filepath.Join(outside, "new")); err == nil {
+		t.Errorf("file was created outside the root")
+	}
+}
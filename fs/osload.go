@@ -0,0 +1,102 @@
+package fs
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/basharal/filesystem/trie"
+)
+
+// LoadOpts controls how LoadFromOS populates the filesystem.
+type LoadOpts struct {
+	// Lazy defers reading file content until the first read/write. Ignored when the filesystem
+	// has a ContentStore.
+	Lazy bool
+
+	// Filter is called with the absolute path (within the filesystem) of every entry. Entries
+	// for which it returns false are skipped, including the children of skipped dirs. Optional.
+	Filter func(path string, isDir bool) bool
+}
+
+// LoadFromOS walks dir on the local filesystem and recreates its structure and content under
+// root. Existing dirs are merged into, while existing files result in ErrAlreadyExist. Symlinks
+// and other non-regular files are skipped.
+func (fs *FileSystem) LoadFromOS(dir string, opts LoadOpts) error {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s isn't a directory", dir)
+	}
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	return fs.loadDir(dir, fs.root.md.node, SeperatorStr, opts)
+}
+
+// loadDir loads the entries of dir into n, whose absolute path is parent (ending with '/').
+func (fs *FileSystem) loadDir(dir string, n *trie.Node, parent string, opts LoadOpts) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		osPath := filepath.Join(dir, name)
+		switch {
+		case entry.IsDir():
+			if opts.Filter != nil && !opts.Filter(parent+name, true) {
+				continue
+			}
+			if err := fs.mkdirAtNode(name+SeperatorStr, n); err != nil && !errors.Is(err, ErrAlreadyExist) {
+				return fmt.Errorf("failed to load %s. %w", osPath, err)
+			}
+			child, ok := fs.trie.FindAtNode(name+SeperatorStr, n)
+			if !ok {
+				// A file with the same name exists.
+				return fmt.Errorf("failed to load %s. %w", osPath, ErrAlreadyExist)
+			}
+			if err := fs.loadDir(osPath, child, parent+name+SeperatorStr, opts); err != nil {
+				return err
+			}
+		case entry.Type().IsRegular():
+			if opts.Filter != nil && !opts.Filter(parent+name, false) {
+				continue
+			}
+			if err := fs.loadFile(osPath, name, n, opts); err != nil {
+				return fmt.Errorf("failed to load %s. %w", osPath, err)
+			}
+		}
+	}
+	return nil
+}
+
+func (fs *FileSystem) loadFile(osPath, name string, n *trie.Node, opts LoadOpts) error {
+	if err := fs.newFileAtNode(name, n); err != nil {
+		return err
+	}
+	child, _ := fs.trie.FindAtNode(name, n)
+	file := child.Meta().(*File)
+	if opts.Lazy && fs.content == nil {
+		info, err := os.Stat(osPath)
+		if err != nil {
+			return err
+		}
+		file.mu.Lock()
+		file.source = osPath
+		file.offloadedSize = info.Size()
+		file.mu.Unlock()
+		fs.accountLocked(file)
+		return nil
+	}
+	f, err := os.Open(osPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = file.Write(f)
+	fs.accountLocked(file)
+	return err
+}
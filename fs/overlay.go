@@ -0,0 +1,358 @@
+package fs
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/basharal/filesystem/fspath"
+)
+
+// Overlay presents a merged view of two filesystems. Reads fall through to the lower layer for
+// anything that isn't in the upper one, while all changes go to the upper layer. The lower layer is
+// never modified. Removing something from the lower layer records a whiteout that hides it.
+type Overlay struct {
+	upper *FileSystem
+	lower *FileSystem
+
+	// mu protects below. All paths are absolute.
+	mu         sync.RWMutex
+	currentDir string
+	// whiteouts hide paths (and their subtrees) in the lower layer.
+	whiteouts map[string]bool
+	// opaque dirs were recreated after being removed, so the lower layer's content under them is
+	// hidden.
+	opaque map[string]bool
+}
+
+// NewOverlay returns an overlay with changes going to upper on top of lower.
+func NewOverlay(upper, lower *FileSystem) *Overlay {
+	return &Overlay{
+		upper:      upper,
+		lower:      lower,
+		currentDir: fspath.Root,
+		whiteouts:  make(map[string]bool),
+		opaque:     make(map[string]bool),
+	}
+}
+
+// CurrentDir returns the absolute path of the current directory
+func (o *Overlay) CurrentDir() string {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	return o.currentDir
+}
+
+// ChangeDir switches current directory to s (relative/absolute)
+func (o *Overlay) ChangeDir(s string) (err error) {
+	defer wrapPathError(&err, "chdir", s)
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	p := o.abs(s)
+	isDir, ok := o.stat(p)
+	if !ok {
+		return ErrNotFound
+	}
+	if !isDir {
+		return fmt.Errorf("directory expected. file given")
+	}
+	o.currentDir = p
+	return nil
+}
+
+// MakeDir makes a new directory relative or absolute.
+func (o *Overlay) MakeDir(s string) (err error) {
+	defer wrapPathError(&err, "mkdir", s)
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.create(o.abs(s), true)
+}
+
+// NewFile creates a new empty file at s (relative/absolute).
+func (o *Overlay) NewFile(s string) (err error) {
+	defer wrapPathError(&err, "create", s)
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.create(o.abs(s), false)
+}
+
+// Remove removes s (relative/absolute) from the overlay. It could be dir/file. Directories must
+// be empty.
+func (o *Overlay) Remove(s string) (err error) {
+	defer wrapPathError(&err, "remove", s)
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	p := o.abs(s)
+	isDir, ok := o.stat(p)
+	if !ok {
+		return ErrNotFound
+	}
+	if p == fspath.Root || p == o.currentDir {
+		return ErrNotSupported
+	}
+	if isDir {
+		files, dirs, err := o.listDir(p)
+		if err != nil {
+			return err
+		}
+		if len(files) != 0 || len(dirs) != 0 {
+			return ErrDirNotEmpty
+		}
+	}
+	if _, ok := o.upper.stat(p); ok {
+		if err := o.upper.Remove(p); err != nil {
+			return err
+		}
+	}
+	if _, ok := o.lowerStat(p); ok {
+		for hidden := range o.whiteouts {
+			if hidden != p && fspath.HasPrefix(hidden, p) {
+				delete(o.whiteouts, hidden)
+			}
+		}
+		o.whiteouts[p] = true
+	}
+	delete(o.opaque, p)
+	return nil
+}
+
+// ListDir lists all the files/dirs in s (relative/abs). Entries in the upper layer shadow the ones
+// with the same name in the lower layer.
+func (o *Overlay) ListDir(s string) (_ []*File, _ []*Dir, err error) {
+	defer wrapPathError(&err, "list", s)
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	return o.listDir(o.abs(s))
+}
+
+func (o *Overlay) listDir(p string) ([]*File, []*Dir, error) {
+	isDir, ok := o.stat(p)
+	if !ok {
+		return nil, nil, ErrNotFound
+	}
+	if !isDir {
+		return nil, nil, fmt.Errorf("directory expected. file given")
+	}
+	files := make([]*File, 0)
+	dirs := make([]*Dir, 0)
+	seen := make(map[string]bool)
+	if isDir, ok := o.upper.stat(p); ok && isDir {
+		upperFiles, upperDirs, err := o.upper.ListDir(p)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, f := range upperFiles {
+			seen[f.Path()] = true
+		}
+		for _, d := range upperDirs {
+			seen[d.Path()] = true
+		}
+		files = append(files, upperFiles...)
+		dirs = append(dirs, upperDirs...)
+	}
+	if isDir, ok := o.lowerStat(p); ok && isDir {
+		lowerFiles, lowerDirs, err := o.lower.ListDir(p)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, f := range lowerFiles {
+			if !seen[f.Path()] && !o.hidden(f.Path()) {
+				files = append(files, f)
+			}
+		}
+		for _, d := range lowerDirs {
+			if !seen[d.Path()] && !o.hidden(d.Path()) {
+				dirs = append(dirs, d)
+			}
+		}
+	}
+	return files, dirs, nil
+}
+
+// Read reads the file at s (relative/abs) from the upper layer if it's there, otherwise from the
+// lower one.
+func (o *Overlay) Read(s string, writer io.Writer) (_ int64, err error) {
+	defer wrapPathError(&err, "read", s)
+	o.mu.RLock()
+	p := o.abs(s)
+	layer := o.upper
+	if _, ok := o.upper.stat(p); !ok {
+		if _, ok := o.lowerStat(p); !ok {
+			o.mu.RUnlock()
+			return -1, ErrNotFound
+		}
+		layer = o.lower
+	}
+	o.mu.RUnlock()
+	return layer.Read(p, writer)
+}
+
+// Write appends what's in reader until EOF to the file s (relative/abs). Files that are only in
+// the lower layer are copied up first.
+func (o *Overlay) Write(s string, reader io.Reader) (_ int64, err error) {
+	defer wrapPathError(&err, "write", s)
+	o.mu.Lock()
+	p := o.abs(s)
+	if err := o.copyUp(p); err != nil {
+		o.mu.Unlock()
+		return -1, err
+	}
+	o.mu.Unlock()
+	return o.upper.Write(p, reader)
+}
+
+// Move moves src to dst. src/dst are relative or absolute. Directories can only be moved if they
+// aren't in the lower layer.
+func (o *Overlay) Move(src, dst string) (err error) {
+	defer wrapPathError(&err, "move", src)
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	src, dst = o.abs(src), o.abs(dst)
+	isDir, ok := o.stat(src)
+	if !ok {
+		return ErrNotFound
+	}
+	if _, ok := o.stat(dst); ok {
+		return &PathError{Op: "move", Path: dst, Err: ErrAlreadyExist}
+	}
+	if parentIsDir, ok := o.stat(fspath.Dir(dst)); !ok || !parentIsDir {
+		return &PathError{Op: "move", Path: fspath.Dir(dst), Err: ErrNotFound}
+	}
+	_, inLower := o.lowerStat(src)
+	if isDir && inLower {
+		return fmt.Errorf("moving directories from the lower layer: %w", ErrNotSupported)
+	}
+	if err := o.copyUp(src); err != nil {
+		return err
+	}
+	if err := o.upperDir(fspath.Dir(dst)); err != nil {
+		return err
+	}
+	if err := o.upper.Move(src, dst); err != nil {
+		return err
+	}
+	if inLower {
+		o.whiteouts[src] = true
+	}
+	o.unhide(dst, isDir)
+	return nil
+}
+
+// abs returns the absolute path of s. Must be called with mu held.
+func (o *Overlay) abs(s string) string {
+	if IsAbs(s) {
+		return fspath.Clean(s)
+	}
+	return fspath.Join(o.currentDir, s)
+}
+
+// hidden returns true if p is hidden in the lower layer. Must be called with mu held.
+func (o *Overlay) hidden(p string) bool {
+	for dir := p; ; dir = fspath.Dir(dir) {
+		if o.whiteouts[dir] || (dir != p && o.opaque[dir]) {
+			return true
+		}
+		if dir == fspath.Root {
+			return false
+		}
+	}
+}
+
+// unhide makes p visible again after it's created in the upper layer. Must be called with mu
+// held.
+func (o *Overlay) unhide(p string, isDir bool) {
+	if !o.whiteouts[p] {
+		return
+	}
+	delete(o.whiteouts, p)
+	if isDir {
+		o.opaque[p] = true
+	}
+}
+
+func (o *Overlay) lowerStat(p string) (bool, bool) {
+	if o.hidden(p) {
+		return false, false
+	}
+	return o.lower.stat(p)
+}
+
+// stat returns whether p is visible and if it's a dir. Must be called with mu held.
+func (o *Overlay) stat(p string) (bool, bool) {
+	if isDir, ok := o.upper.stat(p); ok {
+		return isDir, true
+	}
+	return o.lowerStat(p)
+}
+
+// create creates p in the upper layer. Must be called with mu held.
+func (o *Overlay) create(p string, isDir bool) error {
+	if isDir, ok := o.stat(p); ok {
+		return &ExistsError{Path: p, IsDir: isDir}
+	}
+	if parentIsDir, ok := o.stat(fspath.Dir(p)); !ok || !parentIsDir {
+		return ErrNotFound
+	}
+	if err := o.upperDir(fspath.Dir(p)); err != nil {
+		return err
+	}
+	if err := o.upper.create(p, isDir); err != nil {
+		return err
+	}
+	o.unhide(p, isDir)
+	return nil
+}
+
+// upperDir creates the dir p and its parents in the upper layer if they're missing. Must be called
+// with mu held.
+func (o *Overlay) upperDir(p string) error {
+	if p == fspath.Root {
+		return nil
+	}
+	if err := o.upperDir(fspath.Dir(p)); err != nil {
+		return err
+	}
+	if err := o.upper.create(p, true); err != nil && !errors.Is(err, ErrAlreadyExist) {
+		return err
+	}
+	return nil
+}
+
+// copyUp copies the file p from the lower layer to the upper one if it's not already there. Must be
+// called with mu held.
+func (o *Overlay) copyUp(p string) error {
+	isDir, ok := o.stat(p)
+	if !ok {
+		return ErrNotFound
+	}
+	if _, ok := o.upper.stat(p); ok || isDir {
+		return nil
+	}
+	buf := bytes.NewBuffer(nil)
+	if _, err := o.lower.Read(p, buf); err != nil {
+		return err
+	}
+	if err := o.upperDir(fspath.Dir(p)); err != nil {
+		return err
+	}
+	if err := o.upper.create(p, false); err != nil {
+		return err
+	}
+	_, err := o.upper.Write(p, buf)
+	return err
+}
+
+// stat returns whether the absolute path p exists and if it's a dir.
+func (fs *FileSystem) stat(p string) (bool, bool) {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+	for _, s := range []string{p, fs.normalizeDirPath(p)} {
+		if node := fs.findNode(s); node != nil && node.Meta() != nil {
+			_, isDir := node.Meta().(*Dir)
+			return isDir, true
+		}
+	}
+	return false, false
+}
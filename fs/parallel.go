@@ -0,0 +1,87 @@
+package fs
+
+import (
+	"sync"
+)
+
+// parallel calls fn with 0 to n-1 on up to Opts.Parallelism goroutines and returns the first
+// error. Calls that haven't started yet are skipped once one fails.
+func (fs *FileSystem) parallel(n int, fn func(i int) error) error {
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		next int
+		err  error
+	)
+	workers := fs.parallelism
+	if workers > n {
+		workers = n
+	}
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				mu.Lock()
+				i := next
+				next++
+				stop := i >= n || err != nil
+				mu.Unlock()
+				if stop {
+					return
+				}
+				if e := fn(i); e != nil {
+					mu.Lock()
+					if err == nil {
+						err = e
+					}
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	return err
+}
+
+// result is what a call of ordered's fn returned.
+type result struct {
+	v   interface{}
+	err error
+}
+
+// ordered is like parallel, but calls done with what each call of fn returned in order, on the
+// calling goroutine. Calls of fn run at most Opts.Parallelism ahead of done, which bounds how many
+// results are held. Once done fails, the calls that haven't started are skipped and its error is
+// returned.
+func (fs *FileSystem) ordered(n int, fn func(i int) (interface{}, error), done func(i int, v interface{}, err error) error) error {
+	results := make([]chan result, n)
+	for i := range results {
+		results[i] = make(chan result, 1)
+	}
+	// Calls hold a slot from when they start until done takes their result.
+	slots := make(chan struct{}, fs.parallelism)
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		for i := 0; i < n; i++ {
+			select {
+			case slots <- struct{}{}:
+			case <-stop:
+				return
+			}
+			go func(i int) {
+				v, err := fn(i)
+				results[i] <- result{v: v, err: err}
+			}(i)
+		}
+	}()
+	for i := 0; i < n; i++ {
+		r := <-results[i]
+		<-slots
+		if err := done(i, r.v, r.err); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,383 @@
+package fs
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/basharal/filesystem/blob"
+	"github.com/basharal/filesystem/fspath"
+	"github.com/basharal/filesystem/trie"
+	"github.com/golang/glog"
+)
+
+// The namespace is persisted in the MetaStore as one record per dir, keyed by the dir's ID so that
+// moves only rewrite the records of the parents. Content is keyed by file IDs in the ContentStore,
+// so IDs are kept across restarts.
+const (
+	metaKey      = "namespace/meta"
+	dirKeyPrefix = "namespace/dirs/"
+
+	// persistedContentPrefix is where content goes when there's a MetaStore but no ContentStore.
+	persistedContentPrefix = "content/"
+)
+
+// metaRecord is the state of the filesystem that isn't in any dir.
+type metaRecord struct {
+	LastID uint64 `json:"last_id"`
+}
+
+// dirRecord lists the children of a dir.
+type dirRecord struct {
+	Files []fileRecord   `json:"files,omitempty"`
+	Dirs  []subdirRecord `json:"dirs,omitempty"`
+}
+
+type fileRecord struct {
+	Name     string    `json:"name"`
+	ID       uint64    `json:"id"`
+	Size     int64     `json:"size"`
+	Created  time.Time `json:"created"`
+	Modified time.Time `json:"modified"`
+	Accessed time.Time `json:"accessed"`
+}
+
+// subdirRecord has the usage of the subdir, so that it doesn't have to be loaded to be listed.
+type subdirRecord struct {
+	Name    string    `json:"name"`
+	ID      uint64    `json:"id"`
+	Created time.Time `json:"created"`
+	Files   int64     `json:"files"`
+	Dirs    int64     `json:"dirs"`
+	Size    int64     `json:"size"`
+}
+
+// persister keeps track of what changed since the namespace was last synced to the MetaStore.
+type persister struct {
+	store blob.Store
+
+	// loading serializes loading dirs from the store.
+	loading sync.Mutex
+
+	// syncing serializes syncs.
+	syncing sync.Mutex
+
+	// mu protects below.
+	mu sync.Mutex
+	// dirty are the absolute paths of dirs whose children changed.
+	dirty map[string]bool
+	// removed are the IDs of removed dirs whose records must be deleted.
+	removed []uint64
+}
+
+func newPersister(store blob.Store) *persister {
+	return &persister{store: store, dirty: make(map[string]bool)}
+}
+
+func dirKey(id uint64) string {
+	return dirKeyPrefix + strconv.FormatUint(id, 10)
+}
+
+func (p *persister) get(key string, v interface{}) error {
+	var buf bytes.Buffer
+	if _, err := p.store.Get(key, &buf); err != nil {
+		return err
+	}
+	return json.Unmarshal(buf.Bytes(), v)
+}
+
+func (p *persister) put(key string, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = p.store.Put(key, bytes.NewReader(b))
+	return err
+}
+
+// changed records that the file/dir at the absolute path changed. Its parent's record has to be
+// rewritten, and so do the records of the other dirs containing it, since they have its size.
+func (fs *FileSystem) changed(path string) {
+	if fs.persist == nil || path == fspath.Root {
+		return
+	}
+	fs.persist.markDirty(fspath.Dir(path))
+}
+
+// markDirty records that the dir at the absolute path and the dirs containing it must be synced.
+func (p *persister) markDirty(dir string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for {
+		p.dirty[dir] = true
+		if dir == fspath.Root {
+			return
+		}
+		dir = fspath.Dir(dir)
+	}
+}
+
+// moved records that the dir at the absolute path src was moved to dst, so that the changes under
+// it that weren't synced yet are synced at dst.
+func (fs *FileSystem) moved(src, dst string) {
+	if fs.persist == nil {
+		return
+	}
+	fs.persist.mu.Lock()
+	defer fs.persist.mu.Unlock()
+	for path := range fs.persist.dirty {
+		if rel, ok := fspath.TrimPrefix(path, src); ok {
+			delete(fs.persist.dirty, path)
+			fs.persist.dirty[fspath.Join(dst, rel)] = true
+		}
+	}
+}
+
+// forget records that dir was removed.
+func (fs *FileSystem) forget(dir *Dir) {
+	if fs.persist == nil {
+		return
+	}
+	fs.persist.mu.Lock()
+	fs.persist.removed = append(fs.persist.removed, dir.md.id)
+	fs.persist.mu.Unlock()
+}
+
+// loadAlong loads the dirs that finding path from n goes through (i.e., n, a/ and a/b/ for
+// a/b/c). mu must be held, for reading at least.
+func (fs *FileSystem) loadAlong(n *trie.Node, path string) {
+	fs.loadNode(n)
+	for i := 0; i < len(path)-1; i++ {
+		if path[i] != Separator {
+			continue
+		}
+		child, ok := fs.trie.FindAtNode(path[:i+1], n)
+		if !ok {
+			return
+		}
+		fs.loadNode(child)
+	}
+}
+
+// loadNode loads the children of the dir at n from the store if they haven't been yet. mu must be
+// held, for reading at least. Readers can load concurrently since the trie is thread-safe and
+// loads are serialized.
+func (fs *FileSystem) loadNode(n *trie.Node) {
+	if fs.persist == nil {
+		return
+	}
+	dir, ok := n.Meta().(*Dir)
+	if !ok || atomic.LoadInt32(&dir.unloaded) == 0 {
+		return
+	}
+	fs.persist.loading.Lock()
+	defer fs.persist.loading.Unlock()
+	if atomic.LoadInt32(&dir.unloaded) == 0 {
+		return
+	}
+	if err := fs.restore(dir); err != nil {
+		glog.Errorf("Failed to load %s. %s\n", dir.Path(), err)
+		return
+	}
+	atomic.StoreInt32(&dir.unloaded, 0)
+}
+
+// loadTree loads everything under n.
+func (fs *FileSystem) loadTree(n *trie.Node) {
+	if fs.persist == nil {
+		return
+	}
+	_, children, err := fs.listAtNode(n)
+	if err != nil {
+		return
+	}
+	for _, child := range children {
+		if _, ok := child.Meta().(*Dir); ok {
+			fs.loadTree(child)
+		}
+	}
+}
+
+// listAtNode lists the children of n after loading them.
+func (fs *FileSystem) listAtNode(n *trie.Node) ([]string, []*trie.Node, error) {
+	fs.loadNode(n)
+	return fs.trie.ListAtNode(n)
+}
+
+// restore adds the children of dir from its record. The usage of dir is already known from its
+// parent's record, so it's left as is, except for the root.
+func (fs *FileSystem) restore(dir *Dir) error {
+	if dir == fs.root {
+		var meta metaRecord
+		if err := fs.persist.get(metaKey, &meta); err != nil && !errors.Is(err, blob.ErrNotFound) {
+			return err
+		}
+		for {
+			last := atomic.LoadUint64(&fs.lastID)
+			if meta.LastID <= last || atomic.CompareAndSwapUint64(&fs.lastID, last, meta.LastID) {
+				break
+			}
+		}
+	}
+	var rec dirRecord
+	if err := fs.persist.get(dirKey(dir.md.id), &rec); err != nil {
+		if errors.Is(err, blob.ErrNotFound) {
+			// Never synced.
+			return nil
+		}
+		return err
+	}
+	n := dir.md.node
+	var size int64
+	for _, r := range rec.Files {
+		file := newFile(fs)
+		file.md.id = r.ID
+		file.md.created = unixNano(r.Created)
+		file.size = r.Size
+		file.modified = r.Modified
+		file.accessed = r.Accessed.UnixNano()
+		file.accounted = r.Size
+		added := fs.trie.AddAtNode(r.Name, n, file)
+		file.md.setNode(added)
+		fs.indexAdd(file.Path(), file)
+		size += r.Size
+	}
+	for _, r := range rec.Dirs {
+		child := newDir(fs)
+		child.md.id = r.ID
+		child.md.created = unixNano(r.Created)
+		child.files, child.dirs, child.size = r.Files, r.Dirs, r.Size
+		child.unloaded = 1
+		added := fs.trie.AddAtNode(r.Name+SeperatorStr, n, child)
+		child.md.setNode(added)
+		fs.indexAdd(child.Path(), child)
+		size += r.Size
+	}
+	if dir == fs.root {
+		atomic.StoreInt64(&dir.files, int64(len(rec.Files)))
+		atomic.StoreInt64(&dir.dirs, int64(len(rec.Dirs)))
+		atomic.StoreInt64(&dir.size, size)
+	}
+	return nil
+}
+
+// Sync writes the changes since the last sync to the MetaStore. It's a no-op without one. Changes
+// made while syncing are left for the next sync.
+func (fs *FileSystem) Sync() error {
+	p := fs.persist
+	if p == nil {
+		return nil
+	}
+	p.syncing.Lock()
+	defer p.syncing.Unlock()
+
+	p.mu.Lock()
+	dirty, removed := p.dirty, p.removed
+	p.dirty, p.removed = make(map[string]bool), nil
+	p.mu.Unlock()
+
+	paths := make([]string, 0, len(dirty))
+	for path := range dirty {
+		paths = append(paths, path)
+	}
+	// Records are written in parallel, which stores like blob.Bolt commit together.
+	var (
+		mu     sync.Mutex
+		failed error
+	)
+	fs.parallel(len(paths), func(i int) error {
+		if err := fs.syncDir(paths[i]); err != nil {
+			glog.Errorf("Failed to sync %s. %s\n", paths[i], err)
+			mu.Lock()
+			failed = err
+			mu.Unlock()
+			// Retried on the next sync.
+			p.markDirty(paths[i])
+		}
+		return nil
+	})
+	for _, id := range removed {
+		if err := p.store.Delete(dirKey(id)); err != nil {
+			glog.Errorf("Failed to delete the record of dir %d. %s\n", id, err)
+			failed = err
+		}
+	}
+	if err := p.put(metaKey, metaRecord{LastID: atomic.LoadUint64(&fs.lastID)}); err != nil {
+		return err
+	}
+	return failed
+}
+
+// syncDir writes the record of the dir at the absolute path. Dirs that were removed since are
+// skipped, while dirs that failed to load fail.
+func (fs *FileSystem) syncDir(path string) error {
+	if path != fspath.Root {
+		path += SeperatorStr
+	}
+	// Sizes and times are read after mu is released, since writes hold the files' locks while
+	// waiting for mu. IDs are read before, since ApplyManifest changes them under mu.
+	fs.mu.RLock()
+	node := fs.findNode(path)
+	var dir *Dir
+	var children []*trie.Node
+	if node != nil {
+		dir, _ = node.Meta().(*Dir)
+	}
+	if dir != nil {
+		var err error
+		if _, children, err = fs.listAtNode(node); err != nil {
+			fs.mu.RUnlock()
+			return err
+		}
+		// Dirs that failed to load look empty, so writing their record would lose their children.
+		if atomic.LoadInt32(&dir.unloaded) != 0 {
+			fs.mu.RUnlock()
+			return fmt.Errorf("%s failed to load, so its record is kept", dir.Path())
+		}
+	}
+	names := make([]string, len(children))
+	metas := make([]interface{}, len(children))
+	ids := make([]uint64, len(children))
+	for i, child := range children {
+		names[i] = fspath.Base(nodePath(child))
+		metas[i] = child.Meta()
+		if file, ok := metas[i].(*File); ok {
+			ids[i] = file.md.id
+		}
+	}
+	fs.mu.RUnlock()
+	if dir == nil {
+		return nil
+	}
+
+	var rec dirRecord
+	for i, meta := range metas {
+		switch meta := meta.(type) {
+		case *File:
+			rec.Files = append(rec.Files, fileRecord{
+				Name:     names[i],
+				ID:       ids[i],
+				Size:     meta.Size(),
+				Created:  meta.md.Created(),
+				Modified: meta.ModTime(),
+				Accessed: meta.AccessTime(),
+			})
+		case *Dir:
+			usage := meta.Usage()
+			rec.Dirs = append(rec.Dirs, subdirRecord{
+				Name:    names[i],
+				ID:      meta.md.id,
+				Created: meta.md.Created(),
+				Files:   usage.Files,
+				Dirs:    usage.Dirs,
+				Size:    usage.Size,
+			})
+		}
+	}
+	return fs.persist.put(dirKey(dir.md.id), rec)
+}
@@ -0,0 +1,39 @@
+package fs
+
+import "fmt"
+
+// Preallocate makes room for size more bytes of content in the file at s (relative/absolute), so
+// that appending them doesn't grow and copy the content repeatedly. It's only a hint: the file
+// isn't changed, and nothing is reserved for mounted files, files in a ContentStore or offloaded
+// ones.
+func (fs *FileSystem) Preallocate(s string, size int64) (err error) {
+	defer wrapPathError(&err, "preallocate", s)
+	if _, _, ok := fs.mounted(s); ok {
+		return nil
+	}
+	fs.mu.RLock()
+	node := fs.findNode(s)
+	fs.mu.RUnlock()
+	if node == nil {
+		return ErrNotFound
+	}
+	file, ok := node.Meta().(*File)
+	if !ok {
+		return fmt.Errorf("cannot preallocate directories")
+	}
+	if fs.content != nil || size <= 0 {
+		return nil
+	}
+	file.mu.Lock()
+	defer file.mu.Unlock()
+	if file.archive != nil || file.source != "" {
+		return nil
+	}
+	if int64(cap(file.content)-len(file.content)) >= size {
+		return nil
+	}
+	content := make([]byte, len(file.content), int64(len(file.content))+size)
+	copy(content, file.content)
+	file.content = content
+	return nil
+}
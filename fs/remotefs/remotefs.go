@@ -0,0 +1,251 @@
+// Package remotefs implements fs.Interface over a client.Client, so that anything written against
+// the interface (i.e., the local CLI) can operate on a distributed cluster.
+package remotefs
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/basharal/filesystem/client"
+	"github.com/basharal/filesystem/fs"
+	"github.com/basharal/filesystem/fspath"
+	"github.com/basharal/filesystem/proto/pb_filesystem"
+)
+
+// maxFindResults bounds how many paths Find looks at.
+const maxFindResults = 1000
+
+type Opts struct {
+	// Timeout bounds each operation, including streaming reads/writes. Defaults to no timeout.
+	Timeout time.Duration
+}
+
+// FileSystem is a filesystem backed by a cluster. The current dir is kept locally. Modification
+// times aren't known, so files report zero ones.
+type FileSystem struct {
+	client  *client.Client
+	timeout time.Duration
+
+	// mu protects below.
+	mu sync.RWMutex
+	// currentDir is absolute.
+	currentDir string
+}
+
+var _ fs.Interface = (*FileSystem)(nil)
+
+// New returns a filesystem backed by c. c must be dialed already.
+func New(c *client.Client, opts Opts) *FileSystem {
+	return &FileSystem{client: c, timeout: opts.Timeout, currentDir: fspath.Root}
+}
+
+func (r *FileSystem) context() (context.Context, context.CancelFunc) {
+	if r.timeout > 0 {
+		return context.WithTimeout(context.Background(), r.timeout)
+	}
+	return context.WithCancel(context.Background())
+}
+
+// abs returns the absolute path of p (relative/absolute).
+func (r *FileSystem) abs(p string) string {
+	if fspath.IsAbs(p) {
+		return fspath.Clean(p)
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return fspath.Join(r.currentDir, p)
+}
+
+func toFile(f *pb_filesystem.File) *fs.File {
+	return fs.NewFileEntry(f.Path, f.Size, time.Time{})
+}
+
+func toDir(d *pb_filesystem.Dir) *fs.Dir {
+	return fs.NewDirEntry(d.Path, fs.DirUsage{Files: d.Files, Dirs: d.Dirs, Size: d.Size})
+}
+
+// CurrentDir returns the absolute path of the current directory.
+func (r *FileSystem) CurrentDir() string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.currentDir
+}
+
+// ChangeDir switches the current directory to p (relative/absolute).
+func (r *FileSystem) ChangeDir(p string) error {
+	abs := r.abs(p)
+	_, dir, err := r.Stat(abs)
+	if err != nil {
+		return err
+	}
+	if dir == nil {
+		return &fs.PathError{Op: "chdir", Path: p, Err: fs.ErrNotFound}
+	}
+	r.mu.Lock()
+	r.currentDir = abs
+	r.mu.Unlock()
+	return nil
+}
+
+// ListDir lists the files/dirs in p (relative/absolute) on all the servers it spans.
+func (r *FileSystem) ListDir(p string) ([]*fs.File, []*fs.Dir, error) {
+	ctx, cancel := r.context()
+	defer cancel()
+	pbFiles, pbDirs, err := r.client.ListDir(ctx, r.abs(p))
+	if err != nil {
+		return nil, nil, err
+	}
+	files := make([]*fs.File, 0, len(pbFiles))
+	for _, f := range pbFiles {
+		files = append(files, toFile(f))
+	}
+	dirs := make([]*fs.Dir, 0, len(pbDirs))
+	for _, d := range pbDirs {
+		dirs = append(dirs, toDir(d))
+	}
+	return files, dirs, nil
+}
+
+// Stat returns the file or dir at p (relative/absolute).
+func (r *FileSystem) Stat(p string) (*fs.File, *fs.Dir, error) {
+	ctx, cancel := r.context()
+	defer cancel()
+	file, dir, err := r.client.Stat(ctx, r.abs(p))
+	if err != nil {
+		return nil, nil, err
+	}
+	if file != nil {
+		return toFile(file), nil, nil
+	}
+	return nil, toDir(dir), nil
+}
+
+// Find returns the files/dirs under p (relative/absolute) named search. Only the first
+// maxFindResults are returned.
+func (r *FileSystem) Find(p, search string) ([]*fs.File, []*fs.Dir, error) {
+	ctx, cancel := r.context()
+	defer cancel()
+	regex := fspath.SeparatorStr + regexp.QuoteMeta(search) + fspath.SeparatorStr + "?$"
+	paths, err := r.client.FindFirstRegex(ctx, r.abs(p), regex, maxFindResults)
+	if err != nil {
+		return nil, nil, err
+	}
+	var files []*fs.File
+	var dirs []*fs.Dir
+	for _, path := range paths {
+		if strings.HasSuffix(path, fspath.SeparatorStr) {
+			dirs = append(dirs, fs.NewDirEntry(fspath.Clean(path), fs.DirUsage{}))
+			continue
+		}
+		file, _, err := r.client.Stat(ctx, path)
+		if err != nil {
+			return nil, nil, err
+		}
+		files = append(files, toFile(file))
+	}
+	return files, dirs, nil
+}
+
+// FindFirstRegex returns the first absolute path under p (relative/absolute) matching the regex.
+func (r *FileSystem) FindFirstRegex(p, regex string) (string, error) {
+	res, err := r.FindRegex(p, regex, 1)
+	if err != nil || len(res.Paths) == 0 {
+		return "", err
+	}
+	return res.Paths[0], nil
+}
+
+// FindRegex returns up to max absolute paths under p (relative/absolute) that match the regex.
+// Dirs end with a '/'. The number of visited files/dirs isn't known.
+func (r *FileSystem) FindRegex(p, regex string, max int) (fs.RegexResult, error) {
+	if max <= 0 {
+		return fs.RegexResult{}, fmt.Errorf("max results must be positive")
+	}
+	ctx, cancel := r.context()
+	defer cancel()
+	paths, err := r.client.FindFirstRegex(ctx, r.abs(p), regex, max)
+	return fs.RegexResult{Paths: paths}, err
+}
+
+// MakeDir creates the dir at p (relative/absolute).
+func (r *FileSystem) MakeDir(p string) error {
+	ctx, cancel := r.context()
+	defer cancel()
+	return r.client.MakeDir(ctx, r.abs(p))
+}
+
+// NewFile creates an empty file at p (relative/absolute).
+func (r *FileSystem) NewFile(p string) error {
+	ctx, cancel := r.context()
+	defer cancel()
+	return r.client.CreateFile(ctx, r.abs(p))
+}
+
+// TouchFile creates the file at p (relative/absolute) if it doesn't exist. Otherwise, it updates
+// its modification time.
+func (r *FileSystem) TouchFile(p string) error {
+	ctx, cancel := r.context()
+	defer cancel()
+	return r.client.TouchFile(ctx, r.abs(p))
+}
+
+// Remove removes the file or empty dir at p (relative/absolute).
+func (r *FileSystem) Remove(p string) error {
+	ctx, cancel := r.context()
+	defer cancel()
+	return r.client.Remove(ctx, r.abs(p))
+}
+
+// DeletePrefix removes p (relative/absolute) and everything under it on all the servers it spans.
+func (r *FileSystem) DeletePrefix(p string, dryRun bool) (int, error) {
+	ctx, cancel := r.context()
+	defer cancel()
+	return r.client.DeletePrefix(ctx, r.abs(p), dryRun)
+}
+
+// Move moves the file at src to dst by copying it and removing src, since servers can't move.
+// Dirs can't be moved. src/dst are relative or absolute.
+func (r *FileSystem) Move(src, dst string) error {
+	file, _, err := r.Stat(src)
+	if err != nil {
+		return err
+	}
+	if file == nil {
+		return &fs.PathError{Op: "move", Path: src, Err: fs.ErrNotSupported}
+	}
+	if err := r.Copy(src, dst); err != nil {
+		return err
+	}
+	return r.Remove(src)
+}
+
+// Copy copies the file at src to a new file at dst. src/dst are relative or absolute.
+func (r *FileSystem) Copy(src, dst string) error {
+	ctx, cancel := r.context()
+	defer cancel()
+	return r.client.Copy(ctx, r.abs(src), r.abs(dst))
+}
+
+// Read streams the content of the file at p (relative/absolute) to writer.
+func (r *FileSystem) Read(p string, writer io.Writer) (int64, error) {
+	ctx, cancel := r.context()
+	defer cancel()
+	return r.client.Read(ctx, r.abs(p), writer)
+}
+
+// Write appends reader's content to the file at p (relative/absolute).
+func (r *FileSystem) Write(p string, reader io.Reader) (int64, error) {
+	ctx, cancel := r.context()
+	defer cancel()
+	return r.client.Write(ctx, r.abs(p), reader)
+}
+
+// Limits returns no limits. Servers enforce their own.
+func (r *FileSystem) Limits() fs.Limits {
+	return fs.Limits{}
+}
@@ -0,0 +1,176 @@
+package remotefs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/basharal/filesystem/client"
+	"github.com/basharal/filesystem/fs"
+	"github.com/basharal/filesystem/server"
+	"google.golang.org/grpc"
+)
+
+// createTestFS returns a filesystem backed by a cluster of two servers, [a, n) and [n, {).
+func createTestFS(t *testing.T) *FileSystem {
+	t.Helper()
+	var servers []client.Server
+	for _, r := range [][2]string{{"a", "n"}, {"n", "{"}} {
+		s, err := server.New(server.Opts{StartPrefix: r[0], EndPrefix: r[1]})
+		if err != nil {
+			t.Fatal(err)
+		}
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		g := grpc.NewServer()
+		s.RegisterWith(g)
+		go g.Serve(lis)
+		t.Cleanup(g.Stop)
+		servers = append(servers, client.Server{StartPrefix: r[0], EndPrefix: r[1], Addr: lis.Addr().String()})
+	}
+	c, err := client.New(client.Opts{Servers: servers})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Dial(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return New(c, Opts{Timeout: 5 * time.Second})
+}
+
+// create creates the file at p through the client, making its parents, which the servers' filesystems
+// can't do on their own.
+func create(t *testing.T, r *FileSystem, p string) {
+	t.Helper()
+	ctx, cancel := r.context()
+	defer cancel()
+	if err := r.client.CreateFile(ctx, p, client.Parents()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func read(t *testing.T, r *FileSystem, p string) string {
+	t.Helper()
+	buf := bytes.NewBuffer(nil)
+	if _, err := r.Read(p, buf); err != nil {
+		t.Fatalf("FileSystem.Read(%s) error = %v", p, err)
+	}
+	return buf.String()
+}
+
+func TestFileSystem_Ops(t *testing.T) {
+	r := createTestFS(t)
+	if err := r.MakeDir("/apple"); err != nil {
+		t.Fatalf("FileSystem.MakeDir() error = %v", err)
+	}
+	if err := r.ChangeDir("apple"); err != nil || r.CurrentDir() != "/apple" {
+		t.Fatalf("FileSystem.ChangeDir(apple) error = %v, in %s", err, r.CurrentDir())
+	}
+	if err := r.NewFile("/banana"); err != nil {
+		t.Fatalf("FileSystem.NewFile() error = %v", err)
+	}
+	if err := r.NewFile("/banana"); !errors.Is(err, fs.ErrAlreadyExist) {
+		t.Errorf("FileSystem.NewFile() error = %v, want %v", err, fs.ErrAlreadyExist)
+	}
+	create(t, r, "/apple/f")
+	create(t, r, "/apple/g")
+	if _, err := r.Write("f", strings.NewReader("hello")); err != nil {
+		t.Fatalf("FileSystem.Write() error = %v", err)
+	}
+	if got := read(t, r, "/apple/f"); got != "hello" {
+		t.Errorf("FileSystem.Read(/apple/f) = %q, want hello", got)
+	}
+	if file, _, err := r.Stat("f"); err != nil || file == nil || file.Size() != 5 || file.Path() != "/apple/f" {
+		t.Errorf("FileSystem.Stat(f) = %v, %v, want /apple/f of 5 bytes", file, err)
+	}
+	if _, dir, err := r.Stat("/apple"); err != nil || dir == nil {
+		t.Errorf("FileSystem.Stat(/apple) = %v, %v, want the dir", dir, err)
+	}
+	if err := r.TouchFile("/banana"); err != nil {
+		t.Errorf("FileSystem.TouchFile(/banana) error = %v", err)
+	}
+	// Only dirs can be changed to.
+	for _, p := range []string{"f", "/missing"} {
+		if err := r.ChangeDir(p); !errors.Is(err, fs.ErrNotFound) {
+			t.Errorf("FileSystem.ChangeDir(%s) error = %v, want %v", p, err, fs.ErrNotFound)
+		}
+	}
+	if r.CurrentDir() != "/apple" {
+		t.Errorf("FileSystem.CurrentDir() = %s after failed changes, want /apple", r.CurrentDir())
+	}
+
+	// Files are copied and moved across servers.
+	if err := r.Copy("f", "/zoo"); err != nil {
+		t.Fatalf("FileSystem.Copy() error = %v", err)
+	}
+	if got := read(t, r, "/zoo"); got != "hello" {
+		t.Errorf("FileSystem.Read(/zoo) = %q, want hello", got)
+	}
+	if err := r.Move("/zoo", "/nut"); err != nil {
+		t.Fatalf("FileSystem.Move() error = %v", err)
+	}
+	if _, _, err := r.Stat("/zoo"); !errors.Is(err, fs.ErrNotFound) {
+		t.Errorf("FileSystem.Stat(/zoo) error = %v after the move, want %v", err, fs.ErrNotFound)
+	}
+	if err := r.Remove("/banana"); err != nil {
+		t.Errorf("FileSystem.Remove(/banana) error = %v", err)
+	}
+	if err := r.Move("/apple", "/banana"); !errors.Is(err, fs.ErrNotSupported) {
+		t.Errorf("FileSystem.Move(/apple) error = %v, want %v", err, fs.ErrNotSupported)
+	}
+
+	// The root is listed on both servers.
+	files, dirs, err := r.ListDir("..")
+	if err != nil || len(files) != 1 || files[0].Path() != "/nut" || len(dirs) != 1 || dirs[0].Path() != "/apple" {
+		t.Errorf("FileSystem.ListDir(..) = %v, %v, %v, want /nut and /apple", files, dirs, err)
+	}
+
+	if err := r.Remove("g"); err != nil {
+		t.Errorf("FileSystem.Remove(g) error = %v", err)
+	}
+	// /apple, /apple/f, /apple/x and /apple/x/y.
+	create(t, r, "/apple/x/y")
+	if n, err := r.DeletePrefix("/apple", true); err != nil || n != 4 {
+		t.Errorf("FileSystem.DeletePrefix(/apple, dry run) = %d, %v, want 4", n, err)
+	}
+	if n, err := r.DeletePrefix("/apple", false); err != nil || n != 4 {
+		t.Errorf("FileSystem.DeletePrefix(/apple) = %d, %v, want 4", n, err)
+	}
+	if _, _, err := r.Stat("/apple/f"); !errors.Is(err, fs.ErrNotFound) {
+		t.Errorf("FileSystem.Stat(/apple/f) error = %v, want %v", err, fs.ErrNotFound)
+	}
+}
+
+func TestFileSystem_Find(t *testing.T) {
+	r := createTestFS(t)
+	for _, file := range []string{"/apple/f", "/apple/g", "/nut/f/x"} {
+		create(t, r, file)
+	}
+	files, dirs, err := r.Find("/", "f")
+	if err != nil || len(files) != 1 || files[0].Path() != "/apple/f" || len(dirs) != 1 || dirs[0].Path() != "/nut/f" {
+		t.Errorf("FileSystem.Find(/, f) = %v, %v, %v, want /apple/f and /nut/f", files, dirs, err)
+	}
+
+	res, err := r.FindRegex("/", "/[fg]$", 10)
+	sort.Strings(res.Paths)
+	if err != nil || strings.Join(res.Paths, ",") != "/apple/f,/apple/g" {
+		t.Errorf("FileSystem.FindRegex() = %v, %v, want /apple/f and /apple/g", res.Paths, err)
+	}
+	if _, err := r.FindRegex("/", "f", 0); err == nil {
+		t.Errorf("FileSystem.FindRegex() succeeded without results allowed")
+	}
+	if p, err := r.FindFirstRegex("/apple", "/g$"); err != nil || p != "/apple/g" {
+		t.Errorf("FileSystem.FindFirstRegex() = %s, %v, want /apple/g", p, err)
+	}
+	if p, err := r.FindFirstRegex("/apple", "missing"); err != nil || p != "" {
+		t.Errorf("FileSystem.FindFirstRegex() = %s, %v, want no match", p, err)
+	}
+}
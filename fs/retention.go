@@ -0,0 +1,94 @@
+package fs
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// RetentionPolicy bounds the files kept directly inside a directory. Zero values disable the
+// corresponding rule.
+type RetentionPolicy struct {
+	// MaxAge removes files that haven't been modified for longer than MaxAge.
+	MaxAge time.Duration
+
+	// MaxFiles keeps at most MaxFiles files, removing the least recently modified ones.
+	MaxFiles int
+}
+
+// IsZero returns true if the policy has no rules.
+func (p RetentionPolicy) IsZero() bool {
+	return p.MaxAge == 0 && p.MaxFiles == 0
+}
+
+// SetRetention sets the retention policy for the dir at s (relative/absolute). A zero policy
+// removes any existing policy.
+func (fs *FileSystem) SetRetention(s string, policy RetentionPolicy) (err error) {
+	defer wrapPathError(&err, "retention", s)
+	if policy.MaxAge < 0 || policy.MaxFiles < 0 {
+		return fmt.Errorf("retention rules must not be negative")
+	}
+	s = fs.normalizeDirPath(s)
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	node := fs.findNode(s)
+	if node == nil {
+		return ErrNotFound
+	}
+	dir, ok := node.Meta().(*Dir)
+	if !ok {
+		return fmt.Errorf("directory expected. file given")
+	}
+	if policy.IsZero() {
+		delete(fs.retention, dir)
+		return nil
+	}
+	fs.retention[dir] = policy
+	return nil
+}
+
+// Retention returns the retention policies of all dirs, keyed by their absolute path.
+func (fs *FileSystem) Retention() map[string]RetentionPolicy {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+	policies := make(map[string]RetentionPolicy, len(fs.retention))
+	for dir, policy := range fs.retention {
+		policies[dir.Path()] = policy
+	}
+	return policies
+}
+
+// ApplyRetention removes the files violating retention policies as of now. It returns the
+// absolute paths of the removed files.
+func (fs *FileSystem) ApplyRetention(now time.Time) ([]string, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	removed := make([]string, 0)
+	for dir, policy := range fs.retention {
+		_, nodes, err := fs.listAtNode(dir.md.node)
+		if err != nil {
+			return removed, err
+		}
+		files, _ := convertNodes(nodes)
+
+		// Newest first so that the ones exceeding MaxFiles are at the end.
+		sort.Slice(files, func(i, j int) bool {
+			return files[i].ModTime().After(files[j].ModTime())
+		})
+		for i, file := range files {
+			expired := policy.MaxAge > 0 && now.Sub(file.ModTime()) > policy.MaxAge
+			excess := policy.MaxFiles > 0 && i >= policy.MaxFiles
+			if !expired && !excess {
+				continue
+			}
+			if fs.busy(file) != nil {
+				continue
+			}
+			removed = append(removed, file.Path())
+			fs.removeNode(file.md.node)
+		}
+	}
+	return removed, nil
+}
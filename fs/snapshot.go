@@ -0,0 +1,308 @@
+package fs
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/basharal/filesystem/fspath"
+	"github.com/basharal/filesystem/trie"
+)
+
+// Snapshots are tar archives. File headers carry the IDs of the files, so that incremental
+// snapshots can leave out the content of unchanged files, even moved ones, and have it restored
+// from the snapshots before them.
+const (
+	paxID        = "FILESYSTEM.id"
+	paxSize      = "FILESYSTEM.size"
+	paxUnchanged = "FILESYSTEM.unchanged"
+)
+
+// snapshotEntry is a file/dir captured by Snapshot, with the ID of the file when it was.
+type snapshotEntry struct {
+	path string
+	file *File
+	dir  *Dir
+	id   uint64
+}
+
+// collect appends everything under n to entries, parents before their children. Must be called
+// with mu held.
+func (fs *FileSystem) collect(n *trie.Node, entries []snapshotEntry) ([]snapshotEntry, error) {
+	_, nodes, err := fs.listAtNode(n)
+	if err != nil {
+		return nil, err
+	}
+	files, dirs := convertNodes(nodes)
+	for _, f := range files {
+		entries = append(entries, snapshotEntry{path: f.Path(), file: f, id: f.md.id})
+	}
+	for _, d := range dirs {
+		entries = append(entries, snapshotEntry{path: d.Path(), dir: d})
+		if entries, err = fs.collect(d.md.node, entries); err != nil {
+			return nil, err
+		}
+	}
+	return entries, nil
+}
+
+// Snapshot writes the namespace and the content of its files to w as a tar archive, which
+// RestoreSnapshot loads. Mounted backends aren't included. The structure is captured at once,
+// while the content of each file is captured when it's written, so writes during a snapshot may
+// or may not be included. Reading files for a snapshot doesn't count as accessing them.
+func (fs *FileSystem) Snapshot(w io.Writer) error {
+	return fs.snapshot(w, time.Time{})
+}
+
+// SnapshotSince is like Snapshot, but only includes the content of the files created or modified
+// after since, which should be when the snapshot before it was started. The namespace is always
+// included in full. Restoring it requires the snapshots before it back to a full one (see
+// RestoreSnapshots).
+func (fs *FileSystem) SnapshotSince(w io.Writer, since time.Time) error {
+	return fs.snapshot(w, since)
+}
+
+// snapshot writes a snapshot with the content of the files changed after since, or all of them if
+// since is zero.
+func (fs *FileSystem) snapshot(w io.Writer, since time.Time) error {
+	fs.mu.RLock()
+	entries, err := fs.collect(fs.root.md.node, nil)
+	fs.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+
+	// Content is read in parallel, ahead of writing it in order.
+	unchanged := func(f *File) bool {
+		return !since.IsZero() && !f.ModTime().After(since) && !f.md.Created().After(since)
+	}
+	read := func(i int) (interface{}, error) {
+		e := entries[i]
+		if e.dir != nil || unchanged(e.file) {
+			return nil, nil
+		}
+		var buf bytes.Buffer
+		if _, err := e.file.read(&buf, false); err != nil {
+			return nil, fmt.Errorf("failed to snapshot %s. %w", e.path, err)
+		}
+		return buf.Bytes(), nil
+	}
+	tw := tar.NewWriter(w)
+	write := func(i int, content interface{}, err error) error {
+		if err != nil {
+			return err
+		}
+		e := entries[i]
+		name := strings.TrimPrefix(e.path, SeperatorStr)
+		if e.dir != nil {
+			hdr := &tar.Header{Typeflag: tar.TypeDir, Name: name + SeperatorStr, Mode: 0755, ModTime: e.dir.md.Created()}
+			return tw.WriteHeader(hdr)
+		}
+		hdr := &tar.Header{
+			Typeflag:   tar.TypeReg,
+			Name:       name,
+			Mode:       0644,
+			ModTime:    e.file.ModTime(),
+			AccessTime: e.file.AccessTime(),
+			Format:     tar.FormatPAX,
+			PAXRecords: map[string]string{paxID: strconv.FormatUint(e.id, 10)},
+		}
+		// Only unchanged files have no content. Empty ones have an empty slice.
+		if content == nil {
+			hdr.PAXRecords[paxUnchanged] = "true"
+			hdr.PAXRecords[paxSize] = strconv.FormatInt(e.file.Size(), 10)
+			return tw.WriteHeader(hdr)
+		}
+		data := content.([]byte)
+		hdr.Size = int64(len(data))
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		_, err = tw.Write(data)
+		return err
+	}
+	if err := fs.ordered(len(entries), read, write); err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+// RestoreOpts select what RestoreSnapshot restores and where.
+type RestoreOpts struct {
+	// Path is the absolute path of the file/dir in the snapshot to restore, with everything under
+	// it. Defaults to the root, which restores everything.
+	Path string
+
+	// Target is the absolute path Path is restored to (i.e., /projects/x.restored for
+	// /projects/x). Its parent must exist. Defaults to Path.
+	Target string
+}
+
+// SnapshotLayer opens one of the snapshots restored by RestoreSnapshots.
+type SnapshotLayer func() (io.ReadCloser, error)
+
+// RestoreSnapshot loads the part of a snapshot written by Snapshot that opts select, and returns
+// the number of restored files/dirs. The rest of the filesystem is left as is, so it can be used
+// on a live filesystem. Like LoadFromOS, existing dirs are merged into, while existing files
+// result in ErrAlreadyExist. If Path isn't in the snapshot, it returns ErrNotFound.
+func (fs *FileSystem) RestoreSnapshot(r io.Reader, opts RestoreOpts) (int, error) {
+	return fs.RestoreSnapshots([]SnapshotLayer{func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(r), nil
+	}}, opts)
+}
+
+// RestoreSnapshots is like RestoreSnapshot for a chain of snapshots, oldest first: a full one
+// written by Snapshot followed by incremental ones written by SnapshotSince. The namespace is
+// restored from the last one, while the content of each file comes from the latest snapshot that
+// has it. Older snapshots are only opened while content is missing.
+func (fs *FileSystem) RestoreSnapshots(layers []SnapshotLayer, opts RestoreOpts) (int, error) {
+	if len(layers) == 0 {
+		return 0, fmt.Errorf("no snapshots to restore")
+	}
+	if opts.Path == "" {
+		opts.Path = fspath.Root
+	}
+	if opts.Target == "" {
+		opts.Target = opts.Path
+	}
+	if !IsAbs(opts.Path) || !IsAbs(opts.Target) {
+		return 0, fmt.Errorf("restoring relative paths: %w", ErrInvalidName)
+	}
+	opts.Path, opts.Target = fspath.Clean(opts.Path), fspath.Clean(opts.Target)
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	// missing has the restored files whose content is in older snapshots, by ID.
+	missing := make(map[string]*restoredFile)
+	restored, err := fs.restoreLayer(layers[len(layers)-1], opts, missing)
+	if err != nil {
+		return restored, err
+	}
+	for i := len(layers) - 2; i >= 0 && len(missing) > 0; i-- {
+		if err := fs.restoreContent(layers[i], missing); err != nil {
+			return restored, err
+		}
+	}
+	if len(missing) > 0 {
+		return restored, fmt.Errorf("the snapshots are missing the content of %d files", len(missing))
+	}
+	return restored, nil
+}
+
+// restoredFile is a restored file whose content is in an older snapshot.
+type restoredFile struct {
+	file *File
+	hdr  *tar.Header
+}
+
+// restoreLayer restores the namespace of a snapshot with the content it has, and adds the files
+// whose content it doesn't have to missing. mu must be held.
+func (fs *FileSystem) restoreLayer(layer SnapshotLayer, opts RestoreOpts, missing map[string]*restoredFile) (int, error) {
+	r, err := layer()
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+	tr := tar.NewReader(r)
+	restored := 0
+	found := opts.Path == fspath.Root
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return restored, err
+		}
+		rel, ok := fspath.TrimPrefix(fspath.Join(fspath.Root, hdr.Name), opts.Path)
+		if !ok {
+			continue
+		}
+		found = true
+		path := fspath.Join(opts.Target, rel)
+		if path == fspath.Root {
+			continue
+		}
+		parent := fs.findNode(fs.normalizeDirPath(fspath.Dir(path)))
+		if parent == nil {
+			return restored, fmt.Errorf("failed to restore %s. %w", path, ErrNotFound)
+		}
+		name := fspath.Base(path)
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := fs.mkdirAtNode(name+SeperatorStr, parent); err != nil && !errors.Is(err, ErrAlreadyExist) {
+				return restored, fmt.Errorf("failed to restore %s. %w", path, err)
+			}
+		case tar.TypeReg:
+			if err := fs.newFileAtNode(name, parent); err != nil {
+				return restored, fmt.Errorf("failed to restore %s. %w", path, err)
+			}
+			child, _ := fs.trie.FindAtNode(name, parent)
+			file := child.Meta().(*File)
+			if hdr.PAXRecords[paxUnchanged] != "" {
+				missing[hdr.PAXRecords[paxID]] = &restoredFile{file: file, hdr: hdr}
+				break
+			}
+			if err := fs.restoreFile(file, hdr, tr); err != nil {
+				return restored, fmt.Errorf("failed to restore %s. %w", path, err)
+			}
+		default:
+			continue
+		}
+		restored++
+	}
+	if !found {
+		return 0, &PathError{Op: "restore", Path: opts.Path, Err: ErrNotFound}
+	}
+	return restored, nil
+}
+
+// restoreContent restores the content a snapshot has for missing files, and removes them from
+// missing. mu must be held.
+func (fs *FileSystem) restoreContent(layer SnapshotLayer, missing map[string]*restoredFile) error {
+	r, err := layer()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	tr := tar.NewReader(r)
+	for len(missing) > 0 {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		id := hdr.PAXRecords[paxID]
+		m, ok := missing[id]
+		if !ok || hdr.Typeflag != tar.TypeReg || hdr.PAXRecords[paxUnchanged] != "" {
+			continue
+		}
+		// The times are the ones in the latest snapshot.
+		if err := fs.restoreFile(m.file, m.hdr, tr); err != nil {
+			return fmt.Errorf("failed to restore %s. %w", m.file.Path(), err)
+		}
+		delete(missing, id)
+	}
+	return nil
+}
+
+// restoreFile writes the content in r to a restored file and sets its times from hdr. mu must be
+// held.
+func (fs *FileSystem) restoreFile(file *File, hdr *tar.Header, r io.Reader) error {
+	_, err := file.Write(r)
+	fs.accountLocked(file)
+	accessed := hdr.AccessTime
+	if accessed.IsZero() {
+		accessed = hdr.ModTime
+	}
+	file.updateTimes(accessed, hdr.ModTime)
+	return err
+}
@@ -0,0 +1,115 @@
+package sqlfs
+
+import (
+	"database/sql"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// defaultMaxBatch is how many mutations a group commit holds at most by default.
+const defaultMaxBatch = 128
+
+// Opts configure a FileSystem.
+type Opts struct {
+	// GroupCommit batches concurrent mutations into a single transaction, so that they share a
+	// commit (and its sync to disk). A batch is committed once its first mutation has waited this
+	// long or it holds MaxBatch mutations, so mutations take up to this much longer. Mutations
+	// failing in a batch don't affect the others. 0 commits every mutation on its own.
+	GroupCommit time.Duration
+
+	// MaxBatch is how many mutations a group commit holds at most. Defaults to 128.
+	MaxBatch int
+}
+
+// mutation is a transaction waiting for its group commit.
+type mutation struct {
+	fn   func(tx *sql.Tx) error
+	done chan error
+}
+
+// committer commits mutations in batches. See Opts.GroupCommit.
+type committer struct {
+	db       *sql.DB
+	delay    time.Duration
+	maxBatch int
+
+	// committing serializes commits, so that batches are applied in order.
+	committing sync.Mutex
+
+	// mu protects below.
+	mu      sync.Mutex
+	pending []*mutation
+	timer   *time.Timer
+}
+
+// do runs fn in the next batch and returns once the batch is committed.
+func (c *committer) do(fn func(tx *sql.Tx) error) error {
+	m := &mutation{fn: fn, done: make(chan error, 1)}
+	c.mu.Lock()
+	c.pending = append(c.pending, m)
+	switch {
+	case len(c.pending) >= c.maxBatch:
+		if c.timer != nil {
+			c.timer.Stop()
+			c.timer = nil
+		}
+		go c.flush()
+	case len(c.pending) == 1:
+		c.timer = time.AfterFunc(c.delay, c.flush)
+	}
+	c.mu.Unlock()
+	return <-m.done
+}
+
+// flush commits the pending mutations.
+func (c *committer) flush() {
+	c.committing.Lock()
+	defer c.committing.Unlock()
+	c.mu.Lock()
+	batch := c.pending
+	c.pending = nil
+	if c.timer != nil {
+		c.timer.Stop()
+		c.timer = nil
+	}
+	c.mu.Unlock()
+	if len(batch) == 0 {
+		return
+	}
+	errs, err := c.commit(batch)
+	for i, m := range batch {
+		if err != nil && errs[i] == nil {
+			errs[i] = err
+		}
+		m.done <- errs[i]
+	}
+}
+
+// commit runs batch in a single transaction, each mutation in a savepoint that's rolled back if
+// it fails. It returns the errors of the mutations and of the transaction.
+func (c *committer) commit(batch []*mutation) ([]error, error) {
+	errs := make([]error, len(batch))
+	tx, err := c.db.Begin()
+	if err != nil {
+		return errs, err
+	}
+	for i, m := range batch {
+		savepoint := fmt.Sprintf("m%d", i)
+		if _, err := tx.Exec(`SAVEPOINT ` + savepoint); err != nil {
+			tx.Rollback()
+			return errs, err
+		}
+		if errs[i] = m.fn(tx); errs[i] != nil {
+			if _, err := tx.Exec(`ROLLBACK TO ` + savepoint); err != nil {
+				tx.Rollback()
+				return errs, err
+			}
+		}
+		if _, err := tx.Exec(`RELEASE ` + savepoint); err != nil {
+			tx.Rollback()
+			return errs, err
+		}
+	}
+	return errs, tx.Commit()
+}
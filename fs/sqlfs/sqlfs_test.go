@@ -0,0 +1,174 @@
+package sqlfs
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/basharal/filesystem/fs"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func createTestFS(t *testing.T, opts Opts) *FileSystem {
+	s, err := OpenWithOpts(filepath.Join(t.TempDir(), "fs.db"), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestFileSystem_Ops(t *testing.T) {
+	s := createTestFS(t, Opts{})
+	if err := s.MakeDir("/foo"); err != nil {
+		t.Fatalf("FileSystem.MakeDir() error = %v", err)
+	}
+	if err := s.MakeDir("/x/y"); !errors.Is(err, fs.ErrNotFound) {
+		t.Errorf("FileSystem.MakeDir(/x/y) error = %v, want %v", err, fs.ErrNotFound)
+	}
+	if err := s.ChangeDir("foo"); err != nil {
+		t.Fatalf("FileSystem.ChangeDir() error = %v", err)
+	}
+	if err := s.NewFile("bar"); err != nil {
+		t.Fatalf("FileSystem.NewFile() error = %v", err)
+	}
+	var ee *fs.ExistsError
+	if err := s.NewFile("bar"); !errors.Is(err, fs.ErrAlreadyExist) || !errors.As(err, &ee) || ee.IsDir {
+		t.Errorf("FileSystem.NewFile() error = %v, want an *ExistsError for a file", err)
+	}
+	for _, c := range []string{"foo", "bar"} {
+		if _, err := s.Write("/foo/bar", strings.NewReader(c)); err != nil {
+			t.Fatalf("FileSystem.Write() error = %v", err)
+		}
+	}
+	// A failed write leaves the file as is.
+	abandoned := io.MultiReader(strings.NewReader("partial"), iotest.ErrReader(errors.New("client went away")))
+	if _, err := s.Write("/foo/bar", abandoned); err == nil {
+		t.Errorf("FileSystem.Write() succeeded with a failing reader")
+	}
+	var buf bytes.Buffer
+	if _, err := s.Read("bar", &buf); err != nil || buf.String() != "foobar" {
+		t.Errorf("FileSystem.Read() = %q, %v, want foobar", buf.String(), err)
+	}
+	if err := s.Copy("bar", "/baz"); err != nil {
+		t.Fatalf("FileSystem.Copy() error = %v", err)
+	}
+	if err := s.Copy("bar", "/baz"); !errors.Is(err, fs.ErrAlreadyExist) {
+		t.Errorf("FileSystem.Copy() onto an existing file error = %v, want %v", err, fs.ErrAlreadyExist)
+	}
+
+	files, dirs, err := s.ListDir("/")
+	if err != nil || len(files) != 1 || len(dirs) != 1 {
+		t.Fatalf("FileSystem.ListDir() = %v, %v, %v, want a file and a dir", files, dirs, err)
+	}
+	if files[0].Path() != "/baz" || files[0].String() != "baz" || files[0].Size() != 6 {
+		t.Errorf("FileSystem.ListDir() file = %s %s %d, want /baz baz 6", files[0].Path(), files[0].String(), files[0].Size())
+	}
+	if u := dirs[0].Usage(); dirs[0].Path() != "/foo" || u.Files != 1 || u.Size != 6 {
+		t.Errorf("FileSystem.ListDir() dir = %s %+v, want /foo with a file of 6 bytes", dirs[0].Path(), u)
+	}
+	if files, _, err := s.Find("/", "bar"); err != nil || len(files) != 1 || files[0].Path() != "/foo/bar" {
+		t.Errorf("FileSystem.Find() = %v, %v, want /foo/bar", files, err)
+	}
+	res, err := s.FindRegex("/", "ba", 10)
+	if err != nil || len(res.Paths) != 2 {
+		t.Errorf("FileSystem.FindRegex() = %v, %v, want 2 paths", res.Paths, err)
+	}
+
+	if err := s.Remove("/foo"); !errors.Is(err, fs.ErrNotSupported) {
+		t.Errorf("FileSystem.Remove(current dir) error = %v, want %v", err, fs.ErrNotSupported)
+	}
+	if err := s.ChangeDir("/"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Remove("/foo"); !errors.Is(err, fs.ErrDirNotEmpty) {
+		t.Errorf("FileSystem.Remove(/foo) error = %v, want %v", err, fs.ErrDirNotEmpty)
+	}
+	if err := s.Move("/foo", "/foo/sub"); !errors.Is(err, fs.ErrInvalidName) {
+		t.Errorf("FileSystem.Move() under itself error = %v, want %v", err, fs.ErrInvalidName)
+	}
+	if err := s.Move("/foo", "/qux"); err != nil {
+		t.Fatalf("FileSystem.Move() error = %v", err)
+	}
+	if file, _, err := s.Stat("/qux/bar"); err != nil || file == nil || file.Size() != 6 {
+		t.Errorf("FileSystem.Stat(/qux/bar) = %v, %v, want the moved file", file, err)
+	}
+	if _, _, err := s.Stat("/foo/bar"); !errors.Is(err, fs.ErrNotFound) {
+		t.Errorf("FileSystem.Stat(/foo/bar) error = %v, want %v", err, fs.ErrNotFound)
+	}
+	if n, err := s.DeletePrefix("/qux", true); err != nil || n != 2 {
+		t.Errorf("FileSystem.DeletePrefix(/qux, dry run) = %d, %v, want 2", n, err)
+	}
+	if n, err := s.DeletePrefix("/", false); err != nil || n != 3 {
+		t.Errorf("FileSystem.DeletePrefix(/) = %d, %v, want 3", n, err)
+	}
+	if _, _, err := s.Stat("/baz"); !errors.Is(err, fs.ErrNotFound) {
+		t.Errorf("FileSystem.Stat() error = %v, want %v", err, fs.ErrNotFound)
+	}
+}
+
+func TestFileSystem_Reopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "fs.db")
+	s, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.NewFile("/file"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Write("/file", strings.NewReader("durable")); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	s, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	var buf bytes.Buffer
+	if _, err := s.Read("/file", &buf); err != nil || buf.String() != "durable" {
+		t.Errorf("FileSystem.Read() after reopening = %q, %v, want durable", buf.String(), err)
+	}
+}
+
+func TestFileSystem_GroupCommit(t *testing.T) {
+	if _, err := OpenWithOpts(filepath.Join(t.TempDir(), "fs.db"), Opts{GroupCommit: -time.Millisecond}); err == nil {
+		t.Errorf("OpenWithOpts() with a negative delay succeeded")
+	}
+	s := createTestFS(t, Opts{GroupCommit: 5 * time.Millisecond, MaxBatch: 4})
+	if err := s.NewFile("/taken"); err != nil {
+		t.Fatal(err)
+	}
+	// Mutations share batches, and the ones failing don't affect the others.
+	var wg sync.WaitGroup
+	errs := make([]error, 10)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			name := fmt.Sprintf("/f%d", i)
+			if i%3 == 0 {
+				name = "/taken"
+			}
+			errs[i] = s.NewFile(name)
+		}(i)
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if wantErr := i%3 == 0; (err != nil) != wantErr {
+			t.Errorf("FileSystem.NewFile() %d error = %v, wantErr %v", i, err, wantErr)
+		}
+	}
+	files, _, err := s.ListDir("/")
+	if err != nil || len(files) != 7 {
+		t.Errorf("FileSystem.ListDir() = %d files, %v, want 7", len(files), err)
+	}
+}
@@ -0,0 +1,119 @@
+package fs
+
+import (
+	"sync/atomic"
+
+	"github.com/basharal/filesystem/fspath"
+)
+
+// DirUsage is what a dir holds. It's maintained incrementally as files/dirs are created, removed,
+// moved and written, so that listings don't have to walk subtrees.
+type DirUsage struct {
+	// Files and Dirs are the number of direct children.
+	Files int64
+	Dirs  int64
+
+	// Size is the total size of the files anywhere under the dir.
+	Size int64
+}
+
+// Usage returns what the dir holds. Mounted dirs report zeros.
+func (d *Dir) Usage() DirUsage {
+	return DirUsage{
+		Files: atomic.LoadInt64(&d.files),
+		Dirs:  atomic.LoadInt64(&d.dirs),
+		Size:  atomic.LoadInt64(&d.size),
+	}
+}
+
+// parentDir returns the dir containing the absolute path p, or nil for the root. mu must be held.
+func (fs *FileSystem) parentDir(p string) *Dir {
+	if p == fspath.Root {
+		return nil
+	}
+	dir := fspath.Dir(p)
+	if dir == fspath.Root {
+		return fs.root
+	}
+	node := fs.findNode(dir + SeperatorStr)
+	if node == nil {
+		return nil
+	}
+	d, _ := node.Meta().(*Dir)
+	return d
+}
+
+// addSize adds delta to the size of every dir containing the absolute path p. mu must be held,
+// for reading at least.
+func (fs *FileSystem) addSize(p string, delta int64) {
+	if delta == 0 {
+		return
+	}
+	for d := fs.parentDir(p); d != nil; d = fs.parentDir(d.Path()) {
+		atomic.AddInt64(&d.size, delta)
+	}
+}
+
+// attach counts the file/dir at the absolute path p in its parent and ancestors, and indexes it.
+// mu must be held.
+func (fs *FileSystem) attach(p string, meta interface{}) {
+	fs.indexAdd(p, meta)
+	parent := fs.parentDir(p)
+	if parent == nil {
+		return
+	}
+	switch meta := meta.(type) {
+	case *File:
+		atomic.AddInt64(&parent.files, 1)
+		fs.addSize(p, atomic.LoadInt64(&meta.accounted))
+	case *Dir:
+		atomic.AddInt64(&parent.dirs, 1)
+		fs.addSize(p, atomic.LoadInt64(&meta.size))
+	}
+}
+
+// detach undoes attach before the file/dir at the absolute path p is removed or moved. mu must be
+// held.
+func (fs *FileSystem) detach(p string, meta interface{}) {
+	fs.indexRemove(p, meta)
+	parent := fs.parentDir(p)
+	if parent == nil {
+		return
+	}
+	switch meta := meta.(type) {
+	case *File:
+		atomic.AddInt64(&parent.files, -1)
+		fs.addSize(p, -atomic.LoadInt64(&meta.accounted))
+	case *Dir:
+		atomic.AddInt64(&parent.dirs, -1)
+		fs.addSize(p, -atomic.LoadInt64(&meta.size))
+	}
+}
+
+// account adds the change in the size of file since it was last accounted for to its dirs. Writes
+// account after they're done without holding mu, so a file moved in the meantime is accounted for
+// in its new dirs, and a removed one not at all.
+func (fs *FileSystem) account(file *File) {
+	// The size is read before mu so that a write in progress doesn't hold up everyone else.
+	// accounting keeps concurrent accounts of the file in order.
+	file.accounting.Lock()
+	defer file.accounting.Unlock()
+	size := file.Size()
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+	fs.accountSize(file, size)
+}
+
+// accountLocked is account with mu held.
+func (fs *FileSystem) accountLocked(file *File) {
+	fs.accountSize(file, file.Size())
+}
+
+func (fs *FileSystem) accountSize(file *File, size int64) {
+	if file.detached || file.md.mount() != nil {
+		return
+	}
+	path := file.Path()
+	fs.addSize(path, size-atomic.SwapInt64(&file.accounted, size))
+	fs.changed(path)
+}
@@ -2,9 +2,9 @@ package fs
 
 import "strings"
 
+// validateName rejects '.' and '..' as the names of files/dirs to create. Paths are resolved (see
+// FileSystem.resolve) before their names get here.
 func validateName(s string) error {
-	// At some point we want to support '.' and '..'. Ensure that we don't create anything
-	// right now with such names
 	splitted := strings.Split(s, "/")
 	for _, name := range splitted {
 		if name == "." || name == ".." {
@@ -13,3 +13,19 @@ func validateName(s string) error {
 	}
 	return nil
 }
+
+// hasDots returns true if s has a '.' or '..' component.
+func hasDots(s string) bool {
+	for _, name := range strings.Split(s, SeperatorStr) {
+		if name == "." || name == ".." {
+			return true
+		}
+	}
+	return false
+}
+
+// endsInDots returns true if the last component of s is '.' or '..', unlike names ending in a dot.
+func endsInDots(s string) bool {
+	last := s[strings.LastIndex(s, SeperatorStr)+1:]
+	return last == "." || last == ".."
+}
@@ -0,0 +1,80 @@
+// Package fspath manipulates slash-separated filesystem paths. It's shared by the filesystem,
+// server and client so that paths are canonicalized and compared the same way everywhere.
+package fspath
+
+import (
+	"path"
+	"strings"
+)
+
+const (
+	Separator    = '/'
+	SeparatorStr = string(Separator)
+
+	// Root is the root of a filesystem.
+	Root = SeparatorStr
+)
+
+// IsAbs returns true if p is absolute.
+func IsAbs(p string) bool {
+	return p != "" && p[0] == Separator
+}
+
+// Clean returns the shortest path equivalent to p. It removes repeated and trailing separators
+// and lexically resolves '.' and '..'. Unlike path.Clean, an empty path stays empty.
+func Clean(p string) string {
+	if p == "" {
+		return ""
+	}
+	return path.Clean(p)
+}
+
+// Join joins the elements with separators and cleans the result. Empty elements are ignored.
+func Join(elem ...string) string {
+	return path.Join(elem...)
+}
+
+// Split returns the components of p after cleaning it (i.e., /foo/bar returns [foo bar]). Root
+// has no components.
+func Split(p string) []string {
+	p = strings.Trim(Clean(p), SeparatorStr)
+	if p == "" || p == "." {
+		return nil
+	}
+	return strings.Split(p, SeparatorStr)
+}
+
+// Dir returns all but the last component of p. The parent of root is root.
+func Dir(p string) string {
+	return path.Dir(Clean(p))
+}
+
+// Base returns the last component of p. Root's base is root.
+func Base(p string) string {
+	return path.Base(Clean(p))
+}
+
+// HasPrefix returns true if p is prefix or is inside it. Unlike strings.HasPrefix, it compares
+// whole components, so /foo is a prefix of /foo/bar, but not of /foobar.
+func HasPrefix(p, prefix string) bool {
+	_, ok := TrimPrefix(p, prefix)
+	return ok
+}
+
+// TrimPrefix returns the absolute path of p within prefix (i.e., /foo/bar within /foo is /bar).
+// It returns false if p isn't under prefix.
+func TrimPrefix(p, prefix string) (string, bool) {
+	if !IsAbs(p) || !IsAbs(prefix) {
+		return "", false
+	}
+	p, prefix = Clean(p), Clean(prefix)
+	switch {
+	case prefix == Root:
+		return p, true
+	case p == prefix:
+		return Root, true
+	case strings.HasPrefix(p, prefix+SeparatorStr):
+		return p[len(prefix):], true
+	}
+	return "", false
+}
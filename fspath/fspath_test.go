@@ -0,0 +1,55 @@
+package fspath
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestClean(t *testing.T) {
+	for in, want := range map[string]string{
+		"":            "",
+		"/":           "/",
+		"//foo//bar/": "/foo/bar",
+		"foo/./bar":   "foo/bar",
+		"/foo/../bar": "/bar",
+		"/..":         "/",
+	} {
+		if got := Clean(in); got != want {
+			t.Errorf("Clean(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestSplit(t *testing.T) {
+	for in, want := range map[string][]string{
+		"/":         nil,
+		"":          nil,
+		"/foo/bar/": {"foo", "bar"},
+		"foo":       {"foo"},
+	} {
+		if got := Split(in); !reflect.DeepEqual(got, want) {
+			t.Errorf("Split(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestTrimPrefix(t *testing.T) {
+	tests := []struct {
+		p, prefix, want string
+		ok              bool
+	}{
+		{"/foo/bar", "/", "/foo/bar", true},
+		{"/foo/bar", "/foo", "/bar", true},
+		{"/foo/bar", "/foo/", "/bar", true},
+		{"/foo", "/foo", "/", true},
+		{"/foobar", "/foo", "", false},
+		{"/bar", "/foo", "", false},
+		{"foo", "/", "", false},
+	}
+	for _, test := range tests {
+		got, ok := TrimPrefix(test.p, test.prefix)
+		if got != test.want || ok != test.ok {
+			t.Errorf("TrimPrefix(%q, %q) = %q, %v, want %q, %v", test.p, test.prefix, got, ok, test.want, test.ok)
+		}
+	}
+}
@@ -3,9 +3,12 @@ module github.com/basharal/filesystem
 go 1.16
 
 require (
-	github.com/basharal/trie v0.1.8
 	github.com/fatih/color v1.12.0
+	github.com/fsnotify/fsnotify v1.4.9
 	github.com/golang/glog v0.0.0-20210429001901-424d2337a529
+	github.com/mattn/go-sqlite3 v1.14.16
+	go.etcd.io/bbolt v1.3.5
+	golang.org/x/net v0.0.0-20200822124328-c89045814202
 	google.golang.org/grpc v1.39.0
 	google.golang.org/protobuf v1.27.1
 )
@@ -0,0 +1,224 @@
+// Package output formats the results of CLI commands as colored text or JSON lines.
+package output
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+// Format is how results are printed.
+type Format string
+
+const (
+	// Text is human-readable and colored.
+	Text Format = "text"
+	// JSON prints one object per line.
+	JSON Format = "json"
+)
+
+// Size units.
+const (
+	Bytes = "bytes"
+	// IEC uses powers of 1024 (KiB, MiB...).
+	IEC = "iec"
+	// SI uses powers of 1000 (kB, MB...).
+	SI = "si"
+)
+
+// Time formats besides Go layouts.
+const (
+	RFC3339 = "rfc3339"
+	Unix    = "unix"
+)
+
+// Opts configure a Printer.
+type Opts struct {
+	Format Format
+
+	// NoColor disables colors. They're also disabled when the NO_COLOR env var is set.
+	NoColor bool
+
+	// SizeUnits is one of Bytes (default), IEC or SI.
+	SizeUnits string
+
+	// TimeFormat adds modification times to listings when set. It's RFC3339, Unix or a Go layout
+	// (i.e., 2006-01-02 15:04).
+	TimeFormat string
+
+	// Verbose prints details of operations to stderr (i.e., the servers' summaries of transfers).
+	Verbose bool
+}
+
+// RegisterFlags registers flags for the options on fs. The returned options are set once fs is
+// parsed.
+func RegisterFlags(fs *flag.FlagSet) *Opts {
+	opts := &Opts{}
+	fs.StringVar((*string)(&opts.Format), "output", string(Text), "output of listings: text or json (one object per line)")
+	fs.BoolVar(&opts.NoColor, "no-color", false, "disable colors (also disabled by the NO_COLOR env var)")
+	fs.StringVar(&opts.SizeUnits, "size_units", Bytes, "units of sizes in listings: bytes, iec (KiB) or si (kB)")
+	fs.StringVar(&opts.TimeFormat, "time_format", "", "show modification times in listings: rfc3339, unix or a Go layout")
+	fs.BoolVar(&opts.Verbose, "verbose", false, "print details of operations to stderr, i.e., the servers' summaries of reads and writes")
+	return opts
+}
+
+// Entry is a file/dir in a listing.
+type Entry struct {
+	Name  string `json:"name"`
+	Path  string `json:"path"`
+	Size  int64  `json:"size"`
+	IsDir bool   `json:"is_dir"`
+
+	// ModTime is optional.
+	ModTime *time.Time `json:"mod_time,omitempty"`
+
+	// Files and Dirs are the number of children of dirs, whose Size is the total size of the
+	// files under them.
+	Files int64 `json:"files,omitempty"`
+	Dirs  int64 `json:"dirs,omitempty"`
+}
+
+// Printer prints results to stdout.
+type Printer struct {
+	opts Opts
+	w    io.Writer
+}
+
+// New returns a printer. It disables colors globally if asked to.
+func New(opts Opts) (*Printer, error) {
+	if opts.Format == "" {
+		opts.Format = Text
+	}
+	if opts.Format != Text && opts.Format != JSON {
+		return nil, fmt.Errorf("unknown output %s. must be text or json", opts.Format)
+	}
+	if opts.SizeUnits == "" {
+		opts.SizeUnits = Bytes
+	}
+	if opts.SizeUnits != Bytes && opts.SizeUnits != IEC && opts.SizeUnits != SI {
+		return nil, fmt.Errorf("unknown size units %s. must be bytes, iec or si", opts.SizeUnits)
+	}
+	if _, ok := os.LookupEnv("NO_COLOR"); ok || opts.NoColor {
+		color.NoColor = true
+	}
+	return &Printer{opts: opts, w: os.Stdout}, nil
+}
+
+// JSON returns true if results are printed as JSON lines.
+func (p *Printer) JSON() bool {
+	return p.opts.Format == JSON
+}
+
+// Verbose returns true if details of operations are printed.
+func (p *Printer) Verbose() bool {
+	return p.opts.Verbose
+}
+
+// Object prints v as a single line of JSON.
+func (p *Printer) Object(v interface{}) error {
+	return json.NewEncoder(p.w).Encode(v)
+}
+
+// Entries prints a listing. Files come first, followed by dirs. With fullPath, paths are printed
+// instead of names.
+func (p *Printer) Entries(entries []Entry, fullPath bool) error {
+	return p.entries(entries, fullPath, false)
+}
+
+// LongEntries prints a listing like Entries, along with the sizes and number of children of dirs.
+func (p *Printer) LongEntries(entries []Entry, fullPath bool) error {
+	return p.entries(entries, fullPath, true)
+}
+
+func (p *Printer) entries(entries []Entry, fullPath, long bool) error {
+	if p.JSON() {
+		for _, e := range entries {
+			if err := p.Object(e); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for _, dirs := range []bool{false, true} {
+		for _, e := range entries {
+			if e.IsDir != dirs {
+				continue
+			}
+			s := e.Name
+			if fullPath {
+				s = e.Path
+			}
+			if p.opts.TimeFormat != "" {
+				s = p.Time(e.ModTime) + "\t" + s
+			}
+			if e.IsDir && long {
+				color.New(color.FgCyan).Fprintf(p.w, "%s\t%s\t(%d files, %d dirs)\n", p.Size(e.Size), s, e.Files, e.Dirs)
+				continue
+			}
+			if e.IsDir {
+				color.New(color.FgCyan).Fprintf(p.w, "\t%s\n", s)
+				continue
+			}
+			fmt.Fprintf(p.w, "%s\t%s\n", p.Size(e.Size), s)
+		}
+	}
+	return nil
+}
+
+// Paths prints one path per line.
+func (p *Printer) Paths(paths []string) error {
+	for _, path := range paths {
+		if p.JSON() {
+			if err := p.Object(struct {
+				Path string `json:"path"`
+			}{path}); err != nil {
+				return err
+			}
+			continue
+		}
+		fmt.Fprintln(p.w, path)
+	}
+	return nil
+}
+
+// Size formats n in the printer's units.
+func (p *Printer) Size(n int64) string {
+	base, units := int64(0), []string(nil)
+	switch p.opts.SizeUnits {
+	case IEC:
+		base, units = 1024, []string{"KiB", "MiB", "GiB", "TiB", "PiB"}
+	case SI:
+		base, units = 1000, []string{"kB", "MB", "GB", "TB", "PB"}
+	default:
+		return strconv.FormatInt(n, 10)
+	}
+	if n < base {
+		return strconv.FormatInt(n, 10) + "B"
+	}
+	size := float64(n) / float64(base)
+	i := 0
+	for ; size >= float64(base) && i < len(units)-1; i++ {
+		size /= float64(base)
+	}
+	return strconv.FormatFloat(size, 'f', 1, 64) + units[i]
+}
+
+// Time formats t in the printer's time format. Unknown times are printed as '-'.
+func (p *Printer) Time(t *time.Time) string {
+	if t == nil {
+		return "-"
+	}
+	switch p.opts.TimeFormat {
+	case RFC3339:
+		return t.Format(time.RFC3339)
+	case Unix:
+		return strconv.FormatInt(t.Unix(), 10)
+	}
+	return t.Format(p.opts.TimeFormat)
+}
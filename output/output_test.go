@@ -0,0 +1,69 @@
+package output
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestPrinter_Size(t *testing.T) {
+	tests := []struct {
+		units string
+		n     int64
+		want  string
+	}{
+		{Bytes, 1536, "1536"},
+		{IEC, 512, "512B"},
+		{IEC, 1536, "1.5KiB"},
+		{IEC, 3 << 30, "3.0GiB"},
+		{SI, 1500, "1.5kB"},
+		{SI, 2000000, "2.0MB"},
+	}
+	for _, test := range tests {
+		p, err := New(Opts{SizeUnits: test.units})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := p.Size(test.n); got != test.want {
+			t.Errorf("Size(%d) in %s: expected %s, got %s", test.n, test.units, test.want, got)
+		}
+	}
+	if _, err := New(Opts{SizeUnits: "bits"}); err == nil {
+		t.Errorf("Expected an error for unknown units")
+	}
+}
+
+func TestPrinter_Entries(t *testing.T) {
+	modified := time.Unix(1600000000, 0)
+	entries := []Entry{
+		{Name: "bar", Path: "/foo/bar", IsDir: true},
+		{Name: "f1", Path: "/foo/f1", Size: 10, ModTime: &modified},
+	}
+	var buf bytes.Buffer
+	p, err := New(Opts{TimeFormat: Unix, NoColor: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.w = &buf
+	if err := p.Entries(entries, true); err != nil {
+		t.Fatal(err)
+	}
+	want := "10\t1600000000\t/foo/f1\n\t-\t/foo/bar\n"
+	if buf.String() != want {
+		t.Errorf("Expected %q, got %q", want, buf.String())
+	}
+
+	buf.Reset()
+	p, err = New(Opts{Format: JSON})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.w = &buf
+	if err := p.Entries(entries[:1], false); err != nil {
+		t.Fatal(err)
+	}
+	want = `{"name":"bar","path":"/foo/bar","size":0,"is_dir":true}` + "\n"
+	if buf.String() != want {
+		t.Errorf("Expected %q, got %q", want, buf.String())
+	}
+}
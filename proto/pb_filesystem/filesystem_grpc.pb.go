@@ -26,11 +26,48 @@ type FileSeverClient interface {
 	Remove(ctx context.Context, in *Path, opts ...grpc.CallOption) (*StatusResponse, error)
 	// Create a file at path.
 	CreateFile(ctx context.Context, in *Path, opts ...grpc.CallOption) (*StatusResponse, error)
+	// Creates a file at path if it doesn't exist. Otherwise, updates its modification time.
+	TouchFile(ctx context.Context, in *Path, opts ...grpc.CallOption) (*StatusResponse, error)
 	// Returns file content as a stream of bytes.
 	ReadFile(ctx context.Context, in *Path, opts ...grpc.CallOption) (FileSever_ReadFileClient, error)
 	// A client-to-server streaming RPC.
 	//
 	WriteFile(ctx context.Context, opts ...grpc.CallOption) (FileSever_WriteFileClient, error)
+	// Sets the retention policy of the directory at path. Empty rules remove the policy.
+	SetRetention(ctx context.Context, in *RetentionPolicy, opts ...grpc.CallOption) (*StatusResponse, error)
+	// Returns the retention policies of directories under path.
+	ListRetention(ctx context.Context, in *Path, opts ...grpc.CallOption) (*RetentionList, error)
+	// Returns the first paths under path that match the regex. Patterns are validated and the
+	// search is bounded by the server's limits.
+	FindFirstRegex(ctx context.Context, in *RegexRequest, opts ...grpc.CallOption) (*RegexResponse, error)
+	// Removes path and everything under it in one call. With dry_run, nothing is removed.
+	DeletePrefix(ctx context.Context, in *DeletePrefixRequest, opts ...grpc.CallOption) (*DeletePrefixResponse, error)
+	// Copies the file at src to a new file at dst, both on this server.
+	Copy(ctx context.Context, in *CopyRequest, opts ...grpc.CallOption) (*StatusResponse, error)
+	// Returns the file or the dir at path, whichever exists.
+	Stat(ctx context.Context, in *Path, opts ...grpc.CallOption) (*StatResponse, error)
+	// Returns the files/dirs at path as entries ordered by name.
+	ListEntries(ctx context.Context, in *Path, opts ...grpc.CallOption) (*EntryList, error)
+	// Returns the server's version, the optional features it supports, its range and limits.
+	GetServerInfo(ctx context.Context, in *ServerInfoRequest, opts ...grpc.CallOption) (*ServerInfo, error)
+	// Returns the snapshots the server keeps, oldest first.
+	ListSnapshots(ctx context.Context, in *ListSnapshotsRequest, opts ...grpc.CallOption) (*SnapshotList, error)
+	// Restores path from a snapshot to target, leaving the rest of the filesystem as is.
+	RestoreSnapshot(ctx context.Context, in *RestoreRequest, opts ...grpc.CallOption) (*RestoreResponse, error)
+	// Returns the Merkle digest of the file/dir at path and the digests of its entries.
+	Digest(ctx context.Context, in *Path, opts ...grpc.CallOption) (*DigestResponse, error)
+	// Checks that the server serves path without doing anything with it, i.e., to debug routing.
+	// Fails with InvalidArgument for paths the server doesn't serve.
+	ValidatePath(ctx context.Context, in *Path, opts ...grpc.CallOption) (*StatusResponse, error)
+	// Streams the changes made on the server after a sequence number, along with heartbeats, so
+	// that read replicas can apply them. Fails with OutOfRange once the changes are no longer kept.
+	Tail(ctx context.Context, in *TailRequest, opts ...grpc.CallOption) (FileSever_TailClient, error)
+	// Returns the files under path whose content best matches a query, best first. Fails with
+	// Unimplemented if the server doesn't index content.
+	Search(ctx context.Context, in *SearchRequest, opts ...grpc.CallOption) (*SearchResponse, error)
+	// Converges the dir at path to a manifest at once: missing files/dirs are created, files whose
+	// content differs are replaced and everything else under it is removed.
+	ApplyManifest(ctx context.Context, in *ApplyManifestRequest, opts ...grpc.CallOption) (*ApplyManifestResponse, error)
 }
 
 type fileSeverClient struct {
@@ -77,6 +114,15 @@ func (c *fileSeverClient) CreateFile(ctx context.Context, in *Path, opts ...grpc
 	return out, nil
 }
 
+func (c *fileSeverClient) TouchFile(ctx context.Context, in *Path, opts ...grpc.CallOption) (*StatusResponse, error) {
+	out := new(StatusResponse)
+	err := c.cc.Invoke(ctx, "/filesystem.FileSever/TouchFile", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 func (c *fileSeverClient) ReadFile(ctx context.Context, in *Path, opts ...grpc.CallOption) (FileSever_ReadFileClient, error) {
 	stream, err := c.cc.NewStream(ctx, &FileSever_ServiceDesc.Streams[0], "/filesystem.FileSever/ReadFile", opts...)
 	if err != nil {
@@ -143,6 +189,164 @@ func (x *fileSeverWriteFileClient) CloseAndRecv() (*StatusResponse, error) {
 	return m, nil
 }
 
+func (c *fileSeverClient) SetRetention(ctx context.Context, in *RetentionPolicy, opts ...grpc.CallOption) (*StatusResponse, error) {
+	out := new(StatusResponse)
+	err := c.cc.Invoke(ctx, "/filesystem.FileSever/SetRetention", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *fileSeverClient) ListRetention(ctx context.Context, in *Path, opts ...grpc.CallOption) (*RetentionList, error) {
+	out := new(RetentionList)
+	err := c.cc.Invoke(ctx, "/filesystem.FileSever/ListRetention", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *fileSeverClient) FindFirstRegex(ctx context.Context, in *RegexRequest, opts ...grpc.CallOption) (*RegexResponse, error) {
+	out := new(RegexResponse)
+	err := c.cc.Invoke(ctx, "/filesystem.FileSever/FindFirstRegex", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *fileSeverClient) DeletePrefix(ctx context.Context, in *DeletePrefixRequest, opts ...grpc.CallOption) (*DeletePrefixResponse, error) {
+	out := new(DeletePrefixResponse)
+	err := c.cc.Invoke(ctx, "/filesystem.FileSever/DeletePrefix", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *fileSeverClient) Copy(ctx context.Context, in *CopyRequest, opts ...grpc.CallOption) (*StatusResponse, error) {
+	out := new(StatusResponse)
+	err := c.cc.Invoke(ctx, "/filesystem.FileSever/Copy", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *fileSeverClient) Stat(ctx context.Context, in *Path, opts ...grpc.CallOption) (*StatResponse, error) {
+	out := new(StatResponse)
+	err := c.cc.Invoke(ctx, "/filesystem.FileSever/Stat", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *fileSeverClient) ListEntries(ctx context.Context, in *Path, opts ...grpc.CallOption) (*EntryList, error) {
+	out := new(EntryList)
+	err := c.cc.Invoke(ctx, "/filesystem.FileSever/ListEntries", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *fileSeverClient) GetServerInfo(ctx context.Context, in *ServerInfoRequest, opts ...grpc.CallOption) (*ServerInfo, error) {
+	out := new(ServerInfo)
+	err := c.cc.Invoke(ctx, "/filesystem.FileSever/GetServerInfo", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *fileSeverClient) ListSnapshots(ctx context.Context, in *ListSnapshotsRequest, opts ...grpc.CallOption) (*SnapshotList, error) {
+	out := new(SnapshotList)
+	err := c.cc.Invoke(ctx, "/filesystem.FileSever/ListSnapshots", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *fileSeverClient) RestoreSnapshot(ctx context.Context, in *RestoreRequest, opts ...grpc.CallOption) (*RestoreResponse, error) {
+	out := new(RestoreResponse)
+	err := c.cc.Invoke(ctx, "/filesystem.FileSever/RestoreSnapshot", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *fileSeverClient) Digest(ctx context.Context, in *Path, opts ...grpc.CallOption) (*DigestResponse, error) {
+	out := new(DigestResponse)
+	err := c.cc.Invoke(ctx, "/filesystem.FileSever/Digest", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *fileSeverClient) ValidatePath(ctx context.Context, in *Path, opts ...grpc.CallOption) (*StatusResponse, error) {
+	out := new(StatusResponse)
+	err := c.cc.Invoke(ctx, "/filesystem.FileSever/ValidatePath", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *fileSeverClient) Tail(ctx context.Context, in *TailRequest, opts ...grpc.CallOption) (FileSever_TailClient, error) {
+	stream, err := c.cc.NewStream(ctx, &FileSever_ServiceDesc.Streams[2], "/filesystem.FileSever/Tail", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &fileSeverTailClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type FileSever_TailClient interface {
+	Recv() (*Change, error)
+	grpc.ClientStream
+}
+
+type fileSeverTailClient struct {
+	grpc.ClientStream
+}
+
+func (x *fileSeverTailClient) Recv() (*Change, error) {
+	m := new(Change)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *fileSeverClient) Search(ctx context.Context, in *SearchRequest, opts ...grpc.CallOption) (*SearchResponse, error) {
+	out := new(SearchResponse)
+	err := c.cc.Invoke(ctx, "/filesystem.FileSever/Search", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *fileSeverClient) ApplyManifest(ctx context.Context, in *ApplyManifestRequest, opts ...grpc.CallOption) (*ApplyManifestResponse, error) {
+	out := new(ApplyManifestResponse)
+	err := c.cc.Invoke(ctx, "/filesystem.FileSever/ApplyManifest", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // FileSeverServer is the server API for FileSever service.
 // All implementations must embed UnimplementedFileSeverServer
 // for forward compatibility
@@ -155,11 +359,48 @@ type FileSeverServer interface {
 	Remove(context.Context, *Path) (*StatusResponse, error)
 	// Create a file at path.
 	CreateFile(context.Context, *Path) (*StatusResponse, error)
+	// Creates a file at path if it doesn't exist. Otherwise, updates its modification time.
+	TouchFile(context.Context, *Path) (*StatusResponse, error)
 	// Returns file content as a stream of bytes.
 	ReadFile(*Path, FileSever_ReadFileServer) error
 	// A client-to-server streaming RPC.
 	//
 	WriteFile(FileSever_WriteFileServer) error
+	// Sets the retention policy of the directory at path. Empty rules remove the policy.
+	SetRetention(context.Context, *RetentionPolicy) (*StatusResponse, error)
+	// Returns the retention policies of directories under path.
+	ListRetention(context.Context, *Path) (*RetentionList, error)
+	// Returns the first paths under path that match the regex. Patterns are validated and the
+	// search is bounded by the server's limits.
+	FindFirstRegex(context.Context, *RegexRequest) (*RegexResponse, error)
+	// Removes path and everything under it in one call. With dry_run, nothing is removed.
+	DeletePrefix(context.Context, *DeletePrefixRequest) (*DeletePrefixResponse, error)
+	// Copies the file at src to a new file at dst, both on this server.
+	Copy(context.Context, *CopyRequest) (*StatusResponse, error)
+	// Returns the file or the dir at path, whichever exists.
+	Stat(context.Context, *Path) (*StatResponse, error)
+	// Returns the files/dirs at path as entries ordered by name.
+	ListEntries(context.Context, *Path) (*EntryList, error)
+	// Returns the server's version, the optional features it supports, its range and limits.
+	GetServerInfo(context.Context, *ServerInfoRequest) (*ServerInfo, error)
+	// Returns the snapshots the server keeps, oldest first.
+	ListSnapshots(context.Context, *ListSnapshotsRequest) (*SnapshotList, error)
+	// Restores path from a snapshot to target, leaving the rest of the filesystem as is.
+	RestoreSnapshot(context.Context, *RestoreRequest) (*RestoreResponse, error)
+	// Returns the Merkle digest of the file/dir at path and the digests of its entries.
+	Digest(context.Context, *Path) (*DigestResponse, error)
+	// Checks that the server serves path without doing anything with it, i.e., to debug routing.
+	// Fails with InvalidArgument for paths the server doesn't serve.
+	ValidatePath(context.Context, *Path) (*StatusResponse, error)
+	// Streams the changes made on the server after a sequence number, along with heartbeats, so
+	// that read replicas can apply them. Fails with OutOfRange once the changes are no longer kept.
+	Tail(*TailRequest, FileSever_TailServer) error
+	// Returns the files under path whose content best matches a query, best first. Fails with
+	// Unimplemented if the server doesn't index content.
+	Search(context.Context, *SearchRequest) (*SearchResponse, error)
+	// Converges the dir at path to a manifest at once: missing files/dirs are created, files whose
+	// content differs are replaced and everything else under it is removed.
+	ApplyManifest(context.Context, *ApplyManifestRequest) (*ApplyManifestResponse, error)
 	mustEmbedUnimplementedFileSeverServer()
 }
 
@@ -179,12 +420,60 @@ func (UnimplementedFileSeverServer) Remove(context.Context, *Path) (*StatusRespo
 func (UnimplementedFileSeverServer) CreateFile(context.Context, *Path) (*StatusResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method CreateFile not implemented")
 }
+func (UnimplementedFileSeverServer) TouchFile(context.Context, *Path) (*StatusResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method TouchFile not implemented")
+}
 func (UnimplementedFileSeverServer) ReadFile(*Path, FileSever_ReadFileServer) error {
 	return status.Errorf(codes.Unimplemented, "method ReadFile not implemented")
 }
 func (UnimplementedFileSeverServer) WriteFile(FileSever_WriteFileServer) error {
 	return status.Errorf(codes.Unimplemented, "method WriteFile not implemented")
 }
+func (UnimplementedFileSeverServer) SetRetention(context.Context, *RetentionPolicy) (*StatusResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SetRetention not implemented")
+}
+func (UnimplementedFileSeverServer) ListRetention(context.Context, *Path) (*RetentionList, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListRetention not implemented")
+}
+func (UnimplementedFileSeverServer) FindFirstRegex(context.Context, *RegexRequest) (*RegexResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method FindFirstRegex not implemented")
+}
+func (UnimplementedFileSeverServer) DeletePrefix(context.Context, *DeletePrefixRequest) (*DeletePrefixResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeletePrefix not implemented")
+}
+func (UnimplementedFileSeverServer) Copy(context.Context, *CopyRequest) (*StatusResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Copy not implemented")
+}
+func (UnimplementedFileSeverServer) Stat(context.Context, *Path) (*StatResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Stat not implemented")
+}
+func (UnimplementedFileSeverServer) ListEntries(context.Context, *Path) (*EntryList, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListEntries not implemented")
+}
+func (UnimplementedFileSeverServer) GetServerInfo(context.Context, *ServerInfoRequest) (*ServerInfo, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetServerInfo not implemented")
+}
+func (UnimplementedFileSeverServer) ListSnapshots(context.Context, *ListSnapshotsRequest) (*SnapshotList, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListSnapshots not implemented")
+}
+func (UnimplementedFileSeverServer) RestoreSnapshot(context.Context, *RestoreRequest) (*RestoreResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RestoreSnapshot not implemented")
+}
+func (UnimplementedFileSeverServer) Digest(context.Context, *Path) (*DigestResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Digest not implemented")
+}
+func (UnimplementedFileSeverServer) ValidatePath(context.Context, *Path) (*StatusResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ValidatePath not implemented")
+}
+func (UnimplementedFileSeverServer) Tail(*TailRequest, FileSever_TailServer) error {
+	return status.Errorf(codes.Unimplemented, "method Tail not implemented")
+}
+func (UnimplementedFileSeverServer) Search(context.Context, *SearchRequest) (*SearchResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Search not implemented")
+}
+func (UnimplementedFileSeverServer) ApplyManifest(context.Context, *ApplyManifestRequest) (*ApplyManifestResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ApplyManifest not implemented")
+}
 func (UnimplementedFileSeverServer) mustEmbedUnimplementedFileSeverServer() {}
 
 // UnsafeFileSeverServer may be embedded to opt out of forward compatibility for this service.
@@ -270,6 +559,24 @@ func _FileSever_CreateFile_Handler(srv interface{}, ctx context.Context, dec fun
 	return interceptor(ctx, in, info, handler)
 }
 
+func _FileSever_TouchFile_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Path)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FileSeverServer).TouchFile(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/filesystem.FileSever/TouchFile",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FileSeverServer).TouchFile(ctx, req.(*Path))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 func _FileSever_ReadFile_Handler(srv interface{}, stream grpc.ServerStream) error {
 	m := new(Path)
 	if err := stream.RecvMsg(m); err != nil {
@@ -317,41 +624,621 @@ func (x *fileSeverWriteFileServer) Recv() (*FilePayload, error) {
 	return m, nil
 }
 
-// FileSever_ServiceDesc is the grpc.ServiceDesc for FileSever service.
-// It's only intended for direct use with grpc.RegisterService,
-// and not to be introspected or modified (even as a copy)
-var FileSever_ServiceDesc = grpc.ServiceDesc{
-	ServiceName: "filesystem.FileSever",
-	HandlerType: (*FileSeverServer)(nil),
-	Methods: []grpc.MethodDesc{
-		{
-			MethodName: "ListDir",
-			Handler:    _FileSever_ListDir_Handler,
-		},
-		{
-			MethodName: "MakeDir",
-			Handler:    _FileSever_MakeDir_Handler,
-		},
-		{
-			MethodName: "Remove",
-			Handler:    _FileSever_Remove_Handler,
-		},
-		{
-			MethodName: "CreateFile",
-			Handler:    _FileSever_CreateFile_Handler,
-		},
-	},
-	Streams: []grpc.StreamDesc{
-		{
-			StreamName:    "ReadFile",
-			Handler:       _FileSever_ReadFile_Handler,
-			ServerStreams: true,
-		},
-		{
-			StreamName:    "WriteFile",
-			Handler:       _FileSever_WriteFile_Handler,
-			ClientStreams: true,
+func _FileSever_SetRetention_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RetentionPolicy)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FileSeverServer).SetRetention(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/filesystem.FileSever/SetRetention",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FileSeverServer).SetRetention(ctx, req.(*RetentionPolicy))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _FileSever_ListRetention_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Path)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FileSeverServer).ListRetention(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/filesystem.FileSever/ListRetention",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FileSeverServer).ListRetention(ctx, req.(*Path))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _FileSever_FindFirstRegex_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RegexRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FileSeverServer).FindFirstRegex(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/filesystem.FileSever/FindFirstRegex",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FileSeverServer).FindFirstRegex(ctx, req.(*RegexRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _FileSever_DeletePrefix_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeletePrefixRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FileSeverServer).DeletePrefix(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/filesystem.FileSever/DeletePrefix",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FileSeverServer).DeletePrefix(ctx, req.(*DeletePrefixRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _FileSever_Copy_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CopyRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FileSeverServer).Copy(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/filesystem.FileSever/Copy",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FileSeverServer).Copy(ctx, req.(*CopyRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _FileSever_Stat_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Path)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FileSeverServer).Stat(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/filesystem.FileSever/Stat",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FileSeverServer).Stat(ctx, req.(*Path))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _FileSever_ListEntries_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Path)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FileSeverServer).ListEntries(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/filesystem.FileSever/ListEntries",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FileSeverServer).ListEntries(ctx, req.(*Path))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _FileSever_GetServerInfo_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ServerInfoRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FileSeverServer).GetServerInfo(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/filesystem.FileSever/GetServerInfo",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FileSeverServer).GetServerInfo(ctx, req.(*ServerInfoRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _FileSever_ListSnapshots_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListSnapshotsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FileSeverServer).ListSnapshots(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/filesystem.FileSever/ListSnapshots",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FileSeverServer).ListSnapshots(ctx, req.(*ListSnapshotsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _FileSever_RestoreSnapshot_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RestoreRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FileSeverServer).RestoreSnapshot(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/filesystem.FileSever/RestoreSnapshot",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FileSeverServer).RestoreSnapshot(ctx, req.(*RestoreRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _FileSever_Digest_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Path)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FileSeverServer).Digest(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/filesystem.FileSever/Digest",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FileSeverServer).Digest(ctx, req.(*Path))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _FileSever_ValidatePath_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Path)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FileSeverServer).ValidatePath(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/filesystem.FileSever/ValidatePath",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FileSeverServer).ValidatePath(ctx, req.(*Path))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _FileSever_Tail_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(TailRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(FileSeverServer).Tail(m, &fileSeverTailServer{stream})
+}
+
+type FileSever_TailServer interface {
+	Send(*Change) error
+	grpc.ServerStream
+}
+
+type fileSeverTailServer struct {
+	grpc.ServerStream
+}
+
+func (x *fileSeverTailServer) Send(m *Change) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _FileSever_Search_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SearchRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FileSeverServer).Search(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/filesystem.FileSever/Search",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FileSeverServer).Search(ctx, req.(*SearchRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _FileSever_ApplyManifest_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ApplyManifestRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FileSeverServer).ApplyManifest(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/filesystem.FileSever/ApplyManifest",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FileSeverServer).ApplyManifest(ctx, req.(*ApplyManifestRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// FileSever_ServiceDesc is the grpc.ServiceDesc for FileSever service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var FileSever_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "filesystem.FileSever",
+	HandlerType: (*FileSeverServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "ListDir",
+			Handler:    _FileSever_ListDir_Handler,
+		},
+		{
+			MethodName: "MakeDir",
+			Handler:    _FileSever_MakeDir_Handler,
+		},
+		{
+			MethodName: "Remove",
+			Handler:    _FileSever_Remove_Handler,
+		},
+		{
+			MethodName: "CreateFile",
+			Handler:    _FileSever_CreateFile_Handler,
+		},
+		{
+			MethodName: "TouchFile",
+			Handler:    _FileSever_TouchFile_Handler,
+		},
+		{
+			MethodName: "SetRetention",
+			Handler:    _FileSever_SetRetention_Handler,
+		},
+		{
+			MethodName: "ListRetention",
+			Handler:    _FileSever_ListRetention_Handler,
+		},
+		{
+			MethodName: "FindFirstRegex",
+			Handler:    _FileSever_FindFirstRegex_Handler,
+		},
+		{
+			MethodName: "DeletePrefix",
+			Handler:    _FileSever_DeletePrefix_Handler,
+		},
+		{
+			MethodName: "Copy",
+			Handler:    _FileSever_Copy_Handler,
+		},
+		{
+			MethodName: "Stat",
+			Handler:    _FileSever_Stat_Handler,
+		},
+		{
+			MethodName: "ListEntries",
+			Handler:    _FileSever_ListEntries_Handler,
+		},
+		{
+			MethodName: "GetServerInfo",
+			Handler:    _FileSever_GetServerInfo_Handler,
+		},
+		{
+			MethodName: "ListSnapshots",
+			Handler:    _FileSever_ListSnapshots_Handler,
+		},
+		{
+			MethodName: "RestoreSnapshot",
+			Handler:    _FileSever_RestoreSnapshot_Handler,
+		},
+		{
+			MethodName: "Digest",
+			Handler:    _FileSever_Digest_Handler,
+		},
+		{
+			MethodName: "ValidatePath",
+			Handler:    _FileSever_ValidatePath_Handler,
+		},
+		{
+			MethodName: "Search",
+			Handler:    _FileSever_Search_Handler,
+		},
+		{
+			MethodName: "ApplyManifest",
+			Handler:    _FileSever_ApplyManifest_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "ReadFile",
+			Handler:       _FileSever_ReadFile_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "WriteFile",
+			Handler:       _FileSever_WriteFile_Handler,
+			ClientStreams: true,
+		},
+		{
+			StreamName:    "Tail",
+			Handler:       _FileSever_Tail_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "filesystem.proto",
+}
+
+// FileAdminClient is the client API for FileAdmin service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type FileAdminClient interface {
+	// Takes a snapshot right away. Fails with FailedPrecondition if snapshots aren't enabled.
+	TakeSnapshot(ctx context.Context, in *TakeSnapshotRequest, opts ...grpc.CallOption) (*TakeSnapshotResponse, error)
+	// Applies the retention policies and archives cold files right away, like the janitor does.
+	RunJanitor(ctx context.Context, in *RunJanitorRequest, opts ...grpc.CallOption) (*RunJanitorResponse, error)
+	// Stops taking writes (or takes them again), i.e., before taking the server down. Writes fail
+	// with Unavailable while the server drains.
+	Drain(ctx context.Context, in *DrainRequest, opts ...grpc.CallOption) (*StatusResponse, error)
+	// Returns the server's usage, limits and admission counters.
+	GetStats(ctx context.Context, in *StatsRequest, opts ...grpc.CallOption) (*ServerStats, error)
+	// Changes the prefix range the server serves, i.e., to move files to another server.
+	SetRange(ctx context.Context, in *SetRangeRequest, opts ...grpc.CallOption) (*StatusResponse, error)
+}
+
+type fileAdminClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewFileAdminClient(cc grpc.ClientConnInterface) FileAdminClient {
+	return &fileAdminClient{cc}
+}
+
+func (c *fileAdminClient) TakeSnapshot(ctx context.Context, in *TakeSnapshotRequest, opts ...grpc.CallOption) (*TakeSnapshotResponse, error) {
+	out := new(TakeSnapshotResponse)
+	err := c.cc.Invoke(ctx, "/filesystem.FileAdmin/TakeSnapshot", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *fileAdminClient) RunJanitor(ctx context.Context, in *RunJanitorRequest, opts ...grpc.CallOption) (*RunJanitorResponse, error) {
+	out := new(RunJanitorResponse)
+	err := c.cc.Invoke(ctx, "/filesystem.FileAdmin/RunJanitor", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *fileAdminClient) Drain(ctx context.Context, in *DrainRequest, opts ...grpc.CallOption) (*StatusResponse, error) {
+	out := new(StatusResponse)
+	err := c.cc.Invoke(ctx, "/filesystem.FileAdmin/Drain", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *fileAdminClient) GetStats(ctx context.Context, in *StatsRequest, opts ...grpc.CallOption) (*ServerStats, error) {
+	out := new(ServerStats)
+	err := c.cc.Invoke(ctx, "/filesystem.FileAdmin/GetStats", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *fileAdminClient) SetRange(ctx context.Context, in *SetRangeRequest, opts ...grpc.CallOption) (*StatusResponse, error) {
+	out := new(StatusResponse)
+	err := c.cc.Invoke(ctx, "/filesystem.FileAdmin/SetRange", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// FileAdminServer is the server API for FileAdmin service.
+// All implementations must embed UnimplementedFileAdminServer
+// for forward compatibility
+type FileAdminServer interface {
+	// Takes a snapshot right away. Fails with FailedPrecondition if snapshots aren't enabled.
+	TakeSnapshot(context.Context, *TakeSnapshotRequest) (*TakeSnapshotResponse, error)
+	// Applies the retention policies and archives cold files right away, like the janitor does.
+	RunJanitor(context.Context, *RunJanitorRequest) (*RunJanitorResponse, error)
+	// Stops taking writes (or takes them again), i.e., before taking the server down. Writes fail
+	// with Unavailable while the server drains.
+	Drain(context.Context, *DrainRequest) (*StatusResponse, error)
+	// Returns the server's usage, limits and admission counters.
+	GetStats(context.Context, *StatsRequest) (*ServerStats, error)
+	// Changes the prefix range the server serves, i.e., to move files to another server.
+	SetRange(context.Context, *SetRangeRequest) (*StatusResponse, error)
+	mustEmbedUnimplementedFileAdminServer()
+}
+
+// UnimplementedFileAdminServer must be embedded to have forward compatible implementations.
+type UnimplementedFileAdminServer struct {
+}
+
+func (UnimplementedFileAdminServer) TakeSnapshot(context.Context, *TakeSnapshotRequest) (*TakeSnapshotResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method TakeSnapshot not implemented")
+}
+func (UnimplementedFileAdminServer) RunJanitor(context.Context, *RunJanitorRequest) (*RunJanitorResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RunJanitor not implemented")
+}
+func (UnimplementedFileAdminServer) Drain(context.Context, *DrainRequest) (*StatusResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Drain not implemented")
+}
+func (UnimplementedFileAdminServer) GetStats(context.Context, *StatsRequest) (*ServerStats, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetStats not implemented")
+}
+func (UnimplementedFileAdminServer) SetRange(context.Context, *SetRangeRequest) (*StatusResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SetRange not implemented")
+}
+func (UnimplementedFileAdminServer) mustEmbedUnimplementedFileAdminServer() {}
+
+// UnsafeFileAdminServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to FileAdminServer will
+// result in compilation errors.
+type UnsafeFileAdminServer interface {
+	mustEmbedUnimplementedFileAdminServer()
+}
+
+func RegisterFileAdminServer(s grpc.ServiceRegistrar, srv FileAdminServer) {
+	s.RegisterService(&FileAdmin_ServiceDesc, srv)
+}
+
+func _FileAdmin_TakeSnapshot_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(TakeSnapshotRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FileAdminServer).TakeSnapshot(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/filesystem.FileAdmin/TakeSnapshot",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FileAdminServer).TakeSnapshot(ctx, req.(*TakeSnapshotRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _FileAdmin_RunJanitor_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RunJanitorRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FileAdminServer).RunJanitor(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/filesystem.FileAdmin/RunJanitor",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FileAdminServer).RunJanitor(ctx, req.(*RunJanitorRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _FileAdmin_Drain_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DrainRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FileAdminServer).Drain(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/filesystem.FileAdmin/Drain",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FileAdminServer).Drain(ctx, req.(*DrainRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _FileAdmin_GetStats_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(StatsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FileAdminServer).GetStats(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/filesystem.FileAdmin/GetStats",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FileAdminServer).GetStats(ctx, req.(*StatsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _FileAdmin_SetRange_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SetRangeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FileAdminServer).SetRange(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/filesystem.FileAdmin/SetRange",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FileAdminServer).SetRange(ctx, req.(*SetRangeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// FileAdmin_ServiceDesc is the grpc.ServiceDesc for FileAdmin service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var FileAdmin_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "filesystem.FileAdmin",
+	HandlerType: (*FileAdminServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "TakeSnapshot",
+			Handler:    _FileAdmin_TakeSnapshot_Handler,
+		},
+		{
+			MethodName: "RunJanitor",
+			Handler:    _FileAdmin_RunJanitor_Handler,
+		},
+		{
+			MethodName: "Drain",
+			Handler:    _FileAdmin_Drain_Handler,
+		},
+		{
+			MethodName: "GetStats",
+			Handler:    _FileAdmin_GetStats_Handler,
+		},
+		{
+			MethodName: "SetRange",
+			Handler:    _FileAdmin_SetRange_Handler,
 		},
 	},
+	Streams:  []grpc.StreamDesc{},
 	Metadata: "filesystem.proto",
 }
@@ -0,0 +1,140 @@
+package search
+
+import (
+	"math"
+	"sort"
+	"sync"
+
+	"github.com/basharal/filesystem/fspath"
+)
+
+// BM25 parameters. k1 bounds how much repeating a term raises the score and b how much longer
+// files are penalized.
+const (
+	k1 = 1.2
+	b  = 0.75
+)
+
+// Memory is an Index kept in memory that ranks files with BM25. It only keeps the terms of files,
+// not their text.
+type Memory struct {
+	mu   sync.RWMutex
+	docs map[string]*doc
+	// postings are the frequencies of terms in the files that have them.
+	postings map[string]map[*doc]int
+	// length is the total number of terms in all files.
+	length int
+}
+
+type doc struct {
+	path string
+	// terms are the distinct terms of the file, so that it can be removed from postings.
+	terms  []string
+	length int
+}
+
+// NewMemory returns an empty Memory.
+func NewMemory() *Memory {
+	return &Memory{docs: make(map[string]*doc), postings: make(map[string]map[*doc]int)}
+}
+
+func (m *Memory) Index(path, text string) error {
+	terms := Terms(text)
+	freqs := make(map[string]int)
+	for _, term := range terms {
+		freqs[term]++
+	}
+	d := &doc{path: path, terms: make([]string, 0, len(freqs)), length: len(terms)}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.delete(path)
+	for term, freq := range freqs {
+		d.terms = append(d.terms, term)
+		docs, ok := m.postings[term]
+		if !ok {
+			docs = make(map[*doc]int)
+			m.postings[term] = docs
+		}
+		docs[d] = freq
+	}
+	m.docs[path] = d
+	m.length += d.length
+	return nil
+}
+
+func (m *Memory) Delete(path string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.delete(path)
+	return nil
+}
+
+func (m *Memory) DeletePrefix(prefix string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for path := range m.docs {
+		if fspath.HasPrefix(path, prefix) {
+			m.delete(path)
+		}
+	}
+	return nil
+}
+
+// delete must be called with mu held.
+func (m *Memory) delete(path string) {
+	d, ok := m.docs[path]
+	if !ok {
+		return
+	}
+	for _, term := range d.terms {
+		docs := m.postings[term]
+		delete(docs, d)
+		if len(docs) == 0 {
+			delete(m.postings, term)
+		}
+	}
+	delete(m.docs, path)
+	m.length -= d.length
+}
+
+func (m *Memory) Search(query, prefix string, max int) ([]Hit, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if len(m.docs) == 0 {
+		return nil, nil
+	}
+	n := float64(len(m.docs))
+	avgLength := float64(m.length) / n
+	scores := make(map[*doc]float64)
+	seen := make(map[string]bool)
+	for _, term := range Terms(query) {
+		if seen[term] {
+			continue
+		}
+		seen[term] = true
+		docs := m.postings[term]
+		df := float64(len(docs))
+		idf := math.Log(1 + (n-df+0.5)/(df+0.5))
+		for d, freq := range docs {
+			if !fspath.HasPrefix(d.path, prefix) {
+				continue
+			}
+			tf := float64(freq)
+			scores[d] += idf * tf * (k1 + 1) / (tf + k1*(1-b+b*float64(d.length)/avgLength))
+		}
+	}
+	hits := make([]Hit, 0, len(scores))
+	for d, score := range scores {
+		hits = append(hits, Hit{Path: d.path, Score: score})
+	}
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Score != hits[j].Score {
+			return hits[i].Score > hits[j].Score
+		}
+		return hits[i].Path < hits[j].Path
+	})
+	if len(hits) > max {
+		hits = hits[:max]
+	}
+	return hits, nil
+}
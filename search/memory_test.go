@@ -0,0 +1,83 @@
+package search
+
+import (
+	"reflect"
+	"testing"
+)
+
+func paths(hits []Hit) []string {
+	paths := make([]string, 0, len(hits))
+	for _, hit := range hits {
+		paths = append(paths, hit.Path)
+	}
+	return paths
+}
+
+func TestMemory_Search(t *testing.T) {
+	m := NewMemory()
+	files := map[string]string{
+		"/docs/install.md":   "Install the server, then install the CLI.",
+		"/docs/usage.md":     "Start the server with -port. The CLI connects to the server.",
+		"/logs/server.log":   "ERROR: server failed to start. ERROR: port in use.",
+		"/logs/client.log":   "connected to localhost:8080",
+		"/docs/unrelated.md": "Nothing to see here.",
+	}
+	for path, text := range files {
+		if err := m.Index(path, text); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		query  string
+		prefix string
+		max    int
+		want   []string
+	}{
+		// Files repeating a rarer term rank higher.
+		{"install", "/", 10, []string{"/docs/install.md"}},
+		{"ERROR port", "/", 10, []string{"/logs/server.log", "/docs/usage.md"}},
+		{"server", "/docs", 10, []string{"/docs/usage.md", "/docs/install.md"}},
+		{"server", "/", 1, []string{"/docs/usage.md"}},
+		{"localhost", "/docs", 10, []string{}},
+		{"missing", "/", 10, []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			hits, err := m.Search(tt.query, tt.prefix, tt.max)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := paths(hits); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Search(%q, %q) = %v, want %v", tt.query, tt.prefix, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMemory_Delete(t *testing.T) {
+	m := NewMemory()
+	for _, path := range []string{"/a/f1", "/a/b/f2", "/ab/f3"} {
+		if err := m.Index(path, "hello world"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Reindexing replaces the terms of a file.
+	if err := m.Index("/ab/f3", "goodbye"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.DeletePrefix("/a"); err != nil {
+		t.Fatal(err)
+	}
+	if hits, _ := m.Search("hello", "/", 10); len(hits) != 0 {
+		t.Errorf("Search(hello) = %v after deletion, want none", paths(hits))
+	}
+	if hits, _ := m.Search("goodbye", "/", 10); !reflect.DeepEqual(paths(hits), []string{"/ab/f3"}) {
+		t.Errorf("Search(goodbye) = %v, want /ab/f3", paths(hits))
+	}
+	if err := m.Delete("/ab/f3"); err != nil {
+		t.Fatal(err)
+	}
+	if len(m.docs) != 0 || len(m.postings) != 0 || m.length != 0 {
+		t.Errorf("index isn't empty after deleting all files: %d docs, %d terms", len(m.docs), len(m.postings))
+	}
+}
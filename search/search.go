@@ -0,0 +1,37 @@
+// Package search provides full-text indexes of file content, so that files can be searched by the
+// words in them rather than by name (i.e., documentation or logs).
+package search
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Index is a thread-safe full-text index of files, keyed by their absolute paths. Implementations
+// can wrap a search library (i.e., bleve).
+type Index interface {
+	// Index replaces what's indexed for the file at path with text.
+	Index(path, text string) error
+
+	// Delete removes the file at path. Deleting a missing file isn't an error.
+	Delete(path string) error
+
+	// DeletePrefix removes the files at and under prefix.
+	DeletePrefix(prefix string) error
+
+	// Search returns up to max files at or under prefix matching query, best first.
+	Search(query, prefix string, max int) ([]Hit, error)
+}
+
+// Hit is a file matching a search. Scores are only comparable within the same search.
+type Hit struct {
+	Path  string
+	Score float64
+}
+
+// Terms returns the lower-cased words of text, which is how text and queries are tokenized.
+func Terms(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
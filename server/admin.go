@@ -0,0 +1,127 @@
+package server
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/basharal/filesystem/fs"
+	"github.com/basharal/filesystem/fspath"
+	"github.com/basharal/filesystem/proto/pb_filesystem"
+	"github.com/golang/glog"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// adminServer serves the FileAdmin service of a Server. It's a separate type since some of its
+// RPCs share names with the Server's own methods.
+type adminServer struct {
+	pb_filesystem.UnimplementedFileAdminServer
+
+	s *Server
+}
+
+// prefixes returns the range of the server. See SetRange.
+func (s *Server) prefixes() (start, end string) {
+	s.rangeMu.RLock()
+	defer s.rangeMu.RUnlock()
+	return s.start, s.end
+}
+
+// SetRange changes the range of the server, with the same constraints as Opts.StartPrefix and
+// Opts.EndPrefix. Files outside the new range are kept but no longer served; moving them to the
+// server taking the range over is up to the caller (see fsctl migrate). Snapshots keep the key
+// prefix of the range the server started with.
+func (s *Server) SetRange(start, end string) error {
+	if err := validRange(start, end); err != nil {
+		return err
+	}
+	s.rangeMu.Lock()
+	defer s.rangeMu.Unlock()
+	s.start, s.end = start, end
+	return nil
+}
+
+// Drain stops the server from taking writes when drain is true, and has it take them again
+// otherwise. Reads are served either way, but the server isn't Ready while it drains, so that load
+// balancers move clients elsewhere.
+func (s *Server) Drain(drain bool) {
+	var v int32
+	if drain {
+		v = 1
+	}
+	atomic.StoreInt32(&s.draining, v)
+}
+
+// Draining returns true if the server doesn't take writes. See Drain.
+func (s *Server) Draining() bool {
+	return atomic.LoadInt32(&s.draining) == 1
+}
+
+// writable returns an Unavailable status while the server drains, so that clients retry writes
+// elsewhere or later, and a FailedPrecondition one on read replicas.
+func (s *Server) writable() error {
+	if s.replicaOf != "" {
+		return status.Errorf(codes.FailedPrecondition, "server is a read-only replica of %s", s.replicaOf)
+	}
+	if s.Draining() {
+		return status.Errorf(codes.Unavailable, "server is draining")
+	}
+	return nil
+}
+
+func (a *adminServer) TakeSnapshot(ctx context.Context, in *pb_filesystem.TakeSnapshotRequest) (*pb_filesystem.TakeSnapshotResponse, error) {
+	glog.V(1).Infof("Start TakeSnapshot\n")
+	defer glog.V(1).Infof("End TakeSnapshot\n")
+	if a.s.snapshots == nil {
+		return nil, status.Errorf(codes.FailedPrecondition, "snapshots aren't enabled")
+	}
+	now := time.Now()
+	if err := a.s.snapshots.take(a.s.fs.(fs.Snapshotter), now); err != nil {
+		return nil, toStatus(err)
+	}
+	return &pb_filesystem.TakeSnapshotResponse{TakenUnixMs: now.UnixNano() / int64(time.Millisecond)}, nil
+}
+
+func (a *adminServer) RunJanitor(ctx context.Context, in *pb_filesystem.RunJanitorRequest) (*pb_filesystem.RunJanitorResponse, error) {
+	glog.V(1).Infof("Start RunJanitor\n")
+	defer glog.V(1).Infof("End RunJanitor\n")
+	removed, archived := a.s.janitor(time.Now())
+	return &pb_filesystem.RunJanitorResponse{Removed: removed, Archived: archived}, nil
+}
+
+func (a *adminServer) Drain(ctx context.Context, in *pb_filesystem.DrainRequest) (*pb_filesystem.StatusResponse, error) {
+	glog.Infof("Drain %t\n", in.Drain)
+	a.s.Drain(in.Drain)
+	return &pb_filesystem.StatusResponse{Status: pb_filesystem.Status_SUCCESS}, nil
+}
+
+func (a *adminServer) GetStats(ctx context.Context, in *pb_filesystem.StatsRequest) (*pb_filesystem.ServerStats, error) {
+	_, root, err := a.s.fs.Stat(fspath.Root)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	usage := root.Usage()
+	admission := a.s.admission.stats()
+	return &pb_filesystem.ServerStats{
+		Files:          usage.Files,
+		Dirs:           usage.Dirs,
+		Size:           usage.Size,
+		MaxFileSize:    atomic.LoadInt64(&a.s.maxFileSize),
+		MemoryBudget:   a.s.admission.limit(),
+		InFlightBytes:  admission.InFlightBytes,
+		RejectedWrites: admission.Rejected,
+		Draining:       a.s.Draining(),
+		Ready:          a.s.Ready(),
+		Requests:       atomic.LoadInt64(&a.s.requests),
+		ChecksumErrors: a.s.ChecksumErrors(),
+	}, nil
+}
+
+func (a *adminServer) SetRange(ctx context.Context, in *pb_filesystem.SetRangeRequest) (*pb_filesystem.StatusResponse, error) {
+	glog.Infof("SetRange %s %s\n", in.StartPrefix, in.EndPrefix)
+	if err := a.s.SetRange(in.StartPrefix, in.EndPrefix); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid range (%s, %s). %s", in.StartPrefix, in.EndPrefix, err)
+	}
+	return &pb_filesystem.StatusResponse{Status: pb_filesystem.Status_SUCCESS}, nil
+}
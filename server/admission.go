@@ -0,0 +1,118 @@
+package server
+
+import (
+	"io"
+	"sync/atomic"
+
+	"github.com/basharal/filesystem/fspath"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// AdmissionStats are the counters of the admission control of writes.
+type AdmissionStats struct {
+	// InFlightBytes is how much of the uploads in progress has been received.
+	InFlightBytes int64
+	// Rejected is the number of writes rejected for going over the memory budget.
+	Rejected int64
+}
+
+// admission turns writes away once the filesystem's size plus the uploads in flight reach the
+// memory budget, so that the server rejects writes before running out of memory.
+type admission struct {
+	// budget is 0 without a budget. The fields are accessed atomically.
+	budget   int64
+	inFlight int64
+	rejected int64
+}
+
+func (a *admission) limit() int64 {
+	return atomic.LoadInt64(&a.budget)
+}
+
+// overBudget returns the error of a rejected write. Unavailable tells clients to retry later,
+// once files were removed or uploads finished.
+func (a *admission) overBudget() error {
+	atomic.AddInt64(&a.rejected, 1)
+	return status.Errorf(codes.Unavailable, "memory budget of %d bytes is used up, retry later", a.limit())
+}
+
+// admit returns an error if a write adding size bytes to a filesystem of usage bytes doesn't fit.
+func (a *admission) admit(usage, size int64) error {
+	if usage+atomic.LoadInt64(&a.inFlight)+size > a.limit() {
+		return a.overBudget()
+	}
+	return nil
+}
+
+func (a *admission) stats() AdmissionStats {
+	return AdmissionStats{InFlightBytes: atomic.LoadInt64(&a.inFlight), Rejected: atomic.LoadInt64(&a.rejected)}
+}
+
+// admittedReader counts what's read from an upload as in flight until it's released, and fails
+// once the budget is exceeded midway.
+type admittedReader struct {
+	a     *admission
+	r     io.Reader
+	usage int64
+	n     int64
+}
+
+func (r *admittedReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n += int64(n)
+	inFlight := atomic.AddInt64(&r.a.inFlight, int64(n))
+	if budget := r.a.limit(); budget > 0 && r.usage+inFlight > budget {
+		return 0, r.a.overBudget()
+	}
+	return n, err
+}
+
+// release stops counting the upload as in flight, once it was written (and so is part of the
+// filesystem's size) or failed.
+func (r *admittedReader) release() {
+	atomic.AddInt64(&r.a.inFlight, -r.n)
+	r.n = 0
+}
+
+// usage returns the total size of the files, as the root reports it.
+func (s *Server) usage() (int64, error) {
+	_, root, err := s.fs.Stat(fspath.Root)
+	if err != nil {
+		return 0, err
+	}
+	return root.Usage().Size, nil
+}
+
+// admitWrite returns a reader counting r against the memory budget, or an error if the budget is
+// used up already. release must be called once the write is done. r is returned as is without a
+// budget.
+func (s *Server) admitWrite(r io.Reader) (_ io.Reader, release func(), err error) {
+	if s.admission.limit() == 0 {
+		return r, func() {}, nil
+	}
+	usage, err := s.usage()
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := s.admission.admit(usage, 0); err != nil {
+		return nil, nil, err
+	}
+	admitted := &admittedReader{a: s.admission, r: r, usage: usage}
+	return admitted, admitted.release, nil
+}
+
+// AdmissionStats returns the counters of the admission control of writes. It returns false
+// without a memory budget.
+func (s *Server) AdmissionStats() (AdmissionStats, bool) {
+	if s.admission.limit() == 0 {
+		return AdmissionStats{}, false
+	}
+	return s.admission.stats(), true
+}
+
+// SetMemoryBudget changes the memory budget (see Opts.MemoryBudget) of a running server. Uploads
+// in flight are held to the new budget from their next chunk on.
+func (s *Server) SetMemoryBudget(budget int64) {
+	atomic.StoreInt64(&s.admission.budget, budget)
+}
@@ -0,0 +1,41 @@
+package server
+
+import (
+	"fmt"
+
+	"github.com/basharal/filesystem/fs"
+	"github.com/basharal/filesystem/fs/sqlfs"
+)
+
+// Backend selects where a server keeps its filesystem when Opts.FileSystem isn't set.
+type Backend string
+
+const (
+	// BackendMemory keeps the filesystem in memory. It's the default.
+	BackendMemory Backend = "memory"
+
+	// BackendSQLite keeps metadata and content in the SQLite database at Opts.SQLitePath. The
+	// binary must register a SQLite driver named sqlfs.DriverName (i.e., by importing
+	// github.com/mattn/go-sqlite3).
+	BackendSQLite Backend = "sqlite"
+)
+
+// newBackend returns the filesystem of opts.Backend. fsOpts only apply to the in-memory one.
+func newBackend(opts Opts, fsOpts fs.Opts) (fs.Interface, error) {
+	switch opts.Backend {
+	case "", BackendMemory:
+		if opts.GroupCommit != 0 {
+			return nil, fmt.Errorf("group commits require the sqlite backend")
+		}
+		return fs.NewWithOpts(fsOpts), nil
+	case BackendSQLite:
+		if fsOpts != (fs.Opts{}) {
+			return nil, fmt.Errorf("filesystem options can't be set with the sqlite backend")
+		}
+		if opts.SQLitePath == "" {
+			return nil, fmt.Errorf("sqlite backend requires a database path")
+		}
+		return sqlfs.OpenWithOpts(opts.SQLitePath, sqlfs.Opts{GroupCommit: opts.GroupCommit})
+	}
+	return nil, fmt.Errorf("unknown backend %s", opts.Backend)
+}
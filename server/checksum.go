@@ -0,0 +1,27 @@
+package server
+
+import (
+	"hash/crc32"
+	"sync/atomic"
+
+	"github.com/basharal/filesystem/proto/pb_filesystem"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// checkPayload fails with DataLoss if payload carries a checksum its data doesn't match, counting
+// the mismatch.
+func (s *Server) checkPayload(payload *pb_filesystem.FilePayload) error {
+	if !payload.GetHasCrc32c() || crc32.Checksum(payload.GetData(), castagnoli) == payload.GetCrc32c() {
+		return nil
+	}
+	atomic.AddInt64(&s.checksumErrors, 1)
+	return status.Errorf(codes.DataLoss, "a chunk of %d bytes doesn't match its checksum", len(payload.GetData()))
+}
+
+// ChecksumErrors returns how many upload chunks didn't match their checksum.
+func (s *Server) ChecksumErrors() int64 {
+	return atomic.LoadInt64(&s.checksumErrors)
+}
@@ -0,0 +1,34 @@
+package server
+
+import (
+	"context"
+
+	"github.com/basharal/filesystem/fs"
+	"github.com/basharal/filesystem/proto/pb_filesystem"
+	"github.com/golang/glog"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Returns the Merkle digest of the file/dir at path and the digests of its entries.
+func (s *Server) Digest(ctx context.Context, in *pb_filesystem.Path) (*pb_filesystem.DigestResponse, error) {
+	glog.V(1).Infof("Start Digest %s\n", in.Path)
+	defer glog.V(1).Infof("End Digest %s\n", in.Path)
+	digester, ok := s.fs.(fs.Digester)
+	if !ok {
+		return nil, status.Errorf(codes.Unimplemented, "the filesystem doesn't support digests")
+	}
+	path, err := s.validatePath(in.Path)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid path (%s). %s", in.Path, err)
+	}
+	sum, entries, err := digester.Digest(path)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	resp := &pb_filesystem.DigestResponse{Sum: sum, Entries: make([]*pb_filesystem.EntryDigest, 0, len(entries))}
+	for _, e := range entries {
+		resp.Entries = append(resp.Entries, &pb_filesystem.EntryDigest{Name: e.Name, IsDir: e.IsDir, Sum: e.Sum})
+	}
+	return resp, nil
+}
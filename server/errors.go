@@ -0,0 +1,69 @@
+package server
+
+import (
+	"context"
+	"errors"
+
+	"github.com/basharal/filesystem/fs"
+	"github.com/basharal/filesystem/proto/pb_filesystem"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// statusCodes maps filesystem errors to gRPC status codes. Errors sharing a code are told apart by
+// the reason of the PathError detail.
+var statusCodes = []struct {
+	err  error
+	code codes.Code
+}{
+	{fs.ErrNotFound, codes.NotFound},
+	{fs.ErrAlreadyExist, codes.AlreadyExists},
+	{fs.ErrInvalidName, codes.InvalidArgument},
+	{fs.ErrNotSupported, codes.Unimplemented},
+	{fs.ErrDirNotEmpty, codes.FailedPrecondition},
+	{fs.ErrTimeout, codes.DeadlineExceeded},
+	{fs.ErrLimitExceeded, codes.ResourceExhausted},
+	{fs.ErrBusy, codes.Aborted},
+	{fs.ErrConflict, codes.Aborted},
+	// Streams fail with their context's error once the client cancels or disconnects.
+	{context.Canceled, codes.Canceled},
+	{context.DeadlineExceeded, codes.DeadlineExceeded},
+	// Rejections of validators that don't say otherwise (i.e., with fs.ErrLimitExceeded).
+	{fs.ErrRejected, codes.InvalidArgument},
+}
+
+// toStatus converts filesystem errors to gRPC statuses so that clients can tell them apart. The
+// operation and path of an fs.PathError are attached as a detail, with what exists for an
+// fs.ExistsError and why content was rejected for an fs.RejectedError.
+func toStatus(err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	code, reason := codes.Unknown, ""
+	for _, sc := range statusCodes {
+		if errors.Is(err, sc.err) {
+			code, reason = sc.code, sc.err.Error()
+			break
+		}
+	}
+	st := status.New(code, err.Error())
+	var pe *fs.PathError
+	if errors.As(err, &pe) {
+		detail := &pb_filesystem.PathError{Op: pe.Op, Path: pe.Path, Reason: reason}
+		var ee *fs.ExistsError
+		if errors.As(err, &ee) {
+			detail.Existing, detail.ExistingDir = ee.Path, ee.IsDir
+		}
+		var re *fs.RejectedError
+		if errors.As(err, &re) {
+			detail.RejectedBy, detail.RejectedAt, detail.Rejection = re.Validator, re.Offset, re.Err.Error()
+		}
+		if detailed, err := st.WithDetails(detail); err == nil {
+			st = detailed
+		}
+	}
+	return st.Err()
+}
@@ -0,0 +1,83 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/basharal/filesystem/fspath"
+)
+
+// livenessTimeout is how long the filesystem has to answer a liveness check.
+const livenessTimeout = 5 * time.Second
+
+// SetReady marks the server as ready to take requests or not. ListenAndServe does it once it
+// listens and before it stops. Applications serving it with RegisterWith do it themselves.
+func (s *Server) SetReady(ready bool) {
+	var v int32
+	if ready {
+		v = 1
+	}
+	atomic.StoreInt32(&s.ready, v)
+}
+
+// Ready returns true if the server takes requests: its filesystem was recovered (which New does)
+// and its service is registered and listening. It's false again once the server is stopping, and
+// while it drains.
+func (s *Server) Ready() bool {
+	return atomic.LoadInt32(&s.ready) == 1 && !s.Draining()
+}
+
+// Live returns an error if the filesystem doesn't answer within livenessTimeout, i.e., it's stuck
+// on a lock and the process needs a restart.
+func (s *Server) Live() error {
+	done := make(chan error, 1)
+	go func() {
+		_, _, err := s.fs.Stat(fspath.Root)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(livenessTimeout):
+		return fmt.Errorf("filesystem didn't answer within %s", livenessTimeout)
+	}
+}
+
+// HealthHandler serves the checks of orchestrators (i.e., Kubernetes probes): /livez fails if the
+// process needs a restart (see Live), /readyz fails until the server takes requests (see Ready)
+// and /healthz fails if either does.
+func (s *Server) HealthHandler() http.Handler {
+	mux := http.NewServeMux()
+	live := func(w http.ResponseWriter) bool {
+		if err := s.Live(); err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return false
+		}
+		return true
+	}
+	ready := func(w http.ResponseWriter) bool {
+		if !s.Ready() {
+			http.Error(w, "not ready", http.StatusServiceUnavailable)
+			return false
+		}
+		return true
+	}
+	mux.HandleFunc("/livez", func(w http.ResponseWriter, r *http.Request) {
+		if live(w) {
+			fmt.Fprintln(w, "ok")
+		}
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		if ready(w) {
+			fmt.Fprintln(w, "ok")
+		}
+	})
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		if live(w) && ready(w) {
+			fmt.Fprintln(w, "ok")
+		}
+	})
+	return mux
+}
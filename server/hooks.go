@@ -0,0 +1,171 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"plugin"
+	"sort"
+	"sync"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// Op is an operation hooks are called around.
+type Op string
+
+const (
+	OpRead          Op = "read"
+	OpWrite         Op = "write"
+	OpCreate        Op = "create"
+	OpMakeDir       Op = "mkdir"
+	OpTouch         Op = "touch"
+	OpRemove        Op = "remove"
+	OpDeletePrefix  Op = "deleteprefix"
+	OpCopy          Op = "copy"
+	OpApplyManifest Op = "manifest"
+)
+
+// OpInfo describes an operation to hooks.
+type OpInfo struct {
+	Op Op
+	// Path is the absolute path operated on, or the source of copies.
+	Path string
+	// Dst is the destination of copies.
+	Dst string
+	// Principal is who asked for the operation (see Principal).
+	Principal string
+}
+
+// Hook runs custom logic around the operations of the server (i.e., enforcing naming policies or
+// auditing) without changing it. Hooks are called in order on the goroutine serving the request,
+// once its paths are validated and before anything is created or written, so they must be
+// thread-safe and should be quick. Dry runs aren't hooked.
+//
+// Hooks don't see the content of uploads. Those checking it (i.e., virus scanners) implement
+// UploadValidator as well, which the server runs on the uploads after Opts.UploadValidators, so
+// that rejected content isn't written.
+type Hook interface {
+	// PreOp is called before op runs. An error rejects op: gRPC statuses are returned to the
+	// client as they are, and other errors as PermissionDenied. The PreOps of the hooks after the
+	// rejecting one aren't called.
+	PreOp(ctx context.Context, op OpInfo) error
+
+	// PostOp is called after op ran with the error it failed with, if any. It can't fail op, which
+	// took effect already. It's only called when the PreOps of all the hooks passed.
+	PostOp(ctx context.Context, op OpInfo, err error)
+}
+
+// HookFactory creates a hook from its config, whose format is up to the hook (i.e., a list of
+// patterns or a JSON document).
+type HookFactory func(config string) (Hook, error)
+
+var (
+	hooksMu       sync.RWMutex
+	hookFactories = make(map[string]HookFactory)
+)
+
+// RegisterHook makes a hook available to NewHook by name. It's meant to be called from the init
+// function of the package defining the hook, either compiled into the server binary or loaded as a
+// Go plugin (see LoadHookPlugin). Like database/sql.Register, it panics if name is taken.
+func RegisterHook(name string, factory HookFactory) {
+	hooksMu.Lock()
+	defer hooksMu.Unlock()
+	if factory == nil {
+		panic("server: hook factory is nil")
+	}
+	if _, ok := hookFactories[name]; ok {
+		panic("server: hook " + name + " is registered twice")
+	}
+	hookFactories[name] = factory
+}
+
+// NewHook creates the hook registered as name with config.
+func NewHook(name, config string) (Hook, error) {
+	hooksMu.RLock()
+	factory, ok := hookFactories[name]
+	hooksMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown hook %s (registered: %v)", name, Hooks())
+	}
+	hook, err := factory(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create hook %s. %w", name, err)
+	}
+	return hook, nil
+}
+
+// Hooks returns the names of the registered hooks, sorted.
+func Hooks() []string {
+	hooksMu.RLock()
+	defer hooksMu.RUnlock()
+	names := make([]string, 0, len(hookFactories))
+	for name := range hookFactories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// LoadHookPlugin opens the Go plugin at path, whose init functions register its hooks with
+// RegisterHook. Plugins must be built with the same Go version and dependencies as the server, and
+// are only supported where Go supports them (i.e., Linux and macOS).
+func LoadHookPlugin(path string) error {
+	if _, err := plugin.Open(path); err != nil {
+		return fmt.Errorf("failed to load hook plugin %s. %w", path, err)
+	}
+	return nil
+}
+
+type principalKey struct{}
+
+// WithPrincipal returns a copy of ctx carrying who its request is from, for hooks. Applications
+// authenticating clients set it from their interceptors (see RegisterWith).
+func WithPrincipal(ctx context.Context, principal string) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+// Principal returns who the request of ctx is from: what WithPrincipal set, or else the address
+// of the client.
+func Principal(ctx context.Context) string {
+	if principal, ok := ctx.Value(principalKey{}).(string); ok {
+		return principal
+	}
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		return p.Addr.String()
+	}
+	return ""
+}
+
+// preOp calls the PreOp of the hooks for op on path (and dst for copies), returning the error to
+// fail the request with if one rejects it.
+func (s *Server) preOp(ctx context.Context, op Op, path, dst string) (OpInfo, error) {
+	info := OpInfo{Op: op, Path: path, Dst: dst}
+	if len(s.hooks) == 0 {
+		return info, nil
+	}
+	info.Principal = Principal(ctx)
+	for _, hook := range s.hooks {
+		err := hook.PreOp(ctx, info)
+		if err == nil {
+			continue
+		}
+		if _, ok := status.FromError(err); ok {
+			return info, err
+		}
+		return info, status.Errorf(codes.PermissionDenied, "%s %s rejected. %s", op, path, err)
+	}
+	return info, nil
+}
+
+// postOp calls the PostOp of the hooks for op, which failed with err if it isn't nil. Zero ops are
+// the ones of requests that weren't hooked (i.e., dry runs).
+func (s *Server) postOp(ctx context.Context, op OpInfo, err error) {
+	if op.Op == "" {
+		return
+	}
+	for _, hook := range s.hooks {
+		hook.PostOp(ctx, op, err)
+	}
+}
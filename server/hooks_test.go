@@ -0,0 +1,178 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/basharal/filesystem/proto/pb_filesystem"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// recordingHook records its calls in calls, rejecting ops with reject.
+type recordingHook struct {
+	name   string
+	calls  *[]string
+	reject error
+}
+
+func (h *recordingHook) PreOp(ctx context.Context, op OpInfo) error {
+	*h.calls = append(*h.calls, fmt.Sprintf("%s pre %s %s", h.name, op.Op, op.Path))
+	return h.reject
+}
+
+func (h *recordingHook) PostOp(ctx context.Context, op OpInfo, err error) {
+	*h.calls = append(*h.calls, fmt.Sprintf("%s post %s %v", h.name, op.Op, err != nil))
+}
+
+// scanHook is a hook that also checks uploads, rejecting the ones with a signature.
+type scanHook struct {
+	recordingHook
+}
+
+func (h *scanHook) Name() string {
+	return "scan"
+}
+
+func (h *scanHook) NewCheck(path string) UploadCheck {
+	return &signatureCheck{}
+}
+
+type signatureCheck struct{}
+
+func (c *signatureCheck) Check(p []byte) error {
+	if bytes.Contains(p, []byte("EICAR")) {
+		return errors.New("infected")
+	}
+	return nil
+}
+
+func (c *signatureCheck) Done() error {
+	return nil
+}
+
+func TestServer_Hooks(t *testing.T) {
+	denied := errors.New("bad name")
+	tests := []struct {
+		name      string
+		path      string
+		rejects   []error
+		wantCode  codes.Code
+		wantCalls []string
+	}{
+		{
+			name:     "in order",
+			path:     "/ax/",
+			rejects:  []error{nil, nil},
+			wantCode: codes.OK,
+			wantCalls: []string{
+				"h0 pre mkdir /ax/", "h1 pre mkdir /ax/", "h0 post mkdir false", "h1 post mkdir false",
+			},
+		},
+		{
+			name:     "canonical path",
+			path:     "/z/../ax/",
+			rejects:  []error{nil},
+			wantCode: codes.OK,
+			wantCalls: []string{
+				"h0 pre mkdir /ax/", "h0 post mkdir false",
+			},
+		},
+		{
+			name:      "rejected",
+			path:      "/ax/",
+			rejects:   []error{denied, nil},
+			wantCode:  codes.PermissionDenied,
+			wantCalls: []string{"h0 pre mkdir /ax/"},
+		},
+		{
+			name:      "rejected with a status",
+			path:      "/ax/",
+			rejects:   []error{nil, status.Error(codes.FailedPrecondition, "not now")},
+			wantCode:  codes.FailedPrecondition,
+			wantCalls: []string{"h0 pre mkdir /ax/", "h1 pre mkdir /ax/"},
+		},
+		{
+			name:     "failed op",
+			path:     "/a/",
+			rejects:  []error{nil},
+			wantCode: codes.AlreadyExists,
+			wantCalls: []string{
+				"h0 pre mkdir /a/", "h0 post mkdir true",
+			},
+		},
+		{
+			name:     "invalid path",
+			path:     "/z/",
+			rejects:  []error{nil},
+			wantCode: codes.InvalidArgument,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls []string
+			var hooks []Hook
+			for i, reject := range tt.rejects {
+				hooks = append(hooks, &recordingHook{name: fmt.Sprintf("h%d", i), calls: &calls, reject: reject})
+			}
+			s := newTestServer(t, Opts{Hooks: hooks})
+			_, err := s.MakeDir(context.Background(), &pb_filesystem.Path{Path: tt.path})
+			if status.Code(err) != tt.wantCode {
+				t.Errorf("Server.MakeDir(%s) = %v, want %v", tt.path, err, tt.wantCode)
+			}
+			if !reflect.DeepEqual(calls, tt.wantCalls) {
+				t.Errorf("hooks were called with %q, want %q", calls, tt.wantCalls)
+			}
+			// Rejected ops don't take effect.
+			_, dir, _ := s.fs.Stat("/ax/")
+			if made := dir != nil; made != (tt.wantCode == codes.OK) {
+				t.Errorf("Server.MakeDir(%s) made the dir: %v", tt.path, made)
+			}
+		})
+	}
+}
+
+func TestServer_HookChecksUploads(t *testing.T) {
+	var calls []string
+	hook := &scanHook{recordingHook{name: "scan", calls: &calls}}
+	s := newTestServer(t, Opts{Hooks: []Hook{hook}, UploadValidators: []UploadValidator{MaxUploadSize(100)}})
+	tests := []struct {
+		path    string
+		content string
+		wantErr bool
+	}{
+		{path: "/aclean", content: "hello"},
+		{path: "/ainfected", content: "hello EICAR", wantErr: true},
+	}
+	for _, tt := range tests {
+		if err := s.fs.NewFile(tt.path); err != nil {
+			t.Fatal(err)
+		}
+		calls = nil
+		stream := newUpload(tt.path, nil, tt.content)
+		err := s.WriteFile(stream)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Server.WriteFile(%s) = %v, wantErr %v", tt.path, err, tt.wantErr)
+		}
+		want := []string{fmt.Sprintf("scan pre write %s", tt.path), fmt.Sprintf("scan post write %v", tt.wantErr)}
+		if !reflect.DeepEqual(calls, want) {
+			t.Errorf("hook was called with %q, want %q", calls, want)
+		}
+		var buf bytes.Buffer
+		if _, err := s.fs.Read(tt.path, &buf); err != nil {
+			t.Fatal(err)
+		}
+		if written := buf.Len() > 0; written == tt.wantErr {
+			t.Errorf("Server.WriteFile(%s) wrote %q", tt.path, buf.String())
+		}
+	}
+	// The hook checks after the validators, as "scan".
+	err := s.WriteFile(newUpload("/ainfected", nil, "EICAR"))
+	if detail := pathErrorOf(err); detail == nil || detail.RejectedBy != "scan" {
+		t.Errorf("Server.WriteFile() = %v, want a rejection by scan", err)
+	}
+}
@@ -0,0 +1,83 @@
+package server
+
+import (
+	"context"
+
+	"github.com/basharal/filesystem/fs"
+	"github.com/basharal/filesystem/proto/pb_filesystem"
+)
+
+// Version is the version of the server reported to clients.
+const Version = "1.1.0"
+
+// features are the optional features the server supports. Clients check them before relying on
+// RPCs that older servers don't have.
+var features = []string{
+	"admin",
+	"copy",
+	"crc32c",
+	"delete_prefix",
+	"dir_usage",
+	"list_entries",
+	"stat",
+	"touch",
+	"validate_path",
+}
+
+// features returns the optional features the server supports, including the ones depending on its
+// options and filesystem.
+func (s *Server) features() []string {
+	supported := append([]string(nil), features...)
+	if _, ok := s.fs.(fs.Digester); ok {
+		supported = append(supported, "digest")
+	}
+	if _, ok := s.fs.(fs.ManifestApplier); ok {
+		supported = append(supported, "manifest")
+	}
+	_, creator := s.fs.(fs.Creator)
+	if _, maker := s.fs.(fs.DirMaker); creator && maker {
+		supported = append(supported, "parents")
+	}
+	if s.snapshots != nil {
+		supported = append(supported, "snapshots")
+	}
+	if s.changes != nil {
+		supported = append(supported, "tail")
+	}
+	if s.index != nil {
+		supported = append(supported, "search")
+	}
+	return supported
+}
+
+// Returns the server's version, the optional features it supports, its range and limits.
+func (s *Server) GetServerInfo(ctx context.Context, in *pb_filesystem.ServerInfoRequest) (*pb_filesystem.ServerInfo, error) {
+	limits := s.fs.Limits()
+	start, end := s.prefixes()
+	info := &pb_filesystem.ServerInfo{
+		Version:               Version,
+		Features:              s.features(),
+		StartPrefix:           start,
+		EndPrefix:             end,
+		MaxReadDurationMs:     limits.MaxReadDuration.Milliseconds(),
+		MaxWriteDurationMs:    limits.MaxWriteDuration.Milliseconds(),
+		MaxRegexDurationMs:    limits.MaxRegexDuration.Milliseconds(),
+		MaxRegexNodes:         int64(limits.MaxRegexNodes),
+		MaxRegexPatternLength: int64(s.regex.MaxPatternLength),
+		MaxRegexResults:       int64(s.regex.MaxResults),
+	}
+	if s.snapshots != nil {
+		info.LastSnapshotAgeMs = -1
+		if age, ok := s.LastSnapshotAge(); ok {
+			info.LastSnapshotAgeMs = age.Milliseconds()
+		}
+	}
+	if s.replicaOf != "" {
+		info.ReplicaOf = s.replicaOf
+		info.ReplicaLagMs = -1
+		if lag, ok := s.ReplicaLag(); ok {
+			info.ReplicaLagMs = lag.Milliseconds()
+		}
+	}
+	return info, nil
+}
@@ -0,0 +1,52 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	"github.com/basharal/filesystem/fs"
+	"github.com/golang/glog"
+)
+
+const defaultJanitorInterval = time.Minute
+
+// runJanitor enforces retention policies and lifecycle rules every interval until ctx is done.
+func (s *Server) runJanitor(ctx context.Context) {
+	ticker := time.NewTicker(s.janitorInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			s.janitor(now)
+		}
+	}
+}
+
+// janitor enforces retention policies and lifecycle rules once, and returns the paths of the files
+// removed and archived. Failures are logged, so that one doesn't hold up the other.
+func (s *Server) janitor(now time.Time) (removed, archived []string) {
+	if retainer, ok := s.fs.(fs.Retainer); ok {
+		var err error
+		removed, err = retainer.ApplyRetention(now)
+		if err != nil {
+			glog.Errorf("Failed to apply retention policies. %s\n", err)
+		}
+		if len(removed) > 0 {
+			glog.Infof("Retention removed %d files.\n", len(removed))
+		}
+	}
+
+	if s.archiveStore == nil {
+		return removed, nil
+	}
+	archived, err := s.fs.(fs.Archiver).Archive(s.archiveStore, s.archiveRules, now)
+	if err != nil {
+		glog.Errorf("Failed to apply lifecycle rules. %s\n", err)
+	}
+	if len(archived) > 0 {
+		glog.Infof("Lifecycle archived %d files.\n", len(archived))
+	}
+	return removed, archived
+}
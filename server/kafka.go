@@ -0,0 +1,99 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/basharal/filesystem/fs"
+)
+
+// KafkaRESTOpts configures a KafkaRESTSink.
+type KafkaRESTOpts struct {
+	// RESTURL is the base URL of a Confluent Kafka REST proxy (i.e., http://localhost:8082).
+	RESTURL string
+
+	// Topic events are produced to.
+	Topic string
+
+	Encoding Encoding
+
+	// Timeout for a single produce request. Defaults to 5s.
+	Timeout time.Duration
+}
+
+// KafkaRESTSink produces events to a Kafka topic through the Confluent Kafka REST proxy (v2 API),
+// which is required: it doesn't speak the Kafka protocol, so it can't produce to brokers directly.
+// Events are keyed by path so that changes to the same path land on the same partition.
+type KafkaRESTSink struct {
+	opts   KafkaRESTOpts
+	url    string
+	client *http.Client
+}
+
+// NewKafkaRESTSink returns a sink producing to opts.Topic.
+func NewKafkaRESTSink(opts KafkaRESTOpts) (*KafkaRESTSink, error) {
+	if opts.RESTURL == "" {
+		return nil, fmt.Errorf("kafka rest url is required")
+	}
+	if opts.Topic == "" {
+		return nil, fmt.Errorf("kafka topic is required")
+	}
+	if opts.Timeout == 0 {
+		opts.Timeout = defaultWebhookTimeout
+	}
+	return &KafkaRESTSink{
+		opts:   opts,
+		url:    strings.TrimSuffix(opts.RESTURL, "/") + "/topics/" + url.PathEscape(opts.Topic),
+		client: &http.Client{Timeout: opts.Timeout},
+	}, nil
+}
+
+type kafkaRecord struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+type kafkaRecords struct {
+	Records []kafkaRecord `json:"records"`
+}
+
+func (s *KafkaRESTSink) Send(ctx context.Context, e fs.Event) error {
+	data, err := encodeEvent(e, s.opts.Encoding)
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(kafkaRecords{Records: []kafkaRecord{{
+		Key:   base64.StdEncoding.EncodeToString([]byte(e.Path)),
+		Value: base64.StdEncoding.EncodeToString(data),
+	}}})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/vnd.kafka.binary.v2+json")
+	res, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status from kafka rest proxy %s", res.Status)
+	}
+	return nil
+}
+
+func (s *KafkaRESTSink) Close() error {
+	s.client.CloseIdleConnections()
+	return nil
+}
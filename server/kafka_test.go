@@ -0,0 +1,124 @@
+package server
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/basharal/filesystem/fs"
+	"github.com/basharal/filesystem/proto/pb_filesystem"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestNewKafkaRESTSink(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    KafkaRESTOpts
+		wantURL string
+		wantErr bool
+	}{
+		{name: "valid", opts: KafkaRESTOpts{RESTURL: "http://proxy:8082/", Topic: "fs/events"}, wantURL: "http://proxy:8082/topics/fs%2Fevents"},
+		{name: "no url", opts: KafkaRESTOpts{Topic: "fs"}, wantErr: true},
+		{name: "no topic", opts: KafkaRESTOpts{RESTURL: "http://proxy:8082"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sink, err := NewKafkaRESTSink(tt.opts)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewKafkaRESTSink() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && sink.url != tt.wantURL {
+				t.Errorf("NewKafkaRESTSink() url = %s, want %s", sink.url, tt.wantURL)
+			}
+		})
+	}
+}
+
+func TestKafkaRESTSink_Send(t *testing.T) {
+	var got []kafkaRecord
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.EscapedPath() != "/topics/fs.events" {
+			t.Errorf("request = %s %s, want POST /topics/fs.events", r.Method, r.URL.EscapedPath())
+		}
+		if ct := r.Header.Get("Content-Type"); ct != "application/vnd.kafka.binary.v2+json" {
+			t.Errorf("Content-Type = %s, want the binary v2 type", ct)
+		}
+		var records kafkaRecords
+		if err := json.NewDecoder(r.Body).Decode(&records); err != nil {
+			t.Errorf("failed to decode the records. %s", err)
+		}
+		got = append(got, records.Records...)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer proxy.Close()
+
+	sink, err := NewKafkaRESTSink(KafkaRESTOpts{RESTURL: proxy.URL, Topic: "fs.events", Encoding: EncodingProto})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+	e := fs.Event{Type: fs.EventRemove, Path: "/a/file", Time: time.Unix(1, 0)}
+	if err := sink.Send(context.Background(), e); err != nil {
+		t.Fatalf("KafkaRESTSink.Send() = %v", err)
+	}
+	if len(got) != 1 {
+		t.Fatalf("proxy received %d records, want 1", len(got))
+	}
+	// Records are keyed by path, with the encoded event as the value.
+	if key, err := base64.StdEncoding.DecodeString(got[0].Key); err != nil || string(key) != e.Path {
+		t.Errorf("record key = %q, %v, want %s", key, err, e.Path)
+	}
+	value, err := base64.StdEncoding.DecodeString(got[0].Value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var event pb_filesystem.Event
+	if err := proto.Unmarshal(value, &event); err != nil {
+		t.Fatalf("record value isn't an event. %s", err)
+	}
+	if !proto.Equal(&event, toProtoEvent(e)) {
+		t.Errorf("record value = %v, want %v", &event, toProtoEvent(e))
+	}
+}
+
+func TestKafkaRESTSink_Errors(t *testing.T) {
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"error_code":40401,"message":"Topic not found."}`, http.StatusNotFound)
+	}))
+	defer failing.Close()
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer slow.Close()
+	defer close(release)
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+
+	tests := []struct {
+		name string
+		opts KafkaRESTOpts
+	}{
+		{name: "error status", opts: KafkaRESTOpts{RESTURL: failing.URL}},
+		{name: "timeout", opts: KafkaRESTOpts{RESTURL: slow.URL, Timeout: 50 * time.Millisecond}},
+		{name: "proxy down", opts: KafkaRESTOpts{RESTURL: down.URL}},
+		{name: "unknown encoding", opts: KafkaRESTOpts{RESTURL: failing.URL, Encoding: Encoding(-1)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.opts.Topic = "fs.events"
+			sink, err := NewKafkaRESTSink(tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer sink.Close()
+			if err := sink.Send(context.Background(), fs.Event{Type: fs.EventCreate, Path: "/a/file"}); err == nil {
+				t.Errorf("KafkaRESTSink.Send() succeeded")
+			}
+		})
+	}
+}
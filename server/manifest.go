@@ -0,0 +1,80 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/basharal/filesystem/fs"
+	"github.com/basharal/filesystem/proto/pb_filesystem"
+	"github.com/golang/glog"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Converges the dir at path to a manifest at once. Sources must be on this server. Like uploads,
+// the content of files counts against the memory budget and the max file size.
+func (s *Server) ApplyManifest(ctx context.Context, in *pb_filesystem.ApplyManifestRequest) (*pb_filesystem.ApplyManifestResponse, error) {
+	glog.V(1).Infof("Start ApplyManifest %s\n", in.Path)
+	defer glog.V(1).Infof("End ApplyManifest %s\n", in.Path)
+	applier, ok := s.fs.(fs.ManifestApplier)
+	if !ok {
+		return nil, status.Errorf(codes.Unimplemented, "the filesystem doesn't support manifests")
+	}
+	path, err := s.validatePath(in.Path)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid path (%s). %s", in.Path, err)
+	}
+	if !in.DryRun {
+		if err := s.writable(); err != nil {
+			return nil, err
+		}
+	}
+
+	m := fs.Manifest{Dirs: in.Dirs, Files: make([]fs.ManifestFile, 0, len(in.Files))}
+	var size int64
+	max := atomic.LoadInt64(&s.maxFileSize)
+	for _, f := range in.Files {
+		fileSize := int64(len(f.Content))
+		source := f.Source
+		if source != "" {
+			if source, err = s.validatePath(source); err != nil {
+				return nil, status.Errorf(codes.InvalidArgument, "invalid source (%s). %s", f.Source, err)
+			}
+			// Missing sources are left for ApplyManifest to report.
+			if file, _, err := s.fs.Stat(source); err == nil && file != nil {
+				fileSize = file.Size()
+			}
+		}
+		if max > 0 && fileSize > max {
+			err := fmt.Errorf("%s: max file size of %d bytes: %w", f.Path, max, fs.ErrLimitExceeded)
+			return nil, toStatus(&fs.PathError{Op: "apply", Path: path, Err: err})
+		}
+		size += fileSize
+		m.Files = append(m.Files, fs.ManifestFile{Path: f.Path, Content: f.Content, Source: source, SHA256: f.Sha256})
+	}
+	if !in.DryRun && s.admission.limit() > 0 {
+		usage, err := s.usage()
+		if err != nil {
+			return nil, toStatus(err)
+		}
+		if err := s.admission.admit(usage, size); err != nil {
+			return nil, err
+		}
+	}
+
+	// Dry runs aren't hooked.
+	var op OpInfo
+	if !in.DryRun {
+		var err error
+		if op, err = s.preOp(ctx, OpApplyManifest, path, ""); err != nil {
+			return nil, err
+		}
+	}
+	result, err := applier.ApplyManifest(path, m, in.DryRun)
+	s.postOp(ctx, op, err)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &pb_filesystem.ApplyManifestResponse{Created: result.Created, Updated: result.Updated, Removed: result.Removed}, nil
+}
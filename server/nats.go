@@ -0,0 +1,147 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/basharal/filesystem/fs"
+	"github.com/golang/glog"
+)
+
+// NATSOpts configures a NATS event sink.
+type NATSOpts struct {
+	// Addr is the host:port of the NATS server.
+	Addr string
+
+	// Subject events are published to.
+	Subject string
+
+	Encoding Encoding
+}
+
+// NATSSink publishes events to NATS using the core text protocol. It reconnects lazily if the
+// connection is lost.
+type NATSSink struct {
+	opts NATSOpts
+
+	// mu protects below.
+	mu   sync.Mutex
+	conn net.Conn
+	w    *bufio.Writer
+}
+
+// NewNATSSink returns a sink publishing to opts.Subject.
+func NewNATSSink(opts NATSOpts) (*NATSSink, error) {
+	if opts.Addr == "" {
+		return nil, fmt.Errorf("nats address is required")
+	}
+	if opts.Subject == "" || strings.ContainsAny(opts.Subject, " \t\r\n") {
+		return nil, fmt.Errorf("invalid nats subject %q", opts.Subject)
+	}
+	return &NATSSink{opts: opts}, nil
+}
+
+func (s *NATSSink) Send(ctx context.Context, e fs.Event) error {
+	data, err := encodeEvent(e, s.opts.Encoding)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.connect(ctx); err != nil {
+		return err
+	}
+	fmt.Fprintf(s.w, "PUB %s %d\r\n", s.opts.Subject, len(data))
+	s.w.Write(data)
+	s.w.WriteString("\r\n")
+	if err := s.w.Flush(); err != nil {
+		s.disconnect()
+		return err
+	}
+	return nil
+}
+
+func (s *NATSSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.disconnect()
+}
+
+// connect must be called with mu held.
+func (s *NATSSink) connect(ctx context.Context) error {
+	if s.conn != nil {
+		return nil
+	}
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", s.opts.Addr)
+	if err != nil {
+		return err
+	}
+	r := bufio.NewReader(conn)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	info, err := r.ReadString('\n')
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	if !strings.HasPrefix(info, "INFO") {
+		conn.Close()
+		return fmt.Errorf("unexpected greeting from nats: %s", strings.TrimSpace(info))
+	}
+	conn.SetReadDeadline(time.Time{})
+
+	w := bufio.NewWriter(conn)
+	w.WriteString("CONNECT {\"verbose\":false,\"pedantic\":false,\"name\":\"file_server\"}\r\n")
+	if err := w.Flush(); err != nil {
+		conn.Close()
+		return err
+	}
+	s.conn = conn
+	s.w = w
+	go s.keepalive(conn, r)
+	return nil
+}
+
+// keepalive answers server PINGs until the connection is closed.
+func (s *NATSSink) keepalive(conn net.Conn, r *bufio.Reader) {
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			glog.V(1).Infof("NATS connection to %s closed. %s\n", s.opts.Addr, err)
+			s.mu.Lock()
+			if s.conn == conn {
+				s.disconnect()
+			}
+			s.mu.Unlock()
+			return
+		}
+		switch {
+		case strings.HasPrefix(line, "PING"):
+			s.mu.Lock()
+			if s.conn == conn {
+				s.w.WriteString("PONG\r\n")
+				s.w.Flush()
+			}
+			s.mu.Unlock()
+		case strings.HasPrefix(line, "-ERR"):
+			glog.Errorf("NATS error from %s: %s\n", s.opts.Addr, strings.TrimSpace(line))
+		}
+	}
+}
+
+// disconnect must be called with mu held.
+func (s *NATSSink) disconnect() error {
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn = nil
+	s.w = nil
+	return err
+}
@@ -0,0 +1,248 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/basharal/filesystem/fs"
+)
+
+// fakeNATS is a NATS server speaking enough of the text protocol for NATSSink. Connections are
+// greeted with greeting, and what clients send is passed to frames a line each, with the
+// payloads of PUBs appended after a |.
+type fakeNATS struct {
+	lis      net.Listener
+	greeting string
+	conns    chan net.Conn
+	frames   chan string
+}
+
+func newFakeNATS(t *testing.T, greeting string) *fakeNATS {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeNATS{lis: lis, greeting: greeting, conns: make(chan net.Conn, 10), frames: make(chan string, 100)}
+	t.Cleanup(func() { lis.Close() })
+	go f.serve()
+	return f
+}
+
+func (f *fakeNATS) serve() {
+	for {
+		conn, err := f.lis.Accept()
+		if err != nil {
+			return
+		}
+		fmt.Fprintf(conn, "%s\r\n", f.greeting)
+		f.conns <- conn
+		go f.read(conn)
+	}
+}
+
+func (f *fakeNATS) read(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimSuffix(line, "\r\n")
+		var subject string
+		var size int
+		if _, err := fmt.Sscanf(line, "PUB %s %d", &subject, &size); err == nil {
+			payload := make([]byte, size+2)
+			if _, err := io.ReadFull(r, payload); err != nil {
+				return
+			}
+			line += "|" + string(payload)
+		}
+		f.frames <- line
+	}
+}
+
+// next returns the next frame received, failing the test if none arrives.
+func (f *fakeNATS) next(t *testing.T) string {
+	t.Helper()
+	select {
+	case frame := <-f.frames:
+		return frame
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a frame")
+		return ""
+	}
+}
+
+// accepted returns the next connection accepted, failing the test if none is.
+func (f *fakeNATS) accepted(t *testing.T) net.Conn {
+	t.Helper()
+	select {
+	case conn := <-f.conns:
+		return conn
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a connection")
+		return nil
+	}
+}
+
+// connected returns whether the sink has a connection.
+func (s *NATSSink) connected() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.conn != nil
+}
+
+func TestNewNATSSink(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    NATSOpts
+		wantErr bool
+	}{
+		{name: "valid", opts: NATSOpts{Addr: "localhost:4222", Subject: "fs.events"}},
+		{name: "no address", opts: NATSOpts{Subject: "fs.events"}, wantErr: true},
+		{name: "no subject", opts: NATSOpts{Addr: "localhost:4222"}, wantErr: true},
+		{name: "subject with a space", opts: NATSOpts{Addr: "localhost:4222", Subject: "fs events"}, wantErr: true},
+		{name: "subject with a newline", opts: NATSOpts{Addr: "localhost:4222", Subject: "fs\r\nPUB"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewNATSSink(tt.opts); (err != nil) != tt.wantErr {
+				t.Errorf("NewNATSSink() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNATSSink_Send(t *testing.T) {
+	server := newFakeNATS(t, `INFO {"server_id":"fake"}`)
+	sink, err := NewNATSSink(NATSOpts{Addr: server.lis.Addr().String(), Subject: "fs.events"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+
+	events := []fs.Event{
+		{Type: fs.EventCreate, Path: "/a/file"},
+		{Type: fs.EventMove, Path: "/a/file", NewPath: "/a/moved"},
+	}
+	for _, e := range events {
+		if err := sink.Send(context.Background(), e); err != nil {
+			t.Fatalf("NATSSink.Send() = %v", err)
+		}
+	}
+	if got := server.next(t); !strings.HasPrefix(got, "CONNECT {") {
+		t.Errorf("first frame = %q, want CONNECT", got)
+	}
+	// Events are published over the same connection, each framed with its size.
+	for _, e := range events {
+		data, err := encodeEvent(e, EncodingJSON)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := fmt.Sprintf("PUB fs.events %d|%s\r\n", len(data), data)
+		if got := server.next(t); got != want {
+			t.Errorf("frame = %q, want %q", got, want)
+		}
+	}
+	server.accepted(t)
+	select {
+	case <-server.conns:
+		t.Errorf("NATSSink.Send() connected more than once")
+	default:
+	}
+}
+
+func TestNATSSink_Ping(t *testing.T) {
+	server := newFakeNATS(t, "INFO {}")
+	sink, err := NewNATSSink(NATSOpts{Addr: server.lis.Addr().String(), Subject: "fs.events"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+	if err := sink.Send(context.Background(), fs.Event{Type: fs.EventWrite, Path: "/a/file"}); err != nil {
+		t.Fatalf("NATSSink.Send() = %v", err)
+	}
+	conn := server.accepted(t)
+	server.next(t)
+	server.next(t)
+	if _, err := io.WriteString(conn, "PING\r\n"); err != nil {
+		t.Fatal(err)
+	}
+	if got := server.next(t); got != "PONG" {
+		t.Errorf("answer to PING = %q, want PONG", got)
+	}
+}
+
+func TestNATSSink_Reconnect(t *testing.T) {
+	server := newFakeNATS(t, "INFO {}")
+	sink, err := NewNATSSink(NATSOpts{Addr: server.lis.Addr().String(), Subject: "fs.events"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+	e := fs.Event{Type: fs.EventWrite, Path: "/a/file"}
+	if err := sink.Send(context.Background(), e); err != nil {
+		t.Fatalf("NATSSink.Send() = %v", err)
+	}
+	conn := server.accepted(t)
+	server.next(t)
+	server.next(t)
+	conn.Close()
+	waitFor(t, "the sink to notice the closed connection", func() bool { return !sink.connected() })
+
+	// The next event connects again.
+	if err := sink.Send(context.Background(), e); err != nil {
+		t.Fatalf("NATSSink.Send() after the connection closed = %v", err)
+	}
+	server.accepted(t)
+	frames := []string{server.next(t), server.next(t)}
+	if !strings.HasPrefix(frames[0], "CONNECT") || !strings.HasPrefix(frames[1], "PUB fs.events") {
+		t.Errorf("frames = %q, want the event published after reconnecting", frames)
+	}
+}
+
+func TestNATSSink_Errors(t *testing.T) {
+	t.Run("unexpected greeting", func(t *testing.T) {
+		server := newFakeNATS(t, "-ERR 'Authorization Violation'")
+		sink, err := NewNATSSink(NATSOpts{Addr: server.lis.Addr().String(), Subject: "fs.events"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer sink.Close()
+		if err := sink.Send(context.Background(), fs.Event{Path: "/a/file"}); err == nil || !strings.Contains(err.Error(), "unexpected greeting") {
+			t.Errorf("NATSSink.Send() = %v, want an unexpected greeting", err)
+		}
+		if sink.connected() {
+			t.Errorf("NATSSink kept a connection that failed to greet")
+		}
+	})
+	t.Run("server down", func(t *testing.T) {
+		server := newFakeNATS(t, "INFO {}")
+		server.lis.Close()
+		sink, err := NewNATSSink(NATSOpts{Addr: server.lis.Addr().String(), Subject: "fs.events"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer sink.Close()
+		if err := sink.Send(context.Background(), fs.Event{Path: "/a/file"}); err == nil {
+			t.Errorf("NATSSink.Send() succeeded without a server")
+		}
+	})
+	t.Run("unknown encoding", func(t *testing.T) {
+		sink, err := NewNATSSink(NATSOpts{Addr: "127.0.0.1:1", Subject: "fs.events", Encoding: Encoding(-1)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := sink.Send(context.Background(), fs.Event{Path: "/a/file"}); err == nil {
+			t.Errorf("NATSSink.Send() succeeded with an unknown encoding")
+		}
+	})
+}
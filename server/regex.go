@@ -0,0 +1,92 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"regexp/syntax"
+
+	"github.com/basharal/filesystem/proto/pb_filesystem"
+	"github.com/golang/glog"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	defaultMaxPatternLength = 256
+	defaultMaxProgramSize   = 1000
+	defaultMaxRegexResults  = 100
+)
+
+// RegexOpts validates regex patterns sent by clients. Zero values use defaults. The time and number
+// of nodes a search can take are bounded by fs.Limits.
+type RegexOpts struct {
+	// MaxPatternLength is the max length of a pattern in bytes.
+	MaxPatternLength int
+
+	// MaxProgramSize is the max number of instructions of the compiled pattern. It rejects
+	// patterns that are short, but expensive to match (i.e., nested counted repetitions).
+	MaxProgramSize int
+
+	// MaxResults caps the results a client can ask for.
+	MaxResults int
+}
+
+func (o *RegexOpts) setDefaults() {
+	if o.MaxPatternLength == 0 {
+		o.MaxPatternLength = defaultMaxPatternLength
+	}
+	if o.MaxProgramSize == 0 {
+		o.MaxProgramSize = defaultMaxProgramSize
+	}
+	if o.MaxResults == 0 {
+		o.MaxResults = defaultMaxRegexResults
+	}
+}
+
+// validateRegex only accepts RE2 patterns within the size limits.
+func (o RegexOpts) validateRegex(pattern string) error {
+	if pattern == "" {
+		return fmt.Errorf("empty pattern")
+	}
+	if len(pattern) > o.MaxPatternLength {
+		return fmt.Errorf("pattern is longer than %d bytes", o.MaxPatternLength)
+	}
+	re, err := syntax.Parse(pattern, syntax.Perl)
+	if err != nil {
+		return err
+	}
+	prog, err := syntax.Compile(re.Simplify())
+	if err != nil {
+		return err
+	}
+	if len(prog.Inst) > o.MaxProgramSize {
+		return fmt.Errorf("pattern is too complex (%d instructions, max %d)", len(prog.Inst), o.MaxProgramSize)
+	}
+	return nil
+}
+
+// Returns the first paths under path that match the regex.
+func (s *Server) FindFirstRegex(ctx context.Context, in *pb_filesystem.RegexRequest) (*pb_filesystem.RegexResponse, error) {
+	glog.V(1).Infof("Start FindFirstRegex %s %s\n", in.Path, in.Regex)
+	defer glog.V(1).Infof("End FindFirstRegex %s %s\n", in.Path, in.Regex)
+	path, err := s.validatePath(in.Path)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid path (%s). %s", in.Path, err)
+	}
+	if err := s.regex.validateRegex(in.Regex); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid regex (%s). %s", in.Regex, err)
+	}
+	max := int(in.MaxResults)
+	if max <= 0 {
+		max = 1
+	}
+	if max > s.regex.MaxResults {
+		max = s.regex.MaxResults
+	}
+	res, err := s.fs.FindRegex(path, in.Regex, max)
+	if err != nil {
+		glog.Warningf("Regex %s at %s failed after visiting %d nodes. %s\n", in.Regex, path, res.Visited, err)
+		return nil, toStatus(err)
+	}
+	return &pb_filesystem.RegexResponse{Paths: res.Paths, Visited: int64(res.Visited)}, nil
+}
@@ -0,0 +1,384 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/basharal/filesystem/fs"
+	"github.com/basharal/filesystem/fspath"
+	"github.com/basharal/filesystem/proto/pb_filesystem"
+	"github.com/golang/glog"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// changeLogSize is how many changes a server keeps for its replicas to catch up from.
+	changeLogSize = 4096
+
+	// tailHeartbeat is how often Tail tells replicas that they're caught up.
+	tailHeartbeat = time.Second
+
+	// replicaRetryDelay is how long replicas wait before reconnecting to their primary.
+	replicaRetryDelay = 5 * time.Second
+)
+
+// changeLog keeps the last changes of the filesystem, numbered in order, for Tail.
+type changeLog struct {
+	mu sync.Mutex
+	// seq is the number of the last change. changes holds the ones up to it.
+	seq     int64
+	changes []*pb_filesystem.Change
+	// appended is closed (and replaced) when a change is appended.
+	appended chan struct{}
+}
+
+func newChangeLog() *changeLog {
+	return &changeLog{appended: make(chan struct{})}
+}
+
+// append numbers e after the last change. Events dropped before it (see fs.Event.Dropped) are
+// numbered as well but can't be tailed, so tailers from before them fail and start over.
+func (l *changeLog) append(e fs.Event) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if e.Dropped > 0 {
+		l.seq += int64(e.Dropped)
+		l.changes = nil
+	}
+	l.seq++
+	l.changes = append(l.changes, &pb_filesystem.Change{
+		Seq: l.seq, Type: e.Type.String(), Path: e.Path, NewPath: e.NewPath, IsDir: e.IsDir,
+	})
+	if len(l.changes) > changeLogSize {
+		l.changes = l.changes[len(l.changes)-changeLogSize:]
+	}
+	close(l.appended)
+	l.appended = make(chan struct{})
+}
+
+// since returns the changes after the change numbered after, the number of the last one and a
+// channel closed once there are more. It fails if changes after it were dropped already.
+func (l *changeLog) since(after int64) ([]*pb_filesystem.Change, int64, <-chan struct{}, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if after > l.seq {
+		return nil, 0, nil, fmt.Errorf("change %d wasn't made yet, the last one is %d", after, l.seq)
+	}
+	first := l.seq - int64(len(l.changes)) + 1
+	if after+1 < first {
+		return nil, 0, nil, fmt.Errorf("changes before %d were dropped", first)
+	}
+	changes := append([]*pb_filesystem.Change(nil), l.changes[after+1-first:]...)
+	return changes, l.seq, l.appended, nil
+}
+
+func (l *changeLog) last() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.seq
+}
+
+// runChangeLog appends the events of the filesystem to the change log until ctx is done. Events
+// the log doesn't keep up with are dropped (see fs.Watcher), leaving a gap that fails the tails
+// of replicas, which then copy the files again.
+func (s *Server) runChangeLog(ctx context.Context, watcher fs.Watcher) {
+	events, cancel := watcher.Watch(changeLogSize)
+	defer cancel()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case e, ok := <-events:
+			if !ok {
+				return
+			}
+			s.changes.append(e)
+		}
+	}
+}
+
+// Streams the changes made on the server after a sequence number, along with heartbeats, so
+// that read replicas can apply them. Fails with OutOfRange once the changes are no longer kept,
+// including when the change log missed some.
+func (s *Server) Tail(in *pb_filesystem.TailRequest, stream pb_filesystem.FileSever_TailServer) error {
+	glog.V(1).Infof("Start Tail %d\n", in.After)
+	defer glog.V(1).Infof("End Tail %d\n", in.After)
+	if s.changes == nil {
+		return status.Errorf(codes.Unimplemented, "filesystem doesn't support events")
+	}
+	after := in.After
+	if after < 0 {
+		after = s.changes.last()
+	}
+	// The first heartbeat tells replicas where the stream starts.
+	if err := stream.Send(&pb_filesystem.Change{Seq: after}); err != nil {
+		return err
+	}
+	ticker := time.NewTicker(tailHeartbeat)
+	defer ticker.Stop()
+	for {
+		changes, last, appended, err := s.changes.since(after)
+		if err != nil {
+			return status.Errorf(codes.OutOfRange, "%s", err)
+		}
+		for _, c := range changes {
+			if err := stream.Send(c); err != nil {
+				return err
+			}
+		}
+		after = last
+		select {
+		case <-appended:
+		case <-ticker.C:
+			if err := stream.Send(&pb_filesystem.Change{Seq: after}); err != nil {
+				return err
+			}
+		case <-stream.Context().Done():
+			return toStatus(stream.Context().Err())
+		}
+	}
+}
+
+// ReplicaLag returns how far behind its primary the server is, as of the last time it applied all
+// of the primary's changes. It returns false for servers that aren't replicas or haven't caught
+// up yet.
+func (s *Server) ReplicaLag() (time.Duration, bool) {
+	synced := atomic.LoadInt64(&s.syncedAt)
+	if s.replicaOf == "" || synced == 0 {
+		return 0, false
+	}
+	return time.Since(time.Unix(0, synced)), true
+}
+
+// fresh returns a FailedPrecondition status if the server is a replica further behind its
+// primary than maxStalenessMs, so that clients read from the primary instead.
+func (s *Server) fresh(maxStalenessMs int64) error {
+	if s.replicaOf == "" || maxStalenessMs <= 0 {
+		return nil
+	}
+	lag, ok := s.ReplicaLag()
+	if !ok {
+		return status.Errorf(codes.FailedPrecondition, "replica hasn't caught up with %s yet", s.replicaOf)
+	}
+	if lag > time.Duration(maxStalenessMs)*time.Millisecond {
+		return status.Errorf(codes.FailedPrecondition, "replica is %s behind %s", lag, s.replicaOf)
+	}
+	return nil
+}
+
+// runReplica keeps the server in sync with its primary until ctx is done, reconnecting when the
+// primary can't be reached. When the primary no longer has the changes the replica needs, the
+// replica is stale until it copied the files again, which it does right away.
+func (s *Server) runReplica(ctx context.Context) {
+	for {
+		err := s.replicate(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if status.Code(err) == codes.OutOfRange {
+			glog.Warningf("Missed changes of %s, copying the files again. %s\n", s.replicaOf, err)
+			atomic.StoreInt64(&s.syncedAt, 0)
+			continue
+		}
+		glog.Errorf("Failed to replicate %s. %s\n", s.replicaOf, err)
+		select {
+		case <-time.After(replicaRetryDelay):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// replicate copies the files of the primary and then applies its changes until the stream fails.
+// Changes made while copying are applied again, which they tolerate.
+func (s *Server) replicate(ctx context.Context) error {
+	conn, err := grpc.DialContext(ctx, s.replicaOf, grpc.WithInsecure())
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	primary := pb_filesystem.NewFileSeverClient(conn)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stream, err := primary.Tail(ctx, &pb_filesystem.TailRequest{After: -1})
+	if err != nil {
+		return err
+	}
+	if _, err := stream.Recv(); err != nil {
+		return err
+	}
+	if err := s.syncDir(ctx, primary, fspath.Root); err != nil {
+		return fmt.Errorf("failed to copy the files. %w", err)
+	}
+	glog.Infof("Copied the files of %s\n", s.replicaOf)
+	for {
+		c, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+		if c.Type == "" {
+			atomic.StoreInt64(&s.syncedAt, time.Now().UnixNano())
+			continue
+		}
+		if err := s.apply(ctx, primary, c); err != nil {
+			return fmt.Errorf("failed to apply change %d (%s %s). %w", c.Seq, c.Type, c.Path, err)
+		}
+	}
+}
+
+// syncDir makes dir like it is on the primary: entries the primary doesn't have are removed and
+// the others are copied over.
+func (s *Server) syncDir(ctx context.Context, primary pb_filesystem.FileSeverClient, dir string) error {
+	out, err := primary.ListDir(ctx, &pb_filesystem.Path{Path: dir})
+	if err != nil {
+		return err
+	}
+	remote := make(map[string]bool, len(out.Files)+len(out.Dirs))
+	for _, f := range out.Files {
+		remote[f.Name] = false
+	}
+	for _, d := range out.Dirs {
+		remote[d.Name] = true
+	}
+	files, dirs, err := s.fs.ListDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		if isDir, ok := remote[f.String()]; !ok || isDir {
+			if _, err := s.fs.DeletePrefix(fspath.Join(dir, f.String()), false); err != nil {
+				return err
+			}
+		}
+	}
+	for _, d := range dirs {
+		if isDir, ok := remote[d.String()]; !ok || !isDir {
+			if _, err := s.fs.DeletePrefix(fspath.Join(dir, d.String()), false); err != nil {
+				return err
+			}
+		}
+	}
+	for _, f := range out.Files {
+		if err := s.copyFile(ctx, primary, fspath.Join(dir, f.Name)); err != nil {
+			return err
+		}
+	}
+	for _, d := range out.Dirs {
+		path := fspath.Join(dir, d.Name)
+		if err := s.replicaMakeDir(path); err != nil && !errors.Is(err, fs.ErrAlreadyExist) {
+			return err
+		}
+		if err := s.syncDir(ctx, primary, path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// apply applies a change of the primary. Changes to paths that changed again since (i.e., were
+// removed) are skipped, since the later changes follow.
+func (s *Server) apply(ctx context.Context, primary pb_filesystem.FileSeverClient, c *pb_filesystem.Change) error {
+	t, err := fs.ParseEventType(c.Type)
+	if err != nil {
+		return err
+	}
+	switch t {
+	case fs.EventCreate:
+		err = s.replicaNewFile(c.Path)
+	case fs.EventMakeDir:
+		err = s.replicaMakeDir(c.Path)
+	case fs.EventWrite:
+		err = s.copyFile(ctx, primary, c.Path)
+	case fs.EventRemove:
+		_, err = s.fs.DeletePrefix(c.Path, false)
+	case fs.EventMove:
+		err = s.fs.Move(c.Path, c.NewPath)
+	}
+	if errors.Is(err, fs.ErrAlreadyExist) || errors.Is(err, fs.ErrNotFound) {
+		return nil
+	}
+	return err
+}
+
+// copyFile copies the file at path from the primary, replacing the replica's, since writes append.
+func (s *Server) copyFile(ctx context.Context, primary pb_filesystem.FileSeverClient, path string) error {
+	stream, err := primary.ReadFile(ctx, &pb_filesystem.Path{Path: path})
+	if err != nil {
+		return fromStatus(err)
+	}
+	// The first payload tells if the file is still there before it's created.
+	r := &payloadReader{stream: stream}
+	first, err := stream.Recv()
+	switch {
+	case err == io.EOF:
+		r.eof = true
+	case err != nil:
+		return fromStatus(err)
+	default:
+		r.buf = first.Data
+	}
+	if err := s.fs.Remove(path); err != nil && !errors.Is(err, fs.ErrNotFound) {
+		return err
+	}
+	if err := s.replicaNewFile(path); err != nil {
+		return err
+	}
+	_, err = s.fs.Write(path, r)
+	return err
+}
+
+// replicaMakeDir makes the dir at path, whose parent the replica has. It goes through MakeDirAll
+// where the filesystem has it, since fs.FileSystem's MakeDir only makes dirs a level down.
+func (s *Server) replicaMakeDir(path string) error {
+	_, parents := s.fs.(fs.DirMaker)
+	return s.makeDir(path, parents)
+}
+
+// replicaNewFile is replicaMakeDir for files.
+func (s *Server) replicaNewFile(path string) error {
+	_, parents := s.fs.(fs.Creator)
+	return s.createFile(path, parents)
+}
+
+// payloadReader reads the content of a ReadFile stream.
+type payloadReader struct {
+	stream pb_filesystem.FileSever_ReadFileClient
+	buf    []byte
+	eof    bool
+}
+
+func (r *payloadReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if r.eof {
+			return 0, io.EOF
+		}
+		payload, err := r.stream.Recv()
+		if err == io.EOF {
+			r.eof = true
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		r.buf = payload.Data
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+// fromStatus returns the filesystem error for NotFound statuses of the primary, so that apply can
+// tell them apart.
+func fromStatus(err error) error {
+	if status.Code(err) == codes.NotFound {
+		return fmt.Errorf("%s: %w", err, fs.ErrNotFound)
+	}
+	return err
+}
@@ -0,0 +1,77 @@
+package server
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/basharal/filesystem/fs"
+	"google.golang.org/grpc"
+)
+
+func TestChangeLog_Gap(t *testing.T) {
+	l := newChangeLog()
+	l.append(fs.Event{Type: fs.EventCreate, Path: "/a/x"})
+	l.append(fs.Event{Type: fs.EventCreate, Path: "/a/y", Dropped: 2})
+	if got := l.last(); got != 4 {
+		t.Errorf("changeLog.last() = %d, want 4", got)
+	}
+	for _, after := range []int64{0, 1, 2} {
+		if _, _, _, err := l.since(after); err == nil {
+			t.Errorf("changeLog.since(%d) succeeded across a gap", after)
+		}
+	}
+	changes, last, _, err := l.since(3)
+	if err != nil || last != 4 || len(changes) != 1 || changes[0].Path != "/a/y" {
+		t.Errorf("changeLog.since(3) = %v, %d, %v, want /a/y as change 4", changes, last, err)
+	}
+}
+
+// waitFor polls cond until it's true, failing the test after a while.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(10 * time.Second); !cond(); {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestServer_ReplicaResyncsOnGap(t *testing.T) {
+	// The primary isn't started, so its change log only has what the test appends, as if the
+	// other events were dropped.
+	primary := newTestServer(t, Opts{})
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := grpc.NewServer()
+	primary.RegisterWith(g)
+	go g.Serve(lis)
+	defer g.Stop()
+
+	replica := newTestServer(t, Opts{ReplicaOf: lis.Addr().String()})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	replica.Start(ctx)
+	waitFor(t, "the replica to catch up", func() bool {
+		_, ok := replica.ReplicaLag()
+		return ok
+	})
+
+	for _, path := range []string{"/a/missed", "/a/next"} {
+		if err := primary.createFile(path, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+	primary.changes.append(fs.Event{Type: fs.EventCreate, Path: "/a/next", Dropped: 1})
+	waitFor(t, "the replica to copy the missed file", func() bool {
+		_, _, err := replica.fs.Stat("/a/missed")
+		return err == nil
+	})
+	if _, _, err := replica.fs.Stat("/a/next"); err != nil {
+		t.Errorf("FileSystem.Stat(/a/next) on the replica = %v", err)
+	}
+}
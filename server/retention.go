@@ -0,0 +1,64 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	"github.com/basharal/filesystem/fs"
+	"github.com/basharal/filesystem/fspath"
+	"github.com/basharal/filesystem/proto/pb_filesystem"
+	"github.com/golang/glog"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Sets the retention policy of the directory at path.
+func (s *Server) SetRetention(ctx context.Context, in *pb_filesystem.RetentionPolicy) (*pb_filesystem.StatusResponse, error) {
+	glog.V(1).Infof("Start SetRetention %s\n", in.Path)
+	defer glog.V(1).Infof("End SetRetention %s\n", in.Path)
+	path, err := s.validatePath(in.Path)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid path (%s). %s", in.Path, err)
+	}
+	if err := s.writable(); err != nil {
+		return nil, err
+	}
+	retainer, ok := s.fs.(fs.Retainer)
+	if !ok {
+		return nil, toStatus(fs.ErrNotSupported)
+	}
+	policy := fs.RetentionPolicy{
+		MaxAge:   time.Duration(in.MaxAgeSeconds) * time.Second,
+		MaxFiles: int(in.MaxFiles),
+	}
+	if err := retainer.SetRetention(path, policy); err != nil {
+		return nil, toStatus(err)
+	}
+	return &pb_filesystem.StatusResponse{Status: pb_filesystem.Status_SUCCESS}, nil
+}
+
+// Returns the retention policies of directories under path.
+func (s *Server) ListRetention(ctx context.Context, in *pb_filesystem.Path) (*pb_filesystem.RetentionList, error) {
+	glog.V(1).Infof("Start ListRetention %s\n", in.Path)
+	defer glog.V(1).Infof("End ListRetention %s\n", in.Path)
+	path, err := s.validatePath(in.Path)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid path (%s). %s", in.Path, err)
+	}
+	retainer, ok := s.fs.(fs.Retainer)
+	if !ok {
+		return nil, toStatus(fs.ErrNotSupported)
+	}
+	res := &pb_filesystem.RetentionList{}
+	for dir, policy := range retainer.Retention() {
+		if !fspath.HasPrefix(dir, path) {
+			continue
+		}
+		res.Policies = append(res.Policies, &pb_filesystem.RetentionPolicy{
+			Path:          dir,
+			MaxAgeSeconds: int64(policy.MaxAge / time.Second),
+			MaxFiles:      int64(policy.MaxFiles),
+		})
+	}
+	return res, nil
+}
@@ -0,0 +1,156 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"unicode/utf8"
+
+	"github.com/basharal/filesystem/fs"
+	"github.com/basharal/filesystem/fspath"
+	"github.com/basharal/filesystem/proto/pb_filesystem"
+	"github.com/golang/glog"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// indexQueueSize is how many events the indexer buffers. Files whose events are dropped keep
+	// what was indexed for them last.
+	indexQueueSize = 4096
+
+	// maxIndexedSize is the size of the largest files whose content is indexed.
+	maxIndexedSize = 4 << 20
+
+	defaultSearchResults = 10
+	maxSearchResults     = 1000
+)
+
+var errNotText = fmt.Errorf("not a text file")
+
+// runIndexer indexes the files already in the filesystem, then keeps the index up to date with
+// the filesystem's events until ctx is done.
+func (s *Server) runIndexer(ctx context.Context, watcher fs.Watcher) {
+	// Subscribed first, so that files written while indexing aren't missed.
+	events, stop := watcher.Watch(indexQueueSize)
+	defer stop()
+	if err := s.indexDir(ctx, fspath.Root); err != nil {
+		glog.Errorf("Failed to index the content of %s. %s\n", fspath.Root, err)
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case e, ok := <-events:
+			if !ok {
+				return
+			}
+			if err := s.indexEvent(e); err != nil {
+				glog.Errorf("Failed to index %s event for %s. %s\n", e.Type, e.Path, err)
+			}
+		}
+	}
+}
+
+// indexDir indexes the files under the dir at path.
+func (s *Server) indexDir(ctx context.Context, path string) error {
+	files, dirs, err := s.fs.ListDir(path)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := s.indexFile(fspath.Join(path, file.String())); err != nil {
+			return err
+		}
+	}
+	for _, dir := range dirs {
+		if err := s.indexDir(ctx, fspath.Join(path, dir.String())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Server) indexEvent(e fs.Event) error {
+	switch {
+	case e.Type == fs.EventWrite:
+		return s.indexFile(e.Path)
+	case e.Type == fs.EventRemove && e.IsDir:
+		return s.index.DeletePrefix(e.Path)
+	case e.Type == fs.EventRemove:
+		return s.index.Delete(e.Path)
+	case e.Type == fs.EventMove && e.IsDir:
+		// Moved dirs are empty (see fs.FileSystem.Move).
+		return s.index.DeletePrefix(e.Path)
+	case e.Type == fs.EventMove:
+		if err := s.index.Delete(e.Path); err != nil {
+			return err
+		}
+		return s.indexFile(e.NewPath)
+	}
+	return nil
+}
+
+// indexFile indexes the content of the file at path if it's text (valid UTF-8 without NUL bytes),
+// and removes it from the index otherwise. Files removed in the meantime are skipped, since their
+// event removes them.
+func (s *Server) indexFile(path string) error {
+	var buf bytes.Buffer
+	_, err := s.fs.Read(path, &textWriter{buf: &buf})
+	switch {
+	case errors.Is(err, errNotText) || (err == nil && !utf8.Valid(buf.Bytes())):
+		return s.index.Delete(path)
+	case errors.Is(err, fs.ErrNotFound):
+		return nil
+	case err != nil:
+		return err
+	}
+	return s.index.Index(path, buf.String())
+}
+
+// textWriter fails with errNotText once what's written is larger than maxIndexedSize or has NUL
+// bytes, so that large and binary files aren't read whole.
+type textWriter struct {
+	buf *bytes.Buffer
+}
+
+func (w *textWriter) Write(p []byte) (int, error) {
+	if w.buf.Len()+len(p) > maxIndexedSize || bytes.IndexByte(p, 0) >= 0 {
+		return 0, errNotText
+	}
+	w.buf.Write(p)
+	return len(p), nil
+}
+
+// Returns the files under path whose content best matches a query, best first.
+func (s *Server) Search(ctx context.Context, in *pb_filesystem.SearchRequest) (*pb_filesystem.SearchResponse, error) {
+	glog.V(1).Infof("Start Search %s %s\n", in.Path, in.Query)
+	defer glog.V(1).Infof("End Search %s %s\n", in.Path, in.Query)
+	if s.index == nil {
+		return nil, status.Errorf(codes.Unimplemented, "the server doesn't index content")
+	}
+	path, err := s.validatePath(in.Path)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid path (%s). %s", in.Path, err)
+	}
+	max := int(in.MaxResults)
+	if max <= 0 {
+		max = defaultSearchResults
+	}
+	if max > maxSearchResults {
+		max = maxSearchResults
+	}
+	hits, err := s.index.Search(in.Query, path, max)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	resp := &pb_filesystem.SearchResponse{Hits: make([]*pb_filesystem.SearchHit, 0, len(hits))}
+	for _, hit := range hits {
+		resp.Hits = append(resp.Hits, &pb_filesystem.SearchHit{Path: hit.Path, Score: hit.Score})
+	}
+	return resp, nil
+}
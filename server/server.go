@@ -2,88 +2,624 @@ package server
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"hash/crc32"
 	"io"
 	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/basharal/filesystem/blob"
 	"github.com/basharal/filesystem/fs"
+	"github.com/basharal/filesystem/fspath"
 	"github.com/basharal/filesystem/proto/pb_filesystem"
+	"github.com/basharal/filesystem/search"
 	"github.com/golang/glog"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/keepalive"
 	"google.golang.org/grpc/status"
 )
 
 type Opts struct {
-	Port        int
+	Port int
+
+	// StartPrefix (inclusive) and EndPrefix (exclusive) are the range of paths the server serves.
+	// They're relative to the root and compared with paths lexicographically, so they can be a
+	// letter (i.e., n) or split a dir (i.e., projects/m). Dirs above the boundaries (i.e.,
+	// /projects) are served too, since they hold the paths in range. An empty StartPrefix starts
+	// the range at the beginning of the namespace and an empty EndPrefix runs it to the end, so
+	// that the first and last servers of a cluster own every name outside the others' ranges.
 	StartPrefix string
 	EndPrefix   string
+
+	// Listen are the addresses to listen on at the same time (i.e., localhost:9800, [::1]:9800 or
+	// unix:///tmp/fs.sock). Defaults to localhost:Port.
+	Listen []string
+
+	// WebListen are addresses to also serve on over HTTP (i.e., localhost:8080), for browsers over
+	// grpc-web and for gRPC clients over h2c (see WebHandler). Optional.
+	WebListen []string
+
+	// WebOrigins are the origins of the pages allowed to call the server over WebListen (i.e.,
+	// https://app.example.com), or * for any.
+	WebOrigins []string
+
+	// Webhooks are notified of filesystem events.
+	Webhooks []WebhookOpts
+
+	// Sinks export filesystem events to external systems (i.e., NATS or Kafka).
+	Sinks []SinkOpts
+
+	// ContentStore keeps file content outside of memory so that the namespace can be larger than
+	// RAM. Optional.
+	ContentStore fs.ContentStore
+
+	// MetaStore persists the namespace so that it survives restarts. Content goes there too unless
+	// ContentStore is set. Optional.
+	MetaStore blob.Store
+
+	// SyncInterval is how often changes are written to the MetaStore. Defaults to 10 seconds.
+	SyncInterval time.Duration
+
+	// SnapshotStore receives periodic snapshots of the filesystem (see fs.FileSystem.Snapshot).
+	// Optional.
+	SnapshotStore blob.Store
+
+	// SnapshotInterval is how often snapshots are taken. Defaults to 15 minutes.
+	SnapshotInterval time.Duration
+
+	// SnapshotKeep is how many of the latest snapshots are kept. Defaults to 4. Older snapshots the
+	// kept ones are incremental to are kept as well.
+	SnapshotKeep int
+
+	// SnapshotFullEvery makes every SnapshotFullEvery-th snapshot a full one, while the ones in
+	// between only have the content changed since the snapshot before them (see
+	// fs.FileSystem.SnapshotSince). The first snapshot after a start is always full. Defaults to 1,
+	// which makes all snapshots full.
+	SnapshotFullEvery int
+
+	// ArchiveStore receives the content of files matching ArchiveRules. Archived files are
+	// brought back into memory when read. Optional.
+	ArchiveStore blob.Store
+	ArchiveRules []fs.LifecycleRule
+
+	// JanitorInterval is how often retention policies and lifecycle rules are enforced. Defaults
+	// to a minute.
+	JanitorInterval time.Duration
+
+	// SeedDir is a local dir whose structure and content are loaded at startup. Only entries that
+	// belong to the server's prefix range are loaded. Optional.
+	SeedDir string
+
+	// SeedLazy defers reading the content of seeded files until they're first accessed.
+	SeedLazy bool
+
+	// MirrorDir is a local dir that's kept in sync with the filesystem in both directions, making
+	// the server a network front-end for it. Can't be used with SeedDir. Optional.
+	MirrorDir string
+
+	// MirrorInterval is how often MirrorDir is rescanned for the local changes notifications
+	// missed (see fs.MirrorOpts). Defaults to a minute.
+	MirrorInterval time.Duration
+
+	// FileSystem is the filesystem to serve (i.e., a disk-backed, overlaid or seeded one, or one
+	// shared with the application). ContentStore, Limits, OpenPolicy, WritePolicy, Parallelism and
+	// DisableNameIndex can't be set with it since it's already created. Sinks, archiving, seeding
+	// and mirroring require it to implement the matching optional fs interfaces. Defaults to a new
+	// filesystem.
+	FileSystem fs.Interface
+
+	// Backend selects the filesystem to create when FileSystem isn't set. Defaults to
+	// BackendMemory.
+	Backend Backend
+
+	// SQLitePath is the database file of BackendSQLite. It's created if it doesn't exist.
+	SQLitePath string
+
+	// GroupCommit batches the concurrent mutations of BackendSQLite into a single transaction
+	// committed within this long (see sqlfs.Opts.GroupCommit). 0 commits every mutation on its own.
+	GroupCommit time.Duration
+
+	// Limits bound how long reads, writes and regex searches can take. Defaults to no limits.
+	Limits fs.Limits
+
+	// Regex validates the patterns of regex searches.
+	Regex RegexOpts
+
+	// OpenPolicy decides what happens when files being read are removed/moved. Defaults to failing
+	// with fs.ErrBusy.
+	OpenPolicy fs.OpenPolicy
+
+	// WritePolicy decides what happens when clients write the same file concurrently. Defaults to
+	// applying the writes one after the other.
+	WritePolicy fs.WritePolicy
+
+	// Parallelism is how many files digests, snapshots and prefix deletions work on at once (see
+	// fs.Opts.Parallelism). Defaults to GOMAXPROCS.
+	Parallelism int
+
+	// ContentIndex indexes the content of text files as they're written, for Search (i.e.,
+	// search.NewMemory()). It requires the filesystem to have events. Optional.
+	ContentIndex search.Index
+
+	// DisableNameIndex saves the memory of the index find looks names up in, at the cost of
+	// walking the subtree being searched (see fs.Opts.DisableNameIndex).
+	DisableNameIndex bool
+
+	// QueueWrites applies concurrent writes to the same file in the order they arrived, so that
+	// appends from multiple clients (i.e., log writers) keep their order.
+	QueueWrites bool
+
+	// StreamingWrites passes uploads to the filesystem as they arrive instead of staging them
+	// until they're complete, so that readers see appends right away and large uploads aren't
+	// spooled to disk. Uploads failing midway may then leave part of their content behind,
+	// depending on the filesystem.
+	StreamingWrites bool
+
+	// MaxFileSize fails uploads that would make a file larger than this many bytes with
+	// ResourceExhausted as soon as they exceed it, before they're staged or written. 0 means no
+	// limit.
+	MaxFileSize int64
+
+	// MemoryBudget rejects writes and copies with a retryable Unavailable once the total size of
+	// the files plus the uploads in flight would exceed this many bytes, so that the server turns
+	// writes away before running out of memory. 0 means no budget.
+	MemoryBudget int64
+
+	// WriteIdleTimeout fails uploads nothing (data or heartbeat) arrived on for this long with
+	// DeadlineExceeded, so that clients that went away without closing their stream don't hold
+	// writes forever. Clients uploading slowly should send heartbeats more often (see
+	// client.Opts.WriteHeartbeat). 0 disables the timeout.
+	WriteIdleTimeout time.Duration
+
+	// Keepalive pings idle connections so that they aren't silently dropped by NATs or load
+	// balancers. Defaults to gRPC's defaults.
+	Keepalive KeepaliveOpts
+
+	// ReplicaOf makes the server a read-only replica of the server at this address, which must
+	// serve the same range. It copies the primary's files when it starts and then applies its
+	// changes asynchronously (see Tail), failing writes with FailedPrecondition. Reads can bound
+	// how far behind the primary it may be with max_staleness_ms.
+	ReplicaOf string
+
+	// Hooks are called around the operations of clients, in order (see Hook). The ones that are
+	// UploadValidators check uploads too, after UploadValidators. Optional.
+	Hooks []Hook
+
+	// UploadValidators check the content of uploads as it arrives, in order, failing an upload as
+	// soon as one rejects it (i.e., MaxUploadSize or AllowedTypes). Optional.
+	UploadValidators []UploadValidator
+}
+
+// defaultKeepaliveMinTime is gRPC's default minimum interval between client pings.
+const defaultKeepaliveMinTime = 5 * time.Minute
+
+// KeepaliveOpts configure pinging idle client connections. Zero values use gRPC's defaults.
+type KeepaliveOpts struct {
+	// Time is how long a connection can be idle before the server pings it.
+	Time time.Duration
+
+	// Timeout is how long the server waits for a ping ack before closing the connection.
+	Timeout time.Duration
+
+	// MinTime is the minimum interval clients are allowed to ping at. Clients pinging more often
+	// are disconnected, so it must not be more than the clients' Time.
+	MinTime time.Duration
+
+	// PermitWithoutStream allows clients to ping even when there are no active streams.
+	PermitWithoutStream bool
 }
 
 type Server struct {
 	pb_filesystem.UnimplementedFileSeverServer
 
-	fs    *fs.FileSystem
-	start string
-	end   string
-	port  int
+	fs fs.Interface
+	// rangeMu protects start and end, which SetRange changes.
+	rangeMu         sync.RWMutex
+	start           string
+	end             string
+	listen          []string
+	webListen       []string
+	webOrigins      []string
+	sinks           []SinkOpts
+	archiveStore    blob.Store
+	archiveRules    []fs.LifecycleRule
+	janitorInterval time.Duration
+	syncInterval    time.Duration
+	// snapshots is only set when snapshots are scheduled.
+	snapshots      *snapshotter
+	mirrorDir      string
+	mirrorInterval time.Duration
+	regex          RegexOpts
+	// writes is only set when writes are queued.
+	writes          *writeQueue
+	streamingWrites bool
+	// maxFileSize is accessed atomically. See SetMaxFileSize.
+	maxFileSize int64
+	writeIdle   time.Duration
+	admission   *admission
+	keepalive   KeepaliveOpts
+	// ready is 1 while the server takes requests. See Ready.
+	ready int32
+	// draining is 1 while the server doesn't take writes. See Drain.
+	draining int32
+	// requests is accessed atomically. See validatePath.
+	requests int64
+	// checksumErrors is accessed atomically. See ChecksumErrors.
+	checksumErrors int64
+	// changes is only set when the filesystem has events. See Tail.
+	changes   *changeLog
+	replicaOf string
+	// index is only set when content is indexed.
+	index search.Index
+	// syncedAt is when the replica last applied all of its primary's changes, in unix nanoseconds.
+	// It's accessed atomically. See ReplicaLag.
+	syncedAt int64
+	hooks    []Hook
+	// validators check uploads. See validate.
+	validators []UploadValidator
 }
 
 func New(opts Opts) (*Server, error) {
-	// We only support a single letter prefixes. Longer ones are a bit more complicated
-	// since we need to do some prefix matching.
-	if len(opts.StartPrefix) != 1 {
-		return nil, fmt.Errorf("start prefix must have a single letter")
+	if err := validRange(opts.StartPrefix, opts.EndPrefix); err != nil {
+		return nil, err
+	}
+	sinks := make([]SinkOpts, 0, len(opts.Webhooks)+len(opts.Sinks))
+	for _, w := range opts.Webhooks {
+		hook, err := newWebhook(w)
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, SinkOpts{Sink: hook, Filter: w.Filter})
+	}
+	for _, sink := range opts.Sinks {
+		if sink.Sink == nil {
+			return nil, fmt.Errorf("sink must be set")
+		}
+		sinks = append(sinks, sink)
+	}
+	if opts.ArchiveStore != nil && len(opts.ArchiveRules) == 0 {
+		return nil, fmt.Errorf("archive store requires lifecycle rules")
+	}
+	if opts.ArchiveStore != nil && (opts.ContentStore != nil || opts.MetaStore != nil) {
+		return nil, fmt.Errorf("archiving isn't supported with a content store")
+	}
+	if opts.SeedDir != "" && opts.MirrorDir != "" {
+		return nil, fmt.Errorf("only one of a seed dir and a mirror dir can be set")
+	}
+	if opts.ReplicaOf != "" && (opts.SeedDir != "" || opts.MirrorDir != "") {
+		return nil, fmt.Errorf("replicas can't have a seed dir or a mirror dir")
+	}
+	opts.Regex.setDefaults()
+	if len(opts.Listen) == 0 {
+		opts.Listen = []string{fmt.Sprintf("localhost:%d", opts.Port)}
+	}
+	if opts.JanitorInterval == 0 {
+		opts.JanitorInterval = defaultJanitorInterval
+	}
+	if opts.SyncInterval == 0 {
+		opts.SyncInterval = defaultSyncInterval
+	}
+	fsOpts := fs.Opts{
+		ContentStore: opts.ContentStore,
+		MetaStore:    opts.MetaStore,
+		Limits:       opts.Limits,
+		OpenPolicy:   opts.OpenPolicy,
+		WritePolicy:  opts.WritePolicy,
+		Parallelism:  opts.Parallelism,
+
+		DisableNameIndex: opts.DisableNameIndex,
+	}
+	if opts.FileSystem == nil {
+		f, err := newBackend(opts, fsOpts)
+		if err != nil {
+			return nil, err
+		}
+		opts.FileSystem = f
+	} else if fsOpts != (fs.Opts{}) {
+		return nil, fmt.Errorf("filesystem options can't be set with a filesystem")
+	} else if opts.Backend != "" || opts.GroupCommit != 0 {
+		return nil, fmt.Errorf("a backend can't be set with a filesystem")
+	}
+	if err := checkFeatures(opts, sinks); err != nil {
+		return nil, err
+	}
+	s := &Server{
+		listen:          opts.Listen,
+		webListen:       opts.WebListen,
+		webOrigins:      opts.WebOrigins,
+		start:           opts.StartPrefix,
+		end:             opts.EndPrefix,
+		fs:              opts.FileSystem,
+		sinks:           sinks,
+		archiveStore:    opts.ArchiveStore,
+		archiveRules:    opts.ArchiveRules,
+		janitorInterval: opts.JanitorInterval,
+		syncInterval:    opts.SyncInterval,
+		mirrorDir:       opts.MirrorDir,
+		mirrorInterval:  opts.MirrorInterval,
+		regex:           opts.Regex,
+		streamingWrites: opts.StreamingWrites,
+		maxFileSize:     opts.MaxFileSize,
+		writeIdle:       opts.WriteIdleTimeout,
+		admission:       &admission{budget: opts.MemoryBudget},
+		keepalive:       opts.Keepalive,
+		replicaOf:       opts.ReplicaOf,
+		index:           opts.ContentIndex,
+		hooks:           opts.Hooks,
+		validators:      append([]UploadValidator(nil), opts.UploadValidators...),
+	}
+	for _, hook := range opts.Hooks {
+		if v, ok := hook.(UploadValidator); ok {
+			s.validators = append(s.validators, v)
+		}
+	}
+	if _, ok := s.fs.(fs.Watcher); ok {
+		s.changes = newChangeLog()
+	}
+	if opts.QueueWrites {
+		s.writes = newWriteQueue()
+	}
+	if opts.SnapshotStore != nil {
+		s.snapshots = newSnapshotter(opts)
+	}
+	if opts.SeedDir != "" {
+		err := s.fs.(fs.Loader).LoadFromOS(opts.SeedDir, fs.LoadOpts{
+			Lazy:   opts.SeedLazy,
+			Filter: s.owns,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to seed from %s. %w", opts.SeedDir, err)
+		}
+	}
+	return s, nil
+}
+
+// RegisterWith registers the server's services on grpcServer, so that applications can serve it
+// next to their own services. Start must be called as well for events, the janitor and the
+// mirror to run. Keepalive and the listen addresses are up to grpcServer's owner.
+func (s *Server) RegisterWith(grpcServer *grpc.Server) {
+	pb_filesystem.RegisterFileSeverServer(grpcServer, s)
+	pb_filesystem.RegisterFileAdminServer(grpcServer, &adminServer{s: s})
+}
+
+// Start runs the background work of the server (exporting events, indexing content, the janitor,
+// syncing, snapshots, the mirror and replication) until ctx is done. It doesn't block.
+func (s *Server) Start(ctx context.Context) {
+	if s.changes != nil {
+		go s.runChangeLog(ctx, s.fs.(fs.Watcher))
 	}
-	if len(opts.EndPrefix) != 1 {
-		return nil, fmt.Errorf("end prefix must have a single letter")
+	if s.replicaOf != "" {
+		go s.runReplica(ctx)
 	}
-	if opts.StartPrefix >= opts.EndPrefix {
-		return nil, fmt.Errorf("end prefix must be lexicographically after start prefix")
+	if syncer, ok := s.fs.(fs.Syncer); ok {
+		go s.runSyncer(ctx, syncer)
 	}
-	return &Server{
-		port:  opts.Port,
-		start: opts.StartPrefix,
-		end:   opts.EndPrefix,
-		fs:    fs.New(),
-	}, nil
+	if s.snapshots != nil {
+		go s.runSnapshots(ctx)
+	}
+	for _, sink := range s.sinks {
+		go runSink(ctx, s.fs.(fs.Watcher), sink)
+	}
+	if s.index != nil {
+		go s.runIndexer(ctx, s.fs.(fs.Watcher))
+	}
+	go s.runJanitor(ctx)
+	if s.mirrorDir != "" {
+		go func() {
+			opts := fs.MirrorOpts{Interval: s.mirrorInterval, Filter: s.owns}
+			if err := s.fs.(fs.Mirrorer).Mirror(ctx, s.mirrorDir, opts); err != nil {
+				glog.Errorf("Failed to mirror %s. %s\n", s.mirrorDir, err)
+			}
+		}()
+	}
+}
+
+// FileSystem returns the filesystem the server serves.
+func (s *Server) FileSystem() fs.Interface {
+	return s.fs
 }
 
+// ListenAndServe serves on a dedicated gRPC server listening on the Listen addresses, and on the
+// WebListen ones over HTTP, until ctx is done.
 func (s *Server) ListenAndServe(ctx context.Context) error {
-	l, err := net.Listen("tcp", fmt.Sprintf("localhost:%d", s.port))
-	if err != nil {
-		return err
+	listeners := make([]net.Listener, 0, len(s.listen)+len(s.webListen))
+	for _, addr := range append(append([]string(nil), s.listen...), s.webListen...) {
+		l, err := net.Listen(listenNetwork(addr))
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return err
+		}
+		listeners = append(listeners, l)
 	}
-	grpcServer := grpc.NewServer()
-	pb_filesystem.RegisterFileSeverServer(grpcServer, s)
+	listeners, webListeners := listeners[:len(s.listen)], listeners[len(s.listen):]
+	grpcServer := grpc.NewServer(s.keepaliveOpts()...)
+	s.RegisterWith(grpcServer)
+	webServer := &http.Server{Handler: WebHandler(grpcServer, s.webOrigins)}
+	s.Start(ctx)
 	go func() {
 		<-ctx.Done()
+		s.SetReady(false)
 		fmt.Printf("Starting graceful stop for gRPC server.")
+		webServer.Shutdown(context.Background())
 		grpcServer.GracefulStop()
 		fmt.Printf("Finished graceful stop for gRPC server.")
 	}()
-	fmt.Printf("Starting gRPC serving at %v\n.", l.Addr())
-	grpcServer.Serve(l)
+	var wg sync.WaitGroup
+	for _, l := range webListeners {
+		wg.Add(1)
+		go func(l net.Listener) {
+			defer wg.Done()
+			fmt.Printf("Starting grpc-web serving at %v\n.", l.Addr())
+			if err := webServer.Serve(l); err != nil && err != http.ErrServerClosed {
+				glog.Errorf("Failed to serve at %v. %s\n", l.Addr(), err)
+			}
+		}(l)
+	}
+	for _, l := range listeners {
+		wg.Add(1)
+		go func(l net.Listener) {
+			defer wg.Done()
+			fmt.Printf("Starting gRPC serving at %v\n.", l.Addr())
+			if err := grpcServer.Serve(l); err != nil {
+				glog.Errorf("Failed to serve at %v. %s\n", l.Addr(), err)
+			}
+		}(l)
+	}
+	// Listeners queue connections until they're served, so the server is ready once they're bound.
+	if ctx.Err() == nil {
+		s.SetReady(true)
+	}
+	wg.Wait()
+	return nil
+}
+
+// checkFeatures returns an error if the filesystem in opts lacks a feature that opts use.
+func checkFeatures(opts Opts, sinks []SinkOpts) error {
+	var missing string
+	if _, ok := opts.FileSystem.(fs.Watcher); !ok && len(sinks) > 0 {
+		missing = "events"
+	}
+	if _, ok := opts.FileSystem.(fs.Watcher); !ok && opts.ContentIndex != nil {
+		missing = "content indexing"
+	}
+	if _, ok := opts.FileSystem.(fs.Archiver); !ok && opts.ArchiveStore != nil {
+		missing = "archiving"
+	}
+	if _, ok := opts.FileSystem.(fs.Loader); !ok && opts.SeedDir != "" {
+		missing = "seeding"
+	}
+	if _, ok := opts.FileSystem.(fs.Mirrorer); !ok && opts.MirrorDir != "" {
+		missing = "mirroring"
+	}
+	if _, ok := opts.FileSystem.(fs.Snapshotter); !ok && opts.SnapshotStore != nil {
+		missing = "snapshots"
+	}
+	if missing != "" {
+		return fmt.Errorf("filesystem doesn't support %s", missing)
+	}
+	return nil
+}
+
+// listenNetwork returns the network and address to listen on for addr. Addresses prefixed with
+// unix:// are unix sockets and the rest are TCP (IPv4 or IPv6).
+func listenNetwork(addr string) (string, string) {
+	if strings.HasPrefix(addr, unixScheme) {
+		return "unix", strings.TrimPrefix(addr, unixScheme)
+	}
+	return "tcp", addr
+}
+
+const unixScheme = "unix://"
+
+// keepaliveOpts returns the gRPC options for the keepalive settings that are set. gRPC's defaults
+// are kept otherwise (i.e., a zero MinTime would let clients ping as often as they want).
+func (s *Server) keepaliveOpts() []grpc.ServerOption {
+	opts := make([]grpc.ServerOption, 0, 2)
+	if s.keepalive.Time > 0 || s.keepalive.Timeout > 0 {
+		opts = append(opts, grpc.KeepaliveParams(keepalive.ServerParameters{
+			Time:    s.keepalive.Time,
+			Timeout: s.keepalive.Timeout,
+		}))
+	}
+	if s.keepalive.MinTime > 0 || s.keepalive.PermitWithoutStream {
+		minTime := s.keepalive.MinTime
+		if minTime == 0 {
+			minTime = defaultKeepaliveMinTime
+		}
+		opts = append(opts, grpc.KeepaliveEnforcementPolicy(keepalive.EnforcementPolicy{
+			MinTime:             minTime,
+			PermitWithoutStream: s.keepalive.PermitWithoutStream,
+		}))
+	}
+	return opts
+}
+
+// owns returns true if path belongs to this server.
+func (s *Server) owns(path string, isDir bool) bool {
+	_, err := s.validatePath(path)
+	return err == nil
+}
+
+// validRange returns an error if start and end aren't a range of paths. See Opts.StartPrefix.
+func validRange(start, end string) error {
+	if strings.HasPrefix(start, "/") || strings.HasPrefix(end, "/") {
+		return fmt.Errorf("prefixes must be relative to the root")
+	}
+	if start != "" && end != "" && start >= end {
+		return fmt.Errorf("end prefix must be lexicographically after start prefix")
+	}
 	return nil
 }
 
-// validatePath validates that the path belongs to this server.
-func (s *Server) validatePath(path string) error {
+// inRange returns true if rel (a path without its leading '/') or anything under it is in
+// [start, end), where an empty end leaves the range open.
+func inRange(rel, start, end string) bool {
+	if rel >= start && (end == "" || rel < end) {
+		return true
+	}
+	// Paths under rel are in [rel/, rel0), since '0' follows '/'.
+	return start < rel+"0" && (end == "" || end > rel+"/")
+}
+
+// validatePath validates that the path belongs to this server and returns it canonicalized (see
+// canonical), which is the path to operate on. Otherwise, '..' could escape the server's range.
+func (s *Server) validatePath(path string) (string, error) {
 	if path == "" {
-		return fmt.Errorf("empty path")
+		return "", fmt.Errorf("empty path")
 	}
-	if !fs.IsAbs(path) {
-		return fmt.Errorf("paths must be absolute")
+	if !fspath.IsAbs(path) {
+		return "", fmt.Errorf("paths must be absolute")
 	}
+	// Requests are counted as they validate their paths.
+	atomic.AddInt64(&s.requests, 1)
 
-	// Path is absolute.
-	if len(path) > 1 {
+	path = canonical(path)
+	if path != fspath.Root {
+		start, end := s.prefixes()
 		// Skip '/'
-		if path[1] < s.start[0] || path[1] >= s.end[0] {
-			return fmt.Errorf("path isn't intended for server")
+		if !inRange(path[1:], start, end) {
+			return "", fmt.Errorf("path isn't intended for server")
 		}
 	}
-	return nil
+	return path, nil
+}
+
+// canonical lexically resolves the '.' and '..' components of the absolute path p like the
+// filesystem does, keeping the trailing separator of dirs. Paths without such components are
+// returned as they are.
+func canonical(p string) string {
+	dots := false
+	for _, name := range strings.Split(p, fspath.SeparatorStr) {
+		dots = dots || name == "." || name == ".."
+	}
+	if !dots {
+		return p
+	}
+	clean := fspath.Clean(p)
+	// Paths ending in '.' or '..' name dirs, unlike names ending in a dot.
+	last := p[strings.LastIndex(p, fspath.SeparatorStr)+1:]
+	if clean != fspath.Root && (strings.HasSuffix(p, fspath.SeparatorStr) || last == "." || last == "..") {
+		clean += fspath.SeparatorStr
+	}
+	return clean
+}
+
+// Checks that the server serves path without doing anything with it.
+func (s *Server) ValidatePath(ctx context.Context, in *pb_filesystem.Path) (*pb_filesystem.StatusResponse, error) {
+	if _, err := s.validatePath(in.Path); err != nil {
+		start, end := s.prefixes()
+		return nil, status.Errorf(codes.InvalidArgument, "invalid path (%s) for range [%s, %s). %s", in.Path, start, end, err)
+	}
+	return &pb_filesystem.StatusResponse{Status: pb_filesystem.Status_SUCCESS}, nil
 }
 
 // Returns the list of files/dirs at path.
@@ -91,68 +627,326 @@ func (s *Server) ListDir(ctx context.Context, in *pb_filesystem.Path) (*pb_files
 	glog.V(1).Infof("Start ListDir %s\n", in.Path)
 	defer glog.V(1).Infof("End ListDir %s\n", in.Path)
 
-	if err := s.validatePath(in.Path); err != nil {
+	path, err := s.validatePath(in.Path)
+	if err != nil {
 		return nil, status.Errorf(codes.InvalidArgument, "invalid path (%s). %s", in.Path, err)
 	}
-	files, dirs, err := s.fs.ListDir(in.Path)
-	if err != nil {
+	if err := s.fresh(in.MaxStalenessMs); err != nil {
 		return nil, err
 	}
 	res := &pb_filesystem.ListResponse{}
+	err = s.listDir(path, func(file *fs.File, dir *fs.Dir) bool {
+		if dir != nil {
+			res.Dirs = append(res.Dirs, toDir(dir))
+		} else {
+			res.Files = append(res.Files, toFile(file))
+		}
+		return true
+	})
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return res, nil
+}
+
+// Returns the files/dirs at path as entries ordered by name.
+func (s *Server) ListEntries(ctx context.Context, in *pb_filesystem.Path) (*pb_filesystem.EntryList, error) {
+	glog.V(1).Infof("Start ListEntries %s\n", in.Path)
+	defer glog.V(1).Infof("End ListEntries %s\n", in.Path)
+	path, err := s.validatePath(in.Path)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid path (%s). %s", in.Path, err)
+	}
+	if err := s.fresh(in.MaxStalenessMs); err != nil {
+		return nil, err
+	}
+	res := &pb_filesystem.EntryList{Entries: make([]*pb_filesystem.Entry, 0)}
+	err = s.listDir(path, func(file *fs.File, dir *fs.Dir) bool {
+		if dir != nil {
+			d := toDir(dir)
+			res.Entries = append(res.Entries, &pb_filesystem.Entry{
+				Name: d.Name, Path: d.Path, Size: d.Size, Node: &pb_filesystem.Entry_Dir{Dir: d},
+			})
+		} else {
+			f := toFile(file)
+			res.Entries = append(res.Entries, &pb_filesystem.Entry{
+				Name: f.Name, Path: f.Path, Size: f.Size, Node: &pb_filesystem.Entry_File{File: f},
+			})
+		}
+		return true
+	})
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	sort.Slice(res.Entries, func(i, j int) bool { return res.Entries[i].Name < res.Entries[j].Name })
+	return res, nil
+}
+
+// listDir calls fn with the files/dirs at path, streaming them from filesystems that can (see
+// fs.Iterator) rather than collecting them first.
+func (s *Server) listDir(path string, fn func(*fs.File, *fs.Dir) bool) error {
+	if it, ok := s.fs.(fs.Iterator); ok {
+		return it.ListDirFunc(path, fn)
+	}
+	files, dirs, err := s.fs.ListDir(path)
+	if err != nil {
+		return err
+	}
 	for _, file := range files {
-		res.Files = append(res.Files, &pb_filesystem.File{Name: file.String(), Size: file.Size(), Path: file.Path()})
+		if !fn(file, nil) {
+			return nil
+		}
 	}
 	for _, dir := range dirs {
-		res.Dirs = append(res.Dirs, &pb_filesystem.Dir{Name: dir.String(), Path: dir.Path()})
+		if !fn(nil, dir) {
+			return nil
+		}
+	}
+	return nil
+}
+
+// toFile converts file.
+func toFile(file *fs.File) *pb_filesystem.File {
+	return &pb_filesystem.File{Name: file.String(), Size: file.Size(), Path: file.Path()}
+}
+
+// toDir converts dir along with its usage.
+func toDir(dir *fs.Dir) *pb_filesystem.Dir {
+	usage := dir.Usage()
+	return &pb_filesystem.Dir{
+		Name:  dir.String(),
+		Path:  dir.Path(),
+		Files: usage.Files,
+		Dirs:  usage.Dirs,
+		Size:  usage.Size,
 	}
-	return res, nil
 }
+
 func (s *Server) MakeDir(ctx context.Context, in *pb_filesystem.Path) (*pb_filesystem.StatusResponse, error) {
 	glog.V(1).Infof("Start MakeDir %s\n", in.Path)
 	defer glog.V(1).Infof("End MakeDir %s\n", in.Path)
-	if err := s.validatePath(in.Path); err != nil {
+	path, err := s.validatePath(in.Path)
+	if err != nil {
 		return nil, status.Errorf(codes.InvalidArgument, "invalid path (%s). %s", in.Path, err)
 	}
-	if err := s.fs.MakeDir(in.Path); err != nil {
+	if err := s.writable(); err != nil {
+		return nil, err
+	}
+	op, err := s.preOp(ctx, OpMakeDir, path, "")
+	if err != nil {
 		return nil, err
 	}
+	err = s.makeDir(path, in.Parents)
+	s.postOp(ctx, op, err)
+	if err != nil {
+		return nil, toStatus(err)
+	}
 	return &pb_filesystem.StatusResponse{Status: pb_filesystem.Status_SUCCESS}, nil
 }
 func (s *Server) Remove(ctx context.Context, in *pb_filesystem.Path) (*pb_filesystem.StatusResponse, error) {
 	glog.V(1).Infof("Start Remove %s\n", in.Path)
 	defer glog.V(1).Infof("End Remove %s\n", in.Path)
-	if err := s.validatePath(in.Path); err != nil {
+	path, err := s.validatePath(in.Path)
+	if err != nil {
 		return nil, status.Errorf(codes.InvalidArgument, "invalid path (%s). %s", in.Path, err)
 	}
-	if err := s.fs.Remove(in.Path); err != nil {
+	if err := s.writable(); err != nil {
 		return nil, err
 	}
+	op, err := s.preOp(ctx, OpRemove, path, "")
+	if err != nil {
+		return nil, err
+	}
+	err = s.fs.Remove(path)
+	s.postOp(ctx, op, err)
+	if err != nil {
+		return nil, toStatus(err)
+	}
 	return &pb_filesystem.StatusResponse{Status: pb_filesystem.Status_SUCCESS}, nil
 }
 func (s *Server) CreateFile(ctx context.Context, in *pb_filesystem.Path) (*pb_filesystem.StatusResponse, error) {
 	glog.V(1).Infof("Start CreateFile %s\n", in.Path)
 	defer glog.V(1).Infof("End CreateFile %s\n", in.Path)
-	if err := s.validatePath(in.Path); err != nil {
+	path, err := s.validatePath(in.Path)
+	if err != nil {
 		return nil, status.Errorf(codes.InvalidArgument, "invalid path (%s). %s", in.Path, err)
 	}
-	if err := s.fs.NewFile(in.Path); err != nil {
+	if err := s.writable(); err != nil {
 		return nil, err
 	}
+	op, err := s.preOp(ctx, OpCreate, path, "")
+	if err != nil {
+		return nil, err
+	}
+	err = s.createFile(path, in.Parents)
+	s.postOp(ctx, op, err)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &pb_filesystem.StatusResponse{Status: pb_filesystem.Status_SUCCESS}, nil
+}
+
+// createFile creates the file at path, along with the missing dirs above it with parents, which
+// needs a filesystem supporting it.
+func (s *Server) createFile(path string, parents bool) error {
+	if !parents {
+		return s.fs.NewFile(path)
+	}
+	creator, ok := s.fs.(fs.Creator)
+	if !ok {
+		return &fs.PathError{Op: "create", Path: path, Err: fmt.Errorf("creating parents: %w", fs.ErrNotSupported)}
+	}
+	return creator.CreateFile(path, fs.CreateOpts{Parents: true})
+}
+
+// makeDir makes the dir at path, along with the missing dirs above it with parents, which needs a
+// filesystem supporting it.
+func (s *Server) makeDir(path string, parents bool) error {
+	if !parents {
+		return s.fs.MakeDir(path)
+	}
+	maker, ok := s.fs.(fs.DirMaker)
+	if !ok {
+		return &fs.PathError{Op: "mkdir", Path: path, Err: fmt.Errorf("creating parents: %w", fs.ErrNotSupported)}
+	}
+	return maker.MakeDirAll(path)
+}
+
+// Removes path and everything under it in one call. With dry_run, nothing is removed.
+func (s *Server) DeletePrefix(ctx context.Context, in *pb_filesystem.DeletePrefixRequest) (*pb_filesystem.DeletePrefixResponse, error) {
+	glog.V(1).Infof("Start DeletePrefix %s\n", in.Path)
+	defer glog.V(1).Infof("End DeletePrefix %s\n", in.Path)
+	path, err := s.validatePath(in.Path)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid path (%s). %s", in.Path, err)
+	}
+	if err := s.writable(); err != nil {
+		return nil, err
+	}
+	// Dry runs aren't hooked.
+	var op OpInfo
+	if !in.DryRun {
+		var err error
+		if op, err = s.preOp(ctx, OpDeletePrefix, path, ""); err != nil {
+			return nil, err
+		}
+	}
+	deleted, err := s.fs.DeletePrefix(path, in.DryRun)
+	s.postOp(ctx, op, err)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &pb_filesystem.DeletePrefixResponse{Deleted: int64(deleted)}, nil
+}
+
+// Copies the file at src to a new file at dst, both on this server.
+func (s *Server) Copy(ctx context.Context, in *pb_filesystem.CopyRequest) (*pb_filesystem.StatusResponse, error) {
+	glog.V(1).Infof("Start Copy %s %s\n", in.Src, in.Dst)
+	defer glog.V(1).Infof("End Copy %s %s\n", in.Src, in.Dst)
+	src, err := s.validatePath(in.Src)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid path (%s). %s", in.Src, err)
+	}
+	dst, err := s.validatePath(in.Dst)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid path (%s). %s", in.Dst, err)
+	}
+	if err := s.writable(); err != nil {
+		return nil, err
+	}
+	op, err := s.preOp(ctx, OpCopy, src, dst)
+	if err != nil {
+		return nil, err
+	}
+	if s.admission.limit() > 0 {
+		usage, err := s.usage()
+		if err != nil {
+			s.postOp(ctx, op, err)
+			return nil, toStatus(err)
+		}
+		// Missing sources are left for Copy to report.
+		var size int64
+		if file, _, err := s.fs.Stat(src); err == nil && file != nil {
+			size = file.Size()
+		}
+		if err := s.admission.admit(usage, size); err != nil {
+			s.postOp(ctx, op, err)
+			return nil, err
+		}
+	}
+	err = s.fs.Copy(src, dst)
+	s.postOp(ctx, op, err)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &pb_filesystem.StatusResponse{Status: pb_filesystem.Status_SUCCESS}, nil
+}
+
+// Returns the file or the dir at path, whichever exists.
+func (s *Server) Stat(ctx context.Context, in *pb_filesystem.Path) (*pb_filesystem.StatResponse, error) {
+	glog.V(1).Infof("Start Stat %s\n", in.Path)
+	defer glog.V(1).Infof("End Stat %s\n", in.Path)
+	path, err := s.validatePath(in.Path)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid path (%s). %s", in.Path, err)
+	}
+	file, dir, err := s.fs.Stat(path)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	if file != nil {
+		return &pb_filesystem.StatResponse{File: toFile(file)}, nil
+	}
+	return &pb_filesystem.StatResponse{Dir: toDir(dir)}, nil
+}
+
+// Creates a file at path if it doesn't exist. Otherwise, updates its modification time.
+func (s *Server) TouchFile(ctx context.Context, in *pb_filesystem.Path) (*pb_filesystem.StatusResponse, error) {
+	glog.V(1).Infof("Start TouchFile %s\n", in.Path)
+	defer glog.V(1).Infof("End TouchFile %s\n", in.Path)
+	path, err := s.validatePath(in.Path)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid path (%s). %s", in.Path, err)
+	}
+	if err := s.writable(); err != nil {
+		return nil, err
+	}
+	op, err := s.preOp(ctx, OpTouch, path, "")
+	if err != nil {
+		return nil, err
+	}
+	err = s.fs.TouchFile(path)
+	s.postOp(ctx, op, err)
+	if err != nil {
+		return nil, toStatus(err)
+	}
 	return &pb_filesystem.StatusResponse{Status: pb_filesystem.Status_SUCCESS}, nil
 }
 
 func (s *Server) ReadFile(in *pb_filesystem.Path, stream pb_filesystem.FileSever_ReadFileServer) error {
 	glog.V(1).Infof("Start ReadFile %s\n", in.Path)
 	defer glog.V(1).Infof("End ReadFile %s\n", in.Path)
-	if err := s.validatePath(in.Path); err != nil {
+	path, err := s.validatePath(in.Path)
+	if err != nil {
 		return status.Errorf(codes.InvalidArgument, "invalid path (%s). %s", in.Path, err)
 	}
+	if err := s.fresh(in.MaxStalenessMs); err != nil {
+		return err
+	}
 
-	writer := streamWriter{stream: stream}
-	if _, err := s.fs.Read(in.Path, writer); err != nil {
+	op, err := s.preOp(stream.Context(), OpRead, path, "")
+	if err != nil {
 		return err
 	}
 
+	// Reading stops at the first chunk the client is gone for.
+	writer := &streamWriter{stream: stream, crc32c: in.Crc32c, summary: newSummary()}
+	_, err = s.fs.Read(path, writer)
+	s.postOp(stream.Context(), op, err)
+	if err != nil {
+		return toStatus(err)
+	}
+	writer.summary.send(stream)
 	return nil
 }
 func (s *Server) WriteFile(stream pb_filesystem.FileSever_WriteFileServer) error {
@@ -168,47 +962,267 @@ func (s *Server) WriteFile(stream pb_filesystem.FileSever_WriteFileServer) error
 
 	// First message must be the full path. Others are the bytes
 	if in.GetPath() == "" {
-		return fmt.Errorf("first message must be the path of the file to write to")
+		return status.Errorf(codes.InvalidArgument, "first message must be the path of the file to write to")
+	}
+	// Like the other handlers, nothing is created or written before the path is validated.
+	path, err := s.validatePath(in.GetPath())
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument, "invalid path (%s). %s", in.GetPath(), err)
 	}
-	reader := streamReader{stream: stream}
-	if _, err := s.fs.Write(in.GetPath(), reader); err != nil {
+	if err := s.writable(); err != nil {
 		return err
 	}
+	op, err := s.preOp(stream.Context(), OpWrite, path, "")
+	if err != nil {
+		return err
+	}
+	err = s.writeFile(stream, path, in)
+	s.postOp(stream.Context(), op, err)
+	return err
+}
 
+// writeFile writes the rest of a WriteFile stream to the file at path, the validated path of its
+// first message, in.
+func (s *Server) writeFile(stream pb_filesystem.FileSever_WriteFileServer, path string, in *pb_filesystem.FilePayload) error {
+	received := &streamReader{stream: stream, idle: s.writeIdle, check: s.checkPayload, summary: newSummary()}
+	admitted, release, err := s.admitWrite(received)
+	if err != nil {
+		return toStatus(err)
+	}
+	defer release()
+	if s.writes != nil {
+		release, err := s.writes.acquire(stream.Context(), path)
+		if err != nil {
+			return status.FromContextError(err).Err()
+		}
+		defer release()
+	}
+	reader := s.validate(path, admitted)
+	hint := in.GetSizeHint()
+	if hint > maxPreallocation {
+		hint = maxPreallocation
+	}
+	if max := atomic.LoadInt64(&s.maxFileSize); max > 0 {
+		// Missing files and dirs are left for Write to report.
+		var size int64
+		if file, _, err := s.fs.Stat(path); err == nil && file != nil {
+			size = file.Size()
+		}
+		reader = &maxSizeReader{r: reader, path: path, max: max, remaining: max - size}
+		if hint > max-size {
+			hint = max - size
+		}
+	}
+	// A client canceling or disconnecting mid-upload fails the write. Uploads are staged until
+	// they're complete, so that nothing of a failed one is written.
+	if !s.streamingWrites {
+		staged, err := stage(reader, hint)
+		if err != nil {
+			return toStatus(err)
+		}
+		defer staged.Close()
+		reader = staged
+	}
+	// The file is only created once the upload is staged, so that rejected uploads don't leave an
+	// empty one behind. Streamed uploads can fail while written, so the file is removed then.
+	created := false
+	if in.GetParents() {
+		err := s.createFile(path, true)
+		if err != nil && !errors.Is(err, fs.ErrAlreadyExist) {
+			return toStatus(err)
+		}
+		created = err == nil
+	}
+	s.preallocate(path, hint)
+	if _, err := s.fs.Write(path, reader); err != nil {
+		if created {
+			if err := s.fs.Remove(path); err != nil {
+				glog.Warningf("Failed to remove %s after its upload failed. %s\n", path, err)
+			}
+		}
+		return toStatus(err)
+	}
+	received.summary.send(stream)
 	return stream.SendAndClose(&pb_filesystem.StatusResponse{Status: pb_filesystem.Status_SUCCESS})
 }
 
+// maxPreallocation caps the size hints of uploads, since they're up to clients.
+const maxPreallocation = 256 << 20
+
+// preallocate makes room for the hinted size of an upload to path when the filesystem supports it.
+// Failures are left for the write itself to report.
+func (s *Server) preallocate(path string, hint int64) {
+	p, ok := s.fs.(fs.Preallocator)
+	if !ok || hint <= 0 {
+		return
+	}
+	if err := p.Preallocate(path, hint); err != nil {
+		glog.V(1).Infof("Failed to preallocate %d bytes for %s. %s\n", hint, path, err)
+	}
+}
+
+// chunkSize is the most ReadFile sends in a payload. It matches gRPC's default write buffer, so
+// that each payload goes out in a single write.
+const chunkSize = 32 << 10
+
+// chunks holds the buffers streamWriter reads content into.
+var chunks = sync.Pool{New: func() interface{} {
+	b := make([]byte, chunkSize)
+	return &b
+}}
+
+// streamWriter sends what's written to a ReadFile stream in payloads of up to chunkSize. It fails
+// with the stream's context error once the client cancels or disconnects.
 type streamWriter struct {
 	stream pb_filesystem.FileSever_ReadFileServer
+	// crc32c sets the checksum of payloads.
+	crc32c  bool
+	summary *summary
+}
+
+func (sw *streamWriter) Write(p []byte) (int, error) {
+	n := 0
+	for len(p) > 0 {
+		chunk := p
+		if len(chunk) > chunkSize {
+			chunk = chunk[:chunkSize]
+		}
+		if err := sw.send(chunk); err != nil {
+			return n, err
+		}
+		n += len(chunk)
+		p = p[len(chunk):]
+	}
+	return n, nil
 }
 
-func (sw streamWriter) Write(p []byte) (int, error) {
-	if err := sw.stream.Send(&pb_filesystem.Payload{Data: p}); err != nil {
-		return 0, err
+// ReadFrom is the fast path of io.Copy, used when content is streamed from disk: it reads straight
+// into a pooled chunk and sends it, instead of copying through io.Copy's buffer.
+func (sw *streamWriter) ReadFrom(r io.Reader) (int64, error) {
+	buf := chunks.Get().(*[]byte)
+	defer chunks.Put(buf)
+	var total int64
+	for {
+		n, err := io.ReadFull(r, *buf)
+		if n > 0 {
+			// Send marshals the payload before returning, so the chunk can be reused.
+			if err := sw.send((*buf)[:n]); err != nil {
+				return total, err
+			}
+			total += int64(n)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return total, nil
+		}
+		if err != nil {
+			return total, err
+		}
 	}
-	return len(p), nil
 }
 
+func (sw *streamWriter) send(p []byte) error {
+	if err := sw.stream.Context().Err(); err != nil {
+		return err
+	}
+	sw.summary.add(p)
+	payload := &pb_filesystem.Payload{Data: p}
+	if sw.crc32c {
+		payload.Crc32c, payload.HasCrc32c = crc32.Checksum(p, castagnoli), true
+	}
+	if err := sw.stream.Send(payload); err != nil {
+		if ctxErr := sw.stream.Context().Err(); ctxErr != nil {
+			return ctxErr
+		}
+		return err
+	}
+	return nil
+}
+
+// streamReader reads the payloads of a WriteFile stream, skipping empty ones (heartbeats). Like
+// streamWriter, it fails with the stream's context error once the client cancels or disconnects.
 type streamReader struct {
 	stream pb_filesystem.FileSever_WriteFileServer
+	// idle fails reads once no payload arrived for this long. 0 disables it.
+	idle time.Duration
+	// check fails payloads that don't match their checksum.
+	check   func(*pb_filesystem.FilePayload) error
+	summary *summary
 
 	buf []byte
 }
 
-func (sw streamReader) Read(p []byte) (int, error) {
-	if len(sw.buf) > 0 {
-		return sw.read(p), nil
-	}
-	pb, err := sw.stream.Recv()
-	if err != nil {
-		return 0, err
+func (sw *streamReader) Read(p []byte) (int, error) {
+	for len(sw.buf) == 0 {
+		if err := sw.stream.Context().Err(); err != nil {
+			return 0, err
+		}
+		pb, err := sw.recv()
+		if err != nil {
+			if ctxErr := sw.stream.Context().Err(); ctxErr != nil {
+				return 0, ctxErr
+			}
+			return 0, err
+		}
+		if err := sw.check(pb); err != nil {
+			return 0, err
+		}
+		sw.summary.add(pb.GetData())
+		sw.buf = pb.GetData()
 	}
-	sw.buf = pb.GetData()
 	return sw.read(p), nil
 }
 
-func (sw streamReader) read(p []byte) int {
+// recv receives the next payload, failing with DeadlineExceeded if it doesn't arrive within idle.
+// The receive then left pending ends with the stream once WriteFile returns.
+func (sw *streamReader) recv() (*pb_filesystem.FilePayload, error) {
+	if sw.idle <= 0 {
+		return sw.stream.Recv()
+	}
+	type received struct {
+		pb  *pb_filesystem.FilePayload
+		err error
+	}
+	ch := make(chan received, 1)
+	go func() {
+		pb, err := sw.stream.Recv()
+		ch <- received{pb, err}
+	}()
+	timer := time.NewTimer(sw.idle)
+	defer timer.Stop()
+	select {
+	case r := <-ch:
+		return r.pb, r.err
+	case <-timer.C:
+		return nil, status.Errorf(codes.DeadlineExceeded, "nothing received for %s", sw.idle)
+	}
+}
+
+func (sw *streamReader) read(p []byte) int {
 	n := copy(p, sw.buf)
 	sw.buf = sw.buf[n:]
 	return n
 }
+
+// SetMaxFileSize changes the max file size (see Opts.MaxFileSize) of a running server. It applies
+// to the uploads started afterwards.
+func (s *Server) SetMaxFileSize(max int64) {
+	atomic.StoreInt64(&s.maxFileSize, max)
+}
+
+// maxSizeReader fails with fs.ErrLimitExceeded once more than remaining bytes are read, which is
+// what's left of max for the file at path.
+type maxSizeReader struct {
+	r         io.Reader
+	path      string
+	max       int64
+	remaining int64
+}
+
+func (m *maxSizeReader) Read(p []byte) (int, error) {
+	n, err := m.r.Read(p)
+	m.remaining -= int64(n)
+	if m.remaining < 0 {
+		return 0, &fs.PathError{Op: "write", Path: m.path, Err: fmt.Errorf("max file size of %d bytes: %w", m.max, fs.ErrLimitExceeded)}
+	}
+	return n, err
+}
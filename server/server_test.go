@@ -0,0 +1,180 @@
+package server
+
+import (
+	"context"
+	"io"
+	"path/filepath"
+	"testing"
+
+	"github.com/basharal/filesystem/proto/pb_filesystem"
+	_ "github.com/mattn/go-sqlite3"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// uploadStream is a WriteFile stream that receives payloads and keeps the response.
+type uploadStream struct {
+	grpc.ServerStream
+	payloads []*pb_filesystem.FilePayload
+	resp     *pb_filesystem.StatusResponse
+	// err fails the stream once the payloads were received, instead of ending it.
+	err error
+}
+
+// newUpload returns a stream uploading chunks to path. first sets the rest of the first message.
+func newUpload(path string, first *pb_filesystem.FilePayload, chunks ...string) *uploadStream {
+	if first == nil {
+		first = &pb_filesystem.FilePayload{}
+	}
+	first.Input = &pb_filesystem.FilePayload_Path{Path: path}
+	u := &uploadStream{payloads: []*pb_filesystem.FilePayload{first}}
+	for _, c := range chunks {
+		u.payloads = append(u.payloads, &pb_filesystem.FilePayload{Input: &pb_filesystem.FilePayload_Data{Data: []byte(c)}})
+	}
+	return u
+}
+
+func (u *uploadStream) Context() context.Context {
+	return context.Background()
+}
+
+func (u *uploadStream) SetTrailer(metadata.MD) {}
+
+func (u *uploadStream) Recv() (*pb_filesystem.FilePayload, error) {
+	if len(u.payloads) == 0 {
+		if u.err != nil {
+			return nil, u.err
+		}
+		return nil, io.EOF
+	}
+	p := u.payloads[0]
+	u.payloads = u.payloads[1:]
+	return p, nil
+}
+
+func (u *uploadStream) SendAndClose(resp *pb_filesystem.StatusResponse) error {
+	u.resp = resp
+	return nil
+}
+
+// pathErrorOf returns the PathError detail of the status err, if any.
+func pathErrorOf(err error) *pb_filesystem.PathError {
+	for _, d := range status.Convert(err).Details() {
+		if pe, ok := d.(*pb_filesystem.PathError); ok {
+			return pe
+		}
+	}
+	return nil
+}
+
+// newTestServer returns a server for the range [a, b) with /a/ made.
+func newTestServer(t *testing.T, opts Opts) *Server {
+	t.Helper()
+	opts.StartPrefix, opts.EndPrefix = "a", "b"
+	s, err := New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.fs.MakeDir("/a/"); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestServer_ValidatePath(t *testing.T) {
+	s := newTestServer(t, Opts{})
+	tests := []struct {
+		path string
+		want string
+		ok   bool
+	}{
+		{path: "/", want: "/", ok: true},
+		{path: "/a", want: "/a", ok: true},
+		{path: "/a/", want: "/a/", ok: true},
+		{path: "/abc/d", want: "/abc/d", ok: true},
+		{path: "/a/./b/../c/", want: "/a/c/", ok: true},
+		{path: "/z/../a/x", want: "/a/x", ok: true},
+		{path: "/a/..", want: "/", ok: true},
+		{path: "/a/x/../notes.", want: "/a/notes.", ok: true},
+		{path: "/a/b/.", want: "/a/b/", ok: true},
+		{path: "/b", ok: false},
+		{path: "/a/../z", ok: false},
+		{path: "/a/../../z/", ok: false},
+		{path: "a", ok: false},
+		{path: "", ok: false},
+	}
+	for _, tt := range tests {
+		got, err := s.validatePath(tt.path)
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("Server.validatePath(%q) = %q, %v, want %q, ok %v", tt.path, got, err, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestServer_DotsStayInRange(t *testing.T) {
+	s := newTestServer(t, Opts{})
+	ctx := context.Background()
+	for _, path := range []string{"/z/", "/a/../z/", "/a/./../z/"} {
+		_, err := s.MakeDir(ctx, &pb_filesystem.Path{Path: path})
+		if status.Code(err) != codes.InvalidArgument {
+			t.Errorf("Server.MakeDir(%s) = %v, want InvalidArgument", path, err)
+		}
+	}
+	if _, _, err := s.fs.Stat("/z/"); err == nil {
+		t.Errorf("/z/ was made outside of the range")
+	}
+	// Dots within the range operate on the canonical path.
+	if _, err := s.MakeDir(ctx, &pb_filesystem.Path{Path: "/z/../a/b/", Parents: true}); err != nil {
+		t.Fatalf("Server.MakeDir(/z/../a/b/) = %v", err)
+	}
+	if _, dir, err := s.fs.Stat("/a/b/"); err != nil || dir == nil {
+		t.Errorf("FileSystem.Stat(/a/b/) = %v, %v, want the made dir", dir, err)
+	}
+}
+
+func TestServer_WriteFileOutOfRange(t *testing.T) {
+	s := newTestServer(t, Opts{})
+	tests := []string{"/q/r/file", "/a/../q/file", "/b"}
+	for _, path := range tests {
+		stream := newUpload(path, &pb_filesystem.FilePayload{Parents: true}, "data")
+		if err := s.WriteFile(stream); status.Code(err) != codes.InvalidArgument {
+			t.Errorf("Server.WriteFile(%s) = %v, want InvalidArgument", path, err)
+		}
+	}
+	for _, path := range []string{"/q/", "/b"} {
+		if _, _, err := s.fs.Stat(path); err == nil {
+			t.Errorf("%s was created outside of the range", path)
+		}
+	}
+	stream := newUpload("/a/x/../file", &pb_filesystem.FilePayload{Parents: true}, "data")
+	if err := s.WriteFile(stream); err != nil || stream.resp == nil {
+		t.Fatalf("Server.WriteFile(/a/x/../file) = %v, %v", stream.resp, err)
+	}
+	if file, _, err := s.fs.Stat("/a/file"); err != nil || file.Size() != 4 {
+		t.Errorf("FileSystem.Stat(/a/file) = %v, %v, want 4 bytes", file, err)
+	}
+	// Names ending in a dot are files.
+	stream = newUpload("/a/x/../notes.", &pb_filesystem.FilePayload{Parents: true}, "data")
+	if err := s.WriteFile(stream); err != nil || stream.resp == nil {
+		t.Fatalf("Server.WriteFile(/a/x/../notes.) = %v, %v", stream.resp, err)
+	}
+	if file, _, err := s.fs.Stat("/a/notes."); err != nil || file == nil || file.Size() != 4 {
+		t.Errorf("FileSystem.Stat(/a/notes.) = %v, %v, want 4 bytes", file, err)
+	}
+}
+
+func TestNew_SQLiteBackend(t *testing.T) {
+	s := newTestServer(t, Opts{Backend: BackendSQLite, SQLitePath: filepath.Join(t.TempDir(), "fs.db")})
+	if _, err := s.CreateFile(context.Background(), &pb_filesystem.Path{Path: "/a/file"}); err != nil {
+		t.Fatalf("Server.CreateFile() = %v", err)
+	}
+	stream := newUpload("/a/file", nil, "stored in sqlite")
+	if err := s.WriteFile(stream); err != nil {
+		t.Fatalf("Server.WriteFile() = %v", err)
+	}
+	if file, _, err := s.fs.Stat("/a/file"); err != nil || file.Size() != 16 {
+		t.Errorf("FileSystem.Stat(/a/file) = %v, %v, want 16 bytes", file, err)
+	}
+}
@@ -0,0 +1,124 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/basharal/filesystem/fs"
+	"github.com/basharal/filesystem/fspath"
+	"github.com/basharal/filesystem/proto/pb_filesystem"
+	"github.com/golang/glog"
+	"google.golang.org/protobuf/proto"
+)
+
+const sinkQueueSize = 1024
+
+// EventSink receives filesystem events and exports them to an external system. Send is called
+// for one event at a time and in order.
+type EventSink interface {
+	Send(ctx context.Context, e fs.Event) error
+	Close() error
+}
+
+// EventFilter selects which events are exported.
+type EventFilter struct {
+	// Events to export. Empty means all events.
+	Events []fs.EventType
+
+	// PathPrefix only exports events for paths under the prefix. Empty means all paths.
+	PathPrefix string
+}
+
+// Matches returns true if the event passes the filter.
+func (f EventFilter) Matches(e fs.Event) bool {
+	if f.PathPrefix != "" && !fspath.HasPrefix(e.Path, f.PathPrefix) &&
+		!fspath.HasPrefix(e.NewPath, f.PathPrefix) {
+		return false
+	}
+	if len(f.Events) == 0 {
+		return true
+	}
+	for _, t := range f.Events {
+		if t == e.Type {
+			return true
+		}
+	}
+	return false
+}
+
+// SinkOpts configures an event sink on the server.
+type SinkOpts struct {
+	Sink   EventSink
+	Filter EventFilter
+}
+
+// Encoding is the serialization used for exported events.
+type Encoding int
+
+const (
+	EncodingJSON Encoding = iota
+	EncodingProto
+)
+
+// ParseEncoding returns the encoding by name (json or proto).
+func ParseEncoding(s string) (Encoding, error) {
+	switch strings.ToLower(s) {
+	case "", "json":
+		return EncodingJSON, nil
+	case "proto":
+		return EncodingProto, nil
+	}
+	return 0, fmt.Errorf("unknown encoding %s", s)
+}
+
+func encodeEvent(e fs.Event, enc Encoding) ([]byte, error) {
+	switch enc {
+	case EncodingJSON:
+		return json.Marshal(e)
+	case EncodingProto:
+		return proto.Marshal(toProtoEvent(e))
+	}
+	return nil, fmt.Errorf("unknown encoding %d", enc)
+}
+
+func toProtoEvent(e fs.Event) *pb_filesystem.Event {
+	types := map[fs.EventType]pb_filesystem.EventType{
+		fs.EventCreate:  pb_filesystem.EventType_EVENT_CREATE,
+		fs.EventMakeDir: pb_filesystem.EventType_EVENT_MAKE_DIR,
+		fs.EventWrite:   pb_filesystem.EventType_EVENT_WRITE,
+		fs.EventRemove:  pb_filesystem.EventType_EVENT_REMOVE,
+		fs.EventMove:    pb_filesystem.EventType_EVENT_MOVE,
+	}
+	return &pb_filesystem.Event{
+		Type:         types[e.Type],
+		Path:         e.Path,
+		NewPath:      e.NewPath,
+		IsDir:        e.IsDir,
+		TimeUnixNano: e.Time.UnixNano(),
+	}
+}
+
+// runSink exports matching events to the sink until ctx is done. The sink is closed on exit.
+func runSink(ctx context.Context, filesystem fs.Watcher, opts SinkOpts) {
+	events, stop := filesystem.Watch(sinkQueueSize)
+	defer stop()
+	defer opts.Sink.Close()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case e, ok := <-events:
+			if !ok {
+				return
+			}
+			if !opts.Filter.Matches(e) {
+				continue
+			}
+			if err := opts.Sink.Send(ctx, e); err != nil {
+				glog.Errorf("Failed to export %s event for %s. %s\n", e.Type, e.Path, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,337 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/basharal/filesystem/blob"
+	"github.com/basharal/filesystem/fs"
+	"github.com/basharal/filesystem/proto/pb_filesystem"
+	"github.com/golang/glog"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	defaultSnapshotInterval = 15 * time.Minute
+	defaultSnapshotKeep     = 4
+)
+
+// snapshotter takes snapshots of the filesystem to a store and keeps the last few. Snapshots are
+// keyed by the time they were taken under the server's range, so servers can share a store. The
+// keys of the kept snapshots are in an index, since stores can't list keys. Incremental snapshots
+// have an .inc.tar suffix and depend on the snapshots before them back to a full one.
+type snapshotter struct {
+	store     blob.Store
+	prefix    string
+	interval  time.Duration
+	keep      int
+	fullEvery int
+
+	// last is when the last snapshot was taken in Unix nanoseconds, or zero. Must be accessed
+	// atomically.
+	last int64
+
+	// mu serializes snapshots and protects below.
+	mu sync.Mutex
+	// keys of the kept snapshots, oldest first. nil until loaded from the index.
+	keys []string
+	// since is when the last snapshot taken by this server was started, or zero. incremental is
+	// the number of incremental snapshots taken since the last full one.
+	since       time.Time
+	incremental int
+}
+
+func newSnapshotter(opts Opts) *snapshotter {
+	s := &snapshotter{
+		store:     opts.SnapshotStore,
+		prefix:    fmt.Sprintf("snapshots/%s-%s/", opts.StartPrefix, opts.EndPrefix),
+		interval:  opts.SnapshotInterval,
+		keep:      opts.SnapshotKeep,
+		fullEvery: opts.SnapshotFullEvery,
+	}
+	if s.interval == 0 {
+		s.interval = defaultSnapshotInterval
+	}
+	if s.keep == 0 {
+		s.keep = defaultSnapshotKeep
+	}
+	if s.fullEvery == 0 {
+		s.fullEvery = 1
+	}
+	return s
+}
+
+const incrementalSuffix = ".inc.tar"
+
+func isIncremental(key string) bool {
+	return strings.HasSuffix(key, incrementalSuffix)
+}
+
+func (s *snapshotter) indexKey() string {
+	return s.prefix + "index.json"
+}
+
+// loadIndex reads the keys of the kept snapshots if they weren't yet. mu must be held.
+func (s *snapshotter) loadIndex() error {
+	if s.keys != nil {
+		return nil
+	}
+	var buf bytes.Buffer
+	keys := []string{}
+	if _, err := s.store.Get(s.indexKey(), &buf); err == nil {
+		if err := json.Unmarshal(buf.Bytes(), &keys); err != nil {
+			return fmt.Errorf("failed to parse the snapshot index. %w", err)
+		}
+	} else if !errors.Is(err, blob.ErrNotFound) {
+		return err
+	}
+	s.keys = keys
+	if len(keys) > 0 {
+		atomic.StoreInt64(&s.last, s.takenAt(keys[len(keys)-1]))
+	}
+	return nil
+}
+
+// takenAt returns when the snapshot at key was taken in Unix nanoseconds, or zero.
+func (s *snapshotter) takenAt(key string) int64 {
+	name := strings.TrimPrefix(key, s.prefix)
+	name = strings.TrimSuffix(strings.TrimSuffix(name, incrementalSuffix), ".tar")
+	nanos, err := strconv.ParseInt(name, 10, 64)
+	if err != nil {
+		return 0
+	}
+	return nanos
+}
+
+// take writes a snapshot of f and removes the ones beyond the last keep that no kept one depends
+// on.
+func (s *snapshotter) take(f fs.Snapshotter, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.loadIndex(); err != nil {
+		return err
+	}
+
+	// Incremental snapshots need the previous one, which may not have been kept (or taken by this
+	// server) otherwise.
+	since := s.since
+	if s.incremental+1 >= s.fullEvery || len(s.keys) == 0 || s.takenAt(s.keys[len(s.keys)-1]) != since.UnixNano() {
+		since = time.Time{}
+	}
+	key := fmt.Sprintf("%s%d.tar", s.prefix, now.UnixNano())
+	if !since.IsZero() {
+		key = fmt.Sprintf("%s%d%s", s.prefix, now.UnixNano(), incrementalSuffix)
+	}
+	r, w := io.Pipe()
+	go func() {
+		w.CloseWithError(f.SnapshotSince(w, since))
+	}()
+	_, err := s.store.Put(key, r)
+	// Unblocks the snapshot if the store stopped reading.
+	r.CloseWithError(err)
+	if err != nil {
+		s.store.Delete(key)
+		return err
+	}
+
+	keys := append(s.keys, key)
+	cut := len(keys) - s.keep
+	for cut > 0 && isIncremental(keys[cut]) {
+		cut--
+	}
+	var expired []string
+	if cut > 0 {
+		expired = keys[:cut]
+		keys = keys[cut:]
+	}
+	index, err := json.Marshal(keys)
+	if err != nil {
+		return err
+	}
+	if _, err := s.store.Put(s.indexKey(), bytes.NewReader(index)); err != nil {
+		return err
+	}
+	s.keys = keys
+	s.since = now
+	if since.IsZero() {
+		s.incremental = 0
+	} else {
+		s.incremental++
+	}
+	atomic.StoreInt64(&s.last, now.UnixNano())
+	for _, key := range expired {
+		if err := s.store.Delete(key); err != nil && !errors.Is(err, blob.ErrNotFound) {
+			glog.Errorf("Failed to delete snapshot %s. %s\n", key, err)
+		}
+	}
+	return nil
+}
+
+// list returns when the kept snapshots were taken, oldest first.
+func (s *snapshotter) list() ([]time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.loadIndex(); err != nil {
+		return nil, err
+	}
+	taken := make([]time.Time, 0, len(s.keys))
+	for _, key := range s.keys {
+		taken = append(taken, time.Unix(0, s.takenAt(key)))
+	}
+	return taken, nil
+}
+
+// restore restores the part of f that opts select from the latest snapshot taken at or before at.
+// A zero at selects the latest snapshot. It returns the number of restored files/dirs and when the
+// snapshot was taken.
+func (s *snapshotter) restore(f fs.Snapshotter, at time.Time, opts fs.RestoreOpts) (int, time.Time, error) {
+	// Held throughout so that the snapshot doesn't expire while being restored.
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.loadIndex(); err != nil {
+		return 0, time.Time{}, err
+	}
+	last := -1
+	var taken int64
+	// Snapshots are listed in milliseconds, so they're compared in milliseconds.
+	ms := int64(time.Millisecond)
+	for i, k := range s.keys {
+		if t := s.takenAt(k); at.IsZero() || t/ms <= at.UnixNano()/ms {
+			last, taken = i, t
+		}
+	}
+	if last < 0 {
+		return 0, time.Time{}, errNoSnapshot
+	}
+	first := last
+	for first >= 0 && isIncremental(s.keys[first]) {
+		first--
+	}
+	if first < 0 {
+		return 0, time.Time{}, fmt.Errorf("the full snapshot %s depends on is missing", s.keys[last])
+	}
+
+	var layers []fs.SnapshotLayer
+	for _, key := range s.keys[first : last+1] {
+		layers = append(layers, s.open(key))
+	}
+	n, err := f.RestoreSnapshots(layers, opts)
+	return n, time.Unix(0, taken), err
+}
+
+// open returns a layer that streams the snapshot at key from the store. Closing it unblocks the
+// store if the restore stopped reading.
+func (s *snapshotter) open(key string) fs.SnapshotLayer {
+	return func() (io.ReadCloser, error) {
+		r, w := io.Pipe()
+		go func() {
+			_, err := s.store.Get(key, w)
+			w.CloseWithError(err)
+		}()
+		return r, nil
+	}
+}
+
+// errNoSnapshot is returned when there's no snapshot to restore from.
+var errNoSnapshot = fmt.Errorf("no snapshot was taken by then")
+
+// age returns how long ago the last snapshot was taken, or false if there's none.
+func (s *snapshotter) age(now time.Time) (time.Duration, bool) {
+	last := atomic.LoadInt64(&s.last)
+	if last == 0 {
+		return 0, false
+	}
+	return now.Sub(time.Unix(0, last)), true
+}
+
+// runSnapshots takes a snapshot every interval until ctx is done.
+func (s *Server) runSnapshots(ctx context.Context) {
+	s.snapshots.mu.Lock()
+	if err := s.snapshots.loadIndex(); err != nil {
+		glog.Errorf("Failed to load the snapshot index. %s\n", err)
+	}
+	s.snapshots.mu.Unlock()
+
+	ticker := time.NewTicker(s.snapshots.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if err := s.snapshots.take(s.fs.(fs.Snapshotter), now); err != nil {
+				glog.Errorf("Failed to take a snapshot. %s\n", err)
+			}
+		}
+	}
+}
+
+// LastSnapshotAge returns how long ago the last snapshot was taken. It returns false if snapshots
+// aren't scheduled or none was taken yet.
+func (s *Server) LastSnapshotAge() (time.Duration, bool) {
+	if s.snapshots == nil {
+		return 0, false
+	}
+	return s.snapshots.age(time.Now())
+}
+
+// Returns the snapshots the server keeps, oldest first.
+func (s *Server) ListSnapshots(ctx context.Context, in *pb_filesystem.ListSnapshotsRequest) (*pb_filesystem.SnapshotList, error) {
+	if s.snapshots == nil {
+		return nil, status.Errorf(codes.FailedPrecondition, "snapshots aren't enabled")
+	}
+	taken, err := s.snapshots.list()
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to list snapshots. %s", err)
+	}
+	list := &pb_filesystem.SnapshotList{TakenUnixMs: make([]int64, 0, len(taken))}
+	for _, t := range taken {
+		list.TakenUnixMs = append(list.TakenUnixMs, t.UnixNano()/int64(time.Millisecond))
+	}
+	return list, nil
+}
+
+// Restores path from a snapshot to target, leaving the rest of the filesystem as is.
+func (s *Server) RestoreSnapshot(ctx context.Context, in *pb_filesystem.RestoreRequest) (*pb_filesystem.RestoreResponse, error) {
+	glog.V(1).Infof("Start RestoreSnapshot %s %s\n", in.Path, in.Target)
+	defer glog.V(1).Infof("End RestoreSnapshot %s %s\n", in.Path, in.Target)
+	if s.snapshots == nil {
+		return nil, status.Errorf(codes.FailedPrecondition, "snapshots aren't enabled")
+	}
+	target := in.Target
+	if target == "" {
+		target = in.Path
+	}
+	path, err := s.validatePath(in.Path)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid path (%s). %s", in.Path, err)
+	}
+	if target, err = s.validatePath(target); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid path (%s). %s", target, err)
+	}
+	if err := s.writable(); err != nil {
+		return nil, err
+	}
+	var at time.Time
+	if in.AtUnixMs != 0 {
+		at = time.Unix(0, in.AtUnixMs*int64(time.Millisecond))
+	}
+	n, taken, err := s.snapshots.restore(s.fs.(fs.Snapshotter), at, fs.RestoreOpts{Path: path, Target: target})
+	if err == errNoSnapshot {
+		return nil, status.Errorf(codes.NotFound, "%s", err)
+	}
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &pb_filesystem.RestoreResponse{Restored: int64(n), TakenUnixMs: taken.UnixNano() / int64(time.Millisecond)}, nil
+}
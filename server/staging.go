@@ -0,0 +1,67 @@
+package server
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// stagingMemory is how much of an upload is staged in memory before it spills to a temp file.
+const stagingMemory = 4 << 20
+
+// stagedUpload is an upload read to the end before it's written, so that uploads that fail midway
+// don't write anything. Close must be called once it's written.
+type stagedUpload struct {
+	buf  bytes.Buffer
+	file *os.File
+}
+
+// stage reads r to the end. Large uploads go to a temp file, which is removed on Close. hint is
+// the expected size of the upload, if known, so that the memory for it is allocated at once.
+func stage(r io.Reader, hint int64) (*stagedUpload, error) {
+	u := &stagedUpload{}
+	if hint > stagingMemory {
+		hint = stagingMemory + 1
+	}
+	if hint > 0 {
+		u.buf.Grow(int(hint))
+	}
+	if _, err := io.CopyN(&u.buf, r, stagingMemory+1); err == io.EOF {
+		return u, nil
+	} else if err != nil {
+		return nil, err
+	}
+	f, err := ioutil.TempFile("", "filesystem-upload-")
+	if err != nil {
+		return nil, err
+	}
+	u.file = f
+	if _, err := io.Copy(f, io.MultiReader(&u.buf, r)); err != nil {
+		u.Close()
+		return nil, err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		u.Close()
+		return nil, err
+	}
+	return u, nil
+}
+
+func (u *stagedUpload) Read(p []byte) (int, error) {
+	if u.file != nil {
+		return u.file.Read(p)
+	}
+	return u.buf.Read(p)
+}
+
+func (u *stagedUpload) Close() error {
+	if u.file == nil {
+		return nil
+	}
+	err := u.file.Close()
+	if rmErr := os.Remove(u.file.Name()); err == nil {
+		err = rmErr
+	}
+	return err
+}
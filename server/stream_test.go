@@ -0,0 +1,79 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/basharal/filesystem/blob"
+	"github.com/basharal/filesystem/fs"
+	"github.com/basharal/filesystem/proto/pb_filesystem"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// discardStream is a ReadFile stream that drops what's sent.
+type discardStream struct {
+	grpc.ServerStream
+	sent int64
+}
+
+func (s *discardStream) Context() context.Context {
+	return context.Background()
+}
+
+func (s *discardStream) SetTrailer(metadata.MD) {}
+
+func (s *discardStream) Send(p *pb_filesystem.Payload) error {
+	if len(p.Data) > chunkSize {
+		panic("payload larger than a chunk")
+	}
+	s.sent += int64(len(p.Data))
+	return nil
+}
+
+func benchmarkReadFile(b *testing.B, store fs.ContentStore, size int) {
+	s, err := New(Opts{ContentStore: store})
+	if err != nil {
+		b.Fatal(err)
+	}
+	if err := s.fs.NewFile("/file"); err != nil {
+		b.Fatal(err)
+	}
+	if _, err := s.fs.Write("/file", bytes.NewReader(make([]byte, size))); err != nil {
+		b.Fatal(err)
+	}
+	b.SetBytes(int64(size))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		stream := &discardStream{}
+		if err := s.ReadFile(&pb_filesystem.Path{Path: "/file"}, stream); err != nil {
+			b.Fatal(err)
+		}
+		if stream.sent != int64(size) {
+			b.Fatalf("sent %d bytes, want %d", stream.sent, size)
+		}
+	}
+}
+
+func BenchmarkReadFile_Memory(b *testing.B) {
+	benchmarkReadFile(b, nil, 8<<20)
+}
+
+func BenchmarkReadFile_Disk(b *testing.B) {
+	disk, err := blob.NewDisk(b.TempDir())
+	if err != nil {
+		b.Fatal(err)
+	}
+	benchmarkReadFile(b, fs.NewBlobContentStore(disk, ""), 8<<20)
+}
+
+func BenchmarkReadFile_Mmap(b *testing.B) {
+	m, err := blob.NewMmap(b.TempDir())
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer m.Close()
+	benchmarkReadFile(b, fs.NewBlobContentStore(m, ""), 8<<20)
+}
@@ -0,0 +1,51 @@
+package server
+
+import (
+	"hash/crc32"
+	"strconv"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// Trailing metadata keys of the summary ReadFile and WriteFile attach to the streams they
+// complete. These must match the ones in the client package.
+const (
+	summaryBytes      = "fs-bytes"
+	summaryChunks     = "fs-chunks"
+	summaryCRC32C     = "fs-crc32c"
+	summaryServerTime = "fs-server-time-us"
+)
+
+// summary tallies the data chunks of a transfer, so that clients can confirm they sent or
+// received the same content.
+type summary struct {
+	start  time.Time
+	bytes  int64
+	chunks int64
+	crc    uint32
+}
+
+func newSummary() *summary {
+	return &summary{start: time.Now()}
+}
+
+func (s *summary) add(data []byte) {
+	if len(data) == 0 {
+		return
+	}
+	s.bytes += int64(len(data))
+	s.chunks++
+	s.crc = crc32.Update(s.crc, castagnoli, data)
+}
+
+// send sets the summary as the trailer of stream.
+func (s *summary) send(stream grpc.ServerStream) {
+	stream.SetTrailer(metadata.Pairs(
+		summaryBytes, strconv.FormatInt(s.bytes, 10),
+		summaryChunks, strconv.FormatInt(s.chunks, 10),
+		summaryCRC32C, strconv.FormatUint(uint64(s.crc), 10),
+		summaryServerTime, strconv.FormatInt(time.Since(s.start).Microseconds(), 10),
+	))
+}
@@ -0,0 +1,31 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	"github.com/basharal/filesystem/fs"
+	"github.com/golang/glog"
+)
+
+const defaultSyncInterval = 10 * time.Second
+
+// runSyncer persists the filesystem every interval, and once more when ctx is done so that a
+// graceful shutdown doesn't lose changes.
+func (s *Server) runSyncer(ctx context.Context, syncer fs.Syncer) {
+	ticker := time.NewTicker(s.syncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			if err := syncer.Sync(); err != nil {
+				glog.Errorf("Failed to sync the filesystem. %s\n", err)
+			}
+			return
+		case <-ticker.C:
+			if err := syncer.Sync(); err != nil {
+				glog.Errorf("Failed to sync the filesystem. %s\n", err)
+			}
+		}
+	}
+}
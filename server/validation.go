@@ -0,0 +1,201 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/basharal/filesystem/fs"
+)
+
+// UploadValidator checks the content of uploads as it arrives, so that junk is rejected before
+// it's written (see Opts.UploadValidators).
+type UploadValidator interface {
+	// Name identifies the validator in rejections.
+	Name() string
+
+	// NewCheck returns the check of an upload to path, or nil if it doesn't need one.
+	NewCheck(path string) UploadCheck
+}
+
+// UploadCheck checks the content of a single upload. It's only called by the goroutine serving
+// the upload.
+type UploadCheck interface {
+	// Check is called with the content of the upload in order as it arrives. An error rejects the
+	// upload right away.
+	Check(p []byte) error
+
+	// Done is called once the whole upload arrived. An error rejects it.
+	Done() error
+}
+
+// MaxUploadSize rejects uploads of more than max bytes with fs.ErrLimitExceeded, unlike
+// Opts.MaxFileSize, which bounds the size of files after appending uploads to them.
+func MaxUploadSize(max int64) UploadValidator {
+	return maxUploadSize(max)
+}
+
+type maxUploadSize int64
+
+func (m maxUploadSize) Name() string {
+	return "max_size"
+}
+
+func (m maxUploadSize) NewCheck(path string) UploadCheck {
+	return &sizeCheck{max: int64(m)}
+}
+
+type sizeCheck struct {
+	max, size int64
+}
+
+func (c *sizeCheck) Check(p []byte) error {
+	c.size += int64(len(p))
+	if c.size > c.max {
+		return fmt.Errorf("max upload size of %d bytes: %w", c.max, fs.ErrLimitExceeded)
+	}
+	return nil
+}
+
+func (c *sizeCheck) Done() error {
+	return nil
+}
+
+// sniffLen is how much content types are detected from (see http.DetectContentType).
+const sniffLen = 512
+
+// AllowedTypes only accepts uploads whose MIME type, detected from their first 512 bytes with
+// http.DetectContentType, is one of types. Types can end with /* to allow a whole family (i.e.,
+// text/* or image/*). Parameters (i.e., charset) are ignored and empty uploads are accepted.
+func AllowedTypes(types ...string) UploadValidator {
+	allowed := make([]string, 0, len(types))
+	for _, t := range types {
+		allowed = append(allowed, strings.ToLower(strings.TrimSpace(t)))
+	}
+	return allowedTypes(allowed)
+}
+
+type allowedTypes []string
+
+func (a allowedTypes) Name() string {
+	return "mime"
+}
+
+func (a allowedTypes) NewCheck(path string) UploadCheck {
+	return &typeCheck{allowed: a}
+}
+
+// allows returns true if the media type t is allowed.
+func (a allowedTypes) allows(t string) bool {
+	for _, allowed := range a {
+		if allowed == t || (strings.HasSuffix(allowed, "/*") && strings.HasPrefix(t, allowed[:len(allowed)-1])) {
+			return true
+		}
+	}
+	return false
+}
+
+// typeCheck buffers the start of an upload until it can detect its type.
+type typeCheck struct {
+	allowed allowedTypes
+	head    []byte
+	checked bool
+}
+
+func (c *typeCheck) Check(p []byte) error {
+	if c.checked {
+		return nil
+	}
+	n := sniffLen - len(c.head)
+	if n > len(p) {
+		n = len(p)
+	}
+	c.head = append(c.head, p[:n]...)
+	if len(c.head) < sniffLen {
+		return nil
+	}
+	return c.detect()
+}
+
+func (c *typeCheck) Done() error {
+	if c.checked || len(c.head) == 0 {
+		return nil
+	}
+	return c.detect()
+}
+
+func (c *typeCheck) detect() error {
+	c.checked = true
+	detected := http.DetectContentType(c.head)
+	c.head = nil
+	t, _, err := mime.ParseMediaType(detected)
+	if err != nil {
+		t = detected
+	}
+	if !c.allowed.allows(t) {
+		return fmt.Errorf("content type %s isn't one of %s", t, strings.Join(c.allowed, ", "))
+	}
+	return nil
+}
+
+// validatingReader runs the checks of an upload on its content as it's read, failing the read that
+// brings rejected content.
+type validatingReader struct {
+	r      io.Reader
+	path   string
+	checks []namedCheck
+	offset int64
+}
+
+type namedCheck struct {
+	validator string
+	check     UploadCheck
+}
+
+// validate returns r with the checks of the validators that need to check the upload to path, or
+// r itself if none do.
+func (s *Server) validate(path string, r io.Reader) io.Reader {
+	checks := make([]namedCheck, 0, len(s.validators))
+	for _, v := range s.validators {
+		if check := v.NewCheck(path); check != nil {
+			checks = append(checks, namedCheck{validator: v.Name(), check: check})
+		}
+	}
+	if len(checks) == 0 {
+		return r
+	}
+	return &validatingReader{r: r, path: path, checks: checks}
+}
+
+func (v *validatingReader) Read(p []byte) (int, error) {
+	n, err := v.r.Read(p)
+	// Content is checked in chunks of up to chunkSize, like it's uploaded, so that rejections say
+	// where the rejected content starts regardless of how much is read at once.
+	for chunk := p[:n]; len(chunk) > 0; {
+		size := len(chunk)
+		if size > chunkSize {
+			size = chunkSize
+		}
+		for _, c := range v.checks {
+			if cerr := c.check.Check(chunk[:size]); cerr != nil {
+				return 0, v.reject(c.validator, cerr)
+			}
+		}
+		v.offset += int64(size)
+		chunk = chunk[size:]
+	}
+	if err == io.EOF {
+		for _, c := range v.checks {
+			if cerr := c.check.Done(); cerr != nil {
+				return 0, v.reject(c.validator, cerr)
+			}
+		}
+	}
+	return n, err
+}
+
+func (v *validatingReader) reject(validator string, err error) error {
+	return &fs.PathError{Op: "write", Path: v.path, Err: &fs.RejectedError{Validator: validator, Offset: v.offset, Err: err}}
+}
@@ -0,0 +1,207 @@
+package server
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"google.golang.org/grpc"
+)
+
+const (
+	contentTypeWeb     = "application/grpc-web"
+	contentTypeWebText = "application/grpc-web-text"
+
+	// trailerFrame flags the frame holding the trailers at the end of grpc-web responses.
+	trailerFrame = 0x80
+)
+
+// WebHandler serves the services of grpcServer over HTTP without a translating proxy: to browsers
+// over grpc-web (binary and base64 text, which Connect's grpc-web transport speaks too), and to
+// gRPC clients over cleartext HTTP/2 (h2c). Browsers can't stream requests, so client-streaming
+// RPCs (i.e., WriteFile) aren't available over grpc-web. Pages from origins other than the
+// server's own need to be listed in origins ("*" allows any).
+func WebHandler(grpcServer *grpc.Server, origins []string) http.Handler {
+	h := &webHandler{grpc: grpcServer, origins: make(map[string]bool, len(origins))}
+	for _, origin := range origins {
+		h.origins[strings.TrimSuffix(origin, "/")] = true
+	}
+	return h2c.NewHandler(h, &http2.Server{})
+}
+
+type webHandler struct {
+	grpc    *grpc.Server
+	origins map[string]bool
+}
+
+func (h *webHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if preflight := h.cors(w, r); preflight {
+		return
+	}
+	contentType := r.Header.Get("Content-Type")
+	switch {
+	case strings.HasPrefix(contentType, contentTypeWeb):
+		h.serveWeb(w, r, strings.HasPrefix(contentType, contentTypeWebText))
+	case r.ProtoMajor == 2 && strings.HasPrefix(contentType, "application/grpc"):
+		h.grpc.ServeHTTP(w, r)
+	default:
+		http.Error(w, "expected a gRPC or grpc-web request", http.StatusUnsupportedMediaType)
+	}
+}
+
+// cors sets the CORS headers of requests from allowed origins and answers preflight requests,
+// returning true for them.
+func (h *webHandler) cors(w http.ResponseWriter, r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return false
+	}
+	allowed := h.origins["*"] || h.origins[origin]
+	if allowed {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		w.Header().Add("Vary", "Origin")
+		w.Header().Set("Access-Control-Expose-Headers", "grpc-status, grpc-message, grpc-status-details-bin")
+	}
+	if r.Method != http.MethodOptions {
+		return false
+	}
+	if !allowed {
+		http.Error(w, "origin not allowed", http.StatusForbidden)
+		return true
+	}
+	w.Header().Set("Access-Control-Allow-Methods", http.MethodPost)
+	w.Header().Set("Access-Control-Allow-Headers", "content-type, x-grpc-web, x-user-agent, grpc-timeout")
+	w.Header().Set("Access-Control-Max-Age", "7200")
+	w.WriteHeader(http.StatusNoContent)
+	return true
+}
+
+// serveWeb serves a grpc-web request as a gRPC one. Messages are framed the same way, except that
+// the trailers are sent as a final frame since browsers can't read HTTP trailers, and both are
+// base64 encoded for text requests.
+func (h *webHandler) serveWeb(w http.ResponseWriter, r *http.Request, text bool) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "grpc-web requests must be POSTs", http.StatusMethodNotAllowed)
+		return
+	}
+	if strings.HasSuffix(r.Header.Get("Content-Type"), "+json") {
+		http.Error(w, "only proto messages are supported", http.StatusUnsupportedMediaType)
+		return
+	}
+	r = r.Clone(r.Context())
+	r.ProtoMajor, r.ProtoMinor, r.Proto = 2, 0, "HTTP/2.0"
+	r.Header.Set("Content-Type", "application/grpc+proto")
+	r.Header.Del("Content-Length")
+	if text {
+		r.Body = struct {
+			io.Reader
+			io.Closer
+		}{base64.NewDecoder(base64.StdEncoding, r.Body), r.Body}
+	}
+	contentType := contentTypeWeb + "+proto"
+	if text {
+		contentType = contentTypeWebText + "+proto"
+	}
+	ww := &webWriter{w: w, header: make(http.Header), text: text, contentType: contentType}
+	h.grpc.ServeHTTP(ww, r)
+	ww.writeTrailers()
+}
+
+// webWriter is the http.ResponseWriter of grpc-web requests. It hands headers through and
+// collects the trailers, which gRPC sets in the header after writing it.
+type webWriter struct {
+	w           http.ResponseWriter
+	header      http.Header
+	text        bool
+	contentType string
+	// trailers are the names of the trailers gRPC declared before writing the header.
+	trailers    []string
+	wroteHeader bool
+}
+
+func (ww *webWriter) Header() http.Header {
+	return ww.header
+}
+
+func (ww *webWriter) WriteHeader(code int) {
+	if ww.wroteHeader {
+		return
+	}
+	ww.wroteHeader = true
+	for name, values := range ww.header {
+		if name == "Trailer" {
+			for _, v := range values {
+				ww.trailers = append(ww.trailers, strings.Split(v, ",")...)
+			}
+			continue
+		}
+		ww.w.Header()[name] = values
+	}
+	ww.header = make(http.Header)
+	ww.w.Header().Set("Content-Type", ww.contentType)
+	ww.w.Header().Del("Content-Length")
+	ww.w.WriteHeader(code)
+}
+
+func (ww *webWriter) Write(p []byte) (int, error) {
+	ww.WriteHeader(http.StatusOK)
+	if ww.text {
+		// Chunks are encoded on their own, so that each can be decoded as it arrives.
+		if _, err := io.WriteString(ww.w, base64.StdEncoding.EncodeToString(p)); err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	}
+	return ww.w.Write(p)
+}
+
+// Flush is called by gRPC after each message, so that streams (i.e., ReadFile) reach browsers as
+// they're sent.
+func (ww *webWriter) Flush() {
+	ww.WriteHeader(http.StatusOK)
+	if f, ok := ww.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// writeTrailers sends what gRPC set as trailers in a trailer frame of lower-cased "name: value"
+// lines.
+func (ww *webWriter) writeTrailers() {
+	ww.WriteHeader(http.StatusOK)
+	trailers := make(http.Header)
+	for _, name := range ww.trailers {
+		name = http.CanonicalHeaderKey(strings.TrimSpace(name))
+		if values, ok := ww.header[name]; ok {
+			trailers[name] = values
+		}
+	}
+	for name, values := range ww.header {
+		if strings.HasPrefix(name, http.TrailerPrefix) {
+			trailers[strings.TrimPrefix(name, http.TrailerPrefix)] = values
+		}
+	}
+	names := make([]string, 0, len(trailers))
+	for name := range trailers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	for _, name := range names {
+		for _, v := range trailers[name] {
+			b.WriteString(strings.ToLower(name) + ": " + v + "\r\n")
+		}
+	}
+	frame := make([]byte, 5+b.Len())
+	frame[0] = trailerFrame
+	binary.BigEndian.PutUint32(frame[1:5], uint32(b.Len()))
+	copy(frame[5:], b.String())
+	if _, err := ww.Write(frame); err != nil {
+		return
+	}
+	ww.Flush()
+}
@@ -0,0 +1,128 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/basharal/filesystem/fs"
+	"github.com/golang/glog"
+)
+
+const (
+	// SignatureHeader carries the hex-encoded HMAC-SHA256 of the request body when a secret is
+	// configured for the webhook.
+	SignatureHeader = "X-Filesystem-Signature"
+
+	defaultWebhookRetries = 3
+	defaultWebhookBackoff = 500 * time.Millisecond
+	defaultWebhookTimeout = 5 * time.Second
+)
+
+// WebhookOpts configures a webhook that receives filesystem events as JSON POST requests.
+type WebhookOpts struct {
+	// URL to POST events to.
+	URL string
+
+	// Filter selects the events to deliver.
+	Filter EventFilter
+
+	// Secret is used to sign the body with HMAC-SHA256. Empty disables signing.
+	Secret string
+
+	// MaxRetries is the number of retries after the first failed attempt. Defaults to 3. Negative
+	// disables retries.
+	MaxRetries int
+
+	// Backoff is the initial delay between retries. It doubles after every retry. Defaults to
+	// 500ms.
+	Backoff time.Duration
+
+	// Timeout for a single attempt. Defaults to 5s.
+	Timeout time.Duration
+}
+
+type webhook struct {
+	opts   WebhookOpts
+	client *http.Client
+}
+
+func newWebhook(opts WebhookOpts) (*webhook, error) {
+	if !strings.HasPrefix(opts.URL, "http://") && !strings.HasPrefix(opts.URL, "https://") {
+		return nil, fmt.Errorf("webhook url must be http(s): %s", opts.URL)
+	}
+	if opts.MaxRetries == 0 {
+		opts.MaxRetries = defaultWebhookRetries
+	}
+	if opts.Backoff == 0 {
+		opts.Backoff = defaultWebhookBackoff
+	}
+	if opts.Timeout == 0 {
+		opts.Timeout = defaultWebhookTimeout
+	}
+	return &webhook{
+		opts:   opts,
+		client: &http.Client{Timeout: opts.Timeout},
+	}, nil
+}
+
+// Send POSTs the event, retrying with exponential backoff on failures.
+func (w *webhook) Send(ctx context.Context, e fs.Event) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	backoff := w.opts.Backoff
+	for attempt := 0; ; attempt++ {
+		err = w.post(ctx, body)
+		if err == nil || attempt >= w.opts.MaxRetries {
+			return err
+		}
+		glog.V(1).Infof("Webhook attempt %d to %s failed. Retrying in %v. %s\n", attempt+1, w.opts.URL, backoff, err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+func (w *webhook) Close() error {
+	w.client.CloseIdleConnections()
+	return nil
+}
+
+func (w *webhook) post(ctx context.Context, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.opts.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if w.opts.Secret != "" {
+		req.Header.Set(SignatureHeader, "sha256="+sign(w.opts.Secret, body))
+	}
+	res, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", res.Status)
+	}
+	return nil
+}
+
+func sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,47 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/basharal/filesystem/fs"
+)
+
+func TestWebhook_Retries(t *testing.T) {
+	var attempts int32
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+
+	tests := []struct {
+		name         string
+		maxRetries   int
+		wantAttempts int32
+	}{
+		{name: "default", wantAttempts: 4},
+		{name: "one", maxRetries: 1, wantAttempts: 2},
+		{name: "disabled", maxRetries: -1, wantAttempts: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			atomic.StoreInt32(&attempts, 0)
+			w, err := newWebhook(WebhookOpts{URL: failing.URL, MaxRetries: tt.maxRetries, Backoff: time.Millisecond})
+			if err != nil {
+				t.Fatalf("newWebhook() error = %v", err)
+			}
+			defer w.Close()
+			if err := w.Send(context.Background(), fs.Event{Type: fs.EventCreate, Path: "/a/file"}); err == nil {
+				t.Errorf("webhook.Send() succeeded with a failing endpoint")
+			}
+			if got := atomic.LoadInt32(&attempts); got != tt.wantAttempts {
+				t.Errorf("webhook.Send() made %d attempts, want %d", got, tt.wantAttempts)
+			}
+		})
+	}
+}
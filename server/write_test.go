@@ -0,0 +1,239 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/basharal/filesystem/fs"
+	"github.com/basharal/filesystem/proto/pb_filesystem"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// png is the signature PNG files start with.
+const png = "\x89PNG\r\n\x1a\n"
+
+func TestServer_WriteFile(t *testing.T) {
+	failed := errors.New("client went away")
+	tests := []struct {
+		name   string
+		opts   Opts
+		path   string
+		chunks []string
+		// streamErr fails the stream after the chunks.
+		streamErr error
+		wantCode  codes.Code
+		// want is the content of /ax afterwards, which has "old" before the upload.
+		want string
+		// wantBy and wantAt are the validator rejecting the upload and where.
+		wantBy string
+		wantAt int64
+	}{
+		{
+			name:     "appends",
+			path:     "/ax",
+			chunks:   []string{"new"},
+			wantCode: codes.OK,
+			want:     "oldnew",
+		},
+		{
+			name:     "out of range",
+			path:     "/z",
+			chunks:   []string{"new"},
+			wantCode: codes.InvalidArgument,
+			want:     "old",
+		},
+		{
+			name:     "dots out of range",
+			path:     "/ax/../../z",
+			chunks:   []string{"new"},
+			wantCode: codes.InvalidArgument,
+			want:     "old",
+		},
+		{
+			name:      "staged upload fails midway",
+			path:      "/ax",
+			chunks:    []string{"part", "ial"},
+			streamErr: failed,
+			wantCode:  codes.Unknown,
+			want:      "old",
+		},
+		{
+			name:     "within the max file size",
+			opts:     Opts{MaxFileSize: 8},
+			path:     "/ax",
+			chunks:   []string{"12", "345"},
+			wantCode: codes.OK,
+			want:     "old12345",
+		},
+		{
+			name:     "over the max file size",
+			opts:     Opts{MaxFileSize: 8},
+			path:     "/ax",
+			chunks:   []string{"12", "3456"},
+			wantCode: codes.ResourceExhausted,
+			want:     "old",
+		},
+		{
+			name:     "within the memory budget",
+			opts:     Opts{MemoryBudget: 10},
+			path:     "/ax",
+			chunks:   []string{"1234", "567"},
+			wantCode: codes.OK,
+			want:     "old1234567",
+		},
+		{
+			name:     "over the memory budget",
+			opts:     Opts{MemoryBudget: 10},
+			path:     "/ax",
+			chunks:   []string{"1234", "5678"},
+			wantCode: codes.Unavailable,
+			want:     "old",
+		},
+		{
+			name:     "within the upload size",
+			opts:     Opts{UploadValidators: []UploadValidator{MaxUploadSize(5)}},
+			path:     "/ax",
+			chunks:   []string{"12", "345"},
+			wantCode: codes.OK,
+			want:     "old12345",
+		},
+		{
+			name:     "over the upload size",
+			opts:     Opts{UploadValidators: []UploadValidator{MaxUploadSize(5)}},
+			path:     "/ax",
+			chunks:   []string{"12", "345", "6"},
+			wantCode: codes.ResourceExhausted,
+			want:     "old",
+			wantBy:   "max_size",
+			wantAt:   5,
+		},
+		{
+			name:     "allowed type",
+			opts:     Opts{UploadValidators: []UploadValidator{AllowedTypes("text/*")}},
+			path:     "/ax",
+			chunks:   []string{"plain ", "text"},
+			wantCode: codes.OK,
+			want:     "oldplain text",
+		},
+		{
+			name:     "disallowed type",
+			opts:     Opts{UploadValidators: []UploadValidator{AllowedTypes("text/*")}},
+			path:     "/ax",
+			chunks:   []string{png + strings.Repeat("\x00", sniffLen), "pixels"},
+			wantCode: codes.InvalidArgument,
+			want:     "old",
+			wantBy:   "mime",
+			wantAt:   0,
+		},
+		{
+			// Uploads shorter than what types are detected from are checked once they end.
+			name:     "short disallowed type",
+			opts:     Opts{UploadValidators: []UploadValidator{AllowedTypes("text/*")}},
+			path:     "/ax",
+			chunks:   []string{png, "pixels"},
+			wantCode: codes.InvalidArgument,
+			want:     "old",
+			wantBy:   "mime",
+			wantAt:   14,
+		},
+		{
+			name: "rejected by the second validator",
+			opts: Opts{UploadValidators: []UploadValidator{
+				AllowedTypes("text/*"), MaxUploadSize(int64(2*chunkSize + 1)),
+			}},
+			path:     "/ax",
+			chunks:   []string{strings.Repeat("a", chunkSize), strings.Repeat("b", chunkSize), "cc"},
+			wantCode: codes.ResourceExhausted,
+			want:     "old",
+			wantBy:   "max_size",
+			wantAt:   2 * chunkSize,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t, tt.opts)
+			if err := s.fs.NewFile("/ax"); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := s.fs.Write("/ax", strings.NewReader("old")); err != nil {
+				t.Fatal(err)
+			}
+			stream := newUpload(tt.path, nil, tt.chunks...)
+			stream.err = tt.streamErr
+			err := s.WriteFile(stream)
+			if status.Code(err) != tt.wantCode {
+				t.Errorf("Server.WriteFile(%s) = %v, want %v", tt.path, err, tt.wantCode)
+			}
+			if detail := pathErrorOf(err); tt.wantBy != "" &&
+				(detail == nil || detail.RejectedBy != tt.wantBy || detail.RejectedAt != tt.wantAt) {
+				t.Errorf("Server.WriteFile(%s) = %v, want a rejection by %s at %d", tt.path, detail, tt.wantBy, tt.wantAt)
+			}
+			var buf bytes.Buffer
+			if _, err := s.fs.Read("/ax", &buf); err != nil || buf.String() != tt.want {
+				t.Errorf("FileSystem.Read(/ax) = %q, %v, want %q", buf.String(), err, tt.want)
+			}
+		})
+	}
+}
+
+func TestServer_WriteFileRejectedWithParents(t *testing.T) {
+	tests := []struct {
+		name   string
+		opts   Opts
+		chunks []string
+		err    error
+	}{
+		{name: "over the max file size", opts: Opts{MaxFileSize: 4}, chunks: []string{"12", "345"}},
+		{name: "over the upload size", opts: Opts{UploadValidators: []UploadValidator{MaxUploadSize(4)}}, chunks: []string{"12", "345"}},
+		{name: "disallowed type", opts: Opts{UploadValidators: []UploadValidator{AllowedTypes("text/*")}}, chunks: []string{png, "pixels"}},
+		{name: "over the memory budget", opts: Opts{MemoryBudget: 4}, chunks: []string{"12", "345"}},
+		{name: "client went away", chunks: []string{"part"}, err: errors.New("client went away")},
+		{name: "streamed over the upload size", opts: Opts{StreamingWrites: true, UploadValidators: []UploadValidator{MaxUploadSize(4)}}, chunks: []string{"12", "345"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t, tt.opts)
+			stream := newUpload("/a/new/file", &pb_filesystem.FilePayload{Parents: true}, tt.chunks...)
+			stream.err = tt.err
+			if err := s.WriteFile(stream); err == nil {
+				t.Fatalf("Server.WriteFile(/a/new/file) succeeded")
+			}
+			if file, _, err := s.fs.Stat("/a/new/file"); !errors.Is(err, fs.ErrNotFound) {
+				t.Errorf("FileSystem.Stat(/a/new/file) = %v, %v, want ErrNotFound", file, err)
+			}
+		})
+	}
+}
+
+func TestServer_AdmissionStats(t *testing.T) {
+	s := newTestServer(t, Opts{})
+	if _, ok := s.AdmissionStats(); ok {
+		t.Errorf("Server.AdmissionStats() = _, true, want false without a budget")
+	}
+	s.SetMemoryBudget(4)
+	for _, path := range []string{"/ax", "/ay"} {
+		if err := s.fs.NewFile(path); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.WriteFile(newUpload("/ax", nil, "1234")); err != nil {
+		t.Fatalf("Server.WriteFile(/ax) = %v", err)
+	}
+	// The budget is used up, so the next write is turned away.
+	if err := s.WriteFile(newUpload("/ay", nil, "5")); status.Code(err) != codes.Unavailable {
+		t.Errorf("Server.WriteFile(/ay) = %v, want Unavailable", err)
+	}
+	// Nothing is in flight once the rejected upload failed.
+	want := AdmissionStats{Rejected: 1}
+	if got, ok := s.AdmissionStats(); !ok || got != want {
+		t.Errorf("Server.AdmissionStats() = %+v, %v, want %+v", got, ok, want)
+	}
+	// Raising the budget admits it.
+	s.SetMemoryBudget(5)
+	if err := s.WriteFile(newUpload("/ay", nil, "5")); err != nil {
+		t.Errorf("Server.WriteFile(/ay) = %v with a raised budget", err)
+	}
+}
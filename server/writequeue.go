@@ -0,0 +1,61 @@
+package server
+
+import (
+	"context"
+	"sync"
+)
+
+// writeQueue applies writes to the same path strictly in arrival order. Writes to different paths
+// don't wait on each other.
+type writeQueue struct {
+	mu    sync.Mutex
+	paths map[string][]chan struct{}
+}
+
+func newWriteQueue() *writeQueue {
+	return &writeQueue{paths: make(map[string][]chan struct{})}
+}
+
+// acquire waits for the turn of the write to path. release must be called once the write is done.
+func (q *writeQueue) acquire(ctx context.Context, path string) (release func(), err error) {
+	turn := make(chan struct{})
+	q.mu.Lock()
+	q.paths[path] = append(q.paths[path], turn)
+	if len(q.paths[path]) == 1 {
+		close(turn)
+	}
+	q.mu.Unlock()
+
+	release = func() { q.leave(path, turn) }
+	select {
+	case <-turn:
+		return release, nil
+	case <-ctx.Done():
+		// Give up our place (or our turn if we got it in the meantime).
+		release()
+		return nil, ctx.Err()
+	}
+}
+
+// leave removes turn from the queue of path and hands the turn over to the next write if turn had
+// it.
+func (q *writeQueue) leave(path string, turn chan struct{}) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	queue := q.paths[path]
+	for i, t := range queue {
+		if t != turn {
+			continue
+		}
+		queue = append(queue[:i], queue[i+1:]...)
+		if len(queue) == 0 {
+			delete(q.paths, path)
+			return
+		}
+		if i == 0 {
+			close(queue[0])
+		}
+		q.paths[path] = queue
+		return
+	}
+}
@@ -0,0 +1,144 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// queued returns the number of writes to path holding or waiting for their turn.
+func (q *writeQueue) queued(path string) int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.paths[path])
+}
+
+// waiter acquires the turn of a write to path in the background, sending its result to acquired.
+type waiter struct {
+	release  func()
+	acquired chan error
+}
+
+func wait(ctx context.Context, q *writeQueue, path string) *waiter {
+	w := &waiter{acquired: make(chan error, 1)}
+	go func() {
+		release, err := q.acquire(ctx, path)
+		w.release = release
+		w.acquired <- err
+	}()
+	return w
+}
+
+// result returns the error the waiter's acquire returned, failing the test if it's still waiting.
+func (w *waiter) result(t *testing.T) error {
+	t.Helper()
+	select {
+	case err := <-w.acquired:
+		return err
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a turn")
+		return nil
+	}
+}
+
+// waiting fails the test if the waiter got its turn or gave up.
+func (w *waiter) waiting(t *testing.T) {
+	t.Helper()
+	select {
+	case err := <-w.acquired:
+		t.Fatalf("writeQueue.acquire() = %v while an earlier write holds the turn", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+}
+
+func TestWriteQueue_FIFO(t *testing.T) {
+	q := newWriteQueue()
+	ctx := context.Background()
+	release, err := q.acquire(ctx, "/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Writes to other paths don't wait.
+	other, err := q.acquire(ctx, "/b")
+	if err != nil {
+		t.Fatalf("writeQueue.acquire(/b) = %v", err)
+	}
+	other()
+
+	var waiters []*waiter
+	for i := 0; i < 5; i++ {
+		waiters = append(waiters, wait(ctx, q, "/a"))
+		waitFor(t, fmt.Sprintf("write %d to queue", i), func() bool { return q.queued("/a") == i+2 })
+	}
+	// Each write gets its turn once the one before it is done, and not before.
+	release()
+	for i, w := range waiters {
+		if err := w.result(t); err != nil {
+			t.Fatalf("writeQueue.acquire() of write %d = %v", i, err)
+		}
+		for _, next := range waiters[i+1:] {
+			next.waiting(t)
+		}
+		w.release()
+	}
+	if n := q.queued("/a"); n != 0 {
+		t.Errorf("writeQueue has %d writes queued once all are done", n)
+	}
+}
+
+func TestWriteQueue_Cancel(t *testing.T) {
+	q := newWriteQueue()
+	release, err := q.acquire(context.Background(), "/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	canceled := wait(ctx, q, "/a")
+	waitFor(t, "the write to queue", func() bool { return q.queued("/a") == 2 })
+	next := wait(context.Background(), q, "/a")
+	waitFor(t, "the next write to queue", func() bool { return q.queued("/a") == 3 })
+
+	// Canceling a waiting write gives up its place without handing out a turn.
+	cancel()
+	if err := canceled.result(t); !errors.Is(err, context.Canceled) {
+		t.Errorf("writeQueue.acquire() = %v, want %v", err, context.Canceled)
+	}
+	next.waiting(t)
+	release()
+	if err := next.result(t); err != nil {
+		t.Fatalf("writeQueue.acquire() = %v", err)
+	}
+	next.release()
+}
+
+func TestWriteQueue_CancelAtHead(t *testing.T) {
+	// A write canceled as it gets the turn hands it over to the next one, whichever of the two it
+	// noticed first.
+	for i := 0; i < 20; i++ {
+		q := newWriteQueue()
+		release, err := q.acquire(context.Background(), "/a")
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		head := wait(ctx, q, "/a")
+		waitFor(t, "the write to queue", func() bool { return q.queued("/a") == 2 })
+		next := wait(context.Background(), q, "/a")
+		waitFor(t, "the next write to queue", func() bool { return q.queued("/a") == 3 })
+
+		go cancel()
+		release()
+		if err := head.result(t); err == nil {
+			head.release()
+		}
+		if err := next.result(t); err != nil {
+			t.Fatalf("writeQueue.acquire() = %v", err)
+		}
+		next.release()
+		if n := q.queued("/a"); n != 0 {
+			t.Fatalf("writeQueue has %d writes queued once all are done", n)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+// Package session persists the state of interactive CLI sessions (command history and the last
+// working directory) across runs.
+package session
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// DefaultMaxHistory is the number of commands kept by default.
+const DefaultMaxHistory = 1000
+
+// state is what's persisted.
+type state struct {
+	History []string `json:"history"`
+	Dir     string   `json:"dir,omitempty"`
+}
+
+// Session is the thread-safe state of a CLI session backed by a file.
+type Session struct {
+	path       string
+	maxHistory int
+
+	mu    sync.Mutex
+	state state
+}
+
+// DefaultPath returns the per-user state file of the CLI with the given name, or nothing if the
+// user's config dir is unknown.
+func DefaultPath(name string) string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "filesystem", name+".json")
+}
+
+// Load returns the session saved at path. A missing file is a new session. An empty path keeps
+// the session in memory only. maxHistory defaults to DefaultMaxHistory.
+func Load(path string, maxHistory int) (*Session, error) {
+	if maxHistory <= 0 {
+		maxHistory = DefaultMaxHistory
+	}
+	s := &Session{path: path, maxHistory: maxHistory}
+	if path == "" {
+		return s, nil
+	}
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &s.state); err != nil {
+		return nil, err
+	}
+	s.trim()
+	return s, nil
+}
+
+// Add appends line to the history.
+func (s *Session) Add(line string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.state.History = append(s.state.History, line)
+	s.trim()
+}
+
+// History returns the commands from oldest to newest.
+func (s *Session) History() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.state.History...)
+}
+
+// Dir returns the last working directory, if any.
+func (s *Session) Dir() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.state.Dir
+}
+
+// SetDir records the working directory.
+func (s *Session) SetDir(dir string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.state.Dir = dir
+}
+
+// Save writes the session to its file, creating its dir if needed.
+func (s *Session) Save() error {
+	if s.path == "" {
+		return nil
+	}
+	s.mu.Lock()
+	b, err := json.Marshal(s.state)
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0700); err != nil {
+		return err
+	}
+	// Write to a temp file first so that a crash doesn't leave a truncated state behind.
+	tmp := s.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+// trim drops the oldest commands beyond maxHistory. Must be called with mu held.
+func (s *Session) trim() {
+	if n := len(s.state.History) - s.maxHistory; n > 0 {
+		s.state.History = append([]string(nil), s.state.History[n:]...)
+	}
+}
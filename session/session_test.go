@@ -0,0 +1,52 @@
+package session
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestSession(t *testing.T) {
+	dir, err := ioutil.TempDir("", "session")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "state", "filesystem.json")
+
+	s, err := Load(path, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"ls", "cd /foo", "ls /bar"} {
+		s.Add(line)
+	}
+	s.SetDir("/foo")
+	if err := s.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err = Load(path, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"cd /foo", "ls /bar"}
+	if got := s.History(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+	if s.Dir() != "/foo" {
+		t.Errorf("Expected /foo, got %s", s.Dir())
+	}
+
+	// Sessions without a path are only kept in memory.
+	s, err = Load("", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Add("ls")
+	if err := s.Save(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,315 @@
+// Package trie is the namespace of a filesystem: a tree of path components, keyed by paths like
+// /a/b/f (dirs end with a separator, i.e., /a/b/). It's laid out for namespaces with millions of
+// entries: nodes hold their component rather than their path, components are interned so that
+// names repeated across dirs are stored once, and small dirs keep their children in a sorted slice
+// rather than a map.
+package trie
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	separator = '/'
+
+	// maxSmall is how many children a node keeps in a sorted slice before moving them to a map.
+	maxSmall = 32
+)
+
+// Node is a path component. Nodes with meta are the entries of the trie; the others only lead to
+// them. A node's name and parent never change, so its path can be read without locking.
+type Node struct {
+	// name has a trailing separator for dirs.
+	name   string
+	parent *Node
+	meta   interface{}
+
+	// Children are in small, sorted by name, until there are more than maxSmall of them, and in
+	// large after.
+	small []*Node
+	large map[string]*Node
+}
+
+// Meta returns what the entry was added with.
+func (n *Node) Meta() interface{} {
+	return n.meta
+}
+
+// Path returns the key of the node, i.e., /a/b/ for dirs.
+func (n *Node) Path() string {
+	size := 0
+	for p := n; p != nil; p = p.parent {
+		size += len(p.name)
+	}
+	b := make([]byte, size)
+	for p := n; p != nil; p = p.parent {
+		size -= len(p.name)
+		copy(b[size:], p.name)
+	}
+	return string(b)
+}
+
+// Name returns the last component of the node's path, without the trailing separator of dirs.
+// It's empty for the root dir.
+func (n *Node) Name() string {
+	if n.parent == nil || n.parent.parent == nil {
+		return ""
+	}
+	return strings.TrimSuffix(n.name, string(separator))
+}
+
+func (n *Node) child(name string) *Node {
+	if n.large != nil {
+		return n.large[name]
+	}
+	i := sort.Search(len(n.small), func(i int) bool { return n.small[i].name >= name })
+	if i < len(n.small) && n.small[i].name == name {
+		return n.small[i]
+	}
+	return nil
+}
+
+func (n *Node) addChild(c *Node) {
+	if n.large != nil {
+		n.large[c.name] = c
+		return
+	}
+	if len(n.small) == maxSmall {
+		n.large = make(map[string]*Node, 2*maxSmall)
+		for _, s := range n.small {
+			n.large[s.name] = s
+		}
+		n.small = nil
+		n.large[c.name] = c
+		return
+	}
+	i := sort.Search(len(n.small), func(i int) bool { return n.small[i].name >= c.name })
+	n.small = append(n.small, nil)
+	copy(n.small[i+1:], n.small[i:])
+	n.small[i] = c
+}
+
+func (n *Node) removeChild(name string) {
+	if n.large != nil {
+		delete(n.large, name)
+		return
+	}
+	i := sort.Search(len(n.small), func(i int) bool { return n.small[i].name >= name })
+	if i < len(n.small) && n.small[i].name == name {
+		copy(n.small[i:], n.small[i+1:])
+		n.small[len(n.small)-1] = nil
+		n.small = n.small[:len(n.small)-1]
+	}
+}
+
+func (n *Node) empty() bool {
+	return len(n.small) == 0 && len(n.large) == 0
+}
+
+// each calls fn with the children of n until it returns false. It returns false if fn did.
+func (n *Node) each(fn func(*Node) bool) bool {
+	for _, c := range n.small {
+		if !fn(c) {
+			return false
+		}
+	}
+	for _, c := range n.large {
+		if !fn(c) {
+			return false
+		}
+	}
+	return true
+}
+
+// Trie is thread-safe. Nodes of removed keys can still be read but are no longer in the trie.
+type Trie struct {
+	mu    sync.RWMutex
+	root  *Node
+	names names
+}
+
+// New returns an empty trie.
+func New() *Trie {
+	return &Trie{root: &Node{}, names: names{refs: make(map[string]*name)}}
+}
+
+// Root returns the node all keys are under. It isn't an entry itself and its path is empty.
+func (t *Trie) Root() *Node {
+	return t.root
+}
+
+// Add adds key with meta, replacing the meta of key if it's there already, and returns its node.
+func (t *Trie) Add(key string, meta interface{}) *Node {
+	return t.AddAtNode(key, t.root, meta)
+}
+
+// AddAtNode is like Add for key relative to the path of n.
+func (t *Trie) AddAtNode(key string, n *Node, meta interface{}) *Node {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, name := range split(key) {
+		c := n.child(name)
+		if c == nil {
+			c = &Node{name: t.names.intern(name), parent: n}
+			n.addChild(c)
+		}
+		n = c
+	}
+	n.meta = meta
+	return n
+}
+
+// FindAtNode returns the node of key relative to the path of n, if it's an entry.
+func (t *Trie) FindAtNode(key string, n *Node) (*Node, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	n = find(n, key)
+	if n == nil || n.meta == nil {
+		return nil, false
+	}
+	return n, true
+}
+
+// Find is like FindAtNode from the root.
+func (t *Trie) Find(key string) (*Node, bool) {
+	return t.FindAtNode(key, t.root)
+}
+
+// Remove removes key along with the keys under it.
+func (t *Trie) Remove(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	n := find(t.root, key)
+	if n == nil || n == t.root {
+		return
+	}
+	t.release(n)
+	n.parent.removeChild(n.name)
+	// Drop the nodes that only led to n.
+	for p := n.parent; p != t.root && p.meta == nil && p.empty(); p = p.parent {
+		p.parent.removeChild(p.name)
+		t.names.release(p.name)
+	}
+}
+
+// release releases the names of n and the nodes under it.
+func (t *Trie) release(n *Node) {
+	n.each(func(c *Node) bool {
+		t.release(c)
+		return true
+	})
+	t.names.release(n.name)
+}
+
+// ListAtNode returns the names and nodes of the entries right under n.
+func (t *Trie) ListAtNode(n *Node) ([]string, []*Node, error) {
+	keys := make([]string, 0)
+	nodes := make([]*Node, 0)
+	t.WalkAtNode(n, false, func(c *Node) bool {
+		keys = append(keys, c.Name())
+		nodes = append(nodes, c)
+		return true
+	})
+	return keys, nodes, nil
+}
+
+// FirstRegexMatchAtNode returns the path and node of an entry under n whose name matches regex.
+// It returns an empty path if none does.
+func (t *Trie) FirstRegexMatchAtNode(regex string, n *Node) (string, *Node, error) {
+	re, err := regexp.Compile(regex)
+	if err != nil {
+		return "", nil, err
+	}
+	var found *Node
+	t.WalkAtNode(n, true, func(c *Node) bool {
+		if re.MatchString(c.Name()) {
+			found = c
+			return false
+		}
+		return true
+	})
+	if found == nil {
+		return "", nil, nil
+	}
+	return found.Path(), found, nil
+}
+
+// WalkAtNode calls fn with the entries under n, and under its subdirs if nested, until fn returns
+// false. There are none under files. Unlike ListAtNode, entries aren't collected first, so large
+// dirs can be streamed. fn is called with the trie read-locked, so it can't change the trie.
+func (t *Trie) WalkAtNode(n *Node, nested bool, fn func(*Node) bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	walkNode(n, nested, fn)
+}
+
+func walkNode(n *Node, nested bool, fn func(*Node) bool) bool {
+	return n.each(func(c *Node) bool {
+		if c.meta != nil && !fn(c) {
+			return false
+		}
+		if nested || c.meta == nil {
+			return walkNode(c, nested, fn)
+		}
+		return true
+	})
+}
+
+// find returns the node of key relative to n, if there's one.
+func find(n *Node, key string) *Node {
+	for _, name := range split(key) {
+		if n = n.child(name); n == nil {
+			return nil
+		}
+	}
+	return n
+}
+
+// split returns the components of key, each with its trailing separator (i.e., /, a/ and b for
+// /a/b).
+func split(key string) []string {
+	names := make([]string, 0, strings.Count(key, string(separator))+1)
+	for key != "" {
+		i := strings.IndexByte(key, separator) + 1
+		if i == 0 {
+			i = len(key)
+		}
+		names = append(names, key[:i])
+		key = key[i:]
+	}
+	return names
+}
+
+// names interns the names of nodes. Names are dropped once no node has them.
+type names struct {
+	refs map[string]*name
+}
+
+type name struct {
+	s    string
+	refs int
+}
+
+// intern returns the interned copy of s, so that nodes don't hold on to the keys s is a part of.
+func (ns *names) intern(s string) string {
+	if n, ok := ns.refs[s]; ok {
+		n.refs++
+		return n.s
+	}
+	n := &name{s: string([]byte(s)), refs: 1}
+	ns.refs[n.s] = n
+	return n.s
+}
+
+func (ns *names) release(s string) {
+	n, ok := ns.refs[s]
+	if !ok {
+		return
+	}
+	if n.refs--; n.refs == 0 {
+		delete(ns.refs, s)
+	}
+}
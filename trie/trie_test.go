@@ -0,0 +1,143 @@
+package trie
+
+import (
+	"reflect"
+	"sort"
+	"strconv"
+	"testing"
+)
+
+func newTestTrie(keys ...string) *Trie {
+	t := New()
+	for _, key := range keys {
+		t.Add(key, key)
+	}
+	return t
+}
+
+func TestTrie_Find(t *testing.T) {
+	tr := newTestTrie("/", "/a/", "/a/b", "/a/c/", "/ab")
+	tests := []struct {
+		key      string
+		wantPath string
+		wantName string
+		wantOk   bool
+	}{
+		{"/", "/", "", true},
+		{"/a/", "/a/", "a", true},
+		{"/a/b", "/a/b", "b", true},
+		{"/a/c/", "/a/c/", "c", true},
+		{"/ab", "/ab", "ab", true},
+		{"/a", "", "", false},
+		{"/a/c", "", "", false},
+		{"/a/b/", "", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			n, ok := tr.Find(tt.key)
+			if ok != tt.wantOk {
+				t.Fatalf("Find(%q) = %v, want %v", tt.key, ok, tt.wantOk)
+			}
+			if !ok {
+				return
+			}
+			if n.Path() != tt.wantPath || n.Name() != tt.wantName || n.Meta() != tt.key {
+				t.Errorf("Find(%q) = %q, %q, %v, want %q, %q", tt.key, n.Path(), n.Name(), n.Meta(), tt.wantPath, tt.wantName)
+			}
+		})
+	}
+
+	// Keys are relative to the node they're found at.
+	a, _ := tr.Find("/a/")
+	if n, ok := tr.FindAtNode("c/", a); !ok || n.Path() != "/a/c/" {
+		t.Errorf("FindAtNode(c/) = %v, %v, want /a/c/", n, ok)
+	}
+	if n := tr.AddAtNode("d", a, "d"); n.Path() != "/a/d" {
+		t.Errorf("AddAtNode(d) = %q, want /a/d", n.Path())
+	}
+}
+
+func TestTrie_Remove(t *testing.T) {
+	tr := newTestTrie("/", "/a/", "/a/b", "/a/c/", "/a/c/d", "/x/y/z")
+	tr.Remove("/a/c/")
+	for _, key := range []string{"/a/c/", "/a/c/d"} {
+		if _, ok := tr.Find(key); ok {
+			t.Errorf("Find(%q) found a removed key", key)
+		}
+	}
+	if _, ok := tr.Find("/a/b"); !ok {
+		t.Errorf("Find(/a/b) didn't find a key that wasn't removed")
+	}
+
+	// The nodes that only led to removed keys are dropped, along with their names.
+	tr.Remove("/x/y/z")
+	root, _ := tr.Find("/")
+	if _, nodes, _ := tr.ListAtNode(root); len(nodes) != 1 {
+		t.Errorf("ListAtNode(/) = %d entries, want 1", len(nodes))
+	}
+	for _, name := range []string{"c/", "d", "x/", "y/", "z"} {
+		if _, ok := tr.names.refs[name]; ok {
+			t.Errorf("name %q is still interned", name)
+		}
+	}
+}
+
+func TestTrie_List(t *testing.T) {
+	keys := []string{"/", "/a/", "/a/b/", "/a/b/f", "/c"}
+	for i := 0; i < 2*maxSmall; i++ {
+		keys = append(keys, "/a/f"+strconv.Itoa(i))
+	}
+	tr := newTestTrie(keys...)
+	a, _ := tr.Find("/a/")
+	names, _, err := tr.ListAtNode(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 2*maxSmall+1 {
+		t.Errorf("ListAtNode(/a/) = %d entries, want %d", len(names), 2*maxSmall+1)
+	}
+
+	root, _ := tr.Find("/")
+	var paths []string
+	tr.WalkAtNode(root, true, func(n *Node) bool {
+		if len(paths) < 3 {
+			paths = append(paths, n.Path())
+		}
+		return len(paths) < 3
+	})
+	if len(paths) != 3 {
+		t.Errorf("WalkAtNode() = %v, want 3 entries before stopping", paths)
+	}
+	paths = nil
+	tr.WalkAtNode(a, false, func(n *Node) bool {
+		if n.Name() == "b" || n.Name() == "f" {
+			paths = append(paths, n.Path())
+		}
+		return true
+	})
+	sort.Strings(paths)
+	if want := []string{"/a/b/"}; !reflect.DeepEqual(paths, want) {
+		t.Errorf("WalkAtNode(/a/) = %v, want %v", paths, want)
+	}
+	if path, _, err := tr.FirstRegexMatchAtNode("^b$", root); err != nil || path != "/a/b/" {
+		t.Errorf("FirstRegexMatchAtNode(^b$) = %q, %v, want /a/b/", path, err)
+	}
+	if path, n, err := tr.FirstRegexMatchAtNode("^nope$", root); err != nil || path != "" || n != nil {
+		t.Errorf("FirstRegexMatchAtNode(^nope$) = %q, %v, %v, want no match", path, n, err)
+	}
+}
+
+func TestTrie_Intern(t *testing.T) {
+	tr := newTestTrie("/", "/a/", "/b/", "/a/name", "/b/name")
+	if n := tr.names.refs["name"]; n == nil || n.refs != 2 {
+		t.Fatalf("name is interned %v, want twice", n)
+	}
+	tr.Remove("/a/")
+	if n := tr.names.refs["name"]; n == nil || n.refs != 1 {
+		t.Fatalf("name is interned %v after a removal, want once", n)
+	}
+	tr.Remove("/b/name")
+	if n, ok := tr.names.refs["name"]; ok {
+		t.Errorf("name is interned %v after all removals, want none", n)
+	}
+}